@@ -0,0 +1,260 @@
+//******************************************************************
+//
+// Convert a CSV/TSV table to N4L, or upload it directly, following
+// a mapping from columns to nodes, arrows and context
+//
+// e.g. csv2N4L -m users.map users.csv > users.n4l
+//      csv2N4L -m users.map -u users.csv
+//
+//******************************************************************
+
+package main
+
+import (
+	"fmt"
+	"flag"
+	"os"
+	"io"
+	"bufio"
+	"strings"
+	"path/filepath"
+	"encoding/csv"
+
+	SST "github.com/markburgess/SSTorytime/pkg/SSTorytime"
+)
+
+var MAPPING string
+var DELIMITER string
+var OUTPUT string
+var CHAPTER string
+var UPLOAD bool
+var BATCH int
+
+//******************************************************************
+
+func main() {
+
+	args := Init()
+
+	mapping,ok := SST.ReadTableMapping(MAPPING)
+
+	if !ok {
+		os.Exit(-1)
+	}
+
+	load_arrows := true
+	sst := SST.Open(load_arrows)
+
+	var out *bufio.Writer
+
+	if !UPLOAD {
+		out = OpenOutput()
+	}
+
+	for _,filename := range args {
+
+		chapter := CHAPTER
+
+		if chapter == "" {
+			chapter = mapping.Chapter
+		}
+
+		if chapter == "" {
+			chapter = strings.TrimSuffix(filepath.Base(filename),filepath.Ext(filename))
+		}
+
+		if UPLOAD {
+			UploadTable(&sst,mapping,filename,chapter)
+		} else {
+			ConvertTable(&sst,mapping,filename,chapter,out)
+		}
+	}
+
+	if out != nil {
+		out.Flush()
+	}
+
+	SST.Close(sst)
+}
+
+//**************************************************************
+
+func ConvertTable(sst *SST.PoSST,mapping SST.TableMapping,filename,chapter string,out *bufio.Writer) {
+
+	reader,file,columns := OpenTable(sst,&mapping,filename)
+	defer file.Close()
+
+	fmt.Fprintf(out,"- %s\n\n# converted from %s by csv2N4L\n",chapter,filepath.Base(filename))
+
+	context := ""
+
+	for {
+		row,err := reader.Read()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr,"Error reading",filename,err)
+			continue
+		}
+
+		links := SST.TableRowLinks(mapping,columns,row)
+		out.WriteString(SST.TableLinksToN4L(sst,links,&context))
+	}
+
+	out.WriteString("\n")
+}
+
+//**************************************************************
+
+func UploadTable(sst *SST.PoSST,mapping SST.TableMapping,filename,chapter string) {
+
+	reader,file,columns := OpenTable(sst,&mapping,filename)
+	defer file.Close()
+
+	up := SST.NewTableUpload(sst,chapter)
+	rows := 0
+
+	for {
+		row,err := reader.Read()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			fmt.Println("Error reading",filename,err)
+			continue
+		}
+
+		links := SST.TableRowLinks(mapping,columns,row)
+		SST.UploadTableLinks(sst,&up,links)
+
+		rows++
+
+		if rows % BATCH == 0 {
+			SST.FlushTableUpload(sst,&up)
+			fmt.Println("Uploaded",rows,"rows ...")
+		}
+	}
+
+	SST.FlushTableUpload(sst,&up)
+	sst.STORE.Finalize(SST.DBContext(sst),sst)
+
+	fmt.Println("Uploaded",rows,"rows from",filename,"as",up.NewNodes,"new nodes and",up.Links,"links in chapter",chapter)
+}
+
+//**************************************************************
+
+func OpenTable(sst *SST.PoSST,mapping *SST.TableMapping,filename string) (*csv.Reader,*os.File,map[string]int) {
+
+	file,err := os.Open(filename)
+
+	if err != nil {
+		fmt.Println("Unable to open",filename,err)
+		os.Exit(-1)
+	}
+
+	reader := csv.NewReader(bufio.NewReader(file))
+
+	reader.Comma = Delimiter(filename)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.ReuseRecord = true
+
+	header,err := reader.Read()
+
+	if err != nil {
+		fmt.Println("Unable to read the header of",filename,err)
+		os.Exit(-1)
+	}
+
+	columns,ok := SST.ValidateTableMapping(sst,mapping,header)
+
+	if !ok {
+		os.Exit(-1)
+	}
+
+	return reader,file,columns
+}
+
+//**************************************************************
+
+func Delimiter(filename string) rune {
+
+	switch DELIMITER {
+	case "":
+		if strings.ToLower(filepath.Ext(filename)) == ".tsv" {
+			return '\t'
+		}
+		return ','
+	case "tab","\\t":
+		return '\t'
+	}
+
+	return []rune(DELIMITER)[0]
+}
+
+//**************************************************************
+
+func OpenOutput() *bufio.Writer {
+
+	if OUTPUT == "" {
+		return bufio.NewWriter(os.Stdout)
+	}
+
+	file,err := os.Create(OUTPUT)
+
+	if err != nil {
+		fmt.Println("Unable to write",OUTPUT,err)
+		os.Exit(-1)
+	}
+
+	return bufio.NewWriter(file)
+}
+
+//**************************************************************
+
+func Usage() {
+
+	fmt.Printf("usage: csv2N4L -m mapping [-d delimiter] [-o file.n4l | -u] table.csv ...\n\n")
+	fmt.Println("csv2N4L -m users.map users.csv > users.n4l")
+	fmt.Println("csv2N4L -m users.map -u -batch 50000 users.tsv")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+//**************************************************************
+
+func Init() []string {
+
+	flag.Usage = Usage
+
+	mappingPtr := flag.String("m","","mapping file naming the node, link and context columns")
+	delimiterPtr := flag.String("d","","column delimiter (default tab for .tsv, otherwise comma)")
+	outputPtr := flag.String("o","","write N4L to this file instead of stdout")
+	chapterPtr := flag.String("chapter","","chapter name (default from the mapping, or the file name)")
+	uploadPtr := flag.Bool("u",false,"upload directly instead of writing N4L")
+	batchPtr := flag.Int("batch",100000,"rows per upload batch (fewer rows use less memory, but are slower)")
+
+	flag.Parse()
+
+	MAPPING = *mappingPtr
+	DELIMITER = *delimiterPtr
+	OUTPUT = *outputPtr
+	CHAPTER = *chapterPtr
+	UPLOAD = *uploadPtr
+	BATCH = *batchPtr
+
+	if MAPPING == "" || len(flag.Args()) < 1 || BATCH < 1 {
+		Usage()
+	}
+
+	return flag.Args()
+}
+
+//
+// csv2N4L.go
+//
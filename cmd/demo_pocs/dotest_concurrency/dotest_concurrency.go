@@ -0,0 +1,334 @@
+//******************************************************************
+//
+// Share one session between many goroutines, adding, editing and
+// searching at once. Built with the race detector, which exits
+// with an error if any access is unguarded. Needs no database.
+//
+//******************************************************************
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	SST "github.com/markburgess/SSTorytime/pkg/SSTorytime"
+)
+
+const workers = 8
+const rounds = 50
+const phases = 20
+
+//******************************************************************
+
+func main() {
+
+	sst,err := SST.OpenStoreErr(context.Background(),SST.NewMemoryStore(),true)
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	// Some arrows are declared before the session is shared, and
+	// each worker declares its own while the others are searching
+
+	fwd := SST.InsertArrowDirectory(&sst,"leadsto","then","leads to next","+")
+	bwd := SST.InsertArrowDirectory(&sst,"leadsto","prev","comes from","-")
+	SST.InsertInverseArrowDirectory(&sst,fwd,bwd)
+	sst.STORE.UploadArrows(SST.DBContext(&sst),&sst)
+
+	var wg sync.WaitGroup
+
+	for w := 0; w < workers; w++ {
+		wg.Add(3)
+		go Ingest(&sst,w,&wg)
+		go Search(&sst,w,&wg)
+		go Declare(&sst,w,&wg)
+	}
+
+	wg.Wait()
+
+	// Then searches and declarations that start together, again and
+	// again, so that an unguarded copy of the session is caught on
+	// every run, not only when the timing happens to be right
+
+	for phase := 0; phase < phases; phase++ {
+
+		start := make(chan bool)
+
+		for w := 0; w < workers; w++ {
+			wg.Add(2)
+			go SearchPhase(&sst,w,start,&wg)
+			go DeclarePhase(&sst,phase,w,start,&wg)
+		}
+
+		close(start)
+		wg.Wait()
+	}
+
+	// Then edits of one node at once, each removing its own link from
+	// a hub, so that an edit made from a stale copy of it is noticed
+
+	hub := SST.Vertex(&sst,"hub","edits")
+
+	for s := 0; s < workers*rounds; s++ {
+		SST.Edge(&sst,hub,"then",SST.Vertex(&sst,fmt.Sprintf("spoke %d",s),"edits"),[]string{"edits"},1.0)
+	}
+
+	for s := 0; s < workers*rounds; s++ {
+		wg.Add(1)
+		go Unlink(&sst,hub.NPtr,s,&wg)
+	}
+
+	wg.Wait()
+
+	if !CheckHub(&sst,hub.NPtr) {
+		os.Exit(-1)
+	}
+
+	if !Check(&sst) {
+		os.Exit(-1)
+	}
+
+	SST.Close(sst)
+}
+
+//******************************************************************
+
+func Ingest(sst *SST.PoSST,w int,wg *sync.WaitGroup) {
+
+	// Each worker writes its own chain of events, with a context
+	// shared by everyone and one of its own
+
+	defer wg.Done()
+
+	chap := fmt.Sprintf("worker %d",w)
+	prev := SST.Vertex(sst,fmt.Sprintf("w%d event 0",w),chap)
+
+	for r := 1; r < rounds; r++ {
+
+		next := SST.Vertex(sst,fmt.Sprintf("w%d event %d",w,r),chap)
+		context := []string{"concurrency",fmt.Sprintf("round %d",r%5),chap}
+
+		SST.Edge(sst,prev,"then",next,context,1.0)
+
+		if r % 10 == 0 {
+			if err := SST.RenameNode(sst,prev.NPtr,fmt.Sprintf("w%d renamed %d",w,r-1)); err != nil {
+				fmt.Println("Rename failed",err)
+			}
+		}
+
+		prev = next
+	}
+}
+
+//******************************************************************
+
+func Declare(sst *SST.PoSST,w int,wg *sync.WaitGroup) {
+
+	// A pair of arrows of its own, used at once to link two nodes
+
+	defer wg.Done()
+
+	chap := fmt.Sprintf("worker %d",w)
+	fwd := SST.InsertArrowDirectory(sst,"leadsto",fmt.Sprintf("w%d next",w),fmt.Sprintf("w%d goes to",w),"+")
+	bwd := SST.InsertArrowDirectory(sst,"leadsto",fmt.Sprintf("w%d last",w),fmt.Sprintf("w%d comes from",w),"-")
+	SST.InsertInverseArrowDirectory(sst,fwd,bwd)
+	sst.STORE.UploadArrows(SST.DBContext(sst),sst)
+
+	from := SST.Vertex(sst,fmt.Sprintf("w%d declared from",w),chap)
+	to := SST.Vertex(sst,fmt.Sprintf("w%d declared to",w),chap)
+
+	if _,_,err := SST.EdgeErr(context.Background(),sst,from,fmt.Sprintf("w%d next",w),to,[]string{"concurrency"},1.0); err != nil {
+		fmt.Println("Edge with a new arrow failed",err)
+	}
+
+	for r := 0; r < rounds; r++ {
+		SST.GetDBArrowsMatchingArrowName(sst,fmt.Sprintf("w%d",(w+1) % workers))
+		SST.FormatGraph(sst,SST.GetSearchGraph(sst,SST.DecodeSearchField(fmt.Sprintf("w%d declared",w))),SST.GRAPH_FORMAT_DOT)
+	}
+}
+
+//******************************************************************
+
+func Search(sst *SST.PoSST,w int,wg *sync.WaitGroup) {
+
+	// Read back whatever has been written so far
+
+	defer wg.Done()
+
+	for r := 0; r < rounds; r++ {
+
+		name := fmt.Sprintf("w%d event",(w+1) % workers)
+		arrows := []SST.ArrowPtr{SST.GetDBArrowByName(sst,"then")}
+
+		for _,nptr := range SST.GetDBNodePtrMatchingNCCS(SST.CopySession(sst),name,"",[]string{"concurrency"},arrows,false,10) {
+			node := SST.GetDBNodeByNodePtr(sst,nptr)
+			SST.GetFwdPathsAsLinks(sst,node.NPtr,SST.LEADSTO,3,10)
+		}
+
+		ctx := SST.TryContext(sst,[]string{"concurrency",fmt.Sprintf("reader %d",w)})
+		SST.GetContext(sst,ctx)
+
+		search := SST.DecodeSearchField(name)
+		SST.UpdateSTMContext(sst,"ambient","now",time.Now().Unix(),search)
+	}
+}
+
+//******************************************************************
+
+func SearchPhase(sst *SST.PoSST,w int,start chan bool,wg *sync.WaitGroup) {
+
+	// A cone search first of all, before anything else that might
+	// order it after the declarations
+
+	defer wg.Done()
+
+	from := sst.STORE.GetNodePtrsByName(SST.DBContext(sst),sst,fmt.Sprintf("w%d event 1",w))
+
+	<-start
+
+	for _,nptr := range from {
+		SST.GetFwdPathsAsLinks(sst,nptr,SST.LEADSTO,2,10)
+		SST.GetEntireConePathsAsLinks(sst,"fwd",nptr,2,10)
+	}
+}
+
+//******************************************************************
+
+func DeclarePhase(sst *SST.PoSST,phase,w int,start chan bool,wg *sync.WaitGroup) {
+
+	// A new context and a new arrow, as N4L or an edit would add them
+
+	defer wg.Done()
+
+	<-start
+
+	SST.IdempContextDirectory(sst,fmt.Sprintf("phase %d worker %d",phase,w))
+	SST.InsertArrowDirectory(sst,"leadsto",fmt.Sprintf("p%d w%d",phase,w),fmt.Sprintf("phase %d worker %d",phase,w),"+")
+}
+
+//******************************************************************
+
+func Unlink(sst *SST.PoSST,hub SST.NodePtr,s int,wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	spoke := sst.STORE.GetNodePtrsByName(SST.DBContext(sst),sst,fmt.Sprintf("spoke %d",s))
+
+	if len(spoke) != 1 {
+		fmt.Println("Missing spoke",s)
+		return
+	}
+
+	if err := SST.DeleteLink(sst,hub,"then",spoke[0]); err != nil {
+		fmt.Println("Delete link failed",err)
+	}
+}
+
+//******************************************************************
+
+func CheckHub(sst *SST.PoSST,hub SST.NodePtr) bool {
+
+	// No removal was undone by another
+
+	node := SST.GetDBNodeByNodePtr(sst,hub)
+
+	if left := len(node.I[SST.STTypeToSTIndex(SST.LEADSTO)]); left > 0 {
+		fmt.Println("Lost edits, the hub still has",left,"links")
+		return false
+	}
+
+	return true
+}
+
+//******************************************************************
+
+func Check(sst *SST.PoSST) bool {
+
+	// Every context registered concurrently has exactly one pointer
+
+	seen := make(map[string]bool)
+
+	for _,cd := range SST.GetContextDirectory(sst) {
+
+		if seen[cd.Context] {
+			fmt.Println("Context registered twice:",cd.Context)
+			return false
+		}
+
+		seen[cd.Context] = true
+
+		if SST.GetContext(sst,cd.Ptr) != cd.Context {
+			fmt.Println("Context pointer mismatch:",cd)
+			return false
+		}
+	}
+
+	// Every arrow declared concurrently is where its pointer says,
+	// with its own inverse
+
+	for w := 0; w < workers; w++ {
+
+		short := fmt.Sprintf("w%d next",w)
+		fwd := SST.GetDBArrowByName(sst,short)
+		arrow := SST.GetDBArrowByPtr(sst,fwd)
+
+		if arrow.Short != short || arrow.Ptr != fwd {
+			fmt.Println("Arrow declared wrongly:",short,arrow)
+			return false
+		}
+
+		inverse := SST.GetDBArrowByPtr(sst,SST.GetInverseArrow(sst,fwd))
+
+		if inverse.Short != fmt.Sprintf("w%d last",w) {
+			fmt.Println("Wrong inverse for",short,inverse)
+			return false
+		}
+	}
+
+	for w := 0; w < workers; w++ {
+
+		name := fmt.Sprintf("w%d event %d",w,rounds-1)
+
+		if len(sst.STORE.GetNodePtrsByName(SST.DBContext(sst),sst,name)) != 1 {
+			fmt.Println("Missing node",name)
+			return false
+		}
+	}
+
+	// A renamed node is only found by its new name, also once it is in
+	// the directory, as when syncing N4L, which doesn't ask the store
+
+	sst.SYNC = true
+
+	var old SST.Node
+
+	old.S = "w0 renamed 9"
+	old.L,old.NPtr.Class = SST.StorageClass(old.S)
+
+	renamed := sst.STORE.GetNodePtrsByName(SST.DBContext(sst),sst,old.S)
+
+	if len(renamed) != 1 {
+		fmt.Println("Missing node",old.S)
+		return false
+	}
+
+	SST.CacheNode(sst,SST.GetDBNodeByNodePtr(sst,renamed[0]))
+
+	if err := SST.RenameNode(sst,renamed[0],"w0 renamed again"); err != nil {
+		fmt.Println("Rename failed",err)
+		return false
+	}
+
+	if _,found,_ := SST.CheckExisting(sst,old); found {
+		fmt.Println("Old name still in the directory",old.S)
+		return false
+	}
+
+	return true
+}
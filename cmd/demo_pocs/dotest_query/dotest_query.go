@@ -0,0 +1,207 @@
+//******************************************************************
+//
+// Parse a table of search commands with ParseSearchField and check
+// the names, contexts, chapter, arrows and warnings they give, that
+// they find the same names as another spelling, or that a malformed
+// expression is refused. Needs no database.
+//
+//******************************************************************
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	SST "github.com/markburgess/SSTorytime/pkg/SSTorytime"
+)
+
+//******************************************************************
+
+type QueryCase struct {
+
+	Query     string
+	Name      []string
+	Context   []string
+	Chapter   string
+	Arrows    []string
+	Like      string   // finds the same names in NAMES as this query
+	Warning   bool
+	Malformed bool
+}
+
+//******************************************************************
+
+var NAMES = []string{"foo","bar","foo bar","bar baz","a","b","c","a b","b c","rose","rose draft"}
+
+//******************************************************************
+
+var CASES = []QueryCase{
+
+	// Plain lists are alternatives
+
+	{ Query: "one two three", Name: []string{"one","two","three"} },
+	{ Query: "rose & !draft", Name: []string{"rose&!draft"} },
+	{ Query: "a AND \\ctx b", Name: []string{"a"}, Context: []string{"b"} },
+	{ Query: "\\context (prod & !staging) fish", Context: []string{"prod&!staging","fish"} },
+	{ Query: "(incident OR outage) AND \\ctx (prod & !staging)", Name: []string{"incident","outage"}, Context: []string{"prod&!staging"} },
+	{ Query: "((a))", Name: []string{"(a)"} },
+
+	// NOT and ! mean the same, leaving their matches out of the rest
+
+	{ Query: "NOT foo bar", Like: "!foo bar" },
+	{ Query: "! foo bar", Like: "!foo bar" },
+	{ Query: "bar NOT foo", Like: "bar !foo" },
+	{ Query: "a NOT b c", Like: "a !b c" },
+	{ Query: "rose NOT draft", Like: "rose & !draft" },
+	{ Query: "NOT foo", Like: "!foo" },
+	{ Query: "NOT (a OR b)", Like: "!a !b" },
+
+	// Arrows and chapters take alternatives and exclusions
+
+	{ Query: "\\arrow NOT then", Arrows: []string{"!then"} },
+	{ Query: "\\arrow then OR next", Arrows: []string{"then","next"} },
+	{ Query: "\\chapter notes NOT draft", Chapter: "notes|!draft" },
+	{ Query: "\\chapter NOT draft", Chapter: "!draft" },
+
+	// Ignored words are searched for, with a warning
+
+	{ Query: "\\foo bar", Name: []string{"\\foo","bar"}, Warning: true },
+	{ Query: "rose \\page", Name: []string{"rose","\\page"}, Warning: true },
+
+	// Malformed expressions leave no sensible search
+
+	{ Query: "a AND", Malformed: true },
+	{ Query: "a OR", Malformed: true },
+	{ Query: "\\ctx a AND", Malformed: true },
+	{ Query: "(a OR b", Malformed: true },
+	{ Query: "NOT", Malformed: true },
+	{ Query: "()", Malformed: true },
+	{ Query: "a (b))", Malformed: true },
+	{ Query: "\\chapter (x OR y)", Malformed: true },
+	{ Query: "\\arrow a AND b", Malformed: true },
+}
+
+//******************************************************************
+
+func main() {
+
+	failed := 0
+
+	for _,c := range CASES {
+		if !Check(c) {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		fmt.Println(failed,"of",len(CASES),"queries parsed wrongly")
+		os.Exit(-1)
+	}
+
+	fmt.Println("All",len(CASES),"queries parsed as expected")
+}
+
+//******************************************************************
+
+func Check(c QueryCase) bool {
+
+	search,err := SST.ParseSearchField(c.Query)
+
+	if c.Malformed {
+		if !errors.Is(err,SST.ERR_MALFORMED_QUERY) {
+			fmt.Printf("%q: expected ERR_MALFORMED_QUERY, got %v\n",c.Query,err)
+			return false
+		}
+		return true
+	}
+
+	if err != nil {
+		fmt.Printf("%q: unexpected error %v\n",c.Query,err)
+		return false
+	}
+
+	ok := true
+
+	if c.Like != "" {
+
+		like,err := SST.ParseSearchField(c.Like)
+
+		if err != nil {
+			fmt.Printf("%q: unexpected error %v\n",c.Like,err)
+			return false
+		}
+
+		for _,text := range NAMES {
+			if Finds(search.Name,text) != Finds(like.Name,text) {
+				fmt.Printf("%q: %q finds \"%s\" %v, but %q %v\n",c.Query,search.Name,text,Finds(search.Name,text),like.Name,Finds(like.Name,text))
+				ok = false
+			}
+		}
+
+		return ok
+	}
+
+	if !Same(search.Name,c.Name) {
+		fmt.Printf("%q: name %q, expected %q\n",c.Query,search.Name,c.Name)
+		ok = false
+	}
+
+	if !Same(search.Context,c.Context) {
+		fmt.Printf("%q: context %q, expected %q\n",c.Query,search.Context,c.Context)
+		ok = false
+	}
+
+	if search.Chapter != c.Chapter {
+		fmt.Printf("%q: chapter %q, expected %q\n",c.Query,search.Chapter,c.Chapter)
+		ok = false
+	}
+
+	if !Same(search.Arrows,c.Arrows) {
+		fmt.Printf("%q: arrows %q, expected %q\n",c.Query,search.Arrows,c.Arrows)
+		ok = false
+	}
+
+	if c.Warning != (len(search.Warnings) > 0) {
+		fmt.Printf("%q: warnings %q, expected warning %v\n",c.Query,search.Warnings,c.Warning)
+		ok = false
+	}
+
+	return ok
+}
+
+//******************************************************************
+
+func Finds(names []string,text string) bool {
+
+	// As SolveNodePtrs() and NodeMatchesNCCS() match a node's text
+
+	include,exclude := SST.SplitNegatedSearchList(names)
+
+	for _,ex := range exclude {
+		if SST.NodeNameTermMatches(text,ex) {
+			return false
+		}
+	}
+
+	if len(include) == 0 {
+		return true
+	}
+
+	for _,in := range include {
+		if SST.NodeNameMatches(text,in) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//******************************************************************
+
+func Same(a,b []string) bool {
+
+	return strings.Join(a,"\n") == strings.Join(b,"\n") && len(a) == len(b)
+}
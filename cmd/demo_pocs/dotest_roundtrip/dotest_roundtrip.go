@@ -0,0 +1,237 @@
+//******************************************************************
+//
+// Compile N4L files, export the graph back to N4L with ExportN4L,
+// compile that and check that both give the same graph: the same
+// nodes, links, weights and contexts. Needs no database.
+//
+// e.g. dotest_roundtrip ../examples/chinese.n4l
+//
+//******************************************************************
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	SST "github.com/markburgess/SSTorytime/pkg/SSTorytime"
+	N4L "github.com/markburgess/SSTorytime/pkg/n4l"
+)
+
+//******************************************************************
+
+func main() {
+
+	if len(os.Args) < 2 {
+		fmt.Println("usage: dotest_roundtrip file.n4l ...")
+		os.Exit(2)
+	}
+
+	failed := false
+
+	for _,filename := range os.Args[1:] {
+		if !RoundTrip(filename) {
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(-1)
+	}
+}
+
+//******************************************************************
+
+func RoundTrip(filename string) bool {
+
+	graph,diagnostics := N4L.ParseFiles(filename)
+
+	if N4L.Failed(diagnostics) {
+		fmt.Println("Unable to compile",filename)
+		return false
+	}
+
+	sst,ok := Store(graph)
+
+	if !ok {
+		return false
+	}
+
+	var config SST.N4LConfig
+
+	if dir := FindConfigDir(); dir != "" {
+		config = SST.ReadN4LConfig(dir)
+	}
+
+	var n4l string
+
+	for _,chapter := range SST.GetDBChaptersMatchingName(sst,"") {
+
+		text,err := SST.ExportN4L(&sst,chapter,config)
+
+		if err != nil {
+			fmt.Println("Unable to export",chapter,err)
+			return false
+		}
+
+		n4l += text
+	}
+
+	regraph,diagnostics := N4L.Parse(strings.NewReader(n4l))
+
+	if N4L.Failed(diagnostics) {
+		fmt.Println("The export of",filename,"doesn't compile")
+		return false
+	}
+
+	resst,ok := Store(regraph)
+
+	if !ok {
+		return false
+	}
+
+	before := Describe(&sst)
+	after := Describe(&resst)
+
+	lost := Subtract(before,after)
+	gained := Subtract(after,before)
+
+	for _,s := range lost {
+		fmt.Println(" lost:  ",s)
+	}
+
+	for _,s := range gained {
+		fmt.Println(" gained:",s)
+	}
+
+	if len(lost) > 0 || len(gained) > 0 {
+		fmt.Printf("%s: %d of %d links and nodes lost, %d gained\n",filename,len(lost),len(before),len(gained))
+		return false
+	}
+
+	fmt.Printf("%s: same %d links and nodes\n",filename,len(before))
+	return true
+}
+
+//******************************************************************
+
+func Store(graph *N4L.Graph) (SST.PoSST,bool) {
+
+	// Then open it again, as exportN4L would, with the arrows it stored
+
+	store := SST.NewMemoryStore()
+	sst,err := SST.OpenStoreErr(context.Background(),store,false)
+
+	if err == nil {
+		err = N4L.Upload(sst,graph,false)
+	}
+
+	if err == nil {
+		sst,err = SST.OpenStoreErr(context.Background(),store,true)
+	}
+
+	if err != nil {
+		fmt.Println("Unable to store the graph",err)
+		return sst,false
+	}
+
+	return sst,true
+}
+
+//******************************************************************
+
+func Describe(sst *SST.PoSST) map[string]int {
+
+	// Every node and link, by text, since pointers are renumbered
+
+	var seen = make(map[SST.NodePtr]bool)
+	var description = make(map[string]int)
+
+	for _,chapter := range SST.GetDBChaptersMatchingName(*sst,"") {
+		for _,nptr := range SST.GetDBNodePtrsByChapter(sst,chapter) {
+
+			if seen[nptr] {
+				continue
+			}
+
+			seen[nptr] = true
+
+			node,_ := sst.STORE.GetNode(SST.DBContext(sst),sst,nptr)
+			description[fmt.Sprintf("node %q in %q",node.S,node.Chap)]++
+
+			for st := range node.I {
+				for _,lnk := range node.I[st] {
+					dst,_ := sst.STORE.GetNode(SST.DBContext(sst),sst,lnk.Dst)
+					arrow := sst.ARROW_DIRECTORY[lnk.Arr].Long
+					description[fmt.Sprintf("%q -(%s,%g)-> %q in %s",node.S,arrow,lnk.Wgt,dst.S,Context(sst,lnk.Ctx))]++
+				}
+			}
+		}
+	}
+
+	return description
+}
+
+//******************************************************************
+
+func Context(sst *SST.PoSST,ptr SST.ContextPtr) string {
+
+	var items []string
+
+	for _,c := range strings.Split(SST.GetContext(sst,ptr),",") {
+		if c = strings.TrimSpace(c); c != "" {
+			items = append(items,c)
+		}
+	}
+
+	sort.Strings(items)
+	return "("+strings.Join(items,", ")+")"
+}
+
+//******************************************************************
+
+func Subtract(from,remove map[string]int) []string {
+
+	var retval []string
+
+	for s,n := range from {
+		for i := remove[s]; i < n; i++ {
+			retval = append(retval,s)
+		}
+	}
+
+	sort.Strings(retval)
+	return retval
+}
+
+//******************************************************************
+
+func FindConfigDir() string {
+
+	// Same search path as N4L, so the inferences match what was read
+
+	search_paths := []string{"./SSTconfig","../SSTconfig","../../SSTconfig"}
+	dir := os.Getenv("SST_CONFIG_PATH")
+
+	if dir != "" {
+		search_paths = []string{dir}
+	}
+
+	for p := range search_paths {
+
+		info, err := os.Stat(search_paths[p]);
+
+		if err == nil && info.IsDir() {
+			return search_paths[p]
+		}
+	}
+
+	return ""
+}
+
+//
+// dotest_roundtrip.go
+//
@@ -0,0 +1,152 @@
+//******************************************************************
+//
+// Find the least cost paths through a small weighted graph, with
+// and without a limit on their length, and check their order and
+// total weights. Needs no database.
+//
+//******************************************************************
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	SST "github.com/markburgess/SSTorytime/pkg/SSTorytime"
+)
+
+//******************************************************************
+
+type PathCase struct {
+
+	From     string    // A, and B, unless given
+	To       string
+	K        int
+	MaxDepth int
+	Paths    []string  // the nodes along each path, in order of cost
+	Costs    []float32
+}
+
+//******************************************************************
+
+// A-1->P-1->X is cheaper than A-10->X, but a path of at most two
+// links to B has to take the dearer one. B-1->A closes a loop, which
+// a search from A to A has to find with or without a depth limit
+
+var LINKS = []struct {
+
+	From   string
+	To     string
+	Weight float32
+}{
+	{ "A", "P", 1 },
+	{ "P", "X", 1 },
+	{ "A", "X", 10 },
+	{ "X", "B", 1 },
+	{ "B", "A", 1 },
+}
+
+var CASES = []PathCase{
+
+	{ K: 1, MaxDepth: 0, Paths: []string{"A P X B"}, Costs: []float32{3} },
+	{ K: 1, MaxDepth: 2, Paths: []string{"A X B"}, Costs: []float32{11} },
+	{ K: 1, MaxDepth: 1 },
+	{ K: 2, MaxDepth: 0, Paths: []string{"A P X B","A X B"}, Costs: []float32{3,11} },
+	{ K: 2, MaxDepth: 2, Paths: []string{"A X B"}, Costs: []float32{11} },
+	{ K: 2, MaxDepth: 3, Paths: []string{"A P X B","A X B"}, Costs: []float32{3,11} },
+	{ From: "A", To: "A", K: 1, MaxDepth: 0, Paths: []string{"A P X B A"}, Costs: []float32{4} },
+	{ From: "A", To: "A", K: 1, MaxDepth: 4, Paths: []string{"A P X B A"}, Costs: []float32{4} },
+	{ From: "A", To: "A", K: 1, MaxDepth: 3, Paths: []string{"A X B A"}, Costs: []float32{12} },
+}
+
+//******************************************************************
+
+func main() {
+
+	sst,err := SST.OpenStoreErr(context.Background(),SST.NewMemoryStore(),true)
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	// Arrow 0 is the empty link, which searches don't follow, as in N4L
+
+	empty := SST.InsertArrowDirectory(&sst,"leadsto","empty","debug","+")
+	void := SST.InsertArrowDirectory(&sst,"leadsto","void","unbug","-")
+	SST.InsertInverseArrowDirectory(&sst,empty,void)
+
+	fwd := SST.InsertArrowDirectory(&sst,"leadsto","then","leads to next","+")
+	bwd := SST.InsertArrowDirectory(&sst,"leadsto","prev","comes from","-")
+	SST.InsertInverseArrowDirectory(&sst,fwd,bwd)
+	sst.STORE.UploadArrows(SST.DBContext(&sst),&sst)
+
+	nodes := make(map[string]SST.Node)
+
+	for _,l := range LINKS {
+		for _,name := range []string{l.From,l.To} {
+			if _,ok := nodes[name]; !ok {
+				nodes[name] = SST.Vertex(&sst,name,"weighted paths")
+			}
+		}
+		SST.Edge(&sst,nodes[l.From],"then",nodes[l.To],nil,l.Weight)
+	}
+
+	failed := 0
+
+	for _,c := range CASES {
+
+		from,to := "A","B"
+
+		if c.From != "" {
+			from,to = c.From,c.To
+		}
+
+		if !Check(&sst,c,nodes[from].NPtr,nodes[to].NPtr,fwd) {
+			failed++
+		}
+	}
+
+	SST.Close(sst)
+
+	if failed > 0 {
+		fmt.Println(failed,"of",len(CASES),"searches found the wrong paths")
+		os.Exit(-1)
+	}
+
+	fmt.Println("All",len(CASES),"searches found the least cost paths")
+}
+
+//******************************************************************
+
+func Check(sst *SST.PoSST,c PathCase,from,to SST.NodePtr,arrow SST.ArrowPtr) bool {
+
+	paths,costs := SST.GetWeightedPaths(sst,[]SST.NodePtr{from},[]SST.NodePtr{to},"",nil,[]SST.ArrowPtr{arrow},nil,c.K,c.MaxDepth)
+
+	var found []string
+
+	for _,path := range paths {
+
+		var names []string
+
+		for _,lnk := range path {
+			names = append(names,SST.GetDBNodeByNodePtr(sst,lnk.Dst).S)
+		}
+
+		found = append(found,strings.Join(names," "))
+	}
+
+	ok := fmt.Sprint(found) == fmt.Sprint(c.Paths) && fmt.Sprint(costs) == fmt.Sprint(c.Costs)
+
+	if len(found) == 0 && len(c.Paths) == 0 {
+		ok = true
+	}
+
+	if !ok {
+		fmt.Printf("%s to %s, k=%d depth=%d: found %q costs %v, expected %q costs %v\n",SST.GetDBNodeByNodePtr(sst,from).S,SST.GetDBNodeByNodePtr(sst,to).S,c.K,c.MaxDepth,found,costs,c.Paths,c.Costs)
+	}
+
+	return ok
+}
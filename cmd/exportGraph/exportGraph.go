@@ -0,0 +1,117 @@
+//******************************************************************
+//
+// Export a chapter or search result for Gephi, yEd, Graphviz or RDF
+//
+// e.g. exportGraph -f gexf \\chapter "multi slit" > slit.gexf
+//      exportGraph -f dot -o cone.dot \\from start \\arrows fwd
+//
+//******************************************************************
+
+package main
+
+import (
+	"fmt"
+	"flag"
+	"os"
+	"strings"
+
+	SST "github.com/markburgess/SSTorytime/pkg/SSTorytime"
+)
+
+var FORMAT string
+var OUTPUT string
+
+//******************************************************************
+
+func main() {
+
+	args := Init()
+
+	load_arrows := false
+	sst := SST.Open(load_arrows)
+
+	search_string := ""
+
+	for a := 0; a < len(args); a++ {
+		// a (grouped expression) is not a phrase
+		if strings.Contains(args[a]," ") && !strings.HasPrefix(args[a],"(") {
+			search_string += fmt.Sprintf("\"%s\"",args[a]) + " "
+		} else {
+			search_string += args[a] + " "
+		}
+	}
+
+	search,err := SST.ParseSearchField(search_string)
+
+	if err != nil {
+		fmt.Println(err)
+		SST.Close(sst)
+		os.Exit(-1)
+	}
+
+	for _,warning := range search.Warnings {
+		fmt.Fprintln(os.Stderr,"WARNING:",warning)
+	}
+
+	graph := SST.GetSearchGraph(&sst,search)
+	data := SST.FormatGraph(&sst,graph,FORMAT)
+
+	SST.Close(sst)
+
+	if OUTPUT == "" {
+		fmt.Print(data)
+		return
+	}
+
+	err = os.WriteFile(OUTPUT,[]byte(data),0644)
+
+	if err != nil {
+		fmt.Println("Unable to write",OUTPUT,err)
+		os.Exit(-1)
+	}
+
+	fmt.Println("Wrote",len(graph.Nodes),"nodes and",len(graph.Edges),"links to",OUTPUT)
+}
+
+//**************************************************************
+
+func Usage() {
+
+	fmt.Printf("usage: exportGraph [-f graphml|gexf|dot|turtle|jsonld] [-o file] <search request>\n\n")
+	fmt.Println("exportGraph \\\\chapter \"multi slit\"")
+	fmt.Println("exportGraph -f gexf \\\\chapter chinese \\\\context food")
+	fmt.Println("exportGraph -f dot \\\\from start \\\\arrows fwd")
+	fmt.Println("exportGraph -f dot -o paths.dot a1 to b6")
+	fmt.Println("exportGraph -f turtle \\\\chapter chinese > chinese.ttl")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+//**************************************************************
+
+func Init() []string {
+
+	flag.Usage = Usage
+
+	formatPtr := flag.String("f",SST.GRAPH_FORMAT_GRAPHML,"output format: graphml, gexf, dot, turtle, trig or jsonld")
+	outputPtr := flag.String("o","","write to this file instead of stdout")
+
+	flag.Parse()
+
+	FORMAT = strings.ToLower(*formatPtr)
+	OUTPUT = *outputPtr
+
+	if !SST.IsGraphFormat(FORMAT) {
+		Usage()
+	}
+
+	if len(flag.Args()) < 1 {
+		Usage()
+	}
+
+	return flag.Args()
+}
+
+//
+// exportGraph.go
+//
@@ -0,0 +1,128 @@
+//******************************************************************
+//
+// Write the stored graph back out as N4L source, chapter by chapter
+//
+// e.g. exportN4L -o mary.n4l "high brow poetry about Mary"
+//      exportN4L > everything.n4l
+//
+//******************************************************************
+
+package main
+
+import (
+	"fmt"
+	"flag"
+	"os"
+
+	SST "github.com/markburgess/SSTorytime/pkg/SSTorytime"
+)
+
+var OUTPUT string
+
+//******************************************************************
+
+func main() {
+
+	args := Init()
+
+	load_arrows := true
+	sst := SST.Open(load_arrows)
+
+	chapters := args
+
+	if len(chapters) == 0 {
+		chapters = SST.GetDBChaptersMatchingName(sst,"")
+	}
+
+	var config SST.N4LConfig
+
+	dir := FindConfigDir()
+
+	if dir != "" {
+		config = SST.ReadN4LConfig(dir)
+	} else {
+		fmt.Fprintln(os.Stderr,"Warning: no SSTconfig found, annotations and closures will be written as links")
+	}
+
+	var n4l string
+
+	for c := range chapters {
+
+		text,err := SST.ExportN4L(&sst,chapters[c],config)
+
+		if err != nil {
+			fmt.Println("Unable to export",chapters[c],err)
+			SST.Close(sst)
+			os.Exit(-1)
+		}
+
+		n4l += text
+	}
+
+	SST.Close(sst)
+
+	if OUTPUT == "" {
+		fmt.Print(n4l)
+		return
+	}
+
+	err := os.WriteFile(OUTPUT,[]byte(n4l),0644)
+
+	if err != nil {
+		fmt.Println("Unable to write",OUTPUT,err)
+		os.Exit(-1)
+	}
+}
+
+//**************************************************************
+
+func Usage() {
+
+	fmt.Printf("usage: exportN4L [-o file.n4l] [\"chapter name\" ...]\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+//**************************************************************
+
+func Init() []string {
+
+	flag.Usage = Usage
+
+	outputPtr := flag.String("o","","write N4L to this file instead of stdout")
+
+	flag.Parse()
+
+	OUTPUT = *outputPtr
+
+	return flag.Args()
+}
+
+//**************************************************************
+
+func FindConfigDir() string {
+
+	// Same search path as N4L, so the inferences match what was read
+
+	search_paths := []string{"./SSTconfig","../SSTconfig","../../SSTconfig"}
+	dir := os.Getenv("SST_CONFIG_PATH")
+
+	if dir != "" {
+		search_paths = []string{dir}
+	}
+
+	for p := range search_paths {
+
+		info, err := os.Stat(search_paths[p]);
+
+		if err == nil && info.IsDir() {
+			return search_paths[p]
+		}
+	}
+
+	return ""
+}
+
+//
+// exportN4L.go
+//
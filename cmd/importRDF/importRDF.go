@@ -0,0 +1,140 @@
+//******************************************************************
+//
+// Read RDF triples (Turtle/TriG or JSON-LD) into the graph
+//
+// e.g. importRDF -chapter "vocabulary" thesaurus.ttl
+//      importRDF -sttype contains taxonomy.jsonld
+//
+//******************************************************************
+
+package main
+
+import (
+	"fmt"
+	"flag"
+	"os"
+	"strings"
+	"path/filepath"
+
+	SST "github.com/markburgess/SSTorytime/pkg/SSTorytime"
+)
+
+var CHAPTER string
+var FORMAT string
+var STTYPE int
+
+//******************************************************************
+
+func main() {
+
+	args := Init()
+
+	load_arrows := true
+	sst := SST.Open(load_arrows)
+
+	for _,filename := range args {
+
+		data,err := os.ReadFile(filename)
+
+		if err != nil {
+			fmt.Println("Unable to read",filename,err)
+			os.Exit(-1)
+		}
+
+		format := FORMAT
+
+		if format == "" {
+			format = FormatFromName(filename)
+		}
+
+		var triples []SST.RDFTriple
+
+		switch format {
+		case SST.GRAPH_FORMAT_JSONLD:
+			triples,err = SST.ParseJSONLD(string(data))
+		default:
+			triples,err = SST.ParseTurtle(string(data))
+		}
+
+		if err != nil {
+			fmt.Println("Unable to parse",filename,err)
+			os.Exit(-1)
+		}
+
+		chapter := CHAPTER
+
+		if chapter == "" {
+			chapter = strings.TrimSuffix(filepath.Base(filename),filepath.Ext(filename))
+		}
+
+		nodes,links := SST.UploadRDF(&sst,triples,chapter,STTYPE)
+
+		fmt.Println("Read",len(triples),"triples from",filename,"as",nodes,"nodes and",links,"links")
+	}
+
+	SST.Close(sst)
+}
+
+//**************************************************************
+
+func FormatFromName(filename string) string {
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".jsonld",".json":
+		return SST.GRAPH_FORMAT_JSONLD
+	case ".trig":
+		return SST.GRAPH_FORMAT_TRIG
+	}
+
+	return SST.GRAPH_FORMAT_TURTLE
+}
+
+//**************************************************************
+
+func Usage() {
+
+	fmt.Printf("usage: importRDF [-chapter name] [-sttype type] [-f turtle|trig|jsonld] file.ttl ...\n\n")
+	fmt.Println("Predicates become arrows. Unknown predicates without an sst:sttype are")
+	fmt.Println("defined with -sttype: near, leadsto, contains, express (or -leadsto etc)")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+//**************************************************************
+
+func Init() []string {
+
+	flag.Usage = Usage
+
+	chapterPtr := flag.String("chapter","","chapter for nodes without sst:chapter (default file name)")
+	sttypePtr := flag.String("sttype","express","ST type for new arrows")
+	formatPtr := flag.String("f","","input format: turtle, trig or jsonld (default by file extension)")
+
+	flag.Parse()
+
+	CHAPTER = *chapterPtr
+	FORMAT = strings.ToLower(*formatPtr)
+
+	sttype,ok := SST.RDFSTTypeFromName(*sttypePtr)
+
+	if !ok {
+		fmt.Println("Unknown ST type",*sttypePtr)
+		Usage()
+	}
+
+	STTYPE = sttype
+
+	if FORMAT != "" && FORMAT != SST.GRAPH_FORMAT_TURTLE && FORMAT != SST.GRAPH_FORMAT_TRIG && FORMAT != SST.GRAPH_FORMAT_JSONLD {
+		Usage()
+	}
+
+	if len(flag.Args()) < 1 {
+		Usage()
+	}
+
+	return flag.Args()
+}
+
+//
+// importRDF.go
+//
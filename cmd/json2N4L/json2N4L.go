@@ -0,0 +1,191 @@
+//******************************************************************
+//
+// Convert a JSON or YAML document to N4L, or upload it directly,
+// keeping its nesting as a containment hierarchy
+//
+// e.g. json2N4L raw-json.json > raw-json.n4l
+//      json2N4L -u -chapter "api response" raw-json.json
+//
+//******************************************************************
+
+package main
+
+import (
+	"fmt"
+	"flag"
+	"os"
+	"bufio"
+	"strings"
+	"path/filepath"
+
+	SST "github.com/markburgess/SSTorytime/pkg/SSTorytime"
+)
+
+var CHAPTER string
+var FORMAT string
+var OUTPUT string
+var UPLOAD bool
+var CONTAINS string
+var PROPERTY string
+var SEQUENCE string
+
+//******************************************************************
+
+func main() {
+
+	args := Init()
+
+	load_arrows := true
+	sst := SST.Open(load_arrows)
+
+	arrows,ok := SST.GetDocumentArrows(&sst,CONTAINS,PROPERTY,SEQUENCE)
+
+	if !ok {
+		os.Exit(-1)
+	}
+
+	var out *bufio.Writer
+
+	if !UPLOAD {
+		out = OpenOutput()
+	}
+
+	for _,filename := range args {
+
+		doc := ReadDocument(filename)
+
+		chapter := CHAPTER
+
+		if chapter == "" {
+			chapter = strings.TrimSuffix(filepath.Base(filename),filepath.Ext(filename))
+		}
+
+		links := SST.DocumentToLinks(doc,chapter,arrows)
+
+		if UPLOAD {
+			up := SST.NewTableUpload(&sst,chapter)
+			SST.UploadTableLinks(&sst,&up,links)
+			SST.FlushTableUpload(&sst,&up)
+			sst.STORE.Finalize(SST.DBContext(&sst),&sst)
+
+			fmt.Println("Uploaded",filename,"as",up.NewNodes,"new nodes and",up.Links,"links in chapter",chapter)
+		} else {
+			context := ""
+			fmt.Fprintf(out,"- %s\n\n# converted from %s by json2N4L\n",chapter,filepath.Base(filename))
+			out.WriteString(SST.TableLinksToN4L(&sst,links,&context))
+			out.WriteString("\n")
+		}
+	}
+
+	if out != nil {
+		out.Flush()
+	}
+
+	SST.Close(sst)
+}
+
+//**************************************************************
+
+func ReadDocument(filename string) *SST.DocumentValue {
+
+	data,err := os.ReadFile(filename)
+
+	if err != nil {
+		fmt.Println("Unable to read",filename,err)
+		os.Exit(-1)
+	}
+
+	format := FORMAT
+
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(filename)) {
+		case ".yaml",".yml":
+			format = "yaml"
+		default:
+			format = "json"
+		}
+	}
+
+	var doc *SST.DocumentValue
+
+	if format == "yaml" {
+		doc,err = SST.ParseYAMLDocument(string(data))
+	} else {
+		doc,err = SST.ParseJSONDocument(string(data))
+	}
+
+	if err != nil {
+		fmt.Println("Unable to parse",filename,err)
+		os.Exit(-1)
+	}
+
+	return doc
+}
+
+//**************************************************************
+
+func OpenOutput() *bufio.Writer {
+
+	if OUTPUT == "" {
+		return bufio.NewWriter(os.Stdout)
+	}
+
+	file,err := os.Create(OUTPUT)
+
+	if err != nil {
+		fmt.Println("Unable to write",OUTPUT,err)
+		os.Exit(-1)
+	}
+
+	return bufio.NewWriter(file)
+}
+
+//**************************************************************
+
+func Usage() {
+
+	fmt.Printf("usage: json2N4L [-chapter name] [-f json|yaml] [-o file.n4l | -u] document.json ...\n\n")
+	fmt.Println("Objects and arrays (contain) their members, keys (hasX) their values,")
+	fmt.Println("and array elements are joined in order by (then)")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+//**************************************************************
+
+func Init() []string {
+
+	flag.Usage = Usage
+
+	chapterPtr := flag.String("chapter","","chapter name, also the root node (default file name)")
+	formatPtr := flag.String("f","","input format: json or yaml (default by file extension)")
+	outputPtr := flag.String("o","","write N4L to this file instead of stdout")
+	uploadPtr := flag.Bool("u",false,"upload directly instead of writing N4L")
+	containsPtr := flag.String("contains","contain","arrow from an object or array to its members")
+	propertyPtr := flag.String("property","hasX","arrow from a key to its scalar value")
+	sequencePtr := flag.String("sequence","then","arrow from one array element to the next")
+
+	flag.Parse()
+
+	CHAPTER = *chapterPtr
+	FORMAT = strings.ToLower(*formatPtr)
+	OUTPUT = *outputPtr
+	UPLOAD = *uploadPtr
+	CONTAINS = *containsPtr
+	PROPERTY = *propertyPtr
+	SEQUENCE = *sequencePtr
+
+	if FORMAT != "" && FORMAT != "json" && FORMAT != "yaml" {
+		Usage()
+	}
+
+	if len(flag.Args()) < 1 {
+		Usage()
+	}
+
+	return flag.Args()
+}
+
+//
+// json2N4L.go
+//
@@ -0,0 +1,174 @@
+//******************************************************************
+//
+// Fold a duplicate node into another, or split it off again
+//
+// e.g. mergeN4L -force "daisy" "Daisy"
+//      mergeN4L -force -split "daisy" "Daisy"
+//      mergeN4L -aliases "daisy"
+//
+//******************************************************************
+
+package main
+
+import (
+	"fmt"
+	"flag"
+	"os"
+
+	SST "github.com/markburgess/SSTorytime/pkg/SSTorytime"
+)
+
+var SPLIT bool
+var ALIASES bool
+
+//******************************************************************
+
+func main() {
+
+	args := Init()
+
+	load_arrows := true
+	sst := SST.Open(load_arrows)
+
+	keep := FindNode(&sst,args[0])
+
+	if ALIASES {
+		ShowAliases(&sst,keep)
+		SST.Close(sst)
+		return
+	}
+
+	var report SST.MergeReport
+	var err error
+
+	if SPLIT {
+		report,err = SST.SplitNode(&sst,keep,args[1])
+	} else {
+		report,err = SST.MergeNodes(&sst,keep,FindNode(&sst,args[1]))
+	}
+
+	SST.Close(sst)
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+
+	if SPLIT {
+		fmt.Println("Split off:")
+	} else {
+		fmt.Println("Merged:")
+	}
+
+	fmt.Print(SST.FormatMergeReport(report))
+}
+
+//**************************************************************
+
+func Usage() {
+
+	fmt.Printf("usage: mergeN4L -force <keep> <merge into it>\n")
+	fmt.Printf("       mergeN4L -force -split <node> <alias name>\n")
+	fmt.Printf("       mergeN4L -aliases <node>\n\n")
+	fmt.Printf("Nodes are given by their exact text, or as (class,cptr) when the text is not unique\n\n")
+	fmt.Println("mergeN4L -force \"daisy\" \"Daisy\"")
+	fmt.Println("mergeN4L -force \"(1,12)\" \"(1,40)\"")
+	fmt.Println("mergeN4L -force -split \"daisy\" \"Daisy\"")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+//**************************************************************
+
+func Init() []string {
+
+	flag.Usage = Usage
+
+	forcePtr := flag.Bool("force",false,"confirm a merge or split")
+	splitPtr := flag.Bool("split",false,"split a merged node off again")
+	aliasPtr := flag.Bool("aliases",false,"list the nodes merged into a node")
+
+	flag.Parse()
+
+	SPLIT = *splitPtr
+	ALIASES = *aliasPtr
+
+	args := flag.Args()
+
+	if ALIASES {
+		if len(args) != 1 {
+			Usage()
+		}
+		return args
+	}
+
+	if len(args) != 2 {
+		Usage()
+	}
+
+	if !*forcePtr {
+		fmt.Println("Are you sure you want to change these nodes? Use -force to confirm.")
+		os.Exit(1)
+	}
+
+	return args
+}
+
+//**************************************************************
+
+func FindNode(sst *SST.PoSST,arg string) SST.NodePtr {
+
+	var nptr SST.NodePtr
+
+	n,_ := fmt.Sscanf(arg,"(%d,%d)",&nptr.Class,&nptr.CPtr)
+
+	if n == 2 {
+		return nptr
+	}
+
+	nptrs := sst.STORE.GetNodePtrsByName(SST.DBContext(sst),sst,arg)
+
+	switch len(nptrs) {
+
+	case 0:
+		fmt.Println("No node called",arg)
+		os.Exit(-1)
+
+	case 1:
+		return nptrs[0]
+
+	default:
+		fmt.Println("More than one node is called",arg,"so choose by pointer:")
+
+		for _,nptr := range nptrs {
+			node := SST.GetDBNodeByNodePtr(sst,nptr)
+			fmt.Printf("   (%d,%d) in chapter: %s\n",nptr.Class,nptr.CPtr,node.Chap)
+		}
+
+		os.Exit(-1)
+	}
+
+	return nptr
+}
+
+//**************************************************************
+
+func ShowAliases(sst *SST.PoSST,nptr SST.NodePtr) {
+
+	aliases := SST.GetDBNodeAliases(sst,nptr)
+
+	if len(aliases) == 0 {
+		fmt.Printf("Nothing has been merged into (%d,%d)\n",nptr.Class,nptr.CPtr)
+		return
+	}
+
+	fmt.Printf("Merged into (%d,%d):\n",nptr.Class,nptr.CPtr)
+
+	for _,alias := range aliases {
+		fmt.Printf("   (%d,%d) \"%s\" in chapter: %s\n",alias.Alias.Class,alias.Alias.CPtr,alias.S,alias.Chap)
+	}
+}
+
+//
+// mergeN4L.go
+//
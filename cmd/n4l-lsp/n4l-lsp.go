@@ -0,0 +1,835 @@
+//******************************************************************
+//
+// A Language Server Protocol server for N4L notes, for editors
+// that speak LSP on stdin/stdout, e.g. VS Code, Neovim, Emacs
+//
+// e.g. n4l-lsp
+//      SST_CONFIG_PATH=~/SSTorytime/SSTconfig n4l-lsp
+//
+//******************************************************************
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	SST "github.com/markburgess/SSTorytime/pkg/SSTorytime"
+	N4L "github.com/markburgess/SSTorytime/pkg/n4l"
+)
+
+//******************************************************************
+
+type Document struct {
+
+	URI         string
+	Path        string
+	Lines       []string
+	Marks       []N4L.Mark       // sections, contexts, relations, aliases...
+	SST         SST.PoSST        // the compiled notes, with the SSTconfig arrows
+	Diagnostics []N4L.Diagnostic
+}
+
+//******************************************************************
+
+var (
+	DOCUMENTS = make(map[string]*Document)
+
+	OUT io.Writer // the protocol, see main()
+	SHUTDOWN bool
+)
+
+//******************************************************************
+// BEGIN
+//******************************************************************
+
+func main() {
+
+	flag.Usage = Usage
+	flag.Parse()
+
+	OUT = os.Stdout
+
+	in := bufio.NewReader(os.Stdin)
+
+	for {
+		body,err := ReadMessage(in)
+
+		if err == io.EOF {
+			os.Exit(0)
+		}
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr,"n4l-lsp:",err)
+			os.Exit(1)
+		}
+
+		var msg Message
+
+		if err := json.Unmarshal(body,&msg); err != nil {
+			fmt.Fprintln(os.Stderr,"n4l-lsp: unreadable message:",err)
+			continue
+		}
+
+		Handle(msg)
+	}
+}
+
+//******************************************************************
+
+func Usage() {
+
+	fmt.Printf("usage: n4l-lsp\n\n")
+	fmt.Printf("Speaks the Language Server Protocol on stdin/stdout, for editing N4L notes.\n")
+	fmt.Printf("Arrows are read from SST_CONFIG_PATH, or the nearest SSTconfig directory\n")
+	fmt.Printf("above the workspace or the notes\n\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+//******************************************************************
+
+func Handle(msg Message) {
+
+	switch msg.Method {
+
+	case "initialize":
+		var params InitializeParams
+
+		if DecodeParams(msg,&params) {
+			FindConfig(URIToPath(params.RootURI))
+			Reply(msg,Capabilities())
+		}
+
+	case "initialized":
+
+	case "shutdown":
+		SHUTDOWN = true
+		Reply(msg,nil)
+
+	case "exit":
+		if SHUTDOWN {
+			os.Exit(0)
+		}
+		os.Exit(1)
+
+	case "textDocument/didOpen":
+		var params DidOpenParams
+
+		if DecodeParams(msg,&params) {
+			Open(params.TextDocument.URI,params.TextDocument.Text)
+		}
+
+	case "textDocument/didChange":
+		var params DidChangeParams
+
+		if DecodeParams(msg,&params) && len(params.ContentChanges) > 0 {
+			last := params.ContentChanges[len(params.ContentChanges)-1]
+			Open(params.TextDocument.URI,last.Text)
+		}
+
+	case "textDocument/didClose":
+		var params DidCloseParams
+
+		if DecodeParams(msg,&params) {
+			delete(DOCUMENTS,params.TextDocument.URI)
+			Publish(params.TextDocument.URI,[]LSPDiagnostic{})
+		}
+
+	case "textDocument/completion":
+		var params PositionParams
+
+		if DecodeParams(msg,&params) {
+			Reply(msg,Complete(DOCUMENTS[params.TextDocument.URI],params.Position))
+		}
+
+	case "textDocument/hover":
+		var params PositionParams
+
+		if DecodeParams(msg,&params) {
+			Reply(msg,HoverAt(DOCUMENTS[params.TextDocument.URI],params.Position))
+		}
+
+	case "textDocument/definition":
+		var params PositionParams
+
+		if DecodeParams(msg,&params) {
+			Reply(msg,Definition(DOCUMENTS[params.TextDocument.URI],params.Position))
+		}
+
+	case "textDocument/documentSymbol":
+		var params DidCloseParams // only the textDocument
+
+		if DecodeParams(msg,&params) {
+			Reply(msg,Symbols(DOCUMENTS[params.TextDocument.URI]))
+		}
+
+	default:
+		if msg.ID != nil {
+			ReplyError(msg,ERR_METHOD_NOT_FOUND,"n4l-lsp has no method "+msg.Method)
+		}
+	}
+}
+
+//******************************************************************
+
+func Capabilities() interface{} {
+
+	type completion struct {
+		TriggerCharacters []string `json:"triggerCharacters"`
+	}
+
+	type capabilities struct {
+		TextDocumentSync       int        `json:"textDocumentSync"`
+		CompletionProvider     completion `json:"completionProvider"`
+		HoverProvider          bool       `json:"hoverProvider"`
+		DefinitionProvider     bool       `json:"definitionProvider"`
+		DocumentSymbolProvider bool       `json:"documentSymbolProvider"`
+	}
+
+	var result struct {
+		Capabilities capabilities      `json:"capabilities"`
+		ServerInfo   map[string]string `json:"serverInfo"`
+	}
+
+	result.Capabilities.TextDocumentSync = TEXT_SYNC_FULL
+	result.Capabilities.CompletionProvider.TriggerCharacters = []string{"(","$","@"}
+	result.Capabilities.HoverProvider = true
+	result.Capabilities.DefinitionProvider = true
+	result.Capabilities.DocumentSymbolProvider = true
+	result.ServerInfo = map[string]string{"name": "n4l-lsp"}
+
+	return result
+}
+
+//******************************************************************
+
+func DecodeParams(msg Message,params interface{}) bool {
+
+	if err := json.Unmarshal(msg.Params,params); err != nil {
+		if msg.ID != nil {
+			ReplyError(msg,ERR_INVALID_PARAMS,err.Error())
+		}
+		return false
+	}
+
+	return true
+}
+
+//******************************************************************
+
+func Reply(msg Message,result interface{}) {
+
+	// A success always carries its result, even when that is null
+
+	Send(Success{JSONRPC: "2.0", ID: msg.ID, Result: result})
+}
+
+//******************************************************************
+
+func ReplyError(msg Message,code int,message string) {
+
+	Send(Response{JSONRPC: "2.0", ID: msg.ID, Error: &ResponseError{Code: code, Message: message}})
+}
+
+//******************************************************************
+
+func Send(message interface{}) {
+
+	if err := WriteMessage(OUT,message); err != nil {
+		fmt.Fprintln(os.Stderr,"n4l-lsp:",err)
+		os.Exit(1)
+	}
+}
+
+//******************************************************************
+
+func FindConfig(dir string) {
+
+	// The nearest SSTconfig above dir, unless SST_CONFIG_PATH says
+
+	if os.Getenv("SST_CONFIG_PATH") != "" || dir == "" {
+		return
+	}
+
+	for dir = filepath.Clean(dir); ; dir = filepath.Dir(dir) {
+
+		config := filepath.Join(dir,"SSTconfig")
+
+		if info,err := os.Stat(config); err == nil && info.IsDir() {
+			os.Setenv("SST_CONFIG_PATH",config)
+			return
+		}
+
+		if dir == filepath.Dir(dir) {
+			return
+		}
+	}
+}
+
+//******************************************************************
+// Diagnostics
+//******************************************************************
+
+func Open(uri,text string) {
+
+	// Compile the notes again, after every change
+
+	var doc Document
+
+	doc.URI = uri
+	doc.Path = URIToPath(uri)
+	doc.Lines = strings.Split(text,"\n")
+
+	FindConfig(filepath.Dir(doc.Path))
+
+	graph,diagnostics := Compile(doc.Path,text)
+
+	doc.SST = graph.SST
+
+	// The parser stops at the first error, the outline goes on
+
+	marks,problems := N4L.Outline(doc.Path,text)
+	problems = append(problems,N4L.CheckArrows(&doc.SST,doc.Path,marks)...)
+
+	doc.Marks = marks
+	doc.Diagnostics = diagnostics
+
+	seen := make(map[string]bool)
+
+	for _,d := range diagnostics {
+		seen[fmt.Sprint(d.Line,d.Column,d.Message)] = true
+	}
+
+	for _,d := range problems {
+		if !seen[fmt.Sprint(d.Line,d.Column,d.Message)] {
+			doc.Diagnostics = append(doc.Diagnostics,d)
+		}
+	}
+
+	DOCUMENTS[uri] = &doc
+
+	var published = []LSPDiagnostic{}
+
+	for _,d := range doc.Diagnostics {
+		published = append(published,ConvertDiagnostic(&doc,d))
+	}
+
+	Publish(uri,published)
+}
+
+//******************************************************************
+
+func Compile(path,text string) (*N4L.Graph,[]N4L.Diagnostic) {
+
+	parser := N4L.NewParser()
+	parser.SILENT = true
+	parser.ParseReader(path,strings.NewReader(text))
+
+	return parser.Finish()
+}
+
+//******************************************************************
+
+func Publish(uri string,diagnostics []LSPDiagnostic) {
+
+	var params PublishDiagnosticsParams
+
+	params.URI = uri
+	params.Diagnostics = diagnostics
+
+	Send(Notification{JSONRPC: "2.0", Method: "textDocument/publishDiagnostics", Params: params})
+}
+
+//******************************************************************
+
+func ConvertDiagnostic(doc *Document,d N4L.Diagnostic) LSPDiagnostic {
+
+	var ld LSPDiagnostic
+
+	ld.Source = "N4L"
+	ld.Message = d.Message
+	ld.Severity = SEVERITY_WARNING
+
+	if d.Severity == N4L.SEVERITY_ERROR {
+		ld.Severity = SEVERITY_ERROR
+	}
+
+	line := max(0,min(d.Line-1,len(doc.Lines)-1))
+	text := []rune(doc.Lines[line])
+
+	// A whole line, unless the problem is with one token
+
+	from := 0
+	to := len(text)
+
+	for from < to && unicode.IsSpace(text[from]) {
+		from++
+	}
+
+	switch {
+
+	case d.File != doc.Path:
+		ld.Message = d.File+": "+d.Message // e.g. the configuration
+		line = 0
+		text = []rune(doc.Lines[0])
+		from,to = 0,len(text)
+
+	case d.Column > 0 && d.Column <= len(text):
+		from = d.Column-1
+
+		if m,ok := MarkAt(doc,d.Line,d.Column); ok && m.Column == d.Column {
+			to = min(len(text),from+m.Length)
+		} else {
+			for to = from; to < len(text) && !unicode.IsSpace(text[to]); to++ {
+			}
+		}
+	}
+
+	ld.Range = LineRange(doc,line,from,to)
+
+	return ld
+}
+
+//******************************************************************
+// Completion
+//******************************************************************
+
+func Complete(doc *Document,pos Position) []CompletionItem {
+
+	items := []CompletionItem{}
+
+	if doc == nil || pos.Line >= len(doc.Lines) {
+		return items
+	}
+
+	text := []rune(doc.Lines[pos.Line])
+	col := RuneColumn(doc.Lines[pos.Line],pos.Character)
+	before := string(text[:col])
+
+	// Inside a relation, the arrows
+
+	open := strings.LastIndex(before,"(")
+
+	if open >= 0 && open > strings.LastIndex(before,")") {
+		from := len([]rune(before[:open]))+1
+		return CompleteArrows(doc,LineRange(doc,pos.Line,from,col))
+	}
+
+	// Otherwise aliases, after $ or @
+
+	start := col
+
+	for start > 0 && !unicode.IsSpace(text[start-1]) {
+		start--
+	}
+
+	if start == col {
+		return items
+	}
+
+	edit := LineRange(doc,pos.Line,start,col)
+
+	switch text[start] {
+
+	case '$':
+		for _,alias := range Aliases(doc) {
+			for n,item := range LineItems(doc,alias) {
+				var c CompletionItem
+				c.Label = fmt.Sprintf("$%s.%d",alias.Text,n+1)
+				c.Kind = COMPLETION_REFERENCE
+				c.Detail = item.Text
+				c.TextEdit = &TextEdit{Range: edit, NewText: c.Label}
+				items = append(items,c)
+			}
+		}
+
+	case '@':
+		for _,alias := range Aliases(doc) {
+			var c CompletionItem
+			c.Label = "@"+alias.Text
+			c.Kind = COMPLETION_VARIABLE
+			c.Detail = fmt.Sprintf("line %d",alias.Line)
+			c.TextEdit = &TextEdit{Range: edit, NewText: c.Label}
+			items = append(items,c)
+		}
+	}
+
+	return items
+}
+
+//******************************************************************
+
+func CompleteArrows(doc *Document,edit Range) []CompletionItem {
+
+	// Short and long names, from ARROW_SHORT_DIR and ARROW_LONG_DIR
+
+	items := []CompletionItem{}
+
+	for short,ptr := range doc.SST.ARROW_SHORT_DIR {
+		arrow := doc.SST.ARROW_DIRECTORY[ptr]
+		items = append(items,ArrowItem(short,arrow.Long+", "+ArrowType(arrow),edit))
+	}
+
+	for long,ptr := range doc.SST.ARROW_LONG_DIR {
+		arrow := doc.SST.ARROW_DIRECTORY[ptr]
+
+		if long != arrow.Short {
+			items = append(items,ArrowItem(long,"("+arrow.Short+"), "+ArrowType(arrow),edit))
+		}
+	}
+
+	return items
+}
+
+//******************************************************************
+
+func ArrowItem(name,detail string,edit Range) CompletionItem {
+
+	var c CompletionItem
+
+	c.Label = name
+	c.Kind = COMPLETION_OPERATOR
+	c.Detail = detail
+	c.TextEdit = &TextEdit{Range: edit, NewText: name}
+
+	return c
+}
+
+//******************************************************************
+
+func ArrowType(arrow SST.ArrowDirectory) string {
+
+	sttype := SST.STIndexToSTType(arrow.STAindex)
+
+	return fmt.Sprintf("ST type %d %s",sttype,SST.STTypeName(sttype))
+}
+
+//******************************************************************
+// Hover and definitions
+//******************************************************************
+
+func HoverAt(doc *Document,pos Position) *Hover {
+
+	m,ok := MarkAtPosition(doc,pos)
+
+	if !ok {
+		return nil
+	}
+
+	var value string
+
+	switch m.Role {
+
+	case N4L.ROLE_RELATION:
+		name := N4L.ArrowName(m.Text)
+		arrow,ok := N4L.GetArrow(&doc.SST,name)
+
+		if !ok {
+			value = "No such arrow in SSTconfig: **"+name+"**"
+			break
+		}
+
+		value = fmt.Sprintf("**%s** (%s)\n\n%s",arrow.Long,arrow.Short,ArrowType(arrow))
+
+		if inverse,ok := doc.SST.INVERSE_ARROWS[arrow.Ptr]; ok && inverse != arrow.Ptr {
+			value += "\n\ninverse: **"+doc.SST.ARROW_DIRECTORY[inverse].Long+"**"
+		}
+
+	case N4L.ROLE_LOOKUP:
+		item,ok := Resolve(doc,m)
+
+		if !ok {
+			value = "No such alias or item: **$"+m.Text+"**"
+			break
+		}
+
+		value = fmt.Sprintf("**%s**, line %d",item.Text,item.Line)
+
+	case N4L.ROLE_LINE_ALIAS:
+		var items []string
+
+		for n,item := range LineItems(doc,m) {
+			items = append(items,fmt.Sprintf("$%s.%d = %s",m.Text,n+1,item.Text))
+		}
+
+		value = strings.Join(items,"\n\n")
+
+	default:
+		return nil
+	}
+
+	return &Hover{Contents: MarkupContent{Kind: "markdown", Value: value}, Range: MarkRange(doc,m)}
+}
+
+//******************************************************************
+
+func Definition(doc *Document,pos Position) *Location {
+
+	// Where an alias was defined, or the item it refers to
+
+	m,ok := MarkAtPosition(doc,pos)
+
+	if !ok {
+		return nil
+	}
+
+	switch m.Role {
+
+	case N4L.ROLE_LOOKUP:
+		if item,ok := Resolve(doc,m); ok {
+			return &Location{URI: doc.URI, Range: MarkRange(doc,item)}
+		}
+
+		if alias,ok := AliasFor(doc,AliasName(m.Text),m.Line); ok {
+			return &Location{URI: doc.URI, Range: MarkRange(doc,alias)}
+		}
+
+	case N4L.ROLE_LINE_ALIAS:
+		return &Location{URI: doc.URI, Range: MarkRange(doc,m)}
+	}
+
+	return nil
+}
+
+//******************************************************************
+
+func Resolve(doc *Document,ref N4L.Mark) (N4L.Mark,bool) {
+
+	// $alias.n is the n-th item on the line labelled @alias
+
+	name := AliasName(ref.Text)
+	dot := strings.LastIndex(ref.Text,".")
+
+	if dot < 0 {
+		return N4L.Mark{},false
+	}
+
+	n,err := strconv.Atoi(ref.Text[dot+1:])
+
+	if err != nil || n < 1 {
+		return N4L.Mark{},false
+	}
+
+	alias,ok := AliasFor(doc,name,ref.Line)
+
+	if !ok {
+		return N4L.Mark{},false
+	}
+
+	items := LineItems(doc,alias)
+
+	if n > len(items) {
+		return N4L.Mark{},false
+	}
+
+	return items[n-1],true
+}
+
+//******************************************************************
+
+func AliasName(reference string) string {
+
+	if dot := strings.LastIndex(reference,"."); dot >= 0 {
+		return reference[:dot]
+	}
+
+	return reference
+}
+
+//******************************************************************
+
+func AliasFor(doc *Document,name string,line int) (N4L.Mark,bool) {
+
+	// The last definition before the line, or else the first after
+
+	var found N4L.Mark
+	var ok bool
+
+	for _,m := range doc.Marks {
+		if m.Role == N4L.ROLE_LINE_ALIAS && m.Text == name {
+			if m.Line > line && ok {
+				break
+			}
+			found,ok = m,true
+		}
+	}
+
+	return found,ok
+}
+
+//******************************************************************
+
+func Aliases(doc *Document) []N4L.Mark {
+
+	// One of each alias, where it was last defined
+
+	var aliases []N4L.Mark
+	index := make(map[string]int)
+
+	for _,m := range doc.Marks {
+		if m.Role == N4L.ROLE_LINE_ALIAS && m.Text != "" {
+			if i,seen := index[m.Text]; seen {
+				aliases[i] = m
+			} else {
+				index[m.Text] = len(aliases)
+				aliases = append(aliases,m)
+			}
+		}
+	}
+
+	return aliases
+}
+
+//******************************************************************
+
+func LineItems(doc *Document,alias N4L.Mark) []N4L.Mark {
+
+	// The items on an alias's line, that $alias.1, $alias.2... refer to
+
+	var items []N4L.Mark
+
+	for _,m := range doc.Marks {
+		if m.Line == alias.Line && m.Column > alias.Column {
+			if m.Role == N4L.ROLE_EVENT || m.Role == N4L.ROLE_LOOKUP {
+				items = append(items,m)
+			}
+		}
+	}
+
+	return items
+}
+
+//******************************************************************
+// Outline
+//******************************************************************
+
+func Symbols(doc *Document) []DocumentSymbol {
+
+	// Sections, and the aliases within them
+
+	symbols := []DocumentSymbol{}
+
+	if doc == nil {
+		return symbols
+	}
+
+	for _,m := range doc.Marks {
+
+		switch m.Role {
+
+		case N4L.ROLE_SECTION:
+			var s DocumentSymbol
+
+			s.Name = m.Text
+			s.Kind = SYMBOL_NAMESPACE
+			s.SelectionRange = MarkRange(doc,m)
+			s.Range = s.SelectionRange
+
+			if len(symbols) > 0 {
+				EndSection(doc,&symbols[len(symbols)-1],m.Line-2)
+			}
+
+			symbols = append(symbols,s)
+
+		case N4L.ROLE_LINE_ALIAS:
+			if len(symbols) == 0 {
+				continue
+			}
+
+			var s DocumentSymbol
+
+			s.Name = "@"+m.Text
+			s.Kind = SYMBOL_VARIABLE
+			s.SelectionRange = MarkRange(doc,m)
+			s.Range = LineRange(doc,m.Line-1,0,len([]rune(doc.Lines[m.Line-1])))
+
+			section := &symbols[len(symbols)-1]
+			section.Children = append(section.Children,s)
+		}
+	}
+
+	if len(symbols) > 0 {
+		EndSection(doc,&symbols[len(symbols)-1],len(doc.Lines)-1)
+	}
+
+	return symbols
+}
+
+//******************************************************************
+
+func EndSection(doc *Document,s *DocumentSymbol,line int) {
+
+	// A section runs until the line before the next
+
+	line = max(line,s.Range.Start.Line)
+	s.Range.End = LineRange(doc,line,0,len([]rune(doc.Lines[line]))).End
+}
+
+//******************************************************************
+// Positions
+//******************************************************************
+
+func MarkAtPosition(doc *Document,pos Position) (N4L.Mark,bool) {
+
+	if doc == nil || pos.Line >= len(doc.Lines) {
+		return N4L.Mark{},false
+	}
+
+	col := RuneColumn(doc.Lines[pos.Line],pos.Character)
+
+	return MarkAt(doc,pos.Line+1,col+1)
+}
+
+//******************************************************************
+
+func MarkAt(doc *Document,line,col int) (N4L.Mark,bool) {
+
+	// Both counted from 1, as the outline counts them
+
+	for _,m := range doc.Marks {
+		if m.Line == line && col >= m.Column && col < m.Column+m.Length {
+			return m,true
+		}
+	}
+
+	return N4L.Mark{},false
+}
+
+//******************************************************************
+
+func MarkRange(doc *Document,m N4L.Mark) Range {
+
+	line := m.Line-1
+	length := len([]rune(doc.Lines[line]))
+
+	return LineRange(doc,line,min(m.Column-1,length),min(m.Column-1+m.Length,length))
+}
+
+//******************************************************************
+
+func LineRange(doc *Document,line,from,to int) Range {
+
+	// From rune offsets in a line to an editor's positions
+
+	var r Range
+
+	r.Start.Line = line
+	r.Start.Character = UTF16Column(doc.Lines[line],from)
+	r.End.Line = line
+	r.End.Character = UTF16Column(doc.Lines[line],to)
+
+	return r
+}
+
+//
+// n4l-lsp.go
+//
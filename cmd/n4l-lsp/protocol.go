@@ -0,0 +1,306 @@
+//**************************************************************
+//
+// protocol.go
+//
+//**************************************************************
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+//**************************************************************
+// The parts of the Language Server Protocol that n4l-lsp uses:
+// JSON-RPC messages with a Content-Length header, and positions
+// counted from 0 in UTF-16 code units, as editors count them
+//**************************************************************
+
+type Message struct {
+
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+}
+
+// JSON-RPC 2.0 replies carry either a result or an error, never both
+
+type Response struct {
+
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *ResponseError   `json:"error,omitempty"`
+}
+
+type Success struct {
+
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result"`
+}
+
+type ResponseError struct {
+
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type Notification struct {
+
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+const (
+	ERR_METHOD_NOT_FOUND = -32601
+	ERR_INVALID_PARAMS = -32602
+)
+
+//**************************************************************
+
+type Position struct {
+
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type Range struct {
+
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+type Location struct {
+
+	URI   string `json:"uri"`
+	Range Range  `json:"range"`
+}
+
+type TextDocumentIdentifier struct {
+
+	URI string `json:"uri"`
+}
+
+type TextDocumentItem struct {
+
+	URI     string `json:"uri"`
+	Version int    `json:"version"`
+	Text    string `json:"text"`
+}
+
+type DidOpenParams struct {
+
+	TextDocument TextDocumentItem `json:"textDocument"`
+}
+
+type DidChangeParams struct {
+
+	TextDocument   TextDocumentIdentifier `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"` // the whole text, see TEXT_SYNC_FULL
+	} `json:"contentChanges"`
+}
+
+type DidCloseParams struct {
+
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+type PositionParams struct {
+
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Position     Position               `json:"position"`
+}
+
+type InitializeParams struct {
+
+	RootURI string `json:"rootUri"`
+}
+
+//**************************************************************
+
+type LSPDiagnostic struct {
+
+	Range    Range  `json:"range"`
+	Severity int    `json:"severity"`
+	Source   string `json:"source"`
+	Message  string `json:"message"`
+}
+
+type PublishDiagnosticsParams struct {
+
+	URI         string          `json:"uri"`
+	Diagnostics []LSPDiagnostic `json:"diagnostics"`
+}
+
+type CompletionItem struct {
+
+	Label    string    `json:"label"`
+	Kind     int       `json:"kind"`
+	Detail   string    `json:"detail,omitempty"`
+	TextEdit *TextEdit `json:"textEdit,omitempty"`
+}
+
+type TextEdit struct {
+
+	Range   Range  `json:"range"`
+	NewText string `json:"newText"`
+}
+
+type Hover struct {
+
+	Contents MarkupContent `json:"contents"`
+	Range    Range         `json:"range"`
+}
+
+type MarkupContent struct {
+
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type DocumentSymbol struct {
+
+	Name           string           `json:"name"`
+	Detail         string           `json:"detail,omitempty"`
+	Kind           int              `json:"kind"`
+	Range          Range            `json:"range"`
+	SelectionRange Range            `json:"selectionRange"`
+	Children       []DocumentSymbol `json:"children,omitempty"`
+}
+
+const (
+	TEXT_SYNC_FULL = 1
+
+	SEVERITY_ERROR = 1
+	SEVERITY_WARNING = 2
+
+	COMPLETION_VARIABLE = 6
+	COMPLETION_REFERENCE = 18
+	COMPLETION_OPERATOR = 24
+
+	SYMBOL_NAMESPACE = 3
+	SYMBOL_VARIABLE = 13
+)
+
+//**************************************************************
+
+func ReadMessage(in *bufio.Reader) ([]byte,error) {
+
+	// Headers, a blank line, then Content-Length bytes of JSON
+
+	length := -1
+
+	for {
+		header,err := in.ReadString('\n')
+
+		if err != nil {
+			return nil,err
+		}
+
+		header = strings.TrimSpace(header)
+
+		if header == "" {
+			break
+		}
+
+		name,value,found := strings.Cut(header,":")
+
+		if found && strings.EqualFold(strings.TrimSpace(name),"Content-Length") {
+			length,err = strconv.Atoi(strings.TrimSpace(value))
+
+			if err != nil {
+				return nil,fmt.Errorf("bad Content-Length header: %s",header)
+			}
+		}
+	}
+
+	if length < 0 {
+		return nil,fmt.Errorf("message without Content-Length")
+	}
+
+	body := make([]byte,length)
+	_,err := io.ReadFull(in,body)
+
+	return body,err
+}
+
+//**************************************************************
+
+func WriteMessage(out io.Writer,message interface{}) error {
+
+	body,err := json.Marshal(message)
+
+	if err != nil {
+		return err
+	}
+
+	_,err = fmt.Fprintf(out,"Content-Length: %d\r\n\r\n%s",len(body),body)
+
+	return err
+}
+
+//**************************************************************
+
+func URIToPath(uri string) string {
+
+	u,err := url.Parse(uri)
+
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+
+	return u.Path
+}
+
+//**************************************************************
+
+func UTF16Column(line string,runes int) int {
+
+	// From a count of runes into a line to UTF-16 units
+
+	var units int
+
+	for i,r := range []rune(line) {
+		if i >= runes {
+			break
+		}
+		units += len(utf16.Encode([]rune{r}))
+	}
+
+	return units
+}
+
+//**************************************************************
+
+func RuneColumn(line string,units int) int {
+
+	// From UTF-16 units into a line to a count of runes
+
+	var count int
+
+	for _,r := range []rune(line) {
+		units -= len(utf16.Encode([]rune{r}))
+
+		if units < 0 {
+			break
+		}
+		count++
+	}
+
+	return count
+}
+
+//
+// protocol.go
+//
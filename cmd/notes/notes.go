@@ -86,7 +86,7 @@ func Page(sst SST.PoSST,chapter string,context []string,page int) {
 
 	for n := 0; n < len(notes); n++ {
 
-		txtctx := sst.CONTEXT_DIRECTORY[notes[n].Context].Context
+		txtctx := SST.GetContext(&sst,notes[n].Context)
 
 		if last != notes[n].Chapter || lastc != txtctx {
 			fmt.Println("\n---------------------------------------------")
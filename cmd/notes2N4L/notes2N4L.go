@@ -0,0 +1,194 @@
+//******************************************************************
+//
+// Convert Markdown or Org-mode notes to N4L, or upload them
+// directly: headings become chapters and contain what is under
+// them, lists become sequences, links become arrows and tags context
+//
+// e.g. notes2N4L meeting.md > meeting.n4l
+//      notes2N4L -u -link fwd projects.org
+//
+//******************************************************************
+
+package main
+
+import (
+	"fmt"
+	"flag"
+	"os"
+	"bufio"
+	"strings"
+	"path/filepath"
+
+	SST "github.com/markburgess/SSTorytime/pkg/SSTorytime"
+)
+
+var CHAPTER string
+var FORMAT string
+var OUTPUT string
+var UPLOAD bool
+var CONTAINS string
+var SEQUENCE string
+var LINK string
+
+//******************************************************************
+
+func main() {
+
+	args := Init()
+
+	load_arrows := true
+	sst := SST.Open(load_arrows)
+
+	arrows,ok := SST.GetNoteArrows(&sst,CONTAINS,SEQUENCE,LINK)
+
+	if !ok {
+		os.Exit(-1)
+	}
+
+	var out *bufio.Writer
+
+	if !UPLOAD {
+		out = OpenOutput()
+	}
+
+	for _,filename := range args {
+
+		data,err := os.ReadFile(filename)
+
+		if err != nil {
+			fmt.Println("Unable to read",filename,err)
+			os.Exit(-1)
+		}
+
+		title := CHAPTER
+
+		if title == "" {
+			title = strings.TrimSuffix(filepath.Base(filename),filepath.Ext(filename))
+		}
+
+		chapters := SST.ParseNotes(string(data),Format(filename),title,arrows)
+
+		if UPLOAD {
+			UploadNotes(&sst,chapters,filename)
+		} else {
+			fmt.Fprintf(out,"\n# converted from %s by notes2N4L\n",filepath.Base(filename))
+			out.WriteString(SST.NotesToN4L(&sst,chapters))
+			out.WriteString("\n")
+		}
+	}
+
+	if out != nil {
+		out.Flush()
+	}
+
+	SST.Close(sst)
+}
+
+//**************************************************************
+
+func UploadNotes(sst *SST.PoSST,chapters []SST.NoteChapter,filename string) {
+
+	if len(chapters) == 0 {
+		fmt.Println("Nothing to upload in",filename)
+		return
+	}
+
+	up := SST.NewTableUpload(sst,chapters[0].Chapter)
+
+	for _,chapter := range chapters {
+		up.Chapter = chapter.Chapter
+		SST.UploadTableLinks(sst,&up,chapter.Links)
+	}
+
+	SST.FlushTableUpload(sst,&up)
+	sst.STORE.Finalize(SST.DBContext(sst),sst)
+
+	fmt.Println("Uploaded",filename,"as",up.NewNodes,"new nodes and",up.Links,"links in",len(chapters),"chapters")
+}
+
+//**************************************************************
+
+func Format(filename string) string {
+
+	if FORMAT != "" {
+		return FORMAT
+	}
+
+	if strings.ToLower(filepath.Ext(filename)) == ".org" {
+		return SST.NOTES_ORG
+	}
+
+	return SST.NOTES_MARKDOWN
+}
+
+//**************************************************************
+
+func OpenOutput() *bufio.Writer {
+
+	if OUTPUT == "" {
+		return bufio.NewWriter(os.Stdout)
+	}
+
+	file,err := os.Create(OUTPUT)
+
+	if err != nil {
+		fmt.Println("Unable to write",OUTPUT,err)
+		os.Exit(-1)
+	}
+
+	return bufio.NewWriter(file)
+}
+
+//**************************************************************
+
+func Usage() {
+
+	fmt.Printf("usage: notes2N4L [-chapter name] [-f markdown|org] [-o file.n4l | -u] notes.md ...\n\n")
+	fmt.Println("Top level headings start chapters and (contain) the headings, paragraphs and")
+	fmt.Println("list items below them, list items are joined by (then), and links by (see)")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+//**************************************************************
+
+func Init() []string {
+
+	flag.Usage = Usage
+
+	chapterPtr := flag.String("chapter","","chapter for text before the first heading, unless the notes have a title (default file name)")
+	formatPtr := flag.String("f","","input format: markdown or org (default by file extension)")
+	outputPtr := flag.String("o","","write N4L to this file instead of stdout")
+	uploadPtr := flag.Bool("u",false,"upload directly instead of writing N4L")
+	containsPtr := flag.String("contains","contain","arrow from a heading to what is under it")
+	sequencePtr := flag.String("sequence","then","arrow from one list item to the next")
+	linkPtr := flag.String("link","see","arrow from text to what its links point to")
+
+	flag.Parse()
+
+	CHAPTER = *chapterPtr
+	FORMAT = strings.ToLower(*formatPtr)
+	OUTPUT = *outputPtr
+	UPLOAD = *uploadPtr
+	CONTAINS = *containsPtr
+	SEQUENCE = *sequencePtr
+	LINK = *linkPtr
+
+	if FORMAT == "md" {
+		FORMAT = SST.NOTES_MARKDOWN
+	}
+
+	if FORMAT != "" && FORMAT != SST.NOTES_MARKDOWN && FORMAT != SST.NOTES_ORG {
+		Usage()
+	}
+
+	if len(flag.Args()) < 1 {
+		Usage()
+	}
+
+	return flag.Args()
+}
+
+//
+// notes2N4L.go
+//
@@ -25,6 +25,7 @@ var (
 	VERBOSE bool
 	FWD     string
 	BWD     string
+	WEIGHTED int
 )
 
 //******************************************************************
@@ -44,7 +45,7 @@ func main() {
 
 func Usage() {
 
-	fmt.Printf("usage: PathSolve [-v] -begin <string> -end <string> [-chapter string] subject [context]\n")
+	fmt.Printf("usage: PathSolve [-v] [-weighted k] -begin <string> -end <string> [-chapter string] subject [context]\n")
 	flag.PrintDefaults()
 	os.Exit(0)
 }
@@ -60,6 +61,7 @@ func Init() []string {
 	beginPtr := flag.String("begin", "", "a string match start/begin set")
 	endPtr := flag.String("end", "", "a string to match final end set")
 	dirPtr := flag.Bool("bwd", false, "reverse search direction")
+	weightedPtr := flag.Int("weighted", 0, "rank the k least total link weight paths instead")
 
 	flag.Parse()
 	args := flag.Args()
@@ -69,6 +71,7 @@ func Init() []string {
 	}
 
 	CHAPTER = ""
+	WEIGHTED = *weightedPtr
 
 	if *dirPtr {
 		FWD = "bwd"
@@ -142,7 +145,14 @@ func PathSolve(sst SST.PoSST, chapter,cntext,begin, end string) {
 
 	fmt.Printf("\n\n Paths < end_set= {%s} | {%s} = start set>\n\n",ShowNode(&sst,rightptrs),ShowNode(&sst,leftptrs))
 
-	solutions := SST.GetPathsAndSymmetries(&sst,leftptrs,rightptrs,chapter,context,arrowptrs,sttype,mindepth,maxdepth)
+	var solutions [][]SST.Link
+	var weights []float32
+
+	if WEIGHTED > 0 {
+		solutions,weights = SST.GetWeightedPaths(&sst,leftptrs,rightptrs,chapter,context,arrowptrs,sttype,WEIGHTED,maxdepth)
+	} else {
+		solutions = SST.GetPathsAndSymmetries(&sst,leftptrs,rightptrs,chapter,context,arrowptrs,sttype,mindepth,maxdepth)
+	}
 
 	// Find the path matrix
 
@@ -152,6 +162,9 @@ func PathSolve(sst SST.PoSST, chapter,cntext,begin, end string) {
 
 		for s := 0; s < len(solutions); s++ {
 			prefix := fmt.Sprintf(" - story path: ")
+			if weights != nil {
+				prefix = fmt.Sprintf(" - weight %.2f path: ",weights[s])
+			}
 			SST.PrintLinkPath(&sst,solutions,s,prefix,"",nil)
 			betweenness = TallyPath(&sst,solutions[s],betweenness)
 		}
@@ -81,18 +81,24 @@ func Usage() {
 
 func DeleteChapter(sst SST.PoSST,chapter string) {
 
+	// Remember the chapter's nodes and their neighbours for the change log
+
+	before := SST.SnapshotNeighbourhood(&sst,sst.STORE.GetNodePtrsByChapter(SST.DBContext(&sst),&sst,chapter)...)
+
 	escaped := SST.SQLEscape(chapter)
 	qstr := fmt.Sprintf("select DeleteChapter('%s')",escaped)
 
-	row,err := sst.DB.Query(qstr)
+	row,err := sst.DB.QueryContext(SST.DBContext(&sst),qstr)
 
 	if err != nil {
 		fmt.Println("Error running deletechapter function:",qstr,err)
 		return
-	} else {
-		fmt.Println("Deleted",chapter)
 	}
 
 	row.Close()
 
+	rev := SST.LogChangesSince(&sst,before)
+
+	fmt.Println("Deleted",chapter,"in revision",rev)
+
 }
@@ -12,6 +12,8 @@ import (
 	"sort"
 	"flag"
 	"strings"
+	"time"
+	"context"
 
 	SST "github.com/markburgess/SSTorytime/pkg/SSTorytime"
 )
@@ -19,6 +21,7 @@ import (
 //******************************************************************
 
 var VERBOSE bool = false
+var TIMEOUT time.Duration
 
 var TESTS = []string{
 	"range rover out of its depth",
@@ -71,12 +74,19 @@ func main() {
 	load_arrows := false
 	sst := SST.Open(load_arrows)
 
+	if TIMEOUT > 0 {
+		ctx,cancel := context.WithTimeout(context.Background(),TIMEOUT)
+		defer cancel()
+		sst = SST.WithContext(sst,ctx)
+	}
+
 	var search SST.SearchParameters
 
 	search_string := ""
 
 	for a := 0; a < len(args); a++ {
-		if strings.Contains(args[a]," ") {
+		// a (grouped expression) is not a phrase
+		if strings.Contains(args[a]," ") && !strings.HasPrefix(args[a],"(") {
 			search_string += fmt.Sprintf("\"%s\"",args[a]) + " "
 		} else {
 			search_string += args[a] + " "
@@ -87,9 +97,45 @@ func main() {
 	search_string = SST.CheckHelpQuery(search_string)
 	search_string = SST.CheckConceptQuery(search_string)
 
-	search = SST.DecodeSearchField(search_string)
+	start := time.Now()
+
+	search,err := SST.ParseSearchField(search_string)
+
+	if err != nil {
+		fmt.Println(err)
+		SST.Close(sst)
+		os.Exit(-1)
+	}
+
+	for _,warning := range search.Warnings {
+		fmt.Println("WARNING:",warning)
+	}
+
+	if search.Explain {
+		explain := SST.NewSearchExplanation(search_string,search,start)
+		sst = SST.WithExplanation(sst,explain)
+		SST.ExplainTime(&sst,"decode",start)
+	}
+
+	// Search a copy of the graph as it was, if asked
+
+	searched := sst
+
+	if search.AsOf != "" {
+
+		rewinding := time.Now()
+		searched,err = SST.WithRevision(sst,search.AsOf)
+
+		if err != nil {
+			fmt.Println(err)
+			SST.Close(sst)
+			os.Exit(-1)
+		}
+
+		SST.ExplainTime(&searched,"asof",rewinding)
+	}
 
-	Search(sst,search,search_string)
+	Search(searched,search,search_string)
 	SST.Close(sst)
 	return
 }
@@ -129,6 +175,9 @@ func Usage() {
 	fmt.Println("searchN4L a1 to b6 arrows then")
 	fmt.Println("searchN4L paths a2 to b5 distance 10")
 	fmt.Println("searchN4L <b5|a2> distance 10")
+	fmt.Println("searchN4L \\chapter interference \\export gexf > interference.gexf")
+	fmt.Println("searchN4L brain \\asof 2025-06-01")
+	fmt.Println("searchN4L brain \\changes since 2025-06-01")
 
 	flag.PrintDefaults()
 	os.Exit(0)
@@ -140,12 +189,15 @@ func GetArgs() []string {
 
 	flag.Usage = Usage
 	verbosePtr := flag.Bool("v", false,"verbose")
+	timeoutPtr := flag.Duration("timeout",0,"give up searching after this long, e.g. 30s (default no limit)")
 	flag.Parse()
 
 	if *verbosePtr {
 		VERBOSE = true
 	}
 
+	TIMEOUT = *timeoutPtr
+
 	return flag.Args()
 }
 
@@ -172,6 +224,16 @@ func Search(sst SST.PoSST, search SST.SearchParameters,line string) {
 
 	minlimit,maxlimit := SST.MinMaxPolicy(search)
 
+	SST.ExplainLimits(&sst,minlimit,maxlimit)
+	SST.ExplainArrows(&sst,arrowptrs,sttype)
+
+	if sst.EXPLAIN != nil {
+		defer func() {
+			SST.ExplainDone(&sst)
+			fmt.Print(SST.FormatExplanation(&sst,sst.EXPLAIN))
+		}()
+	}
+
 	if VERBOSE {
 		fmt.Println("Your starting expression generated this set: ",line,"\n")
 		fmt.Println(" -         start set:",SL(search.Name))
@@ -189,8 +251,19 @@ func Search(sst SST.PoSST, search SST.SearchParameters,line string) {
 	}
 
 
+	// Hand the selection over to another graph tool instead
+
+	if search.Export != "" {
+		SST.ExplainHandler(&sst,SST.EXPLAIN_EXPORT)
+		graph := SST.GetSearchGraph(&sst,search)
+		fmt.Print(SST.FormatGraph(&sst,graph,search.Export))
+		return
+	}
+
 	var nodeptrs,leftptrs,rightptrs []SST.NodePtr
 
+	resolving := time.Now()
+
 	if (from || to) && !pagenr && !sequence {
 		leftptrs = SST.SolveNodePtrs(sst,search.From,search,arrowptrs,maxlimit)
 		rightptrs = SST.SolveNodePtrs(sst,search.To,search,arrowptrs,maxlimit)
@@ -198,26 +271,52 @@ func Search(sst SST.PoSST, search SST.SearchParameters,line string) {
 
 	nodeptrs = SST.SolveNodePtrs(sst,search.Name,search,arrowptrs,maxlimit)
 
+	SST.ExplainTime(&sst,"resolve",resolving)
+	SST.ExplainNodePtrs(&sst,nodeptrs,leftptrs,rightptrs)
+
 	// SEARCH SELECTION *********************************************
 
 	fmt.Println()
 	fmt.Println("------------------------------------------------------------------")
 	fmt.Println(" Limiting to maximum of",maxlimit,"results")
 
+	// The history of the graph, or of the nodes found
+
+	if search.Changes != "" {
+
+		SST.ExplainHandler(&sst,SST.EXPLAIN_CHANGES)
+		ShowChanges(sst,search,nodeptrs)
+		ShowTime(sst,search)
+		return
+	}
+
 	// Table of contents
 
 	if (context || chapter) && !name && !sequence && !pagenr && !(from || to) {
 
+		SST.ExplainHandler(&sst,SST.EXPLAIN_CONTENTS)
 		ShowMatchingChapter(sst,search.Chapter,search.Context,maxlimit)
 		ShowTime(sst,search)
 		return
 	}
 
+	// Where the nodes came from, instead of their orbits
+
+	if name && search.Provenance {
+
+		fmt.Println("------------------------------------------------------------------")
+		SST.ExplainHandler(&sst,SST.EXPLAIN_PROVENANCE)
+		ShowProvenance(sst,nodeptrs,maxlimit)
+		ShowTime(sst,search)
+		return
+	}
+
 	// if we have name, (maybe with context, chapter, arrows)
 
 	if name && ! sequence && !pagenr {
 
 		fmt.Println("------------------------------------------------------------------")
+		SST.ExplainHandler(&sst,SST.EXPLAIN_ORBIT)
 		FindOrbits(sst, nodeptrs, maxlimit)
 		ShowTime(sst,search)
 		return
@@ -234,7 +333,8 @@ func Search(sst SST.PoSST, search SST.SearchParameters,line string) {
 	if from && to {
 
 		fmt.Println("------------------------------------------------------------------")
-		PathSolve(sst,leftptrs,rightptrs,search.Chapter,search.Context,arrowptrs,sttype,minlimit,maxlimit)
+		SST.ExplainHandler(&sst,SST.EXPLAIN_PATH)
+		PathSolve(sst,leftptrs,rightptrs,search.Chapter,search.Context,arrowptrs,sttype,minlimit,maxlimit,search.Weighted)
 		ShowTime(sst,search)
 		return
 	}
@@ -247,18 +347,21 @@ func Search(sst SST.PoSST, search SST.SearchParameters,line string) {
 
 		if nodeptrs != nil {
 			fmt.Println("------------------------------------------------------------------")
+			SST.ExplainHandler(&sst,SST.EXPLAIN_CONE)
 			CausalCones(sst,nodeptrs,search.Chapter,search.Context,arrowptrs,sttype,maxlimit)
 			ShowTime(sst,search)
 			return
 		}
 		if leftptrs != nil {
 			fmt.Println("------------------------------------------------------------------")
+			SST.ExplainHandler(&sst,SST.EXPLAIN_CONE)
 			CausalCones(sst,leftptrs,search.Chapter,search.Context,arrowptrs,sttype,maxlimit)
 			ShowTime(sst,search)
 			return
 		}
 		if rightptrs != nil {
 			fmt.Println("------------------------------------------------------------------")
+			SST.ExplainHandler(&sst,SST.EXPLAIN_CONE)
 			CausalCones(sst,rightptrs,search.Chapter,search.Context,arrowptrs,sttype,maxlimit)
 			ShowTime(sst,search)
 			return
@@ -271,6 +374,8 @@ func Search(sst SST.PoSST, search SST.SearchParameters,line string) {
 
 		var notes []SST.PageMap
 
+		SST.ExplainHandler(&sst,SST.EXPLAIN_PAGEMAP)
+
 		if !(name || chapter) {
 			search.Chapter = "%%"
 			chapter = true
@@ -294,6 +399,7 @@ func Search(sst SST.PoSST, search SST.SearchParameters,line string) {
 	// Look for axial trails following a particular arrow, like _sequence_
 
 	if sequence {
+		SST.ExplainHandler(&sst,SST.EXPLAIN_STORIES)
 		ShowStories(sst,nodeptrs,arrowptrs,sttype,maxlimit)
 		ShowTime(sst,search)
 		return
@@ -302,6 +408,7 @@ func Search(sst SST.PoSST, search SST.SearchParameters,line string) {
 	// if we have sequence with arrows, then we are looking for sequence context or stories
 
 	if arrows || sttypes {
+		SST.ExplainHandler(&sst,SST.EXPLAIN_ARROWS)
 		ShowMatchingArrows(sst,arrowptrs,sttype)
 		ShowTime(sst,search)
 		return
@@ -355,6 +462,54 @@ func FindOrbits(sst SST.PoSST, nptrs []SST.NodePtr, limit int) {
 
 //******************************************************************
 
+func ShowProvenance(sst SST.PoSST, nptrs []SST.NodePtr, limit int) {
+
+	for n,nptr := range nptrs {
+
+		if n >= limit {
+			return
+		}
+
+		node := SST.GetDBNodeByNodePtr(&sst,nptr)
+		fmt.Printf("\n%d: \"%s\" (%d,%d) in %s\n",n,node.S,nptr.Class,nptr.CPtr,node.Chap)
+
+		records := SST.GetDBProvenance(&sst,nptr)
+
+		if len(records) == 0 {
+			fmt.Println("     no record of where this came from")
+		}
+
+		for _,p := range records {
+			fmt.Println("    ",SST.FormatProvenance(&sst,p))
+		}
+	}
+}
+
+//******************************************************************
+
+func ShowChanges(sst SST.PoSST, search SST.SearchParameters, nptrs []SST.NodePtr) {
+
+	changes,err := SST.GetDBChangesSince(&sst,search.Changes)
+
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if search.Name != nil {
+		changes = SST.FilterChanges(changes,nptrs)
+	}
+
+	if len(changes) == 0 {
+		fmt.Println("\nNo changes since",search.Changes)
+		return
+	}
+
+	fmt.Print(SST.FormatChanges(&sst,changes))
+}
+
+//******************************************************************
+
 func CausalCones(sst SST.PoSST,nptrs []SST.NodePtr, chap string, context []string,arrows []SST.ArrowPtr, sttype []int,limit int) {
 
 	var total int = 1
@@ -401,7 +556,7 @@ func CausalCones(sst SST.PoSST,nptrs []SST.NodePtr, chap string, context []strin
 
 //******************************************************************
 
-func PathSolve(sst SST.PoSST,leftptrs,rightptrs []SST.NodePtr,chapter string,context []string,arrowptrs []SST.ArrowPtr,sttype []int,mindepth,maxdepth int) {
+func PathSolve(sst SST.PoSST,leftptrs,rightptrs []SST.NodePtr,chapter string,context []string,arrowptrs []SST.ArrowPtr,sttype []int,mindepth,maxdepth,weighted int) {
 	var count int
 
 	if leftptrs == nil || rightptrs == nil {
@@ -414,6 +569,18 @@ func PathSolve(sst SST.PoSST,leftptrs,rightptrs []SST.NodePtr,chapter string,con
 		fmt.Println("Solver/handler: PathSolve()")
 	}
 
+	// Rank by the total link weight instead of path length
+
+	if weighted > 0 {
+		paths,costs := SST.GetWeightedPaths(&sst,leftptrs,rightptrs,chapter,context,arrowptrs,sttype,weighted,maxdepth)
+
+		for p := range paths {
+			prefix := fmt.Sprintf(" - weight %.2f path: ",costs[p])
+			SST.PrintLinkPath(&sst,paths,p,prefix,chapter,context)
+		}
+		return
+	}
+
 	solutions := SST.GetPathsAndSymmetries(&sst,leftptrs,rightptrs,chapter,context,arrowptrs,sttype,mindepth,maxdepth)
 
 	if len(solutions) > 0 {
@@ -688,7 +855,7 @@ func ShowNotes(sst SST.PoSST,notes []SST.PageMap) {
 
 	for n := 0; n < len(notes); n++ {
 
-		txtctx := sst.CONTEXT_DIRECTORY[notes[n].Context].Context
+		txtctx := SST.GetContext(&sst,notes[n].Context)
 
 		if last != notes[n].Chapter || lastc != txtctx {
 
@@ -24,9 +24,12 @@ import (
 	"time"
 	"flag"
 	"errors"	
+	"strconv"
 	"crypto/md5"
+	"sync"
 
 	SST "github.com/markburgess/SSTorytime/pkg/SSTorytime"
+	N4L "github.com/markburgess/SSTorytime/pkg/n4l"
 )
 
 // *********************************************************************
@@ -42,6 +45,12 @@ var httpAddr string
 var httpsAddr string
 var certFile string
 var keyFile string
+var searchTimeout time.Duration
+var pool SST.PoolConfig
+
+// One database session for all requests, see Start()
+
+var SESSION *SST.SharedSession
 
 // *********************************************************************
 // Main
@@ -65,6 +74,9 @@ func Init() string {
 	httpsPtr := flag.String("https", ":8443", "HTTPS listen address")
 	certPtr := flag.String("cert", "../server/cert.pem", "TLS certificate PEM path")
 	keyPtr := flag.String("key", "../server/key.pem", "TLS private key PEM path")
+	timeoutPtr := flag.Duration("timeout", 2*time.Minute, "Abandon a search that takes longer than this (0 for no limit)")
+	maxconnsPtr := flag.Int("maxconns", SST.DefaultPool().MaxOpen, "Most database connections open at once, for concurrent searches")
+	idleconnsPtr := flag.Int("idleconns", SST.DefaultPool().MaxIdle, "Database connections kept open between searches")
 
 	flag.Parse()
 
@@ -75,6 +87,11 @@ func Init() string {
 	httpsAddr = *httpsPtr
 	certFile = *certPtr
 	keyFile = *keyPtr
+	searchTimeout = *timeoutPtr
+
+	pool = SST.DefaultPool()
+	pool.MaxOpen = *maxconnsPtr
+	pool.MaxIdle = *idleconnsPtr
 
 	return *resourcePtr
 }
@@ -86,7 +103,7 @@ func Usage() {
         // We assume that the server is run from the directory under which
 	// it will store all cached files. The resources directory is extra read-only
 
-	fmt.Printf("usage: http_server [-resources string] [-http addr] [-https addr] [-cert file] [-key file]\n")
+	fmt.Printf("usage: http_server [-resources string] [-http addr] [-https addr] [-cert file] [-key file] [-timeout duration] [-maxconns n] [-idleconns n]\n")
 	flag.PrintDefaults()
 	os.Exit(0)
 }
@@ -103,6 +120,16 @@ func Start(resources string) {
 		log.Fatal("failed to create sub-filesystem:", err)
 	}
 
+	// Open the database once, and share it between requests
+
+	SESSION, err = SST.OpenSharedErr(context.Background(),true,pool)
+
+	if err != nil {
+		log.Fatal("Unable to open the database: ", err)
+	}
+
+	defer SESSION.Close()
+
 
 	// 2. Create a router (ServeMux) and register various handlers.
 
@@ -117,7 +144,12 @@ func Start(resources string) {
 	mux.Handle("/Assets/", http.StripPrefix("/Assets/cacheroot", fileserver3))
 	mux.HandleFunc("/searchN4L", SearchN4LHandler)
 	mux.HandleFunc("/Upload", UploadHandler)
+	mux.HandleFunc("/UploadN4L", UploadN4LHandler)
 	mux.HandleFunc("/SearchAssets", AssetsHandler)
+	mux.HandleFunc("/DeleteNode", DeleteNodeHandler)
+	mux.HandleFunc("/DeleteLink", DeleteLinkHandler)
+	mux.HandleFunc("/EditLink", EditLinkHandler)
+	mux.HandleFunc("/RenameNode", RenameNodeHandler)
 
 	fmt.Println("\n***********************************************\n")
 	fmt.Println(" *  File serving resources, set to: ",resources)
@@ -217,7 +249,17 @@ func EnableCORS(next http.Handler) http.Handler {
 
 func SearchN4LHandler(w http.ResponseWriter, r *http.Request) {
 
-	sst := SST.Open(true)
+	// Stop querying if the browser goes away or the search takes too long
+
+	ctx := r.Context()
+
+	if searchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx,cancel = context.WithTimeout(ctx,searchTimeout)
+		defer cancel()
+	}
+
+	sst := SESSION.Request(ctx)
 
 	switch r.Method {
 
@@ -242,15 +284,47 @@ func SearchN4LHandler(w http.ResponseWriter, r *http.Request) {
 
 		fmt.Println("\nReceived command:", name)
 
-		search := SST.DecodeSearchField(name)
+		start := time.Now()
+
+		search,err := SST.ParseSearchField(name)
+
+		if err != nil {
+			http.Error(w,err.Error(),http.StatusBadRequest)
+			return
+		}
+
+		for _,warning := range search.Warnings {
+			fmt.Println("WARNING:",warning)
+		}
+
+		if search.Explain {
+			explain := SST.NewSearchExplanation(name,search,start)
+			sst = SST.WithExplanation(sst,explain)
+			SST.ExplainTime(&sst,"decode",start)
+		}
+
+		if search.AsOf != "" {
+
+			rewinding := time.Now()
+			sst,err = SST.WithRevision(sst,search.AsOf)
+
+			if err != nil {
+				http.Error(w,err.Error(),http.StatusBadRequest)
+				return
+			}
+
+			SST.ExplainTime(&sst,"asof",rewinding)
+		}
 
 		HandleSearch(sst,search, name, w, r)
 
+		if errors.Is(ctx.Err(),context.DeadlineExceeded) {
+			fmt.Println("Search abandoned after",searchTimeout,":",name)
+		}
+
 	default:
 		http.Error(w, "Not supported", http.StatusMethodNotAllowed)
 	}
-	
-	SST.Close(sst)
 }
 
 // *********************************************************************
@@ -426,6 +500,136 @@ func UploadInline(w http.ResponseWriter, r *http.Request) {
 
 // *********************************************************************
 
+type N4LReport struct {
+
+	Chapters []string
+	Messages []N4L.Diagnostic // errors and warnings, with their lines
+}
+
+const ERR_NO_N4L SST.SSTError = "No N4L notes in the request, send filedata or text"
+
+var UPLOADING sync.Mutex // one N4L upload or edit at a time, see SharedSession.Uploader()
+
+// *********************************************************************
+
+func UploadN4LHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Compile notes, as N4L -u does, and store them unless there are
+	// errors, or only check them if asked
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.ParseMultipartForm(32 << 20)
+
+	name,text,err := FormN4L(r)
+
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		data,_ := json.Marshal(err.Error())
+		response := fmt.Sprintf("{ \"Response\" : \"Failed\",\n \"Content\" : %s }",data)
+		w.Write([]byte(response))
+		return
+	}
+
+	check := r.FormValue("check") == "true"
+	force := r.FormValue("force") == "true"
+
+	// Parsing needs no database, so any number can run at once
+
+	parser := N4L.NewParser()
+	parser.SILENT = true
+	parser.ParseReader(name,strings.NewReader(text))
+	graph,diagnostics := parser.Finish()
+
+	var report N4LReport
+
+	report.Messages = diagnostics
+	report.Chapters = N4L.GetMemChapters(graph.SST)
+
+	kind := "Uploaded"
+	status := http.StatusOK
+
+	switch {
+
+	case N4L.Failed(report.Messages):
+		kind = "Failed"
+		status = http.StatusBadRequest
+
+	case check:
+		kind = "Checked"
+
+	default:
+		UPLOADING.Lock()
+		defer UPLOADING.Unlock()
+
+		// An upload should not stop part way because the client went away
+
+		sst := SESSION.Uploader(context.WithoutCancel(r.Context()))
+
+		err = N4L.Upload(sst,graph,force)
+
+		SESSION.Changed()
+
+		if err != nil {
+			var d N4L.Diagnostic
+
+			if !errors.As(err,&d) {
+				d.File = name
+				d.Severity = N4L.SEVERITY_ERROR
+				d.Message = err.Error()
+			}
+
+			report.Messages = append(report.Messages,d)
+
+			kind = "Failed"
+			status = http.StatusConflict
+		}
+	}
+
+	fmt.Println("N4L upload of",name,kind,report.Chapters)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	data,_ := json.Marshal(report)
+	response := fmt.Sprintf("{ \"Response\" : \"%s\",\n \"Content\" : %s }",kind,data)
+	w.Write([]byte(response))
+}
+
+// *********************************************************************
+
+func FormN4L(r *http.Request) (string,string,error) {
+
+	// The notes as an uploaded file, or as text with a name
+
+	file,header,err := r.FormFile("filedata")
+
+	if err == nil {
+		defer file.Close()
+		data,err := io.ReadAll(file)
+		return header.Filename,string(data),err
+	}
+
+	text := r.FormValue("text")
+
+	if strings.TrimSpace(text) == "" {
+		return "","",ERR_NO_N4L
+	}
+
+	name := r.FormValue("name")
+
+	if name == "" {
+		name = "upload.n4l"
+	}
+
+	return name,text,nil
+}
+
+// *********************************************************************
+
 func AssetsHandler(w http.ResponseWriter, r *http.Request) {
 
 	name := r.FormValue("name")
@@ -442,6 +646,234 @@ func AssetsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(response))
 }
 
+// *********************************************************************
+// Corrections to single nodes and links
+// *********************************************************************
+
+func DeleteNodeHandler(w http.ResponseWriter, r *http.Request) {
+
+	EditHandler(w,r,func(sst *SST.PoSST) (string,error) {
+
+		nptr,err := FormNodePtr(r,"node")
+
+		if err != nil {
+			return "",err
+		}
+
+		return fmt.Sprintf("deleted node (%d,%d)",nptr.Class,nptr.CPtr),SST.DeleteNode(sst,nptr)
+	})
+}
+
+// *********************************************************************
+
+func DeleteLinkHandler(w http.ResponseWriter, r *http.Request) {
+
+	EditHandler(w,r,func(sst *SST.PoSST) (string,error) {
+
+		from,arrow,to,err := FormLink(r)
+
+		if err != nil {
+			return "",err
+		}
+
+		return fmt.Sprintf("deleted link %s -(%s)-> %s",r.FormValue("from"),arrow,r.FormValue("to")),SST.DeleteLink(sst,from,arrow,to)
+	})
+}
+
+// *********************************************************************
+
+func EditLinkHandler(w http.ResponseWriter, r *http.Request) {
+
+	// Either or both of weight and context
+
+	EditHandler(w,r,func(sst *SST.PoSST) (string,error) {
+
+		from,arrow,to,err := FormLink(r)
+
+		if err != nil {
+			return "",err
+		}
+
+		weight := r.FormValue("weight")
+		_,context := r.Form["context"]
+
+		if weight == "" && !context {
+			return "",fmt.Errorf("%w: expected a weight or a context to change",ERR_BAD_EDIT_FORM)
+		}
+
+		// Both changes as one edit, so neither is made without the other
+
+		var wgt float64
+		var ctxptr SST.ContextPtr
+
+		if weight != "" {
+			wgt,err = strconv.ParseFloat(weight,32)
+
+			if err != nil {
+				return "",fmt.Errorf("%w: weight %s is not a number",ERR_BAD_EDIT_FORM,weight)
+			}
+
+			if wgt == 0 {
+				return "",SST.ERR_ZERO_WEIGHT
+			}
+		}
+
+		if context {
+			var ctx []string
+
+			for _,c := range strings.Split(r.FormValue("context"),",") {
+				if c = strings.TrimSpace(c); c != "" {
+					ctx = append(ctx,c)
+				}
+			}
+
+			ctxptr = SST.TryContext(sst,ctx)
+		}
+
+		err = SST.EditLink(sst,from,arrow,to,func(lnk SST.Link) (SST.Link,bool) {
+
+			if weight != "" {
+				lnk.Wgt = float32(wgt)
+			}
+
+			if context {
+				lnk.Ctx = ctxptr
+			}
+
+			return lnk,true
+		})
+
+		if err != nil {
+			return "",err
+		}
+
+		return fmt.Sprintf("edited link %s -(%s)-> %s",r.FormValue("from"),arrow,r.FormValue("to")),nil
+	})
+}
+
+// *********************************************************************
+
+func RenameNodeHandler(w http.ResponseWriter, r *http.Request) {
+
+	EditHandler(w,r,func(sst *SST.PoSST) (string,error) {
+
+		nptr,err := FormNodePtr(r,"node")
+
+		if err != nil {
+			return "",err
+		}
+
+		name := strings.TrimSpace(r.FormValue("name"))
+
+		return fmt.Sprintf("renamed node (%d,%d) to %s",nptr.Class,nptr.CPtr,name),SST.RenameNode(sst,nptr,name)
+	})
+}
+
+// *********************************************************************
+
+const ERR_BAD_EDIT_FORM SST.SSTError = "Malformed edit request"
+
+// *********************************************************************
+
+func EditHandler(w http.ResponseWriter, r *http.Request, edit func(sst *SST.PoSST) (string,error)) {
+
+	// Common part of the edit endpoints: POST only, and a JSON reply
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.ParseForm()
+
+	// An upload allocates nodes from its own copy of the directories,
+	// so edits wait for it, as another upload would
+
+	UPLOADING.Lock()
+	defer UPLOADING.Unlock()
+
+	sst := SESSION.Writer(r.Context())
+
+	done,err := edit(&sst)
+
+	// Even a failed edit may have added a context, or part of a change
+
+	SESSION.Changed()
+
+	if err != nil {
+		fmt.Println("Edit failed:",err)
+
+		status := http.StatusInternalServerError
+
+		switch {
+		case errors.Is(err,SST.ERR_NO_SUCH_NODE), errors.Is(err,SST.ERR_NO_SUCH_LINK):
+			status = http.StatusNotFound
+		case errors.Is(err,ERR_BAD_EDIT_FORM), errors.Is(err,SST.ERR_NO_SUCH_ARROW),
+			errors.Is(err,SST.ERR_NODE_NAME), errors.Is(err,SST.ERR_NODE_CLASS), errors.Is(err,SST.ERR_ZERO_WEIGHT):
+			status = http.StatusBadRequest
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		data,_ := json.Marshal(err.Error())
+		response := fmt.Sprintf("{ \"Response\" : \"Failed\",\n \"Content\" : %s }",data)
+		w.Write([]byte(response))
+		return
+	}
+
+	fmt.Println("Edit:",done)
+
+	w.Header().Set("Content-Type", "application/json")
+	data,_ := json.Marshal(done)
+	response := fmt.Sprintf("{ \"Response\" : \"Edited\",\n \"Content\" : %s }",data)
+	w.Write([]byte(response))
+}
+
+// *********************************************************************
+
+func FormNodePtr(r *http.Request, field string) (SST.NodePtr,error) {
+
+	// Nodes are given as (class,cptr), as in a search
+
+	var nptr SST.NodePtr
+
+	value := strings.TrimSpace(r.FormValue(field))
+	n,_ := fmt.Sscanf(value,"(%d,%d)",&nptr.Class,&nptr.CPtr)
+
+	if n != 2 {
+		return nptr,fmt.Errorf("%w: %s should be a node pointer like (1,2), not \"%s\"",ERR_BAD_EDIT_FORM,field,value)
+	}
+
+	return nptr,nil
+}
+
+// *********************************************************************
+
+func FormLink(r *http.Request) (SST.NodePtr,string,SST.NodePtr,error) {
+
+	var to SST.NodePtr
+
+	from,err := FormNodePtr(r,"from")
+
+	if err != nil {
+		return from,"",to,err
+	}
+
+	to,err = FormNodePtr(r,"to")
+
+	if err != nil {
+		return from,"",to,err
+	}
+
+	arrow := strings.TrimSpace(r.FormValue("arrow"))
+
+	if arrow == "" {
+		return from,"",to,fmt.Errorf("%w: missing arrow",ERR_BAD_EDIT_FORM)
+	}
+
+	return from,arrow,to,nil
+}
+
 // *********************************************************************
 
 func UpdateLastSawSection(sst SST.PoSST,w http.ResponseWriter, r *http.Request, query string) {
@@ -503,6 +935,9 @@ func HandleSearch(sst SST.PoSST,search SST.SearchParameters, line string, w http
 
 	minlimit,maxlimit := SST.MinMaxPolicy(search)
 
+	SST.ExplainLimits(&sst,minlimit,maxlimit)
+	SST.ExplainArrows(&sst,arrowptrs,sttype)
+
 	fmt.Println()
 	fmt.Println("        start set:", SL(search.Name))
 	fmt.Println("          finding:", SL(search.Finds))
@@ -522,10 +957,19 @@ func HandleSearch(sst SST.PoSST,search SST.SearchParameters, line string, w http
 	var nodeptrs, leftptrs, rightptrs []SST.NodePtr
 
 	if (search.Bookmarks) {
+		SST.ExplainHandler(&sst,SST.EXPLAIN_BOOKMARKS)
 		HandleBookmarks(w,r,sst,search)
 		return
 	}
-	
+
+	if search.Export != "" {
+		SST.ExplainHandler(&sst,SST.EXPLAIN_EXPORT)
+		HandleGraphExport(w,r,sst,search)
+		return
+	}
+
+	resolving := time.Now()
+
 	if (from || to) && !pagenr && !sequence {
 		leftptrs = SST.SolveNodePtrs(sst, search.From, search, arrowptrs, maxlimit)
 		rightptrs = SST.SolveNodePtrs(sst, search.To, search, arrowptrs, maxlimit)
@@ -541,41 +985,56 @@ func HandleSearch(sst SST.PoSST,search SST.SearchParameters, line string, w http
 		nodeptrs = SST.SolveNodePtrs(sst, search.Name, search, arrowptrs, maxlimit)
 	}
 	
+	SST.ExplainTime(&sst,"resolve",resolving)
+	SST.ExplainNodePtrs(&sst,nodeptrs,leftptrs,rightptrs)
+
 	fmt.Println("Solved search nodes ... for ",search.Name)
 
 	// SEARCH SELECTION *********************************************
 
+	if search.Changes != "" {
+		SST.ExplainHandler(&sst,SST.EXPLAIN_CHANGES)
+		HandleChanges(w,r,sst,search,nodeptrs)
+		return
+	}
+
 	// Table of contents
 
 	if search.Stats {
+		SST.ExplainHandler(&sst,SST.EXPLAIN_STATS)
 		ShowStats(w,r,sst,search,nodeptrs)
 		return
 	}
 
 	if search.Finds != nil {
+		SST.ExplainHandler(&sst,SST.EXPLAIN_OVERVIEW)
 		ShowOverview(w,r,sst,search,nodeptrs,maxlimit)
 		return
 	}
 	
 	if (context || chapter) && !name && !sequence && !pagenr && !(from || to) {
+		SST.ExplainHandler(&sst,SST.EXPLAIN_CONTENTS)
 		ShowChapterContexts(w,r,sst,search,maxlimit)
 		return
 	}
 
 	if name && !sequence && !pagenr {
+		SST.ExplainHandler(&sst,SST.EXPLAIN_ORBIT)
 		HandleOrbit(w,r,sst,search,nodeptrs,maxlimit)
 		return
 	}
 
 	if (name && from) || (name && to) {
 		fmt.Printf("\nSearch \"%s\" has conflicting parts <to|from> and match strings\n", line)
-		os.Exit(-1)
+		http.Error(w, "Search has conflicting parts <to|from> and match strings", http.StatusBadRequest)
+		return
 	}
 
 	// Closed path solving, two sets of nodeptrs
 	// if we have BOTH from/to (maybe with chapter/context) then we are looking for paths
 
 	if from && to {
+		SST.ExplainHandler(&sst,SST.EXPLAIN_PATH)
 		HandlePathSolve(w,r,sst,leftptrs,rightptrs,search,arrowptrs,sttype,minlimit,maxlimit)
 		return
 	}
@@ -585,14 +1044,17 @@ func HandleSearch(sst SST.PoSST,search SST.SearchParameters, line string, w http
 	if (name || from || to) && !pagenr && !sequence {
 
 		if nodeptrs != nil {
+			SST.ExplainHandler(&sst,SST.EXPLAIN_CONE)
 			HandleCausalCones(w,r,sst,nodeptrs,search,arrowptrs,sttype,maxlimit)
 			return
 		}
 		if leftptrs != nil {
+			SST.ExplainHandler(&sst,SST.EXPLAIN_CONE)
 			HandleCausalCones(w,r,sst,leftptrs,search,arrowptrs,sttype,maxlimit)
 			return
 		}
 		if rightptrs != nil {
+			SST.ExplainHandler(&sst,SST.EXPLAIN_CONE)
 			HandleCausalCones(w,r,sst,rightptrs,search,arrowptrs,sttype,maxlimit)
 			return
 		}
@@ -604,6 +1066,8 @@ func HandleSearch(sst SST.PoSST,search SST.SearchParameters, line string, w http
 
 		var notes []SST.PageMap
 
+		SST.ExplainHandler(&sst,SST.EXPLAIN_PAGEMAP)
+
 		if !(name || chapter) {
 			search.Chapter = "%%"
 			chapter = true
@@ -625,6 +1089,7 @@ func HandleSearch(sst SST.PoSST,search SST.SearchParameters, line string, w http
 	// Look for axial trails following a particular arrow, like _sequence_
 
 	if sequence {
+		SST.ExplainHandler(&sst,SST.EXPLAIN_STORIES)
 		HandleStories(w,r,sst,search,nodeptrs,arrowptrs,sttype,maxlimit)
 		return
 	}
@@ -632,6 +1097,7 @@ func HandleSearch(sst SST.PoSST,search SST.SearchParameters, line string, w http
 	// if we have sequence with arrows, then we are looking for sequence context or stories
 
 	if arrows || sttypes {
+		SST.ExplainHandler(&sst,SST.EXPLAIN_ARROWS)
 		HandleMatchingArrows(w,r,sst,search,arrowptrs,sttype)
 		return
 	}
@@ -664,6 +1130,56 @@ func HandleBookmarks(w http.ResponseWriter, r *http.Request, sst SST.PoSST, sear
 
 // *********************************************************************
 
+func HandleChanges(w http.ResponseWriter, r *http.Request, sst SST.PoSST, search SST.SearchParameters, nptrs []SST.NodePtr) {
+
+	changes,err := SST.GetDBChangesSince(&sst,search.Changes)
+
+	if err != nil {
+		http.Error(w,err.Error(),http.StatusBadRequest)
+		return
+	}
+
+	if search.Name != nil {
+		changes = SST.FilterChanges(changes,nptrs)
+	}
+
+	data, _ := json.Marshal(changes)
+	response := PackageResponse(sst,search,"Changes",string(data))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+	fmt.Println("Reply Changes sent",len(changes))
+}
+
+// *********************************************************************
+
+func HandleGraphExport(w http.ResponseWriter, r *http.Request, sst SST.PoSST, search SST.SearchParameters) {
+
+	// Not the usual JSON: this is a file for Gephi, yEd, Graphviz or an RDF store
+
+	graph := SST.GetSearchGraph(&sst,search)
+	data := SST.FormatGraph(&sst,graph,search.Export)
+
+	switch search.Export {
+	case SST.GRAPH_FORMAT_DOT:
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+	case SST.GRAPH_FORMAT_TURTLE:
+		w.Header().Set("Content-Type", "text/turtle")
+	case SST.GRAPH_FORMAT_TRIG:
+		w.Header().Set("Content-Type", "application/trig")
+	case SST.GRAPH_FORMAT_JSONLD:
+		w.Header().Set("Content-Type", "application/ld+json")
+	default:
+		w.Header().Set("Content-Type", "application/xml")
+	}
+
+	w.Header().Set("Content-Disposition", "attachment; filename=\"sstorytime."+search.Export+"\"")
+	w.Write([]byte(data))
+	fmt.Println("Reply graph export sent",len(graph.Nodes),"nodes",len(graph.Edges),"edges")
+}
+
+// *********************************************************************
+
 func HandleOrbit(w http.ResponseWriter, r *http.Request, sst SST.PoSST, search SST.SearchParameters, nptrs []SST.NodePtr, limit int) {
 
 	var count int
@@ -779,7 +1295,14 @@ func HandlePathSolve(w http.ResponseWriter, r *http.Request, sst SST.PoSST, left
 
 	fmt.Println("HandlePathSolve(", leftptrs, ",", rightptrs, ")")
 
-	solutions := SST.GetPathsAndSymmetries(&sst,leftptrs,rightptrs,chapter,context,arrowptrs,sttype,mindepth,maxdepth)
+	var solutions [][]SST.Link
+	var weights []float32
+
+	if search.Weighted > 0 {
+		solutions,weights = SST.GetWeightedPaths(&sst,leftptrs,rightptrs,chapter,context,arrowptrs,sttype,search.Weighted,maxdepth)
+	} else {
+		solutions = SST.GetPathsAndSymmetries(&sst,leftptrs,rightptrs,chapter,context,arrowptrs,sttype,mindepth,maxdepth)
+	}
 
 	if len(solutions) > 0 {
 		// format paths
@@ -791,6 +1314,11 @@ func HandlePathSolve(w http.ResponseWriter, r *http.Request, sst SST.PoSST, left
 		soln.Title = fmt.Sprintf("paths solutions from %v to %v",search.From,search.To)
 		soln.BTWC = SST.BetweenNessCentrality(sst, solutions)
 		soln.SuperNodes = SST.SuperNodes(sst, solutions, maxdepth)
+		soln.Weights = weights
+
+		if weights != nil {
+			soln.Title = fmt.Sprintf("least weight paths from %v to %v",search.From,search.To)
+		}
 
 		var wpaths [][]SST.WebPath
 		nth := 0
@@ -1236,6 +1764,15 @@ func PackageResponse(sst SST.PoSST, search SST.SearchParameters, kind string, js
 	intent, _ := json.Marshal(now_ctx)
 	ambient, _ := json.Marshal(ambien)
 
+	// A search with \explain says how it got the Content
+
+	if sst.EXPLAIN != nil {
+		SST.ExplainDone(&sst)
+		explain, _ := json.Marshal(sst.EXPLAIN)
+		response := fmt.Sprintf("{ \"Response\" : \"%s\",\n \"Content\" : %s,\n \"Time\" : \"%s\", \"Intent\" : %s, \"Ambient\" : %s,\n \"Explain\" : %s }", kind, jstr, key, intent, ambient, explain)
+		return []byte(response)
+	}
+
 	response := fmt.Sprintf("{ \"Response\" : \"%s\",\n \"Content\" : %s,\n \"Time\" : \"%s\", \"Intent\" : %s, \"Ambient\" : %s }", kind, jstr, key, intent, ambient)
 
 	return []byte(response)
@@ -409,6 +409,7 @@ header.appendChild(ctxbar);
 let b_add = document.createElement("span");
 nowbar.appendChild(b_add);
 BookMarkButton(b_add);
+N4LUploadButton(b_add);
 }
 
 /***********************************************************/
@@ -2002,6 +2003,110 @@ button2.onclick = function uploadbutton()
 
 /***********************************************************/
 
+function N4LUploadButton(container)
+{
+let button = document.createElement("button");
+button.textContent = "N4L";
+button.className = "n4l-shortcut";
+button.title = "Add notes from an N4L file";
+container.appendChild(button);
+
+// Hidden file chooser
+
+let select = document.createElement('input');
+select.type = "file";
+select.hidden = true;
+container.appendChild(select);
+
+select.addEventListener('change', (event) => {
+
+let localfile = event.target.files[0];
+
+if (localfile)
+   {
+   SendN4L(localfile);
+   }
+
+select.value = "";
+});
+
+button.addEventListener("click", () => {
+  select.click();
+});
+}
+
+/***********************************************************/
+
+function SendN4L(localfile)
+{
+let formData = new FormData();
+formData.append("filedata",localfile);
+
+startHipnotize();
+
+fetch("/UploadN4L", { method: POST_METHOD, body: formData })
+
+.then((response) =>
+   {
+   return response.json();
+   })
+
+.then((obj) =>
+   {
+   stopHipnotize();
+   DisplayN4LReport(obj);
+   })
+
+.catch((error) =>
+   {
+   stopHipnotize();
+   DisplayError("upload failed " + error);
+   });
+}
+
+/***********************************************************/
+
+function DisplayN4LReport(obj)
+{
+// Errors and warnings from compiling the notes, by line
+
+const main = document.querySelector("main");
+main.innerHTML = "";
+
+let heading = document.createElement("h5");
+main.appendChild(heading);
+
+if (typeof obj.Content === "string")
+   {
+   heading.textContent = obj.Content;
+   return;
+   }
+
+let chapters = obj.Content.Chapters || [];
+let messages = obj.Content.Messages || [];
+
+if (obj.Response == "Uploaded")
+   {
+   heading.textContent = "Uploaded " + chapters.join(", ");
+   }
+else
+   {
+   heading.textContent = "Not uploaded, please correct the notes";
+   }
+
+let list = document.createElement("ul");
+main.appendChild(list);
+
+for (let m of messages)
+   {
+   let item = document.createElement("li");
+   item.textContent = m.File + " line " + m.Line + " (" + m.Severity + "): " + m.Message;
+   list.appendChild(item);
+   }
+}
+
+/***********************************************************/
+
 function Upload(container, text, chap, context)
 {
 let button1 = document.createElement("button");
@@ -75,7 +75,12 @@ func RipFile2File(filename string,percentage float64){
 	}
 
 	fmt.Println("Fractionating file...",filename)
-	psf,L := SST.FractionateTextFile(filename)
+	psf,L,err := SST.FractionateTextFileErr(filename)
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
 
 	fmt.Println("Analyzing longitudinal patterns")
 	ranking1 := SelectByRunningIntent(psf,L,percentage)
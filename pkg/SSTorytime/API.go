@@ -7,8 +7,8 @@
 package SSTorytime
 
 import (
+	"context"
 	"fmt"
-	"os"
 	_ "github.com/lib/pq"
 
 )
@@ -23,37 +23,80 @@ func Vertex(sst *PoSST,name,chap string) Node {
 
 	n.S = name
 	n.Chap = chap
+	n.L,n.NPtr.Class = StorageClass(name)
 
-	return IdempDBAddNode(sst,n)
+	// A stored node is not changed, and a new one is logged by the store
+	// as it is added
+
+	if stored := sst.STORE.GetNodePtrsByName(DBContext(sst),sst,name); len(stored) > 0 {
+		n.NPtr = stored[0]
+	} else {
+		n = IdempDBAddNode(sst,n)
+	}
+
+	RecordDBProvenance(sst,n.NPtr,NODE_PROVENANCE,NO_NODE_PTR)
+	return n
 }
 
 // **************************************************************************
 
 func Edge(sst *PoSST,from Node,arrow string,to Node,context []string,weight float32) (ArrowPtr,int) {
 
-	arrowptr,sttype := GetDBArrowsWithArrowName(sst,arrow)
+	arrowptr,sttype,err := EdgeErr(DBContext(sst),sst,from,arrow,to,context,weight)
+	ExitOnError(err)
+
+	return arrowptr,sttype
+}
+
+// **************************************************************************
+
+func EdgeErr(ctx context.Context,sst *PoSST,from Node,arrow string,to Node,context []string,weight float32) (ArrowPtr,int,error) {
+
+	// The queries are abandoned if ctx is done
+
+	arrowptr,sttype,err := GetDBArrowsWithArrowNameErr(ctx,sst,arrow)
+
+	if err != nil {
+		return 0,0,err
+	}
 
 	var link Link
 
 	link.Arr = arrowptr
 	link.Dst = to.NPtr
 	link.Wgt = weight
-	link.Ctx = TryContext(sst,context)
+	link.Ctx = TryContextWith(ctx,sst,context)
 
-	IdempDBAddLink(sst,from,link,to)
+	err = IdempDBAddLinks(ctx,sst,[]Node{from},[]Link{link},[]Node{to})
 
-	return arrowptr,sttype
+	if err == nil {
+		RecordDBProvenanceWith(ctx,sst,from.NPtr,arrowptr,to.NPtr)
+	}
+
+	return arrowptr,sttype,err
 }
 
 // **************************************************************************
 
 func HubJoin(sst *PoSST,name,chap string,nptrs []NodePtr,arrow string,context []string,weight []float32) Node {
 
-	// Create a container node joining several other nodes in a list, like a hyperlink
+	hub,err := HubJoinErr(DBContext(sst),sst,name,chap,nptrs,arrow,context,weight)
+	ExitOnError(err)
+
+	return hub
+}
+
+// **************************************************************************
+
+func HubJoinErr(ctx context.Context,sst *PoSST,name,chap string,nptrs []NodePtr,arrow string,context []string,weight []float32) (Node,error) {
+
+	// Create a container node joining several other nodes in a list, like a hyperlink.
+	// The queries are abandoned if ctx is done
+
+	var hub Node
 
 	if nptrs == nil {
-		fmt.Println("Call to HubJoin with a null list of pointers")
-		os.Exit(-1)
+		return hub,ERR_HUB_NO_NODES
 	}
 
 	if weight == nil {
@@ -63,8 +106,13 @@ func HubJoin(sst *PoSST,name,chap string,nptrs []NodePtr,arrow string,context []
 	}
 
 	if len(nptrs) != len(weight) {
-		fmt.Println("Call to HubJoin with inconsistent node/weight pointer arrays: dimensions ",len(nptrs),"vs",len(weight))
-		os.Exit(-1)
+		return hub,fmt.Errorf("%w: dimensions %d vs %d",ERR_HUB_WEIGHTS,len(nptrs),len(weight))
+	}
+
+	arrowptr,_,err := GetDBArrowsWithArrowNameErr(ctx,sst,arrow)
+
+	if err != nil {
+		return hub,err
 	}
 
 	var chaps = make(map[string]int)
@@ -73,7 +121,7 @@ func HubJoin(sst *PoSST,name,chap string,nptrs []NodePtr,arrow string,context []
 		name = "hub_"+arrow+"_"
 		for n := range nptrs {
 			name += fmt.Sprintf("(%d,%d)",nptrs[n].Class,nptrs[n].CPtr)
-			node := GetDBNodeByNodePtr(sst,nptrs[n])
+			node := GetDBNodeByNodePtrWith(ctx,sst,nptrs[n])
 			chaps[node.Chap]++
 		}
 	}
@@ -90,9 +138,15 @@ func HubJoin(sst *PoSST,name,chap string,nptrs []NodePtr,arrow string,context []
 		}
 	}
 
-	container := IdempDBAddNode(sst,to)
+	// The store logs the hub as it adds it, then all the links go
+	// together as the next revision, or none of them
 
-	arrowptr,_ := GetDBArrowsWithArrowName(sst,arrow)
+	container := sst.STORE.IdempAddNode(ctx,sst,to)
+
+	RecordDBProvenanceWith(ctx,sst,container.NPtr,NODE_PROVENANCE,NO_NODE_PTR)
+
+	var from,hubs []Node
+	var links []Link
 
 	for nptr := range nptrs {
 
@@ -100,14 +154,185 @@ func HubJoin(sst *PoSST,name,chap string,nptrs []NodePtr,arrow string,context []
 		link.Arr = arrowptr
 		link.Dst = container.NPtr
 		link.Wgt = weight[nptr]
-		link.Ctx = TryContext(sst,context)
-		from := GetDBNodeByNodePtr(sst,nptrs[nptr])
-		IdempDBAddLink(sst,from,link,container)
+		link.Ctx = TryContextWith(ctx,sst,context)
+
+		from = append(from,GetDBNodeByNodePtrWith(ctx,sst,nptrs[nptr]))
+		hubs = append(hubs,container)
+		links = append(links,link)
 	}
 
-	return GetDBNodeByNodePtr(sst,container.NPtr)
+	if err := IdempDBAddLinks(ctx,sst,from,links,hubs); err != nil {
+		return hub,err
+	}
+
+	for _,n := range from {
+		RecordDBProvenanceWith(ctx,sst,n.NPtr,arrowptr,container.NPtr)
+	}
+
+	return GetDBNodeByNodePtrWith(ctx,sst,container.NPtr),nil
+}
+
+// **************************************************************************
+// Corrections, see db_editing.go
+// **************************************************************************
+
+func DeleteLink(sst *PoSST,from NodePtr,arrow string,to NodePtr) error {
+
+	// Removes the link in any context, together with its inverse on the other node
+
+	return EditLink(sst,from,arrow,to,func(lnk Link) (Link,bool) {
+		return lnk,false
+	})
+}
+
+// **************************************************************************
+
+func SetLinkWeight(sst *PoSST,from NodePtr,arrow string,to NodePtr,weight float32) error {
+
+	if weight == 0 {
+		return ERR_ZERO_WEIGHT
+	}
+
+	return EditLink(sst,from,arrow,to,func(lnk Link) (Link,bool) {
+		lnk.Wgt = weight
+		return lnk,true
+	})
+}
+
+// **************************************************************************
+
+func SetLinkContext(sst *PoSST,from NodePtr,arrow string,to NodePtr,context []string) error {
+
+	ctxptr := TryContext(sst,context)
+
+	return EditLink(sst,from,arrow,to,func(lnk Link) (Link,bool) {
+		lnk.Ctx = ctxptr
+		return lnk,true
+	})
 }
 
+// **************************************************************************
+
+func EditLink(sst *PoSST,from NodePtr,arrow string,to NodePtr,edit func(Link) (Link,bool)) error {
+
+	// Any change to a link and its inverse, e.g. a new weight and context
+	// together, as one edit. If edit returns false, the link goes
+
+	arrowptr,_,err := GetDBArrowsWithArrowNameErr(DBContext(sst),sst,arrow)
+
+	if err != nil {
+		return err
+	}
+
+	return EditDBLinks(sst,from,arrowptr,to,edit)
+}
+
+// **************************************************************************
+
+func RenameNode(sst *PoSST,nptr NodePtr,name string) error {
+
+	// Changes the text but keeps the NodePtr, so links to it are unaffected.
+	// The class is part of the NodePtr, so the new name must be of the same class
+
+	if name == "" {
+		return ERR_NODE_NAME
+	}
+
+	if _,class := StorageClass(name); class != nptr.Class {
+		return fmt.Errorf("%w: \"%s\" is class %d, (%d,%d) is class %d",ERR_NODE_CLASS,name,class,nptr.Class,nptr.CPtr,nptr.Class)
+	}
+
+	for _,other := range sst.STORE.GetNodePtrsByName(DBContext(sst),sst,name) {
+		if other != nptr {
+			return fmt.Errorf("%w: \"%s\" is (%d,%d)",ERR_NODE_NAME,name,other.Class,other.CPtr)
+		}
+	}
+
+	var node Node
+	var edits NodeEdits
+
+	edits.Plan = func(read LockedRead,edits *NodeEdits) error {
+
+		before,err := LockedSnapshot(read,nptr)
+
+		if err != nil {
+			return err
+		}
+
+		node = before[nptr]
+
+		if node.S == "" {
+			return fmt.Errorf("%w: (%d,%d)",ERR_NO_SUCH_NODE,nptr.Class,nptr.CPtr)
+		}
+
+		after := before.Copy()
+
+		renamed := after[nptr]
+		renamed.S = name
+		after[nptr] = renamed
+
+		*edits = LoggedEditsBetween(sst,before,after)
+		return nil
+	}
+
+	if err := sst.STORE.EditNodes(DBContext(sst),sst,&edits); err != nil {
+		return err
+	}
+
+	RenameDirectoryNode(sst,node,name)
+	return nil
+}
+
+// **************************************************************************
+
+func DeleteNode(sst *PoSST,nptr NodePtr) error {
+
+	// Removes the node and the links that other nodes have to it
+
+	var node Node
+	var edits NodeEdits
+
+	edits.Plan = func(read LockedRead,edits *NodeEdits) error {
+
+		// Every link is stored in both directions, so the node's own links
+		// name all the nodes that point back to it
+
+		before,err := LockedNeighbourhood(read,nptr)
+
+		if err != nil {
+			return err
+		}
+
+		node = before[nptr]
+
+		if node.S == "" {
+			return fmt.Errorf("%w: (%d,%d)",ERR_NO_SUCH_NODE,nptr.Class,nptr.CPtr)
+		}
+
+		after := before.Copy()
+
+		for nbr := range LinkedNodes(node) {
+			n := after[nbr]
+			RemoveLinksTo(&n,nptr)
+			after[nbr] = n
+		}
+
+		after[nptr] = Node{NPtr: nptr}
+
+		*edits = LoggedEditsBetween(sst,before,after)
+		return nil
+	}
+
+	// The node and all the links to it go together, or not at all
+
+	if err := sst.STORE.EditNodes(DBContext(sst),sst,&edits); err != nil {
+		return err
+	}
+
+	UncacheNodes(sst,edits.Touched()...)
+	ForgetNode(sst,node)
+	return nil
+}
 
 
 
@@ -8,8 +8,8 @@
 package SSTorytime
 
 import (
+	"context"
 	"fmt"
-	"os"
 	"strings"
 	_ "github.com/lib/pq"
 
@@ -19,11 +19,31 @@ import (
 
 func AppendTextToDirectory(sst *PoSST,event Node,ErrFunc func(string)) NodePtr {
 
+	node_alloc_ptr,err := AppendTextToDirectoryErr(DBContext(sst),sst,event)
+
+	if err != nil {
+		ErrFunc(err.Error())
+	}
+
+	return node_alloc_ptr
+}
+
+//**************************************************************
+
+func AppendTextToDirectoryErr(ctx context.Context,sst *PoSST,event Node) (NodePtr,error) {
+
 	var cnode_slot ClassedNodePtr = -1
 	var ok bool = false
 	var node_alloc_ptr NodePtr = NO_NODE_PTR
+	var err error
+
+	// Only the check for an existing node goes to the database
 
-	cnode_slot,ok = CheckExisting(sst,event)
+	cnode_slot,ok,err = CheckExistingWith(ctx,sst,event)
+
+	if err != nil {
+		return NO_NODE_PTR,err
+	}
 
 	node_alloc_ptr.Class = event.NPtr.Class
 
@@ -31,7 +51,7 @@ func AppendTextToDirectory(sst *PoSST,event Node,ErrFunc func(string)) NodePtr {
 		// This node already exists
 		node_alloc_ptr.CPtr = cnode_slot
 		IdempAddChapterSeqToNode(sst,node_alloc_ptr.Class,node_alloc_ptr.CPtr,event.Chap,event.Seq)
-		return node_alloc_ptr
+		return node_alloc_ptr,nil
 	}
 
 	switch event.NPtr.Class {
@@ -75,16 +95,25 @@ func AppendTextToDirectory(sst *PoSST,event Node,ErrFunc func(string)) NodePtr {
 		event.NPtr = node_alloc_ptr
 		sst.NODE_DIRECTORY.GT1024 = append(sst.NODE_DIRECTORY.GT1024,event)
 		sst.NODE_DIRECTORY.GT1024_top++
+	default:
+		return NO_NODE_PTR,fmt.Errorf("%w: %d",ERR_NO_SUCH_NODE_CLASS,event.NPtr.Class)
 	}
 
 	event.NPtr = node_alloc_ptr
 	
-	return node_alloc_ptr
+	return node_alloc_ptr,nil
+}
+
+//**************************************************************
+
+func CheckExisting(sst *PoSST,event Node) (ClassedNodePtr,bool,error) {
+
+	return CheckExistingWith(DBContext(sst),sst,event)
 }
 
 //**************************************************************
 
-func CheckExisting(sst *PoSST,event Node) (ClassedNodePtr,bool) {
+func CheckExistingWith(ctx context.Context,sst *PoSST,event Node) (ClassedNodePtr,bool,error) {
 
 	var cnode_slot ClassedNodePtr = -1
 	var ok bool = false
@@ -107,17 +136,15 @@ func CheckExisting(sst *PoSST,event Node) (ClassedNodePtr,bool) {
 
 	// If we're not resetting everything, need to check for existing nodes
 	
-	if !WIPE_DB {
-		db_exists := GetDBNodePtrByName(*sst,event.S)
+	if !sst.WIPE && !sst.SYNC {
+		db_exists := sst.STORE.GetNodePtrsByName(ctx,sst,event.S)
 
 		if db_exists != nil {
-			
-			fmt.Println("Matches for",event.S,"already exist in the database. You need to reload all overlapping segments together.")
-			os.Exit(-1)
+			return cnode_slot,ok,fmt.Errorf("%w: %s",ERR_NODE_EXISTS,event.S)
 		}
 	}
 	
-	return cnode_slot,ok
+	return cnode_slot,ok,nil
 }
 
 //**************************************************************
@@ -209,7 +236,7 @@ func NearEquiv(sst *PoSST,n1,n2 NodePtr, s1,s2 string,ErrFunc func(string)) {
 	var lnk Link
 	var context = []string{"ambiguous"}
 
-	lnk.Arr = sst.ARROW_SHORT_DIR["caps"]
+	lnk.Arr,_ = GetArrowPtrByName(sst,"caps")
 	lnk.Wgt = 1
 	lnk.Ctx = RegisterContext(sst,nil,context)
 	AppendLinkToNode(sst,n1,lnk,n2)
@@ -278,8 +305,7 @@ func UpdateSeqStatus(sst *PoSST,class int,cptr ClassedNodePtr,seq bool) Node {
 		return sst.NODE_DIRECTORY.GT1024[cptr]
 	}
 
-	fmt.Println("Non existent node class (shouldn't happen)")
-	os.Exit(-1)
+	fmt.Println(ERR_NO_SUCH_NODE_CLASS,class)
 	var dummy Node
 	return dummy
 }
@@ -290,8 +316,21 @@ func InsertArrowDirectory(sst *PoSST,stname,alias,name,pm string) ArrowPtr {
 
 	// Insert an arrow into the forward/backward indices
 
+	return InsertArrowDirectoryByIndex(sst,GetSTIndexByName(stname,pm),alias,name)
+}
+
+//**************************************************************
+
+func InsertArrowDirectoryByIndex(sst *PoSST,staindex int,alias,name string) ArrowPtr {
+
+	// As InsertArrowDirectory, for an arrow copied from another session
+
 	var newarrow ArrowDirectory
 
+	lock := DirLock(sst)
+	lock.Lock()
+	defer lock.Unlock()
+
 	// Check is already exists - harmless
 
 	prev_alias,a_exists := sst.ARROW_SHORT_DIR[alias]
@@ -320,12 +359,16 @@ func InsertArrowDirectory(sst *PoSST,stname,alias,name,pm string) ArrowPtr {
 		}
 	}
 
-	newarrow.STAindex = GetSTIndexByName(stname,pm)
+	newarrow.STAindex = staindex
 	newarrow.Long = name
 	newarrow.Short = alias
 	newarrow.Ptr = sst.ARROW_DIRECTORY_TOP
 
-	sst.ARROW_DIRECTORY = append(sst.ARROW_DIRECTORY,newarrow)
+	// A new array, so that copies of the directory handed out by
+	// GetArrowDirectory(), or held by copies of the session, never change
+
+	top := len(sst.ARROW_DIRECTORY)
+	sst.ARROW_DIRECTORY = append(sst.ARROW_DIRECTORY[:top:top],newarrow)
 	sst.ARROW_SHORT_DIR[alias] = sst.ARROW_DIRECTORY_TOP
 	sst.ARROW_LONG_DIR[name] = sst.ARROW_DIRECTORY_TOP
 	sst.ARROW_DIRECTORY_TOP++
@@ -343,6 +386,10 @@ func InsertInverseArrowDirectory(sst *PoSST,fwd,bwd ArrowPtr) {
 
 	// Lookup inverse by long name, only need this in search presentation
 
+	lock := DirLock(sst)
+	lock.Lock()
+	defer lock.Unlock()
+
 	sst.INVERSE_ARROWS[fwd] = bwd
 	sst.INVERSE_ARROWS[bwd] = fwd
 }
@@ -432,21 +479,7 @@ func MergeContextLists(sst *PoSST,one,two []string) ContextPtr {
 
 	// Register the merger of contexts
 
-	ctxptr,ok := sst.CONTEXT_DIR[ctxstr]
-
-	if ok {
-		return ctxptr
-	} else {
-		var cd ContextDirectory
-		cd.Context = ctxstr
-		cd.Ptr = sst.CONTEXT_TOP
-		sst.CONTEXT_DIRECTORY = append(sst.CONTEXT_DIRECTORY,cd)
-		sst.CONTEXT_DIR[ctxstr] = sst.CONTEXT_TOP
-		ctxptr = sst.CONTEXT_TOP
-		sst.CONTEXT_TOP++
-	}
-	
-	return ctxptr
+	return IdempContextDirectory(sst,ctxstr)
 }
 
 //**************************************************************
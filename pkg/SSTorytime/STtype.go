@@ -9,7 +9,6 @@ package SSTorytime
 
 import (
 	"fmt"
-	"os"
 	_ "github.com/lib/pq"
 
 )
@@ -79,7 +78,7 @@ func PrintSTAIndex(stindex int) string {
 
 // **************************************************************************
 
-func STTypeDBChannel(sttype int) string {
+func STTypeDBChannel(sttype int) (string,error) {
 
 	// This expects the range for sttype to be unshifted 0,+/-
 
@@ -101,11 +100,10 @@ func STTypeDBChannel(sttype int) string {
 	case -EXPRESS:
 		link_channel = I_MEXPR
 	default:
-		fmt.Println(ERR_ILLEGAL_LINK_CLASS,sttype)
-		os.Exit(-1)
+		return "",fmt.Errorf("%w: %d",ERR_ILLEGAL_LINK_CLASS,sttype)
 	}
 
-	return link_channel
+	return link_channel,nil
 }
 
 // **************************************************************************
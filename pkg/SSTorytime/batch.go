@@ -0,0 +1,573 @@
+//**************************************************************
+//
+// batch.go
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	_ "github.com/lib/pq"
+
+)
+
+//**************************************************************
+// Building a graph from a program, in bulk. Vertex() and Edge()
+// go to the database one call at a time, and a failure half
+// way leaves half a graph. A Batch collects the same calls in
+// memory, then stores them all in one transaction, or none:
+//
+//   batch := BeginBatch(sst)
+//   n1 := batch.Vertex("Mary had a little lamb",chap)
+//   n2 := batch.Vertex("Whose fleece was white as snow",chap)
+//   batch.Edge(n1,"then",n2,context,1.0)
+//   err := batch.Commit()
+//
+// New nodes get their NodePtrs straight away, counting on from
+// the top of each channel, so nobody else should add nodes
+// while a batch is open. If they do, Commit() refuses with
+// ERR_BATCH_CONFLICT and nothing is stored.
+//**************************************************************
+
+type Batch struct {
+
+	sst      *PoSST
+	nodes    []Node                 // new nodes, with their links
+	index    map[NodePtr]int        // where a new node is in nodes
+	names    map[string]NodePtr     // every name seen so far, new or stored
+	links    []BatchLink            // links for nodes already stored
+	chapters map[NodePtr]string     // stored nodes seen in other chapters
+	seen     map[BatchLink]bool
+	tops     map[int]ClassedNodePtr // channel tops when first used
+	next     map[int]ClassedNodePtr
+	contexts map[string]ContextPtr
+	sources  []Provenance           // kept if the session has a SOURCE
+	closed   bool
+}
+
+//**************************************************************
+
+type BatchLink struct {
+
+	NPtr   NodePtr
+	Link   Link
+	STtype int
+}
+
+//**************************************************************
+
+type BatchRecord struct {
+
+	Changes []Change     // one revision for the change log, stamped
+	Sources []Provenance // one record per node or link and file
+	PageMap []PageMap    // lines added to the page map
+}
+
+// **************************************************************************
+
+func BeginBatch(sst *PoSST) *Batch {
+
+	var b Batch
+
+	b.sst = sst
+	b.index = make(map[NodePtr]int)
+	b.names = make(map[string]NodePtr)
+	b.seen = make(map[BatchLink]bool)
+	b.chapters = make(map[NodePtr]string)
+	b.tops = make(map[int]ClassedNodePtr)
+	b.next = make(map[int]ClassedNodePtr)
+	b.contexts = make(map[string]ContextPtr)
+
+	return &b
+}
+
+// **************************************************************************
+
+func (b *Batch) Vertex(name,chap string) Node {
+
+	// Names are unique, as for Vertex(). Unlike Vertex(), which leaves a
+	// stored node alone, a node named again in another chapter belongs to both
+
+	var n Node
+
+	n.S = name
+	n.Chap = chap
+	n.L,n.NPtr.Class = StorageClass(name)
+
+	if b.closed {
+		fmt.Println(ERR_BATCH_CLOSED)
+		return n
+	}
+
+	if nptr,known := b.names[name]; known {
+
+		b.AddChapter(nptr,chap)
+
+		if i,isnew := b.index[nptr]; isnew {
+			return b.nodes[i]
+		}
+
+		n.NPtr = nptr
+		return n
+	}
+
+	stored := b.sst.STORE.GetNodePtrsByName(DBContext(b.sst),b.sst,name)
+
+	if len(stored) > 0 {
+		n.NPtr = stored[0]
+		b.names[name] = n.NPtr
+		b.AddChapter(n.NPtr,chap)
+		b.Source(n.NPtr,NODE_PROVENANCE,NO_NODE_PTR)
+		return n
+	}
+
+	class := n.NPtr.Class
+
+	if _,used := b.next[class]; !used {
+		b.tops[class] = b.sst.STORE.GetTopCPtr(DBContext(b.sst),b.sst,class)
+		b.next[class] = b.tops[class]
+	}
+
+	b.next[class]++
+	n.NPtr.CPtr = b.next[class]
+
+	b.index[n.NPtr] = len(b.nodes)
+	b.names[name] = n.NPtr
+	b.nodes = append(b.nodes,n)
+	b.Source(n.NPtr,NODE_PROVENANCE,NO_NODE_PTR)
+
+	return n
+}
+
+// **************************************************************************
+
+func (b *Batch) AddChapter(nptr NodePtr,chap string) {
+
+	// Add chap to the node's list, for new nodes at once, for stored
+	// nodes on Commit()
+
+	if i,isnew := b.index[nptr]; isnew {
+		b.nodes[i].Chap = MergeChapterLists(b.nodes[i].Chap,chap)
+		return
+	}
+
+	chaps,known := b.chapters[nptr]
+
+	if !known {
+		node,_ := b.sst.STORE.GetNode(DBContext(b.sst),b.sst,nptr)
+		chaps = node.Chap
+	}
+
+	if merged := MergeChapterLists(chaps,chap); merged != chaps || known {
+		b.chapters[nptr] = merged
+	}
+}
+
+// **************************************************************************
+
+func (b *Batch) Edge(from Node,arrow string,to Node,context []string,weight float32) (ArrowPtr,int,error) {
+
+	// As EdgeErr(), adding the inverse link to the other node
+
+	if b.closed {
+		return 0,0,ERR_BATCH_CLOSED
+	}
+
+	arrowptr,sttype,err := GetDBArrowsWithArrowNameErr(DBContext(b.sst),b.sst,arrow)
+
+	if err != nil {
+		return 0,0,err
+	}
+
+	if from.NPtr == to.NPtr {
+		return arrowptr,sttype,fmt.Errorf("%w: %s",ERR_SELF_LOOP,from.S)
+	}
+
+	if weight == 0 {
+		return arrowptr,sttype,ERR_ZERO_WEIGHT
+	}
+
+	var link Link
+
+	link.Arr = arrowptr
+	link.Dst = to.NPtr
+	link.Wgt = weight
+	link.Ctx = b.Context(context)
+
+	b.AppendLink(from.NPtr,link,sttype)
+
+	var invlink Link
+
+	invlink.Arr = GetInverseArrow(b.sst,arrowptr)
+	invlink.Wgt = weight
+	invlink.Ctx = link.Ctx
+	invlink.Dst = from.NPtr
+
+	b.AppendLink(to.NPtr,invlink,-sttype)
+	b.Source(from.NPtr,arrowptr,to.NPtr)
+
+	return arrowptr,sttype,nil
+}
+
+// **************************************************************************
+
+func (b *Batch) AppendLink(nptr NodePtr,lnk Link,sttype int) {
+
+	if i,isnew := b.index[nptr]; isnew {
+
+		stindex := STTypeToSTIndex(sttype)
+
+		if !LinkInList(b.nodes[i].I[stindex],lnk) {
+			b.nodes[i].I[stindex] = append(b.nodes[i].I[stindex],lnk)
+		}
+		return
+	}
+
+	bl := BatchLink{NPtr: nptr, Link: lnk, STtype: sttype}
+
+	if !b.seen[bl] {
+		b.seen[bl] = true
+		b.links = append(b.links,bl)
+	}
+}
+
+// **************************************************************************
+
+func (b *Batch) Source(nptr NodePtr,arr ArrowPtr,dst NodePtr) {
+
+	// As RecordDBProvenance(), but stored only on Commit()
+
+	if b.sst.SOURCE == nil {
+		return
+	}
+
+	p := *b.sst.SOURCE
+
+	p.NPtr = nptr
+	p.Arr = arr
+	p.Dst = dst
+
+	if p.Author == "" {
+		p.Author = ProvenanceAuthor()
+	}
+
+	b.sources = append(b.sources,p)
+}
+
+// **************************************************************************
+
+func (b *Batch) Context(context []string) ContextPtr {
+
+	// Contexts are a shared directory of labels, so they are registered
+	// at once, even if the batch is rolled back
+
+	ctxstr := CompileContextString(context)
+
+	ctxptr,known := b.contexts[ctxstr]
+
+	if !known {
+		ctxptr = TryContext(b.sst,context)
+		b.contexts[ctxstr] = ctxptr
+	}
+
+	return ctxptr
+}
+
+// **************************************************************************
+
+func (b *Batch) Counts() (int,int) {
+
+	// New nodes, and links to add to stored nodes
+
+	return len(b.nodes),len(b.links)
+}
+
+// **************************************************************************
+
+func (b *Batch) Commit() error {
+
+	if b.closed {
+		return ERR_BATCH_CLOSED
+	}
+
+	b.closed = true
+
+	var touched []NodePtr
+
+	for _,n := range b.nodes {
+		touched = append(touched,n.NPtr)
+	}
+
+	for _,bl := range b.links {
+		touched = append(touched,bl.NPtr)
+	}
+
+	for nptr := range b.chapters {
+		touched = append(touched,nptr)
+	}
+
+	// The change log and provenance go in the same transaction, so they
+	// are worked out beforehand
+
+	var record BatchRecord
+
+	record.Changes = b.Changes(SnapshotNodes(b.sst,touched...))
+	record.Sources = FirstProvenance(b.sources)
+
+	StampChanges(b.sst,record.Changes)
+
+	err := b.sst.STORE.UploadBatch(DBContext(b.sst),b.sst,b.nodes,b.links,b.chapters,b.tops,record)
+
+	if err != nil {
+		return err
+	}
+
+	for _,bl := range b.links {
+		UncacheNodes(b.sst,bl.NPtr)
+	}
+
+	for nptr := range b.chapters {
+		UncacheNodes(b.sst,nptr)
+	}
+
+	return nil
+}
+
+// **************************************************************************
+
+func (b *Batch) Changes(before ChangeSnapshot) []Change {
+
+	// What Commit() does to the nodes in the snapshot: the new nodes
+	// appear, the chapters change and the links are appended, as
+	// UploadBatch() does it
+
+	after := make(map[NodePtr]Node)
+
+	for nptr,n := range before {
+		after[nptr] = n
+	}
+
+	for _,n := range b.nodes {
+		after[n.NPtr] = n
+	}
+
+	for nptr,chaps := range b.chapters {
+
+		if n,stored := after[nptr]; stored {
+			n.Chap = chaps
+			after[nptr] = n
+		}
+	}
+
+	for _,bl := range b.links {
+
+		n := after[bl.NPtr]
+
+		if n.S == "" || bl.NPtr == bl.Link.Dst {
+			continue
+		}
+
+		stindex := STTypeToSTIndex(bl.STtype)
+
+		if !LinkInList(n.I[stindex],bl.Link) {
+			var links []Link
+			links = append(links,n.I[stindex]...)
+			n.I[stindex] = append(links,bl.Link)
+			after[bl.NPtr] = n
+		}
+	}
+
+	return ChangesBetween(before,after)
+}
+
+// **************************************************************************
+
+func (b *Batch) Rollback() {
+
+	// Forget everything since BeginBatch(), nothing has been stored
+
+	b.closed = true
+	b.nodes = nil
+	b.links = nil
+	b.chapters = nil
+	b.sources = nil
+}
+
+// **************************************************************************
+// Postgres
+// **************************************************************************
+
+func (pg PostgresStore) UploadBatch(ctx context.Context,sst *PoSST,nodes []Node,links []BatchLink,chapters map[NodePtr]string,tops map[int]ClassedNodePtr,record BatchRecord) error {
+
+	// Multi-row inserts, and the link appends and chapters in chunks, in one
+	// transaction with the change log and provenance
+
+	const chunk = 500
+
+	tx,err := sst.DB.BeginTx(ctx,nil)
+
+	if err != nil {
+		return fmt.Errorf("%w: %v",ERR_BATCH_FAILED,err)
+	}
+
+	defer tx.Rollback()
+
+	// Keep out other writers until we are done, IdempAppendNode() counts
+	// on max(CPtr) too
+
+	_,err = tx.ExecContext(ctx,"LOCK TABLE Node IN SHARE ROW EXCLUSIVE MODE")
+
+	if err != nil {
+		return fmt.Errorf("%w: %v",ERR_BATCH_FAILED,err)
+	}
+
+	for channel,top := range tops {
+
+		var now int
+
+		qstr := fmt.Sprintf("SELECT coalesce(max((NPtr).CPtr),0) FROM Node WHERE (NPtr).Chan=%d",channel)
+
+		err = tx.QueryRowContext(ctx,qstr).Scan(&now)
+
+		if err != nil {
+			return fmt.Errorf("%w: %v",ERR_BATCH_FAILED,err)
+		}
+
+		if ClassedNodePtr(now) != top {
+			return fmt.Errorf("%w: channel %d was at %d, now %d",ERR_BATCH_CONFLICT,channel,top,now)
+		}
+	}
+
+	for i := 0; i < len(nodes); i += chunk {
+
+		end := i + chunk
+
+		if end > len(nodes) {
+			end = len(nodes)
+		}
+
+		var rows []string
+
+		for _,n := range nodes[i:end] {
+			rows = append(rows,FormatSQLNodeValues(n))
+		}
+
+		qstr := "INSERT INTO Node " + NODE_INSERT_COLS + " VALUES\n" + strings.Join(rows,",\n")
+
+		_,err = tx.ExecContext(ctx,qstr)
+
+		if err != nil {
+			return fmt.Errorf("%w: %v",ERR_BATCH_FAILED,err)
+		}
+	}
+
+	var qstr string
+
+	for i,bl := range links {
+
+		qstr += AppendDBLinkToNodeCommand(sst,bl.NPtr,bl.Link,bl.STtype)
+
+		if (i+1) % chunk == 0 || i == len(links)-1 {
+
+			_,err = tx.ExecContext(ctx,qstr)
+
+			if err != nil {
+				return fmt.Errorf("%w: %v",ERR_BATCH_FAILED,err)
+			}
+
+			qstr = ""
+		}
+	}
+
+	var i int
+
+	for nptr,chaps := range chapters {
+
+		qstr += fmt.Sprintf("UPDATE Node SET Chap='%s' WHERE NPtr='(%d,%d)'::NodePtr;\n",SQLEscape(chaps),nptr.Class,nptr.CPtr)
+
+		if i++; i % chunk == 0 || i == len(chapters) {
+
+			_,err = tx.ExecContext(ctx,qstr)
+
+			if err != nil {
+				return fmt.Errorf("%w: %v",ERR_BATCH_FAILED,err)
+			}
+
+			qstr = ""
+		}
+	}
+
+	if len(record.Changes) > 0 {
+
+		var rev int64
+
+		err = tx.QueryRowContext(ctx,"SELECT nextval('ChangeRevision')").Scan(&rev)
+
+		if err != nil {
+			return fmt.Errorf("%w: %v",ERR_BATCH_FAILED,err)
+		}
+
+		for i,c := range record.Changes {
+
+			qstr += FormatSQLChange(rev,c)
+
+			if (i+1) % chunk == 0 || i == len(record.Changes)-1 {
+
+				_,err = tx.ExecContext(ctx,qstr)
+
+				if err != nil {
+					return fmt.Errorf("%w: %v",ERR_BATCH_FAILED,err)
+				}
+
+				qstr = ""
+			}
+		}
+	}
+
+	for i,line := range record.PageMap {
+
+		qstr += FormatSQLPageMap(line)
+
+		if (i+1) % chunk == 0 || i == len(record.PageMap)-1 {
+
+			_,err = tx.ExecContext(ctx,qstr)
+
+			if err != nil {
+				return fmt.Errorf("%w: %v",ERR_BATCH_FAILED,err)
+			}
+
+			qstr = ""
+		}
+	}
+
+	for i,p := range record.Sources {
+
+		qstr += FormatSQLProvenance(p)
+
+		if (i+1) % chunk == 0 || i == len(record.Sources)-1 {
+
+			_,err = tx.ExecContext(ctx,qstr)
+
+			if err != nil {
+				return fmt.Errorf("%w: %v",ERR_BATCH_FAILED,err)
+			}
+
+			qstr = ""
+		}
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return fmt.Errorf("%w: %v",ERR_BATCH_FAILED,err)
+	}
+
+	return nil
+}
+
+//
+// batch.go
+//
@@ -7,17 +7,12 @@
 package SSTorytime
 
 import (
+	"context"
 	"fmt"
-	"os"
-	"sync"
 	_ "github.com/lib/pq"
 
 )
 
-// **************************************************************************
-
-var MUTEX sync.Mutex
-
 // **************************************************************************
 //  Node registration and memory management
 // **************************************************************************
@@ -78,194 +73,308 @@ func GetMemoryNodeFromPtr(sst *PoSST,frptr NodePtr) Node {
 
 func CacheNode(sst *PoSST,n Node) {
 
+	ExitOnError(CacheNodeErr(DBContext(sst),sst,n))
+}
+
+// **************************************************************************
+
+func CacheNodeErr(ctx context.Context,sst *PoSST,n Node) error {
+
+	lock := DirLock(sst)
+	lock.Lock()
+	defer lock.Unlock()
+
 	_,already := sst.NODE_CACHE[n.NPtr]
 
 	if !already {
-		MUTEX.Lock()
-		defer MUTEX.Unlock()
-		sst.NODE_CACHE[n.NPtr] = AppendTextToDirectory(sst,n,RunErr)
+		nptr,err := AppendTextToDirectoryErr(ctx,sst,n)
+
+		if err != nil {
+			return err
+		}
+
+		sst.NODE_CACHE[n.NPtr] = nptr
 	}
+
+	return nil
+}
+
+// **************************************************************************
+
+func DownloadArrowsFromDB(sst *PoSST) error {
+
+	return DownloadArrowsFromDBWith(DBContext(sst),sst)
 }
 
 // **************************************************************************
 
-func DownloadArrowsFromDB(sst *PoSST) {
+func DownloadArrowsFromDBWith(ctx context.Context,sst *PoSST) error {
 
 	// These must be ordered to match in-memory array
 
-	qstr := fmt.Sprintf("SELECT STAindex,Long,Short,ArrPtr FROM ArrowDirectory ORDER BY ArrPtr")
+	directory,inverses,err := sst.STORE.DownloadArrows(ctx,sst)
 
-	row, err := sst.DB.Query(qstr)
-	
 	if err != nil {
-		fmt.Println("QUERY Download Arrows Failed",err)
+		return err
 	}
 
+	lock := DirLock(sst)
+	lock.Lock()
+	defer lock.Unlock()
+
 	sst.ARROW_DIRECTORY = nil
 	sst.ARROW_DIRECTORY_TOP = 0
 
-	var staidx int
-	var long string
-	var short string
-	var ptr ArrowPtr
-	var ad ArrowDirectory
+	for _,ad := range directory {
 
-	if row != nil {
-		for row.Next() {		
-			err = row.Scan(&staidx,&long,&short,&ptr)
-			ad.STAindex = staidx
-			ad.Long = long
-			ad.Short = short
-			ad.Ptr = ptr
-
-			sst.ARROW_DIRECTORY = append(sst.ARROW_DIRECTORY,ad)
-			sst.ARROW_SHORT_DIR[short] = sst.ARROW_DIRECTORY_TOP
-			sst.ARROW_LONG_DIR[long] = sst.ARROW_DIRECTORY_TOP
-
-			if ad.Ptr != sst.ARROW_DIRECTORY_TOP {
-				fmt.Println(ERR_MEMORY_DB_ARROW_MISMATCH,ad,ad.Ptr,sst.ARROW_DIRECTORY_TOP)
-				os.Exit(-1)
-			}
+		sst.ARROW_DIRECTORY = append(sst.ARROW_DIRECTORY,ad)
+		sst.ARROW_SHORT_DIR[ad.Short] = sst.ARROW_DIRECTORY_TOP
+		sst.ARROW_LONG_DIR[ad.Long] = sst.ARROW_DIRECTORY_TOP
 
-			sst.ARROW_DIRECTORY_TOP++
+		if ad.Ptr != sst.ARROW_DIRECTORY_TOP {
+			return fmt.Errorf("%w: %v at %d, expected %d",ERR_MEMORY_DB_ARROW_MISMATCH,ad,ad.Ptr,sst.ARROW_DIRECTORY_TOP)
 		}
 
-		row.Close()
+		sst.ARROW_DIRECTORY_TOP++
+	}
+
+	for plus,minus := range inverses {
+		sst.INVERSE_ARROWS[plus] = minus
+	}
+
+	return nil
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) DownloadArrows(ctx context.Context,sst *PoSST) ([]ArrowDirectory,map[ArrowPtr]ArrowPtr,error) {
+
+	// All or nothing, a partial directory would misnumber the arrows
+
+	var directory []ArrowDirectory
+	var inverses = make(map[ArrowPtr]ArrowPtr)
+
+	qstr := fmt.Sprintf("SELECT STAindex,Long,Short,ArrPtr FROM ArrowDirectory ORDER BY ArrPtr")
+
+	row, err := sst.DB.QueryContext(ctx,qstr)
+	
+	if err != nil {
+		return nil,nil,fmt.Errorf("%w: arrows: %v",ERR_DOWNLOAD_FAILED,err)
+	}
+
+	for row.Next() {		
+
+		var ad ArrowDirectory
+
+		err = row.Scan(&ad.STAindex,&ad.Long,&ad.Short,&ad.Ptr)
+
+		if err != nil {
+			row.Close()
+			return nil,nil,fmt.Errorf("%w: arrows: %v",ERR_DOWNLOAD_FAILED,err)
+		}
+
+		directory = append(directory,ad)
+	}
+
+	err = row.Err()
+	row.Close()
+
+	if err != nil {
+		return nil,nil,fmt.Errorf("%w: arrows: %v",ERR_DOWNLOAD_FAILED,err)
 	}
 
 	// Get Inverses
 
 	qstr = fmt.Sprintf("SELECT Plus,Minus FROM ArrowInverses ORDER BY Plus")
 
-	row, err = sst.DB.Query(qstr)
+	row, err = sst.DB.QueryContext(ctx,qstr)
 	
 	if err != nil {    
-		fmt.Println("QUERY Download Inverses Failed",err)
+		return nil,nil,fmt.Errorf("%w: inverses: %v",ERR_DOWNLOAD_FAILED,err)
 	}
 
 	var plus,minus ArrowPtr
 
-	if row != nil {
-		for row.Next() {		
+	for row.Next() {		
 
-			err = row.Scan(&plus,&minus)
+		err = row.Scan(&plus,&minus)
 
-			if err != nil {
-				fmt.Println("QUERY Download Arrows Failed",err)
-			}
+		if err != nil {
+			row.Close()
+			return nil,nil,fmt.Errorf("%w: inverses: %v",ERR_DOWNLOAD_FAILED,err)
+		}
+
+		inverses[plus] = minus
+	}
+
+	err = row.Err()
+	row.Close()
+
+	if err != nil {
+		return nil,nil,fmt.Errorf("%w: inverses: %v",ERR_DOWNLOAD_FAILED,err)
+	}
+
+	return directory,inverses,nil
+}
+
+// **************************************************************************
+
+func DownloadContextsFromDB(sst *PoSST) error {
+
+	return DownloadContextsFromDBWith(DBContext(sst),sst)
+}
+
+// **************************************************************************
+
+func DownloadContextsFromDBWith(ctx context.Context,sst *PoSST) error {
+
+	directory,err := sst.STORE.DownloadContexts(ctx,sst)
+
+	if err != nil {
+		return err
+	}
+
+	lock := DirLock(sst)
+	lock.Lock()
+	defer lock.Unlock()
 
-			sst.INVERSE_ARROWS[plus] = minus
+	contexts := sst.CONTEXTS
+	contexts.Directory = nil
+	contexts.Top = 0
+
+	for _,c := range directory {
+
+		if c.Ptr != contexts.Top {
+			return fmt.Errorf("%w: %v, expected %d",ERR_MEMORY_DB_CONTEXT_MISMATCH,c,contexts.Top)
 		}
-		row.Close()
+
+		contexts.Directory = append(contexts.Directory,c)
+		contexts.Index[c.Context] = contexts.Top
+		contexts.Top++
 	}
+
+	SyncContextFields(sst)
+	return nil
 }
 
 // **************************************************************************
 
-func DownloadContextsFromDB(sst *PoSST) {
+func (pg PostgresStore) DownloadContexts(ctx context.Context,sst *PoSST) ([]ContextDirectory,error) {
 
 	qstr := fmt.Sprintf("SELECT Context,CtxPtr FROM ContextDirectory ORDER BY CtxPtr")
 
-	row, err := sst.DB.Query(qstr)
+	row, err := sst.DB.QueryContext(ctx,qstr)
 	
 	if err != nil {
-		fmt.Println("QUERY Download Arrows Failed",err)
+		return nil,fmt.Errorf("%w: contexts: %v",ERR_DOWNLOAD_FAILED,err)
 	}
 
-	sst.CONTEXT_DIRECTORY = nil
-	sst.CONTEXT_TOP = 0
+	var directory []ContextDirectory
 
-	var context string
-	var ptr ContextPtr
+	for row.Next() {		
 
-	if row != nil {
-		for row.Next() {		
-			err = row.Scan(&context,&ptr)
+		var c ContextDirectory
 
-			var c ContextDirectory
+		err = row.Scan(&c.Context,&c.Ptr)
 
-			c.Context = context
-			c.Ptr = ptr
+		if err != nil {
+			row.Close()
+			return nil,fmt.Errorf("%w: contexts: %v",ERR_DOWNLOAD_FAILED,err)
+		}
 
-			if c.Ptr != sst.CONTEXT_TOP {
-				fmt.Println(ERR_MEMORY_DB_CONTEXT_MISMATCH,c,sst.CONTEXT_TOP)
-				os.Exit(-1)
-			}
+		directory = append(directory,c)
+	}
 
-			sst.CONTEXT_DIRECTORY = append(sst.CONTEXT_DIRECTORY,c)
-			sst.CONTEXT_DIR[context] = sst.CONTEXT_TOP
-			sst.CONTEXT_TOP++
-		}
+	err = row.Err()
+	row.Close()
 
-		row.Close()
+	if err != nil {
+		return nil,fmt.Errorf("%w: contexts: %v",ERR_DOWNLOAD_FAILED,err)
 	}
+
+	return directory,nil
 }
 
 // **************************************************************************
 
 func SynchronizeNPtrs(sst *PoSST) {
 
+	SynchronizeNPtrsWith(DBContext(sst),sst)
+}
+
+// **************************************************************************
+
+func SynchronizeNPtrsWith(ctx context.Context,sst *PoSST) {
+
 	// If we're merging (not recommended) N4L into an existing db, we need to synch
 
-	// GetDBTopNodes(N_CHANNELS) ?
-	
 	for channel := N1GRAM; channel <= GT1024; channel++ {
-		
-		qstr := fmt.Sprintf("SELECT max((Nptr).CPtr) FROM Node WHERE (Nptr).Chan=%d",channel)
 
-		row, err := sst.DB.Query(qstr)
-		
-		if err != nil {
-			fmt.Println("QUERY Synchronizing nptrs",err)
-		}
+		top_cptr := int(sst.STORE.GetTopCPtr(ctx,sst,channel))
 
-		var top_cptr int
+		if top_cptr > 0 {
 
-		if row != nil {
-			for row.Next() {			
-				err = row.Scan(&top_cptr)
+			var empty Node
+
+			sst.HWM[channel] = ClassedNodePtr(top_cptr)
+
+			// Make sure internal accouting points to the right nodes
 			
-				if err != nil {
-					continue // maybe not defined yet
+			for n := 0; n <= top_cptr; n++ {
+
+				switch channel {
+				case N1GRAM:
+					sst.NODE_DIRECTORY.N1_top++
+					sst.NODE_DIRECTORY.N1directory = append(sst.NODE_DIRECTORY.N1directory,empty)
+				case N2GRAM:
+					sst.NODE_DIRECTORY.N2directory = append(sst.NODE_DIRECTORY.N2directory,empty)
+					sst.NODE_DIRECTORY.N2_top++
+				case N3GRAM:
+					sst.NODE_DIRECTORY.N3directory = append(sst.NODE_DIRECTORY.N3directory,empty)
+					sst.NODE_DIRECTORY.N3_top++
+				case LT128:
+					sst.NODE_DIRECTORY.LT128directory = append(sst.NODE_DIRECTORY.LT128directory,empty)
+					sst.NODE_DIRECTORY.LT128_top++
+				case LT1024:
+					sst.NODE_DIRECTORY.LT1024 = append(sst.NODE_DIRECTORY.LT1024,empty)
+					sst.NODE_DIRECTORY.LT1024_top++
+				case GT1024:
+					sst.NODE_DIRECTORY.GT1024 = append(sst.NODE_DIRECTORY.GT1024,empty)
+					sst.NODE_DIRECTORY.GT1024_top++
 				}
+			}
+		}
+	}
+}
 
-				if top_cptr > 0 {
-
-					var empty Node
-
-					sst.HWM[channel] = ClassedNodePtr(top_cptr)
-
-					// Make sure internal accouting points to the right nodes
-					
-					for n := 0; n <= top_cptr; n++ {
-
-						switch channel {
-						case N1GRAM:
-							sst.NODE_DIRECTORY.N1_top++
-							sst.NODE_DIRECTORY.N1directory = append(sst.NODE_DIRECTORY.N1directory,empty)
-						case N2GRAM:
-							sst.NODE_DIRECTORY.N2directory = append(sst.NODE_DIRECTORY.N2directory,empty)
-							sst.NODE_DIRECTORY.N2_top++
-						case N3GRAM:
-							sst.NODE_DIRECTORY.N3directory = append(sst.NODE_DIRECTORY.N3directory,empty)
-							sst.NODE_DIRECTORY.N3_top++
-						case LT128:
-							sst.NODE_DIRECTORY.LT128directory = append(sst.NODE_DIRECTORY.LT128directory,empty)
-							sst.NODE_DIRECTORY.LT128_top++
-						case LT1024:
-							sst.NODE_DIRECTORY.LT1024 = append(sst.NODE_DIRECTORY.LT1024,empty)
-							sst.NODE_DIRECTORY.LT1024_top++
-						case GT1024:
-							sst.NODE_DIRECTORY.GT1024 = append(sst.NODE_DIRECTORY.GT1024,empty)
-							sst.NODE_DIRECTORY.GT1024_top++
-						}
-					}
-				}
+// **************************************************************************
+
+func (pg PostgresStore) GetTopCPtr(ctx context.Context,sst *PoSST,channel int) ClassedNodePtr {
+
+	// GetDBTopNodes(N_CHANNELS) ?
+
+	qstr := fmt.Sprintf("SELECT max((Nptr).CPtr) FROM Node WHERE (Nptr).Chan=%d",channel)
+
+	row, err := sst.DB.QueryContext(ctx,qstr)
+	
+	if err != nil {
+		fmt.Println("QUERY Synchronizing nptrs",err)
+	}
+
+	var top_cptr int
+
+	if row != nil {
+		for row.Next() {			
+			err = row.Scan(&top_cptr)
+			
+			if err != nil {
+				top_cptr = 0 // maybe not defined yet
 			}
-			row.Close()
 		}
+		row.Close()
 	}
 
+	return ClassedNodePtr(top_cptr)
 }
 
 
@@ -273,4 +382,3 @@ func SynchronizeNPtrs(sst *PoSST) {
 //
 // cache.go
 //
-
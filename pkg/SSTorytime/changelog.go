@@ -0,0 +1,1025 @@
+//**************************************************************
+//
+// changelog.go
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	_ "github.com/lib/pq"
+
+)
+
+//**************************************************************
+// The history of the graph. Every upload, edit or API call that
+// changes nodes or links adds one revision to an append-only
+// change log, with a Change for each node it added, renamed,
+// moved between chapters or deleted, and for each link entry it
+// added or removed (a link is stored on both its nodes, so it
+// has two entries, and a new weight or context is a removal and
+// an addition). Nothing is taken out of the log, except by -wipe.
+//
+// The graph as it was at an earlier revision is the present
+// graph with the later changes undone, see GraphAsOf(). History
+// starts when the log was created: revision 0 is the graph as
+// it was then. The page map, bookmarks and provenance are not
+// part of the history
+//**************************************************************
+
+type Change struct {
+
+	Rev    int64
+	Time   int64     // unix seconds, the same for the whole revision
+	Author string
+	Op     string    // CHANGE_*
+	NPtr   NodePtr
+	STtype int       // the link's channel, for link changes
+	Lnk    Link
+	Chap   string    // the chapter of an added or deleted node
+	Old    string    // text or chapter before
+	New    string    // and after
+}
+
+//**************************************************************
+
+const (
+	CHANGE_NODE_ADDED = "node added"
+	CHANGE_NODE_DELETED = "node deleted"
+	CHANGE_NODE_RENAMED = "node renamed"
+	CHANGE_NODE_CHAPTER = "node chapter"
+	CHANGE_LINK_ADDED = "link added"
+	CHANGE_LINK_REMOVED = "link removed"
+)
+
+//**************************************************************
+
+type ChangeSnapshot map[NodePtr]Node // nodes before a change, S="" if absent
+
+//**************************************************************
+
+type AsOfKey struct {
+
+	db     *sql.DB
+	store  Storage
+	rev    int64
+	latest int64 // the revision of the graph it was made from
+}
+
+//**************************************************************
+
+type AsOfGraph struct {
+
+	key  AsOfKey
+	past *MemoryStore
+}
+
+//**************************************************************
+
+const ASOF_CACHE_SIZE = 4 // past graphs kept by GraphAsOf()
+
+var ASOF_MAX_NODES = 200000 // largest graph GraphAsOf() will copy, 0 for any
+var ASOF_LOCK sync.Mutex  // guards ASOF_CACHE
+var ASOF_CACHE []AsOfGraph
+
+//**************************************************************
+
+const SNAPSHOT_BULK = 64 // read more nodes than this in one query
+
+// **************************************************************************
+
+func SnapshotNodes(sst *PoSST,nptrs ...NodePtr) ChangeSnapshot {
+
+	return SnapshotNodesWith(DBContext(sst),sst,nptrs...)
+}
+
+// **************************************************************************
+
+func SnapshotNodesWith(ctx context.Context,sst *PoSST,nptrs ...NodePtr) ChangeSnapshot {
+
+	// Remember nodes as they are, before changing them. A node that is
+	// about to be added is remembered as absent
+
+	before := make(ChangeSnapshot)
+	before.ReadWith(ctx,sst,nptrs...)
+
+	return before
+}
+
+// **************************************************************************
+
+func SnapshotNeighbourhood(sst *PoSST,nptrs ...NodePtr) ChangeSnapshot {
+
+	// The nodes and every node linked to them, for changes that
+	// also edit the other halves of their links
+
+	before := SnapshotNodes(sst,nptrs...)
+
+	var nbrs []NodePtr
+
+	for _,nptr := range nptrs {
+		for nbr := range LinkedNodes(before[nptr]) {
+			nbrs = append(nbrs,nbr)
+		}
+	}
+
+	before.Read(sst,nbrs...)
+	return before
+}
+
+// **************************************************************************
+
+func LockedSnapshot(read LockedRead,nptrs ...NodePtr) (ChangeSnapshot,error) {
+
+	// As SnapshotNodes(), in EditNodes()'s transaction, see NodeEdits.Plan
+
+	before := make(ChangeSnapshot)
+
+	if err := before.ReadLocked(read,nptrs...); err != nil {
+		return nil,err
+	}
+
+	return before,nil
+}
+
+// **************************************************************************
+
+func LockedNeighbourhood(read LockedRead,nptrs ...NodePtr) (ChangeSnapshot,error) {
+
+	// As SnapshotNeighbourhood(), in EditNodes()'s transaction
+
+	before,err := LockedSnapshot(read,nptrs...)
+
+	if err != nil {
+		return nil,err
+	}
+
+	var nbrs []NodePtr
+
+	for _,nptr := range nptrs {
+		for nbr := range LinkedNodes(before[nptr]) {
+			nbrs = append(nbrs,nbr)
+		}
+	}
+
+	if err = before.ReadLocked(read,nbrs...); err != nil {
+		return nil,err
+	}
+
+	return before,nil
+}
+
+// **************************************************************************
+
+func (before ChangeSnapshot) Read(sst *PoSST,nptrs ...NodePtr) {
+
+	before.ReadWith(DBContext(sst),sst,nptrs...)
+}
+
+// **************************************************************************
+
+func (before ChangeSnapshot) ReadWith(ctx context.Context,sst *PoSST,nptrs ...NodePtr) {
+
+	// The first reading counts
+
+	var unknown []NodePtr
+
+	for _,nptr := range nptrs {
+		if _,known := before[nptr]; !known {
+			unknown = append(unknown,nptr)
+		}
+	}
+
+	for nptr,n := range ReadNodes(ctx,sst,unknown) {
+		before[nptr] = n
+	}
+}
+
+// **************************************************************************
+
+func (before ChangeSnapshot) ReadLocked(read LockedRead,nptrs ...NodePtr) error {
+
+	// As ReadWith(), in EditNodes()'s transaction
+
+	var unknown []NodePtr
+
+	for _,nptr := range nptrs {
+		if _,known := before[nptr]; !known {
+			unknown = append(unknown,nptr)
+		}
+	}
+
+	nodes,err := read(unknown...)
+
+	if err != nil {
+		return err
+	}
+
+	for nptr,n := range nodes {
+		before[nptr] = n
+	}
+
+	return nil
+}
+
+// **************************************************************************
+
+func (before ChangeSnapshot) Added(nptr NodePtr) {
+
+	// A node whose NodePtr was not known until it was added, unless
+	// it turned out to be one that was already read
+
+	if _,known := before[nptr]; !known {
+		before[nptr] = Node{NPtr: nptr}
+	}
+}
+
+// **************************************************************************
+
+func (before ChangeSnapshot) Copy() map[NodePtr]Node {
+
+	// A copy to edit into the nodes after a change, with its own link columns
+
+	var after = make(map[NodePtr]Node)
+
+	for nptr,n := range before {
+
+		for stindex := 0; stindex < ST_TOP; stindex++ {
+			n.I[stindex] = append([]Link(nil),n.I[stindex]...)
+		}
+
+		after[nptr] = n
+	}
+
+	return after
+}
+
+// **************************************************************************
+
+func ReadNodes(ctx context.Context,sst *PoSST,nptrs []NodePtr) map[NodePtr]Node {
+
+	// One query per node, or one for the whole graph if there are many
+
+	var nodes = make(map[NodePtr]Node)
+
+	for _,nptr := range nptrs {
+		nodes[nptr] = Node{NPtr: nptr}
+	}
+
+	if len(nptrs) > SNAPSHOT_BULK {
+
+		for _,n := range sst.STORE.GetAllNodes(ctx,sst) {
+			if _,wanted := nodes[n.NPtr]; wanted {
+				nodes[n.NPtr] = n
+			}
+		}
+
+		return nodes
+	}
+
+	for _,nptr := range nptrs {
+		n,_ := sst.STORE.GetNode(ctx,sst,nptr)
+		n.NPtr = nptr
+		nodes[nptr] = n
+	}
+
+	return nodes
+}
+
+// **************************************************************************
+
+func ChangesSince(sst *PoSST,before ChangeSnapshot) []Change {
+
+	// Compare the snapshot with the nodes as they are now
+
+	var nptrs []NodePtr
+
+	for nptr := range before {
+		nptrs = append(nptrs,nptr)
+	}
+
+	return ChangesBetween(before,ReadNodes(DBContext(sst),sst,nptrs))
+}
+
+// **************************************************************************
+
+func ChangesBetween(before ChangeSnapshot,after map[NodePtr]Node) []Change {
+
+	// Node by node, in NodePtr order
+
+	var nptrs []NodePtr
+
+	for nptr := range before {
+		nptrs = append(nptrs,nptr)
+	}
+
+	SortNodePtrs(nptrs)
+
+	var changes []Change
+
+	for _,nptr := range nptrs {
+		changes = append(changes,NodeChanges(before[nptr],after[nptr])...)
+	}
+
+	return changes
+}
+
+// **************************************************************************
+
+func NodeChanges(before,after Node) []Change {
+
+	// What turned one version of a node into the other. A node added
+	// comes before its links, and a node deleted after them, so that
+	// they can be undone in reverse order
+
+	var changes []Change
+	var c Change
+
+	c.NPtr = before.NPtr
+
+	if after.S != "" {
+		c.NPtr = after.NPtr
+	}
+
+	if before.S == "" && after.S != "" {
+		add := c
+		add.Op = CHANGE_NODE_ADDED
+		add.New = after.S
+		add.Chap = after.Chap
+		changes = append(changes,add)
+	}
+
+	if before.S != "" && after.S != "" {
+
+		if before.S != after.S {
+			rename := c
+			rename.Op = CHANGE_NODE_RENAMED
+			rename.Old = before.S
+			rename.New = after.S
+			changes = append(changes,rename)
+		}
+
+		if before.Chap != after.Chap {
+			move := c
+			move.Op = CHANGE_NODE_CHAPTER
+			move.Old = before.Chap
+			move.New = after.Chap
+			changes = append(changes,move)
+		}
+	}
+
+	for stindex := 0; stindex < ST_TOP; stindex++ {
+
+		c.STtype = STIndexToSTType(stindex)
+
+		for _,lnk := range before.I[stindex] {
+			if !LinkInList(after.I[stindex],lnk) {
+				remove := c
+				remove.Op = CHANGE_LINK_REMOVED
+				remove.Lnk = lnk
+				changes = append(changes,remove)
+			}
+		}
+
+		for _,lnk := range after.I[stindex] {
+			if !LinkInList(before.I[stindex],lnk) {
+				add := c
+				add.Op = CHANGE_LINK_ADDED
+				add.Lnk = lnk
+				changes = append(changes,add)
+			}
+		}
+	}
+
+	if before.S != "" && after.S == "" {
+		c.STtype = 0
+		c.Op = CHANGE_NODE_DELETED
+		c.Old = before.S
+		c.Chap = before.Chap
+		changes = append(changes,c)
+	}
+
+	return changes
+}
+
+// **************************************************************************
+
+func LogChanges(sst *PoSST,changes []Change) int64 {
+
+	// Store the changes as one new revision, and return its number
+
+	if len(changes) == 0 {
+		return 0
+	}
+
+	StampChanges(sst,changes)
+	return sst.STORE.AppendChanges(DBContext(sst),sst,changes)
+}
+
+// **************************************************************************
+
+func StampChanges(sst *PoSST,changes []Change) {
+
+	// One time and author for the whole revision
+
+	now := time.Now().Unix()
+	author := ProvenanceAuthor()
+
+	if sst.SOURCE != nil && sst.SOURCE.Author != "" {
+		author = sst.SOURCE.Author
+	}
+
+	for i := range changes {
+		changes[i].Time = now
+		changes[i].Author = author
+	}
+}
+
+// **************************************************************************
+
+func LoggedEditsBetween(sst *PoSST,before ChangeSnapshot,after map[NodePtr]Node) NodeEdits {
+
+	// As NodeEditsBetween(), with the changes for EditNodes() to log
+	// in the same transaction
+
+	edits := NodeEditsBetween(before,after)
+	edits.Changes = ChangesBetween(before,after)
+
+	StampChanges(sst,edits.Changes)
+	return edits
+}
+
+// **************************************************************************
+
+func AddedNodeChanges(sst *PoSST,n Node) []Change {
+
+	// For the stores to log a node that IdempAddNode() adds
+
+	changes := NodeChanges(Node{},n)
+
+	StampChanges(sst,changes)
+	return changes
+}
+
+// **************************************************************************
+
+func LogChangesSince(sst *PoSST,before ChangeSnapshot) int64 {
+
+	// e.g. defer LogChangesSince(sst,SnapshotNodes(sst,nptr))
+
+	return LogChanges(sst,ChangesSince(sst,before))
+}
+
+// **************************************************************************
+
+func GetDBChanges(sst *PoSST,after int64) []Change {
+
+	// Every change made after revision after, oldest first
+
+	return sst.STORE.GetChanges(DBContext(sst),sst,after)
+}
+
+// **************************************************************************
+
+func GetDBChangesSince(sst *PoSST,since string) ([]Change,error) {
+
+	// The changes after a revision number, or since a date, as in \changes
+
+	rev,err := ResolveRevision(sst,since)
+
+	if err != nil {
+		return nil,err
+	}
+
+	return GetDBChanges(sst,rev),nil
+}
+
+// **************************************************************************
+
+func LatestDBRevision(sst *PoSST) int64 {
+
+	return sst.STORE.GetRevisionAt(DBContext(sst),sst,math.MaxInt64)
+}
+
+// **************************************************************************
+
+func ResolveRevision(sst *PoSST,s string) (int64,error) {
+
+	// A revision number, or the last revision made by a date and time
+
+	if rev,err := strconv.ParseInt(s,10,64); err == nil {
+
+		if rev < 0 || rev > LatestDBRevision(sst) {
+			return 0,fmt.Errorf("%w: %d",ERR_NO_SUCH_REVISION,rev)
+		}
+
+		return rev,nil
+	}
+
+	t,err := ParseChangeTime(s)
+
+	if err != nil {
+		return 0,err
+	}
+
+	return sst.STORE.GetRevisionAt(DBContext(sst),sst,t.Unix()),nil
+}
+
+// **************************************************************************
+
+func ParseChangeTime(s string) (time.Time,error) {
+
+	// A date means the start of that day, in local time. Searches are
+	// lower-cased, so put back the T and Z of RFC3339
+
+	layouts := []string{ time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02" }
+
+	s = strings.ToUpper(strings.TrimSpace(s))
+
+	for _,layout := range layouts {
+		if t,err := time.ParseInLocation(layout,s,time.Local); err == nil {
+			return t,nil
+		}
+	}
+
+	return time.Time{},fmt.Errorf("%w: \"%s\" is not a revision number or a date like 2006-01-02 or 2006-01-02T15:04",ERR_MALFORMED_QUERY,s)
+}
+
+// **************************************************************************
+
+func IsRevision(s string) bool {
+
+	if _,err := strconv.ParseInt(s,10,64); err == nil {
+		return true
+	}
+
+	_,err := ParseChangeTime(s)
+	return err == nil
+}
+
+// **************************************************************************
+
+func WithRevision(sst PoSST,revision string) (PoSST,error) {
+
+	// A copy of the session that searches the graph as it was at a
+	// revision number or date, see GraphAsOf()
+
+	rev,err := ResolveRevision(&sst,revision)
+
+	if err != nil {
+		return sst,err
+	}
+
+	return GraphAsOf(sst,rev)
+}
+
+// **************************************************************************
+
+func GraphAsOf(sst PoSST,rev int64) (PoSST,error) {
+
+	// Copy the graph into a memory store and undo every change made
+	// after revision rev. The copy is for searching only, as it is
+	// shared by later calls for the same revision until the graph
+	// changes, see ASOF_CACHE. Notes and bookmarks are as they are now.
+	// Each new copy reads the whole graph, page map and change log into
+	// memory, so graphs above ASOF_MAX_NODES are refused
+
+	latest := LatestDBRevision(&sst)
+
+	if rev < 0 || rev > latest {
+		return sst,fmt.Errorf("%w: %d",ERR_NO_SUCH_REVISION,rev)
+	}
+
+	var asof PoSST
+
+	asof = sst
+	asof.DB = nil
+	asof.SOURCE = nil
+	asof.NODE_CACHE = make(map[NodePtr]NodePtr)
+	asof.NODE_DIRECTORY = NewNodeDirectory()
+
+	key := AsOfKey{sst.DB,sst.STORE,rev,latest}
+
+	if past := CachedGraphAsOf(key); past != nil {
+
+		asof.STORE = past
+
+		bookmarks := sst.STORE.GetBookmarks(DBContext(&sst),&sst)
+
+		past.lock.Lock()
+		past.Bookmarks = bookmarks
+		past.lock.Unlock()
+
+		return asof,nil
+	}
+
+	if ASOF_MAX_NODES > 0 {
+
+		var total int
+
+		for channel := N1GRAM; channel <= GT1024; channel++ {
+			total += int(sst.STORE.GetTopCPtr(DBContext(&sst),&sst,channel))
+		}
+
+		if total > ASOF_MAX_NODES {
+			return sst,fmt.Errorf("%w: %d nodes",ERR_ASOF_TOO_LARGE,total)
+		}
+	}
+
+	past := NewMemoryStore()
+
+	asof.STORE = past
+
+	past.UploadNodes(DBContext(&asof),&asof,sst.STORE.GetAllNodes(DBContext(&sst),&sst))
+	past.UploadArrows(DBContext(&asof),&asof)
+
+	contexts,err := sst.STORE.DownloadContexts(DBContext(&sst),&sst)
+
+	if err != nil {
+		return sst,err
+	}
+
+	for _,cd := range contexts {
+		past.IdempAddContext(DBContext(&asof),&asof,cd.Context,cd.Ptr)
+	}
+
+	past.UploadPageMap(DBContext(&asof),&asof,sst.STORE.GetPageMap(DBContext(&sst),&sst,"%%",nil,1,math.MaxInt32))
+	past.Bookmarks = sst.STORE.GetBookmarks(DBContext(&sst),&sst)
+
+	// The copy keeps the log up to rev, so that \changes looks back from there
+
+	var changes []Change
+
+	for _,c := range sst.STORE.GetChanges(DBContext(&sst),&sst,0) {
+		if c.Rev <= rev {
+			past.Changes = append(past.Changes,c)
+		} else {
+			changes = append(changes,c)
+		}
+	}
+
+	for c := len(changes)-1; c >= 0; c-- {
+		UndoChange(&asof,changes[c])
+	}
+
+	CacheGraphAsOf(key,past)
+	return asof,nil
+}
+
+// **************************************************************************
+
+func CachedGraphAsOf(key AsOfKey) *MemoryStore {
+
+	// The most recently used come first
+
+	ASOF_LOCK.Lock()
+	defer ASOF_LOCK.Unlock()
+
+	for i,entry := range ASOF_CACHE {
+		if entry.key == key {
+			copy(ASOF_CACHE[1:i+1],ASOF_CACHE[:i])
+			ASOF_CACHE[0] = entry
+			return entry.past
+		}
+	}
+
+	return nil
+}
+
+// **************************************************************************
+
+func CacheGraphAsOf(key AsOfKey,past *MemoryStore) {
+
+	ASOF_LOCK.Lock()
+	defer ASOF_LOCK.Unlock()
+
+	var kept = []AsOfGraph{ {key,past} }
+
+	for _,entry := range ASOF_CACHE {
+
+		// Drop what was made from an older graph, or by a parallel call
+
+		stale := entry.key.db == key.db && entry.key.store == key.store && entry.key.latest != key.latest
+
+		if entry.key != key && !stale && len(kept) < ASOF_CACHE_SIZE {
+			kept = append(kept,entry)
+		}
+	}
+
+	ASOF_CACHE = kept
+}
+
+// **************************************************************************
+
+func UndoChange(sst *PoSST,c Change) {
+
+	store := sst.STORE
+
+	switch c.Op {
+
+	case CHANGE_NODE_ADDED:
+		store.DeleteNode(DBContext(sst),sst,c.NPtr)
+
+	case CHANGE_NODE_DELETED:
+		var n Node
+		n.NPtr = c.NPtr
+		n.S = c.Old
+		n.L,_ = StorageClass(c.Old)
+		n.Chap = c.Chap
+		store.UploadNodes(DBContext(sst),sst,[]Node{n})
+
+	case CHANGE_NODE_RENAMED:
+		store.RenameNode(DBContext(sst),sst,c.NPtr,c.Old)
+
+	case CHANGE_NODE_CHAPTER:
+		store.SetNodeChapter(DBContext(sst),sst,c.NPtr,c.Old)
+
+	case CHANGE_LINK_ADDED:
+
+		// Weights can be rounded in storage, so match the rest
+
+		node,_ := store.GetNode(DBContext(sst),sst,c.NPtr)
+
+		var links []Link
+
+		for _,lnk := range node.I[STTypeToSTIndex(c.STtype)] {
+			if lnk.Arr != c.Lnk.Arr || lnk.Dst != c.Lnk.Dst || lnk.Ctx != c.Lnk.Ctx {
+				links = append(links,lnk)
+			}
+		}
+
+		store.SetLinks(DBContext(sst),sst,c.NPtr,links,c.STtype)
+
+	case CHANGE_LINK_REMOVED:
+		store.AppendLink(DBContext(sst),sst,c.NPtr,c.Lnk,c.STtype)
+	}
+}
+
+// **************************************************************************
+
+func FilterChanges(changes []Change,nptrs []NodePtr) []Change {
+
+	// Only the changes to these nodes or their links
+
+	var wanted = make(map[NodePtr]bool)
+
+	for _,nptr := range nptrs {
+		wanted[nptr] = true
+	}
+
+	var filtered []Change
+
+	for _,c := range changes {
+
+		isLink := c.Op == CHANGE_LINK_ADDED || c.Op == CHANGE_LINK_REMOVED
+
+		if wanted[c.NPtr] || (isLink && wanted[c.Lnk.Dst]) {
+			filtered = append(filtered,c)
+		}
+	}
+
+	return filtered
+}
+
+// **************************************************************************
+
+func IsForwardHalf(c Change) bool {
+
+	// Each link is logged at both its ends. For reading, show only the
+	// end with the positive arrow, or the lower NodePtr for NEAR links
+
+	switch c.Op {
+	case CHANGE_LINK_ADDED,CHANGE_LINK_REMOVED:
+	default:
+		return true
+	}
+
+	if c.STtype != 0 {
+		return c.STtype > 0
+	}
+
+	if c.NPtr.Class != c.Lnk.Dst.Class {
+		return c.NPtr.Class < c.Lnk.Dst.Class
+	}
+
+	return c.NPtr.CPtr < c.Lnk.Dst.CPtr
+}
+
+// **************************************************************************
+
+func FormatChange(sst *PoSST,c Change) string {
+
+	ptr := fmt.Sprintf("(%d,%d)",c.NPtr.Class,c.NPtr.CPtr)
+
+	switch c.Op {
+
+	case CHANGE_NODE_ADDED:
+		return fmt.Sprintf("+ node %s \"%s\" in %s",ptr,c.New,c.Chap)
+
+	case CHANGE_NODE_DELETED:
+		return fmt.Sprintf("- node %s \"%s\" from %s",ptr,c.Old,c.Chap)
+
+	case CHANGE_NODE_RENAMED:
+		return fmt.Sprintf("~ node %s renamed \"%s\" -> \"%s\"",ptr,c.Old,c.New)
+
+	case CHANGE_NODE_CHAPTER:
+		return fmt.Sprintf("~ node %s chapter \"%s\" -> \"%s\"",ptr,c.Old,c.New)
+	}
+
+	arrow := "?"
+	arrows := GetArrowDirectory(sst)
+
+	if c.Lnk.Arr >= 0 && int(c.Lnk.Arr) < len(arrows) {
+		arrow = arrows[c.Lnk.Arr].Long
+	}
+
+	sign := "+"
+
+	if c.Op == CHANGE_LINK_REMOVED {
+		sign = "-"
+	}
+
+	s := fmt.Sprintf("%s link %s -(%s)-> (%d,%d)",sign,ptr,arrow,c.Lnk.Dst.Class,c.Lnk.Dst.CPtr)
+
+	if c.Lnk.Wgt != 1 {
+		s += fmt.Sprintf(" weight %.2f",c.Lnk.Wgt)
+	}
+
+	if ctx,_ := sst.STORE.GetContextByPtr(DBContext(sst),sst,c.Lnk.Ctx); ctx != "" {
+		s += " in " + ctx
+	}
+
+	return s
+}
+
+// **************************************************************************
+
+func FormatRevision(c Change) string {
+
+	// The heading for the changes of one revision
+
+	s := fmt.Sprintf("revision %d at %s",c.Rev,time.Unix(c.Time,0).Format(time.RFC3339))
+
+	if c.Author != "" {
+		s += " by " + c.Author
+	}
+
+	return s
+}
+
+// **************************************************************************
+
+func FormatChanges(sst *PoSST,changes []Change) string {
+
+	// A history, one heading per revision, each link shown once
+
+	var s string
+	var rev int64 = -1
+
+	for _,c := range changes {
+
+		if !IsForwardHalf(c) {
+			continue
+		}
+
+		if c.Rev != rev {
+			rev = c.Rev
+			s += "\n" + FormatRevision(c) + "\n"
+		}
+
+		s += "    " + FormatChange(sst,c) + "\n"
+	}
+
+	return s
+}
+
+// **************************************************************************
+// Postgres
+// **************************************************************************
+
+func (pg PostgresStore) AppendChanges(ctx context.Context,sst *PoSST,changes []Change) int64 {
+
+	// The whole revision in one transaction
+
+	edits := NodeEdits{Changes: changes}
+
+	err := pg.EditNodes(ctx,sst,&edits)
+
+	if err != nil {
+		fmt.Println("Failed to store change log",err)
+		return 0
+	}
+
+	return edits.Revision
+}
+
+// **************************************************************************
+
+func AppendSQLChanges(ctx context.Context,tx *sql.Tx,changes []Change) (int64,error) {
+
+	// One new revision, in the caller's transaction, 0 if there is nothing
+
+	if len(changes) == 0 {
+		return 0,nil
+	}
+
+	var rev int64
+
+	err := tx.QueryRowContext(ctx,"SELECT nextval('ChangeRevision')").Scan(&rev)
+
+	if err != nil {
+		return 0,err
+	}
+
+	var qstr string
+
+	for _,c := range changes {
+		qstr += FormatSQLChange(rev,c)
+	}
+
+	_,err = tx.ExecContext(ctx,qstr)
+
+	if err != nil {
+		return 0,err
+	}
+
+	return rev,nil
+}
+
+// **************************************************************************
+
+func FormatSQLChange(rev int64,c Change) string {
+
+	return fmt.Sprintf("INSERT INTO ChangeLog (Rev,Time,Author,Op,NPtr,STtype,Arr,Wgt,Ctx,Dst,Chap,Old,New) "+
+		"VALUES (%d,%d,'%s','%s','(%d,%d)',%d,%d,%f,%d,'(%d,%d)','%s','%s','%s');\n",
+		rev,c.Time,SQLEscape(c.Author),c.Op,c.NPtr.Class,c.NPtr.CPtr,c.STtype,
+		c.Lnk.Arr,c.Lnk.Wgt,c.Lnk.Ctx,c.Lnk.Dst.Class,c.Lnk.Dst.CPtr,
+		SQLEscape(c.Chap),SQLEscape(c.Old),SQLEscape(c.New))
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) GetChanges(ctx context.Context,sst *PoSST,after int64) []Change {
+
+	qstr := fmt.Sprintf("SELECT Rev,Time,Author,Op,NPtr,STtype,Arr,Wgt,Ctx,Dst,Chap,Old,New FROM ChangeLog "+
+		"WHERE Rev > %d ORDER BY Rev,Id",after)
+
+	row,err := sst.DB.QueryContext(ctx,qstr)
+
+	if err != nil {
+		fmt.Println("QUERY GetChanges Failed",err,qstr)
+		return nil
+	}
+
+	var changes []Change
+
+	for row.Next() {
+
+		var c Change
+		var nptr,dst string
+
+		err = row.Scan(&c.Rev,&c.Time,&c.Author,&c.Op,&nptr,&c.STtype,&c.Lnk.Arr,&c.Lnk.Wgt,&c.Lnk.Ctx,&dst,&c.Chap,&c.Old,&c.New)
+
+		if err != nil {
+			fmt.Println("Couldn't read change",err)
+			continue
+		}
+
+		fmt.Sscanf(nptr,"(%d,%d)",&c.NPtr.Class,&c.NPtr.CPtr)
+		fmt.Sscanf(dst,"(%d,%d)",&c.Lnk.Dst.Class,&c.Lnk.Dst.CPtr)
+		changes = append(changes,c)
+	}
+
+	row.Close()
+	return changes
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) GetRevisionAt(ctx context.Context,sst *PoSST,t int64) int64 {
+
+	var rev int64
+
+	qstr := fmt.Sprintf("SELECT coalesce(max(Rev),0) FROM ChangeLog WHERE Time <= %d",t)
+
+	err := sst.DB.QueryRowContext(ctx,qstr).Scan(&rev)
+
+	if err != nil {
+		fmt.Println("QUERY GetRevisionAt Failed",err,qstr)
+		return 0
+	}
+
+	return rev
+}
+
+//
+// changelog.go
+//
@@ -0,0 +1,418 @@
+//**************************************************************
+//
+// concurrency.go
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"sync"
+	_ "github.com/lib/pq"
+
+)
+
+//**************************************************************
+// What may be shared between goroutines.
+//
+// A session holds in-memory directories beside the database: the
+// node cache and node directory, which grow as nodes are fetched,
+// the context table, which grows as contexts are registered, and
+// the arrow directory. The node directory and context table are
+// held by pointer, so that copies of a PoSST share them, and they
+// are guarded by the session's DIRLOCK, which MemoryInit() makes.
+// So any number of goroutines may search, add and edit through
+// one session, or copies of it, e.g. ingestion workers, provided
+// they go through the library functions rather than the maps.
+//
+// The arrow directory is guarded by the same lock: declarations
+// with InsertArrowDirectory() and InsertInverseArrowDirectory()
+// take it, and readers go through GetArrowDirectory(),
+// GetInverseArrow(), GetInverseArrows(), GetArrowPtrByName() and
+// GetDBArrowByPtr(). The slice and its top are not pointers, so
+// even copying the session reads them: functions that take a PoSST
+// by value must be passed CopySession(sst), not *sst, while others
+// may be declaring arrows. With that, goroutines sharing one session
+// may declare arrows while others search. A copy that declares
+// arrows of its own, e.g. to compile N4L, should have its own
+// directories, see CloneArrowDirectory().
+//
+// The short term memory of search terms, STM_INT_FRAG and
+// STM_AMB_FRAG, is shared by all sessions and guarded by STM_LOCK.
+// The WIPE and SYNC settings belong to each session.
+//
+// The backends are safe in themselves: database/sql pools its
+// connections, and the memory store has its own lock. A server
+// should also see SharedSession, which keeps one session fresh.
+//
+// cmd/demo_pocs/dotest_concurrency runs this under -race.
+//**************************************************************
+
+var STM_LOCK sync.Mutex // guards the STM_ fragment maps, see text_intentionality.go
+
+var no_dirlock sync.RWMutex // for sessions not opened by MemoryInit()
+
+// **************************************************************************
+
+func DirLock(sst *PoSST) *sync.RWMutex {
+
+	if sst.DIRLOCK == nil {
+		return &no_dirlock
+	}
+
+	return sst.DIRLOCK
+}
+
+// **************************************************************************
+
+func CopySession(sst *PoSST) PoSST {
+
+	// For the functions that take a PoSST by value. Writing *sst reads the
+	// arrow directory and its top, which InsertArrowDirectory() changes,
+	// so take the copy under the lock
+
+	lock := DirLock(sst)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return *sst
+}
+
+// **************************************************************************
+
+func NewNodeDirectory() *NodeDirectory {
+
+	var directory NodeDirectory
+
+	directory.N1grams = make(map[string]ClassedNodePtr)
+	directory.N2grams = make(map[string]ClassedNodePtr)
+	directory.N3grams = make(map[string]ClassedNodePtr)
+	directory.LT128 = make(map[string]ClassedNodePtr)
+
+	return &directory
+}
+
+// **************************************************************************
+
+func NewContextTable() *ContextTable {
+
+	var contexts ContextTable
+
+	contexts.Index = make(map[string]ContextPtr)
+	return &contexts
+}
+
+// **************************************************************************
+
+func CloneContextTable(sst *PoSST) *ContextTable {
+
+	// A private copy for a session that will go its own way
+
+	lock := DirLock(sst)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	contexts := sst.CONTEXTS
+	clone := NewContextTable()
+	clone.Directory = append([]ContextDirectory(nil),contexts.Directory...)
+	clone.Top = contexts.Top
+
+	for ctx,ptr := range contexts.Index {
+		clone.Index[ctx] = ptr
+	}
+
+	return clone
+}
+
+// **************************************************************************
+
+func CloneArrowDirectory(sst *PoSST) {
+
+	// Private copies of the arrow directories, for a session that
+	// declares arrows while others are reading them, e.g. with N4L
+
+	lock := DirLock(sst)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	short := make(map[string]ArrowPtr)
+	long := make(map[string]ArrowPtr)
+	inverses := make(map[ArrowPtr]ArrowPtr)
+
+	for name,ptr := range sst.ARROW_SHORT_DIR {
+		short[name] = ptr
+	}
+
+	for name,ptr := range sst.ARROW_LONG_DIR {
+		long[name] = ptr
+	}
+
+	for plus,minus := range sst.INVERSE_ARROWS {
+		inverses[plus] = minus
+	}
+
+	sst.ARROW_DIRECTORY = append([]ArrowDirectory(nil),sst.ARROW_DIRECTORY...)
+	sst.ARROW_SHORT_DIR = short
+	sst.ARROW_LONG_DIR = long
+	sst.INVERSE_ARROWS = inverses
+}
+
+// **************************************************************************
+
+func ArrowsLoaded(sst *PoSST) bool {
+
+	// Whether the arrow directory has been read yet
+
+	lock := DirLock(sst)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return sst.ARROW_DIRECTORY_TOP > 0
+}
+
+// **************************************************************************
+
+func GetArrowDirectory(sst *PoSST) []ArrowDirectory {
+
+	// The arrow directory as it is now. Declarations replace the array
+	// instead of changing it, so this can be ranged over or indexed
+	// while others add arrows
+
+	lock := DirLock(sst)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return sst.ARROW_DIRECTORY
+}
+
+// **************************************************************************
+
+func GetInverseArrow(sst *PoSST,arr ArrowPtr) ArrowPtr {
+
+	lock := DirLock(sst)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return sst.INVERSE_ARROWS[arr]
+}
+
+// **************************************************************************
+
+func GetInverseArrows(sst *PoSST) map[ArrowPtr]ArrowPtr {
+
+	// A copy to range over while others may be declaring arrows
+
+	lock := DirLock(sst)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	inverses := make(map[ArrowPtr]ArrowPtr)
+
+	for plus,minus := range sst.INVERSE_ARROWS {
+		inverses[plus] = minus
+	}
+
+	return inverses
+}
+
+// **************************************************************************
+
+func GetArrowPtrByName(sst *PoSST,name string) (ArrowPtr,bool) {
+
+	// The short name first, then the long one, without downloading
+
+	lock := DirLock(sst)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	ptr,ok := sst.ARROW_SHORT_DIR[name]
+
+	if !ok {
+		ptr,ok = sst.ARROW_LONG_DIR[name]
+	}
+
+	return ptr,ok
+}
+
+// **************************************************************************
+
+func CachedNodePtr(sst *PoSST,db_nptr NodePtr) (NodePtr,bool) {
+
+	// The in-memory location of a node already fetched from the database
+
+	lock := DirLock(sst)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	im_nptr,cached := sst.NODE_CACHE[db_nptr]
+	return im_nptr,cached
+}
+
+// **************************************************************************
+
+func UncacheNodes(sst *PoSST,nptrs ...NodePtr) {
+
+	// Forget cached copies of nodes that have been changed in the database
+
+	lock := DirLock(sst)
+	lock.Lock()
+	defer lock.Unlock()
+
+	for _,nptr := range nptrs {
+		delete(sst.NODE_CACHE,nptr)
+	}
+}
+
+// **************************************************************************
+
+func ForgetNode(sst *PoSST,node Node) {
+
+	// Forget a deleted node: its cached copy, and its name in the directory,
+	// so the name is no longer found. The slot stays, so others keep their CPtr
+
+	lock := DirLock(sst)
+	lock.Lock()
+	defer lock.Unlock()
+
+	slots,names,im_nptr,ok := DirectorySlot(sst,node)
+	delete(sst.NODE_CACHE,node.NPtr)
+
+	if !ok {
+		return
+	}
+
+	if names != nil && names[node.S] == im_nptr.CPtr {
+		delete(names,node.S)
+	}
+
+	slots[im_nptr.CPtr] = Node{NPtr: im_nptr}
+}
+
+// **************************************************************************
+
+func RenameDirectoryNode(sst *PoSST,node Node,name string) {
+
+	// Move a renamed node's directory entry to its new name, so the old
+	// name is no longer found and the new one is
+
+	lock := DirLock(sst)
+	lock.Lock()
+	defer lock.Unlock()
+
+	slots,names,im_nptr,ok := DirectorySlot(sst,node)
+	delete(sst.NODE_CACHE,node.NPtr)
+
+	if !ok {
+		return
+	}
+
+	if names != nil {
+		if names[node.S] == im_nptr.CPtr {
+			delete(names,node.S)
+		}
+		names[name] = im_nptr.CPtr
+	}
+
+	slots[im_nptr.CPtr].S = name
+	slots[im_nptr.CPtr].L,_ = StorageClass(name)
+}
+
+// **************************************************************************
+
+func DirectorySlot(sst *PoSST,node Node) ([]Node,map[string]ClassedNodePtr,NodePtr,bool) {
+
+	// Where a stored node is in the directory, if it is there at all.
+	// Caller holds the directory lock
+
+	im_nptr,cached := sst.NODE_CACHE[node.NPtr]
+
+	if !cached {
+		im_nptr = node.NPtr
+	}
+
+	dir := sst.NODE_DIRECTORY
+
+	var slots []Node
+	var names map[string]ClassedNodePtr
+
+	switch im_nptr.Class {
+	case N1GRAM:
+		slots,names = dir.N1directory,dir.N1grams
+	case N2GRAM:
+		slots,names = dir.N2directory,dir.N2grams
+	case N3GRAM:
+		slots,names = dir.N3directory,dir.N3grams
+	case LT128:
+		slots,names = dir.LT128directory,dir.LT128
+	case LT1024:
+		slots = dir.LT1024
+	case GT1024:
+		slots = dir.GT1024
+	}
+
+	// Only if the slot really holds this node, the directory may not
+	// follow the database's numbering
+
+	if im_nptr.CPtr < 0 || int(im_nptr.CPtr) >= len(slots) || slots[im_nptr.CPtr].S != node.S {
+		return nil,nil,im_nptr,false
+	}
+
+	return slots,names,im_nptr,true
+}
+
+// **************************************************************************
+
+func GetContextDirectory(sst *PoSST) []ContextDirectory {
+
+	// A copy to range over while others may be registering contexts
+
+	lock := DirLock(sst)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return append([]ContextDirectory(nil),sst.CONTEXTS.Directory...)
+}
+
+// **************************************************************************
+
+func IdempContextDirectory(sst *PoSST,ctxstr string) ContextPtr {
+
+	// Look up a normalized context string, adding it if new
+
+	lock := DirLock(sst)
+	lock.Lock()
+	defer lock.Unlock()
+
+	contexts := sst.CONTEXTS
+	ctxptr,exists := contexts.Index[ctxstr]
+
+	if !exists {
+		var cd ContextDirectory
+		cd.Context = ctxstr
+		cd.Ptr = contexts.Top
+		contexts.Directory = append(contexts.Directory,cd)
+		contexts.Index[ctxstr] = contexts.Top
+		ctxptr = contexts.Top
+		contexts.Top++
+	}
+
+	SyncContextFields(sst)
+	return ctxptr
+}
+
+// **************************************************************************
+
+func SyncContextFields(sst *PoSST) {
+
+	// Keep the deprecated CONTEXT_ fields in step with the context table.
+	// Caller holds the directory lock, or has the only copy of the table
+
+	sst.CONTEXT_DIRECTORY = sst.CONTEXTS.Directory
+	sst.CONTEXT_DIR = sst.CONTEXTS.Index
+	sst.CONTEXT_TOP = sst.CONTEXTS.Top
+}
+
+//
+// concurrency.go
+//
+
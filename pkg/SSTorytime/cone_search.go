@@ -0,0 +1,631 @@
+// **************************************************************************
+//
+// cone_search.go
+//
+// **************************************************************************
+
+package SSTorytime
+
+import (
+	"strings"
+	"unicode/utf8"
+	_ "github.com/lib/pq"
+
+)
+
+// **************************************************************************
+// Go equivalents of the PL/pgSQL cone/path functions in postgres_types_functions.go
+// for backends that have no stored procedures. They only need sst.STORE.GetNode()
+// and GetContextByPtr(), and return the same paths as the SQL text format would
+// after ParseLinkPath(). Arrays in plpgsql are passed by value, so the exclude
+// lists are copied on each recursion to give the same search horizon.
+// **************************************************************************
+
+func GetSingletonAsLink(start NodePtr) Link {
+
+	// Construct an empty link pointing nowhere as a starting node
+
+	var lnk Link
+
+	lnk.Arr = 0
+	lnk.Wgt = 1.0
+	lnk.Ctx = 0
+	lnk.Dst = start
+
+	return lnk
+}
+
+// **************************************************************************
+
+func GetNeighboursByType(sst *PoSST,start NodePtr,sttype int) []Link {
+
+	if sttype < -EXPRESS || sttype > EXPRESS || Cancelled(sst) {
+		return nil
+	}
+
+	n,_ := sst.STORE.GetNode(DBContext(sst),sst,start)
+
+	if n.L == 0 {
+		return nil
+	}
+
+	return n.I[STTypeToSTIndex(sttype)]
+}
+
+// **************************************************************************
+
+func GetNCNeighboursByType(sst *PoSST,start NodePtr,chapter string,rm_acc bool,sttype int) []Link {
+
+	// chapter is a LIKE pattern, e.g. %chap% or %chap%|!%draft%
+
+	if sttype < -EXPRESS || sttype > EXPRESS || Cancelled(sst) {
+		return nil
+	}
+
+	n,_ := sst.STORE.GetNode(DBContext(sst),sst,start)
+
+	if n.L == 0 || !MatchChapter(n.Chap,chapter,rm_acc) {
+		return nil
+	}
+
+	return n.I[STTypeToSTIndex(sttype)]
+}
+
+// **************************************************************************
+
+func GetFwdLinks(sst *PoSST,start NodePtr,exclude []NodePtr,sttype int) []Link {
+
+	var neighbours []Link
+
+	for _,lnk := range GetNeighboursByType(sst,start,sttype) {
+
+		if lnk.Arr == 0 {
+			continue
+		}
+
+		if !InNodeSet(exclude,lnk.Dst) {
+			neighbours = append(neighbours,lnk)
+		}
+	}
+
+	return neighbours
+}
+
+// **************************************************************************
+
+func GetNCFwdLinks(sst *PoSST,start NodePtr,chapter string,rm_acc bool,context []string,exclude []NodePtr,sttype int) []Link {
+
+	var neighbours []Link
+
+	for _,lnk := range GetNCNeighboursByType(sst,start,chapter,rm_acc,sttype) {
+
+		if lnk.Arr == 0 {
+			continue
+		}
+
+		if !MatchContext(sst,lnk.Ctx,context) {
+			continue
+		}
+
+		if !InNodeSet(exclude,lnk.Dst) {
+			neighbours = append(neighbours,lnk)
+		}
+	}
+
+	return neighbours
+}
+
+// **************************************************************************
+
+func GetConstrainedFwdLinksBySTType(sst *PoSST,start NodePtr,chapter string,rm_acc bool,context []string,exclude []NodePtr,sttype int,arrows []ArrowPtr) []Link {
+
+	// Fully filtering version of the neighbour scan
+
+	var neighbours []Link
+
+	for _,lnk := range GetNCNeighboursByType(sst,start,chapter,rm_acc,sttype) {
+
+		if lnk.Arr == 0 {
+			continue
+		}
+
+		if len(arrows) > 0 && !MatchArrows(arrows,lnk.Arr) {
+			continue
+		}
+
+		if !MatchContext(sst,lnk.Ctx,context) {
+			continue
+		}
+
+		if !InNodeSet(exclude,lnk.Dst) {
+			neighbours = append(neighbours,lnk)
+		}
+	}
+
+	return neighbours
+}
+
+// **************************************************************************
+
+func FwdPathsAsLinks(sst *PoSST,start NodePtr,sttype,maxdepth,maxlimit int) [][]Link {
+
+	// Orthogonal (depth first) paths from origin spreading out
+
+	exclude := []NodePtr{start}
+	startlnk := GetSingletonAsLink(start)
+	path := []Link{startlnk}
+
+	return SumFwdPaths(sst,startlnk,path,sttype,1,maxdepth,exclude,maxlimit)
+}
+
+// **************************************************************************
+
+func SumFwdPaths(sst *PoSST,start Link,path []Link,sttype,depth,maxdepth int,exclude []NodePtr,maxlimit int) [][]Link {
+
+	var ret_paths [][]Link
+	var count int = 0
+
+	if depth == maxdepth {
+		return append(ret_paths,path)
+	}
+
+	exclude = CopyNodePtrSet(exclude)
+
+	fwdlinks := GetFwdLinks(sst,start.Dst,exclude,sttype)
+
+	// limit recursion explosions
+
+	horizon := maxlimit - len(fwdlinks)
+
+	if horizon < 0 {
+		horizon = 0
+		maxdepth = depth + 1
+	}
+
+	for _,lnk := range fwdlinks {
+
+		if InNodeSet(exclude,lnk.Dst) {
+			continue
+		}
+
+		exclude = append(exclude,lnk.Dst)
+
+		if count >= maxlimit {
+			return append(ret_paths,path)
+		}
+
+		count++
+
+		tot_path := ExtendPath(path,lnk)
+		appendix := SumFwdPaths(sst,lnk,tot_path,sttype,depth+1,maxdepth,exclude,horizon)
+
+		if appendix != nil {
+			ret_paths = append(ret_paths,appendix...)
+			count += CountPathHops(appendix)
+		} else {
+			ret_paths = append(ret_paths,tot_path)
+		}
+	}
+
+	return ret_paths
+}
+
+// **************************************************************************
+
+func AllPathsAsLinks(sst *PoSST,start NodePtr,orientation string,maxdepth,maxlimit int) [][]Link {
+
+	// Typeless cone searches
+
+	exclude := []NodePtr{start}
+	startlnk := GetSingletonAsLink(start)
+	path := []Link{startlnk}
+
+	return SumAllPaths(sst,startlnk,path,orientation,1,maxdepth,exclude,maxlimit)
+}
+
+// **************************************************************************
+
+func SumAllPaths(sst *PoSST,start Link,path []Link,orientation string,depth,maxdepth int,exclude []NodePtr,maxlimit int) [][]Link {
+
+	var ret_paths [][]Link
+	var fwdlinks []Link
+	var counter int = 0
+
+	if depth == maxdepth {
+		return append(ret_paths,path)
+	}
+
+	exclude = CopyNodePtrSet(exclude)
+
+	for _,st := range OrientationSTTypes(orientation) {
+		fwdlinks = append(fwdlinks,GetFwdLinks(sst,start.Dst,exclude,st)...)
+	}
+
+	for _,lnk := range fwdlinks {
+
+		if counter > maxlimit {
+			return ret_paths
+		}
+
+		if InNodeSet(exclude,lnk.Dst) {
+			continue
+		}
+
+		exclude = append(exclude,lnk.Dst)
+
+		tot_path := ExtendPath(path,lnk)
+		appendix := SumAllPaths(sst,lnk,tot_path,orientation,depth+1,maxdepth,exclude,maxlimit)
+
+		if appendix != nil {
+			ret_paths = append(ret_paths,appendix...)
+		} else {
+			ret_paths = append(ret_paths,tot_path)
+		}
+
+		counter++
+	}
+
+	return ret_paths
+}
+
+// **************************************************************************
+
+func AllNCPathsAsLinks(sst *PoSST,start []NodePtr,chapter string,rm_acc bool,context []string,orientation string,maxdepth,maxlimit int) [][]Link {
+
+	// Chapter/context filtered paths from a start set of more than one node
+
+	var ret_paths [][]Link
+
+	exclude := CopyNodePtrSet(start)
+
+	for _,node := range start {
+		startlnk := GetSingletonAsLink(node)
+		path := []Link{startlnk}
+		root := SumAllNCPaths(sst,startlnk,path,orientation,1,maxdepth,chapter,rm_acc,context,exclude,maxlimit)
+		ret_paths = append(ret_paths,root...)
+	}
+
+	return ret_paths
+}
+
+// **************************************************************************
+
+func SumAllNCPaths(sst *PoSST,start Link,path []Link,orientation string,depth,maxdepth int,chapter string,rm_acc bool,context []string,exclude []NodePtr,maxlimit int) [][]Link {
+
+	var ret_paths [][]Link
+	var fwdlinks []Link
+	var count int = 0
+
+	if depth == maxdepth {
+		return append(ret_paths,path)
+	}
+
+	exclude = CopyNodePtrSet(exclude)
+
+	// We order the link types to respect the geometry of the temporal links
+	// so that (then) will always come last for visual sensemaking
+
+	for _,st := range OrientationSTTypes(orientation) {
+		fwdlinks = append(fwdlinks,GetNCFwdLinks(sst,start.Dst,chapter,rm_acc,context,exclude,st)...)
+	}
+
+	horizon := maxlimit - len(fwdlinks)
+
+	if horizon < 0 {
+		horizon = 0
+		maxdepth = depth + 1
+	}
+
+	for _,lnk := range fwdlinks {
+
+		if InNodeSet(exclude,lnk.Dst) {
+			continue
+		}
+
+		exclude = append(exclude,lnk.Dst)
+
+		if count > maxlimit {
+			ret_paths = append(ret_paths,path)
+			continue
+		}
+
+		count++
+
+		if !MatchContext(sst,lnk.Ctx,context) {
+			continue
+		}
+
+		tot_path := ExtendPath(path,lnk)
+		appendix := SumAllNCPaths(sst,lnk,tot_path,orientation,depth+1,maxdepth,chapter,rm_acc,context,exclude,horizon)
+
+		if appendix != nil {
+			ret_paths = append(ret_paths,appendix...)
+			count += CountPathHops(appendix)
+		} else {
+			ret_paths = append(ret_paths,tot_path)
+		}
+	}
+
+	return ret_paths
+}
+
+// **************************************************************************
+
+func ConstraintPathsAsLinks(sst *PoSST,start []NodePtr,chapter string,rm_acc bool,context []string,arrows []ArrowPtr,sttypes []int,maxdepth,maxlimit int) [][]Link {
+
+	var ret_paths [][]Link
+
+	if len(sttypes) == 0 {
+		sttypes = []int{-3,-2,-1,0,1,2,3}
+	}
+
+	exclude := CopyNodePtrSet(start)
+
+	for _,node := range start {
+		startlnk := GetSingletonAsLink(node)
+		path := []Link{startlnk}
+		root := SumConstraintPaths(sst,startlnk,path,1,maxdepth,chapter,rm_acc,context,arrows,sttypes,exclude,maxlimit)
+		ret_paths = append(ret_paths,root...)
+	}
+
+	return ret_paths
+}
+
+// **************************************************************************
+
+func SumConstraintPaths(sst *PoSST,start Link,path []Link,depth,maxdepth int,chapter string,rm_acc bool,context []string,arrows []ArrowPtr,sttypes []int,exclude []NodePtr,maxlimit int) [][]Link {
+
+	var ret_paths [][]Link
+	var fwdlinks []Link
+	var count int = 0
+
+	if depth == maxdepth {
+		return append(ret_paths,path)
+	}
+
+	exclude = CopyNodePtrSet(exclude)
+
+	for _,st := range sttypes {
+		fwdlinks = append(fwdlinks,GetConstrainedFwdLinksBySTType(sst,start.Dst,chapter,rm_acc,context,exclude,st,arrows)...)
+	}
+
+	horizon := maxlimit - len(fwdlinks)
+
+	if horizon < 0 {
+		horizon = 0
+		maxdepth = depth + 1
+	}
+
+	for _,lnk := range fwdlinks {
+
+		if InNodeSet(exclude,lnk.Dst) {
+			continue
+		}
+
+		exclude = append(exclude,lnk.Dst)
+
+		if count > maxlimit {
+			ret_paths = append(ret_paths,path)
+			continue
+		}
+
+		count++
+
+		if !MatchContext(sst,lnk.Ctx,context) {
+			continue
+		}
+
+		tot_path := ExtendPath(path,lnk)
+		appendix := SumConstraintPaths(sst,lnk,tot_path,depth+1,maxdepth,chapter,rm_acc,context,arrows,sttypes,exclude,horizon)
+
+		if appendix != nil {
+			ret_paths = append(ret_paths,appendix...)
+			count += CountPathHops(appendix)
+		} else {
+			ret_paths = append(ret_paths,tot_path)
+		}
+	}
+
+	return ret_paths
+}
+
+// **************************************************************************
+
+func MatchContext(sst *PoSST,thisctxptr ContextPtr,user_set []string) bool {
+
+	// Go version of match_context(). Terms like !draft inhibit a match,
+	// and if there are only such terms then everything else matches.
+	// Boolean queries add alternatives like prod&!staging
+
+	include,exclude := SplitNegatedSearchList(user_set)
+	include,conjunctions := SplitQueryConjunctions(include)
+
+	if len(exclude) > 0 {
+
+		if MatchContextSet(sst,thisctxptr,exclude) {
+			return false
+		}
+
+		if len(include) == 0 && len(conjunctions) == 0 {
+			return true
+		}
+	}
+
+	if len(conjunctions) == 0 {
+		return MatchContextSet(sst,thisctxptr,include)
+	}
+
+	if len(include) > 0 && MatchContextSet(sst,thisctxptr,include) {
+		return true
+	}
+
+	match := func(term string) bool {
+		return MatchContextSet(sst,thisctxptr,[]string{term})
+	}
+
+	for _,conj := range conjunctions {
+
+		if MatchQueryConjunction(conj,match) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// **************************************************************************
+
+func MatchContextSet(sst *PoSST,thisctxptr ContextPtr,user_set []string) bool {
+
+	// Go version of match_context_set(). The policy/notes expression is db_set,
+	// the client/lookup set is user_set - both COULD use AND expressions.
+	// We are looking for sets that overlap for a true result
+
+	var client []string
+
+	for _,item := range user_set {
+		if len(item) > 0 {
+			client = append(client,item)
+		}
+	}
+
+	// If no constraints at all, then match
+
+	if len(client) == 0 {
+		return true
+	}
+
+	ctxstr,_ := sst.STORE.GetContextByPtr(DBContext(sst),sst,thisctxptr)
+
+	// If there is a constraint, but no db membership, then no match
+
+	if ctxstr == "" {
+		return false
+	}
+
+	db_set := strings.Split(ctxstr,",")
+
+	for _,item_db := range db_set {
+		for _,item_us := range client {
+			if item_db == item_us {
+				return true
+			}
+		}
+	}
+
+	for c := range client {
+		client[c] = UnCmp(client[c],true)
+	}
+
+	var or_list []string
+
+	// First split check AND strings in the notes
+
+	for _,item := range db_set {
+
+		item = UnCmp(item,true)
+		and_list := strings.Split(item,".")
+
+		if len(and_list) > 1 {
+
+			and_result := 0
+
+			for _,ref := range and_list {
+				for _,c := range client {
+					if ref == c {
+						and_result++
+					}
+				}
+			}
+
+			if and_result == len(and_list) {
+				return true
+			}
+		} else {
+			or_list = append(or_list,item)
+		}
+	}
+
+	// if still not match, check any left overs, now we can look at substring partial matches
+
+	for _,ref := range or_list {
+		for _,c := range client {
+
+			pos := strings.Index(ref,c)
+
+			if pos < 0 {
+				continue
+			}
+
+			partial := ref[pos+len(c):]
+
+			if dot := strings.Index(partial,"."); dot >= 0 {
+				partial = partial[:dot]
+			}
+
+			// Only allow significant matches, since this is approx
+
+			if utf8.RuneCountInString(c) >= 4 && utf8.RuneCountInString(partial) < 3 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// **************************************************************************
+
+func OrientationSTTypes(orientation string) []int {
+
+	switch orientation {
+	case "bwd":
+		return []int{-3,-2,-1,0}
+	case "fwd":
+		return []int{0,1,2,3}
+	default:
+		return []int{-3,-2,-1,0,1,2,3}
+	}
+}
+
+// **************************************************************************
+
+func ExtendPath(path []Link,lnk Link) []Link {
+
+	var delta []Link
+
+	delta = append(delta,path...)
+	delta = append(delta,lnk)
+
+	return delta
+}
+
+// **************************************************************************
+
+func CountPathHops(paths [][]Link) int {
+
+	// Equivalent of counting the ';' separators in the SQL text paths
+
+	var count int
+
+	for _,p := range paths {
+		if len(p) > 1 {
+			count += len(p)-1
+		}
+	}
+
+	return count
+}
+
+// **************************************************************************
+
+func CopyNodePtrSet(set []NodePtr) []NodePtr {
+
+	var cpy []NodePtr
+
+	return append(cpy,set...)
+}
+
+//
+// cone_search.go
+//
@@ -0,0 +1,728 @@
+//**************************************************************
+//
+// db_editing.go
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	_ "github.com/lib/pq"
+
+)
+
+//**************************************************************
+// Corrections to single nodes and links, without reloading a
+// chapter. Every link is stored twice: once on the source node,
+// and once as its inverse on the destination node, in the
+// column for -sttype. Edits have to keep both halves in step,
+// so the stores make each edit in one transaction.
+//**************************************************************
+
+type LinkEdit struct {
+
+	NPtr   NodePtr
+	STtype int
+	Links  []Link // replaces the whole column
+}
+
+//**************************************************************
+
+type NodeEdits struct {
+
+	// One correction of the graph, for Storage.EditNodes() to make
+	// in one transaction. Mostly made by NodeEditsBetween(), from
+	// nodes read in Plan so that concurrent edits are not lost
+
+	Plan      func(read LockedRead,edits *NodeEdits) error // fills in the rest, first in the transaction
+	Added     []Node              // new nodes, with their links
+	Links     []LinkEdit
+	Appended  []BatchLink         // links added unless there, as AppendLink()
+	Renamed   map[NodePtr]string
+	Chapters  map[NodePtr]string
+	Seqs      map[NodePtr]bool
+	Repoint   map[NodePtr]NodePtr // page map references, from -> to
+	Aliases   []NodeAlias         // merge records, see node_merging.go
+	Unaliased []NodePtr
+	Deleted   []NodePtr           // with their provenance and page map places
+	PageMaps  map[string][]PageMap    // chapters whose page map lines are replaced
+	Sources   map[string][]Provenance // files whose provenance records are replaced
+	Changes   []Change            // one revision for the change log, stamped
+
+	Repointed int                 // page map lines re-pointed, set by EditNodes()
+	Revision  int64               // the revision of Changes, set by EditNodes()
+}
+
+//**************************************************************
+
+// Reads nodes in EditNodes()'s transaction, so that no other edit
+// changes them until it ends. Absent nodes have S=""
+
+type LockedRead func(nptrs ...NodePtr) (map[NodePtr]Node,error)
+
+//**************************************************************
+
+func EditDBLinks(sst *PoSST,from NodePtr,arrowptr ArrowPtr,to NodePtr,edit func(Link) (Link,bool)) error {
+
+	// Apply edit to every link from -> to with this arrow, whatever its
+	// context, then to the inverses. If edit returns false, the link goes
+
+	arrows := GetArrowDirectory(sst)
+
+	if arrowptr < 0 || int(arrowptr) >= len(arrows) {
+		return fmt.Errorf("%w: (%d)",ERR_NO_SUCH_ARROW,arrowptr)
+	}
+
+	sttype := STIndexToSTType(arrows[arrowptr].STAindex)
+	inverse := GetInverseArrow(sst,arrowptr)
+
+	var edits NodeEdits
+
+	edits.Plan = func(read LockedRead,edits *NodeEdits) error {
+
+		// Check both ends before changing either
+
+		before,err := LockedSnapshot(read,from,to)
+
+		if err != nil {
+			return err
+		}
+
+		for _,nptr := range []NodePtr{from,to} {
+			if before[nptr].S == "" {
+				return fmt.Errorf("%w: (%d,%d)",ERR_NO_SUCH_NODE,nptr.Class,nptr.CPtr)
+			}
+		}
+
+		forward,found := EditLinkColumn(before[from],sttype,arrowptr,to,edit)
+
+		if !found {
+			return fmt.Errorf("%w: (%d,%d) -(%s)-> (%d,%d)",ERR_NO_SUCH_LINK,
+				from.Class,from.CPtr,arrows[arrowptr].Long,to.Class,to.CPtr)
+		}
+
+		columns := []LinkEdit{forward}
+
+		// A missing inverse is not an error, the edit still takes effect
+
+		if backward,found := EditLinkColumn(before[to],-sttype,inverse,from,edit); found {
+			columns = append(columns,backward)
+		}
+
+		*edits = *LoggedLinkEdits(sst,before,columns)
+		return nil
+	}
+
+	// Both halves together, or neither, with the change log
+
+	err := sst.STORE.EditNodes(DBContext(sst),sst,&edits)
+
+	UncacheNodes(sst,from,to)
+	return err
+}
+
+// **************************************************************************
+
+func EditLinkColumn(node Node,sttype int,arrowptr ArrowPtr,dst NodePtr,edit func(Link) (Link,bool)) (LinkEdit,bool) {
+
+	// The new column of links for node, without storing it
+
+	var links []Link
+	var found bool
+
+	for _,lnk := range node.I[STTypeToSTIndex(sttype)] {
+
+		if lnk.Arr == arrowptr && lnk.Dst == dst {
+
+			found = true
+
+			var keep bool
+			lnk,keep = edit(lnk)
+
+			if !keep {
+				continue
+			}
+		}
+
+		// Two links can become the same, e.g. on changing context
+
+		if !LinkInList(links,lnk) {
+			links = append(links,lnk)
+		}
+	}
+
+	return LinkEdit{NPtr: node.NPtr,STtype: sttype,Links: links},found
+}
+
+// **************************************************************************
+
+func LinkEditsTo(node Node,dst NodePtr) []LinkEdit {
+
+	// The columns of node without the links that point to dst
+
+	var edits []LinkEdit
+
+	for stindex := 0; stindex < ST_TOP; stindex++ {
+
+		var links []Link
+
+		for _,lnk := range node.I[stindex] {
+			if lnk.Dst != dst {
+				links = append(links,lnk)
+			}
+		}
+
+		if len(links) < len(node.I[stindex]) {
+			edits = append(edits,LinkEdit{NPtr: node.NPtr,STtype: STIndexToSTType(stindex),Links: links})
+		}
+	}
+
+	return edits
+}
+
+// **************************************************************************
+
+func RemoveDBLinksTo(sst *PoSST,nptr NodePtr,dst NodePtr) error {
+
+	// Remove every link on nptr that points to dst, in any column
+
+	var edits NodeEdits
+
+	edits.Plan = func(read LockedRead,edits *NodeEdits) error {
+
+		before,err := LockedSnapshot(read,nptr)
+
+		if err != nil {
+			return err
+		}
+
+		if columns := LinkEditsTo(before[nptr],dst); columns != nil {
+			*edits = *LoggedLinkEdits(sst,before,columns)
+		}
+
+		return nil // or already gone
+	}
+
+	err := sst.STORE.EditNodes(DBContext(sst),sst,&edits)
+
+	UncacheNodes(sst,nptr)
+	return err
+}
+
+// **************************************************************************
+
+func LoggedLinkEdits(sst *PoSST,before ChangeSnapshot,edits []LinkEdit) *NodeEdits {
+
+	// The new link columns, with the changes they make to the nodes
+	// in the snapshot
+
+	after := before.Copy()
+
+	for _,e := range edits {
+		n := after[e.NPtr]
+		n.I[STTypeToSTIndex(e.STtype)] = e.Links
+		after[e.NPtr] = n
+	}
+
+	changes := ChangesBetween(before,after)
+	StampChanges(sst,changes)
+
+	return &NodeEdits{Links: edits,Changes: changes}
+}
+
+// **************************************************************************
+
+func NodeEditsBetween(before ChangeSnapshot,after map[NodePtr]Node) NodeEdits {
+
+	// What turns the nodes in the snapshot into the nodes after, where
+	// S="" means absent, as for ChangesBetween()
+
+	var edits NodeEdits
+
+	edits.Renamed = make(map[NodePtr]string)
+	edits.Chapters = make(map[NodePtr]string)
+	edits.Seqs = make(map[NodePtr]bool)
+
+	var nptrs []NodePtr
+
+	for nptr := range before {
+		nptrs = append(nptrs,nptr)
+	}
+
+	for nptr := range after {
+		if _,known := before[nptr]; !known {
+			nptrs = append(nptrs,nptr)
+		}
+	}
+
+	SortNodePtrs(nptrs)
+
+	for _,nptr := range nptrs {
+
+		old,now := before[nptr],after[nptr]
+
+		switch {
+		case old.S == "" && now.S == "":
+			continue
+		case old.S == "":
+			now.NPtr = nptr
+			edits.Added = append(edits.Added,now)
+			continue
+		case now.S == "":
+			edits.Deleted = append(edits.Deleted,nptr)
+			continue
+		}
+
+		if now.S != old.S {
+			edits.Renamed[nptr] = now.S
+		}
+
+		if now.Chap != old.Chap {
+			edits.Chapters[nptr] = now.Chap
+		}
+
+		if now.Seq != old.Seq {
+			edits.Seqs[nptr] = now.Seq
+		}
+
+		for stindex := 0; stindex < ST_TOP; stindex++ {
+			if !SameLinks(old.I[stindex],now.I[stindex]) {
+				edits.Links = append(edits.Links,LinkEdit{NPtr: nptr,STtype: STIndexToSTType(stindex),Links: now.I[stindex]})
+			}
+		}
+	}
+
+	return edits
+}
+
+// **************************************************************************
+
+func (edits NodeEdits) Touched() []NodePtr {
+
+	// The nodes whose cached copies are out of date afterwards
+
+	var nptrs []NodePtr
+
+	for _,n := range edits.Added {
+		nptrs = append(nptrs,n.NPtr)
+	}
+
+	for _,e := range edits.Links {
+		nptrs = append(nptrs,e.NPtr)
+	}
+
+	for _,bl := range edits.Appended {
+		nptrs = append(nptrs,bl.NPtr)
+	}
+
+	for _,changed := range []map[NodePtr]string{edits.Renamed,edits.Chapters} {
+		for nptr := range changed {
+			nptrs = append(nptrs,nptr)
+		}
+	}
+
+	for nptr := range edits.Seqs {
+		nptrs = append(nptrs,nptr)
+	}
+
+	return append(nptrs,edits.Deleted...)
+}
+
+// **************************************************************************
+
+func SameLinks(a,b []Link) bool {
+
+	// The same column, in the same order
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for l := range a {
+		if a[l] != b[l] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// **************************************************************************
+
+func SortNodePtrs(nptrs []NodePtr) {
+
+	sort.Slice(nptrs,func(i,j int) bool {
+		if nptrs[i].Class != nptrs[j].Class {
+			return nptrs[i].Class < nptrs[j].Class
+		}
+		return nptrs[i].CPtr < nptrs[j].CPtr
+	})
+}
+
+// **************************************************************************
+
+func LinkInList(links []Link,lnk Link) bool {
+
+	for _,prev := range links {
+		if prev == lnk {
+			return true
+		}
+	}
+
+	return false
+}
+
+// **************************************************************************
+// Postgres
+// **************************************************************************
+
+func (pg PostgresStore) SetLinks(ctx context.Context,sst *PoSST,nptr NodePtr,links []Link,sttype int) bool {
+
+	err := pg.EditNodes(ctx,sst,&NodeEdits{Links: []LinkEdit{{NPtr: nptr,STtype: sttype,Links: links}}})
+
+	if err != nil {
+		fmt.Println("Failed to set links",err)
+		return false
+	}
+
+	return true
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) EditNodes(ctx context.Context,sst *PoSST,edits *NodeEdits) error {
+
+	// Add nodes, replace link columns, names and chapters, re-point the
+	// page map, record merges and delete nodes, with their provenance and
+	// their places in the page map, all or nothing
+
+	tx,err := sst.DB.BeginTx(ctx,nil)
+
+	if err != nil {
+		return fmt.Errorf("%w: %v",ERR_EDIT_FAILED,err)
+	}
+
+	defer tx.Rollback()
+
+	if plan := edits.Plan; plan != nil {
+
+		read := func(nptrs ...NodePtr) (map[NodePtr]Node,error) {
+			return LockDBNodes(ctx,tx,nptrs)
+		}
+
+		if err = plan(read,edits); err != nil {
+			return err
+		}
+	}
+
+	exec := func(nptr NodePtr,qstr string) error {
+
+		_,err := tx.ExecContext(ctx,qstr)
+
+		if err != nil {
+			return fmt.Errorf("%w: (%d,%d) %v",ERR_EDIT_FAILED,nptr.Class,nptr.CPtr,err)
+		}
+
+		return nil
+	}
+
+	for _,n := range edits.Added {
+		if err = exec(n.NPtr,UploadNodeToDB(sst,n)); err != nil {
+			return err
+		}
+	}
+
+	for _,e := range edits.Links {
+
+		qstr := AppendDBLinkArrayToNode(sst,e.NPtr,FormatSQLLinkArray(e.Links),e.STtype)
+
+		if qstr == "" {
+			return fmt.Errorf("%w: (%d,%d)",ERR_EDIT_FAILED,e.NPtr.Class,e.NPtr.CPtr)
+		}
+
+		if err = exec(e.NPtr,qstr); err != nil {
+			return err
+		}
+	}
+
+	for _,bl := range edits.Appended {
+
+		if bl.STtype < -EXPRESS || bl.STtype > EXPRESS {
+			return fmt.Errorf("%w: %d",ERR_ST_OUT_OF_BOUNDS,bl.STtype)
+		}
+
+		if bl.NPtr == bl.Link.Dst {
+			continue
+		}
+
+		if err = exec(bl.NPtr,AppendDBLinkToNodeCommand(sst,bl.NPtr,bl.Link,bl.STtype)); err != nil {
+			return err
+		}
+	}
+
+	for nptr,name := range edits.Renamed {
+
+		// The NPtr stays the same, as links elsewhere point to it, so only
+		// names of the same class. The Search columns are regenerated
+
+		l,_ := StorageClass(name)
+		qstr := fmt.Sprintf("UPDATE Node SET S='%s',L=%d WHERE NPtr='(%d,%d)'::NodePtr",SQLEscape(name),l,nptr.Class,nptr.CPtr)
+
+		if err = exec(nptr,qstr); err != nil {
+			return err
+		}
+	}
+
+	for nptr,chap := range edits.Chapters {
+
+		qstr := fmt.Sprintf("UPDATE Node SET Chap='%s' WHERE NPtr='(%d,%d)'::NodePtr",SQLEscape(chap),nptr.Class,nptr.CPtr)
+
+		if err = exec(nptr,qstr); err != nil {
+			return err
+		}
+	}
+
+	for nptr,seq := range edits.Seqs {
+
+		qstr := fmt.Sprintf("UPDATE Node SET Seq=%t WHERE NPtr='(%d,%d)'::NodePtr",seq,nptr.Class,nptr.CPtr)
+
+		if err = exec(nptr,qstr); err != nil {
+			return err
+		}
+	}
+
+	for from,to := range edits.Repoint {
+
+		// Rewrite the path of every page map line that mentions from, in order
+
+		fromptr := fmt.Sprintf("'(%d,%d)'::NodePtr",from.Class,from.CPtr)
+		toptr := fmt.Sprintf("'(%d,%d)'::NodePtr",to.Class,to.CPtr)
+
+		qstr := fmt.Sprintf("UPDATE PageMap SET Path = ARRAY("+
+			"SELECT ROW(l.Arr,l.Wgt,l.Ctx,CASE WHEN l.Dst = %s THEN %s ELSE l.Dst END)::Link "+
+			"FROM unnest(Path) WITH ORDINALITY AS l(Arr,Wgt,Ctx,Dst,n) ORDER BY l.n) "+
+			"WHERE %s = ANY(SELECT p.Dst FROM unnest(Path) AS p)",fromptr,toptr,fromptr)
+
+		result,err := tx.ExecContext(ctx,qstr)
+
+		if err != nil {
+			return fmt.Errorf("%w: (%d,%d) %v",ERR_EDIT_FAILED,from.Class,from.CPtr,err)
+		}
+
+		count,_ := result.RowsAffected()
+		edits.Repointed += int(count)
+	}
+
+	for _,alias := range edits.Aliases {
+		if err = exec(alias.Alias,FormatSQLAlias(alias)); err != nil {
+			return err
+		}
+	}
+
+	for _,alias := range edits.Unaliased {
+
+		qstr := fmt.Sprintf("DELETE FROM NodeAlias WHERE Alias='(%d,%d)'::NodePtr",alias.Class,alias.CPtr)
+
+		if err = exec(alias,qstr); err != nil {
+			return err
+		}
+	}
+
+	for _,nptr := range edits.Deleted {
+
+		ptr := fmt.Sprintf("'(%d,%d)'::NodePtr",nptr.Class,nptr.CPtr)
+
+		qstr := fmt.Sprintf("DELETE FROM Node WHERE NPtr=%s;\n",ptr)
+		qstr += fmt.Sprintf("DELETE FROM Provenance WHERE NPtr=%s OR Dst=%s;\n",ptr,ptr)
+		qstr += fmt.Sprintf("UPDATE PageMap SET Path = ARRAY("+
+			"SELECT ROW(l.Arr,l.Wgt,l.Ctx,l.Dst)::Link "+
+			"FROM unnest(Path) WITH ORDINALITY AS l(Arr,Wgt,Ctx,Dst,n) WHERE l.Dst <> %s ORDER BY l.n) "+
+			"WHERE %s = ANY(SELECT p.Dst FROM unnest(Path) AS p)",ptr,ptr)
+
+		if err = exec(nptr,qstr); err != nil {
+			return err
+		}
+	}
+
+	for chap,lines := range edits.PageMaps {
+
+		qstr := fmt.Sprintf("DELETE FROM PageMap WHERE Chap='%s';\n",SQLEscape(chap))
+
+		for _,line := range lines {
+			qstr += FormatSQLPageMap(line)
+		}
+
+		if _,err = tx.ExecContext(ctx,qstr); err != nil {
+			return fmt.Errorf("%w: page map of %s %v",ERR_EDIT_FAILED,chap,err)
+		}
+	}
+
+	for file,records := range edits.Sources {
+
+		qstr := fmt.Sprintf("DELETE FROM Provenance WHERE File='%s';\n",SQLEscape(file))
+
+		for _,p := range records {
+			qstr += FormatSQLProvenance(p)
+		}
+
+		if _,err = tx.ExecContext(ctx,qstr); err != nil {
+			return fmt.Errorf("%w: provenance of %s %v",ERR_EDIT_FAILED,file,err)
+		}
+	}
+
+	edits.Revision,err = AppendSQLChanges(ctx,tx,edits.Changes)
+
+	if err != nil {
+		return fmt.Errorf("%w: change log %v",ERR_EDIT_FAILED,err)
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return fmt.Errorf("%w: %v",ERR_EDIT_FAILED,err)
+	}
+
+	return nil
+}
+
+// **************************************************************************
+
+func LockDBNodes(ctx context.Context,tx *sql.Tx,nptrs []NodePtr) (map[NodePtr]Node,error) {
+
+	// Read the nodes FOR UPDATE, in a fixed order so that two edits
+	// locking the same nodes do not deadlock
+
+	var nodes = make(map[NodePtr]Node)
+	var ptrs []string
+
+	for _,nptr := range nptrs {
+		nodes[nptr] = Node{NPtr: nptr}
+		ptrs = append(ptrs,fmt.Sprintf("'(%d,%d)'::NodePtr",nptr.Class,nptr.CPtr))
+	}
+
+	if len(ptrs) == 0 {
+		return nodes,nil
+	}
+
+	cols := I_MEXPR+","+I_MCONT+","+I_MLEAD+","+I_NEAR +","+I_PLEAD+","+I_PCONT+","+I_PEXPR
+	qstr := fmt.Sprintf("SELECT NPtr,L,S,Chap,coalesce(Seq,false),%s FROM Node WHERE NPtr IN (%s) AND NOT L=0 "+
+		"ORDER BY (NPtr).Chan,(NPtr).CPtr FOR UPDATE",cols,strings.Join(ptrs,","))
+
+	row,err := tx.QueryContext(ctx,qstr)
+
+	if err != nil {
+		return nil,fmt.Errorf("%w: %v",ERR_EDIT_FAILED,err)
+	}
+
+	defer row.Close()
+
+	for row.Next() {
+
+		var n Node
+		var nptr string
+		var whole [ST_TOP]string
+
+		err = row.Scan(&nptr,&n.L,&n.S,&n.Chap,&n.Seq,&whole[0],&whole[1],&whole[2],&whole[3],&whole[4],&whole[5],&whole[6])
+
+		if err != nil {
+			return nil,fmt.Errorf("%w: %v",ERR_EDIT_FAILED,err)
+		}
+
+		fmt.Sscanf(nptr,"(%d,%d)",&n.NPtr.Class,&n.NPtr.CPtr)
+
+		for i := 0; i < ST_TOP; i++ {
+			n.I[i] = ParseLinkArray(whole[i])
+		}
+
+		nodes[n.NPtr] = n
+	}
+
+	if err = row.Err(); err != nil {
+		return nil,fmt.Errorf("%w: %v",ERR_EDIT_FAILED,err)
+	}
+
+	return nodes,nil
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) RenameNode(ctx context.Context,sst *PoSST,nptr NodePtr,name string) bool {
+
+	err := pg.EditNodes(ctx,sst,&NodeEdits{Renamed: map[NodePtr]string{nptr: name}})
+
+	if err != nil {
+		fmt.Println("Failed to rename node",err)
+		return false
+	}
+
+	return true
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) DeleteNode(ctx context.Context,sst *PoSST,nptr NodePtr) bool {
+
+	err := pg.EditNodes(ctx,sst,&NodeEdits{Deleted: []NodePtr{nptr}})
+
+	if err != nil {
+		fmt.Println("Failed to delete node",err)
+		return false
+	}
+
+	return true
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) SetNodeChapter(ctx context.Context,sst *PoSST,nptr NodePtr,chap string) bool {
+
+	err := pg.EditNodes(ctx,sst,&NodeEdits{Chapters: map[NodePtr]string{nptr: chap}})
+
+	if err != nil {
+		fmt.Println("Failed to set chapter",err)
+		return false
+	}
+
+	return true
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) SetNodeSeq(ctx context.Context,sst *PoSST,nptr NodePtr,seq bool) bool {
+
+	err := pg.EditNodes(ctx,sst,&NodeEdits{Seqs: map[NodePtr]bool{nptr: seq}})
+
+	if err != nil {
+		fmt.Println("Failed to set sequence status",err)
+		return false
+	}
+
+	return true
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) RepointPageMap(ctx context.Context,sst *PoSST,from,to NodePtr) int {
+
+	// Returns the number of page map lines changed
+
+	edits := NodeEdits{Repoint: map[NodePtr]NodePtr{from: to}}
+
+	err := pg.EditNodes(ctx,sst,&edits)
+
+	if err != nil {
+		fmt.Println("Failed to re-point page map",err)
+		return 0
+	}
+
+	return edits.Repointed
+}
+
+//
+// db_editing.go
+//
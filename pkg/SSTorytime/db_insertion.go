@@ -7,8 +7,8 @@
 package SSTorytime
 
 import (
+	"context"
 	"fmt"
-	"os"
 	"strings"
 	_ "github.com/lib/pq"
 
@@ -47,6 +47,13 @@ func IdempDBAddNode(sst *PoSST,n Node) Node {
 	// We use this function when we aren't counting CPtr values
 	// This functon may be deprecated in future
 
+	return sst.STORE.IdempAddNode(DBContext(sst),sst,n)
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) IdempAddNode(ctx context.Context,sst *PoSST,n Node) Node {
+
 	var qstr string
 
 	// No need to trust the values, ignore/overwrite CPtr
@@ -56,11 +63,30 @@ func IdempDBAddNode(sst *PoSST,n Node) Node {
 	es := SQLEscape(n.S)
 	ec := SQLEscape(n.Chap)
 
-	// Wrap BEGIN/END a single transaction
+	// Wrap BEGIN/END a single transaction, with the change log if
+	// the node is new
+
+	tx,err := sst.DB.BeginTx(ctx,nil)
+
+	if err != nil {
+		fmt.Println("Failed to add node",err)
+		return n
+	}
+
+	defer tx.Rollback()
+
+	var known bool
+
+	qstr = fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM Node WHERE S='%s')",es)
+
+	if err = tx.QueryRowContext(ctx,qstr).Scan(&known); err != nil {
+		fmt.Println("Failed to add node",err,qstr)
+		return n
+	}
 
 	qstr = fmt.Sprintf("SELECT IdempAppendNode(%d,%d,'%s','%s')",n.L,n.NPtr.Class,es,ec)
 
-	row,err := sst.DB.Query(qstr)
+	row,err := tx.QueryContext(ctx,qstr)
 	
 	if err != nil {
 		s := fmt.Sprint("Failed to add node",err)
@@ -85,6 +111,19 @@ func IdempDBAddNode(sst *PoSST,n Node) Node {
 		n.NPtr.CPtr = ClassedNodePtr(ch)
 		
 		row.Close()
+		err = row.Err()
+	}
+
+	if !known && err == nil {
+		_,err = AppendSQLChanges(ctx,tx,AddedNodeChanges(sst,n))
+	}
+
+	if err == nil {
+		err = tx.Commit()
+	}
+
+	if err != nil {
+		fmt.Println("Failed to add node",n.S,err)
 	}
 
 	return n
@@ -92,51 +131,110 @@ func IdempDBAddNode(sst *PoSST,n Node) Node {
 
 // **************************************************************************
 
-func IdempDBAddLink(sst *PoSST,from Node,link Link,to Node) {
+func IdempDBAddLink(sst *PoSST,from Node,link Link,to Node) error {
 
 	// API Entry point for registering links
 
-	frptr := from.NPtr
-	toptr := to.NPtr
+	return IdempDBAddLinks(DBContext(sst),sst,[]Node{from},[]Link{link},[]Node{to})
+}
+
+// **************************************************************************
 
-	link.Dst = toptr // it might have changed, so override
+func IdempDBAddLinks(ctx context.Context,sst *PoSST,from []Node,links []Link,to []Node) error {
 
-	if frptr == toptr {
-		fmt.Println("Self-loops are not allowed",from.S,from,link,to)
-		os.Exit(-1)
-	}
+	// Each link from[i] -> to[i], with its inverse, as one edit logged
+	// with the change log, all or nothing. The queries run under ctx
+
+	arrows := GetArrowDirectory(sst)
+
+	var appended []BatchLink
+	var nptrs []NodePtr
 
-	if link.Arr < 0 || len(sst.ARROW_DIRECTORY) == 0 {
-		fmt.Println("No arrows have yet been defined, so you can't rely on the arrow names")
-		os.Exit(-1)
+	for i,link := range links {
+
+		frptr := from[i].NPtr
+		toptr := to[i].NPtr
+
+		link.Dst = toptr // it might have changed, so override
+
+		if frptr == toptr {
+			return fmt.Errorf("%w: %s",ERR_SELF_LOOP,from[i].S)
+		}
+
+		if link.Arr < 0 || len(arrows) == 0 {
+			return ERR_NO_ARROWS
+		}
+
+		if int(link.Arr) >= len(arrows) {
+			return fmt.Errorf("%w: (%d)",ERR_NO_SUCH_ARROW,link.Arr)
+		}
+
+		if link.Wgt == 0 {
+			return ERR_ZERO_WEIGHT
+		}
+
+		sttype := STIndexToSTType(arrows[link.Arr].STAindex)
+
+		// Double up the reverse definition for easy indexing of both in/out arrows
+		// But be careful not the make the graph undirected by mistake
+
+		var invlink Link
+		invlink.Arr = GetInverseArrow(sst,link.Arr)
+		invlink.Wgt = link.Wgt
+		invlink.Ctx = link.Ctx
+		invlink.Dst = frptr
+
+		appended = append(appended,BatchLink{NPtr: frptr,Link: link,STtype: sttype})
+		appended = append(appended,BatchLink{NPtr: toptr,Link: invlink,STtype: -sttype})
+		nptrs = append(nptrs,frptr,toptr)
 	}
 
-	if link.Wgt == 0 {
-		fmt.Println("Attempt to register a link with zero weight is pointless")
-		os.Exit(-1)
+	before := SnapshotNodesWith(ctx,sst,nptrs...)
+	after := before.Copy()
+
+	for _,bl := range appended {
+
+		n := after[bl.NPtr]
+
+		if n.S == "" {
+			return fmt.Errorf("%w: (%d,%d)",ERR_NO_SUCH_NODE,bl.NPtr.Class,bl.NPtr.CPtr)
+		}
+
+		stindex := STTypeToSTIndex(bl.STtype)
+		n.I[stindex] = AppendNewLink(n.I[stindex],bl.Link)
+		after[bl.NPtr] = n
 	}
 
-	sttype := STIndexToSTType(sst.ARROW_DIRECTORY[link.Arr].STAindex)
+	var edits NodeEdits
 
-	AppendDBLinkToNode(sst,frptr,link,sttype)
+	edits.Appended = appended
+	edits.Changes = ChangesBetween(before,after)
+	StampChanges(sst,edits.Changes)
 
-	// Double up the reverse definition for easy indexing of both in/out arrows
-	// But be careful not the make the graph undirected by mistake
+	err := sst.STORE.EditNodes(ctx,sst,&edits)
 
-	var invlink Link
-	invlink.Arr = sst.INVERSE_ARROWS[link.Arr]
-	invlink.Wgt = link.Wgt
-	invlink.Dst = frptr
-	AppendDBLinkToNode(sst,toptr,invlink,-sttype)
+	UncacheNodes(sst,nptrs...)
+	return err
 }
 
 // **************************************************************************
 
 func AppendDBLinkToNode(sst *PoSST, n1ptr NodePtr, lnk Link, sttype int) bool {
 
+	return sst.STORE.AppendLink(DBContext(sst),sst,n1ptr,lnk,sttype)
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) AppendLink(ctx context.Context,sst *PoSST, n1ptr NodePtr, lnk Link, sttype int) bool {
+
 	qstr := AppendDBLinkToNodeCommand(sst,n1ptr,lnk,sttype)
 
-	row,err := sst.DB.Query(qstr)
+	if qstr == "" {
+		return false
+	}
+
+	row,err := sst.DB.QueryContext(ctx,qstr)
 
 	if err != nil {
 		fmt.Println("Failed to append",err,qstr)
@@ -155,7 +253,7 @@ func AppendDBLinkToNodeCommand(sst *PoSST, n1ptr NodePtr, lnk Link, sttype int)
 
 	if sttype < -EXPRESS || sttype > EXPRESS {
 		fmt.Println(ERR_ST_OUT_OF_BOUNDS,sttype)
-		os.Exit(-1)
+		return ""
 	}
 
 	if n1ptr == lnk.Dst {
@@ -167,7 +265,12 @@ func AppendDBLinkToNodeCommand(sst *PoSST, n1ptr NodePtr, lnk Link, sttype int)
 
 	literal := fmt.Sprintf("%s::Link",linkval)
 
-	link_table := STTypeDBChannel(sttype)
+	link_table,err := STTypeDBChannel(sttype)
+
+	if err != nil {
+		fmt.Println(err)
+		return ""
+	}
 
 	qstr := fmt.Sprintf("UPDATE NODE SET %s=array_append(%s,%s) WHERE (NPtr).CPtr = '%d' AND (NPtr).Chan = '%d' AND (%s IS NULL OR NOT %s = ANY(%s));\n",
 		link_table,
@@ -190,10 +293,15 @@ func AppendDBLinkArrayToNode(sst *PoSST, nptr NodePtr, array string, sttype int)
 
 	if sttype < -EXPRESS || sttype > EXPRESS {
 		fmt.Println(ERR_ST_OUT_OF_BOUNDS,sttype)
-		os.Exit(-1)
+		return ""
 	}
 
-	link_table := STTypeDBChannel(sttype)
+	link_table,err := STTypeDBChannel(sttype)
+
+	if err != nil {
+		fmt.Println(err)
+		return ""
+	}
 
 	qstr := fmt.Sprintf("UPDATE NODE SET %s='%s' WHERE (NPtr).CPtr = '%d' AND (NPtr).Chan = '%d';\n",
 		link_table,
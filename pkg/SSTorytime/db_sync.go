@@ -0,0 +1,593 @@
+//**************************************************************
+//
+// db_sync.go
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	_ "github.com/lib/pq"
+
+)
+
+//**************************************************************
+// Incremental upload of a re-parsed N4L file. Instead of removing
+// its chapters and uploading everything again, compare the parsed
+// graph with what is stored for the same chapters, and apply only
+// the difference. A node whose text is unchanged keeps its NodePtr,
+// so LastSeen history, bookmarks and links from other chapters
+// that refer to it still hold.
+//
+// A link belongs to the upload when both ends are in the chapters
+// being synced and at least one of them is in no other chapter.
+// Links between two nodes shared with other chapters are added but
+// never removed, as another file may have written them.
+//**************************************************************
+
+type SyncReport struct {
+
+	Chapters       []string
+	Added          int  // nodes new to the database
+	Deleted        int  // nodes that were only in these chapters
+	Detached       int  // nodes still in other chapters, no longer in these
+	Changed        int  // nodes that kept their NodePtr, with new links or chapters
+	Unchanged      int
+	LinksAdded     int  // link entries, each link is stored on both its nodes
+	LinksRemoved   int
+	PageMapAdded   int
+	PageMapRemoved int
+}
+
+//**************************************************************
+
+type SyncState struct {
+
+	chapters  string                  // comma separated, as in Node.Chap
+	remap     map[NodePtr]NodePtr     // parsed NodePtr -> stored NodePtr
+	parsed    map[NodePtr]Node        // by stored NodePtr, with links re-pointed
+	stored    map[NodePtr]Node        // as in the database before the sync
+	exclusive map[NodePtr]bool        // in no chapter except those being synced
+}
+
+// **************************************************************************
+
+func SyncGraphToDB(sst PoSST,out io.Writer) (SyncReport,error) {
+
+	// The counterpart of GraphToDB, for nodes parsed after SynchronizeNPtrs().
+	// Progress goes to out, if not nil
+
+	if out == nil {
+		out = io.Discard
+	}
+
+	var report SyncReport
+	var state SyncState
+
+	nodes := ParsedNodes(&sst)
+
+	report.Chapters = ParsedChapters(nodes,sst.PAGE_MAP)
+	state.chapters = strings.Join(report.Chapters,",")
+	state.remap = SyncNodePtrs(&sst,nodes)
+	state.parsed = make(map[NodePtr]Node)
+	state.stored = make(map[NodePtr]Node)
+	state.exclusive = make(map[NodePtr]bool)
+
+	for _,n := range nodes {
+
+		n.NPtr = state.remap[n.NPtr]
+
+		for stindex := 0; stindex < ST_TOP; stindex++ {
+
+			var links []Link
+
+			for _,lnk := range n.I[stindex] {
+				lnk.Dst = SyncRemap(state.remap,lnk.Dst)
+				links = append(links,lnk)
+			}
+
+			n.I[stindex] = links
+		}
+
+		state.parsed[n.NPtr] = n
+	}
+
+	// What the database holds now, for these chapters and for any
+	// parsed node that was already stored under another chapter
+
+	for _,chap := range report.Chapters {
+		for _,nptr := range sst.STORE.GetNodePtrsByChapter(DBContext(&sst),&sst,chap) {
+			state.stored[nptr],_ = sst.STORE.GetNode(DBContext(&sst),&sst,nptr)
+		}
+	}
+
+	for nptr := range state.parsed {
+		if _,known := state.stored[nptr]; !known {
+			n,_ := sst.STORE.GetNode(DBContext(&sst),&sst,nptr)
+
+			if n.S != "" {
+				state.stored[nptr] = n
+			}
+		}
+	}
+
+	for nptr := range state.parsed {
+		state.exclusive[nptr] = true
+	}
+
+	for nptr,n := range state.stored {
+		state.exclusive[nptr] = SyncOnlyInChapters(n.Chap,state.chapters)
+	}
+
+	// Every node the sync might touch, as one revision of the change log
+
+	var touched []NodePtr
+
+	for nptr,n := range state.parsed {
+		touched = append(touched,nptr)
+		for nbr := range LinkedNodes(n) {
+			touched = append(touched,nbr)
+		}
+	}
+
+	for nptr := range state.stored {
+		touched = append(touched,nptr)
+	}
+
+	before := SnapshotNeighbourhood(&sst,touched...)
+
+	// Arrows and contexts first, so that every link can refer to them.
+	// These are only declarations, and are kept even if the rest fails
+
+	fmt.Fprintln(out,"Storing arrows and contexts...")
+
+	sst.STORE.UploadArrows(DBContext(&sst),&sst)
+	UploadContextsToDB(&sst)
+
+	// Work out the whole difference, then store it in one transaction,
+	// so that a failure leaves the database as it was
+
+	after := before.Copy()
+
+	for nptr,n := range state.parsed {
+		if _,known := state.stored[nptr]; !known {
+			after[nptr] = n
+			report.LinksAdded += SyncCountLinks(n)
+			report.Added++
+		}
+	}
+
+	var deleted []NodePtr
+
+	for nptr,old := range state.stored {
+
+		SyncStoredNode(&state,nptr,old,after,&report)
+
+		if _,ok := state.parsed[nptr]; !ok && state.exclusive[nptr] {
+			deleted = append(deleted,nptr)
+		}
+	}
+
+	for _,nptr := range deleted {
+
+		// Links to nodes outside the sync were not covered above
+
+		for other := range LinkedNodes(state.stored[nptr]) {
+			if _,inside := state.stored[other]; !inside {
+				n := after[other]
+				RemoveLinksTo(&n,nptr)
+				after[other] = n
+			}
+		}
+
+		after[nptr] = Node{NPtr: nptr}
+		report.Deleted++
+	}
+
+	edits := LoggedEditsBetween(&sst,before,after)
+	edits.PageMaps = make(map[string][]PageMap)
+
+	for _,chap := range report.Chapters {
+		SyncPageMap(&sst,&state,chap,&edits,&report)
+	}
+
+	edits.Sources = SyncProvenance(&sst,&state)
+
+	fmt.Fprintln(out,"Storing changes...")
+
+	if err := sst.STORE.EditNodes(DBContext(&sst),&sst,&edits); err != nil {
+		return report,err
+	}
+
+	UncacheNodes(&sst,edits.Touched()...)
+
+	for _,nptr := range deleted {
+		ForgetNode(&sst,state.stored[nptr])
+	}
+
+	fmt.Fprintln(out,"Indexing ....")
+
+	sst.STORE.Finalize(DBContext(&sst),&sst)
+
+	return report,nil
+}
+
+// **************************************************************************
+
+func SyncStoredNode(state *SyncState,nptr NodePtr,old Node,after map[NodePtr]Node,report *SyncReport) {
+
+	// Bring one stored node into line with its parsed version, if any
+
+	n,parsed := state.parsed[nptr]
+
+	if !parsed && state.exclusive[nptr] {
+		report.LinksRemoved += SyncCountLinks(old)
+		return // to be deleted
+	}
+
+	changed := false
+	now := after[nptr]
+
+	for stindex := 0; stindex < ST_TOP; stindex++ {
+
+		var links []Link
+
+		for _,lnk := range old.I[stindex] {
+			if !SyncOwnsLink(state,nptr,lnk.Dst) {
+				links = append(links,lnk)
+			}
+		}
+
+		for _,lnk := range n.I[stindex] {
+			if !LinkInList(links,lnk) {
+				links = append(links,lnk)
+			}
+		}
+
+		add,remove := SyncLinkDifference(old.I[stindex],links)
+
+		if add+remove == 0 {
+			continue
+		}
+
+		now.I[stindex] = links
+
+		report.LinksAdded += add
+		report.LinksRemoved += remove
+		changed = true
+	}
+
+	// Keep the chapters that came from elsewhere
+
+	var others []string
+
+	for _,c := range strings.Split(old.Chap,",") {
+		if c != "" && !InChapterList(state.chapters,c) {
+			others = append(others,c)
+		}
+	}
+
+	now.Chap = MergeChapterLists(strings.Join(others,","),n.Chap)
+	now.Seq = n.Seq || (old.Seq && !state.exclusive[nptr])
+
+	if now.Chap != old.Chap || now.Seq != old.Seq {
+		changed = true
+	}
+
+	after[nptr] = now
+
+	switch {
+	case !parsed:
+		report.Detached++
+	case changed:
+		report.Changed++
+	default:
+		report.Unchanged++
+	}
+}
+
+// **************************************************************************
+
+func SyncPageMap(sst *PoSST,state *SyncState,chap string,edits *NodeEdits,report *SyncReport) {
+
+	// Page map lines have no identity beyond their content, so if a
+	// chapter's lines differ at all, replace them
+
+	var before = make(map[string]int)
+	var after = make(map[string]int)
+	var lines []PageMap
+
+	for _,event := range GetDBPageMap(CopySession(sst),chap,nil,1,EXPORT_PAGEMAP_LIMIT) {
+		if event.Chapter == chap {
+			before[SyncPageMapKey(event)]++
+		}
+	}
+
+	for _,event := range sst.PAGE_MAP {
+
+		if event.Chapter != chap {
+			continue
+		}
+
+		var path []Link
+
+		for _,lnk := range event.Path {
+			lnk.Dst = SyncRemap(state.remap,lnk.Dst)
+			path = append(path,lnk)
+		}
+
+		event.Path = path
+
+		key := SyncPageMapKey(event)
+
+		if after[key] == 0 {
+			lines = append(lines,event)
+		}
+
+		after[key]++
+	}
+
+	var add,remove int
+
+	for key := range after {
+		if before[key] == 0 {
+			add++
+		}
+	}
+
+	for key := range before {
+		if after[key] == 0 {
+			remove++
+		}
+	}
+
+	if add+remove == 0 {
+		return
+	}
+
+	edits.PageMaps[chap] = lines
+
+	report.PageMapAdded += add
+	report.PageMapRemoved += remove
+}
+
+// **************************************************************************
+
+func SyncProvenance(sst *PoSST,state *SyncState) map[string][]Provenance {
+
+	// The parsed files are read afresh, so replace all their records
+
+	var records []Provenance
+	var files = make(map[string][]Provenance)
+
+	for _,p := range sst.PROVENANCE {
+		p.NPtr = SyncRemap(state.remap,p.NPtr)
+		p.Dst = SyncRemap(state.remap,p.Dst)
+		records = append(records,p)
+	}
+
+	for _,p := range FirstProvenance(records) {
+		files[p.File] = append(files[p.File],p)
+	}
+
+	return files
+}
+
+// **************************************************************************
+
+func ParsedNodes(sst *PoSST) []Node {
+
+	// The nodes in memory that are not placeholders for stored ones
+
+	var nodes []Node
+
+	for class := N1GRAM; class <= GT1024; class++ {
+
+		var directory []Node
+
+		switch class {
+		case N1GRAM:
+			directory = sst.NODE_DIRECTORY.N1directory
+		case N2GRAM:
+			directory = sst.NODE_DIRECTORY.N2directory
+		case N3GRAM:
+			directory = sst.NODE_DIRECTORY.N3directory
+		case LT128:
+			directory = sst.NODE_DIRECTORY.LT128directory
+		case LT1024:
+			directory = sst.NODE_DIRECTORY.LT1024
+		case GT1024:
+			directory = sst.NODE_DIRECTORY.GT1024
+		}
+
+		for _,n := range directory {
+			if n.S != "" {
+				nodes = append(nodes,n)
+			}
+		}
+	}
+
+	return nodes
+}
+
+// **************************************************************************
+
+func ParsedChapters(nodes []Node,pagemap []PageMap) []string {
+
+	var chapters = make(map[string]bool)
+
+	for _,n := range nodes {
+		for _,c := range strings.Split(n.Chap,",") {
+			if c != "" {
+				chapters[c] = true
+			}
+		}
+	}
+
+	for _,event := range pagemap {
+		if event.Chapter != "" {
+			chapters[event.Chapter] = true
+		}
+	}
+
+	var list []string
+
+	for c := range chapters {
+		list = append(list,c)
+	}
+
+	sort.Strings(list)
+	return list
+}
+
+// **************************************************************************
+
+func SyncNodePtrs(sst *PoSST,nodes []Node) map[NodePtr]NodePtr {
+
+	// Stored nodes are matched by their text, which is unique. New nodes
+	// are numbered on from the top of their channel
+
+	var remap = make(map[NodePtr]NodePtr)
+	var next = make(map[int]ClassedNodePtr)
+
+	for _,n := range nodes {
+
+		found := sst.STORE.GetNodePtrsByName(DBContext(sst),sst,n.S)
+
+		if len(found) > 0 {
+			remap[n.NPtr] = found[0]
+			continue
+		}
+
+		class := n.NPtr.Class
+
+		if _,ok := next[class]; !ok {
+			next[class] = sst.STORE.GetTopCPtr(DBContext(sst),sst,class)
+
+			var top NodePtr
+			top.Class = class
+			top.CPtr = next[class]
+
+			if stored,_ := sst.STORE.GetNode(DBContext(sst),sst,top); stored.S != "" {
+				next[class]++
+			}
+		}
+
+		var nptr NodePtr
+		nptr.Class = class
+		nptr.CPtr = next[class]
+		next[class]++
+
+		remap[n.NPtr] = nptr
+	}
+
+	return remap
+}
+
+// **************************************************************************
+
+func SyncRemap(remap map[NodePtr]NodePtr,nptr NodePtr) NodePtr {
+
+	// Context links point nowhere, and are left as they are
+
+	if to,ok := remap[nptr]; ok {
+		return to
+	}
+
+	return nptr
+}
+
+// **************************************************************************
+
+func SyncOwnsLink(state *SyncState,from,to NodePtr) bool {
+
+	if to == NONODE {
+		return state.exclusive[from]
+	}
+
+	_,known := state.stored[to]
+	_,parsed := state.parsed[to]
+
+	if !known && !parsed {
+		return false
+	}
+
+	return state.exclusive[from] || state.exclusive[to]
+}
+
+// **************************************************************************
+
+func SyncOnlyInChapters(chaps,synced string) bool {
+
+	for _,c := range strings.Split(chaps,",") {
+		if c != "" && !InChapterList(synced,c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// **************************************************************************
+
+func SyncLinkDifference(before,after []Link) (int,int) {
+
+	var add,remove int
+
+	for _,lnk := range after {
+		if !LinkInList(before,lnk) {
+			add++
+		}
+	}
+
+	for _,lnk := range before {
+		if !LinkInList(after,lnk) {
+			remove++
+		}
+	}
+
+	return add,remove
+}
+
+// **************************************************************************
+
+func SyncCountLinks(n Node) int {
+
+	var count int
+
+	for stindex := 0; stindex < ST_TOP; stindex++ {
+		count += len(n.I[stindex])
+	}
+
+	return count
+}
+
+// **************************************************************************
+
+func SyncPageMapKey(event PageMap) string {
+
+	return fmt.Sprintf("%s/%s/%d/%d/%v",event.Chapter,event.Alias,event.Context,event.Line,event.Path)
+}
+
+// **************************************************************************
+
+func FormatSyncReport(report SyncReport) string {
+
+	var s string
+
+	s += fmt.Sprintf(" - chapters: %s\n",strings.Join(report.Chapters,", "))
+	s += fmt.Sprintf(" - nodes added: %d, deleted: %d, changed: %d, unchanged: %d\n",report.Added,report.Deleted,report.Changed,report.Unchanged)
+	s += fmt.Sprintf(" - nodes kept for other chapters only: %d\n",report.Detached)
+	s += fmt.Sprintf(" - link entries added: %d, removed: %d\n",report.LinksAdded,report.LinksRemoved)
+	s += fmt.Sprintf(" - page map lines added: %d, removed: %d\n",report.PageMapAdded,report.PageMapRemoved)
+
+	return s
+}
+
+//
+// db_sync.go
+//
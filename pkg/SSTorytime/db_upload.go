@@ -7,8 +7,9 @@
 package SSTorytime
 
 import (
+	"context"
         "fmt"
-        "os"
+	"io"
 	"strings"
 	_ "github.com/lib/pq"
 
@@ -16,84 +17,104 @@ import (
 
 //**************************************************************
 
-func GraphToDB(sst PoSST,wait_counter bool) {
+const NODE_INSERT_COLS = "(NPtr.Chan,NPtr.Cptr,L,S,Chap,Seq," +
+	I_MEXPR + "," + I_MCONT + "," + I_MLEAD + "," + I_NEAR + "," + I_PLEAD + "," + I_PCONT + "," + I_PEXPR + ")"
 
-	fmt.Println(".  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  ")	
-	fmt.Println("\nStoring primary nodes ...")
+//**************************************************************
+
+func GraphToDB(sst PoSST,out io.Writer) error {
+
+	// Arrows and contexts are added if new, then the nodes, page map,
+	// change log and provenance are stored together or not at all.
+	// Progress goes to out, if not nil
+
+	if out == nil {
+		out = io.Discard
+	}
+
+	fmt.Fprintln(out,".  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  ")	
+	fmt.Fprintln(out,"Storing Arrows...")
+
+	sst.STORE.UploadArrows(DBContext(&sst),&sst)
+
+	fmt.Fprintln(out,"Storing contexts...")
+
+	UploadContextsToDB(&sst)
+
+	fmt.Fprintln(out,".  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  ")	
+	fmt.Fprintln(out,"\nStoring primary nodes and page map ...")
+
+	// Nodes above the high water mark are new
+
+	var nodes []Node
 
 	for class := N1GRAM; class <= GT1024; class++ {
 		
 		offset := int(sst.HWM[class])
 
+		var directory []Node
+
 		switch class {
 		case N1GRAM:
-			UploadNodesBatch(&sst, sst.NODE_DIRECTORY.N1directory[offset:])
+			directory = sst.NODE_DIRECTORY.N1directory
 		case N2GRAM:
-			UploadNodesBatch(&sst, sst.NODE_DIRECTORY.N2directory[offset:])
+			directory = sst.NODE_DIRECTORY.N2directory
 		case N3GRAM:
-			UploadNodesBatch(&sst, sst.NODE_DIRECTORY.N3directory[offset:])
+			directory = sst.NODE_DIRECTORY.N3directory
 		case LT128:
-			UploadNodesBatch(&sst, sst.NODE_DIRECTORY.LT128directory[offset:])
+			directory = sst.NODE_DIRECTORY.LT128directory
 		case LT1024:
-			UploadNodesBatch(&sst, sst.NODE_DIRECTORY.LT1024[offset:])
+			directory = sst.NODE_DIRECTORY.LT1024
 		case GT1024:
-			UploadNodesBatch(&sst, sst.NODE_DIRECTORY.GT1024[offset:])
+			directory = sst.NODE_DIRECTORY.GT1024
 		}
 
+		for _,n := range directory[offset:] {
+			if n.S != "" { // not a placeholder from SynchronizeNPtrs
+				nodes = append(nodes,n)
+			}
+		}
 	}
-	
-	// Arrows etc
 
-	fmt.Println(".  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  ")	
-	fmt.Println("Storing Arrows...")
+	var record BatchRecord
 
-	sst.DB.QueryRow("drop table ArrowDirectory")
-	sst.DB.QueryRow("drop table ArrowInverses")
-
-	if !CreateTable(sst,ARROW_INVERSES_TABLE) {
-		fmt.Println("Unable to create table as, ",ARROW_INVERSES_TABLE)
-		os.Exit(-1)
-	}
-	if !CreateTable(sst,ARROW_DIRECTORY_TABLE) {
-		fmt.Println("Unable to create table as, ",ARROW_DIRECTORY_TABLE)
-		os.Exit(-1)
+	for _,n := range nodes {
+		record.Changes = append(record.Changes,NodeChanges(Node{},n)...)
 	}
 
-	UploadArrowsToDB(sst)
+	record.Sources = FirstProvenance(sst.PROVENANCE)
+	record.PageMap = sst.PAGE_MAP
 
-	fmt.Println("Storing inverse Arrows...")
-
-	UploadInverseArrowsToDB(sst)
+	StampChanges(&sst,record.Changes)
 
-	fmt.Println("Storing contexts...")
+	err := sst.STORE.UploadBatch(DBContext(&sst),&sst,nodes,nil,nil,nil,record)
 
-	UploadContextsToDB(&sst)
-
-	fmt.Println("Storing page map...")
+	if err != nil {
+		return err
+	}
 
-	UploadPageMapBatch(&sst, sst.PAGE_MAP)
-	
 	// CREATE INDICES
 	
-	fmt.Println(".  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  ")	
-	fmt.Println("Indexing ....")
-
-	Waiting()
+	fmt.Fprintln(out,".  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  .  ")	
+	fmt.Fprintln(out,"Indexing ....")
 
-	sst.DB.QueryRow("CREATE INDEX IF NOT EXISTS sst_gin on Node USING GIN (to_tsvector('english',Search))")
-	sst.DB.QueryRow("CREATE INDEX IF NOT EXISTS sst_ungin on Node USING GIN (to_tsvector('english',UnSearch))")
-	sst.DB.QueryRow("CREATE INDEX IF NOT EXISTS sst_s on Node USING GIN (S)")
-	sst.DB.QueryRow("CREATE INDEX IF NOT EXISTS sst_n on Node USING GIN (NPtr)")
-	sst.DB.QueryRow("CREATE INDEX IF NOT EXISTS sst_cnt on ContextDirectory USING GIN (Context)")
-	sst.DB.QueryRow("ALTER TABLE Node SET LOGGED")
-	sst.DB.QueryRow("ALTER TABLE PageMap SET LOGGED")
+	WaitingTo(out)
 
+	sst.STORE.Finalize(DBContext(&sst),&sst)
+	return nil
 }
 
 // **************************************************************************
 
 func BookmarksToDB(sst PoSST,marks map[string]string) {
 
+	sst.STORE.UploadBookmarks(DBContext(&sst),&sst,marks)
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) UploadBookmarks(ctx context.Context,sst *PoSST,marks map[string]string) {
+
 	qstr := ""
 	
 	for b, q := range marks {
@@ -101,7 +122,7 @@ func BookmarksToDB(sst PoSST,marks map[string]string) {
 		qstr += fmt.Sprintf("INSERT INTO Bookmarks (Bookmark,Query) VALUES ('%s','%s');\n",SQLEscape(b),SQLEscape(q))
 	}
 
-	DBCommit(&sst,qstr)
+	DBCommitWith(ctx,sst,qstr)
 }
 
 
@@ -111,6 +132,13 @@ func BookmarksToDB(sst PoSST,marks map[string]string) {
 
 func UploadNodesBatch(sst *PoSST, nodes []Node) {
 
+	sst.STORE.UploadNodes(DBContext(sst),sst,nodes)
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) UploadNodes(ctx context.Context,sst *PoSST, nodes []Node) {
+
 	const chunk = 200
 
 	var qstr string
@@ -118,20 +146,27 @@ func UploadNodesBatch(sst *PoSST, nodes []Node) {
 	for i := 0; i < len(nodes); i++ {
 	
 		if (i > 0 && i % chunk == 0) {
-			DBCommit(sst,qstr)
+			DBCommitWith(ctx,sst,qstr)
 			qstr = ""
 		}
 
 		qstr += UploadNodeToDB(sst,nodes[i])
 	}
 
-	DBCommit(sst,qstr)
+	DBCommitWith(ctx,sst,qstr)
 }
 
 // **************************************************************************
 
 func DBCommit(sst *PoSST, qstr string) {
 
+	DBCommitWith(DBContext(sst),sst,qstr)
+}
+
+// **************************************************************************
+
+func DBCommitWith(ctx context.Context,sst *PoSST, qstr string) {
+
 	if qstr == "" {
 		return
 	}
@@ -140,7 +175,7 @@ func DBCommit(sst *PoSST, qstr string) {
 	cstr += qstr
 	cstr += "\nCOMMIT;"
 	
-	row,err := sst.DB.Query(qstr)
+	row,err := sst.DB.QueryContext(ctx,qstr)
 	
 	if err != nil {
 		s := fmt.Sprint("Failed to insert",err)
@@ -161,13 +196,21 @@ func DBCommit(sst *PoSST, qstr string) {
 
 func UploadNodeToDB(sst *PoSST, n Node) string {
 
-	/*	if !WIPE_DB {
+	/*	if !sst.WIPE {
 		fmt.Println("\n\n!!!!!! NOTE: -u without -wipe is currently unsupported, pending a rethink !!!!!!\n")
 		os.Exit(-1)
 	}*/
 
-	const nodecols  = "(NPtr.Chan,NPtr.Cptr,L,S,Chap,Seq," +
-		I_MEXPR + "," + I_MCONT + "," + I_MLEAD + "," + I_NEAR + "," + I_PLEAD + "," + I_PCONT + "," + I_PEXPR + ")"
+	qstr := "INSERT INTO Node " + NODE_INSERT_COLS + " VALUES " + FormatSQLNodeValues(n) + ";\n"
+
+	return qstr
+}
+
+// **************************************************************************
+
+func FormatSQLNodeValues(n Node) string {
+
+	// One row of values for NODE_INSERT_COLS
 
 	seq := "false"
 
@@ -183,15 +226,37 @@ func UploadNodeToDB(sst *PoSST, n Node) string {
 		cols[stindex] = FormatSQLLinkArray(n.I[stindex])
 	}
 
-	vals := fmt.Sprintf("(%d,%d,%d,'%s','%s',%s,'%s','%s','%s','%s','%s','%s','%s')",
+	return fmt.Sprintf("(%d,%d,%d,'%s','%s',%s,'%s','%s','%s','%s','%s','%s','%s')",
 		n.NPtr.Class, n.NPtr.CPtr, n.L,
 		SQLEscape(n.S), SQLEscape(n.Chap), seq,
 		cols[0], cols[1], cols[2], cols[3], cols[4], cols[5], cols[6])
+}
 
-	
-	qstr := "INSERT INTO Node " + nodecols + " VALUES " + vals + ";\n"
+// **************************************************************************
 
-	return qstr
+func (pg PostgresStore) UploadArrows(ctx context.Context,sst *PoSST) {
+
+	sst.DB.QueryRowContext(ctx,"drop table ArrowDirectory")
+	sst.DB.QueryRowContext(ctx,"drop table ArrowInverses")
+
+	// The helpers take the session by value
+
+	session := WithContext(CopySession(sst),ctx)
+
+	if !CreateTable(session,ARROW_INVERSES_TABLE) {
+		fmt.Println("Unable to create table as, ",ARROW_INVERSES_TABLE)
+		return
+	}
+	if !CreateTable(session,ARROW_DIRECTORY_TABLE) {
+		fmt.Println("Unable to create table as, ",ARROW_DIRECTORY_TABLE)
+		return
+	}
+
+	UploadArrowsToDB(session)
+
+	fmt.Println("Storing inverse Arrows...")
+
+	UploadInverseArrowsToDB(session)
 }
 
 // **************************************************************************
@@ -200,11 +265,11 @@ func UploadArrowsToDB(sst PoSST) {
 	
 	qstr := "BEGIN;\n"
 	
-	for arrow := range sst.ARROW_DIRECTORY {
+	for arrow,ad := range GetArrowDirectory(&sst) {
 		
-		staidx := sst.ARROW_DIRECTORY[arrow].STAindex
-		long := SQLEscape(sst.ARROW_DIRECTORY[arrow].Long)
-		short := SQLEscape(sst.ARROW_DIRECTORY[arrow].Short)
+		staidx := ad.STAindex
+		long := SQLEscape(ad.Long)
+		short := SQLEscape(ad.Short)
 		
 		qstr += fmt.Sprintf("INSERT INTO ArrowDirectory (STAindex,Long,Short,ArrPtr) SELECT %d,'%s','%s',%d WHERE NOT EXISTS (SELECT Long,Short,ArrPtr FROM ArrowDirectory WHERE lower(Long) = lower('%s') OR lower(Short) = lower('%s') OR ArrPtr = %d);\n",staidx,long,short,arrow,long,short,arrow)
 		
@@ -212,7 +277,7 @@ func UploadArrowsToDB(sst PoSST) {
 	
 	qstr += "\nCOMMIT;"
 	
-	row,err := sst.DB.Query(qstr)
+	row,err := sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		s := fmt.Sprint("Failed to insert",err)
@@ -233,16 +298,14 @@ func UploadInverseArrowsToDB(sst PoSST) {
 
 	qstr := "BEGIN;\n"
 	
-	for arrow := range sst.INVERSE_ARROWS {
-		plus := arrow
-		minus := sst.INVERSE_ARROWS[arrow]
+	for plus,minus := range GetInverseArrows(&sst) {
 		
 		qstr += fmt.Sprintf("INSERT INTO ArrowInverses (Plus,Minus) SELECT %d,%d WHERE NOT EXISTS (SELECT Plus,Minus FROM ArrowInverses WHERE Plus = %d OR minus = %d);\n",plus,minus,plus,minus)
 	}
 
 	qstr += "\nCOMMIT;"
 	
-	row,err := sst.DB.Query(qstr)
+	row,err := sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		s := fmt.Sprint("Failed to insert",err)
@@ -260,8 +323,8 @@ func UploadInverseArrowsToDB(sst PoSST) {
 
 func UploadContextsToDB(sst *PoSST) {
 
-	for ctxdir := range sst.CONTEXT_DIRECTORY {
-		UploadContextToDB(sst,SQLEscape(sst.CONTEXT_DIRECTORY[ctxdir].Context),sst.CONTEXT_DIRECTORY[ctxdir].Ptr)
+	for _,cd := range GetContextDirectory(sst) {
+		UploadContextToDB(sst,cd.Context,cd.Ptr)
 	}
 }
 
@@ -269,6 +332,13 @@ func UploadContextsToDB(sst *PoSST) {
 
 func UploadContextToDB(sst *PoSST,contextstring string,ptr ContextPtr) ContextPtr {
 
+	return sst.STORE.IdempAddContext(DBContext(sst),sst,contextstring,ptr)
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) IdempAddContext(ctx context.Context,sst *PoSST,contextstring string,ptr ContextPtr) ContextPtr {
+
 	a := SQLEscape(contextstring)
 	b := ptr
 
@@ -276,7 +346,7 @@ func UploadContextToDB(sst *PoSST,contextstring string,ptr ContextPtr) ContextPt
 
 	qstr := fmt.Sprintf("SELECT IdempInsertContext('%s',%d)",a,b)
 
-	row,err := sst.DB.Query(qstr)
+	row,err := sst.DB.QueryContext(ctx,qstr)
 	
 	if err != nil {
 		fmt.Println("FAILED \n",qstr,err)
@@ -298,24 +368,54 @@ func UploadContextToDB(sst *PoSST,contextstring string,ptr ContextPtr) ContextPt
 
 func UploadPageMapBatch(sst *PoSST, lines []PageMap) {
 
+	sst.STORE.UploadPageMap(DBContext(sst),sst,lines)
+}
+
+//**************************************************************
+
+func (pg PostgresStore) UploadPageMap(ctx context.Context,sst *PoSST, lines []PageMap) {
+
 	const chunk = 200
 	var qstr string
 	
 	for i := 0; i < len(lines); i++ {
 	
 		if (i % chunk == 0) {
-			DBCommit(sst,qstr)
+			DBCommitWith(ctx,sst,qstr)
 			qstr = ""
 		}
 
-		line := lines[i]
-		qstr += fmt.Sprintf("INSERT INTO PageMap (Chap,Alias,Ctx,Line,Path) VALUES ('%s','%s',%d,%d,'%s');\n",
+		qstr += FormatSQLPageMap(lines[i])
+	}
+
+	DBCommitWith(ctx,sst,qstr)
+
+}
+
+//**************************************************************
+
+func FormatSQLPageMap(line PageMap) string {
+
+	return fmt.Sprintf("INSERT INTO PageMap (Chap,Alias,Ctx,Line,Path) VALUES ('%s','%s',%d,%d,'%s');\n",
 		SQLEscape(line.Chapter), line.Alias, line.Context, line.Line,
 		FormatSQLLinkArray(line.Path))
-	}
+}
+
+//**************************************************************
+
+func (pg PostgresStore) DeletePageMap(ctx context.Context,sst *PoSST,chap string) int {
+
+	qstr := fmt.Sprintf("DELETE FROM PageMap WHERE Chap='%s'",SQLEscape(chap))
 
-	DBCommit(sst,qstr)
+	result,err := sst.DB.ExecContext(ctx,qstr)
+
+	if err != nil {
+		fmt.Println("Failed to delete page map",err,qstr)
+		return 0
+	}
 
+	count,_ := result.RowsAffected()
+	return int(count)
 }
 
 
@@ -0,0 +1,271 @@
+//**************************************************************
+//
+// document_import.go
+//
+// Walk a JSON or YAML document and turn its structure into links:
+// objects and arrays contain their members, keys express their
+// scalar values, and array elements follow one another
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"strconv"
+	"unicode"
+	"encoding/json"
+	_ "github.com/lib/pq"
+
+)
+
+//**************************************************************
+
+const (
+	DOC_OBJECT = iota
+	DOC_ARRAY
+	DOC_SCALAR
+	DOC_NULL
+)
+
+//**************************************************************
+
+type DocumentValue struct {
+
+	// A parsed document that keeps the order of object keys
+
+	Kind   int
+	Keys   []string
+	Items  []*DocumentValue // object fields, in the order of Keys, or array elements
+	Scalar string
+}
+
+//**************************************************************
+
+type DocumentArrows struct {
+
+	Contains ArrowPtr  // object or array -> member
+	Property ArrowPtr  // key -> scalar value
+	Sequence ArrowPtr  // array element -> next element
+}
+
+//**************************************************************
+
+func GetDocumentArrows(sst *PoSST,contains,property,sequence string) (DocumentArrows,bool) {
+
+	var arrows DocumentArrows
+
+	var ok1,ok2,ok3 bool
+
+	arrows.Contains,ok1 = GetImportArrow(sst,contains,CONTAINS)
+	arrows.Property,ok2 = GetImportArrow(sst,property,EXPRESS)
+	arrows.Sequence,ok3 = GetImportArrow(sst,sequence,LEADSTO)
+
+	return arrows,ok1 && ok2 && ok3
+}
+
+//**************************************************************
+
+func GetImportArrow(sst *PoSST,name string,sttypes ...int) (ArrowPtr,bool) {
+
+	// Importers only use arrows that are already defined, and warn
+	// if an arrow is not of the expected type
+
+	arr,found := GetExportArrowByName(sst,name)
+
+	if !found {
+		fmt.Printf("No such arrow (%s), it needs to be defined in SSTconfig and uploaded first\n",name)
+		return 0,false
+	}
+
+	sttype := STIndexToSTType(GetDBArrowByPtr(sst,arr).STAindex)
+
+	var expected []string
+
+	for _,st := range sttypes {
+		if st == sttype {
+			return arr,true
+		}
+		expected = append(expected,STTypeName(st))
+	}
+
+	fmt.Printf("Warning: arrow (%s) is not of type %s\n",name,strings.Join(expected," or "))
+
+	return arr,true
+}
+
+//**************************************************************
+
+func DocumentToLinks(doc *DocumentValue,root string,arrows DocumentArrows) []TableRowLink {
+
+	// The root is named after the document, and every other node
+	// after its path from the root, e.g. "api.data.users[0]"
+
+	var links []TableRowLink
+
+	WalkDocument(doc,root,nil,arrows,&links)
+
+	return links
+}
+
+//**************************************************************
+
+func WalkDocument(doc *DocumentValue,path string,keys []string,arrows DocumentArrows,links *[]TableRowLink) {
+
+	switch doc.Kind {
+
+	case DOC_OBJECT:
+		for k,key := range doc.Keys {
+			child := DocumentKeyPath(path,key)
+			context := append(append([]string{},keys...),DocumentContextWord(key))
+			AddDocumentMember(path,child,doc.Items[k],context,arrows,links)
+		}
+
+	case DOC_ARRAY:
+		prev := ""
+		for i,item := range doc.Items {
+			child := fmt.Sprintf("%s[%d]",path,i)
+			AddDocumentMember(path,child,item,keys,arrows,links)
+
+			if prev != "" {
+				*links = append(*links,TableRowLink{From: prev, Arr: arrows.Sequence, To: child, Context: keys})
+			}
+
+			prev = child
+		}
+	}
+}
+
+//**************************************************************
+
+func AddDocumentMember(parent,child string,item *DocumentValue,context []string,arrows DocumentArrows,links *[]TableRowLink) {
+
+	if item.Kind == DOC_NULL || item.Kind == DOC_SCALAR && item.Scalar == "" {
+		return
+	}
+
+	*links = append(*links,TableRowLink{From: parent, Arr: arrows.Contains, To: child, Context: context})
+
+	if item.Kind == DOC_SCALAR {
+		*links = append(*links,TableRowLink{From: child, Arr: arrows.Property, To: item.Scalar, Context: context})
+		return
+	}
+
+	WalkDocument(item,child,context,arrows,links)
+}
+
+//**************************************************************
+
+func DocumentKeyPath(path,key string) string {
+
+	simple := key != ""
+
+	for _,r := range key {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '-' {
+			simple = false
+			break
+		}
+	}
+
+	if simple {
+		return path + "." + key
+	}
+
+	return path + "[" + strconv.Quote(key) + "]"
+}
+
+//**************************************************************
+
+func DocumentContextWord(key string) string {
+
+	// Commas separate context words
+
+	return strings.Join(strings.Fields(strings.ReplaceAll(key,","," "))," ")
+}
+
+//**************************************************************
+// JSON, keeping the key order
+//**************************************************************
+
+func ParseJSONDocument(text string) (*DocumentValue,error) {
+
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+
+	doc,err := DecodeJSONValue(dec)
+
+	if err != nil {
+		return nil,err
+	}
+
+	if _,err := dec.Token(); err != io.EOF {
+		return nil,fmt.Errorf("unexpected data after the JSON document")
+	}
+
+	return doc,nil
+}
+
+//**************************************************************
+
+func DecodeJSONValue(dec *json.Decoder) (*DocumentValue,error) {
+
+	tok,err := dec.Token()
+
+	if err != nil {
+		return nil,err
+	}
+
+	var doc DocumentValue
+
+	switch t := tok.(type) {
+
+	case json.Delim:
+		if t == '{' {
+			doc.Kind = DOC_OBJECT
+
+			for dec.More() {
+				key,err := dec.Token()
+				if err != nil {
+					return nil,err
+				}
+				item,err := DecodeJSONValue(dec)
+				if err != nil {
+					return nil,err
+				}
+				doc.Keys = append(doc.Keys,fmt.Sprint(key))
+				doc.Items = append(doc.Items,item)
+			}
+		} else {
+			doc.Kind = DOC_ARRAY
+
+			for dec.More() {
+				item,err := DecodeJSONValue(dec)
+				if err != nil {
+					return nil,err
+				}
+				doc.Items = append(doc.Items,item)
+			}
+		}
+
+		// the closing delimiter
+
+		if _,err := dec.Token(); err != nil {
+			return nil,err
+		}
+
+	case nil:
+		doc.Kind = DOC_NULL
+
+	default:
+		doc.Kind = DOC_SCALAR
+		doc.Scalar = fmt.Sprint(t)
+	}
+
+	return &doc,nil
+}
+
+//
+// document_import.go
+//
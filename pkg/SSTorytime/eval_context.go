@@ -7,6 +7,7 @@
 package SSTorytime
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"time"
@@ -19,10 +20,14 @@ import (
 
 func GetContext(sst *PoSST,contextptr ContextPtr) string {
 
-	exists := int(contextptr) < len(sst.CONTEXT_DIRECTORY)
+	lock := DirLock(sst)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	exists := int(contextptr) < len(sst.CONTEXTS.Directory)
 
 	if exists {
-		return sst.CONTEXT_DIRECTORY[contextptr].Context
+		return sst.CONTEXTS.Directory[contextptr].Context
 	}
 
 	return "unknown context"
@@ -38,30 +43,25 @@ func RegisterContext(sst *PoSST,parse_state map[string]bool,context []string) Co
 		return 0
 	}
 
-	ctxptr,exists := sst.CONTEXT_DIR[ctxstr] 
+	return IdempContextDirectory(sst,ctxstr)
+}
 
-	if !exists {
-		var cd ContextDirectory
-		cd.Context = ctxstr
-		cd.Ptr = sst.CONTEXT_TOP
-		sst.CONTEXT_DIRECTORY = append(sst.CONTEXT_DIRECTORY,cd)
-		sst.CONTEXT_DIR[ctxstr] = sst.CONTEXT_TOP
-		ctxptr = sst.CONTEXT_TOP
-		sst.CONTEXT_TOP++
-	}
+// **************************************************************************
 
-	return ctxptr
+func TryContext(sst *PoSST,context []string) ContextPtr {
+
+	return TryContextWith(DBContext(sst),sst,context)
 }
 
 // **************************************************************************
 
-func TryContext(sst *PoSST,context []string) ContextPtr {
+func TryContextWith(ctx context.Context,sst *PoSST,context []string) ContextPtr {
 
 	ctxstr := CompileContextString(context)
-	str,ctxptr := GetDBContextByName(sst,ctxstr)
+	str,ctxptr := sst.STORE.GetContextByName(ctx,sst,ctxstr)
 
 	if ctxptr == -1 || str != ctxstr {
-		ctxptr = UploadContextToDB(sst,ctxstr,-1)
+		ctxptr = sst.STORE.IdempAddContext(ctx,sst,ctxstr,-1)
 		RegisterContext(sst,nil,context)
 	}
 
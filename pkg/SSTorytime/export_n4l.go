@@ -0,0 +1,1351 @@
+//**************************************************************
+//
+// export_n4l.go
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	_ "github.com/lib/pq"
+
+)
+
+//**************************************************************
+// Regenerate N4L source for a chapter from the stored graph.
+// The PageMap replays the original lines in order, with their
+// context, sequence mode and annotations; any links or context
+// memberships that the page map does not account for are then
+// written out explicitly, so that N4L recompiles the same graph
+//**************************************************************
+
+const EXPORT_PAGEMAP_LIMIT = 1000000
+const EXPORT_CLOSURE_ROUNDS = 10
+
+//**************************************************************
+
+type N4LExport struct {
+
+	Chapter  string
+	Markers  map[ArrowPtr]string  // annotation arrow -> marker symbol
+	Arrows   map[string]ArrowPtr  // and back
+	Symbols  []string             // all marker symbols, longest first
+	Then     ArrowPtr             // the arrow N4L uses for sequence mode
+	Caps     ArrowPtr             // the arrow N4L adds between alternative capitalizations
+	Closures []ExportClosure      // sequences N4L closes with a result arrow
+	Closed   map[ExportLinkKey]ExportClosureTime // the links they made, and when
+
+	Nodes    map[NodePtr]Node
+	Covered  map[ExportLinkKey]map[string]bool
+	Written  map[NodePtr]bool
+
+	Out      strings.Builder
+	Context  string               // current :: context :: block
+	Sequence bool
+}
+
+//**************************************************************
+
+type N4LConfig struct {
+
+	// The parts of SSTconfig that decide what N4L infers for itself
+
+	Annotations map[string]string    // marker -> arrow name, as in annotations.sst
+	Closures    []N4LClosure         // as in closures.sst
+}
+
+//**************************************************************
+
+type N4LClosure struct {
+
+	// e.g. (ph) + (he) => (ep)
+
+	Sequence []string
+	Result   string
+}
+
+//**************************************************************
+
+type ExportClosure struct {
+
+	Sequence []ArrowPtr
+	Result   ArrowPtr
+}
+
+//**************************************************************
+
+type ExportClosureTime struct {
+
+	// N4L closes sequences while completing each node in turn,
+	// applying the closures in the order they are configured
+
+	Node NodePtr
+	Rule int
+}
+
+//**************************************************************
+
+type ExportLinkKey struct {
+
+	// Links with the same arrow and destination are merged by N4L,
+	// so context coverage is tracked per (from,arrow,to)
+
+	From NodePtr
+	Arr  ArrowPtr
+	Dst  NodePtr
+}
+
+//**************************************************************
+
+func ExportN4L(sst *PoSST,chapter string,config N4LConfig) (string,error) {
+
+	// Arrows are written by name, so the session must have them loaded
+
+	if len(GetArrowDirectory(sst)) == 0 {
+		return "",ERR_NO_ARROWS
+	}
+
+	exp := NewN4LExport(sst,chapter,config)
+
+	exp.Out.WriteString(fmt.Sprintf("\n-%s\n",chapter))
+
+	ExportPageMapLines(sst,&exp)
+	FindExportClosures(sst,&exp)
+	ExportRemainder(sst,&exp)
+
+	return exp.Out.String(),nil
+}
+
+//**************************************************************
+
+func NewN4LExport(sst *PoSST,chapter string,config N4LConfig) N4LExport {
+
+	var exp N4LExport
+
+	exp.Chapter = chapter
+	exp.Markers = make(map[ArrowPtr]string)
+	exp.Arrows = make(map[string]ArrowPtr)
+	exp.Nodes = make(map[NodePtr]Node)
+	exp.Covered = make(map[ExportLinkKey]map[string]bool)
+	exp.Closed = make(map[ExportLinkKey]ExportClosureTime)
+	exp.Written = make(map[NodePtr]bool)
+	exp.Then = -1
+
+	for symbol,name := range config.Annotations {
+
+		exp.Symbols = append(exp.Symbols,symbol)
+
+		arr,ok := GetExportArrowByName(sst,name)
+
+		if ok {
+			exp.Markers[arr] = symbol
+			exp.Arrows[symbol] = arr
+		}
+	}
+
+	for _,cl := range config.Closures {
+
+		var closure ExportClosure
+		var ok bool = true
+
+		for _,name := range cl.Sequence {
+			arr,found := GetExportArrowByName(sst,name)
+			closure.Sequence = append(closure.Sequence,arr)
+			ok = ok && found
+		}
+
+		closure.Result,_ = GetExportArrowByName(sst,cl.Result)
+
+		if ok && cl.Result != "" {
+			exp.Closures = append(exp.Closures,closure)
+		}
+	}
+
+	sort.Slice(exp.Symbols, func(i,j int) bool {
+		if len(exp.Symbols[i]) != len(exp.Symbols[j]) {
+			return len(exp.Symbols[i]) > len(exp.Symbols[j])
+		}
+		return exp.Symbols[i] < exp.Symbols[j]
+	})
+
+	then,ok := GetExportArrowByName(sst,"then")
+
+	if ok {
+		exp.Then = then
+	}
+
+	caps,ok := GetArrowPtrByName(sst,"caps")
+
+	if ok {
+		exp.Caps = caps
+	} else {
+		exp.Caps = -1
+	}
+
+	return exp
+}
+
+//**************************************************************
+
+func ExportPageMapLines(sst *PoSST,exp *N4LExport) {
+
+	// Replay the lines N4L recorded for this chapter
+
+	var lines []PageMap
+
+	for _,event := range GetDBPageMap(CopySession(sst),exp.Chapter,nil,1,EXPORT_PAGEMAP_LIMIT) {
+		if event.Chapter == exp.Chapter && len(event.Path) > 0 {
+			event.Path = ValidPathPrefix(sst,exp,event.Path)
+			lines = append(lines,event)
+		}
+	}
+
+	// A line is joined to the previous one by (then) only in sequence mode,
+	// so work out in advance which lines need it, unless written explicitly
+
+	var explicit = make(map[ExportLinkKey]bool)
+
+	for _,line := range lines {
+		for p := 1; p < len(line.Path); p++ {
+			explicit[ExportLinkKey{From: line.Path[p-1].Dst, Arr: line.Path[p].Arr, Dst: line.Path[p].Dst}] = true
+		}
+	}
+
+	var need = make([]bool,len(lines))
+
+	for l := 1; l < len(lines); l++ {
+
+		prev := lines[l-1].Path[0].Dst
+		this := lines[l].Path[0].Dst
+
+		if prev != this && exp.Then >= 0 && !explicit[ExportLinkKey{From: prev, Arr: exp.Then, Dst: this}] {
+			items := ExportContextItems(sst,lines[l].Context)
+			need[l] = HasLinkInContext(sst,exp,prev,exp.Then,this,items)
+		}
+	}
+
+	for l := range lines {
+
+		line := lines[l]
+		items := ExportContextItems(sst,line.Context)
+		first := line.Path[0].Dst
+
+		SetExportContext(exp,items)
+
+		starts := SequenceStartsAfter(lines,need,l)
+		changed := l == 0 || first != lines[l-1].Path[0].Dst
+
+		if exp.Sequence && changed && !need[l] {
+			if starts {
+				exp.Out.WriteString(" +:: _sequence_ ::\n\n")  // restart the chain here
+			} else {
+				exp.Out.WriteString("\n -:: _sequence_ ::\n\n")
+				exp.Sequence = false
+			}
+		} else if !exp.Sequence && starts {
+			exp.Out.WriteString(" +:: _sequence_ ::\n\n")
+			exp.Sequence = true
+		}
+
+		if exp.Sequence && need[l] {
+			CoverExportLink(sst,exp,lines[l-1].Path[0].Dst,exp.Then,first,items)
+		}
+
+		var text string
+
+		if line.Alias != "" {
+			text = "@" + line.Alias + " "
+		}
+
+		text += ExportNodeText(sst,exp,first,items,true)
+
+		for p := 1; p < len(line.Path); p++ {
+
+			lnk := line.Path[p]
+			from := line.Path[p-1].Dst
+			lnkitems := ExportContextItems(sst,lnk.Ctx)
+
+			text += fmt.Sprintf(" (%s) ",ExportArrowSpec(sst,lnk.Arr,lnk.Wgt,SubtractItems(lnkitems,items)))
+			text += ExportNodeText(sst,exp,lnk.Dst,items,true)
+
+			CoverExportLink(sst,exp,from,lnk.Arr,lnk.Dst,lnkitems)
+		}
+
+		for p := range line.Path {
+			CoverExportGhost(exp,line.Path[p].Dst,items)
+		}
+
+		exp.Out.WriteString(" "+text+"\n")
+	}
+
+	if exp.Sequence {
+		exp.Out.WriteString("\n -:: _sequence_ ::\n")
+		exp.Sequence = false
+	}
+}
+
+//**************************************************************
+
+func ExportRemainder(sst *PoSST,exp *N4LExport) {
+
+	// Links and context memberships not accounted for by the page map,
+	// e.g. added through the API or merged from other chapters
+
+	nptrs := GetDBNodePtrsByChapter(sst,exp.Chapter)
+
+	sort.Slice(nptrs, func(i,j int) bool {
+		if nptrs[i].Class != nptrs[j].Class {
+			return nptrs[i].Class < nptrs[j].Class
+		}
+		return nptrs[i].CPtr < nptrs[j].CPtr
+	})
+
+	var blocks = make(map[string][]string)
+
+	for _,nptr := range nptrs {
+
+		node := GetExportNode(sst,exp,nptr)
+
+		for st := range node.I {
+			for _,lnk := range node.I[st] {
+
+				if lnk.Arr == 0 {
+					continue // context membership
+				}
+
+				items := ExportContextItems(sst,lnk.Ctx)
+				key := ExportLinkKey{From: nptr, Arr: lnk.Arr, Dst: lnk.Dst}
+
+				if IsExportCovered(exp,key,items) {
+					continue
+				}
+
+				dst := GetExportNode(sst,exp,lnk.Dst)
+
+				if !InChapterList(dst.Chap,exp.Chapter) || lnk.Dst == nptr {
+					continue // a self-loop can only come from an annotation
+				}
+
+				if lnk.Arr == exp.Caps && DifferentCaps(node,dst) {
+					continue // N4L links these itself
+				}
+
+				if st == ST_ZERO+NEAR && IsCompletedNear(sst,exp,node,lnk,UncoveredItems(exp,key,items)) {
+					continue // N4L completes these itself
+				}
+
+				if IsClosedSequence(sst,exp,nptr,lnk,UncoveredItems(exp,key,items)) {
+					continue // and closes these
+				}
+
+				// Write inverse links the way round they were declared
+
+				from,arr,to,wgt := nptr,lnk.Arr,lnk.Dst,lnk.Wgt
+
+				if STIndexToSTType(st) < 0 {
+					from,to = to,from
+					arr = GetInverseArrow(sst,lnk.Arr)
+					wgt = 1
+
+					for _,fwd := range dst.I[GetDBArrowByPtr(sst,arr).STAindex] {
+						if fwd.Arr == arr && fwd.Dst == nptr {
+							wgt = fwd.Wgt
+						}
+					}
+				}
+
+				// Declaring a link also adds both ends to the block context,
+				// so keep the block within what they already belong to
+
+				block := IntersectItems(items,ExportGhostItems(sst,exp,from))
+				block = IntersectItems(block,ExportGhostItems(sst,exp,to))
+
+				if len(block) == 0 {
+					block = items
+				}
+
+				text := ExportNodeText(sst,exp,from,block,false)
+				text += fmt.Sprintf(" (%s) ",ExportArrowSpec(sst,arr,wgt,SubtractItems(items,block)))
+				text += ExportNodeText(sst,exp,to,block,false)
+
+				CoverExportLink(sst,exp,from,arr,to,items)
+				CoverExportGhost(exp,from,block)
+				CoverExportGhost(exp,to,block)
+
+				ctxstr := strings.Join(block,", ")
+				blocks[ctxstr] = append(blocks[ctxstr],text)
+			}
+		}
+	}
+
+	// Lone items whose context membership is still incomplete
+
+	for _,nptr := range nptrs {
+
+		ghost := ExportGhostItems(sst,exp,nptr)
+		key := ExportLinkKey{From: nptr}
+		missing := UncoveredItems(exp,key,ghost)
+
+		if len(ghost) == 0 && !exp.Written[nptr] {
+			missing = []string{"any"}
+		}
+
+		if len(missing) > 0 {
+			ctxstr := strings.Join(missing,", ")
+			blocks[ctxstr] = append(blocks[ctxstr],ExportNodeText(sst,exp,nptr,missing,false))
+			CoverExportGhost(exp,nptr,missing)
+		}
+	}
+
+	var order []string
+
+	for ctxstr := range blocks {
+		order = append(order,ctxstr)
+	}
+
+	sort.Strings(order)
+
+	for _,ctxstr := range order {
+
+		SetExportContext(exp,strings.Split(ctxstr,", "))
+
+		for _,text := range blocks[ctxstr] {
+			exp.Out.WriteString(" "+text+"\n")
+		}
+	}
+}
+
+//**************************************************************
+
+func IsCompletedNear(sst *PoSST,exp *N4LExport,node Node,lnk Link,missing []string) bool {
+
+	// Neighbours sharing a NEAR arrow are linked by N4L without
+	// context, so an uncontextualized link between them is implied
+
+	if len(SubtractItems(missing,[]string{"any"})) > 0 {
+		return false
+	}
+
+	if strings.HasPrefix(GetDBArrowByPtr(sst,lnk.Arr).Short,"!") {
+		return false
+	}
+
+	for _,via := range node.I[ST_ZERO+NEAR] {
+
+		if via.Arr != lnk.Arr || via.Dst == lnk.Dst {
+			continue
+		}
+
+		common := GetExportNode(sst,exp,via.Dst)
+
+		for _,next := range common.I[ST_ZERO+NEAR] {
+			if next.Arr == lnk.Arr && next.Dst == lnk.Dst {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+//**************************************************************
+
+func IsClosedSequence(sst *PoSST,exp *N4LExport,nptr NodePtr,lnk Link,missing []string) bool {
+
+	// A closure (a) + (b) => (c) links back to the start of any a,b
+	// sequence, in the context of the start's first leadsto link
+
+	if _,closed := exp.Closed[ExportLinkKey{From: nptr, Arr: lnk.Arr, Dst: lnk.Dst}]; !closed {
+		return false
+	}
+
+	start := GetExportNode(sst,exp,lnk.Dst)
+
+	if len(start.I[ST_ZERO+LEADSTO]) == 0 {
+		return false
+	}
+
+	ctx := ExportContextItems(sst,start.I[ST_ZERO+LEADSTO][0].Ctx)
+
+	return len(SubtractItems(missing,ctx)) == 0
+}
+
+//**************************************************************
+
+func FindExportClosures(sst *PoSST,exp *N4LExport) {
+
+	// N4L completes nodes in the order it created them, and a closure
+	// follows only the links made so far, so replay them in that order.
+	// Which links are closures decides what is visible when, so start
+	// from the whole graph and repeat until nothing changes. Links the
+	// page map writes are there from the start
+
+	if len(exp.Closures) == 0 {
+		return
+	}
+
+	nptrs := GetDBNodePtrsByChapter(sst,exp.Chapter)
+
+	sort.Slice(nptrs, func(i,j int) bool {
+		return IsEarlierNode(nptrs[i],nptrs[j])
+	})
+
+	for round := 0; round < EXPORT_CLOSURE_ROUNDS; round++ {
+
+		var found = make(map[ExportLinkKey]ExportClosureTime)
+
+		for _,nptr := range nptrs {
+
+			node := GetExportNode(sst,exp,nptr)
+
+			for rule,cl := range exp.Closures {
+
+				now := ExportClosureTime{Node: nptr, Rule: rule}
+				from := FollowExportSequence(sst,exp,node,cl.Sequence,now)
+				key := ExportLinkKey{From: from, Arr: cl.Result, Dst: nptr}
+
+				if _,seen := found[key]; seen || from.Class < 0 || !HasExportLink(sst,exp,key) {
+					continue
+				}
+
+				found[key] = now
+			}
+		}
+
+		same := len(found) == len(exp.Closed)
+
+		for key,when := range found {
+			if exp.Closed[key] != when {
+				same = false
+			}
+		}
+
+		exp.Closed = found
+
+		if same {
+			return
+		}
+	}
+}
+
+//**************************************************************
+
+func FollowExportSequence(sst *PoSST,exp *N4LExport,node Node,sequence []ArrowPtr,now ExportClosureTime) NodePtr {
+
+	// As N4L does it, the last matching link wins at each step,
+	// ignoring closures that have not been made yet
+
+	for _,arr := range sequence {
+
+		found := false
+		from := node.NPtr
+		stindex := GetDBArrowByPtr(sst,arr).STAindex
+
+		for _,lnk := range node.I[stindex] {
+
+			key := ExportLinkKey{From: from, Arr: lnk.Arr, Dst: lnk.Dst}
+			when,closed := exp.Closed[key]
+
+			if closed && !IsEarlierClosure(when,now) && len(exp.Covered[key]) == 0 {
+				continue
+			}
+
+			if lnk.Arr == arr {
+				found = true
+				node = GetExportNode(sst,exp,lnk.Dst)
+			}
+		}
+
+		if !found {
+			return NodePtr{Class: -1}
+		}
+	}
+
+	return node.NPtr
+}
+
+//**************************************************************
+
+func IsEarlierClosure(one,two ExportClosureTime) bool {
+
+	if one.Node != two.Node {
+		return IsEarlierNode(one.Node,two.Node)
+	}
+
+	return one.Rule < two.Rule
+}
+
+//**************************************************************
+
+func IsEarlierNode(one,two NodePtr) bool {
+
+	// The order in which N4L created, and so completes, the nodes
+
+	if one.Class != two.Class {
+		return one.Class < two.Class
+	}
+
+	return one.CPtr < two.CPtr
+}
+
+//**************************************************************
+
+func HasExportLink(sst *PoSST,exp *N4LExport,key ExportLinkKey) bool {
+
+	from := GetExportNode(sst,exp,key.From)
+
+	for _,lnk := range from.I[GetDBArrowByPtr(sst,key.Arr).STAindex] {
+		if lnk.Arr == key.Arr && lnk.Dst == key.Dst {
+			return true
+		}
+	}
+
+	return false
+}
+
+//**************************************************************
+
+func ValidPathPrefix(sst *PoSST,exp *N4LExport,path []Link) []Link {
+
+	// The path assumes each link starts from the previous item, which
+	// is not so for lines like  "a" b (arrow) c  so stop where it fails
+
+	for p := 1; p < len(path); p++ {
+
+		items := ExportContextItems(sst,path[p].Ctx)
+
+		if !HasLinkInContext(sst,exp,path[p-1].Dst,path[p].Arr,path[p].Dst,items) {
+			return path[:p]
+		}
+	}
+
+	return path
+}
+
+//**************************************************************
+
+func SequenceStartsAfter(lines []PageMap,need []bool,l int) bool {
+
+	// Does the next line with a different first item continue a sequence from line l?
+
+	for next := l+1; next < len(lines); next++ {
+		if lines[next].Path[0].Dst != lines[l].Path[0].Dst {
+			return need[next]
+		}
+	}
+
+	return false
+}
+
+//**************************************************************
+
+func SetExportContext(exp *N4LExport,items []string) {
+
+	ctxstr := strings.Join(items,", ")
+
+	if ctxstr != exp.Context {
+		exp.Out.WriteString(fmt.Sprintf("\n :: %s ::\n\n",ctxstr))
+		exp.Context = ctxstr
+	}
+}
+
+//**************************************************************
+
+func ExportNodeText(sst *PoSST,exp *N4LExport,nptr NodePtr,items []string,annotate bool) string {
+
+	node := GetExportNode(sst,exp,nptr)
+	exp.Written[nptr] = true
+
+	text := node.S
+
+	if annotate {
+		text = AnnotateN4LText(sst,exp,node,items)
+	}
+
+	// N4L measures the text with its quotes, so "x" and x can be different nodes
+
+	if text == node.S && (NeedsN4LQuotes(text,exp.Symbols) || node.L == len(text)+2) {
+		text = QuoteN4LText(text)
+	}
+
+	// Whatever markers N4L will find in this text, it will turn into links
+
+	for _,ann := range ExtractN4LAnnotations(text,exp.Symbols) {
+
+		arr,ok := exp.Arrows[ann.Symbol]
+
+		if !ok {
+			continue
+		}
+
+		for st := range node.I {
+			for _,lnk := range node.I[st] {
+				if lnk.Arr == arr && GetExportNode(sst,exp,lnk.Dst).S == ann.Word {
+					CoverExportLink(sst,exp,nptr,lnk.Arr,lnk.Dst,items)
+					exp.Written[lnk.Dst] = true
+				}
+			}
+		}
+	}
+
+	return text
+}
+
+//**************************************************************
+
+func AnnotateN4LText(sst *PoSST,exp *N4LExport,node Node,items []string) string {
+
+	// Put back the markers that N4L turned into links, e.g. "the =word"
+
+	type insertion struct {
+		Pos    int
+		Symbol string
+		Word   string
+	}
+
+	var inserts []insertion
+	var taken = make(map[int]bool)
+
+	// N4L measures the text as written, so markers would hide a case
+	// variant that it links automatically
+	for st := range node.I {
+		for _,lnk := range node.I[st] {
+			if lnk.Arr == exp.Caps {
+				return node.S
+			}
+		}
+	}
+
+	runes := []rune(node.S)
+
+	for st := range node.I {
+		for _,lnk := range node.I[st] {
+
+			symbol,ok := exp.Markers[lnk.Arr]
+
+			if !ok {
+				continue
+			}
+
+			if len(SubtractItems(items,ExportContextItems(sst,lnk.Ctx))) > 0 {
+				continue
+			}
+
+			dst := GetExportNode(sst,exp,lnk.Dst)
+
+			if len(dst.S) == 0 || strings.ContainsAny(dst.S,"\n\"'") {
+				continue
+			}
+
+			if !InChapterList(dst.Chap,exp.Chapter) {
+				continue
+			}
+
+			// The word may have been quoted, as in ="a phrase", and
+			// several words can only have been annotated that way
+
+			words := [][]rune{[]rune("\"" + dst.S + "\"")}
+
+			if !strings.ContainsAny(dst.S," \t") {
+				words = append(words,[]rune(dst.S))
+			}
+
+			pos := -1
+
+			for w := 0; w < len(words) && pos < 0; w++ {
+
+				pos = FindN4LWord(runes,words[w],taken,false)
+
+				if pos < 0 {
+					pos = FindN4LWord(runes,words[w],taken,true)
+				}
+			}
+
+			if pos < 0 {
+				continue
+			}
+
+			taken[pos] = true
+			inserts = append(inserts,insertion{Pos: pos, Symbol: symbol, Word: dst.S})
+		}
+	}
+
+	if len(inserts) == 0 {
+		return node.S
+	}
+
+	sort.Slice(inserts, func(i,j int) bool {
+		return inserts[i].Pos < inserts[j].Pos
+	})
+
+	var annotated []rune
+	var next int
+
+	for r := range runes {
+		if next < len(inserts) && inserts[next].Pos == r {
+			annotated = append(annotated,[]rune(inserts[next].Symbol)...)
+			next++
+		}
+		annotated = append(annotated,runes[r])
+	}
+
+	// Check that N4L reads it back as intended, since a marker
+	// could run into its neighbours and read as a longer one
+
+	text := string(annotated)
+
+	if StripN4LAnnotations(text,exp.Symbols) != node.S || NeedsN4LQuotes(text,nil) {
+		return node.S
+	}
+
+	found := ExtractN4LAnnotations(text,exp.Symbols)
+
+	if len(found) != len(inserts) {
+		return node.S
+	}
+
+	for i := range inserts {
+		if found[i].Symbol != inserts[i].Symbol || found[i].Word != inserts[i].Word {
+			return node.S
+		}
+	}
+
+	return text
+}
+
+//**************************************************************
+
+func FindN4LWord(runes,word []rune,taken map[int]bool,anywhere bool) int {
+
+	// Position of word in runes, as a whitespace separated token
+	// or else anywhere it ends, since N4L reads to the next space
+
+	for p := 0; p+len(word) <= len(runes); p++ {
+
+		if taken[p] || !anywhere && p > 0 && !unicode.IsSpace(runes[p-1]) {
+			continue
+		}
+
+		end := p+len(word)
+
+		if end < len(runes) && !unicode.IsSpace(runes[end]) && word[len(word)-1] != '"' {
+			continue
+		}
+
+		if string(runes[p:end]) == string(word) {
+			return p
+		}
+	}
+
+	return -1
+}
+
+//**************************************************************
+
+type ExportAnnotation struct {
+
+	Symbol string
+	Word   string
+}
+
+//**************************************************************
+
+func ExtractN4LAnnotations(token string,symbols []string) []ExportAnnotation {
+
+	// The links N4L's AddBackAnnotations will make from this token,
+	// which counts bytes but matches markers on runes, as it does
+
+	var found []ExportAnnotation
+	var protected bool
+
+	runes := []rune(token)
+
+	for r := 0; r < len(token); r++ {
+
+		if token[r] == '"' {
+			protected = !protected
+			continue
+		}
+
+		if protected {
+			continue
+		}
+
+		if symbol := N4LMarkerAt(runes,r,symbols); symbol != "" {
+			found = append(found,ExportAnnotation{Symbol: symbol, Word: ExtractN4LWord(runes,r+len(symbol))})
+			r += len(symbol)-1
+		}
+	}
+
+	return found
+}
+
+//**************************************************************
+
+func StripN4LAnnotations(text string,symbols []string) string {
+
+	// The node text N4L's StripAnnotations will keep from this token
+
+	var protected bool
+	var clean []rune
+
+	if len(text) > 1 && text[0] == text[len(text)-1] && (text[0] == '"' || text[0] == '\'') {
+		text = text[1:len(text)-1]
+		protected = true
+	}
+
+	runes := []rune(text)
+
+	for r := 0; r < len(runes); r++ {
+
+		if runes[r] == '"' {
+			protected = !protected
+		}
+
+		if !protected {
+			if symbol := N4LMarkerAt(runes,r,symbols); symbol != "" {
+				r += len(symbol)-1
+				continue
+			}
+		}
+
+		clean = append(clean,runes[r])
+	}
+
+	return string(clean)
+}
+
+//**************************************************************
+
+func N4LMarkerAt(runes []rune,offset int,symbols []string) string {
+
+	// As N4L's EmbeddedSymbol: a marker must be followed by a non-space,
+	// and the longest one wins (symbols are sorted longest first)
+
+	for _,symbol := range symbols {
+
+		sym := []rune(symbol)
+		end := offset+len(sym)
+
+		if offset < 0 || end >= len(runes) || string(runes[offset:end]) != symbol || unicode.IsSpace(runes[end]) {
+			continue
+		}
+
+		return symbol
+	}
+
+	return ""
+}
+
+//**************************************************************
+
+func ExtractN4LWord(runes []rune,offset int) string {
+
+	// As N4L's ExtractWord, the word or quoted phrase after a marker
+
+	var protected,end_of_protection bool
+	var word []rune
+	var pair_quote string
+
+	for r := offset; r < len(runes); r++ {
+
+		if runes[r] == '"' || runes[r] == '\'' {
+			if protected {
+				end_of_protection = true
+			}
+			protected = !protected
+			pair_quote = string(runes[r]) + " "
+			continue
+		}
+
+		if !protected && unicode.IsSpace(runes[r]) || !protected && end_of_protection {
+			break
+		}
+
+		word = append(word,runes[r])
+	}
+
+	return strings.Trim(strings.TrimSpace(string(word)),pair_quote)
+}
+
+//**************************************************************
+
+func NeedsN4LQuotes(text string,symbols []string) bool {
+
+	if len(text) == 0 {
+		return false
+	}
+
+	if strings.TrimSpace(text) != text {
+		return true
+	}
+
+	// Characters that would start a context, chapter, alias, reference etc
+
+	if strings.ContainsRune("-+:@$(\"'#",[]rune(text)[0]) {
+		return true
+	}
+
+	if strings.ContainsAny(text,"()#\n\r") || strings.Contains(text,"//") {
+		return true
+	}
+
+	// Embedded quotes are fine as long as they pair up
+
+	if strings.Count(text,"\"")%2 != 0 {
+		return true
+	}
+
+	return StripN4LAnnotations(text,symbols) != text
+}
+
+//**************************************************************
+
+func QuoteN4LText(text string) string {
+
+	// N4L ends a quoted item at a quote followed by space, # or //
+
+	for _,quote := range []string{"\"","'"} {
+		if !EndsN4LQuote(text,quote) {
+			return quote + text + quote
+		}
+	}
+
+	return "\"" + text + "\""
+}
+
+//**************************************************************
+
+func EndsN4LQuote(text,quote string) bool {
+
+	runes := []rune(text+" ")
+
+	for r := 0; r < len(runes)-1; r++ {
+
+		if string(runes[r]) != quote {
+			continue
+		}
+
+		next := runes[r+1]
+
+		if unicode.IsSpace(next) || next == '#' || next == '/' && r+2 < len(runes) && runes[r+2] == '/' {
+			return true
+		}
+	}
+
+	return false
+}
+
+//**************************************************************
+
+func ExportArrowSpec(sst *PoSST,arr ArrowPtr,wgt float32,extra []string) string {
+
+	// (arrow,weight,extra context...) as read by N4L
+
+	spec := []string{GetDBArrowByPtr(sst,arr).Short}
+
+	if wgt != 1 {
+		spec = append(spec,strconv.FormatFloat(float64(wgt),'g',-1,32))
+	}
+
+	spec = append(spec,extra...)
+
+	return strings.Join(spec,",")
+}
+
+//**************************************************************
+
+func ExportContextItems(sst *PoSST,ptr ContextPtr) []string {
+
+	// The context at the top of an N4L file is "any"
+
+	var items []string
+
+	for _,c := range strings.Split(GetContext(sst,ptr),",") {
+		c = strings.TrimSpace(c)
+		if c != "" {
+			items = append(items,c)
+		}
+	}
+
+	if len(items) == 0 {
+		items = []string{"any"}
+	}
+
+	sort.Strings(items)
+	return items
+}
+
+//**************************************************************
+
+func ExportGhostItems(sst *PoSST,exp *N4LExport,nptr NodePtr) []string {
+
+	// The context membership recorded by the empty arrow
+
+	node := GetExportNode(sst,exp,nptr)
+
+	for st := range node.I {
+		for _,lnk := range node.I[st] {
+			if lnk.Arr == 0 {
+				return ExportContextItems(sst,lnk.Ctx)
+			}
+		}
+	}
+
+	return nil
+}
+
+//**************************************************************
+
+func HasLinkInContext(sst *PoSST,exp *N4LExport,from NodePtr,arr ArrowPtr,to NodePtr,items []string) bool {
+
+	node := GetExportNode(sst,exp,from)
+
+	for _,lnk := range node.I[GetDBArrowByPtr(sst,arr).STAindex] {
+		if lnk.Arr == arr && lnk.Dst == to {
+			return len(SubtractItems(items,ExportContextItems(sst,lnk.Ctx))) == 0
+		}
+	}
+
+	return false
+}
+
+//**************************************************************
+
+func CoverExportLink(sst *PoSST,exp *N4LExport,from NodePtr,arr ArrowPtr,to NodePtr,items []string) {
+
+	// N4L adds the inverse of every link it reads
+
+	CoverExportKey(exp,ExportLinkKey{From: from, Arr: arr, Dst: to},items)
+	CoverExportKey(exp,ExportLinkKey{From: to, Arr: GetInverseArrow(sst,arr), Dst: from},items)
+}
+
+//**************************************************************
+
+func CoverExportGhost(exp *N4LExport,nptr NodePtr,items []string) {
+
+	CoverExportKey(exp,ExportLinkKey{From: nptr},items)
+	exp.Written[nptr] = true
+}
+
+//**************************************************************
+
+func CoverExportKey(exp *N4LExport,key ExportLinkKey,items []string) {
+
+	if exp.Covered[key] == nil {
+		exp.Covered[key] = make(map[string]bool)
+	}
+
+	for _,c := range items {
+		exp.Covered[key][c] = true
+	}
+}
+
+//**************************************************************
+
+func IsExportCovered(exp *N4LExport,key ExportLinkKey,items []string) bool {
+
+	return len(UncoveredItems(exp,key,items)) == 0
+}
+
+//**************************************************************
+
+func UncoveredItems(exp *N4LExport,key ExportLinkKey,items []string) []string {
+
+	var retval []string
+
+	for _,c := range items {
+		if !exp.Covered[key][c] {
+			retval = append(retval,c)
+		}
+	}
+
+	return retval
+}
+
+//**************************************************************
+
+func GetExportArrowByName(sst *PoSST,name string) (ArrowPtr,bool) {
+
+	return GetArrowPtrByName(sst,name)
+}
+
+//**************************************************************
+
+func GetExportNode(sst *PoSST,exp *N4LExport,nptr NodePtr) Node {
+
+	// Straight from the store, so Dynamic: functions stay unexpanded
+
+	node,cached := exp.Nodes[nptr]
+
+	if !cached {
+		node,_ = sst.STORE.GetNode(DBContext(sst),sst,nptr)
+		node.NPtr = nptr
+		exp.Nodes[nptr] = node
+	}
+
+	return node
+}
+
+//**************************************************************
+
+func SubtractItems(from,remove []string) []string {
+
+	var retval []string
+
+	for _,c := range from {
+		if _,found := InList(c,remove); !found {
+			retval = append(retval,c)
+		}
+	}
+
+	return retval
+}
+
+//**************************************************************
+
+func IntersectItems(one,two []string) []string {
+
+	var retval []string
+
+	for _,c := range one {
+		if _,found := InList(c,two); found {
+			retval = append(retval,c)
+		}
+	}
+
+	return retval
+}
+
+//**************************************************************
+
+func ReadN4LConfig(dir string) N4LConfig {
+
+	// Read the inference rules from an SSTconfig directory
+
+	var config N4LConfig
+
+	config.Annotations = ReadAnnotationConfig(dir+"/annotations.sst")
+	config.Closures = ReadClosureConfig(dir+"/closures.sst")
+
+	return config
+}
+
+//**************************************************************
+
+func ReadAnnotationConfig(filename string) map[string]string {
+
+	// Read marker -> arrow pairs from an annotations.sst file, e.g.
+	//  = (involves)
+
+	content,err := os.ReadFile(filename)
+
+	if err != nil {
+		fmt.Println("Unable to read annotation config",filename,err)
+		return nil
+	}
+
+	var annotations = make(map[string]string)
+
+	for _,line := range strings.Split(string(content),"\n") {
+
+		if c := strings.Index(line,"//"); c >= 0 {
+			line = line[:c]
+		}
+
+		line = strings.TrimSpace(line)
+
+		if len(line) == 0 || line[0] == '#' || line[0] == '-' {
+			continue
+		}
+
+		lparen := strings.Index(line,"(")
+		rparen := strings.LastIndex(line,")")
+
+		if lparen < 1 || rparen < lparen {
+			continue
+		}
+
+		symbol := strings.TrimSpace(line[:lparen])
+		arrow := strings.TrimSpace(line[lparen+1:rparen])
+
+		if symbol != "" && arrow != "" {
+			annotations[symbol] = arrow
+		}
+	}
+
+	return annotations
+}
+
+//**************************************************************
+
+func ReadClosureConfig(filename string) []N4LClosure {
+
+	// Read arrow closures from a closures.sst file, e.g.
+	//  (ph) + (he) => (ep)
+
+	content,err := os.ReadFile(filename)
+
+	if err != nil {
+		fmt.Println("Unable to read closure config",filename,err)
+		return nil
+	}
+
+	var closures []N4LClosure
+
+	for _,line := range strings.Split(string(content),"\n") {
+
+		if c := strings.Index(line,"//"); c >= 0 {
+			line = line[:c]
+		}
+
+		parts := strings.Split(line,"=>")
+
+		if len(parts) != 2 {
+			continue
+		}
+
+		var closure N4LClosure
+
+		closure.Sequence = ParenthesizedNames(parts[0])
+		result := ParenthesizedNames(parts[1])
+
+		if len(closure.Sequence) == 0 || len(result) != 1 {
+			continue
+		}
+
+		closure.Result = result[0]
+		closures = append(closures,closure)
+	}
+
+	return closures
+}
+
+//**************************************************************
+
+func ParenthesizedNames(s string) []string {
+
+	var names []string
+
+	for {
+		lparen := strings.Index(s,"(")
+		rparen := strings.Index(s,")")
+
+		if lparen < 0 || rparen < lparen {
+			return names
+		}
+
+		names = append(names,strings.TrimSpace(s[lparen+1:rparen]))
+		s = s[rparen+1:]
+	}
+}
+
+//
+// export_n4l.go
+//
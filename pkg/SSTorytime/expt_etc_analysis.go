@@ -39,7 +39,7 @@ func CollapsePsi(sst *PoSST,node Node,stindex int) (Etc, string) {
 
 	message := ""
 
-	arrow := sst.ARROW_DIRECTORY[node.I[stindex][0].Arr].Long
+	arrow := GetDBArrowByPtr(sst,node.I[stindex][0].Arr).Long
 
 	switch sttype {
 		
@@ -51,7 +51,7 @@ func CollapsePsi(sst *PoSST,node Node,stindex int) (Etc, string) {
 		// skip bogus empty links
 		for l := 0; l < len(node.I[stindex]); l++ {
 
-			arrow = sst.ARROW_DIRECTORY[node.I[stindex][l].Arr].Long
+			arrow = GetDBArrowByPtr(sst,node.I[stindex][l].Arr).Long
 
 			if arrow == "empty" || arrow == "debug" {
 				continue
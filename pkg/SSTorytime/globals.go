@@ -6,14 +6,45 @@
 
 package SSTorytime
 
+// Errors returned by the library, test for them with errors.Is()
+
+const (
+	ERR_ST_OUT_OF_BOUNDS SSTError = "Link STtype is out of bounds (must be -3 to +3)"
+	ERR_ILLEGAL_LINK_CLASS SSTError = "ILLEGAL LINK CLASS"
+	ERR_NO_SUCH_ARROW SSTError = "No such arrow has been declared in the configuration"
+	ERR_MEMORY_DB_ARROW_MISMATCH SSTError = "Arrows in database are not in synch (shouldn't happen)"
+	ERR_MEMORY_DB_CONTEXT_MISMATCH SSTError = "Contexts in database are not in synch (shouldn't happen)"
+	ERR_UNKNOWN_STORE SSTError = "Unknown storage backend (expected postgres://, sqlite:// or memory:)"
+	ERR_DB_CONNECT SSTError = "Error connecting to the database"
+	ERR_DB_CONFIGURE SSTError = "Unable to create the database tables and types"
+	ERR_NODE_EXISTS SSTError = "Node already exists in the database, overlapping files need to be reloaded together"
+	ERR_NO_SUCH_NODE_CLASS SSTError = "Non existent node class (shouldn't happen)"
+	ERR_SELF_LOOP SSTError = "Self-loops are not allowed"
+	ERR_NO_ARROWS SSTError = "No arrows have yet been defined, so you can't rely on the arrow names"
+	ERR_ZERO_WEIGHT SSTError = "Attempt to register a link with zero weight is pointless"
+	ERR_HUB_NO_NODES SSTError = "Call to HubJoin with a null list of pointers"
+	ERR_HUB_WEIGHTS SSTError = "Call to HubJoin with inconsistent node/weight pointer arrays"
+	ERR_NO_SUCH_FILE SSTError = "Unable to read file"
+	ERR_MALFORMED_QUERY SSTError = "Malformed search query"
+	ERR_NO_SUCH_NODE SSTError = "No such node in the database"
+	ERR_NO_SUCH_LINK SSTError = "No such link between these nodes"
+	ERR_NODE_NAME SSTError = "A node needs a non-empty name that no other node has"
+	ERR_NODE_CLASS SSTError = "A node can only be renamed to a name of the same length class"
+	ERR_EDIT_FAILED SSTError = "Unable to update the node in the database"
+	ERR_MERGE_SELF SSTError = "A node cannot be merged with itself"
+	ERR_NO_SUCH_ALIAS SSTError = "No node was merged under this name"
+	ERR_ALIAS_REUSED SSTError = "The merged node's NodePtr or name has since been reused"
+	ERR_BATCH_CLOSED SSTError = "This batch has already been committed or rolled back"
+	ERR_BATCH_CONFLICT SSTError = "Nodes were added or removed during the batch, so its NodePtrs are out of date"
+	ERR_BATCH_FAILED SSTError = "Unable to commit the batch, nothing was changed"
+	ERR_NO_SUCH_REVISION SSTError = "No such revision in the change log"
+	ERR_DOWNLOAD_FAILED SSTError = "Unable to read the arrow or context directory from the database"
+	ERR_ASOF_TOO_LARGE SSTError = "The graph is too large to copy for a search of an earlier revision, see ASOF_MAX_NODES"
+)
+
 const (
 	CREDENTIALS_FILE = ".SSTorytime" // user's home directory
 
-	ERR_ST_OUT_OF_BOUNDS = "Link STtype is out of bounds (must be -3 to +3)"
-	ERR_ILLEGAL_LINK_CLASS = "ILLEGAL LINK CLASS"
-	ERR_NO_SUCH_ARROW = "No such arrow has been declared in the configuration: "
-	ERR_MEMORY_DB_ARROW_MISMATCH = "Arrows in database are not in synch (shouldn't happen)"
-	ERR_MEMORY_DB_CONTEXT_MISMATCH = "Contexts in database are not in synch (shouldn't happen)"
 	WARN_DIFFERENT_CAPITALS = "WARNING: A similar capitalization/punctuation exists"
 
 	SCREENWIDTH = 120
@@ -105,16 +136,17 @@ var (
 	NO_NODE_PTR NodePtr // see Init()
 	NONODE NodePtr
 
-	// Uploading
+	// Deprecated: set sst.WIPE instead. If true, every session opened wipes
 
 	WIPE_DB bool = false
+
         SILLINESS_COUNTER int
         SILLINESS_POS int
         SILLINESS_SLOGAN int
 	SILLINESS bool
 
 
-        // Text analysis
+        // Text analysis, guarded by STM_LOCK
 
         STM_INT_FRAG = make(map[string]History) // for intentional (exceptional) fragments
         STM_AMB_FRAG = make(map[string]History) // for ambient (repeated) fragments
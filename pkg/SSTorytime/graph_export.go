@@ -0,0 +1,543 @@
+// **************************************************************************
+//
+// graph_export.go
+//
+// Serialize chapters and search results for other graph tools,
+// e.g. Gephi (GEXF), yEd (GraphML) and Graphviz (DOT)
+//
+// **************************************************************************
+
+package SSTorytime
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"encoding/xml"
+	_ "github.com/lib/pq"
+
+)
+
+// **************************************************************************
+
+const (
+	GRAPH_FORMAT_GRAPHML = "graphml"
+	GRAPH_FORMAT_GEXF = "gexf"
+	GRAPH_FORMAT_DOT = "dot"
+	GRAPH_FORMAT_TURTLE = "turtle"
+	GRAPH_FORMAT_TRIG = "trig"
+	GRAPH_FORMAT_JSONLD = "jsonld"
+)
+
+var GRAPH_FORMATS = []string{ GRAPH_FORMAT_GRAPHML, GRAPH_FORMAT_GEXF, GRAPH_FORMAT_DOT, GRAPH_FORMAT_TURTLE, GRAPH_FORMAT_TRIG, GRAPH_FORMAT_JSONLD }
+
+// **************************************************************************
+
+type GraphExport struct {
+
+	Nodes []GraphExportNode
+	Edges []GraphExportEdge
+}
+
+// **************************************************************************
+
+type GraphExportNode struct {
+
+	NPtr    NodePtr
+	Text    string
+	Chapter string
+}
+
+// **************************************************************************
+
+type GraphExportEdge struct {
+
+	From    NodePtr
+	To      NodePtr
+	Arr     ArrowPtr
+	Short   string
+	Long    string
+	STType  int
+	Wgt     float32
+	Context string
+}
+
+// **************************************************************************
+
+func IsGraphFormat(format string) bool {
+
+	for f := range GRAPH_FORMATS {
+		if format == GRAPH_FORMATS[f] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// **************************************************************************
+
+func GetSearchGraph(sst *PoSST,search SearchParameters) GraphExport {
+
+	// Select nodes the way searchN4L would, then export the links between them.
+	// Names or from/to expand to cones or paths, otherwise the whole chapter
+
+	arrowptrs,sttypes := ArrowPtrFromArrowsNames(sst,search.Arrows)
+	minlimit,maxlimit := MinMaxPolicy(search)
+
+	var nptrs []NodePtr
+
+	if search.Name == nil && search.From == nil && search.To == nil {
+		nptrs = GetChapterGraphNodes(sst,search.Chapter)
+		return GetNodeGraph(sst,nptrs,search)
+	}
+
+	if search.From != nil && search.To != nil {
+
+		leftptrs := SolveNodePtrs(CopySession(sst),search.From,search,arrowptrs,maxlimit)
+		rightptrs := SolveNodePtrs(CopySession(sst),search.To,search,arrowptrs,maxlimit)
+
+		paths := GetPathsAndSymmetries(sst,leftptrs,rightptrs,search.Chapter,search.Context,arrowptrs,sttypes,minlimit,maxlimit)
+		nptrs = append(leftptrs,rightptrs...)
+		nptrs = append(nptrs,GetPathNodes(paths)...)
+
+		return GetNodeGraph(sst,nptrs,search)
+	}
+
+	var start []NodePtr
+
+	start = append(start,SolveNodePtrs(CopySession(sst),search.Name,search,arrowptrs,maxlimit)...)
+	start = append(start,SolveNodePtrs(CopySession(sst),search.From,search,arrowptrs,maxlimit)...)
+	start = append(start,SolveNodePtrs(CopySession(sst),search.To,search,arrowptrs,maxlimit)...)
+
+	if len(sttypes) == 0 {
+		sttypes = []int{-EXPRESS,-CONTAINS,-LEADSTO,NEAR,LEADSTO,CONTAINS,EXPRESS}
+	}
+
+	for _,nptr := range start {
+
+		nptrs = append(nptrs,nptr)
+
+		for _,st := range sttypes {
+			cone,_ := GetFwdPathsAsLinks(sst,nptr,st,maxlimit,CAUSAL_CONE_MAXLIMIT)
+			nptrs = append(nptrs,GetPathNodes(cone)...)
+		}
+	}
+
+	return GetNodeGraph(sst,nptrs,search)
+}
+
+// **************************************************************************
+
+func GetChapterGraphNodes(sst *PoSST,chapter string) []NodePtr {
+
+	include,exclude := SplitChapterExclusions(chapter)
+
+	if include == "%%" || include == "any" {
+		include = ""
+	}
+
+	var nptrs []NodePtr
+
+	for _,chap := range GetDBChaptersMatchingName(CopySession(sst),include) {
+
+		if len(exclude) > 0 && !GraphChapterMatch(chap,chapter) {
+			continue
+		}
+
+		nptrs = append(nptrs,GetDBNodePtrsByChapter(sst,chap)...)
+	}
+
+	return nptrs
+}
+
+// **************************************************************************
+
+func GetPathNodes(paths [][]Link) []NodePtr {
+
+	var nptrs []NodePtr
+
+	for p := range paths {
+		for l := range paths[p] {
+			nptrs = append(nptrs,paths[p][l].Dst)
+		}
+	}
+
+	return nptrs
+}
+
+// **************************************************************************
+
+func GetNodeGraph(sst *PoSST,nptrs []NodePtr,search SearchParameters) GraphExport {
+
+	// Serialize the nodes and the links in their Node.I arrays that
+	// stay inside the set and pass the chapter/context/arrow filters
+
+	var graph GraphExport
+	var nodes = make(map[NodePtr]Node)
+
+	arrowptrs,sttypes := ArrowPtrFromArrowsNames(sst,search.Arrows)
+
+	for _,nptr := range nptrs {
+
+		if _,done := nodes[nptr]; done {
+			continue
+		}
+
+		node := GetDBNodeByNodePtr(sst,nptr)
+
+		if node.S == "" || !GraphChapterMatch(node.Chap,search.Chapter) {
+			continue
+		}
+
+		nodes[nptr] = node
+		graph.Nodes = append(graph.Nodes,GraphExportNode{NPtr: nptr, Text: node.S, Chapter: node.Chap})
+	}
+
+	sort.Slice(graph.Nodes, func(i,j int) bool {
+		return LessNodePtr(graph.Nodes[i].NPtr,graph.Nodes[j].NPtr)
+	})
+
+	var seen = make(map[GraphExportEdge]bool)
+
+	for _,gn := range graph.Nodes {
+
+		node := nodes[gn.NPtr]
+
+		for st := range node.I {
+			for _,lnk := range node.I[st] {
+
+				if lnk.Arr == 0 {
+					continue // context membership, not a link
+				}
+
+				if _,inside := nodes[lnk.Dst]; !inside {
+					continue
+				}
+
+				if !MatchContexts(sst,search.Context,lnk.Ctx) {
+					continue
+				}
+
+				edge := GetGraphEdge(sst,gn.NPtr,st,lnk)
+
+				if !GraphArrowMatch(sst,edge,arrowptrs,sttypes) {
+					continue
+				}
+
+				// Every link is stored from both ends, so only keep one of them
+
+				key := edge
+				key.Wgt = 0
+
+				if seen[key] {
+					continue
+				}
+
+				seen[key] = true
+				graph.Edges = append(graph.Edges,edge)
+			}
+		}
+	}
+
+	// A context or arrow filter selects links, so drop what they leave isolated
+
+	if len(search.Context) > 0 || len(arrowptrs) > 0 || len(sttypes) > 0 {
+
+		var linked = make(map[NodePtr]bool)
+		var kept []GraphExportNode
+
+		for _,edge := range graph.Edges {
+			linked[edge.From] = true
+			linked[edge.To] = true
+		}
+
+		for _,gn := range graph.Nodes {
+			if linked[gn.NPtr] {
+				kept = append(kept,gn)
+			}
+		}
+
+		graph.Nodes = kept
+	}
+
+	return graph
+}
+
+// **************************************************************************
+
+func GetGraphEdge(sst *PoSST,from NodePtr,stindex int,lnk Link) GraphExportEdge {
+
+	// Turn inverse links around, so each link is seen in its declared direction
+
+	var edge GraphExportEdge
+
+	edge.From = from
+	edge.To = lnk.Dst
+	edge.Arr = lnk.Arr
+	edge.Wgt = lnk.Wgt
+	edge.STType = STIndexToSTType(stindex)
+
+	if edge.STType < 0 {
+		edge.From,edge.To = edge.To,edge.From
+		edge.Arr = GetInverseArrow(sst,lnk.Arr)
+		edge.STType = -edge.STType
+	}
+
+	if edge.STType == NEAR && LessNodePtr(edge.To,edge.From) {
+		edge.From,edge.To = edge.To,edge.From
+	}
+
+	arrow := GetDBArrowByPtr(sst,edge.Arr)
+	edge.Short = arrow.Short
+	edge.Long = arrow.Long
+
+	ctxstr,_ := sst.STORE.GetContextByPtr(DBContext(sst),sst,lnk.Ctx)
+	edge.Context = ctxstr
+
+	return edge
+}
+
+// **************************************************************************
+
+func GraphChapterMatch(chaps,chapter string) bool {
+
+	include,exclude := SplitChapterExclusions(chapter)
+
+	if include == "%%" || include == "any" {
+		include = ""
+	}
+
+	for _,chap := range strings.Split(chaps,",") {
+
+		if !strings.Contains(strings.ToLower(chap),strings.ToLower(include)) {
+			continue
+		}
+
+		excluded := false
+
+		for _,ex := range exclude {
+			if strings.Contains(strings.ToLower(chap),strings.ToLower(ex)) {
+				excluded = true
+				break
+			}
+		}
+
+		if !excluded {
+			return true
+		}
+	}
+
+	return false
+}
+
+// **************************************************************************
+
+func GraphArrowMatch(sst *PoSST,edge GraphExportEdge,arrowptrs []ArrowPtr,sttypes []int) bool {
+
+	// Named arrows are the tighter constraint, so they win over their types
+
+	if len(arrowptrs) > 0 {
+		return MatchArrows(arrowptrs,edge.Arr) || MatchArrows(arrowptrs,GetInverseArrow(sst,edge.Arr))
+	}
+
+	if len(sttypes) == 0 {
+		return true
+	}
+
+	for _,st := range sttypes {
+		if st == edge.STType || st == -edge.STType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// **************************************************************************
+
+func LessNodePtr(a,b NodePtr) bool {
+
+	if a.Class != b.Class {
+		return a.Class < b.Class
+	}
+
+	return a.CPtr < b.CPtr
+}
+
+// **************************************************************************
+
+func GraphNodeId(nptr NodePtr) string {
+
+	return fmt.Sprintf("n%d_%d",nptr.Class,nptr.CPtr)
+}
+
+// **************************************************************************
+
+func FormatGraph(sst *PoSST,graph GraphExport,format string) string {
+
+	switch format {
+	case GRAPH_FORMAT_GRAPHML:
+		return GraphToGraphML(graph)
+	case GRAPH_FORMAT_GEXF:
+		return GraphToGEXF(graph)
+	case GRAPH_FORMAT_DOT:
+		return GraphToDOT(graph)
+	case GRAPH_FORMAT_TURTLE:
+		return WriteTurtle(GraphToRDF(sst,graph))
+	case GRAPH_FORMAT_TRIG:
+		return WriteTriG(GraphToRDF(sst,graph))
+	case GRAPH_FORMAT_JSONLD:
+		return WriteJSONLD(GraphToRDF(sst,graph))
+	}
+
+	fmt.Println("Unknown graph export format",format,"- try one of",GRAPH_FORMATS)
+	return ""
+}
+
+// **************************************************************************
+
+func GraphToGraphML(graph GraphExport) string {
+
+	var out strings.Builder
+
+	out.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	out.WriteString("<graphml xmlns=\"http://graphml.graphdrawing.org/xmlns\">\n")
+	out.WriteString("  <key id=\"label\" for=\"node\" attr.name=\"label\" attr.type=\"string\"/>\n")
+	out.WriteString("  <key id=\"chapter\" for=\"node\" attr.name=\"chapter\" attr.type=\"string\"/>\n")
+	out.WriteString("  <key id=\"arrow\" for=\"edge\" attr.name=\"arrow\" attr.type=\"string\"/>\n")
+	out.WriteString("  <key id=\"long\" for=\"edge\" attr.name=\"long\" attr.type=\"string\"/>\n")
+	out.WriteString("  <key id=\"sttype\" for=\"edge\" attr.name=\"sttype\" attr.type=\"int\"/>\n")
+	out.WriteString("  <key id=\"weight\" for=\"edge\" attr.name=\"weight\" attr.type=\"double\"/>\n")
+	out.WriteString("  <key id=\"context\" for=\"edge\" attr.name=\"context\" attr.type=\"string\"/>\n")
+	out.WriteString("  <graph id=\"SSTorytime\" edgedefault=\"directed\">\n")
+
+	for _,n := range graph.Nodes {
+		out.WriteString(fmt.Sprintf("    <node id=\"%s\">\n",GraphNodeId(n.NPtr)))
+		out.WriteString(fmt.Sprintf("      <data key=\"label\">%s</data>\n",XMLEscape(n.Text)))
+		out.WriteString(fmt.Sprintf("      <data key=\"chapter\">%s</data>\n",XMLEscape(n.Chapter)))
+		out.WriteString("    </node>\n")
+	}
+
+	for e,edge := range graph.Edges {
+		out.WriteString(fmt.Sprintf("    <edge id=\"e%d\" source=\"%s\" target=\"%s\" directed=\"%t\">\n",e,GraphNodeId(edge.From),GraphNodeId(edge.To),edge.STType != NEAR))
+		out.WriteString(fmt.Sprintf("      <data key=\"arrow\">%s</data>\n",XMLEscape(edge.Short)))
+		out.WriteString(fmt.Sprintf("      <data key=\"long\">%s</data>\n",XMLEscape(edge.Long)))
+		out.WriteString(fmt.Sprintf("      <data key=\"sttype\">%d</data>\n",edge.STType))
+		out.WriteString(fmt.Sprintf("      <data key=\"weight\">%g</data>\n",edge.Wgt))
+		out.WriteString(fmt.Sprintf("      <data key=\"context\">%s</data>\n",XMLEscape(edge.Context)))
+		out.WriteString("    </edge>\n")
+	}
+
+	out.WriteString("  </graph>\n")
+	out.WriteString("</graphml>\n")
+
+	return out.String()
+}
+
+// **************************************************************************
+
+func GraphToGEXF(graph GraphExport) string {
+
+	var out strings.Builder
+
+	out.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	out.WriteString("<gexf xmlns=\"http://gexf.net/1.3\" version=\"1.3\">\n")
+	out.WriteString("  <graph defaultedgetype=\"directed\" mode=\"static\">\n")
+	out.WriteString("    <attributes class=\"node\">\n")
+	out.WriteString("      <attribute id=\"chapter\" title=\"chapter\" type=\"string\"/>\n")
+	out.WriteString("    </attributes>\n")
+	out.WriteString("    <attributes class=\"edge\">\n")
+	out.WriteString("      <attribute id=\"arrow\" title=\"arrow\" type=\"string\"/>\n")
+	out.WriteString("      <attribute id=\"long\" title=\"long\" type=\"string\"/>\n")
+	out.WriteString("      <attribute id=\"sttype\" title=\"sttype\" type=\"integer\"/>\n")
+	out.WriteString("      <attribute id=\"context\" title=\"context\" type=\"string\"/>\n")
+	out.WriteString("    </attributes>\n")
+
+	out.WriteString("    <nodes>\n")
+
+	for _,n := range graph.Nodes {
+		out.WriteString(fmt.Sprintf("      <node id=\"%s\" label=\"%s\">\n",GraphNodeId(n.NPtr),XMLEscape(n.Text)))
+		out.WriteString(fmt.Sprintf("        <attvalues><attvalue for=\"chapter\" value=\"%s\"/></attvalues>\n",XMLEscape(n.Chapter)))
+		out.WriteString("      </node>\n")
+	}
+
+	out.WriteString("    </nodes>\n")
+	out.WriteString("    <edges>\n")
+
+	for e,edge := range graph.Edges {
+
+		kind := "directed"
+
+		if edge.STType == NEAR {
+			kind = "undirected"
+		}
+
+		out.WriteString(fmt.Sprintf("      <edge id=\"e%d\" source=\"%s\" target=\"%s\" type=\"%s\" weight=\"%g\" label=\"%s\">\n",e,GraphNodeId(edge.From),GraphNodeId(edge.To),kind,edge.Wgt,XMLEscape(edge.Short)))
+		out.WriteString("        <attvalues>\n")
+		out.WriteString(fmt.Sprintf("          <attvalue for=\"arrow\" value=\"%s\"/>\n",XMLEscape(edge.Short)))
+		out.WriteString(fmt.Sprintf("          <attvalue for=\"long\" value=\"%s\"/>\n",XMLEscape(edge.Long)))
+		out.WriteString(fmt.Sprintf("          <attvalue for=\"sttype\" value=\"%d\"/>\n",edge.STType))
+		out.WriteString(fmt.Sprintf("          <attvalue for=\"context\" value=\"%s\"/>\n",XMLEscape(edge.Context)))
+		out.WriteString("        </attvalues>\n")
+		out.WriteString("      </edge>\n")
+	}
+
+	out.WriteString("    </edges>\n")
+	out.WriteString("  </graph>\n")
+	out.WriteString("</gexf>\n")
+
+	return out.String()
+}
+
+// **************************************************************************
+
+func GraphToDOT(graph GraphExport) string {
+
+	var out strings.Builder
+
+	out.WriteString("digraph \"SSTorytime\" {\n")
+
+	for _,n := range graph.Nodes {
+		out.WriteString(fmt.Sprintf("  \"%s\" [label=\"%s\", chapter=\"%s\"];\n",GraphNodeId(n.NPtr),DOTEscape(n.Text),DOTEscape(n.Chapter)))
+	}
+
+	for _,edge := range graph.Edges {
+
+		var dir string
+
+		if edge.STType == NEAR {
+			dir = ", dir=none"
+		}
+
+		out.WriteString(fmt.Sprintf("  \"%s\" -> \"%s\" [label=\"%s\", long=\"%s\", sttype=%d, weight=%g, context=\"%s\"%s];\n",
+			GraphNodeId(edge.From),GraphNodeId(edge.To),DOTEscape(edge.Short),DOTEscape(edge.Long),edge.STType,edge.Wgt,DOTEscape(edge.Context),dir))
+	}
+
+	out.WriteString("}\n")
+
+	return out.String()
+}
+
+// **************************************************************************
+
+func XMLEscape(s string) string {
+
+	var buf strings.Builder
+	xml.EscapeText(&buf,[]byte(s))
+	return buf.String()
+}
+
+// **************************************************************************
+
+func DOTEscape(s string) string {
+
+	s = strings.ReplaceAll(s,"\\","\\\\")
+	s = strings.ReplaceAll(s,"\"","\\\"")
+	s = strings.ReplaceAll(s,"\n","\\n")
+	return s
+}
+
+//
+// graph_export.go
+//
@@ -29,6 +29,7 @@ func JSONNodeEvent(sst PoSST, nptr NodePtr,xyz Coords,orbits [ST_TOP][]Orbit) No
 	event.NPtr = nptr
 	event.XYZ = xyz
 	event.Orbits = orbits
+	event.Provenance = GetDBProvenance(&sst,nptr)
 	return event
 }
 
@@ -61,7 +62,7 @@ func LinkWebPaths(sst *PoSST,cone [][]Link,nth int,chapter string,context []stri
 
 			nextnode := GetDBNodeByNodePtr(sst,cone[p][l].Dst)
 
-			if !SimilarString(nextnode.Chap,chapter) {
+			if !SimilarChapter(nextnode.Chap,chapter) {
 				break
 			}
 			
@@ -316,7 +317,7 @@ func GetLongestAxialPath(sst *PoSST,nptr NodePtr,arrowptr ArrowPtr,limit int) []
 
 	var max int = 1
 
-	sttype := STIndexToSTType(sst.ARROW_DIRECTORY[arrowptr].STAindex)
+	sttype := STIndexToSTType(GetDBArrowByPtr(sst,arrowptr).STAindex)
 
 	paths,dim := GetFwdPathsAsLinks(sst,nptr,sttype,limit,limit)
 
@@ -7,6 +7,7 @@
 package SSTorytime
 
 import (
+	"context"
 	"fmt"
 	_ "github.com/lib/pq"
 
@@ -17,25 +18,57 @@ import (
 
 func UpdateLastSawSection(sst PoSST,name string) {
 
+	sst.STORE.LastSawSection(DBContext(&sst),&sst,name)
+}
+
+// *********************************************************************
+
+func (pg PostgresStore) LastSawSection(ctx context.Context,sst *PoSST,name string) {
+
 	s := fmt.Sprintf("select LastSawSection('%s')",name)
-	sst.DB.QueryRow(s)
+	sst.DB.QueryRowContext(ctx,s)
 }
 
 // *********************************************************************
 
 func UpdateLastSawNPtr(sst PoSST,class,cptr int,name string) {
 
-	s := fmt.Sprintf("select LastSawNPtr('(%d,%d)','%s')",class,cptr,name)
-	sst.DB.QueryRow(s)
+	var nptr NodePtr
+
+	nptr.Class = class
+	nptr.CPtr = ClassedNodePtr(cptr)
+
+	sst.STORE.LastSawNPtr(DBContext(&sst),&sst,nptr,name)
+}
+
+// *********************************************************************
+
+func (pg PostgresStore) LastSawNPtr(ctx context.Context,sst *PoSST,nptr NodePtr,name string) {
+
+	s := fmt.Sprintf("select LastSawNPtr('(%d,%d)','%s')",nptr.Class,nptr.CPtr,name)
+	sst.DB.QueryRowContext(ctx,s)
 }
 
 //******************************************************************
 
 func GetLastSawSection(sst PoSST) []LastSeen {
 
+	ret := sst.STORE.GetLastSeen(DBContext(&sst),&sst)
+
+	for c := 0; c < len(ret); c++ {
+		ret[c].XYZ = AssignChapterCoordinates(c,len(ret))
+	}
+
+	return ret
+}
+
+//******************************************************************
+
+func (pg PostgresStore) GetLastSeen(ctx context.Context,sst *PoSST) []LastSeen {
+
 	qstr := fmt.Sprintf("SELECT section,nptr,EXTRACT(EPOCH FROM first),EXTRACT(EPOCH FROM last),freq,delta as pdelta,EXTRACT(EPOCH FROM NOW()-last) as ndelta from Lastseen ORDER BY section")
 
-	row,err := sst.DB.Query(qstr)
+	row,err := sst.DB.QueryContext(ctx,qstr)
 
 	if err != nil {
 		fmt.Println("GetLastSawSection failed\n",qstr,err)
@@ -56,10 +89,6 @@ func GetLastSawSection(sst PoSST) []LastSeen {
 			ret = append(ret,ls)
 		}
 
-		for c := 0; c < len(ret); c++ {
-			ret[c].XYZ = AssignChapterCoordinates(c,len(ret))
-		}
-
 		row.Close()
 	}
 
@@ -70,11 +99,18 @@ func GetLastSawSection(sst PoSST) []LastSeen {
 
 func GetLastSawNPtr(sst PoSST, nptr NodePtr) LastSeen {
 
+	return sst.STORE.GetLastSeenNPtr(DBContext(&sst),&sst,nptr)
+}
+
+//******************************************************************
+
+func (pg PostgresStore) GetLastSeenNPtr(ctx context.Context,sst *PoSST, nptr NodePtr) LastSeen {
+
 	var ls LastSeen
 
 	qstr := fmt.Sprintf("SELECT section,EXTRACT(EPOCH FROM first),EXTRACT(EPOCH FROM last),freq,delta as pdelta,EXTRACT(EPOCH FROM NOW()-last) as ndelta from Lastseen WHERE NPTR='(%d,%d)'::NodePtr",nptr.Class,nptr.CPtr)
 
-	row,err := sst.DB.Query(qstr)
+	row,err := sst.DB.QueryContext(ctx,qstr)
 
 	if err != nil {
 		fmt.Println("GetLastSawNPtr failed\n",qstr,err)
@@ -101,20 +137,27 @@ func GetLastSawNPtr(sst PoSST, nptr NodePtr) LastSeen {
 
 func GetNewlySeenNPtrs(sst PoSST,search SearchParameters) map[NodePtr]bool {
 
+	return sst.STORE.GetNewlySeen(DBContext(&sst),&sst,search.Horizon)
+}
+
+// *********************************************************************
+
+func (pg PostgresStore) GetNewlySeen(ctx context.Context,sst *PoSST,horizon int) map[NodePtr]bool {
+
 	var qstr string
 	var nptrs = make (map[NodePtr]bool)
 
-	switch search.Horizon {
+	switch horizon {
 
 	case RECENT:
-		qstr = fmt.Sprintf("SELECT NPtr FROM LastSeen WHERE last > NOW() - INTERVAL '%d hour'",search.Horizon)
+		qstr = fmt.Sprintf("SELECT NPtr FROM LastSeen WHERE last > NOW() - INTERVAL '%d hour'",horizon)
 	case NEVER:
 		qstr = "SELECT NPtr FROM LastSeen"
 	default:
 		return nptrs
 	}
 
-	row,err := sst.DB.Query(qstr)
+	row,err := sst.DB.QueryContext(ctx,qstr)
 	
 	if err != nil {
 		fmt.Println("Failed to get LastSeen",err)
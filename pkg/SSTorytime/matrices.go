@@ -34,7 +34,18 @@ func GetDBAdjacentNodePtrBySTType(sst PoSST,sttypes []int,chap string,cn []strin
 
 	for st := 0; st < len(sttypes); st++ {
 
-		stname := STTypeDBChannel(sttypes[st])
+		if sttypes[st] < -EXPRESS || sttypes[st] > EXPRESS {
+			fmt.Println(ERR_ST_OUT_OF_BOUNDS,sttypes[st])
+			return nil,nil
+		}
+
+		stname,err := STTypeDBChannel(sttypes[st])
+
+		if err != nil {
+			fmt.Println(err)
+			return nil,nil
+		}
+
 		qwhere += fmt.Sprintf("array_length(%s::text[],1) IS NOT NULL AND match_context((%s)[0].Ctx,%s)",stname,stname,context)
 
 		if st != dim-1 {
@@ -47,7 +58,7 @@ func GetDBAdjacentNodePtrBySTType(sst PoSST,sttypes []int,chap string,cn []strin
 
 	qstr = fmt.Sprintf("SELECT NPtr%s FROM Node WHERE lower(Chap) LIKE lower('%s') AND (%s)",qsearch,chapter,qwhere)
 
-	row, err := sst.DB.Query(qstr)
+	row, err := sst.DB.QueryContext(DBContext(&sst),qstr)
 
 	if err != nil {
 		fmt.Println("QUERY GetDBAdjacentNodePtrBySTType Failed",err)
@@ -468,12 +479,12 @@ func AdjointLinkPath(sst *PoSST,LL []Link) []Link {
 	// len(seq)-1 matches the last node of right join
 	// when we invert, links and destinations are shifted
 
-	var prevarrow ArrowPtr = sst.INVERSE_ARROWS[0]
+	var prevarrow ArrowPtr = GetInverseArrow(sst,0)
 
 	for j := len(LL)-1; j >= 0; j-- {
 
 		var lnk Link = LL[j]
-		lnk.Arr = sst.INVERSE_ARROWS[prevarrow]
+		lnk.Arr = GetInverseArrow(sst,prevarrow)
 		adjoint = append(adjoint,lnk)
 		prevarrow = LL[j].Arr
 	}
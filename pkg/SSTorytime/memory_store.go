@@ -0,0 +1,1633 @@
+//**************************************************************
+//
+// memory_store.go
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	_ "github.com/lib/pq"
+
+)
+
+//**************************************************************
+// A pure Go backend that keeps the whole graph in memory, for
+// tests and notebooks that have no database. Nothing persists
+// after the process ends. Open it with
+//
+//   sst := OpenStore(NewMemoryStore(),true)
+//**************************************************************
+
+type MemoryStore struct {
+
+	lock      sync.RWMutex
+
+	Nodes     map[NodePtr]Node
+	Names     map[string][]NodePtr
+	Top       [N_CHANNELS]ClassedNodePtr
+
+	Arrows    []ArrowDirectory
+	Inverses  map[ArrowPtr]ArrowPtr
+	Contexts  []ContextDirectory
+
+	PageMap   []PageMap
+	Bookmarks []Bookmark
+	LastSeen  []LastSeen
+	Aliases   []NodeAlias
+	Provenance map[NodePtr][]Provenance // by NPtr
+	Changes   []Change                  // oldest first
+}
+
+//**************************************************************
+
+func NewMemoryStore() *MemoryStore {
+
+	var m MemoryStore
+
+	m.Reset()
+	return &m
+}
+
+//**************************************************************
+
+func (m *MemoryStore) Reset() {
+
+	m.Nodes = make(map[NodePtr]Node)
+	m.Names = make(map[string][]NodePtr)
+	m.Inverses = make(map[ArrowPtr]ArrowPtr)
+	m.Top = [N_CHANNELS]ClassedNodePtr{}
+	m.Arrows = nil
+	m.Contexts = nil
+	m.PageMap = nil
+	m.Bookmarks = nil
+	m.LastSeen = nil
+	m.Aliases = nil
+	m.Provenance = make(map[NodePtr][]Provenance)
+	m.Changes = nil
+}
+
+// **************************************************************************
+// Session
+// **************************************************************************
+
+func (m *MemoryStore) Configure(ctx context.Context,sst *PoSST,load_arrows bool) error {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if sst.WIPE || m.Nodes == nil {
+		m.Reset()
+	}
+
+	return nil
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) Finalize(ctx context.Context,sst *PoSST) {
+
+	// No indices to build
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) Close(sst *PoSST) {
+
+	// The graph lives as long as the store
+}
+
+// **************************************************************************
+// Nodes and links
+// **************************************************************************
+
+func (m *MemoryStore) UploadNodes(ctx context.Context,sst *PoSST,nodes []Node) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _,n := range nodes {
+
+		if n.S == "" {
+			continue // placeholder from SynchronizeNPtrs
+		}
+
+		m.SetNode(n)
+	}
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) SetNode(n Node) {
+
+	// Caller holds the lock
+
+	old,exists := m.Nodes[n.NPtr]
+
+	if exists {
+		m.Names[old.S] = DeleteNodePtr(m.Names[old.S],n.NPtr)
+	}
+
+	m.Nodes[n.NPtr] = n
+	m.Names[n.S] = IdempAddNodePtr(m.Names[n.S],n.NPtr)
+
+	if n.NPtr.Class > 0 && n.NPtr.Class < N_CHANNELS && n.NPtr.CPtr > m.Top[n.NPtr.Class] {
+		m.Top[n.NPtr.Class] = n.NPtr.CPtr
+	}
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) IdempAddNode(ctx context.Context,sst *PoSST,n Node) Node {
+
+	// Same policy as IdempAppendNode(): names are unique, CPtr = max+1
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	n.L,n.NPtr.Class = StorageClass(n.S)
+
+	existing := m.Names[n.S]
+
+	if len(existing) > 0 {
+		n.NPtr = existing[0]
+		return n
+	}
+
+	n.NPtr.CPtr = m.Top[n.NPtr.Class] + 1
+	m.SetNode(n)
+	m.AddChanges(AddedNodeChanges(sst,n))
+
+	return n
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) AppendLink(ctx context.Context,sst *PoSST,nptr NodePtr,lnk Link,sttype int) bool {
+
+	if sttype < -EXPRESS || sttype > EXPRESS {
+		fmt.Println(ERR_ST_OUT_OF_BOUNDS,sttype)
+		return false
+	}
+
+	if nptr == lnk.Dst {
+		return false
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	n,exists := m.Nodes[nptr]
+
+	if !exists {
+		return false
+	}
+
+	stindex := STTypeToSTIndex(sttype)
+
+	for _,prev := range n.I[stindex] {
+		if prev == lnk {
+			return true
+		}
+	}
+
+	var links []Link
+	links = append(links,n.I[stindex]...)
+	n.I[stindex] = append(links,lnk)
+
+	m.Nodes[nptr] = n
+	return true
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) GetNode(ctx context.Context,sst *PoSST,nptr NodePtr) (Node,bool) {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	n,exists := m.Nodes[nptr]
+
+	if !exists {
+		var empty Node
+		empty.NPtr = nptr
+		return empty,false
+	}
+
+	return n,false
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) GetNodePtrsByName(ctx context.Context,sst *PoSST,name string) []NodePtr {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var retval []NodePtr
+
+	return append(retval,m.Names[name]...)
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) GetNodePtrsMatching(ctx context.Context,sst *PoSST,nm,chap string,cn []string,arrow []ArrowPtr,seq bool,limit int) []NodePtr {
+
+	ExplainGoCall(sst,"GetNodePtrsMatching",nm,chap,cn,arrow,seq,limit)
+
+	// Match outside the lock, as contexts are looked up in the store
+
+	nodes := m.GetAllNodes(ctx,sst)
+
+	var matches []Node
+
+	for _,n := range nodes {
+		if NodeMatchesNCCS(sst,n,nm,chap,cn,arrow,seq) {
+			matches = append(matches,n)
+		}
+	}
+
+	return OrderNodeMatches(matches,limit)
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) GetChaptersMatching(ctx context.Context,sst *PoSST,src string) []string {
+
+	m.lock.RLock()
+
+	var chaps []string
+
+	for _,n := range m.Nodes {
+		chaps = append(chaps,n.Chap)
+	}
+
+	m.lock.RUnlock()
+
+	return FilterChaptersMatching(chaps,src)
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) GetNodePtrsByChapter(ctx context.Context,sst *PoSST,chap string) []NodePtr {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var retval []NodePtr
+
+	for nptr,n := range m.Nodes {
+		if InChapterList(n.Chap,chap) {
+			retval = append(retval,nptr)
+		}
+	}
+
+	return retval
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) GetTopCPtr(ctx context.Context,sst *PoSST,channel int) ClassedNodePtr {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if channel < 0 || channel >= N_CHANNELS {
+		return 0
+	}
+
+	return m.Top[channel]
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) GetAllNodes(ctx context.Context,sst *PoSST) []Node {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var nodes []Node
+
+	for _,n := range m.Nodes {
+		nodes = append(nodes,n)
+	}
+
+	return nodes
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) UploadBatch(ctx context.Context,sst *PoSST,nodes []Node,links []BatchLink,chapters map[NodePtr]string,tops map[int]ClassedNodePtr,record BatchRecord) error {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for channel,top := range tops {
+		if channel < 0 || channel >= N_CHANNELS || m.Top[channel] != top {
+			return fmt.Errorf("%w: channel %d was at %d",ERR_BATCH_CONFLICT,channel,top)
+		}
+	}
+
+	for _,n := range nodes {
+		m.SetNode(n)
+	}
+
+	for nptr,chaps := range chapters {
+		if n,exists := m.Nodes[nptr]; exists {
+			n.Chap = chaps
+			m.Nodes[nptr] = n
+		}
+	}
+
+	for _,bl := range links {
+
+		n,exists := m.Nodes[bl.NPtr]
+
+		if !exists || bl.NPtr == bl.Link.Dst {
+			continue
+		}
+
+		stindex := STTypeToSTIndex(bl.STtype)
+
+		if !LinkInList(n.I[stindex],bl.Link) {
+			var links []Link
+			links = append(links,n.I[stindex]...)
+			n.I[stindex] = append(links,bl.Link)
+			m.Nodes[bl.NPtr] = n
+		}
+	}
+
+	m.PageMap = append(m.PageMap,record.PageMap...)
+	m.AddChanges(record.Changes)
+	m.SetProvenance(record.Sources)
+	return nil
+}
+
+// **************************************************************************
+// Editing
+// **************************************************************************
+
+func (m *MemoryStore) SetLinks(ctx context.Context,sst *PoSST,nptr NodePtr,links []Link,sttype int) bool {
+
+	err := m.EditNodes(ctx,sst,&NodeEdits{Links: []LinkEdit{{NPtr: nptr,STtype: sttype,Links: links}}})
+
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+
+	return true
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) EditNodes(ctx context.Context,sst *PoSST,edits *NodeEdits) error {
+
+	// Check everything before changing anything, under the one lock
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if plan := edits.Plan; plan != nil {
+
+		read := func(nptrs ...NodePtr) (map[NodePtr]Node,error) {
+
+			var nodes = make(map[NodePtr]Node)
+
+			for _,nptr := range nptrs {
+
+				n,exists := m.Nodes[nptr]
+
+				if !exists {
+					n = Node{NPtr: nptr}
+				}
+
+				nodes[nptr] = n
+			}
+
+			return nodes,nil
+		}
+
+		if err := plan(read,edits); err != nil {
+			return err
+		}
+	}
+
+	stored := func(nptr NodePtr) error {
+		if _,exists := m.Nodes[nptr]; !exists {
+			return fmt.Errorf("%w: (%d,%d)",ERR_EDIT_FAILED,nptr.Class,nptr.CPtr)
+		}
+		return nil
+	}
+
+	for _,n := range edits.Added {
+		if _,exists := m.Nodes[n.NPtr]; exists {
+			return fmt.Errorf("%w: (%d,%d) already exists",ERR_EDIT_FAILED,n.NPtr.Class,n.NPtr.CPtr)
+		}
+	}
+
+	var added = make(map[NodePtr]bool)
+
+	for _,n := range edits.Added {
+		added[n.NPtr] = true
+	}
+
+	for _,e := range edits.Links {
+
+		if e.STtype < -EXPRESS || e.STtype > EXPRESS {
+			return fmt.Errorf("%w: %d",ERR_ST_OUT_OF_BOUNDS,e.STtype)
+		}
+
+		if err := stored(e.NPtr); err != nil && !added[e.NPtr] {
+			return err
+		}
+	}
+
+	for _,bl := range edits.Appended {
+		if bl.STtype < -EXPRESS || bl.STtype > EXPRESS {
+			return fmt.Errorf("%w: %d",ERR_ST_OUT_OF_BOUNDS,bl.STtype)
+		}
+	}
+
+	for _,nptrs := range [][]NodePtr{edits.Touched(),edits.Deleted} {
+		for _,nptr := range nptrs {
+			if err := stored(nptr); err != nil && !added[nptr] {
+				return err
+			}
+		}
+	}
+
+	for _,n := range edits.Added {
+		m.SetNode(n)
+	}
+
+	for _,e := range edits.Links {
+
+		n := m.Nodes[e.NPtr]
+
+		var replace []Link
+		n.I[STTypeToSTIndex(e.STtype)] = append(replace,e.Links...)
+
+		m.Nodes[e.NPtr] = n
+	}
+
+	for _,bl := range edits.Appended {
+
+		n := m.Nodes[bl.NPtr]
+		stindex := STTypeToSTIndex(bl.STtype)
+
+		if bl.NPtr != bl.Link.Dst && !LinkInList(n.I[stindex],bl.Link) {
+			var links []Link
+			n.I[stindex] = append(append(links,n.I[stindex]...),bl.Link)
+			m.Nodes[bl.NPtr] = n
+		}
+	}
+
+	for nptr,name := range edits.Renamed {
+		n := m.Nodes[nptr]
+		n.S = name
+		n.L,_ = StorageClass(name)
+		m.SetNode(n)
+	}
+
+	for nptr,chap := range edits.Chapters {
+		n := m.Nodes[nptr]
+		n.Chap = chap
+		m.Nodes[nptr] = n
+	}
+
+	for nptr,seq := range edits.Seqs {
+		n := m.Nodes[nptr]
+		n.Seq = seq
+		m.Nodes[nptr] = n
+	}
+
+	for from,to := range edits.Repoint {
+		for i := range m.PageMap {
+			if RepointPath(m.PageMap[i].Path,from,to) {
+				edits.Repointed++
+			}
+		}
+	}
+
+	for _,alias := range edits.Aliases {
+		m.Aliases = DeleteAliasFrom(m.Aliases,alias.Alias)
+		m.Aliases = append(m.Aliases,alias)
+	}
+
+	for _,alias := range edits.Unaliased {
+		m.Aliases = DeleteAliasFrom(m.Aliases,alias)
+	}
+
+	for _,nptr := range edits.Deleted {
+
+		n := m.Nodes[nptr]
+
+		m.Names[n.S] = DeleteNodePtr(m.Names[n.S],nptr)
+		m.DeleteProvenanceTo(nptr)
+
+		if len(m.Names[n.S]) == 0 {
+			delete(m.Names,n.S)
+		}
+
+		for i := range m.PageMap {
+			m.PageMap[i].Path = DeleteFromPath(m.PageMap[i].Path,nptr)
+		}
+
+		delete(m.Nodes,nptr)
+	}
+
+	for chap,lines := range edits.PageMaps {
+		m.DeletePageMapOf(chap)
+		m.PageMap = append(m.PageMap,lines...)
+	}
+
+	for file,records := range edits.Sources {
+		m.DeleteProvenanceFrom(file)
+		m.SetProvenance(records)
+	}
+
+	edits.Revision = m.AddChanges(edits.Changes)
+
+	return nil
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) RenameNode(ctx context.Context,sst *PoSST,nptr NodePtr,name string) bool {
+
+	return m.EditNodes(ctx,sst,&NodeEdits{Renamed: map[NodePtr]string{nptr: name}}) == nil
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) DeleteNode(ctx context.Context,sst *PoSST,nptr NodePtr) bool {
+
+	return m.EditNodes(ctx,sst,&NodeEdits{Deleted: []NodePtr{nptr}}) == nil
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) SetNodeChapter(ctx context.Context,sst *PoSST,nptr NodePtr,chap string) bool {
+
+	return m.EditNodes(ctx,sst,&NodeEdits{Chapters: map[NodePtr]string{nptr: chap}}) == nil
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) SetNodeSeq(ctx context.Context,sst *PoSST,nptr NodePtr,seq bool) bool {
+
+	return m.EditNodes(ctx,sst,&NodeEdits{Seqs: map[NodePtr]bool{nptr: seq}}) == nil
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) RepointPageMap(ctx context.Context,sst *PoSST,from,to NodePtr) int {
+
+	edits := NodeEdits{Repoint: map[NodePtr]NodePtr{from: to}}
+	m.EditNodes(ctx,sst,&edits)
+
+	return edits.Repointed
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) UploadAlias(ctx context.Context,sst *PoSST,alias NodeAlias) {
+
+	m.EditNodes(ctx,sst,&NodeEdits{Aliases: []NodeAlias{alias}})
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) GetAliases(ctx context.Context,sst *PoSST,into NodePtr) []NodeAlias {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var aliases []NodeAlias
+
+	for _,alias := range m.Aliases {
+		if alias.Into == into {
+			aliases = append(aliases,alias)
+		}
+	}
+
+	return aliases
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) DeleteAlias(ctx context.Context,sst *PoSST,alias NodePtr) bool {
+
+	return m.EditNodes(ctx,sst,&NodeEdits{Unaliased: []NodePtr{alias}}) == nil
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) UploadProvenance(ctx context.Context,sst *PoSST,records []Provenance) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.SetProvenance(records)
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) SetProvenance(records []Provenance) {
+
+	// Caller holds the lock
+
+	for _,p := range records {
+
+		var kept []Provenance
+
+		for _,prev := range m.Provenance[p.NPtr] {
+			if prev.Arr != p.Arr || prev.Dst != p.Dst || prev.File != p.File {
+				kept = append(kept,prev)
+			}
+		}
+
+		m.Provenance[p.NPtr] = append(kept,p)
+	}
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) GetProvenance(ctx context.Context,sst *PoSST,nptr NodePtr) []Provenance {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var records []Provenance
+
+	for from,list := range m.Provenance {
+		for _,p := range list {
+			if from == nptr || (p.Dst == nptr && p.Arr >= 0) {
+				records = append(records,p)
+			}
+		}
+	}
+
+	sort.SliceStable(records, func(i,j int) bool {
+		if records[i].File != records[j].File {
+			return records[i].File < records[j].File
+		}
+		return records[i].Line < records[j].Line
+	})
+
+	return records
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) DeleteProvenance(ctx context.Context,sst *PoSST,file string) int {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.DeleteProvenanceFrom(file)
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) DeleteProvenanceFrom(file string) int {
+
+	// Caller holds the lock
+
+	var count int
+
+	for from,list := range m.Provenance {
+
+		var kept []Provenance
+
+		for _,p := range list {
+			if p.File != file {
+				kept = append(kept,p)
+			}
+		}
+
+		count += len(list) - len(kept)
+		m.Provenance[from] = kept
+	}
+
+	return count
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) DeleteProvenanceTo(nptr NodePtr) {
+
+	// Caller holds the lock
+
+	delete(m.Provenance,nptr)
+
+	for from,list := range m.Provenance {
+
+		var kept []Provenance
+
+		for _,p := range list {
+			if p.Dst != nptr {
+				kept = append(kept,p)
+			}
+		}
+
+		m.Provenance[from] = kept
+	}
+}
+
+// **************************************************************************
+// Change log
+// **************************************************************************
+
+func (m *MemoryStore) AppendChanges(ctx context.Context,sst *PoSST,changes []Change) int64 {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.AddChanges(changes)
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) AddChanges(changes []Change) int64 {
+
+	// Caller holds the lock
+
+	if len(changes) == 0 {
+		return 0
+	}
+
+	var rev int64 = 1
+
+	if len(m.Changes) > 0 {
+		rev = m.Changes[len(m.Changes)-1].Rev + 1
+	}
+
+	for _,c := range changes {
+		c.Rev = rev
+		m.Changes = append(m.Changes,c)
+	}
+
+	return rev
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) GetChanges(ctx context.Context,sst *PoSST,after int64) []Change {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var changes []Change
+
+	for _,c := range m.Changes {
+		if c.Rev > after {
+			changes = append(changes,c)
+		}
+	}
+
+	return changes
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) GetRevisionAt(ctx context.Context,sst *PoSST,t int64) int64 {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var rev int64
+
+	for _,c := range m.Changes {
+		if c.Time <= t {
+			rev = c.Rev
+		}
+	}
+
+	return rev
+}
+
+// **************************************************************************
+// Arrows and contexts
+// **************************************************************************
+
+func (m *MemoryStore) UploadArrows(ctx context.Context,sst *PoSST) {
+
+	// Replaces the previous directory, like dropping the tables
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.Arrows = nil
+	m.Inverses = make(map[ArrowPtr]ArrowPtr)
+
+	for arrow,ad := range GetArrowDirectory(sst) {
+		ad.Ptr = ArrowPtr(arrow)
+		m.Arrows = append(m.Arrows,ad)
+	}
+
+	for plus,minus := range GetInverseArrows(sst) {
+		m.Inverses[plus] = minus
+	}
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) DownloadArrows(ctx context.Context,sst *PoSST) ([]ArrowDirectory,map[ArrowPtr]ArrowPtr,error) {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var directory []ArrowDirectory
+	var inverses = make(map[ArrowPtr]ArrowPtr)
+
+	directory = append(directory,m.Arrows...)
+
+	for plus,minus := range m.Inverses {
+		inverses[plus] = minus
+	}
+
+	return directory,inverses,nil
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) IdempAddContext(ctx context.Context,sst *PoSST,context string,ptr ContextPtr) ContextPtr {
+
+	// Same policy as IdempInsertContext(), -1 means allocate a new pointer
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var cd ContextDirectory
+
+	cd.Context = context
+
+	if ptr == -1 {
+		cd.Ptr = 0
+
+		for _,c := range m.Contexts {
+			if c.Ptr >= cd.Ptr {
+				cd.Ptr = c.Ptr + 1
+			}
+		}
+
+		m.Contexts = append(m.Contexts,cd)
+		return cd.Ptr
+	}
+
+	for _,c := range m.Contexts {
+		if c.Ptr == ptr || c.Context == context {
+			return c.Ptr
+		}
+	}
+
+	cd.Ptr = ptr
+	m.Contexts = append(m.Contexts,cd)
+
+	sort.Slice(m.Contexts, func(i,j int) bool {
+		return m.Contexts[i].Ptr < m.Contexts[j].Ptr
+	})
+
+	return ptr
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) DownloadContexts(ctx context.Context,sst *PoSST) ([]ContextDirectory,error) {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var directory []ContextDirectory
+
+	return append(directory,m.Contexts...),nil
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) GetContextByName(ctx context.Context,sst *PoSST,src string) (string,ContextPtr) {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	remove_accents,stripped := IsBracketedSearchTerm(src)
+	stripped = SQLUnescape(stripped)
+
+	for _,c := range m.Contexts {
+
+		if remove_accents && Unaccent(c.Context) == stripped {
+			return c.Context,c.Ptr
+		}
+
+		if !remove_accents && c.Context == src {
+			return c.Context,c.Ptr
+		}
+	}
+
+	return "",0
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) GetContextByPtr(ctx context.Context,sst *PoSST,ptr ContextPtr) (string,ContextPtr) {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	for _,c := range m.Contexts {
+		if c.Ptr == ptr {
+			return c.Context,c.Ptr
+		}
+	}
+
+	return "",0
+}
+
+// **************************************************************************
+// Page map and bookmarks
+// **************************************************************************
+
+func (m *MemoryStore) UploadPageMap(ctx context.Context,sst *PoSST,lines []PageMap) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.PageMap = append(m.PageMap,lines...)
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) DeletePageMap(ctx context.Context,sst *PoSST,chap string) int {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.DeletePageMapOf(chap)
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) DeletePageMapOf(chap string) int {
+
+	// Caller holds the lock
+
+	var kept []PageMap
+
+	for _,event := range m.PageMap {
+		if event.Chapter != chap {
+			kept = append(kept,event)
+		}
+	}
+
+	count := len(m.PageMap) - len(kept)
+	m.PageMap = kept
+	return count
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) GetPageMap(ctx context.Context,sst *PoSST,chap string,cn []string,page,limit int) []PageMap {
+
+	ExplainGoCall(sst,"GetPageMap",chap,cn,page,limit)
+
+	m.lock.RLock()
+
+	var pagemap []PageMap
+
+	for _,event := range m.PageMap {
+		pagemap = append(pagemap,event)
+	}
+
+	m.lock.RUnlock()
+
+	return FilterPageMap(sst,pagemap,chap,cn,page,limit)
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) UploadBookmarks(ctx context.Context,sst *PoSST,marks map[string]string) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for b, q := range marks {
+		var bm Bookmark
+		bm.Bookmark = b
+		bm.Query = q
+		m.Bookmarks = append(m.Bookmarks,bm)
+	}
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) GetBookmarks(ctx context.Context,sst *PoSST) []Bookmark {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var marks []Bookmark
+
+	return append(marks,m.Bookmarks...)
+}
+
+// **************************************************************************
+// Last seen, same 1 minute dead time as LastSawSection() and LastSawNPtr()
+// **************************************************************************
+
+func (m *MemoryStore) LastSawSection(ctx context.Context,sst *PoSST,name string) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var section NodePtr
+
+	section.Class = -1
+	section.CPtr = -1
+
+	m.LastSeen = SawLastSeen(m.LastSeen,name,section)
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) LastSawNPtr(ctx context.Context,sst *PoSST,nptr NodePtr,name string) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.LastSeen = SawLastSeen(m.LastSeen,name,nptr)
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) GetLastSeen(ctx context.Context,sst *PoSST) []LastSeen {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var ret []LastSeen
+	now := time.Now().Unix()
+
+	for _,ls := range m.LastSeen {
+		ls.Ndelta = float64(now - ls.Last)
+		ret = append(ret,ls)
+	}
+
+	sort.SliceStable(ret, func(i,j int) bool {
+		return ret[i].Section < ret[j].Section
+	})
+
+	return ret
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) GetLastSeenNPtr(ctx context.Context,sst *PoSST,nptr NodePtr) LastSeen {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var ls LastSeen
+
+	for _,prev := range m.LastSeen {
+		if prev.NPtr == nptr {
+			ls = prev
+			ls.Ndelta = float64(time.Now().Unix() - ls.Last)
+		}
+	}
+
+	ls.NPtr = nptr
+	return ls
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) GetNewlySeen(ctx context.Context,sst *PoSST,horizon int) map[NodePtr]bool {
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var nptrs = make (map[NodePtr]bool)
+	now := time.Now().Unix()
+
+	for _,ls := range m.LastSeen {
+
+		switch horizon {
+		case RECENT:
+			if ls.Last > now - int64(horizon) * 3600 {
+				nptrs[ls.NPtr] = true
+			}
+		case NEVER:
+			nptrs[ls.NPtr] = true
+		}
+	}
+
+	return nptrs
+}
+
+// **************************************************************************
+// Cone and path searches, using the Go versions of the stored functions
+// on a copy of the session that carries ctx, as they check Cancelled().
+// The copy is taken under the directory lock, see CopySession()
+// **************************************************************************
+
+func (m *MemoryStore) FwdPathsAsLinks(ctx context.Context,sst *PoSST,start NodePtr,sttype,depth,maxlimit int) [][]Link {
+
+	ExplainGoCall(sst,"FwdPathsAsLinks",start,sttype,depth,maxlimit)
+
+	search := WithContext(CopySession(sst),ctx)
+
+	return FwdPathsAsLinks(&search,start,sttype,depth,maxlimit)
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) EntireConePathsAsLinks(ctx context.Context,sst *PoSST,orientation string,start NodePtr,depth,limit int) [][]Link {
+
+	ExplainGoCall(sst,"EntireConePathsAsLinks",orientation,start,depth,limit)
+
+	search := WithContext(CopySession(sst),ctx)
+
+	return AllPathsAsLinks(&search,start,orientation,depth,limit)
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) EntireNCConePathsAsLinks(ctx context.Context,sst *PoSST,orientation string,start []NodePtr,depth int,chapter string,context []string,limit int) [][]Link {
+
+	ExplainGoCall(sst,"EntireNCConePathsAsLinks",orientation,start,depth,chapter,context,limit)
+
+	chapter,rm_acc := ChapterLikePattern(chapter)
+
+	search := WithContext(CopySession(sst),ctx)
+
+	return AllNCPathsAsLinks(&search,start,chapter,rm_acc,context,orientation,depth,limit)
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) ConstraintConePathsAsLinks(ctx context.Context,sst *PoSST,start []NodePtr,depth int,chapter string,context []string,arrows []ArrowPtr,sttypes []int,limit int) [][]Link {
+
+	ExplainGoCall(sst,"ConstraintConePathsAsLinks",start,depth,chapter,context,arrows,sttypes,limit)
+
+	chapter,rm_acc := ChapterLikePattern(chapter)
+
+	search := WithContext(CopySession(sst),ctx)
+
+	return ConstraintPathsAsLinks(&search,start,chapter,rm_acc,context,arrows,sttypes,depth,limit)
+}
+
+// **************************************************************************
+
+func (m *MemoryStore) ConstrainedFwdLinks(ctx context.Context,sst *PoSST,start []NodePtr,chapter string,context []string,sttypes []int,arrows []ArrowPtr,maxlimit int) []Link {
+
+	ExplainGoCall(sst,"ConstrainedFwdLinks",start,chapter,context,sttypes,arrows,maxlimit)
+
+	search := WithContext(CopySession(sst),ctx)
+
+	return ConstrainedFwdLinks(&search,start,chapter,context,sttypes,arrows)
+}
+
+// **************************************************************************
+// Helpers shared by backends without PL/pgSQL
+// **************************************************************************
+
+func ConstrainedFwdLinks(sst *PoSST,start []NodePtr,chapter string,context []string,sttypes []int,arrows []ArrowPtr) []Link {
+
+	var ret []Link
+
+	if len(start) == 0 {
+		return ret
+	}
+
+	chapter,rm_acc := ChapterLikePattern(chapter)
+
+	exclude := append(CopyNodePtrSet(start),NONODE)
+
+	for _,st := range sttypes {
+		orbit := GetConstrainedFwdLinksBySTType(sst,start[0],chapter,rm_acc,context,exclude,st,arrows)
+		ret = append(ret,orbit...)
+	}
+
+	return ret
+}
+
+// **************************************************************************
+
+func ChapterLikePattern(chapter string) (string,bool) {
+
+	// As the SQL wrappers do: (chapter) means ignore accents, and match substrings
+
+	pattern,remove_accents := ChapterSearchPattern(chapter)
+
+	return SQLUnescape(pattern),remove_accents
+}
+
+// **************************************************************************
+
+func SQLUnescape(s string) string {
+
+	return strings.ReplaceAll(s,"''","'")
+}
+
+// **************************************************************************
+
+func NodeMatchesNCCS(sst *PoSST,n Node,name,chap string,context []string,arrow []ArrowPtr,seq bool) bool {
+
+	// Go version of the WHERE clause from NodeWhereString()
+
+	if n.L == 0 {
+		return false
+	}
+
+	// Chapter first to limit search by block
+
+	chap_pattern,chap_rm_acc := ChapterLikePattern(chap)
+	chap,chap_exclude := SplitChapterExclusions(chap)
+
+	if chap != "any" && chap != "" {
+
+		remove_chap_accents,chap_stripped := IsBracketedSearchTerm(chap)
+
+		if remove_chap_accents {
+			if !LikeMatch(UnCmp(n.Chap,true),"%"+strings.ToLower(SQLUnescape(chap_stripped))+"%") {
+				return false
+			}
+		} else if !LikeMatch(strings.ToLower(n.Chap),"%"+strings.ToLower(chap)+"%") {
+			return false
+		}
+	}
+
+	// Inhibited chapters
+
+	if len(chap_exclude) > 0 && !MatchChapter(n.Chap,chap_pattern,chap_rm_acc) {
+		return false
+	}
+
+	if !NodeNameMatches(n.S,name) {
+		return false
+	}
+
+	if seq && !n.Seq {
+		return false
+	}
+
+	// context and arrows, as in NCC_match()
+
+	_,cn_stripped := IsBracketedSearchList(context)
+
+	if len(arrow) == 0 {
+
+		for _,lnk := range n.I[STTypeToSTIndex(LEADSTO)] {
+			if lnk.Arr == 0 && MatchContext(sst,lnk.Ctx,cn_stripped) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	for _,st := range GetSTtypesFromArrows(sst,arrow) {
+		for _,lnk := range n.I[STTypeToSTIndex(st)] {
+			if MatchArrows(arrow,lnk.Arr) && MatchContext(sst,lnk.Ctx,cn_stripped) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// **************************************************************************
+
+func NodeNameMatches(text,name string) bool {
+
+	// The name part of NodeMatchesNCCS(): one term, or a conjunction
+	// like a&b&!c with every term matched the same way
+
+	if !IsQueryConjunction(name) {
+		return NodeNameTermMatches(text,name)
+	}
+
+	include,exclude := SplitQueryConjunction(name)
+
+	for _,term := range include {
+		if !NodeNameTermMatches(text,term) {
+			return false
+		}
+	}
+
+	for _,term := range exclude {
+		if NodeNameTermMatches(text,term) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// **************************************************************************
+
+func NodeNameTermMatches(text,name string) bool {
+
+	outer_exact_match,nopling := IsExactMatch(name)
+	remove_name_accents,nobrack := IsBracketedSearchTerm(nopling)
+	inner_exact_match,bare_name := IsExactMatch(SQLUnescape(nobrack))
+
+	is_exact_match := outer_exact_match || inner_exact_match
+
+	// First ignore technical references from ad hoc search results, like img paths
+
+	if !strings.HasPrefix(bare_name,"/") && strings.HasPrefix(text,"/") {
+		return false
+	}
+
+	if is_exact_match {
+
+		if strings.ToLower(text) != bare_name {
+			return false
+		}
+
+	} else if name != "any" && name != "%%" {
+
+		if IsStringFragment(bare_name) {
+			if !strings.Contains(strings.ToLower(text),strings.ToLower(bare_name)) {
+				return false
+			}
+		} else if !TSQueryMatch(text,bare_name,remove_name_accents) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// **************************************************************************
+
+func TSQueryMatch(text,query string,unaccent bool) bool {
+
+	// A rough stand-in for to_tsvector @@ to_tsquery, matching word prefixes
+	// with | for OR, & or <-> for AND and ! for NOT
+
+	text = UnCmp(text,unaccent)
+	query = UnCmp(query,unaccent)
+
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !IsWordRune(r)
+	})
+
+	for _,alternative := range strings.Split(query,"|") {
+
+		alternative = strings.NewReplacer("<->"," ","&"," ","(","",")","").Replace(alternative)
+		terms := strings.Fields(alternative)
+
+		if len(terms) == 0 {
+			continue
+		}
+
+		all := true
+
+		for _,term := range terms {
+
+			negate := strings.HasPrefix(term,"!")
+			term = strings.Trim(term,"!:*")
+
+			if HasWordPrefix(words,term) == negate {
+				all = false
+				break
+			}
+		}
+
+		if all {
+			return true
+		}
+	}
+
+	return false
+}
+
+// **************************************************************************
+
+func IsWordRune(r rune) bool {
+
+	return r == '\'' || r == '-' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r > 127
+}
+
+// **************************************************************************
+
+func HasWordPrefix(words []string,term string) bool {
+
+	for _,w := range words {
+
+		// Crude stemming: fish ~ fishes, run ~ running
+
+		if strings.HasPrefix(w,term) || (len(w) > 3 && strings.HasPrefix(term,w) && len(term)-len(w) < 3) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// **************************************************************************
+
+func OrderNodeMatches(matches []Node,limit int) []NodePtr {
+
+	// ORDER BY S ASC,(CARDINALITY(Ie3)+CARDINALITY(Im3)+CARDINALITY(Il1)) DESC LIMIT
+
+	cardinality := func(n Node) int {
+		return len(n.I[STTypeToSTIndex(EXPRESS)]) + len(n.I[STTypeToSTIndex(-EXPRESS)]) + len(n.I[STTypeToSTIndex(LEADSTO)])
+	}
+
+	sort.Slice(matches, func(i,j int) bool {
+		if matches[i].S != matches[j].S {
+			return matches[i].S < matches[j].S
+		}
+		return cardinality(matches[i]) > cardinality(matches[j])
+	})
+
+	var retval []NodePtr
+
+	for i := 0; i < len(matches) && i < limit; i++ {
+		retval = append(retval,matches[i].NPtr)
+	}
+
+	return retval
+}
+
+// **************************************************************************
+
+func FilterChaptersMatching(chaps []string,src string) []string {
+
+	// As GetDBChaptersMatchingName, but over a list of Chap values
+
+	var chapters = make(map[string]int)
+	var retval []string
+
+	remove_accents,stripped := IsBracketedSearchTerm(src)
+	pattern := "%"+strings.ToLower(SQLUnescape(stripped))+"%"
+
+	for _,whole := range chaps {
+
+		if !LikeMatch(UnCmp(whole,remove_accents),pattern) {
+			continue
+		}
+
+		several := strings.Split(whole,",")
+
+		for s := range several {
+			chapters[several[s]]++
+		}
+	}
+
+	for c := range chapters {
+		if strings.Contains(c,src) {
+			if len(c) > 0 {
+				retval = append(retval,c)
+			}
+		}
+	}
+
+	sort.Strings(retval)
+	return retval
+}
+
+// **************************************************************************
+
+func InChapterList(chaps,chap string) bool {
+
+	// Node.Chap accumulates a comma separated list of chapters
+
+	for _,c := range strings.Split(chaps,",") {
+		if c == chap {
+			return true
+		}
+	}
+
+	return false
+}
+
+// **************************************************************************
+
+func FilterPageMap(sst *PoSST,lines []PageMap,chap string,cn []string,page,limit int) []PageMap {
+
+	// As GetDBPageMap, but over a list of PageMap rows
+
+	var pagemap []PageMap
+	var distinct = make(map[string]bool)
+
+	chap = strings.Trim(chap,"\"")
+	chapter,rm_acc := ChapterLikePattern(chap)
+
+	for _,event := range lines {
+
+		if !MatchChapter(event.Chapter,chapter,rm_acc) {
+			continue
+		}
+
+		if !MatchContext(sst,event.Context,cn) {
+			continue
+		}
+
+		key := fmt.Sprintf("%s/%d/%d/%v",event.Chapter,event.Context,event.Line,event.Path)
+
+		if distinct[key] {
+			continue
+		}
+
+		distinct[key] = true
+		pagemap = append(pagemap,event)
+	}
+
+	sort.SliceStable(pagemap, func(i,j int) bool {
+		if pagemap[i].Chapter != pagemap[j].Chapter {
+			return pagemap[i].Chapter < pagemap[j].Chapter
+		}
+		return pagemap[i].Line < pagemap[j].Line
+	})
+
+	offset := (page-1) * limit
+
+	if offset < 0 {
+		offset = 0
+	}
+
+	if offset >= len(pagemap) {
+		return nil
+	}
+
+	if offset+limit < len(pagemap) {
+		return pagemap[offset:offset+limit]
+	}
+
+	return pagemap[offset:]
+}
+
+// **************************************************************************
+
+func SawLastSeen(table []LastSeen,name string,nptr NodePtr) []LastSeen {
+
+	// Update the access statistics, with a 1 minute dead time
+
+	now := time.Now().Unix()
+
+	for i := range table {
+
+		if table[i].NPtr != nptr {
+			continue
+		}
+
+		// Sections all share the (-1,-1) pointer, so go by name
+
+		if nptr.Class == -1 && table[i].Section != name {
+			continue
+		}
+
+		deltat := now - table[i].Last
+
+		if deltat > 60 {
+			table[i].Pdelta = 0.5 * float64(deltat) + 0.5 * table[i].Pdelta
+			table[i].Freq++
+			table[i].Last = now
+		}
+
+		return table
+	}
+
+	var ls LastSeen
+
+	ls.Section = name
+	ls.NPtr = nptr
+	ls.First = now
+	ls.Last = now
+	ls.Pdelta = 0
+	ls.Freq = 1
+
+	return append(table,ls)
+}
+
+// **************************************************************************
+
+func DeleteNodePtr(set []NodePtr,n NodePtr) []NodePtr {
+
+	var retval []NodePtr
+
+	for _,prev := range set {
+		if prev != n {
+			retval = append(retval,prev)
+		}
+	}
+
+	return retval
+}
+
+//
+// memory_store.go
+//
@@ -0,0 +1,634 @@
+//**************************************************************
+//
+// node_merging.go
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	_ "github.com/lib/pq"
+
+)
+
+//**************************************************************
+// Folding duplicate concepts together. MergeNodes(a,b) moves
+// everything of b onto a and deletes b, leaving a NodeAlias
+// record of what b was, so that SplitNode(a,name) can undo it.
+// CheckAltCaps() only warns about near duplicates, this acts.
+//**************************************************************
+
+type NodeAlias struct {
+
+	Alias    NodePtr         // the node that was merged away
+	S        string
+	Chap     string
+	Seq      bool
+	I        [ST_TOP][]Link  // its links before the merge, by STindex
+
+	Into     NodePtr         // the node that absorbed it
+	IntoChap string          // Into's chapters before the merge
+	Shared   []Link          // links in I that Into already had
+}
+
+//**************************************************************
+
+type MergeReport struct {
+
+	Node      NodePtr // the node that was kept
+	Alias     NodePtr // the node that was merged or split off
+	Name      string  // the alias's text
+	Moved     int     // links moved to Node, or back from it
+	Shared    int     // links both nodes had
+	Repointed int     // links in other nodes that now point elsewhere
+	PageMap   int     // page map lines that now point to Node
+	Chapters  string  // Node's chapters afterwards
+}
+
+// **************************************************************************
+
+func MergeNodes(sst *PoSST,keep,fold NodePtr) (MergeReport,error) {
+
+	// Fold node fold into node keep, re-pointing all references to it, as
+	// one edit with the alias record, so it happens entirely or not at all
+
+	var report MergeReport
+
+	report.Node = keep
+	report.Alias = fold
+
+	if keep == fold {
+		return report,fmt.Errorf("%w: (%d,%d)",ERR_MERGE_SELF,keep.Class,keep.CPtr)
+	}
+
+	var edits NodeEdits
+
+	edits.Plan = func(read LockedRead,edits *NodeEdits) error {
+
+		// The nodes are read again here, so that no edit in between is lost
+
+		before,err := LockedNeighbourhood(read,keep,fold)
+
+		if err != nil {
+			return err
+		}
+
+		a,b := before[keep],before[fold]
+
+		if a.S == "" {
+			return fmt.Errorf("%w: (%d,%d)",ERR_NO_SUCH_NODE,keep.Class,keep.CPtr)
+		}
+
+		if b.S == "" {
+			return fmt.Errorf("%w: (%d,%d)",ERR_NO_SUCH_NODE,fold.Class,fold.CPtr)
+		}
+
+		report.Name = b.S
+
+		var alias NodeAlias
+
+		alias.Alias = fold
+		alias.S = b.S
+		alias.Chap = b.Chap
+		alias.Seq = b.Seq
+		alias.I = b.I
+		alias.Into = keep
+		alias.IntoChap = a.Chap
+
+		for stindex := 0; stindex < ST_TOP; stindex++ {
+			for _,lnk := range b.I[stindex] {
+				if lnk.Dst != keep && LinkInList(a.I[stindex],lnk) {
+					alias.Shared = append(alias.Shared,lnk)
+				}
+			}
+		}
+
+		after := before.Copy()
+		kept := after[keep]
+
+		for stindex := 0; stindex < ST_TOP; stindex++ {
+
+			for _,lnk := range b.I[stindex] {
+
+				if lnk.Dst == keep || lnk.Dst == fold {
+					continue // would be a self-loop
+				}
+
+				if LinkInList(alias.Shared,lnk) {
+					report.Shared++
+					continue
+				}
+
+				kept.I[stindex] = AppendNewLink(kept.I[stindex],lnk)
+				report.Moved++
+			}
+		}
+
+		report.Chapters = MergeChapterLists(a.Chap,b.Chap)
+		kept.Chap = report.Chapters
+		after[keep] = kept
+
+		// The other halves of the links now belong to keep
+
+		for nbr := range LinkedNodes(b) {
+
+			if nbr == fold {
+				continue
+			}
+
+			n := after[nbr]
+			report.Repointed += RepointLinks(&n,fold,keep)
+			after[nbr] = n
+		}
+
+		after[fold] = Node{NPtr: fold}
+
+		*edits = LoggedEditsBetween(sst,before,after)
+		edits.Repoint = map[NodePtr]NodePtr{fold: keep}
+		edits.Aliases = []NodeAlias{alias}
+		return nil
+	}
+
+	if err := sst.STORE.EditNodes(DBContext(sst),sst,&edits); err != nil {
+		return report,err
+	}
+
+	report.PageMap = edits.Repointed
+
+	UncacheNodes(sst,edits.Touched()...)
+	return report,nil
+}
+
+// **************************************************************************
+
+func SplitNode(sst *PoSST,keep NodePtr,name string) (MergeReport,error) {
+
+	// Undo MergeNodes(), restoring the node that was merged into keep under
+	// this name, with its NodePtr and links. Page map lines stay with keep
+
+	var report MergeReport
+
+	report.Node = keep
+	report.Name = name
+
+	var alias NodeAlias
+	var found bool
+
+	for _,a := range sst.STORE.GetAliases(DBContext(sst),sst,keep) {
+		if a.S == name {
+			alias = a
+			found = true
+		}
+	}
+
+	if !found {
+		return report,fmt.Errorf("%w: \"%s\" in (%d,%d)",ERR_NO_SUCH_ALIAS,name,keep.Class,keep.CPtr)
+	}
+
+	report.Alias = alias.Alias
+
+	// New nodes may have taken the name in the meantime
+
+	if others := sst.STORE.GetNodePtrsByName(DBContext(sst),sst,name); len(others) > 0 {
+		return report,fmt.Errorf("%w: \"%s\" is now (%d,%d)",ERR_ALIAS_REUSED,name,others[0].Class,others[0].CPtr)
+	}
+
+	var edits NodeEdits
+
+	edits.Plan = func(read LockedRead,edits *NodeEdits) error {
+
+		// The alias's old neighbours may no longer be keep's
+
+		before,err := LockedNeighbourhood(read,keep,alias.Alias)
+
+		if err != nil {
+			return err
+		}
+
+		var dsts []NodePtr
+
+		for stindex := 0; stindex < ST_TOP; stindex++ {
+			for _,lnk := range alias.I[stindex] {
+				dsts = append(dsts,lnk.Dst)
+			}
+		}
+
+		if err = before.ReadLocked(read,dsts...); err != nil {
+			return err
+		}
+
+		a := before[keep]
+
+		if a.S == "" {
+			return fmt.Errorf("%w: (%d,%d)",ERR_NO_SUCH_NODE,keep.Class,keep.CPtr)
+		}
+
+		// A new node may have taken the pointer in the meantime
+
+		if other := before[alias.Alias]; other.S != "" {
+			return fmt.Errorf("%w: (%d,%d) is now \"%s\"",ERR_ALIAS_REUSED,alias.Alias.Class,alias.Alias.CPtr,other.S)
+		}
+
+		var b Node
+
+		b.NPtr = alias.Alias
+		b.S = alias.S
+		b.L,_ = StorageClass(alias.S)
+		b.Chap = alias.Chap
+		b.Seq = alias.Seq
+		b.I = alias.I
+
+		after := before.Copy()
+		after[b.NPtr] = b
+
+		for stindex := 0; stindex < ST_TOP; stindex++ {
+
+			for _,lnk := range alias.I[stindex] {
+
+				if lnk.Dst == b.NPtr {
+					continue // restored with the node
+				}
+
+				shared := LinkInList(alias.Shared,lnk)
+				moved := lnk.Dst != keep && !shared
+
+				// A moved link goes back, even if its other end has gone since
+
+				if moved {
+					k := after[keep]
+					k.I[stindex] = RemoveLink(k.I[stindex],lnk)
+					after[keep] = k
+					report.Moved++
+				}
+
+				if shared {
+					report.Shared++
+				}
+
+				dst := after[lnk.Dst]
+
+				if dst.S == "" {
+					continue
+				}
+
+				// Give the other end its half back
+
+				var inverse Link
+				inverse.Arr = GetInverseArrow(sst,lnk.Arr)
+				inverse.Wgt = lnk.Wgt
+				inverse.Ctx = lnk.Ctx
+				inverse.Dst = b.NPtr
+
+				inv := STTypeToSTIndex(-STIndexToSTType(stindex))
+
+				if moved {
+
+					var repointed Link
+					repointed = inverse
+					repointed.Dst = keep
+
+					dst.I[inv] = RemoveLink(dst.I[inv],repointed)
+				}
+
+				dst.I[inv] = AppendNewLink(dst.I[inv],inverse)
+				after[lnk.Dst] = dst
+				report.Repointed++
+			}
+		}
+
+		report.Chapters = UnmergeChapterLists(a.Chap,alias.Chap,alias.IntoChap)
+
+		k := after[keep]
+		k.Chap = report.Chapters
+		after[keep] = k
+
+		*edits = LoggedEditsBetween(sst,before,after)
+		edits.Unaliased = []NodePtr{alias.Alias}
+		return nil
+	}
+
+	if err := sst.STORE.EditNodes(DBContext(sst),sst,&edits); err != nil {
+		return report,err
+	}
+
+	UncacheNodes(sst,edits.Touched()...)
+	return report,nil
+}
+
+// **************************************************************************
+
+func GetDBNodeAliases(sst *PoSST,nptr NodePtr) []NodeAlias {
+
+	// The nodes that have been merged into nptr, and can be split off again
+
+	return sst.STORE.GetAliases(DBContext(sst),sst,nptr)
+}
+
+// **************************************************************************
+
+func FormatMergeReport(report MergeReport) string {
+
+	var s string
+
+	s += fmt.Sprintf(" - node (%d,%d), alias (%d,%d) \"%s\"\n",report.Node.Class,report.Node.CPtr,report.Alias.Class,report.Alias.CPtr,report.Name)
+	s += fmt.Sprintf(" - links moved: %d, shared: %d\n",report.Moved,report.Shared)
+	s += fmt.Sprintf(" - links re-pointed in other nodes: %d\n",report.Repointed)
+	s += fmt.Sprintf(" - page map lines re-pointed: %d\n",report.PageMap)
+	s += fmt.Sprintf(" - chapters: %s\n",report.Chapters)
+
+	return s
+}
+
+// **************************************************************************
+
+func LinkedNodes(n Node) map[NodePtr]bool {
+
+	// Every link is stored in both directions, so these are also the
+	// nodes that point back to n
+
+	var nodes = make(map[NodePtr]bool)
+
+	for stindex := 0; stindex < ST_TOP; stindex++ {
+		for _,lnk := range n.I[stindex] {
+			nodes[lnk.Dst] = true
+		}
+	}
+
+	return nodes
+}
+
+// **************************************************************************
+
+func RepointLinks(n *Node,from,to NodePtr) int {
+
+	// Rewrite n's links to from as links to to. On to itself, these
+	// would be self-loops, so they go
+
+	var count int
+
+	for stindex := 0; stindex < ST_TOP; stindex++ {
+
+		var links []Link
+
+		for _,lnk := range n.I[stindex] {
+
+			if lnk.Dst == from {
+				count++
+
+				if n.NPtr == to {
+					continue
+				}
+
+				lnk.Dst = to
+			}
+
+			links = AppendNewLink(links,lnk)
+		}
+
+		n.I[stindex] = links
+	}
+
+	return count
+}
+
+// **************************************************************************
+
+func AppendNewLink(links []Link,lnk Link) []Link {
+
+	// As AppendLink(), which leaves a column alone if the link is there
+
+	if LinkInList(links,lnk) {
+		return links
+	}
+
+	return append(links,lnk)
+}
+
+// **************************************************************************
+
+func RemoveLink(links []Link,lnk Link) []Link {
+
+	var kept []Link
+
+	for _,prev := range links {
+		if prev != lnk {
+			kept = append(kept,prev)
+		}
+	}
+
+	return kept
+}
+
+// **************************************************************************
+
+func RemoveLinksTo(n *Node,dst NodePtr) {
+
+	// In every column
+
+	for stindex := 0; stindex < ST_TOP; stindex++ {
+
+		var links []Link
+
+		for _,lnk := range n.I[stindex] {
+			if lnk.Dst != dst {
+				links = append(links,lnk)
+			}
+		}
+
+		n.I[stindex] = links
+	}
+}
+
+// **************************************************************************
+
+func MergeChapterLists(chaps,more string) string {
+
+	// Node.Chap is a comma separated list, keep the order and no repeats
+
+	merged := chaps
+
+	for _,c := range strings.Split(more,",") {
+
+		if c == "" || InChapterList(merged,c) {
+			continue
+		}
+
+		if merged == "" {
+			merged = c
+		} else {
+			merged += "," + c
+		}
+	}
+
+	return merged
+}
+
+// **************************************************************************
+
+func UnmergeChapterLists(chaps,alias,before string) string {
+
+	// Drop the chapters that came only with the alias
+
+	var kept []string
+
+	for _,c := range strings.Split(chaps,",") {
+		if InChapterList(before,c) || !InChapterList(alias,c) {
+			kept = append(kept,c)
+		}
+	}
+
+	return strings.Join(kept,",")
+}
+
+// **************************************************************************
+
+func RepointPath(path []Link,from,to NodePtr) bool {
+
+	// For backends that rewrite page map paths in Go, in place
+
+	var changed bool
+
+	for i := range path {
+		if path[i].Dst == from {
+			path[i].Dst = to
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// **************************************************************************
+
+func DeleteFromPath(path []Link,nptr NodePtr) []Link {
+
+	// For backends that rewrite page map paths in Go, as RepointPath()
+
+	var kept []Link
+
+	for _,lnk := range path {
+		if lnk.Dst != nptr {
+			kept = append(kept,lnk)
+		}
+	}
+
+	return kept
+}
+
+// **************************************************************************
+
+func DeleteAliasFrom(aliases []NodeAlias,nptr NodePtr) []NodeAlias {
+
+	var kept []NodeAlias
+
+	for _,alias := range aliases {
+		if alias.Alias != nptr {
+			kept = append(kept,alias)
+		}
+	}
+
+	return kept
+}
+
+// **************************************************************************
+// Postgres
+// **************************************************************************
+
+func (pg PostgresStore) UploadAlias(ctx context.Context,sst *PoSST,alias NodeAlias) {
+
+	err := pg.EditNodes(ctx,sst,&NodeEdits{Aliases: []NodeAlias{alias}})
+
+	if err != nil {
+		fmt.Println("Failed to record alias",err)
+	}
+}
+
+// **************************************************************************
+
+func FormatSQLAlias(alias NodeAlias) string {
+
+	// Replaces an older record for the same NodePtr
+
+	var cols [ST_TOP]string
+
+	for stindex := 0; stindex < ST_TOP; stindex++ {
+		cols[stindex] = FormatSQLLinkArray(alias.I[stindex])
+	}
+
+	qstr := fmt.Sprintf("DELETE FROM NodeAlias WHERE Alias='(%d,%d)'::NodePtr;\n",alias.Alias.Class,alias.Alias.CPtr)
+
+	qstr += fmt.Sprintf("INSERT INTO NodeAlias (Alias,S,Chap,Seq,IntoNPtr,IntoChap,%s,%s,%s,%s,%s,%s,%s,Shared) "+
+		"VALUES ('(%d,%d)'::NodePtr,'%s','%s',%t,'(%d,%d)'::NodePtr,'%s','%s','%s','%s','%s','%s','%s','%s','%s');\n",
+		I_MEXPR,I_MCONT,I_MLEAD,I_NEAR,I_PLEAD,I_PCONT,I_PEXPR,
+		alias.Alias.Class,alias.Alias.CPtr,SQLEscape(alias.S),SQLEscape(alias.Chap),alias.Seq,
+		alias.Into.Class,alias.Into.CPtr,SQLEscape(alias.IntoChap),
+		cols[0],cols[1],cols[2],cols[3],cols[4],cols[5],cols[6],FormatSQLLinkArray(alias.Shared))
+
+	return qstr
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) GetAliases(ctx context.Context,sst *PoSST,into NodePtr) []NodeAlias {
+
+	qstr := fmt.Sprintf("SELECT Alias,S,Chap,Seq,IntoChap,%s,%s,%s,%s,%s,%s,%s,Shared FROM NodeAlias WHERE IntoNPtr='(%d,%d)'::NodePtr",
+		I_MEXPR,I_MCONT,I_MLEAD,I_NEAR,I_PLEAD,I_PCONT,I_PEXPR,into.Class,into.CPtr)
+
+	row,err := sst.DB.QueryContext(ctx,qstr)
+
+	if err != nil {
+		fmt.Println("QUERY GetAliases Failed",err,qstr)
+		return nil
+	}
+
+	var aliases []NodeAlias
+
+	for row.Next() {
+
+		var alias NodeAlias
+		var nptr,shared string
+		var cols [ST_TOP]string
+
+		err = row.Scan(&nptr,&alias.S,&alias.Chap,&alias.Seq,&alias.IntoChap,
+			&cols[0],&cols[1],&cols[2],&cols[3],&cols[4],&cols[5],&cols[6],&shared)
+
+		if err != nil {
+			fmt.Println("Couldn't read alias",err)
+			continue
+		}
+
+		fmt.Sscanf(nptr,"(%d,%d)",&alias.Alias.Class,&alias.Alias.CPtr)
+
+		for stindex := 0; stindex < ST_TOP; stindex++ {
+			alias.I[stindex] = ParseLinkArray(cols[stindex])
+		}
+
+		alias.Into = into
+		alias.Shared = ParseLinkArray(shared)
+		aliases = append(aliases,alias)
+	}
+
+	row.Close()
+	return aliases
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) DeleteAlias(ctx context.Context,sst *PoSST,alias NodePtr) bool {
+
+	err := pg.EditNodes(ctx,sst,&NodeEdits{Unaliased: []NodePtr{alias}})
+
+	if err != nil {
+		fmt.Println("Failed to delete alias",err)
+		return false
+	}
+
+	return true
+}
+
+//
+// node_merging.go
+//
@@ -0,0 +1,616 @@
+//**************************************************************
+//
+// notes_import.go
+//
+// Turn Markdown and Org-mode notes into links: headings are
+// chapters or contain the headings below them, list items follow
+// one another, links point to what they name, and tags are context
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	_ "github.com/lib/pq"
+
+)
+
+//**************************************************************
+
+const (
+	NOTES_MARKDOWN = "markdown"
+	NOTES_ORG = "org"
+)
+
+//**************************************************************
+
+type NoteArrows struct {
+
+	Contains ArrowPtr  // heading -> subheading, paragraph or list item
+	Sequence ArrowPtr  // list item -> next item
+	Link     ArrowPtr  // text -> what a link points to
+}
+
+//**************************************************************
+
+type NoteChapter struct {
+
+	Chapter string
+	Links   []TableRowLink
+}
+
+//**************************************************************
+
+type NoteHeading struct {
+
+	Level int
+	Text  string
+	Tags  []string
+}
+
+//**************************************************************
+
+type NoteList struct {
+
+	Indent int
+	Parent string
+	Last   string
+}
+
+//**************************************************************
+
+type NoteParser struct {
+
+	Format   string
+	Arrows   NoteArrows
+	Chapters []NoteChapter
+
+	Title    string        // chapter for text before the first heading
+	Tags     []string      // for the whole file
+	Headings []NoteHeading // open headings, outermost first
+	Lists    []NoteList    // open lists, outermost first
+
+	Para     []string      // lines of the current paragraph or item
+	Item     bool          // the lines are a list item
+	Block    []string      // lines of a code or quote block
+	Fence    string        // what ends the block
+	Drawer   bool          // inside an Org :PROPERTIES: drawer
+}
+
+//**************************************************************
+
+var NOTE_MD_HEADING = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+var NOTE_ORG_HEADING = regexp.MustCompile(`^(\*+)\s+(.*)$`)
+var NOTE_ORG_TAGS = regexp.MustCompile(`\s+:([\p{L}\p{N}_@#%:]+):\s*$`)
+var NOTE_ORG_TODO = regexp.MustCompile(`^(TODO|DONE|NEXT|WAITING|CANCELLED)\s+(\[#[A-Z]\]\s+)?`)
+var NOTE_LIST_ITEM = regexp.MustCompile(`^(\s*)([-+*]|\d+[.)])\s+(\[[ xX-]\]\s+)?(.*)$`)
+var NOTE_HASHTAG = regexp.MustCompile(`(^|\s)#(\p{L}[\p{L}\p{N}_/-]*)`)
+var NOTE_SETEXT = regexp.MustCompile(`^(=+|-+)\s*$`)
+var NOTE_RULE = regexp.MustCompile(`^([-*_]\s*){3,}$`)
+
+var NOTE_ORG_LINK = regexp.MustCompile(`\[\[([^\]]+)\](\[([^\]]*)\])?\]`)
+var NOTE_WIKI_LINK = regexp.MustCompile(`\[\[([^\]|]+)(\|([^\]]*))?\]\]`)
+var NOTE_MD_LINK = regexp.MustCompile(`!?\[([^\]]*)\]\(\s*<?([^)\s>]+)>?(\s+"[^"]*")?\s*\)`)
+var NOTE_AUTO_LINK = regexp.MustCompile(`<((https?|ftp|mailto):[^>\s]+)>`)
+
+//**************************************************************
+
+func GetNoteArrows(sst *PoSST,contains,sequence,link string) (NoteArrows,bool) {
+
+	var arrows NoteArrows
+
+	var ok1,ok2,ok3 bool
+
+	arrows.Contains,ok1 = GetImportArrow(sst,contains,CONTAINS)
+	arrows.Sequence,ok2 = GetImportArrow(sst,sequence,LEADSTO)
+	arrows.Link,ok3 = GetImportArrow(sst,link,NEAR,LEADSTO)
+
+	return arrows,ok1 && ok2 && ok3
+}
+
+//**************************************************************
+
+func ParseNotes(text,format,title string,arrows NoteArrows) []NoteChapter {
+
+	// The title names the chapter of anything before the first
+	// heading, unless the notes give their own
+
+	var p NoteParser
+
+	p.Format = format
+	p.Arrows = arrows
+	p.Title = title
+
+	lines := strings.Split(strings.ReplaceAll(text,"\r\n","\n"),"\n")
+
+	if format == NOTES_MARKDOWN {
+		lines = p.FrontMatter(lines)
+	}
+
+	for _,line := range lines {
+		p.Line(line)
+	}
+
+	p.EndBlock()
+	p.EndPara()
+
+	return p.Chapters
+}
+
+//**************************************************************
+
+func (p *NoteParser) FrontMatter(lines []string) []string {
+
+	// YAML between --- lines at the top of a Markdown file
+
+	if len(lines) == 0 || strings.TrimSpace(lines[0]) != "---" {
+		return lines
+	}
+
+	for end := 1; end < len(lines); end++ {
+
+		if strings.TrimSpace(lines[end]) != "---" {
+			continue
+		}
+
+		doc,err := ParseYAMLDocument(strings.Join(lines[1:end],"\n"))
+
+		if err != nil || doc.Kind != DOC_OBJECT {
+			return lines
+		}
+
+		for k,key := range doc.Keys {
+			item := doc.Items[k]
+
+			switch strings.ToLower(key) {
+			case "title":
+				if item.Kind == DOC_SCALAR {
+					p.Title = item.Scalar
+				}
+			case "tags","keywords":
+				if item.Kind == DOC_SCALAR {
+					p.Tags = append(p.Tags,SplitNoteTags(item.Scalar,",")...)
+				}
+				for _,tag := range item.Items {
+					if tag.Kind == DOC_SCALAR {
+						p.Tags = append(p.Tags,tag.Scalar)
+					}
+				}
+			}
+		}
+
+		return lines[end+1:]
+	}
+
+	return lines
+}
+
+//**************************************************************
+
+func (p *NoteParser) Line(line string) {
+
+	trimmed := strings.TrimSpace(line)
+
+	// Code and quote blocks are kept whole
+
+	if p.Fence != "" {
+		if strings.EqualFold(trimmed,p.Fence) || p.Fence == "```" && strings.HasPrefix(trimmed,"```") {
+			p.EndBlock()
+		} else {
+			p.Block = append(p.Block,line)
+		}
+		return
+	}
+
+	if p.Format == NOTES_ORG {
+		if p.OrgLine(line,trimmed) {
+			return
+		}
+	} else if p.MarkdownLine(line,trimmed) {
+		return
+	}
+
+	if trimmed == "" {
+		if !p.Item {
+			p.EndPara()
+		}
+		return
+	}
+
+	if m := NOTE_LIST_ITEM.FindStringSubmatch(line); m != nil {
+		p.ListItem(len(m[1]),m[4])
+		return
+	}
+
+	// Text after a list that is not indented ends it
+
+	if len(p.Lists) > 0 && line[0] != ' ' && line[0] != '\t' {
+		p.EndPara()
+		p.Lists = nil
+	}
+
+	p.Para = append(p.Para,trimmed)
+}
+
+//**************************************************************
+
+func (p *NoteParser) MarkdownLine(line,trimmed string) bool {
+
+	switch {
+	case strings.HasPrefix(trimmed,"```") || strings.HasPrefix(trimmed,"~~~"):
+		p.EndPara()
+		p.Fence = trimmed[:3]
+		return true
+
+	case strings.HasPrefix(trimmed,"<!--") && strings.HasSuffix(trimmed,"-->"):
+		return true
+
+	case len(p.Para) == 1 && !p.Item && NOTE_SETEXT.MatchString(trimmed):
+		level := 1
+		if trimmed[0] == '-' {
+			level = 2
+		}
+		text := p.Para[0]
+		p.Para = nil
+		p.Heading(level,text)
+		return true
+
+	case NOTE_RULE.MatchString(trimmed):
+		p.EndPara()
+		return true
+
+	case strings.HasPrefix(trimmed,">"):
+		if quote := strings.TrimSpace(strings.TrimLeft(trimmed,">")); quote != "" {
+			p.Para = append(p.Para,quote)
+		} else {
+			p.EndPara()
+		}
+		return true
+	}
+
+	if m := NOTE_MD_HEADING.FindStringSubmatch(line); m != nil {
+		p.Heading(len(m[1]),m[2])
+		return true
+	}
+
+	return false
+}
+
+//**************************************************************
+
+func (p *NoteParser) OrgLine(line,trimmed string) bool {
+
+	upper := strings.ToUpper(trimmed)
+
+	switch {
+	case p.Drawer:
+		p.Drawer = upper != ":END:"
+		return true
+
+	case upper == ":PROPERTIES:" || upper == ":LOGBOOK:":
+		p.Drawer = true
+		return true
+
+	case strings.HasPrefix(upper,"#+TITLE:"):
+		p.Title = strings.TrimSpace(trimmed[len("#+TITLE:"):])
+		return true
+
+	case strings.HasPrefix(upper,"#+FILETAGS:"):
+		p.Tags = append(p.Tags,SplitNoteTags(trimmed[len("#+FILETAGS:"):],":")...)
+		return true
+
+	case strings.HasPrefix(upper,"#+BEGIN_"):
+		p.EndPara()
+		p.Fence = "#+END_" + strings.Fields(trimmed[len("#+BEGIN_"):] + " ")[0]
+		return true
+
+	case strings.HasPrefix(trimmed,"#+") || trimmed == "#" || strings.HasPrefix(trimmed,"# "):
+		return true // settings and comments
+
+	case strings.HasPrefix(trimmed,"-----") && NOTE_RULE.MatchString(trimmed):
+		p.EndPara()
+		return true
+
+	case strings.HasPrefix(upper,"SCHEDULED:") || strings.HasPrefix(upper,"DEADLINE:") || strings.HasPrefix(upper,"CLOSED:"):
+		return true
+	}
+
+	if m := NOTE_ORG_HEADING.FindStringSubmatch(line); m != nil {
+		p.Heading(len(m[1]),m[2])
+		return true
+	}
+
+	return false
+}
+
+//**************************************************************
+
+func (p *NoteParser) Heading(level int,text string) {
+
+	p.EndPara()
+	p.Lists = nil
+
+	var tags []string
+
+	if p.Format == NOTES_ORG {
+		if m := NOTE_ORG_TAGS.FindStringSubmatch(text); m != nil {
+			tags = SplitNoteTags(m[1],":")
+			text = strings.TrimSpace(text[:len(text)-len(m[0])])
+		}
+		text = NOTE_ORG_TODO.ReplaceAllString(text,"")
+	} else {
+		for _,m := range NOTE_HASHTAG.FindAllStringSubmatch(text,-1) {
+			tags = append(tags,m[2])
+		}
+		text = strings.TrimSpace(NOTE_HASHTAG.ReplaceAllString(text,"$1"))
+	}
+
+	text,targets := NoteLinks(text)
+
+	if text == "" {
+		return
+	}
+
+	for len(p.Headings) > 0 && p.Headings[len(p.Headings)-1].Level >= level {
+		p.Headings = p.Headings[:len(p.Headings)-1]
+	}
+
+	// A heading that is not inside another starts a chapter
+
+	if len(p.Headings) == 0 {
+		p.Chapters = append(p.Chapters,NoteChapter{Chapter: text})
+	} else {
+		p.AddLink(p.Parent(),p.Arrows.Contains,text,nil)
+	}
+
+	p.Headings = append(p.Headings,NoteHeading{Level: level, Text: text, Tags: tags})
+
+	for _,target := range targets {
+		p.AddLink(text,p.Arrows.Link,target,nil)
+	}
+}
+
+//**************************************************************
+
+func (p *NoteParser) ListItem(indent int,text string) {
+
+	p.EndPara()
+
+	for len(p.Lists) > 0 && p.Lists[len(p.Lists)-1].Indent > indent {
+		p.Lists = p.Lists[:len(p.Lists)-1]
+	}
+
+	if len(p.Lists) == 0 || p.Lists[len(p.Lists)-1].Indent < indent {
+
+		// A new list, inside the last item if there is one
+
+		var list NoteList
+
+		list.Indent = indent
+		list.Parent = p.Parent()
+
+		if len(p.Lists) > 0 {
+			list.Parent = p.Lists[len(p.Lists)-1].Last
+		}
+
+		p.Lists = append(p.Lists,list)
+	}
+
+	p.Para = []string{strings.TrimSpace(text)}
+	p.Item = true
+}
+
+//**************************************************************
+
+func (p *NoteParser) EndPara() {
+
+	// A paragraph or list item is complete
+
+	if len(p.Para) == 0 {
+		p.Item = false
+		return
+	}
+
+	text,targets := NoteLinks(strings.Join(p.Para," "))
+
+	var tags []string
+
+	if p.Format == NOTES_MARKDOWN {
+		for _,m := range NOTE_HASHTAG.FindAllStringSubmatch(text,-1) {
+			tags = append(tags,m[2])
+		}
+		text = strings.TrimSpace(NOTE_HASHTAG.ReplaceAllString(text,"$1"))
+	}
+
+	if text == "" && len(targets) > 0 {
+		text = targets[0]
+		targets = targets[1:]
+	}
+
+	p.Para = nil
+
+	if text == "" {
+		p.Item = false
+		return
+	}
+
+	if p.Item && len(p.Lists) > 0 {
+
+		list := &p.Lists[len(p.Lists)-1]
+
+		p.AddLink(list.Parent,p.Arrows.Contains,text,tags)
+
+		if list.Last != "" && list.Last != text {
+			p.AddLink(list.Last,p.Arrows.Sequence,text,tags)
+		}
+
+		list.Last = text
+	} else {
+		p.AddLink(p.Parent(),p.Arrows.Contains,text,tags)
+	}
+
+	for _,target := range targets {
+		p.AddLink(text,p.Arrows.Link,target,tags)
+	}
+
+	p.Item = false
+}
+
+//**************************************************************
+
+func (p *NoteParser) EndBlock() {
+
+	if p.Fence == "" {
+		return
+	}
+
+	text := strings.Trim(strings.Join(p.Block,"\n"),"\n")
+
+	p.Fence = ""
+	p.Block = nil
+
+	if strings.TrimSpace(text) != "" {
+		p.AddLink(p.Parent(),p.Arrows.Contains,text,nil)
+	}
+}
+
+//**************************************************************
+
+func (p *NoteParser) Parent() string {
+
+	if len(p.Headings) > 0 {
+		return p.Headings[len(p.Headings)-1].Text
+	}
+
+	// Text before the first heading belongs to the title
+
+	return p.Title
+}
+
+//**************************************************************
+
+func (p *NoteParser) AddLink(from string,arr ArrowPtr,to string,tags []string) {
+
+	if from == to || from == "" || to == "" {
+		return
+	}
+
+	if len(p.Chapters) == 0 {
+		p.Chapters = append(p.Chapters,NoteChapter{Chapter: p.Title})
+	}
+
+	// Tags apply to everything under the heading they are on
+
+	context := append([]string{},p.Tags...)
+
+	for _,h := range p.Headings {
+		context = append(context,h.Tags...)
+	}
+
+	context = append(context,tags...)
+
+	chapter := &p.Chapters[len(p.Chapters)-1]
+	chapter.Links = append(chapter.Links,TableRowLink{From: from, Arr: arr, To: to, Context: context})
+}
+
+//**************************************************************
+
+func NoteLinks(text string) (string,[]string) {
+
+	// Replace links by their descriptions, and return what they
+	// point to: Org [[target][description]], wiki [[target|alias]],
+	// Markdown [text](url) and <url>
+
+	var targets []string
+
+	text = NOTE_ORG_LINK.ReplaceAllStringFunc(text,func(s string) string {
+
+		m := NOTE_ORG_LINK.FindStringSubmatch(s)
+
+		if m[2] == "" && strings.Contains(m[1],"|") {
+			m = NOTE_WIKI_LINK.FindStringSubmatch(s)
+		}
+
+		targets = append(targets,NoteLinkTarget(m[1]))
+		return NoteLinkText(m[3],m[1])
+	})
+
+	text = NOTE_MD_LINK.ReplaceAllStringFunc(text,func(s string) string {
+		m := NOTE_MD_LINK.FindStringSubmatch(s)
+		targets = append(targets,m[2])
+		return NoteLinkText(m[1],m[2])
+	})
+
+	text = NOTE_AUTO_LINK.ReplaceAllStringFunc(text,func(s string) string {
+		m := NOTE_AUTO_LINK.FindStringSubmatch(s)
+		targets = append(targets,m[1])
+		return m[1]
+	})
+
+	return strings.TrimSpace(text),targets
+}
+
+//**************************************************************
+
+func NoteLinkTarget(target string) string {
+
+	// Links to headings name the heading node
+
+	target = strings.TrimSpace(target)
+
+	if strings.HasPrefix(target,"*") || strings.HasPrefix(target,"#") {
+		return strings.TrimSpace(target[1:])
+	}
+
+	return target
+}
+
+//**************************************************************
+
+func NoteLinkText(text,target string) string {
+
+	if text = strings.TrimSpace(text); text != "" {
+		return text
+	}
+
+	return NoteLinkTarget(target)
+}
+
+//**************************************************************
+
+func SplitNoteTags(s,sep string) []string {
+
+	var tags []string
+
+	for _,tag := range strings.Split(s,sep) {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags,tag)
+		}
+	}
+
+	return tags
+}
+
+//**************************************************************
+
+func NotesToN4L(sst *PoSST,chapters []NoteChapter) string {
+
+	var n4l string
+
+	for _,chapter := range chapters {
+
+		context := ""
+
+		n4l += fmt.Sprintf("\n- %s\n",chapter.Chapter)
+		n4l += TableLinksToN4L(sst,chapter.Links,&context)
+	}
+
+	return n4l
+}
+
+//
+// notes_import.go
+//
@@ -7,6 +7,7 @@
 package SSTorytime
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	_ "github.com/lib/pq"
@@ -45,6 +46,10 @@ func GetPathsAndSymmetries(sst *PoSST,start_set,end_set []NodePtr,chapter string
 
 	for turn := 0; ldepth < maxdepth && rdepth < maxdepth; turn++ {
 
+		if Cancelled(sst) {
+			return nil
+		}
+
 		fmt.Print("\r   ..Waves searching: ",ldepth,rdepth)
 
 		solutions,loop_corrections = WaveFrontsOverlap(sst,left_paths,right_paths,Lnum,Rnum,ldepth,rdepth)
@@ -85,6 +90,10 @@ func IncConstraintConeLinks(sst *PoSST,cone [][]Link,chapter string ,context []s
 
 	for p := 0; p < len(cone); p++ {
 
+		if Cancelled(sst) {
+			return nil
+		}
+
 		branch := cone[p]
 		var exclude = make(map[NodePtr]bool)
 
@@ -120,10 +129,16 @@ func IncConstraintConeLinks(sst *PoSST,cone [][]Link,chapter string ,context []s
 
 func GetConstrainedFwdLinks(sst *PoSST,start []NodePtr,chapter string,context []string,sttypes []int,arrows []ArrowPtr,maxlimit int) []Link {
 
+	return sst.STORE.ConstrainedFwdLinks(DBContext(sst),sst,start,chapter,context,sttypes,arrows,maxlimit)
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) ConstrainedFwdLinks(ctx context.Context,sst *PoSST,start []NodePtr,chapter string,context []string,sttypes []int,arrows []ArrowPtr,maxlimit int) []Link {
+
 	var ret []Link
 
-	remove_accents,stripped := IsBracketedSearchTerm(chapter)
-	chapter = "%"+stripped+"%"
+	chapter,remove_accents := ChapterSearchPattern(chapter)
 	rm_acc := "false"
 
 	if remove_accents {
@@ -141,7 +156,9 @@ func GetConstrainedFwdLinks(sst *PoSST,start []NodePtr,chapter string,context []
 
 		qstr := fmt.Sprintf("select GetConstrainedFwdLinks('%s','%s',%s,%s,%s,%d,%s,%d);",startnode,chapter,rm_acc,cnt,excl,st,arr,maxlimit)
 
-		row, err := sst.DB.Query(qstr)
+		ExplainQuery(sst,"ConstrainedFwdLinks",qstr)
+
+		row, err := sst.DB.QueryContext(ctx,qstr)
 		
 		if err != nil {
 			fmt.Println("QUERY to ConstraintPathsAsLinks Failed",err,qstr)
@@ -244,7 +261,7 @@ func AdjointArrows(sst *PoSST,arrowptrs []ArrowPtr) []ArrowPtr {
 	var result []ArrowPtr
 
 	for _,a := range arrowptrs {
-		idemp[sst.INVERSE_ARROWS[a]] = true
+		idemp[GetInverseArrow(sst,a)] = true
 	}
 
 	for a := range idemp {
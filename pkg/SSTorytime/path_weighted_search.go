@@ -0,0 +1,439 @@
+// **************************************************************************
+//
+// path_weighted_search.go
+//
+// Least cost paths between a start and an end set, adding up Link.Wgt,
+// with Yen's algorithm for the k next best alternatives
+//
+// **************************************************************************
+
+package SSTorytime
+
+import (
+	"container/heap"
+	_ "github.com/lib/pq"
+
+)
+
+// **************************************************************************
+
+type WeightedSearch struct {
+
+	// The constraints are the same as for GetPathsAndSymmetries()
+
+	Chapter    string
+	Context    []string
+	Arrows     []ArrowPtr
+	STtypes    []int
+	MaxDepth   int
+
+	// Neighbours are looked up once per search, as Yen's algorithm
+	// revisits the same nodes many times
+
+	neighbours map[NodePtr][]Link
+}
+
+// **************************************************************************
+
+type WeightedEdge struct {
+
+	From NodePtr
+	Arr  ArrowPtr
+	To   NodePtr
+}
+
+// **************************************************************************
+
+type WeightedHop struct {
+
+	NPtr  NodePtr
+	Cost  float32
+	Depth int
+	Order int
+	Prev  *WeightedHop
+	Link  Link
+}
+
+// With a depth limit, a node reached more cheaply by a longer path
+// may still be needed by a shorter one, so each depth is settled apart
+
+type WeightedState struct {
+
+	NPtr  NodePtr
+	Depth int
+}
+
+type WeightedFrontier []*WeightedHop
+
+func (f WeightedFrontier) Len() int { return len(f) }
+func (f WeightedFrontier) Swap(i,j int) { f[i],f[j] = f[j],f[i] }
+func (f *WeightedFrontier) Push(x any) { *f = append(*f,x.(*WeightedHop)) }
+
+func (f WeightedFrontier) Less(i,j int) bool {
+
+	// Ties go to the shorter, then the earlier path, so results are repeatable
+
+	if f[i].Cost != f[j].Cost {
+		return f[i].Cost < f[j].Cost
+	}
+
+	if f[i].Depth != f[j].Depth {
+		return f[i].Depth < f[j].Depth
+	}
+
+	return f[i].Order < f[j].Order
+}
+
+func (f *WeightedFrontier) Pop() any {
+
+	old := *f
+	last := old[len(old)-1]
+	*f = old[:len(old)-1]
+	return last
+}
+
+// **************************************************************************
+
+func GetWeightedPaths(sst *PoSST,start_set,end_set []NodePtr,chapter string,context []string,arrowptrs []ArrowPtr,sttypes []int,k,maxdepth int) ([][]Link,[]float32) {
+
+	// Return up to k paths from start_set to end_set in order of increasing
+	// total weight, i.e. the sum of Link.Wgt along each path. The first link
+	// of a path is a singleton pointing to the start node, as in the cone searches
+
+	if start_set == nil || end_set == nil || k < 1 {
+		return nil,nil
+	}
+
+	if sttypes == nil || len(sttypes) == 0 {
+		sttypes = []int{1,2,3,0,-1,-2,-3}
+	}
+
+	var ws WeightedSearch
+
+	ws.Chapter = chapter
+	ws.Context = context
+	ws.Arrows = arrowptrs
+	ws.STtypes = sttypes
+	ws.MaxDepth = maxdepth
+	ws.neighbours = make(map[NodePtr][]Link)
+
+	return YenKShortestPaths(sst,&ws,start_set,end_set,k)
+}
+
+// **************************************************************************
+
+func YenKShortestPaths(sst *PoSST,ws *WeightedSearch,start_set,end_set []NodePtr,k int) ([][]Link,[]float32) {
+
+	var paths [][]Link
+	var costs []float32
+
+	best,cost := LeastCostPath(sst,ws,start_set,end_set,nil,nil,ws.MaxDepth)
+
+	if best == nil {
+		return nil,nil
+	}
+
+	paths = append(paths,best)
+	costs = append(costs,cost)
+
+	var candidates [][]Link
+	var candidate_costs []float32
+
+	for len(paths) < k {
+
+		prev := paths[len(paths)-1]
+
+		// Deviate from each node of the previous path in turn, keeping the
+		// root before it and avoiding the edges that earlier paths took from there
+
+		for spur := 0; spur < len(prev)-1; spur++ {
+
+			if Cancelled(sst) {
+				return paths,costs
+			}
+
+			root := prev[:spur+1]
+			banned_edges := make(map[WeightedEdge]bool)
+			banned_nodes := make(map[NodePtr]bool)
+
+			for _,path := range paths {
+				if len(path) > spur+1 && SameRootPath(path,root) {
+					banned_edges[WeightedEdge{From: path[spur].Dst, Arr: path[spur+1].Arr, To: path[spur+1].Dst}] = true
+				}
+			}
+
+			for _,lnk := range root[:spur] {
+				banned_nodes[lnk.Dst] = true
+			}
+
+			// The tail may only use the depth that the root leaves
+
+			maxdepth := ws.MaxDepth
+
+			if maxdepth > 0 {
+				maxdepth -= spur
+
+				if maxdepth < 1 {
+					continue
+				}
+			}
+
+			tail,_ := LeastCostPath(sst,ws,[]NodePtr{prev[spur].Dst},end_set,banned_nodes,banned_edges,maxdepth)
+
+			// A deviation at the first node may also start from another start node
+
+			if spur == 0 {
+				var others []NodePtr
+
+				for _,nptr := range start_set {
+					if nptr != prev[0].Dst && !UsedAsStart(paths,nptr) {
+						others = append(others,nptr)
+					}
+				}
+
+				alt,_ := LeastCostPath(sst,ws,others,end_set,nil,nil,ws.MaxDepth)
+
+				if alt != nil {
+					candidates,candidate_costs = AddCandidatePath(candidates,candidate_costs,paths,alt)
+				}
+			}
+
+			if tail == nil {
+				continue
+			}
+
+			var total []Link
+			total = append(total,root...)
+			total = append(total,tail[1:]...)
+
+			candidates,candidate_costs = AddCandidatePath(candidates,candidate_costs,paths,total)
+		}
+
+		if len(candidates) == 0 {
+			break
+		}
+
+		// Promote the cheapest candidate
+
+		next := 0
+
+		for c := range candidates {
+			if candidate_costs[c] < candidate_costs[next] || candidate_costs[c] == candidate_costs[next] && len(candidates[c]) < len(candidates[next]) {
+				next = c
+			}
+		}
+
+		paths = append(paths,candidates[next])
+		costs = append(costs,candidate_costs[next])
+
+		candidates = append(candidates[:next],candidates[next+1:]...)
+		candidate_costs = append(candidate_costs[:next],candidate_costs[next+1:]...)
+	}
+
+	return paths,costs
+}
+
+// **************************************************************************
+
+func LeastCostPath(sst *PoSST,ws *WeightedSearch,start_set,end_set []NodePtr,banned_nodes map[NodePtr]bool,banned_edges map[WeightedEdge]bool,maxdepth int) ([]Link,float32) {
+
+	// Dijkstra's algorithm from all the start nodes at once, with at most
+	// maxdepth links if it is above zero. Weights below zero can't be
+	// ordered this way, so those links are not followed
+
+	var frontier WeightedFrontier
+	var settled = make(map[WeightedState]bool)
+	var order int
+
+	state := func(nptr NodePtr,depth int) WeightedState {
+		if maxdepth > 0 {
+			return WeightedState{NPtr: nptr, Depth: depth}
+		}
+		return WeightedState{NPtr: nptr}
+	}
+
+	targets := make(map[NodePtr]bool)
+
+	for _,nptr := range end_set {
+		targets[nptr] = true
+	}
+
+	for _,nptr := range start_set {
+		if !banned_nodes[nptr] {
+			heap.Push(&frontier,&WeightedHop{NPtr: nptr, Link: GetSingletonAsLink(nptr), Order: order})
+			order++
+		}
+	}
+
+	for frontier.Len() > 0 {
+
+		if Cancelled(sst) {
+			return nil,0
+		}
+
+		hop := heap.Pop(&frontier).(*WeightedHop)
+
+		if settled[state(hop.NPtr,hop.Depth)] {
+			continue
+		}
+
+		// A start node that is also a target can only be reached again by
+		// a loop, so it isn't settled until then, even without depths
+
+		if hop.Depth > 0 || !targets[hop.NPtr] {
+			settled[state(hop.NPtr,hop.Depth)] = true
+		}
+
+		if targets[hop.NPtr] && hop.Depth > 0 {
+			return WeightedHopPath(hop),hop.Cost
+		}
+
+		if maxdepth > 0 && hop.Depth >= maxdepth {
+			continue
+		}
+
+		for _,lnk := range WeightedNeighbours(sst,ws,hop.NPtr) {
+
+			if settled[state(lnk.Dst,hop.Depth+1)] || banned_nodes[lnk.Dst] || lnk.Wgt < 0 {
+				continue
+			}
+
+			// Nodes are settled per depth, so don't go round a loop, unless
+			// it closes at a target
+
+			if maxdepth > 0 && OnWeightedPath(hop,lnk.Dst) && !targets[lnk.Dst] {
+				continue
+			}
+
+			if banned_edges[WeightedEdge{From: hop.NPtr, Arr: lnk.Arr, To: lnk.Dst}] {
+				continue
+			}
+
+			next := WeightedHop{NPtr: lnk.Dst, Cost: hop.Cost + lnk.Wgt, Depth: hop.Depth + 1, Order: order, Prev: hop, Link: lnk}
+			heap.Push(&frontier,&next)
+			order++
+		}
+	}
+
+	return nil,0
+}
+
+// **************************************************************************
+
+func WeightedNeighbours(sst *PoSST,ws *WeightedSearch,nptr NodePtr) []Link {
+
+	links,cached := ws.neighbours[nptr]
+
+	if !cached {
+		links = GetConstrainedFwdLinks(sst,[]NodePtr{nptr},ws.Chapter,ws.Context,ws.STtypes,ws.Arrows,CAUSAL_CONE_MAXLIMIT)
+		ws.neighbours[nptr] = links
+	}
+
+	return links
+}
+
+// **************************************************************************
+
+func OnWeightedPath(hop *WeightedHop,nptr NodePtr) bool {
+
+	for ; hop != nil; hop = hop.Prev {
+		if hop.NPtr == nptr {
+			return true
+		}
+	}
+
+	return false
+}
+
+// **************************************************************************
+
+func WeightedHopPath(hop *WeightedHop) []Link {
+
+	var path []Link
+
+	for ; hop != nil; hop = hop.Prev {
+		path = append(path,hop.Link)
+	}
+
+	for i,j := 0,len(path)-1; i < j; i,j = i+1,j-1 {
+		path[i],path[j] = path[j],path[i]
+	}
+
+	return path
+}
+
+// **************************************************************************
+
+func AddCandidatePath(candidates [][]Link,costs []float32,paths [][]Link,path []Link) ([][]Link,[]float32) {
+
+	for _,known := range paths {
+		if SameLinkPath(known,path) {
+			return candidates,costs
+		}
+	}
+
+	for _,known := range candidates {
+		if SameLinkPath(known,path) {
+			return candidates,costs
+		}
+	}
+
+	return append(candidates,path),append(costs,LinkPathWeight(path))
+}
+
+// **************************************************************************
+
+func UsedAsStart(paths [][]Link,nptr NodePtr) bool {
+
+	for _,path := range paths {
+		if path[0].Dst == nptr {
+			return true
+		}
+	}
+
+	return false
+}
+
+// **************************************************************************
+
+func SameRootPath(path,root []Link) bool {
+
+	if len(path) < len(root) {
+		return false
+	}
+
+	for l := range root {
+		if path[l].Dst != root[l].Dst || l > 0 && path[l].Arr != root[l].Arr {
+			return false
+		}
+	}
+
+	return true
+}
+
+// **************************************************************************
+
+func SameLinkPath(a,b []Link) bool {
+
+	return len(a) == len(b) && SameRootPath(a,b)
+}
+
+// **************************************************************************
+
+func LinkPathWeight(path []Link) float32 {
+
+	// The first link only locates the start node
+
+	var total float32
+
+	for l := 1; l < len(path); l++ {
+		total += path[l].Wgt
+	}
+
+	return total
+}
+
+
+//
+// path_weighted_search.go
+//
@@ -7,8 +7,8 @@
 package SSTorytime
 
 import (
+	"context"
 	"fmt"
-	"os"
 	"strings"
 	"strconv"
 	"sort"
@@ -29,6 +29,14 @@ func SolveNodePtrs(sst PoSST,nodenames []string,search SearchParameters,arr []Ar
 
 	nodeptrs,rest := ParseLiteralNodePtrs(nodenames)
 
+	// Names like !draft inhibit matches rather than adding to them
+
+	rest,exclude := SplitNegatedSearchList(rest)
+
+	if len(rest) == 0 && len(exclude) > 0 {
+		rest = []string{"any"}
+	}
+
 	var idempotence = make(map[NodePtr]bool)
 	var result []NodePtr
 
@@ -52,7 +60,9 @@ func SolveNodePtrs(sst PoSST,nodenames []string,search SearchParameters,arr []Ar
 	// Currently disordered, sort by additional scoring by running context ..
 
 	for uniqnptr := range idempotence {
-		result = append(result,uniqnptr)
+		if len(exclude) == 0 || !NodeNameExcluded(&sst,uniqnptr,exclude) {
+			result = append(result,uniqnptr)
+		}
 	}
 
 	sort.Slice(result, ScoreContext)
@@ -62,26 +72,34 @@ func SolveNodePtrs(sst PoSST,nodenames []string,search SearchParameters,arr []Ar
 
 //******************************************************************
 
-func GetBookmarksFromDB(sst PoSST) []Bookmark {
-
-	// Order by L to favour exact matches
+func NodeNameExcluded(sst *PoSST,nptr NodePtr,exclude []string) bool {
 
-	qstr := fmt.Sprintf("SELECT Bookmark,Query FROM Bookmarks;")
+	// Excluded names are matched as the names searched for, so that
+	// !term and NOT term leave out the same nodes
 
-	row, err := sst.DB.Query(qstr)
+	node := GetDBNodeByNodePtr(sst,nptr)
 
-	if err != nil {
-		fmt.Println("QUERY BegBookmarksFromDB Failed",err,qstr)
+	for _,ex := range exclude {
+		if NodeNameTermMatches(node.S,ex) {
+			return true
+		}
 	}
 
-	var b Bookmark
+	return false
+}
+
+//******************************************************************
+
+func GetBookmarksFromDB(sst PoSST) []Bookmark {
+
+	marks := sst.STORE.GetBookmarks(DBContext(&sst),&sst)
+
 	var chaps []string
 	var sorts = make(map[string][]Bookmark)
 	var retval []Bookmark
 
-	if row != nil {
-		for row.Next() {		
-			err = row.Scan(&b.Bookmark,&b.Query)
+	if marks != nil {
+		for _,b := range marks {
 
 			line := strings.Split(b.Bookmark,",")
 
@@ -98,8 +116,6 @@ func GetBookmarksFromDB(sst PoSST) []Bookmark {
 			}
 		}
 		
-		row.Close()
-
 		for key := range sorts {
 			chaps = append(chaps,key)
 		}
@@ -137,14 +153,49 @@ func GetBookmarksFromDB(sst PoSST) []Bookmark {
 
 //******************************************************************
 
+func (pg PostgresStore) GetBookmarks(ctx context.Context,sst *PoSST) []Bookmark {
+
+	qstr := fmt.Sprintf("SELECT Bookmark,Query FROM Bookmarks;")
+
+	row, err := sst.DB.QueryContext(ctx,qstr)
+
+	if err != nil {
+		fmt.Println("QUERY BegBookmarksFromDB Failed",err,qstr)
+	}
+
+	var b Bookmark
+	var marks []Bookmark
+
+	if row != nil {
+		for row.Next() {		
+			err = row.Scan(&b.Bookmark,&b.Query)
+			marks = append(marks,b)
+		}
+		
+		row.Close()
+	}
+
+	return marks
+}
+
+//******************************************************************
+
 func GetDBNodePtrByName(sst PoSST,name string) []NodePtr {
 
 	// simplified, retain for compatibility
+
+	return sst.STORE.GetNodePtrsByName(DBContext(&sst),&sst,name)
+}
+
+//******************************************************************
+
+func (pg PostgresStore) GetNodePtrsByName(ctx context.Context,sst *PoSST,name string) []NodePtr {
+
 	nm := SQLEscape(name)
 
 	qstr := fmt.Sprintf("SELECT NPtr FROM Node WHERE S = '%s'",nm)
 
-	row, err := sst.DB.Query(qstr)
+	row, err := sst.DB.QueryContext(ctx,qstr)
 
 	if err != nil {
 		fmt.Println("QUERY GetNodePtrMatchingName Failed",err,qstr)
@@ -180,14 +231,25 @@ func GetDBNodePtrMatchingName(sst PoSST,name,chap string) []NodePtr {
 
 func GetDBNodePtrMatchingNCCS(sst PoSST,nm,chap string,cn []string,arrow []ArrowPtr,seq bool,limit int) []NodePtr {
 
+	// A conjunction like a&b&!c from a boolean query is matched by the store
+
+	return sst.STORE.GetNodePtrsMatching(DBContext(&sst),&sst,nm,chap,cn,arrow,seq,limit)
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) GetNodePtrsMatching(ctx context.Context,sst *PoSST,nm,chap string,cn []string,arrow []ArrowPtr,seq bool,limit int) []NodePtr {
+
 	// Order by L to favour exact matches
 
 	nm = SQLEscape(nm)
 	chap = SQLEscape(chap)
 
-	qstr := fmt.Sprintf("SELECT NPtr FROM Node WHERE %s ORDER BY S ASC,(CARDINALITY(Ie3)+CARDINALITY(Im3)+CARDINALITY(Il1)) DESC LIMIT %d",NodeWhereString(sst,nm,chap,cn,arrow,seq),limit)
+	qstr := fmt.Sprintf("SELECT NPtr FROM Node WHERE %s ORDER BY S ASC,(CARDINALITY(Ie3)+CARDINALITY(Im3)+CARDINALITY(Il1)) DESC LIMIT %d",NodeWhereString(CopySession(sst),nm,chap,cn,arrow,seq),limit)
 
-	row, err := sst.DB.Query(qstr)
+	ExplainQuery(sst,"GetNodePtrsMatching",qstr)
+
+	row, err := sst.DB.QueryContext(ctx,qstr)
 
 	if err != nil {
 		fmt.Println("QUERY GetNodePtrMatchingNCC Failed",err,qstr)
@@ -222,6 +284,9 @@ func NodeWhereString(sst PoSST,name,chap string,context []string,arrow []ArrowPt
 
 	// Chapter first to limit search by block
 
+	chap_pattern,chap_rm_acc := ChapterSearchPattern(chap)
+	chap,chap_exclude := SplitChapterExclusions(chap)
+
 	if chap != "any" && chap != "" {
 
 		remove_chap_accents,chap_stripped := IsBracketedSearchTerm(chap)
@@ -237,7 +302,69 @@ func NodeWhereString(sst PoSST,name,chap string,context []string,arrow []ArrowPt
 		chap_col = "true"
 	}
 
-	// Name search using tsquery for wildcards and additional S = exact_constraint for !exact!
+	// Inhibited chapters
+
+	if len(chap_exclude) > 0 {
+		chap_col += fmt.Sprintf(" AND match_chapter(Chap,'%s',%t)",chap_pattern,chap_rm_acc)
+	}
+
+	nm_col = NodeNameWhereString(name)
+
+        var seq_col string
+        
+        if seq {
+                seq_col = "AND Seq=true"
+        }
+
+	// context and arrows
+
+	_,cn_stripped := IsBracketedSearchList(context)
+	ctx_col = FormatSQLStringArray(cn_stripped)
+
+	arrows := FormatSQLIntArray(Arrow2Int(arrow))
+	sttypes := FormatSQLIntArray(GetSTtypesFromArrows(&sst,arrow))
+
+	dbcols := I_MEXPR+","+I_MCONT+","+I_MLEAD+","+I_NEAR +","+I_PLEAD+","+I_PCONT+","+I_PEXPR
+
+	qstr = fmt.Sprintf("%s %s %s AND NCC_match(NPtr,%s,%s,%s,%s)",
+		chap_col,nm_col,seq_col, ctx_col,arrows,sttypes,dbcols)
+
+	return qstr
+}
+
+// **************************************************************************
+
+func NodeNameWhereString(name string) string {
+
+	// The name part of NodeWhereString(): one term, or a conjunction
+	// like a&b&!c with every term matched the same way
+
+	if !IsQueryConjunction(name) {
+		return NodeNameTermWhereString(name)
+	}
+
+	include,exclude := SplitQueryConjunction(name)
+
+	var nm_col string
+
+	for _,term := range include {
+		nm_col += " "+NodeNameTermWhereString(term)
+	}
+
+	for _,term := range exclude {
+		nm_col += fmt.Sprintf(" AND NOT (true %s)",NodeNameTermWhereString(term))
+	}
+
+	return nm_col
+}
+
+// **************************************************************************
+
+func NodeNameTermWhereString(name string) string {
+
+	var nm_col string
+
+	// Search using tsquery for wildcards and additional S = exact_constraint for !exact!
 
 	outer_exact_match,nopling := IsExactMatch(name)
 	remove_name_accents,nobrack := IsBracketedSearchTerm(nopling)
@@ -275,31 +402,19 @@ func NodeWhereString(sst PoSST,name,chap string,context []string,arrow []ArrowPt
 		}
 	}
 
-        var seq_col string
-        
-        if seq {
-                seq_col = "AND Seq=true"
-        }
-
-	// context and arrows
-
-	_,cn_stripped := IsBracketedSearchList(context)
-	ctx_col = FormatSQLStringArray(cn_stripped)
-
-	arrows := FormatSQLIntArray(Arrow2Int(arrow))
-	sttypes := FormatSQLIntArray(GetSTtypesFromArrows(sst,arrow))
+	return nm_col
+}
 
-	dbcols := I_MEXPR+","+I_MCONT+","+I_MLEAD+","+I_NEAR +","+I_PLEAD+","+I_PCONT+","+I_PEXPR
+// **************************************************************************
 
-	qstr = fmt.Sprintf("%s %s %s AND NCC_match(NPtr,%s,%s,%s,%s)",
-		chap_col,nm_col,seq_col, ctx_col,arrows,sttypes,dbcols)
+func GetDBChaptersMatchingName(sst PoSST,src string) []string {
 
-	return qstr
+	return sst.STORE.GetChaptersMatching(DBContext(&sst),&sst,src)
 }
 
 // **************************************************************************
 
-func GetDBChaptersMatchingName(sst PoSST,src string) []string {
+func (pg PostgresStore) GetChaptersMatching(ctx context.Context,sst *PoSST,src string) []string {
 
 	var qstr string
 
@@ -313,7 +428,9 @@ func GetDBChaptersMatchingName(sst PoSST,src string) []string {
 		qstr = fmt.Sprintf("SELECT DISTINCT Chap FROM Node WHERE lower(Chap) LIKE lower('%s')",search)
 	}
 
-	row, err := sst.DB.Query(qstr)
+	ExplainQuery(sst,"GetChaptersMatching",qstr)
+
+	row, err := sst.DB.QueryContext(ctx,qstr)
 	
 	if err != nil {
 		fmt.Println("QUERY GetDBChaptersMatchingName",err)
@@ -350,8 +467,58 @@ func GetDBChaptersMatchingName(sst PoSST,src string) []string {
 
 // **************************************************************************
 
+func GetDBNodePtrsByChapter(sst *PoSST,chap string) []NodePtr {
+
+	// Exact membership, since Chap can be a merged list of chapters
+
+	return sst.STORE.GetNodePtrsByChapter(DBContext(sst),sst,chap)
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) GetNodePtrsByChapter(ctx context.Context,sst *PoSST,chap string) []NodePtr {
+
+	search := "%"+SQLEscape(chap)+"%"
+
+	qstr := fmt.Sprintf("SELECT NPtr,Chap FROM Node WHERE Chap LIKE '%s'",search)
+
+	row, err := sst.DB.QueryContext(ctx,qstr)
+
+	if err != nil {
+		fmt.Println("QUERY GetDBNodePtrsByChapter Failed",err,qstr)
+	}
+
+	var whole,chaps string
+	var retval []NodePtr
+
+	if row != nil {
+		for row.Next() {
+			var n NodePtr
+			err = row.Scan(&whole,&chaps)
+			fmt.Sscanf(whole,"(%d,%d)",&n.Class,&n.CPtr)
+
+			if InChapterList(chaps,chap) {
+				retval = append(retval,n)
+			}
+		}
+
+		row.Close()
+	}
+
+	return retval
+}
+
+// **************************************************************************
+
 func GetDBContextByName(sst *PoSST,src string) (string,ContextPtr) {
 
+	return sst.STORE.GetContextByName(DBContext(sst),sst,src)
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) GetContextByName(ctx context.Context,sst *PoSST,src string) (string,ContextPtr) {
+
 	var qstr string
 
 	remove_accents,stripped := IsBracketedSearchTerm(src)
@@ -364,7 +531,7 @@ func GetDBContextByName(sst *PoSST,src string) (string,ContextPtr) {
 		qstr = fmt.Sprintf("SELECT DISTINCT Context,CtxPtr FROM ContextDirectory WHERE Context='%s'",search)
 	}
 
-	row, err := sst.DB.Query(qstr)
+	row, err := sst.DB.QueryContext(ctx,qstr)
 
 	if err != nil {
 		fmt.Println("QUERY GetDBContextByName",err)
@@ -390,9 +557,16 @@ func GetDBContextByName(sst *PoSST,src string) (string,ContextPtr) {
 
 func GetDBContextByPtr(sst *PoSST,ptr ContextPtr) (string,ContextPtr) {
 
+	return sst.STORE.GetContextByPtr(DBContext(sst),sst,ptr)
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) GetContextByPtr(ctx context.Context,sst *PoSST,ptr ContextPtr) (string,ContextPtr) {
+
 	qstr := fmt.Sprintf("SELECT DISTINCT Context,CtxPtr FROM ContextDirectory WHERE CtxPtr=%d",ptr)
 
-	row, err := sst.DB.Query(qstr)
+	row, err := sst.DB.QueryContext(ctx,qstr)
 	
 	if err != nil {
 		fmt.Println("QUERY GetDBContextssByPtr",err)
@@ -416,12 +590,12 @@ func GetDBContextByPtr(sst *PoSST,ptr ContextPtr) (string,ContextPtr) {
 
 // **************************************************************************
 
-func GetSTtypesFromArrows(sst PoSST,arrows []ArrowPtr) []int {
+func GetSTtypesFromArrows(sst *PoSST,arrows []ArrowPtr) []int {
 
 	var sttypes []int
 
 	for a := range arrows {
-		sta := sst.ARROW_DIRECTORY[arrows[a]].STAindex
+		sta := GetDBArrowByPtr(sst,arrows[a]).STAindex
 		st := STIndexToSTType(sta)
 		sttypes = append(sttypes,st)
 	}
@@ -433,17 +607,52 @@ func GetSTtypesFromArrows(sst PoSST,arrows []ArrowPtr) []int {
 
 func GetDBNodeByNodePtr(sst *PoSST,db_nptr NodePtr) Node {
 
-	im_nptr,cached := sst.NODE_CACHE[db_nptr]
+	return GetDBNodeByNodePtrWith(DBContext(sst),sst,db_nptr)
+}
+
+// **************************************************************************
+
+func GetDBNodeByNodePtrWith(ctx context.Context,sst *PoSST,db_nptr NodePtr) Node {
+
+	im_nptr,cached := CachedNodePtr(sst,db_nptr)
 
 	if cached {
+		lock := DirLock(sst)
+		lock.RLock()
+		defer lock.RUnlock()
 		return GetMemoryNodeFromPtr(sst,im_nptr)
 	}
 
+	n,ambiguous := sst.STORE.GetNode(ctx,sst,db_nptr)
+
+	// A node that can't be cached, e.g. as the request was cancelled,
+	// is still returned
+
+	if ambiguous {
+		CacheNodeErr(ctx,sst,n)
+	}
+
+	// Expand any dynamic inbuilt functions
+
+	if strings.HasPrefix(n.S,"Dynamic: ") {
+		n.S = ExpandDynamicFunctions(n.S)
+	}
+
+	n.NPtr = db_nptr
+	return n
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) GetNode(ctx context.Context,sst *PoSST,db_nptr NodePtr) (Node,bool) {
+
+	// Returns true if the pointer was ambiguous and the first match was chosen
+
 	// This ony works if we insert non-null arrays like '[]' during initialization
 	cols := I_MEXPR+","+I_MCONT+","+I_MLEAD+","+I_NEAR +","+I_PLEAD+","+I_PCONT+","+I_PEXPR
 	qstr := fmt.Sprintf("select L,S,Chap,%s from Node where NPtr='(%d,%d)'::NodePtr AND NOT L=0",cols,db_nptr.Class,db_nptr.CPtr)
 
-	row, err := sst.DB.Query(qstr)
+	row, err := sst.DB.QueryContext(ctx,qstr)
 
 	var n Node
 	var matches []Node
@@ -451,7 +660,7 @@ func GetDBNodeByNodePtr(sst *PoSST,db_nptr NodePtr) Node {
 
 	if err != nil {
 		fmt.Println("GetDBNodeByNodePointer Failed:",err)
-		return n
+		return n,false
 	}
 
 	var whole [ST_TOP]string
@@ -471,6 +680,8 @@ func GetDBNodeByNodePtr(sst *PoSST,db_nptr NodePtr) Node {
 			count++
 		}
 
+		row.Close()
+
 		if count > 1 {
 			fmt.Println("\nWARNING !\nGetDBNodeByNodePtr returned too many matches (this shouldn't happen):",count,"for ptr",db_nptr)
 
@@ -478,25 +689,59 @@ func GetDBNodeByNodePtr(sst *PoSST,db_nptr NodePtr) Node {
 				fmt.Println(" - Value: ",val)
 			}
 			
-			if !cached {
-				CacheNode(sst,matches[0])
-			}
-
 			n = matches[0]
 			fmt.Println("Selected first match: ",matches[0],"\n")
+			n.NPtr = db_nptr
+			return n,true
+		}
+	}
+	
+	n.NPtr = db_nptr
+	return n,false
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) GetAllNodes(ctx context.Context,sst *PoSST) []Node {
+
+	// The whole graph in one query, e.g. to copy it, see GraphAsOf()
+
+	cols := I_MEXPR+","+I_MCONT+","+I_MLEAD+","+I_NEAR +","+I_PLEAD+","+I_PCONT+","+I_PEXPR
+	qstr := fmt.Sprintf("SELECT NPtr,L,S,Chap,coalesce(Seq,false),%s FROM Node WHERE NOT L=0",cols)
+
+	row,err := sst.DB.QueryContext(ctx,qstr)
+
+	if err != nil {
+		fmt.Println("QUERY GetAllNodes Failed",err,qstr)
+		return nil
+	}
+
+	var nodes []Node
+
+	for row.Next() {
+
+		var n Node
+		var nptr string
+		var whole [ST_TOP]string
+
+		err = row.Scan(&nptr,&n.L,&n.S,&n.Chap,&n.Seq,&whole[0],&whole[1],&whole[2],&whole[3],&whole[4],&whole[5],&whole[6])
+
+		if err != nil {
+			fmt.Println("Couldn't read node",err)
+			continue
 		}
 
-		// Expand any dynamic inbuilt functions
+		fmt.Sscanf(nptr,"(%d,%d)",&n.NPtr.Class,&n.NPtr.CPtr)
 
-		if strings.HasPrefix(n.S,"Dynamic: ") {
-			n.S = ExpandDynamicFunctions(n.S)
+		for i := 0; i < ST_TOP; i++ {
+			n.I[i] = ParseLinkArray(whole[i])
 		}
 
-		row.Close()
+		nodes = append(nodes,n)
 	}
-	
-	n.NPtr = db_nptr
-	return n
+
+	row.Close()
+	return nodes
 }
 
 // **************************************************************************
@@ -523,8 +768,25 @@ func GetDBSingletonBySTType(sst PoSST,sttypes []int,chap string,cn []string) ([]
 			return nil,nil
 		}
 
-		stname := STTypeDBChannel(sttypes[st])
-		stinv := STTypeDBChannel(-sttypes[st])
+		if sttypes[st] > EXPRESS {
+			fmt.Println(ERR_ST_OUT_OF_BOUNDS,sttypes[st])
+			return nil,nil
+		}
+
+		stname,err := STTypeDBChannel(sttypes[st])
+
+		if err != nil {
+			fmt.Println(err)
+			return nil,nil
+		}
+
+		stinv,err := STTypeDBChannel(-sttypes[st])
+
+		if err != nil {
+			fmt.Println(err)
+			return nil,nil
+		}
+
 		qwhere += fmt.Sprintf("(array_length(%s::text[],1) IS NOT NULL AND array_length(%s::text[],1) IS NULL AND match_context((%s)[0].Ctx,%s))",stname,stinv,stname,context)
 		
 		if st != dim-1 {
@@ -534,7 +796,7 @@ func GetDBSingletonBySTType(sst PoSST,sttypes []int,chap string,cn []string) ([]
 
 	qstr = fmt.Sprintf("SELECT NPtr FROM Node WHERE lower(Chap) LIKE lower('%s') AND (%s)",chapter,qwhere)
 
-	row, err := sst.DB.Query(qstr)
+	row, err := sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("QUERY GetDBSingletonBySTType Failed",err,"IN",qstr)
@@ -570,8 +832,20 @@ func GetDBSingletonBySTType(sst PoSST,sttypes []int,chap string,cn []string) ([]
 
 	for st := 0; st < len(sttypes); st++ {
 
-		stname := STTypeDBChannel(-sttypes[st])
-		stinv := STTypeDBChannel(sttypes[st])
+		stname,err := STTypeDBChannel(-sttypes[st])
+
+		if err != nil {
+			fmt.Println(err)
+			return nil,nil
+		}
+
+		stinv,err := STTypeDBChannel(sttypes[st])
+
+		if err != nil {
+			fmt.Println(err)
+			return nil,nil
+		}
+
 		qwhere += fmt.Sprintf("(array_length(%s::text[],1) IS NOT NULL AND array_length(%s::text[],1) IS NULL AND match_context((%s)[0].Ctx,%s))",stname,stinv,stname,context)
 		
 		if st != dim-1 {
@@ -581,7 +855,7 @@ func GetDBSingletonBySTType(sst PoSST,sttypes []int,chap string,cn []string) ([]
 
 	qstr = fmt.Sprintf("SELECT NPtr FROM Node WHERE lower(Chap) LIKE lower('%s') AND (%s)",chapter,qwhere)
 
-	row, err = sst.DB.Query(qstr)
+	row, err = sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("QUERY GetDBSingletonBySTType 2 Failed",err,"IN",qstr)
@@ -711,26 +985,39 @@ func GetSequenceContainers(sst *PoSST,nodeptrs []NodePtr, arrowptrs []ArrowPtr,
 
 func GetDBArrowsWithArrowName(sst *PoSST,s string) (ArrowPtr,int) {
 
-	if sst.ARROW_DIRECTORY_TOP == 0 {
-		DownloadArrowsFromDB(sst)
+	arrowptr,sttype,err := GetDBArrowsWithArrowNameErr(DBContext(sst),sst,s)
+
+	if err != nil {
+		fmt.Println(err)
 	}
 
-	s = strings.Trim(s,"!")
+	return arrowptr,sttype
+}
+
+// **************************************************************************
+
+func GetDBArrowsWithArrowNameErr(ctx context.Context,sst *PoSST,s string) (ArrowPtr,int,error) {
 
-	if s == "" {
-		fmt.Println("No such arrow found in database:",s)
-		return 0,0
+	if !ArrowsLoaded(sst) {
+		if err := DownloadArrowsFromDBWith(ctx,sst); err != nil {
+			return 0,0,err
+		}
 	}
 
+	s = strings.Trim(s,"!")
+
+	lock := DirLock(sst)
+	lock.RLock()
+	defer lock.RUnlock()
+
 	for a := range sst.ARROW_DIRECTORY {
-		if s == sst.ARROW_DIRECTORY[a].Long || s == sst.ARROW_DIRECTORY[a].Short {
+		if s != "" && (s == sst.ARROW_DIRECTORY[a].Long || s == sst.ARROW_DIRECTORY[a].Short) {
 			sttype := STIndexToSTType(sst.ARROW_DIRECTORY[a].STAindex)
-			return sst.ARROW_DIRECTORY[a].Ptr,sttype
+			return sst.ARROW_DIRECTORY[a].Ptr,sttype,nil
 		}
 	}
 
-	fmt.Println("No such arrow found in database:",s)
-	return 0,0
+	return 0,0,fmt.Errorf("%w: (%s)",ERR_NO_SUCH_ARROW,s)
 }
 
 // **************************************************************************
@@ -739,8 +1026,10 @@ func GetDBArrowsMatchingArrowName(sst *PoSST,s string) []ArrowPtr {
 
 	var list []ArrowPtr
 
-	if sst.ARROW_DIRECTORY_TOP == 0 {
-		DownloadArrowsFromDB(sst)
+	if !ArrowsLoaded(sst) {
+		if err := DownloadArrowsFromDB(sst); err != nil {
+			fmt.Println(err)
+		}
 	}
 
 	trimmed := strings.Trim(s,"!")
@@ -749,6 +1038,10 @@ func GetDBArrowsMatchingArrowName(sst *PoSST,s string) []ArrowPtr {
 		return list
 	}
 
+	lock := DirLock(sst)
+	lock.RLock()
+	defer lock.RUnlock()
+
 	if trimmed != s {
 		for a := range sst.ARROW_DIRECTORY {
 			if sst.ARROW_DIRECTORY[a].Long==trimmed || sst.ARROW_DIRECTORY[a].Short==trimmed {
@@ -770,47 +1063,69 @@ func GetDBArrowsMatchingArrowName(sst *PoSST,s string) []ArrowPtr {
 
 func GetDBArrowByName(sst *PoSST,name string) ArrowPtr {
 
-	if sst.ARROW_DIRECTORY_TOP == 0 {
-		DownloadArrowsFromDB(sst)
+	if strings.Trim(name,"!") == "" {
+		return 0
 	}
 
-	name = strings.Trim(name,"!")
+	ptr,err := GetDBArrowByNameErr(DBContext(sst),sst,name)
 
-	if name == "" {
-		return 0
+	if err != nil {
+		fmt.Println(err,"- no arrows defined in database yet?")
+	}
+
+	return ptr
+}
+
+// **************************************************************************
+
+func GetDBArrowByNameErr(ctx context.Context,sst *PoSST,name string) (ArrowPtr,error) {
+
+	if !ArrowsLoaded(sst) {
+		if err := DownloadArrowsFromDBWith(ctx,sst); err != nil {
+			return 0,err
+		}
 	}
 
+	name = strings.Trim(name,"!")
+
+	lock := DirLock(sst)
+	lock.RLock()
+	defer lock.RUnlock()
+
 	ptr, ok := sst.ARROW_SHORT_DIR[name]
 	
 	// If not, then check longname
 	
 	if !ok {
 		ptr, ok = sst.ARROW_LONG_DIR[name]
-		
-		if !ok {
-			ptr, ok = sst.ARROW_SHORT_DIR[name]
-			
-			// If not, then check longname
-			
-			if !ok {
-				ptr, ok = sst.ARROW_LONG_DIR[name]
-				fmt.Println(ERR_NO_SUCH_ARROW,"("+name+") - no arrows defined in database yet?")
-				return 0
-			}
-		}
 	}
 
-	return ptr
+	if !ok || name == "" {
+		return 0,fmt.Errorf("%w: (%s)",ERR_NO_SUCH_ARROW,name)
+	}
+
+	return ptr,nil
 }
 
 // **************************************************************************
 
 func GetDBArrowByPtr(sst *PoSST,arrowptr ArrowPtr) ArrowDirectory {
 
-	if int(arrowptr) > len(sst.ARROW_DIRECTORY) {
-		DownloadArrowsFromDB(sst)
+	lock := DirLock(sst)
+
+	lock.RLock()
+	unknown := int(arrowptr) > len(sst.ARROW_DIRECTORY)
+	lock.RUnlock()
+
+	if unknown {
+		if err := DownloadArrowsFromDB(sst); err != nil {
+			fmt.Println(err)
+		}
 	}
 
+	lock.RLock()
+	defer lock.RUnlock()
+
 	if int(arrowptr) < len(sst.ARROW_DIRECTORY) {
 		a := sst.ARROW_DIRECTORY[arrowptr]
 		return a
@@ -828,7 +1143,9 @@ func GetDBArrowBySTType(sst PoSST,sttype int) []ArrowDirectory {
 
 	var retval []ArrowDirectory
 
-	DownloadArrowsFromDB(&sst)
+	if err := DownloadArrowsFromDB(&sst); err != nil {
+		fmt.Println(err)
+	}
 
 	for a := range sst.ARROW_DIRECTORY {
 		sta := sst.ARROW_DIRECTORY[a].STAindex
@@ -849,6 +1166,8 @@ func ArrowPtrFromArrowsNames(sst *PoSST,arrows []string) ([]ArrowPtr,[]int) {
 	var arr []ArrowPtr
 	var stt []int
 
+	arrows,exclude := SplitNegatedSearchList(arrows)
+
 	for a := range arrows {
 
 		// is the entry a number? sttype?
@@ -880,17 +1199,90 @@ func ArrowPtrFromArrowsNames(sst *PoSST,arrows []string) ([]ArrowPtr,[]int) {
 		}
 	}
 
+	if len(exclude) > 0 {
+		arr,stt = InhibitArrows(sst,arr,stt,exclude)
+	}
+
 	return arr,stt
 }
 
 // **************************************************************************
 
+func InhibitArrows(sst *PoSST,arr []ArrowPtr,stt []int,exclude []string) ([]ArrowPtr,[]int) {
+
+	// Remove the excluded arrows, or arrows of excluded sttypes. The result
+	// has to be a list of allowed arrows, so if no arrows were named, start
+	// from all the arrows (of the given sttypes, if any)
+
+	var ex_arr = make(map[ArrowPtr]bool)
+	var ex_stt = make(map[int]bool)
+
+	for _,name := range exclude {
+
+		number,err := strconv.Atoi(name)
+
+		if err == nil && number >= -EXPRESS && number <= EXPRESS {
+			ex_stt[number] = true
+		} else {
+			xarr,_ := ArrowPtrFromArrowsNames(sst,[]string{name})
+			for _,a := range xarr {
+				ex_arr[a] = true
+			}
+		}
+	}
+
+	if len(arr) == 0 {
+
+		if !ArrowsLoaded(sst) {
+			if err := DownloadArrowsFromDB(sst); err != nil {
+				fmt.Println(err)
+			}
+		}
+
+		var sttypes = make(map[int]bool)
+
+		for _,st := range stt {
+			sttypes[st] = true
+		}
+
+		for _,adir := range GetArrowDirectory(sst) {
+			if adir.Ptr > 0 && (len(stt) == 0 || sttypes[STIndexToSTType(adir.STAindex)]) {
+				arr = append(arr,adir.Ptr)
+			}
+		}
+	}
+
+	var ret_arr []ArrowPtr
+	var ret_stt []int
+	var seen_stt = make(map[int]bool)
+
+	for _,a := range arr {
+
+		st := STIndexToSTType(GetDBArrowByPtr(sst,a).STAindex)
+
+		if ex_arr[a] || ex_stt[st] {
+			continue
+		}
+
+		ret_arr = append(ret_arr,a)
+
+		if !seen_stt[st] {
+			ret_stt = append(ret_stt,st)
+			seen_stt[st] = true
+		}
+	}
+
+	return ret_arr,ret_stt
+}
+
+// **************************************************************************
+
 func GetAppointedNodesByArrow(sst *PoSST,arrow ArrowPtr,cn []string,chap string,size int) map[ArrowPtr][]Appointment {
 
 	// return a map of all the nodes in chap,context that are pointed to by the same type of arrow
         // grouped by arrow
 
-	reverse_arrow := sst.INVERSE_ARROWS[arrow]
+	reverse_arrow := GetInverseArrow(sst,arrow)
 	arr := GetDBArrowByPtr(sst,reverse_arrow)
 	sttype := STIndexToSTType(arr.STAindex)
 
@@ -912,7 +1304,7 @@ func GetAppointedNodesByArrow(sst *PoSST,arrow ArrowPtr,cn []string,chap string,
 
 	qstr := fmt.Sprintf("SELECT unnest(GetAppointments(%d,%d,%d,'%s',%s,%v))",int(reverse_arrow),sttype,size,chap_col,context,remove_chap_accents)
 
-	row, err := sst.DB.Query(qstr)
+	row, err := sst.DB.QueryContext(DBContext(sst),qstr)
 	
 	if err != nil {
 		fmt.Println("QUERY GetAppointedNodesByArrow Failed",err,qstr)
@@ -961,7 +1353,7 @@ func GetAppointedNodesBySTType(sst *PoSST,sttype int,cn []string,chap string,siz
 
 	qstr := fmt.Sprintf("SELECT unnest(GetAppointments(%d,%d,%d,'%s',%s,%v))",-1,sttype,size,chap_col,context,remove_chap_accents)
 
-	row, err := sst.DB.Query(qstr)
+	row, err := sst.DB.QueryContext(DBContext(sst),qstr)
 	
 	if err != nil {
 		fmt.Println("QUERY GetAppointedNodesByArrow Failed",err,qstr)
@@ -1034,7 +1426,7 @@ func ParseAppointedNodeCluster(sst *PoSST,whole string) Appointment {
 	fmt.Sscanf(l[1],"%d",&next.STType)
 
 	// invert arrow
-	next.Arr = sst.INVERSE_ARROWS[ArrowPtr(arrp)]
+	next.Arr = GetInverseArrow(sst,ArrowPtr(arrp))
 	next.STType = -next.STType
 
 	next.Chap = l[2]
@@ -1064,26 +1456,35 @@ func ScoreContext(i,j int) bool {
 
 func GetDBPageMap(sst PoSST,chap string,cn []string,page int,limit int) []PageMap {
 
+	return sst.STORE.GetPageMap(DBContext(&sst),&sst,chap,cn,page,limit)
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) GetPageMap(ctx context.Context,sst *PoSST,chap string,cn []string,page int,limit int) []PageMap {
+
 	var qstr string
 
 	chap = strings.Trim(chap,"\"")
 
 	context := FormatSQLStringArray(cn)
-	chapter := "%"+chap+"%"
+	chapter,rm_acc := ChapterSearchPattern(chap)
 
 	hits_per_page := limit
 	offset := (page-1) * hits_per_page;
 
-	qstr = fmt.Sprintf("SELECT DISTINCT Chap,Ctx,Line,Path FROM PageMap "+
-		"WHERE match_context(Ctx,%s)=true AND lower(Chap) LIKE lower('%s') ORDER BY Chap,Line OFFSET %d LIMIT %d",context,chapter,offset,hits_per_page)
+	qstr = fmt.Sprintf("SELECT DISTINCT Chap,Alias,Ctx,Line,Path FROM PageMap "+
+		"WHERE match_context(Ctx,%s)=true AND match_chapter(Chap,'%s',%t) ORDER BY Chap,Line OFFSET %d LIMIT %d",context,chapter,rm_acc,offset,hits_per_page)
+
+	ExplainQuery(sst,"GetPageMap",qstr)
 
-	row, err := sst.DB.Query(qstr)
+	row, err := sst.DB.QueryContext(ctx,qstr)
 
 	if err != nil {
 		fmt.Println("GetDBPageMap Failed:",err,qstr)
 	}
 
-	var path string
+	var path,alias string
 	var pagemap []PageMap
 	var line int
 	var ctxptr ContextPtr
@@ -1093,7 +1494,7 @@ func GetDBPageMap(sst PoSST,chap string,cn []string,page int,limit int) []PageMa
 
 			var event PageMap
 
-			err = row.Scan(&chap,&ctxptr,&line,&path)
+			err = row.Scan(&chap,&alias,&ctxptr,&line,&path)
 
 			if err != nil {
 				fmt.Println("Error reading GetDBPageMap",err)
@@ -1102,6 +1503,7 @@ func GetDBPageMap(sst PoSST,chap string,cn []string,page int,limit int) []PageMa
 			event.Path = ParseMapLinkArray(path)
 
 			event.Chapter = chap
+			event.Alias = alias
 			event.Context = ctxptr
 			event.Line = line;
 
@@ -1120,7 +1522,7 @@ func GetFwdConeAsNodes(sst *PoSST, start NodePtr, sttype,depth int,limit int) []
 
 	qstr := fmt.Sprintf("select unnest(fwdconeasnodes) from FwdConeAsNodes('(%d,%d)',%d,%d,%d);",start.Class,start.CPtr,sttype,depth,limit)
 
-	row, err := sst.DB.Query(qstr)
+	row, err := sst.DB.QueryContext(DBContext(sst),qstr)
 	
 	if err != nil {
 		fmt.Println("QUERY to FwdConeAsNodes Failed",err)
@@ -1151,7 +1553,7 @@ func GetFwdConeAsLinks(sst *PoSST, start NodePtr, sttype,depth int) []Link {
 
 	qstr := fmt.Sprintf("select unnest(fwdconeaslinks) from FwdConeAsLinks('(%d,%d)',%d,%d);",start.Class,start.CPtr,sttype,depth)
 
-	row, err := sst.DB.Query(qstr)
+	row, err := sst.DB.QueryContext(DBContext(sst),qstr)
 	
 	if err != nil {
 		fmt.Println("QUERY to FwdConeAsLinks Failed",err)
@@ -1177,9 +1579,20 @@ func GetFwdConeAsLinks(sst *PoSST, start NodePtr, sttype,depth int) []Link {
 
 func GetFwdPathsAsLinks(sst *PoSST, start NodePtr, sttype,depth int, maxlimit int) ([][]Link,int) {
 
+	retval := sst.STORE.FwdPathsAsLinks(DBContext(sst),sst,start,sttype,depth,maxlimit)
+
+	return retval,len(retval)
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) FwdPathsAsLinks(ctx context.Context,sst *PoSST, start NodePtr, sttype,depth int, maxlimit int) [][]Link {
+
 	qstr := fmt.Sprintf("SELECT FwdPathsAsLinks from FwdPathsAsLinks('(%d,%d)',%d,%d,%d);",start.Class,start.CPtr,sttype,depth,maxlimit)
 
-	row, err := sst.DB.Query(qstr)
+	ExplainQuery(sst,"FwdPathsAsLinks",qstr)
+
+	row, err := sst.DB.QueryContext(ctx,qstr)
 	
 	if err != nil {
 		fmt.Println("QUERY to FwdPathsAsLinks Failed",err)
@@ -1197,7 +1610,7 @@ func GetFwdPathsAsLinks(sst *PoSST, start NodePtr, sttype,depth int, maxlimit in
 		row.Close()
 	}
 
-	return retval,len(retval)
+	return retval
 }
 
 // **************************************************************************
@@ -1208,10 +1621,25 @@ func GetEntireConePathsAsLinks(sst *PoSST,orientation string,start NodePtr,depth
 
 	// Todo: how to limit path search? Usually solutions are small..?
 
+	retval := sst.STORE.EntireConePathsAsLinks(DBContext(sst),sst,orientation,start,depth,limit)
+
+	sort.Slice(retval, func(i,j int) bool {
+		return len(retval[i]) < len(retval[j])
+	})
+
+	return retval,len(retval)
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) EntireConePathsAsLinks(ctx context.Context,sst *PoSST,orientation string,start NodePtr,depth int,limit int) [][]Link {
+
 	qstr := fmt.Sprintf("select AllPathsAsLinks from AllPathsAsLinks('(%d,%d)','%s',%d, %d);",
 		start.Class,start.CPtr,orientation,depth,limit)
 
-	row, err := sst.DB.Query(qstr)
+	ExplainQuery(sst,"EntireConePathsAsLinks",qstr)
+
+	row, err := sst.DB.QueryContext(ctx,qstr)
 
 	if err != nil {
 		fmt.Println("QUERY to AllPathsAsLinks Failed",err,qstr)
@@ -1229,11 +1657,7 @@ func GetEntireConePathsAsLinks(sst *PoSST,orientation string,start NodePtr,depth
 		row.Close()
 	}
 
-	sort.Slice(retval, func(i,j int) bool {
-		return len(retval[i]) < len(retval[j])
-	})
-
-	return retval,len(retval)
+	return retval
 }
 
 // **************************************************************************
@@ -1243,8 +1667,16 @@ func GetEntireNCConePathsAsLinks(sst *PoSST,orientation string,start []NodePtr,d
 	// See also GetConstraintConePathsAsLinks for an interface with arrow matching
 	// orientation should be "fwd" or "bwd" else "both"
 
-	remove_accents,stripped := IsBracketedSearchTerm(chapter)
-	chapter = "%"+stripped+"%"
+	retval := sst.STORE.EntireNCConePathsAsLinks(DBContext(sst),sst,orientation,start,depth,chapter,context,limit)
+
+	return retval,len(retval)
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) EntireNCConePathsAsLinks(ctx context.Context,sst *PoSST,orientation string,start []NodePtr,depth int,chapter string,context []string,limit int) [][]Link {
+
+	chapter,remove_accents := ChapterSearchPattern(chapter)
 	rm_acc := "false"
 
 	if remove_accents {
@@ -1253,11 +1685,13 @@ func GetEntireNCConePathsAsLinks(sst *PoSST,orientation string,start []NodePtr,d
 
 	qstr := fmt.Sprintf("select AllNCPathsAsLinks(%s,'%s',%s,%s,'%s',%d,%d);",FormatSQLNodePtrArray(start),chapter,rm_acc,FormatSQLStringArray(context),orientation,depth,limit)
 
-	row, err := sst.DB.Query(qstr)
+	ExplainQuery(sst,"EntireNCConePathsAsLinks",qstr)
+
+	row, err := sst.DB.QueryContext(ctx,qstr)
 
 	if err != nil {
 		fmt.Println("QUERY to AllNCPathsAsLinks Failed",err,qstr)
-		os.Exit(-1)
+		return nil
 	}
 
 	var whole string
@@ -1272,7 +1706,7 @@ func GetEntireNCConePathsAsLinks(sst *PoSST,orientation string,start []NodePtr,d
 		row.Close()
 	}
 
-	return retval,len(retval)
+	return retval
 }
 
 // **************************************************************************
@@ -1282,8 +1716,16 @@ func GetConstraintConePathsAsLinks(sst *PoSST,start []NodePtr,depth int,chapter
 	// See also GetEntireNCConePathsAsLinks() for a differently optimized interface
 	// orientation should be "fwd" or "bwd" else "both"
 
-	remove_accents,stripped := IsBracketedSearchTerm(chapter)
-	chapter = "%"+stripped+"%"
+	retval := sst.STORE.ConstraintConePathsAsLinks(DBContext(sst),sst,start,depth,chapter,context,arrowptrs,sttypes,limit)
+
+	return retval,len(retval)
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) ConstraintConePathsAsLinks(ctx context.Context,sst *PoSST,start []NodePtr,depth int,chapter string,context []string,arrowptrs []ArrowPtr,sttypes []int,limit int) [][]Link {
+
+	chapter,remove_accents := ChapterSearchPattern(chapter)
 	rm_acc := "false"
 
 	if remove_accents {
@@ -1297,11 +1739,13 @@ func GetConstraintConePathsAsLinks(sst *PoSST,start []NodePtr,depth int,chapter
 
 	qstr := fmt.Sprintf("select ConstraintPathsAsLinks(%s,'%s',%s,%s,%s,%s,%d,%d);",nod,chapter,rm_acc,cnt,arr,stt,depth,limit)
 
-	row, err := sst.DB.Query(qstr)
+	ExplainQuery(sst,"ConstraintConePathsAsLinks",qstr)
+
+	row, err := sst.DB.QueryContext(ctx,qstr)
 
 	if err != nil {
 		fmt.Println("QUERY to ConstraintPathsAsLinks Failed",err,qstr)
-		os.Exit(-1)
+		return nil
 	}
 
 	var whole string
@@ -1317,7 +1761,7 @@ func GetConstraintConePathsAsLinks(sst *PoSST,start []NodePtr,depth int,chapter
 		row.Close()
 	}
 
-	return retval,len(retval)
+	return retval
 }
 
 
@@ -56,6 +56,56 @@ const PAGEMAP_TABLE = "CREATE UNLOGGED TABLE IF NOT EXISTS PageMap " +
 	"Path     Link[] " +
 	")"
 
+const NODE_ALIAS_TABLE = "CREATE TABLE IF NOT EXISTS NodeAlias " +
+	"( " +
+	"Alias     NodePtr,        \n" +
+	"S         text,           \n" +
+	"Chap      text,           \n" +
+	"Seq       boolean,        \n" +
+	"IntoNPtr  NodePtr,        \n" +
+	"IntoChap  text,           \n" +
+	I_MEXPR+"  Link[],         \n" + // Im3
+	I_MCONT+"  Link[],         \n" + // Im2
+	I_MLEAD+"  Link[],         \n" + // Im1
+	I_NEAR +"  Link[],         \n" + // In0
+	I_PLEAD+"  Link[],         \n" + // Il1
+	I_PCONT+"  Link[],         \n" + // Ic2
+	I_PEXPR+"  Link[],         \n" + // Ie3
+	"Shared    Link[]          \n" +
+	")"
+
+const PROVENANCE_TABLE = "CREATE TABLE IF NOT EXISTS Provenance " +
+	"( " +
+	"NPtr      NodePtr,        \n" +
+	"Arr       int,            \n" + // -1 for the node itself
+	"Dst       NodePtr,        \n" +
+	"File      text,           \n" +
+	"Line      int,            \n" +
+	"Author    text,           \n" +
+	"Time      bigint,         \n" + // unix seconds
+	"Primary Key(NPtr,Arr,Dst,File)" +
+	")"
+
+const CHANGELOG_TABLE = "CREATE TABLE IF NOT EXISTS ChangeLog " +
+	"( " +
+	"Id        serial primary key,\n" + // order within a revision
+	"Rev       bigint,         \n" +
+	"Time      bigint,         \n" + // unix seconds
+	"Author    text,           \n" +
+	"Op        text,           \n" +
+	"NPtr      NodePtr,        \n" +
+	"STtype    int,            \n" +
+	"Arr       int,            \n" +
+	"Wgt       real,           \n" +
+	"Ctx       int,            \n" +
+	"Dst       NodePtr,        \n" +
+	"Chap      text,           \n" +
+	"Old       text,           \n" +
+	"New       text            \n" +
+	")"
+
+const CHANGE_REVISION_SEQUENCE = "CREATE SEQUENCE IF NOT EXISTS ChangeRevision"
+
 const ARROW_DIRECTORY_TABLE = "CREATE UNLOGGED TABLE IF NOT EXISTS ArrowDirectory " +
 	"(    " +
 	"STAindex int,           " +
@@ -107,7 +157,7 @@ const APPOINTMENT_TYPE = "CREATE TYPE Appointment AS  " +
 
 func CreateType(sst PoSST, defn string) bool {
 
-	row,err := sst.DB.Query(defn)
+	row,err := sst.DB.QueryContext(DBContext(&sst),defn)
 
 	if err != nil {
 		s := fmt.Sprintln("Failed to create datatype PGLink ",err)
@@ -127,7 +177,7 @@ func CreateType(sst PoSST, defn string) bool {
 
 func CreateTable(sst PoSST,defn string) bool {
 
-	row,err := sst.DB.Query(defn)
+	row,err := sst.DB.QueryContext(DBContext(&sst),defn)
 	
 	if err != nil {
 		s := fmt.Sprintln("Failed to create a table %.10 ...",defn,err)
@@ -155,6 +205,21 @@ func DefineStoredFunctions(sst PoSST) {
 	
 	cols := I_MEXPR+","+I_MCONT+","+I_MLEAD+","+I_NEAR +","+I_PLEAD+","+I_PCONT+","+I_PEXPR
 
+	// The link column of each sttype, for the CASE statements below
+
+	var channel = make(map[int]string)
+
+	for st := -EXPRESS; st <= EXPRESS; st++ {
+
+		col,err := STTypeDBChannel(st)
+
+		if err != nil {
+			fmt.Println("Error defining postgres functions:",err)
+			return
+		}
+
+		channel[st] = col
+	}
 
 	qstr := fmt.Sprintf("CREATE OR REPLACE FUNCTION IdempInsertNode(iLi INT, iszchani INT, icptri INT, iSi TEXT, ichapi TEXT)\n" +
 		"RETURNS TABLE (    \n" +
@@ -170,7 +235,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;",cols);
 
-	row,err := sst.DB.Query(qstr)
+	row,err := sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -189,7 +254,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;",cols);
 
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -218,7 +283,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;";
 
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -243,7 +308,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;";
 
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -275,7 +340,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;";
 
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -314,7 +379,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"      CASE st \n"		
 	for st := -EXPRESS; st <= EXPRESS; st++ {
 		qstr += fmt.Sprintf("   WHEN %d THEN\n"+
-			"         SELECT %s INTO lnkarray FROM Node WHERE Nptr=thisnptr;\n",st,STTypeDBChannel(st));
+			"         SELECT %s INTO lnkarray FROM Node WHERE Nptr=thisnptr;\n",st,channel[st]);
 	}
 	qstr +=	"      ELSE RAISE EXCEPTION 'No such sttype in NCC_match %', sttype;\n" +
 		"      END CASE;\n" +
@@ -332,7 +397,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n"+
 		"$fn$ LANGUAGE plpgsql;"
 
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -354,7 +419,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n"+
 		"$fn$ LANGUAGE plpgsql;"
 
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -374,7 +439,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n"+
 		"$fn$ LANGUAGE plpgsql;"
 
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -394,7 +459,7 @@ func DefineStoredFunctions(sst PoSST) {
 	
 	for st := -EXPRESS; st <= EXPRESS; st++ {
 		qstr += fmt.Sprintf("WHEN %d THEN\n"+
-			"     SELECT %s INTO fwdlinks FROM Node WHERE Nptr=start AND NOT L=0 LIMIT maxlimit;\n",st,STTypeDBChannel(st));
+			"     SELECT %s INTO fwdlinks FROM Node WHERE Nptr=start AND NOT L=0 LIMIT maxlimit;\n",st,channel[st]);
 	}
 	qstr += "ELSE RAISE EXCEPTION 'No such sttype %', sttype;\n" +
 		"END CASE;\n" +
@@ -402,7 +467,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;\n"
 
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -442,7 +507,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;\n")
 
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -479,7 +544,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;\n"
 	
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -540,7 +605,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;\n"
 	
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -600,7 +665,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;\n"
 
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -630,7 +695,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;\n"
 
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -697,7 +762,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;\n"
 
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -729,7 +794,7 @@ func DefineStoredFunctions(sst PoSST) {
 	
         // select AllPathsAsLinks('(4,1)',3)
 
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -821,7 +886,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;\n"
 
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -841,7 +906,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;\n"
 
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -853,7 +918,7 @@ func DefineStoredFunctions(sst PoSST) {
 	// the client/lookup set is user_set - both COULD use AND expressions.
 	// We are looking for sets that overlap for a true result
 
-	qstr = "CREATE OR REPLACE FUNCTION match_context(thisctxptr int,user_set text[])\n"+
+	qstr = "CREATE OR REPLACE FUNCTION match_context_set(thisctxptr int,user_set text[])\n"+
 		"RETURNS boolean AS $fn$\n" +
 		"DECLARE\n" +
 		"   ctxstr text;\n" +
@@ -972,7 +1037,147 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;\n"
 
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
+	
+	if err != nil {
+		fmt.Println("Error defining postgres function:",qstr,err)
+	}
+
+	row.Close()
+
+	// Alternatives from boolean queries, like prod&!staging, where every
+	// term must match, or not match if negated
+
+	qstr = "CREATE OR REPLACE FUNCTION match_context_conjunction(thisctxptr int,conj text)\n"+
+		"RETURNS boolean AS $fn$\n" +
+		"DECLARE\n" +
+		"   term text;\n" +
+		"BEGIN \n" +
+
+		"FOREACH term IN ARRAY string_to_array(conj,'&') LOOP\n" +
+		"   term = trim(term);\n" +
+		"   IF length(term) = 0 THEN\n" +
+		"      CONTINUE;\n" +
+		"   END IF;\n" +
+		"   IF length(term) > 1 AND left(term,1) = '!' AND (right(term,1) <> '!' OR left(term,2) = '!!') THEN\n" +
+		"      IF match_context_set(thisctxptr,ARRAY[trim(substr(term,2))]) THEN\n" +
+		"         RETURN false;\n" +
+		"      END IF;\n" +
+		"   ELSIF NOT match_context_set(thisctxptr,ARRAY[term]) THEN\n" +
+		"      RETURN false;\n" +
+		"   END IF;\n" +
+		"END LOOP;\n" +
+
+		"RETURN true;\n" +
+		"END ;\n" +
+		"$fn$ LANGUAGE plpgsql;\n"
+
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
+	
+	if err != nil {
+		fmt.Println("Error defining postgres function:",qstr,err)
+	}
+
+	row.Close()
+
+	// Inhibition contexts: user terms like !draft exclude links whose context
+	// matches them, and the remaining terms are matched as before
+
+	qstr = "CREATE OR REPLACE FUNCTION match_context(thisctxptr int,user_set text[])\n"+
+		"RETURNS boolean AS $fn$\n" +
+		"DECLARE\n" +
+		"   include text[] = ARRAY[]::text[];\n" +
+		"   exclude text[] = ARRAY[]::text[];\n" +
+		"   conjunctions text[] = ARRAY[]::text[];\n" +
+		"   item text;\n" +
+		"BEGIN \n" +
+
+		"IF array_length(user_set,1) IS NULL THEN\n" +
+		"   RETURN true;\n"+
+		"END IF;\n"+
+
+		// !term! is an exact match, but !!term! excludes one
+
+		"FOREACH item IN ARRAY user_set LOOP\n" +
+		"   item = trim(item);\n" +
+		"   IF position('&' in item) > 0 THEN\n" +
+		"      conjunctions = array_append(conjunctions,item);\n" +
+		"   ELSIF length(item) > 1 AND left(item,1) = '!' AND (right(item,1) <> '!' OR left(item,2) = '!!') THEN\n" +
+		"      exclude = array_append(exclude,trim(substr(item,2)));\n" +
+		"   ELSE\n" +
+		"      include = array_append(include,item);\n" +
+		"   END IF;\n" +
+		"END LOOP;\n" +
+
+		"IF array_length(exclude,1) IS NOT NULL THEN\n" +
+		"   IF match_context_set(thisctxptr,exclude) THEN\n" +
+		"      RETURN false;\n" +
+		"   END IF;\n" +
+		"   IF array_length(include,1) IS NULL AND array_length(conjunctions,1) IS NULL THEN\n" +
+		"      RETURN true;\n" +
+		"   END IF;\n" +
+		"END IF;\n" +
+
+		"IF array_length(conjunctions,1) IS NULL THEN\n" +
+		"   RETURN match_context_set(thisctxptr,include);\n" +
+		"END IF;\n" +
+
+		"IF array_length(include,1) IS NOT NULL AND match_context_set(thisctxptr,include) THEN\n" +
+		"   RETURN true;\n" +
+		"END IF;\n" +
+
+		"FOREACH item IN ARRAY conjunctions LOOP\n" +
+		"   IF match_context_conjunction(thisctxptr,item) THEN\n" +
+		"      RETURN true;\n" +
+		"   END IF;\n" +
+		"END LOOP;\n" +
+
+		"RETURN false;\n" +
+		"END ;\n" +
+		"$fn$ LANGUAGE plpgsql;\n"
+
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
+	
+	if err != nil {
+		fmt.Println("Error defining postgres function:",qstr,err)
+	}
+
+	row.Close()
+
+	// Chapter LIKE patterns, with excluded chapters appended as |!pattern.
+	// Chap can be a list, and is only excluded if no listed chapter is left
+
+	qstr = "CREATE OR REPLACE FUNCTION match_chapter(chap text,chapter text,rm_acc boolean)\n"+
+		"RETURNS boolean AS $fn$\n" +
+		"DECLARE\n" +
+		"   parts text[];\n" +
+		"   one text;\n" +
+		"   cmp text;\n" +
+		"   excluded boolean;\n" +
+		"BEGIN \n" +
+		"   parts = string_to_array(chapter,'|!');\n" +
+		"   IF coalesce(array_length(parts,1),1) < 2 THEN\n" +
+		"      RETURN UnCmp(chap,rm_acc) LIKE lower(chapter);\n" +
+		"   END IF;\n" +
+		"   FOREACH one IN ARRAY string_to_array(chap,',') LOOP\n" +
+		"      cmp = UnCmp(one,rm_acc);\n" +
+		"      IF cmp LIKE lower(parts[1]) THEN\n" +
+		"         excluded = false;\n" +
+		"         FOR i IN 2..array_length(parts,1) LOOP\n" +
+		"            IF cmp LIKE lower(parts[i]) THEN\n" +
+		"               excluded = true;\n" +
+		"            END IF;\n" +
+		"         END LOOP;\n" +
+		"         IF NOT excluded THEN\n" +
+		"            RETURN true;\n" +
+		"         END IF;\n" +
+		"      END IF;\n" +
+		"   END LOOP;\n" +
+		"   RETURN false;\n" +
+		"END ;\n" +
+		"$fn$ LANGUAGE plpgsql;\n"
+
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -995,7 +1200,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;\n"
 
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -1022,7 +1227,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;\n"
 
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -1049,7 +1254,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;\n"
 
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -1073,7 +1278,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;\n"
 
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("FAILED UnCmp definition\n",qstr,err)
@@ -1183,7 +1388,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;\n"
 
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -1222,7 +1427,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;\n"
 
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -1266,7 +1471,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;\n"
 
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -1342,7 +1547,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;\n"
 
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -1395,7 +1600,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;\n")
 	
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -1439,7 +1644,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;\n")
 	
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -1488,7 +1693,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"   CASE sttype \n"
 	for st := -EXPRESS; st <= EXPRESS; st++ {
 		qstr += fmt.Sprintf("WHEN %d THEN\n"+
-			"     SELECT %s INTO fwdlinks FROM Node WHERE NOT L=0 AND Nptr=start AND UnCmp(Chap,rm_acc) LIKE lower(chapter) LIMIT maxlimit;\n",st,STTypeDBChannel(st));
+			"     SELECT %s INTO fwdlinks FROM Node WHERE NOT L=0 AND Nptr=start AND match_chapter(Chap,chapter,rm_acc) LIMIT maxlimit;\n",st,channel[st]);
 	}
 	
 	qstr += "ELSE RAISE EXCEPTION 'No such sttype %', sttype;\n" +
@@ -1497,7 +1702,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;\n"
 	
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -1530,7 +1735,7 @@ func DefineStoredFunctions(sst PoSST) {
 		qstr += fmt.Sprintf("WHEN %d THEN\n",st);
 
 		qstr += "   IF with_accents THEN\n"
-		qstr += fmt.Sprintf("      FOR this IN SELECT NPtr as thptr,Chap as thchap,%s as chn FROM Node WHERE lower(unaccent(chap)) LIKE lower(chaptxt)\n",STTypeDBChannel(st));
+		qstr += fmt.Sprintf("      FOR this IN SELECT NPtr as thptr,Chap as thchap,%s as chn FROM Node WHERE lower(unaccent(chap)) LIKE lower(chaptxt)\n",channel[st]);
 		qstr += "      LOOP\n" +
 		"         count := 0;\n" +
 		"         app.NFrom = null;"+
@@ -1562,7 +1767,7 @@ func DefineStoredFunctions(sst PoSST) {
 
 		qstr += "   ELSE\n"
 
-		qstr += fmt.Sprintf("      FOR this IN SELECT NPtr as thptr,Chap as thchap,%s as chn FROM Node WHERE lower(chap) LIKE lower(chaptxt)\n",STTypeDBChannel(st));
+		qstr += fmt.Sprintf("      FOR this IN SELECT NPtr as thptr,Chap as thchap,%s as chn FROM Node WHERE lower(chap) LIKE lower(chaptxt)\n",channel[st]);
 		qstr += "      LOOP\n" +
 		"         count := 0;\n" +
 		"         app.NFrom = null;"+
@@ -1599,7 +1804,7 @@ func DefineStoredFunctions(sst PoSST) {
 	qstr += "END ;\n"
 	qstr += "$fn$ LANGUAGE plpgsql;\n"
 	
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -1684,7 +1889,7 @@ func DefineStoredFunctions(sst PoSST) {
 			"      END LOOP;\n"+
 			"      UPDATE Node SET %s = ed_list WHERE NPtr = nnptr;\n"+
 			"   END IF;\n",
-			STTypeDBChannel(st),STTypeDBChannel(st))
+			channel[st],channel[st])
 	}
 	
 	qstr += "END LOOP;\n"+
@@ -1696,7 +1901,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;\n"
 
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -1733,7 +1938,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;\n"
 	
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -1769,7 +1974,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql;\n"
 	
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
@@ -1789,7 +1994,7 @@ func DefineStoredFunctions(sst PoSST) {
 		"END ;\n" +
 		"$fn$ LANGUAGE plpgsql IMMUTABLE;\n"
 	
-	row,err = sst.DB.Query(qstr)
+	row,err = sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("Error defining postgres function:",qstr,err)
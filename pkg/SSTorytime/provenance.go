@@ -0,0 +1,298 @@
+//**************************************************************
+//
+// provenance.go
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+	_ "github.com/lib/pq"
+
+)
+
+//**************************************************************
+// Where nodes and links came from. PageMap remembers the lines
+// of each chapter, but not which file, line or person made a
+// given node or link. A Provenance record says so, one per node
+// or link and source file, so that a node mentioned in two files
+// has two records. N4L notes them as it parses, in sst.PROVENANCE,
+// and API callers can name their source with WithProvenance()
+//**************************************************************
+
+type Provenance struct {
+
+	NPtr   NodePtr   // the node, or the source of the link
+	Arr    ArrowPtr  // the link's arrow, or NODE_PROVENANCE for the node itself
+	Dst    NodePtr   // the link's destination
+	File   string
+	Line   int
+	Author string
+	Time   int64     // unix seconds, the same for everything one upload stores
+}
+
+//**************************************************************
+
+const NODE_PROVENANCE ArrowPtr = -1
+
+// **************************************************************************
+
+func WithProvenance(sst PoSST,source *Provenance) PoSST {
+
+	// A copy of the session that records source as the origin of the
+	// nodes and links made by Vertex(), Edge() and HubJoin(). Only File,
+	// Line and Author are used, and they can be changed between calls
+
+	sst.SOURCE = source
+	return sst
+}
+
+// **************************************************************************
+
+func NoteProvenance(sst *PoSST,nptr NodePtr,arr ArrowPtr,dst NodePtr,file string,line int) {
+
+	// Record in memory, for GraphToDB(), as for the page map
+
+	var p Provenance
+
+	p.NPtr = nptr
+	p.Arr = arr
+	p.Dst = dst
+	p.File = file
+	p.Line = line
+	p.Author = ProvenanceAuthor()
+
+	sst.PROVENANCE = append(sst.PROVENANCE,p)
+}
+
+// **************************************************************************
+
+func RecordDBProvenance(sst *PoSST,nptr NodePtr,arr ArrowPtr,dst NodePtr) {
+
+	RecordDBProvenanceWith(DBContext(sst),sst,nptr,arr,dst)
+}
+
+// **************************************************************************
+
+func RecordDBProvenanceWith(ctx context.Context,sst *PoSST,nptr NodePtr,arr ArrowPtr,dst NodePtr) {
+
+	// For API calls that go straight to the database
+
+	if sst.SOURCE == nil {
+		return
+	}
+
+	p := *sst.SOURCE
+
+	p.NPtr = nptr
+	p.Arr = arr
+	p.Dst = dst
+	p.Time = time.Now().Unix()
+
+	if p.Author == "" {
+		p.Author = ProvenanceAuthor()
+	}
+
+	sst.STORE.UploadProvenance(ctx,sst,[]Provenance{p})
+}
+
+// **************************************************************************
+
+func UploadProvenanceBatch(sst *PoSST,records []Provenance) {
+
+	sst.STORE.UploadProvenance(DBContext(sst),sst,FirstProvenance(records))
+}
+
+// **************************************************************************
+
+func FirstProvenance(records []Provenance) []Provenance {
+
+	// Only the first mention of a node or link in a file counts, and
+	// every record gets the time of this upload
+
+	type key struct {
+		nptr NodePtr
+		arr  ArrowPtr
+		dst  NodePtr
+		file string
+	}
+
+	var seen = make(map[key]bool)
+	var first []Provenance
+
+	now := time.Now().Unix()
+
+	for _,p := range records {
+
+		k := key{p.NPtr,p.Arr,p.Dst,p.File}
+
+		if seen[k] {
+			continue
+		}
+
+		seen[k] = true
+
+		if p.Time == 0 {
+			p.Time = now
+		}
+
+		first = append(first,p)
+	}
+
+	return first
+}
+
+// **************************************************************************
+
+func GetDBProvenance(sst *PoSST,nptr NodePtr) []Provenance {
+
+	// The node's own records, and those of links from or to it
+
+	return sst.STORE.GetProvenance(DBContext(sst),sst,nptr)
+}
+
+// **************************************************************************
+
+func ProvenanceAuthor() string {
+
+	if author := os.Getenv("SST_AUTHOR"); author != "" {
+		return author
+	}
+
+	if u,err := user.Current(); err == nil {
+		return u.Username
+	}
+
+	return os.Getenv("USER")
+}
+
+// **************************************************************************
+
+func FormatProvenance(sst *PoSST,p Provenance) string {
+
+	var s string
+
+	if p.Arr == NODE_PROVENANCE {
+		s = fmt.Sprintf("node (%d,%d)",p.NPtr.Class,p.NPtr.CPtr)
+	} else {
+		arrow := "?"
+		arrows := GetArrowDirectory(sst)
+
+		if p.Arr >= 0 && int(p.Arr) < len(arrows) {
+			arrow = arrows[p.Arr].Long
+		}
+
+		s = fmt.Sprintf("link (%d,%d) -(%s)-> (%d,%d)",p.NPtr.Class,p.NPtr.CPtr,arrow,p.Dst.Class,p.Dst.CPtr)
+	}
+
+	s += fmt.Sprintf(" from %s:%d",p.File,p.Line)
+
+	if p.Author != "" {
+		s += " by " + p.Author
+	}
+
+	if p.Time > 0 {
+		s += " at " + time.Unix(p.Time,0).Format(time.RFC3339)
+	}
+
+	return s
+}
+
+// **************************************************************************
+// Postgres
+// **************************************************************************
+
+func (pg PostgresStore) UploadProvenance(ctx context.Context,sst *PoSST,records []Provenance) {
+
+	// A newer record for the same node or link and file replaces the old
+
+	const chunk = 200
+	var qstr string
+
+	for i,p := range records {
+
+		if i % chunk == 0 {
+			DBCommitWith(ctx,sst,qstr)
+			qstr = ""
+		}
+
+		qstr += FormatSQLProvenance(p)
+	}
+
+	DBCommitWith(ctx,sst,qstr)
+}
+
+// **************************************************************************
+
+func FormatSQLProvenance(p Provenance) string {
+
+	return fmt.Sprintf("INSERT INTO Provenance (NPtr,Arr,Dst,File,Line,Author,Time) "+
+		"VALUES ('(%d,%d)',%d,'(%d,%d)','%s',%d,'%s',%d) "+
+		"ON CONFLICT (NPtr,Arr,Dst,File) DO UPDATE SET Line=EXCLUDED.Line,Author=EXCLUDED.Author,Time=EXCLUDED.Time;\n",
+		p.NPtr.Class,p.NPtr.CPtr,p.Arr,p.Dst.Class,p.Dst.CPtr,SQLEscape(p.File),p.Line,SQLEscape(p.Author),p.Time)
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) GetProvenance(ctx context.Context,sst *PoSST,nptr NodePtr) []Provenance {
+
+	ptr := fmt.Sprintf("'(%d,%d)'::NodePtr",nptr.Class,nptr.CPtr)
+
+	qstr := fmt.Sprintf("SELECT NPtr,Arr,Dst,File,Line,Author,Time FROM Provenance "+
+		"WHERE NPtr=%s OR (Dst=%s AND Arr>=0) ORDER BY File,Line",ptr,ptr)
+
+	row,err := sst.DB.QueryContext(ctx,qstr)
+
+	if err != nil {
+		fmt.Println("QUERY GetProvenance Failed",err,qstr)
+		return nil
+	}
+
+	var records []Provenance
+
+	for row.Next() {
+
+		var p Provenance
+		var from,to string
+
+		err = row.Scan(&from,&p.Arr,&to,&p.File,&p.Line,&p.Author,&p.Time)
+
+		if err != nil {
+			fmt.Println("Couldn't read provenance",err)
+			continue
+		}
+
+		fmt.Sscanf(from,"(%d,%d)",&p.NPtr.Class,&p.NPtr.CPtr)
+		fmt.Sscanf(to,"(%d,%d)",&p.Dst.Class,&p.Dst.CPtr)
+		records = append(records,p)
+	}
+
+	row.Close()
+	return records
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) DeleteProvenance(ctx context.Context,sst *PoSST,file string) int {
+
+	qstr := fmt.Sprintf("DELETE FROM Provenance WHERE File='%s'",SQLEscape(file))
+
+	result,err := sst.DB.ExecContext(ctx,qstr)
+
+	if err != nil {
+		fmt.Println("Failed to delete provenance",err,qstr)
+		return 0
+	}
+
+	count,_ := result.RowsAffected()
+	return int(count)
+}
+
+//
+// provenance.go
+//
@@ -0,0 +1,731 @@
+// **************************************************************************
+//
+// query_expression.go
+//
+// Boolean expressions in the search language, e.g.
+//
+//   (incident OR outage) AND \ctx (prod & !staging)
+//
+// The expression tree is flattened into an OR list of AND terms, which is
+// the form the name and context matchers already understand: a list of
+// alternatives, where each alternative like "prod&!staging" must match
+// in all its parts. Terms side by side are alternatives too, as in the
+// older form, e.g. \context (prod & !staging) fish, and a negation among
+// them leaves its matches out of the rest, as !term does in that form,
+// so NOT foo bar, ! foo bar and !foo bar all mean bar without foo
+//
+// **************************************************************************
+
+package SSTorytime
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	_ "github.com/lib/pq"
+
+)
+
+// **************************************************************************
+
+const (
+	QUERY_TERM = iota
+	QUERY_AND
+	QUERY_OR
+	QUERY_NOT
+
+	QUERY_AND_OP = "&"
+	QUERY_OR_OP = "|"
+	QUERY_NOT_OP = "!"
+
+	// Flattening can multiply out the terms, so stop before it gets silly
+	QUERY_MAX_ALTERNATIVES = 64
+)
+
+var QUERY_AND_WORD = regexp.MustCompile(`\bAND\b`)
+var QUERY_OR_WORD = regexp.MustCompile(`\bOR\b`)
+var QUERY_NOT_WORD = regexp.MustCompile(`\bNOT\b`)
+var QUERY_NPTR_LITERAL = regexp.MustCompile(`^\([0-9]+,[0-9]+\)`)
+
+// **************************************************************************
+
+type QueryExpr struct {
+
+	Op   int
+	Term string        // only for QUERY_TERM
+	Args []*QueryExpr
+}
+
+// **************************************************************************
+
+type QueryToken struct {
+
+	Op   int           // QUERY_TERM or the operator, or '(' and ')'
+	Text string
+}
+
+// **************************************************************************
+
+type QueryParser struct {
+
+	Src    string
+	Tokens []QueryToken
+	Pos    int
+}
+
+// **************************************************************************
+// Recognizing expressions
+// **************************************************************************
+
+func MarkQueryOperators(cmd string) string {
+
+	// The search command is lowercased before it is parsed, so AND, OR
+	// and NOT are only operators in capitals, and become & | ! here.
+	// Quoted text is left alone, so quote them to search for the words
+
+	var ret []string
+
+	for _,part := range SplitOutsideQuotes(cmd) {
+
+		if part.Quoted {
+			ret = append(ret,part.Text)
+			continue
+		}
+
+		text := QUERY_AND_WORD.ReplaceAllString(part.Text,QUERY_AND_OP)
+		text = QUERY_OR_WORD.ReplaceAllString(text,QUERY_OR_OP)
+		text = QUERY_NOT_WORD.ReplaceAllString(text,QUERY_NOT_OP)
+
+		ret = append(ret,text)
+	}
+
+	return strings.Join(ret,"")
+}
+
+// **************************************************************************
+
+type QuotedText struct {
+
+	Text   string
+	Quoted bool
+}
+
+// **************************************************************************
+
+func SplitOutsideQuotes(s string) []QuotedText {
+
+	var parts []QuotedText
+	var upto []rune
+	runes := []rune(s)
+
+	for r := 0; r < len(runes); r++ {
+
+		if IsQuote(runes[r]) && !IsApostrophe(runes,r) {
+
+			if len(upto) > 0 {
+				parts = append(parts,QuotedText{Text: string(upto)})
+				upto = nil
+			}
+
+			qstr,_ := ReadToNext(runes,r,runes[r])
+			parts = append(parts,QuotedText{Text: qstr, Quoted: true})
+			r += len([]rune(qstr))-1
+			continue
+		}
+
+		upto = append(upto,runes[r])
+	}
+
+	if len(upto) > 0 {
+		parts = append(parts,QuotedText{Text: string(upto)})
+	}
+
+	return parts
+}
+
+// **************************************************************************
+
+func IsQueryExpression(terms []string) bool {
+
+	// A list of search terms is an expression if it uses the operators,
+	// otherwise it's the usual list of alternatives. The exact forms
+	// !term! and |term| are not operators
+
+	for _,term := range terms {
+
+		for _,part := range SplitOutsideQuotes(term) {
+
+			if part.Quoted {
+				continue
+			}
+
+			// so that a missing or extra bracket is reported
+
+			if strings.Count(part.Text,"(") != strings.Count(part.Text,")") {
+				return true
+			}
+
+			for _,word := range strings.Fields(part.Text) {
+
+				// (word) ignores accents, but ((word)) is a group and () is empty
+
+				if strings.Count(word,"(") > 1 || strings.Contains(word,"()") {
+					return true
+				}
+
+				if word == QUERY_NOT_OP {
+					return true
+				}
+
+
+				if exact,_ := IsExactMatch(word); exact && len(word) > 2 {
+					continue
+				}
+
+				if strings.ContainsAny(word,QUERY_AND_OP+QUERY_OR_OP) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// **************************************************************************
+
+func ExpandQueryTerms(terms []string,joined bool) ([]string,error) {
+
+	// Turn the terms of one search field into the list of alternatives.
+	// A trailing AND only joins this field to the next, e.g. with \ctx,
+	// which is always the case, so it's dangling unless one is joined
+
+	src := strings.TrimSpace(strings.Join(terms," "))
+
+	if joined {
+		src = strings.TrimSpace(strings.TrimSuffix(src,QUERY_AND_OP))
+	}
+
+	expr,err := ParseQueryExpression(src)
+
+	if err != nil {
+		return nil,err
+	}
+
+	return QueryAlternatives(expr)
+}
+
+// **************************************************************************
+// Parsing
+// **************************************************************************
+
+func ParseQueryExpression(src string) (*QueryExpr,error) {
+
+	// expr := and { [|] and }
+	// and  := not { & not | not }
+	// not  := ! not | primary
+	// primary := ( expr ) | term
+
+	tokens,err := LexQueryExpression(src)
+
+	if err != nil {
+		return nil,err
+	}
+
+	if len(tokens) == 0 {
+		return nil,fmt.Errorf("%w: empty expression",ERR_MALFORMED_QUERY)
+	}
+
+	var parser = QueryParser{Src: src, Tokens: tokens}
+
+	expr,err := parser.ParseOr()
+
+	if err != nil {
+		return nil,err
+	}
+
+	if parser.Pos < len(parser.Tokens) {
+
+		tok := parser.Tokens[parser.Pos]
+
+		if tok.Op == ')' {
+			return nil,fmt.Errorf("%w: unmatched ) in \"%s\"",ERR_MALFORMED_QUERY,src)
+		}
+
+		return nil,fmt.Errorf("%w: unexpected \"%s\" in \"%s\"",ERR_MALFORMED_QUERY,tok.Text,src)
+	}
+
+	return expr,nil
+}
+
+// **************************************************************************
+
+func LexQueryExpression(src string) ([]QueryToken,error) {
+
+	var tokens []QueryToken
+	runes := []rune(src)
+
+	for r := 0; r < len(runes); r++ {
+
+		switch runes[r] {
+
+		case ' ','\t':
+			continue
+
+		case '&':
+			tokens = append(tokens,QueryToken{Op: QUERY_AND, Text: QUERY_AND_OP})
+			continue
+
+		case '|',',':
+			tokens = append(tokens,QueryToken{Op: QUERY_OR, Text: QUERY_OR_OP})
+			continue
+
+		case ')':
+			tokens = append(tokens,QueryToken{Op: ')', Text: ")"})
+			continue
+
+		case '(':
+			// (class,cptr) is a node reference, not a group
+			if nptr := QUERY_NPTR_LITERAL.FindString(string(runes[r:])); nptr != "" {
+				tokens = append(tokens,QueryToken{Op: QUERY_TERM, Text: nptr})
+				r += len(nptr)-1
+			} else {
+				tokens = append(tokens,QueryToken{Op: '(', Text: "("})
+			}
+			continue
+		}
+
+		if IsQuote(runes[r]) && !IsApostrophe(runes,r) {
+
+			qstr,_ := ReadToNext(runes,r,runes[r])
+			quoted := []rune(qstr)
+
+			if len(quoted) < 2 || !IsQuote(quoted[len(quoted)-1]) {
+				return nil,fmt.Errorf("%w: unterminated quote in \"%s\"",ERR_MALFORMED_QUERY,src)
+			}
+
+			tokens = append(tokens,QueryToken{Op: QUERY_TERM, Text: string(quoted[1:len(quoted)-1])})
+			r += len(quoted)-1
+			continue
+		}
+
+		// A word runs up to the next operator or bracket
+
+		end := r
+
+		for end < len(runes) && !strings.ContainsRune(" \t&|,()",runes[end]) {
+			end++
+		}
+
+		word := string(runes[r:end])
+		r = end-1
+
+		// !term! is an exact match, and !!term! negates one
+
+		if exact,_ := IsExactMatch(word); exact && !strings.HasPrefix(word,"!!") && len(word) > 2 {
+			tokens = append(tokens,QueryToken{Op: QUERY_TERM, Text: word})
+			continue
+		}
+
+		for strings.HasPrefix(word,QUERY_NOT_OP) {
+			tokens = append(tokens,QueryToken{Op: QUERY_NOT, Text: QUERY_NOT_OP})
+			word = word[1:]
+
+			if exact,_ := IsExactMatch(word); exact && len(word) > 2 {
+				break
+			}
+		}
+
+		if len(word) > 0 {
+			tokens = append(tokens,QueryToken{Op: QUERY_TERM, Text: word})
+		}
+	}
+
+	return tokens,nil
+}
+
+// **************************************************************************
+
+func (p *QueryParser) ParseOr() (*QueryExpr,error) {
+
+	left,err := p.ParseAnd()
+
+	if err != nil {
+		return nil,err
+	}
+
+	var args = []*QueryExpr{left}
+
+	// A term or group straight after another is an alternative, as in "a b"
+
+	for p.Next(QUERY_OR) || p.Pos < len(p.Tokens) && (p.Tokens[p.Pos].Op == QUERY_TERM || p.Tokens[p.Pos].Op == '(' || p.Tokens[p.Pos].Op == QUERY_NOT) {
+
+		right,err := p.ParseAnd()
+
+		if err != nil {
+			return nil,err
+		}
+
+		args = append(args,right)
+	}
+
+	if len(args) == 1 {
+		return left,nil
+	}
+
+	// A negated alternative leaves its matches out of the others, as
+	// in "rose !draft", unless there is nothing else, as in "!a !b"
+
+	var include,exclude []*QueryExpr
+
+	for _,arg := range args {
+		if arg.Op == QUERY_NOT {
+			exclude = append(exclude,arg)
+		} else {
+			include = append(include,arg)
+		}
+	}
+
+	if len(include) == 0 || len(exclude) == 0 {
+		return &QueryExpr{Op: QUERY_OR, Args: args},nil
+	}
+
+	rest := include[0]
+
+	if len(include) > 1 {
+		rest = &QueryExpr{Op: QUERY_OR, Args: include}
+	}
+
+	return &QueryExpr{Op: QUERY_AND, Args: append([]*QueryExpr{rest},exclude...)},nil
+}
+
+// **************************************************************************
+
+func (p *QueryParser) ParseAnd() (*QueryExpr,error) {
+
+	left,err := p.ParseNot()
+
+	if err != nil {
+		return nil,err
+	}
+
+	var args = []*QueryExpr{left}
+
+	for p.Next(QUERY_AND) {
+
+		right,err := p.ParseNot()
+
+		if err != nil {
+			return nil,err
+		}
+
+		args = append(args,right)
+	}
+
+	if len(args) == 1 {
+		return left,nil
+	}
+
+	return &QueryExpr{Op: QUERY_AND, Args: args},nil
+}
+
+// **************************************************************************
+
+func (p *QueryParser) ParseNot() (*QueryExpr,error) {
+
+	if p.Next(QUERY_NOT) {
+
+		arg,err := p.ParseNot()
+
+		if err != nil {
+			return nil,err
+		}
+
+		return &QueryExpr{Op: QUERY_NOT, Args: []*QueryExpr{arg}},nil
+	}
+
+	return p.ParsePrimary()
+}
+
+// **************************************************************************
+
+func (p *QueryParser) ParsePrimary() (*QueryExpr,error) {
+
+	if p.Pos >= len(p.Tokens) {
+		prev := p.Tokens[len(p.Tokens)-1].Text
+		return nil,fmt.Errorf("%w: missing term after \"%s\" in \"%s\"",ERR_MALFORMED_QUERY,prev,p.Src)
+	}
+
+	tok := p.Tokens[p.Pos]
+
+	switch tok.Op {
+
+	case QUERY_TERM:
+		p.Pos++
+		return &QueryExpr{Op: QUERY_TERM, Term: tok.Text},nil
+
+	case '(':
+		p.Pos++
+
+		// A single bracketed word keeps its old meaning of ignoring accents
+
+		if p.Pos+1 < len(p.Tokens) && p.Tokens[p.Pos].Op == QUERY_TERM && p.Tokens[p.Pos+1].Op == ')' {
+			term := "(" + p.Tokens[p.Pos].Text + ")"
+			p.Pos += 2
+			return &QueryExpr{Op: QUERY_TERM, Term: term},nil
+		}
+
+		expr,err := p.ParseOr()
+
+		if err != nil {
+			return nil,err
+		}
+
+		if !p.Next(')') {
+			return nil,fmt.Errorf("%w: missing ) in \"%s\"",ERR_MALFORMED_QUERY,p.Src)
+		}
+
+		return expr,nil
+	}
+
+	if tok.Op == ')' && p.Pos > 0 && p.Tokens[p.Pos-1].Op == '(' {
+		return nil,fmt.Errorf("%w: empty brackets in \"%s\"",ERR_MALFORMED_QUERY,p.Src)
+	}
+
+	if p.Pos == 0 {
+		return nil,fmt.Errorf("%w: \"%s\" without a term before it in \"%s\"",ERR_MALFORMED_QUERY,tok.Text,p.Src)
+	}
+
+	prev := p.Tokens[p.Pos-1].Text
+	return nil,fmt.Errorf("%w: \"%s\" follows \"%s\" in \"%s\"",ERR_MALFORMED_QUERY,tok.Text,prev,p.Src)
+}
+
+// **************************************************************************
+
+func (p *QueryParser) Next(op int) bool {
+
+	if p.Pos < len(p.Tokens) && p.Tokens[p.Pos].Op == op {
+		p.Pos++
+		return true
+	}
+
+	return false
+}
+
+// **************************************************************************
+// Flattening into alternatives
+// **************************************************************************
+
+func QueryAlternatives(expr *QueryExpr) ([]string,error) {
+
+	// Each alternative is a conjunction of terms joined by &, where !term
+	// is negated. The positive terms come first, and an alternative with
+	// only negations starts with &, e.g. &!term, since !term on its own
+	// would exclude matches from all the alternatives
+
+	conjunctions,err := QueryDNF(expr,false)
+
+	if err != nil {
+		return nil,err
+	}
+
+	var alternatives []string
+
+	for _,conj := range conjunctions {
+
+		var include,exclude []string
+
+		for _,term := range conj {
+			if negated,_ := IsNegatedSearchTerm(term); negated {
+				exclude = append(exclude,term)
+			} else {
+				include = append(include,term)
+			}
+		}
+
+		alt := strings.Join(append(include,exclude...),QUERY_AND_OP)
+
+		if len(include) == 0 {
+			alt = QUERY_AND_OP + alt
+		}
+
+		alternatives = append(alternatives,alt)
+	}
+
+	return alternatives,nil
+}
+
+// **************************************************************************
+
+func QueryTermList(alts []string) ([]string,[]string,bool) {
+
+	// The alternatives as a list of terms, and the !terms they all
+	// exclude, for fields like \arrow and \chapter that have no
+	// conjunctions. False if they can't be written that way
+
+	var include,exclude []string
+
+	for a,alt := range alts {
+
+		in,ex := SplitQueryConjunction(alt)
+
+		if len(in) > 1 {
+			return nil,nil,false
+		}
+
+		if a == 0 {
+			exclude = ex
+		} else if strings.Join(ex,QUERY_AND_OP) != strings.Join(exclude,QUERY_AND_OP) {
+			return nil,nil,false
+		}
+
+		include = append(include,in...)
+	}
+
+	return include,exclude,true
+}
+
+// **************************************************************************
+
+func QueryDNF(expr *QueryExpr,negate bool) ([][]string,error) {
+
+	// Disjunctive normal form, pushing negations down to the terms
+
+	switch expr.Op {
+
+	case QUERY_TERM:
+		if negate {
+			return [][]string{{QUERY_NOT_OP + expr.Term}},nil
+		}
+		return [][]string{{expr.Term}},nil
+
+	case QUERY_NOT:
+		return QueryDNF(expr.Args[0],!negate)
+	}
+
+	// De Morgan: a negated AND is an OR of negations and vice versa
+
+	conjoin := expr.Op == QUERY_AND
+
+	if negate {
+		conjoin = !conjoin
+	}
+
+	var result [][]string
+
+	for a,arg := range expr.Args {
+
+		sub,err := QueryDNF(arg,negate)
+
+		if err != nil {
+			return nil,err
+		}
+
+		if !conjoin {
+			result = append(result,sub...)
+		} else if a == 0 {
+			result = sub
+		} else {
+			var product [][]string
+
+			for _,left := range result {
+				for _,right := range sub {
+					var conj []string
+					conj = append(conj,left...)
+					conj = append(conj,right...)
+					product = append(product,conj)
+				}
+			}
+
+			result = product
+		}
+
+		if len(result) > QUERY_MAX_ALTERNATIVES {
+			return nil,fmt.Errorf("%w: too many combinations (over %d), try simplifying the expression",ERR_MALFORMED_QUERY,QUERY_MAX_ALTERNATIVES)
+		}
+	}
+
+	return result,nil
+}
+
+// **************************************************************************
+// Evaluating alternatives
+// **************************************************************************
+
+func IsQueryConjunction(term string) bool {
+
+	return strings.Contains(term,QUERY_AND_OP)
+}
+
+// **************************************************************************
+
+func SplitQueryConjunctions(list []string) ([]string,[]string) {
+
+	// Separate plain alternatives from conjunctions like a&!b
+
+	var plain,conj []string
+
+	for _,item := range list {
+
+		if IsQueryConjunction(item) {
+			conj = append(conj,item)
+		} else {
+			plain = append(plain,item)
+		}
+	}
+
+	return plain,conj
+}
+
+// **************************************************************************
+
+func SplitQueryConjunction(term string) ([]string,[]string) {
+
+	// The terms that must match, and the terms that must not
+
+	var include,exclude []string
+
+	for _,part := range strings.Split(term,QUERY_AND_OP) {
+
+		part = strings.TrimSpace(part)
+
+		if len(part) == 0 {
+			continue
+		}
+
+		negated,stripped := IsNegatedSearchTerm(part)
+
+		if negated {
+			exclude = append(exclude,stripped)
+		} else {
+			include = append(include,part)
+		}
+	}
+
+	return include,exclude
+}
+
+// **************************************************************************
+
+func MatchQueryConjunction(term string,match func(string) bool) bool {
+
+	include,exclude := SplitQueryConjunction(term)
+
+	for _,in := range include {
+		if !match(in) {
+			return false
+		}
+	}
+
+	for _,ex := range exclude {
+		if match(ex) {
+			return false
+		}
+	}
+
+	return true
+}
+
+//
+// query_expression.go
+//
@@ -0,0 +1,738 @@
+// **************************************************************************
+//
+// rdf.go
+//
+// Map the graph to and from RDF triples: nodes are resources with
+// a label, arrows are predicates that remember their ST type, and
+// contexts are named graphs. See rdf_parsing.go for the syntax
+//
+// **************************************************************************
+
+package SSTorytime
+
+import (
+	"fmt"
+	"strings"
+	"strconv"
+	"net/url"
+	_ "github.com/lib/pq"
+
+)
+
+// **************************************************************************
+
+const (
+	RDF_NS = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	RDFS_NS = "http://www.w3.org/2000/01/rdf-schema#"
+	SKOS_NS = "http://www.w3.org/2004/02/skos/core#"
+
+	RDF_TYPE = RDF_NS + "type"
+	RDF_SUBJECT = RDF_NS + "subject"
+	RDF_PREDICATE = RDF_NS + "predicate"
+	RDF_OBJECT = RDF_NS + "object"
+	RDFS_LABEL = RDFS_NS + "label"
+	SKOS_PREFLABEL = SKOS_NS + "prefLabel"
+
+	// Our own vocabulary
+
+	SST_RDF_NS = "https://github.com/markburgess/SSTorytime/rdf#"
+	SST_NODE_NS = "https://github.com/markburgess/SSTorytime/node/"
+	SST_ARROW_NS = "https://github.com/markburgess/SSTorytime/arrow/"
+	SST_CONTEXT_NS = "https://github.com/markburgess/SSTorytime/context/"
+
+	SST_RDF_ARROW = SST_RDF_NS + "Arrow"
+	SST_RDF_SHORT = SST_RDF_NS + "short"
+	SST_RDF_LONG = SST_RDF_NS + "long"
+	SST_RDF_STTYPE = SST_RDF_NS + "sttype"
+	SST_RDF_INVERSE = SST_RDF_NS + "inverse"
+	SST_RDF_CHAPTER = SST_RDF_NS + "chapter"
+	SST_RDF_WEIGHT = SST_RDF_NS + "weight"
+	SST_RDF_CONTEXT = SST_RDF_NS + "context"
+)
+
+var RDF_PREFIXES = map[string]string{
+	"rdf": RDF_NS,
+	"rdfs": RDFS_NS,
+	"sst": SST_RDF_NS,
+	"sstn": SST_NODE_NS,
+	"ssta": SST_ARROW_NS,
+	"sstc": SST_CONTEXT_NS,
+}
+
+// **************************************************************************
+
+type RDFTriple struct {
+
+	Subject   string   // IRI or _:blank
+	Predicate string   // IRI
+	Object    string   // IRI, _:blank or the literal value
+	Literal   bool     // Object is a literal
+	Graph     string   // named graph IRI, "" for the default graph
+}
+
+// **************************************************************************
+
+type RDFArrow struct {
+
+	// An arrow declared in the data, as written by GraphToRDF
+
+	Short   string
+	Long    string
+	STType  int
+	Inverse string
+	Typed   bool     // STType was given
+}
+
+// **************************************************************************
+// Graph -> RDF
+// **************************************************************************
+
+func GraphToRDF(sst *PoSST,graph GraphExport) []RDFTriple {
+
+	// Declarations first, so that a reader sees what the predicates mean
+
+	var triples []RDFTriple
+	var declared = make(map[ArrowPtr]bool)
+
+	for _,edge := range graph.Edges {
+
+		for _,arr := range []ArrowPtr{edge.Arr,GetInverseArrow(sst,edge.Arr)} {
+
+			if declared[arr] {
+				continue
+			}
+
+			declared[arr] = true
+			triples = append(triples,RDFArrowDeclaration(sst,arr)...)
+		}
+	}
+
+	for _,n := range graph.Nodes {
+		iri := RDFNodeIRI(n.NPtr)
+		triples = append(triples,RDFTriple{Subject: iri, Predicate: RDFS_LABEL, Object: n.Text, Literal: true})
+		triples = append(triples,RDFTriple{Subject: iri, Predicate: SST_RDF_CHAPTER, Object: n.Chapter, Literal: true})
+	}
+
+	// Links go into the named graph of their context, and
+	// weights other than 1 need a reified statement
+
+	var blank int
+
+	for _,edge := range graph.Edges {
+
+		var triple RDFTriple
+
+		triple.Subject = RDFNodeIRI(edge.From)
+		triple.Predicate = RDFArrowIRI(edge.Short)
+		triple.Object = RDFNodeIRI(edge.To)
+
+		if edge.Context != "" && edge.Context != "any" {
+			triple.Graph = RDFContextIRI(edge.Context)
+		}
+
+		triples = append(triples,triple)
+
+		if edge.Wgt != 1 {
+			stmt := fmt.Sprintf("_:w%d",blank)
+			blank++
+			triples = append(triples,RDFTriple{Subject: stmt, Predicate: RDF_SUBJECT, Object: triple.Subject, Graph: triple.Graph})
+			triples = append(triples,RDFTriple{Subject: stmt, Predicate: RDF_PREDICATE, Object: triple.Predicate, Graph: triple.Graph})
+			triples = append(triples,RDFTriple{Subject: stmt, Predicate: RDF_OBJECT, Object: triple.Object, Graph: triple.Graph})
+			triples = append(triples,RDFTriple{Subject: stmt, Predicate: SST_RDF_WEIGHT, Object: fmt.Sprintf("%g",edge.Wgt), Literal: true, Graph: triple.Graph})
+		}
+	}
+
+	return triples
+}
+
+// **************************************************************************
+
+func FlattenRDFGraphs(triples []RDFTriple) []RDFTriple {
+
+	// Plain Turtle has no named graphs, so a link's context goes on
+	// its reified statement as sst:context, next to any weight
+
+	var reified = make(map[string]bool)
+	var stmts = make(map[string]string)
+
+	for _,r := range GetRDFReifications(triples) {
+		reified[r.Id] = true
+		stmts[RDFStatementKey(r.Triple)] = r.Id
+	}
+
+	var flat []RDFTriple
+	var blank int
+
+	for _,t := range triples {
+
+		graph := t.Graph
+		key := RDFStatementKey(t)
+		t.Graph = ""
+		flat = append(flat,t)
+
+		if graph == "" || reified[t.Subject] {
+			continue
+		}
+
+		stmt,ok := stmts[key]
+
+		if !ok {
+			stmt = fmt.Sprintf("_:c%d",blank)
+			blank++
+			flat = append(flat,RDFTriple{Subject: stmt, Predicate: RDF_SUBJECT, Object: t.Subject})
+			flat = append(flat,RDFTriple{Subject: stmt, Predicate: RDF_PREDICATE, Object: t.Predicate})
+			flat = append(flat,RDFTriple{Subject: stmt, Predicate: RDF_OBJECT, Object: t.Object})
+		}
+
+		flat = append(flat,RDFTriple{Subject: stmt, Predicate: SST_RDF_CONTEXT, Object: graph})
+	}
+
+	return flat
+}
+
+// **************************************************************************
+
+func RDFArrowDeclaration(sst *PoSST,arr ArrowPtr) []RDFTriple {
+
+	arrow := GetDBArrowByPtr(sst,arr)
+	inverse := GetDBArrowByPtr(sst,GetInverseArrow(sst,arr))
+	iri := RDFArrowIRI(arrow.Short)
+
+	var triples []RDFTriple
+
+	triples = append(triples,RDFTriple{Subject: iri, Predicate: RDF_TYPE, Object: SST_RDF_ARROW})
+	triples = append(triples,RDFTriple{Subject: iri, Predicate: SST_RDF_SHORT, Object: arrow.Short, Literal: true})
+	triples = append(triples,RDFTriple{Subject: iri, Predicate: SST_RDF_LONG, Object: arrow.Long, Literal: true})
+	triples = append(triples,RDFTriple{Subject: iri, Predicate: SST_RDF_STTYPE, Object: RDFSTTypeName(STIndexToSTType(arrow.STAindex)), Literal: true})
+	triples = append(triples,RDFTriple{Subject: iri, Predicate: SST_RDF_INVERSE, Object: RDFArrowIRI(inverse.Short)})
+
+	return triples
+}
+
+// **************************************************************************
+
+func RDFNodeIRI(nptr NodePtr) string {
+
+	return fmt.Sprintf("%s%d_%d",SST_NODE_NS,nptr.Class,nptr.CPtr)
+}
+
+// **************************************************************************
+
+func RDFArrowIRI(short string) string {
+
+	return SST_ARROW_NS + url.PathEscape(short)
+}
+
+// **************************************************************************
+
+func RDFContextIRI(context string) string {
+
+	return SST_CONTEXT_NS + url.PathEscape(context)
+}
+
+// **************************************************************************
+
+func RDFSTTypeName(sttype int) string {
+
+	switch sttype {
+	case NEAR:
+		return "NEAR"
+	case LEADSTO:
+		return "LEADSTO"
+	case CONTAINS:
+		return "CONTAINS"
+	case EXPRESS:
+		return "EXPRESS"
+	case -LEADSTO:
+		return "-LEADSTO"
+	case -CONTAINS:
+		return "-CONTAINS"
+	case -EXPRESS:
+		return "-EXPRESS"
+	}
+
+	return strconv.Itoa(sttype)
+}
+
+// **************************************************************************
+
+func RDFSTTypeFromName(name string) (int,bool) {
+
+	name = strings.ToUpper(strings.TrimSpace(name))
+
+	for st := -EXPRESS; st <= EXPRESS; st++ {
+		if name == RDFSTTypeName(st) {
+			return st,true
+		}
+	}
+
+	// Also accept the N4L section names
+
+	switch strings.TrimPrefix(strings.TrimPrefix(name,"+"),"-") {
+	case "SIMILARITY":
+		return NEAR,true
+	case "PROPERTIES":
+		return EXPRESS,true
+	}
+
+	return 0,false
+}
+
+// **************************************************************************
+// RDF -> Graph
+// **************************************************************************
+
+func UploadRDF(sst *PoSST,triples []RDFTriple,chapter string,default_sttype int) (int,int) {
+
+	// Create nodes and links for the triples. Predicates become arrows,
+	// found by short name or else defined with default_sttype, unless
+	// the data declares them. Returns the number of nodes and links
+
+	new_arrows := AddRDFMandatory(sst)
+
+	arrows := GetRDFArrows(triples)
+	labels := GetRDFLiterals(triples,[]string{RDFS_LABEL,SKOS_PREFLABEL})
+	chapters := GetRDFLiterals(triples,[]string{SST_RDF_CHAPTER})
+	weights,contexts,statements := GetRDFStatements(triples)
+
+	var nodes = make(map[string]Node)
+	var arrowptrs = make(map[string]ArrowPtr)
+	var links int
+
+	for _,t := range triples {
+
+		if IsRDFMetaTriple(t,arrows,statements) {
+			continue
+		}
+
+		arr,ok := arrowptrs[t.Predicate]
+
+		if !ok {
+			var created bool
+			arr,created = GetRDFArrowPtr(sst,t.Predicate,arrows,default_sttype)
+			arrowptrs[t.Predicate] = arr
+			new_arrows = new_arrows || created
+		}
+
+		if arr < 0 {
+			continue
+		}
+
+		from := GetRDFNode(sst,nodes,t.Subject,false,labels,chapters,chapter)
+		to := GetRDFNode(sst,nodes,t.Object,t.Literal,labels,chapters,chapter)
+
+		if from.NPtr == to.NPtr {
+			fmt.Println("Skipping self-loop in RDF",t.Subject,t.Predicate,t.Object)
+			continue
+		}
+
+		var link Link
+
+		link.Arr = arr
+		link.Wgt = 1
+		graph := t.Graph
+
+		if c,ok := contexts[RDFStatementKey(t)]; ok && graph == "" {
+			graph = c
+		}
+
+		link.Ctx = TryContext(sst,RDFGraphContext(graph))
+
+		if w,ok := weights[RDFStatementKey(t)]; ok && w != 0 {
+			link.Wgt = w
+		}
+
+		if err := IdempDBAddLink(sst,from,link,to); err != nil {
+			fmt.Println("Skipping RDF statement",t.Subject,t.Predicate,t.Object,err)
+			continue
+		}
+
+		links++
+	}
+
+	// Labelled resources are nodes even if nothing links to them
+
+	for _,t := range triples {
+		if t.Predicate == RDFS_LABEL || t.Predicate == SKOS_PREFLABEL {
+			GetRDFNode(sst,nodes,t.Subject,false,labels,chapters,chapter)
+		}
+	}
+
+	if new_arrows {
+		sst.STORE.UploadArrows(DBContext(sst),sst)
+	}
+
+	return len(nodes),links
+}
+
+// **************************************************************************
+
+func AddRDFMandatory(sst *PoSST) bool {
+
+	// An empty database needs the conventions N4L starts with: arrow 0
+	// is the empty link and context 0 is "any"
+
+	TryContext(sst,[]string{"any"})
+
+	if len(GetArrowDirectory(sst)) > 0 {
+		return false
+	}
+
+	arr := InsertArrowDirectory(sst,"leadsto","empty","debug","+")
+	inv := InsertArrowDirectory(sst,"leadsto","void","unbug","-")
+	InsertInverseArrowDirectory(sst,arr,inv)
+
+	return true
+}
+
+// **************************************************************************
+
+func GetRDFArrows(triples []RDFTriple) map[string]RDFArrow {
+
+	var arrows = make(map[string]RDFArrow)
+
+	for _,t := range triples {
+
+		switch t.Predicate {
+		case SST_RDF_SHORT,SST_RDF_LONG,SST_RDF_STTYPE,SST_RDF_INVERSE:
+		case RDF_TYPE:
+			if t.Object != SST_RDF_ARROW {
+				continue
+			}
+		default:
+			continue
+		}
+
+		a := arrows[t.Subject]
+
+		switch t.Predicate {
+		case SST_RDF_SHORT:
+			a.Short = t.Object
+		case SST_RDF_LONG:
+			a.Long = t.Object
+		case SST_RDF_STTYPE:
+			a.STType,a.Typed = RDFSTTypeFromName(t.Object)
+		case SST_RDF_INVERSE:
+			a.Inverse = t.Object
+		}
+
+		arrows[t.Subject] = a
+	}
+
+	return arrows
+}
+
+// **************************************************************************
+
+func GetRDFLiterals(triples []RDFTriple,predicates []string) map[string]string {
+
+	// First value wins, in the order of the predicates given
+
+	var values = make(map[string]string)
+
+	for p := len(predicates)-1; p >= 0; p-- {
+		for t := len(triples)-1; t >= 0; t-- {
+			if triples[t].Predicate == predicates[p] && triples[t].Literal {
+				values[triples[t].Subject] = triples[t].Object
+			}
+		}
+	}
+
+	return values
+}
+
+// **************************************************************************
+
+type RDFReification struct {
+
+	// _:s rdf:subject S; rdf:predicate P; rdf:object O; sst:weight W; sst:context C
+
+	Id      string
+	Triple  RDFTriple
+	Weight  float32
+	HasWgt  bool
+	Context string   // the named graph of the statement in plain Turtle
+}
+
+// **************************************************************************
+
+func GetRDFReifications(triples []RDFTriple) []RDFReification {
+
+	var stmts = make(map[string]*RDFReification)
+	var order []string
+
+	for _,t := range triples {
+
+		switch t.Predicate {
+		case RDF_SUBJECT,RDF_PREDICATE,RDF_OBJECT,SST_RDF_WEIGHT,SST_RDF_CONTEXT:
+		default:
+			continue
+		}
+
+		r,ok := stmts[t.Subject]
+
+		if !ok {
+			r = &RDFReification{Id: t.Subject}
+			r.Triple.Graph = t.Graph
+			stmts[t.Subject] = r
+			order = append(order,t.Subject)
+		}
+
+		switch t.Predicate {
+		case RDF_SUBJECT:
+			r.Triple.Subject = t.Object
+		case RDF_PREDICATE:
+			r.Triple.Predicate = t.Object
+		case RDF_OBJECT:
+			r.Triple.Object = t.Object
+		case SST_RDF_WEIGHT:
+			w,err := strconv.ParseFloat(t.Object,32)
+			if err == nil {
+				r.Weight = float32(w)
+				r.HasWgt = true
+			}
+		case SST_RDF_CONTEXT:
+			r.Context = t.Object
+		}
+	}
+
+	var reifications []RDFReification
+
+	for _,id := range order {
+		reifications = append(reifications,*stmts[id])
+	}
+
+	return reifications
+}
+
+// **************************************************************************
+
+func GetRDFStatements(triples []RDFTriple) (map[string]float32,map[string]string,map[string]bool) {
+
+	// The weights and contexts that reified statements give their triples
+
+	var weights = make(map[string]float32)
+	var contexts = make(map[string]string)
+	var statements = make(map[string]bool)
+
+	for _,r := range GetRDFReifications(triples) {
+
+		statements[r.Id] = true
+		key := RDFStatementKey(r.Triple)
+
+		if r.HasWgt {
+			weights[key] = r.Weight
+		}
+
+		if r.Context != "" {
+			contexts[key] = r.Context
+		}
+	}
+
+	return weights,contexts,statements
+}
+
+// **************************************************************************
+
+func RDFStatementKey(t RDFTriple) string {
+
+	return t.Graph + " " + t.Subject + " " + t.Predicate + " " + t.Object
+}
+
+// **************************************************************************
+
+func IsRDFMetaTriple(t RDFTriple,arrows map[string]RDFArrow,statements map[string]bool) bool {
+
+	// Triples that describe the graph rather than belong to it
+
+	if _,isarrow := arrows[t.Subject]; isarrow {
+		return true
+	}
+
+	if statements[t.Subject] {
+		return true
+	}
+
+	switch t.Predicate {
+	case RDFS_LABEL,SKOS_PREFLABEL,SST_RDF_CHAPTER:
+		return t.Literal
+	}
+
+	return false
+}
+
+// **************************************************************************
+
+func GetRDFArrowPtr(sst *PoSST,predicate string,arrows map[string]RDFArrow,default_sttype int) (ArrowPtr,bool) {
+
+	// Use an existing arrow of the same name, or define a new pair
+
+	decl := arrows[predicate]
+
+	if decl.Short == "" {
+		decl.Short = RDFLocalName(predicate)
+	}
+
+	if decl.Long == "" {
+		decl.Long = decl.Short
+	}
+
+	if arr,ok := GetArrowPtrByName(sst,decl.Short); ok {
+		return arr,false
+	}
+
+	if arr,ok := GetArrowPtrByName(sst,decl.Long); ok {
+		return arr,false
+	}
+
+	sttype := default_sttype
+
+	if decl.Typed {
+		sttype = decl.STType
+	}
+
+	if sttype == NEAR {
+		arr := InsertArrowDirectory(sst,"similarity",decl.Short,decl.Long,"both")
+		InsertInverseArrowDirectory(sst,arr,arr)
+		return arr,arr >= 0
+	}
+
+	inv,declared := arrows[decl.Inverse]
+
+	if !declared || inv.Short == "" {
+		inv.Short = "inv-" + decl.Short
+		inv.Long = "inverse " + decl.Long
+	}
+
+	if inv.Long == "" {
+		inv.Long = inv.Short
+	}
+
+	stname := STTypeSectionName(sttype)
+
+	// Always define the pair from the positive side
+
+	if sttype < 0 {
+		fwd := InsertArrowDirectory(sst,stname,inv.Short,inv.Long,"+")
+		bwd := InsertArrowDirectory(sst,stname,decl.Short,decl.Long,"-")
+		InsertInverseArrowDirectory(sst,fwd,bwd)
+		return bwd,bwd >= 0
+	}
+
+	fwd := InsertArrowDirectory(sst,stname,decl.Short,decl.Long,"+")
+	bwd := InsertArrowDirectory(sst,stname,inv.Short,inv.Long,"-")
+	InsertInverseArrowDirectory(sst,fwd,bwd)
+
+	return fwd,fwd >= 0
+}
+
+// **************************************************************************
+
+func STTypeSectionName(sttype int) string {
+
+	// The names used by GetSTIndexByName and the arrows-*.sst files
+
+	switch sttype {
+	case LEADSTO,-LEADSTO:
+		return "leadsto"
+	case CONTAINS,-CONTAINS:
+		return "contains"
+	case EXPRESS,-EXPRESS:
+		return "properties"
+	}
+
+	return "similarity"
+}
+
+// **************************************************************************
+
+func GetRDFNode(sst *PoSST,nodes map[string]Node,term string,literal bool,labels,chapters map[string]string,chapter string) Node {
+
+	key := term
+
+	if literal {
+		key = "\"" + term
+	}
+
+	if n,ok := nodes[key]; ok {
+		return n
+	}
+
+	var n Node
+
+	switch {
+	case literal:
+		n.S = term
+	case labels[term] != "":
+		n.S = labels[term]
+	case strings.HasPrefix(term,"_:"):
+		// blank nodes are local to the file, so don't merge them by name
+		n.S = chapter + " " + term
+	default:
+		n.S = RDFLocalName(term)
+	}
+
+	n.Chap = chapter
+
+	if !literal && chapters[term] != "" {
+		n.Chap = chapters[term]
+	}
+
+	n = IdempDBAddNode(sst,n)
+	nodes[key] = n
+
+	return n
+}
+
+// **************************************************************************
+
+func RDFGraphContext(graph string) []string {
+
+	// The default graph is the same as no context in N4L
+
+	if graph == "" {
+		return []string{"any"}
+	}
+
+	var name string
+
+	if strings.HasPrefix(graph,SST_CONTEXT_NS) {
+		name,_ = url.PathUnescape(strings.TrimPrefix(graph,SST_CONTEXT_NS))
+	} else {
+		name = RDFLocalName(graph)
+	}
+
+	var context []string
+
+	for _,item := range strings.Split(name,",") {
+		context = append(context,strings.TrimSpace(item))
+	}
+
+	return context
+}
+
+// **************************************************************************
+
+func RDFLocalName(iri string) string {
+
+	// The readable end of an IRI, e.g. http://x.org/ns#broader -> broader
+
+	local := iri
+	cut := strings.LastIndexAny(strings.TrimRight(iri,"/#"),"/#:")
+
+	if cut >= 0 && cut < len(iri)-1 {
+		local = strings.TrimRight(iri[cut+1:],"/#")
+	}
+
+	unescaped,err := url.PathUnescape(local)
+
+	if err == nil && unescaped != "" {
+		return unescaped
+	}
+
+	return local
+}
+
+//
+// rdf.go
+//
@@ -0,0 +1,1220 @@
+// **************************************************************************
+//
+// rdf_parsing.go
+//
+// Read and write RDF triples as Turtle, TriG (Turtle with graph blocks
+// for the named graphs) and JSON-LD. This covers what vocabularies
+// normally use, not every corner of the specifications
+//
+// **************************************************************************
+
+package SSTorytime
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"strconv"
+	"unicode"
+	"encoding/json"
+	_ "github.com/lib/pq"
+
+)
+
+// **************************************************************************
+// Turtle / TriG output
+// **************************************************************************
+
+func WriteTurtle(triples []RDFTriple) string {
+
+	// Turtle has only the default graph, so contexts become sst:context
+
+	return WriteTriG(FlattenRDFGraphs(triples))
+}
+
+// **************************************************************************
+
+func WriteTriG(triples []RDFTriple) string {
+
+	var out strings.Builder
+
+	var names []string
+
+	for name := range RDF_PREFIXES {
+		names = append(names,name)
+	}
+
+	sort.Strings(names)
+
+	for _,name := range names {
+		out.WriteString(fmt.Sprintf("@prefix %s: <%s> .\n",name,RDF_PREFIXES[name]))
+	}
+
+	graphs,order := GroupRDFTriples(triples)
+
+	for _,graph := range order {
+
+		indent := ""
+
+		if graph != "" {
+			out.WriteString(fmt.Sprintf("\n%s {\n",TurtleTerm(graph,false)))
+			indent = "    "
+		}
+
+		for _,subject := range graphs[graph].Order {
+
+			out.WriteString("\n" + indent + TurtleTerm(subject,false))
+
+			preds := graphs[graph].Subjects[subject]
+
+			for p,pred := range preds.Order {
+
+				if p > 0 {
+					out.WriteString(" ;\n" + indent + "   ")
+				}
+
+				out.WriteString(" " + TurtleTerm(pred,false) + " ")
+
+				for o,obj := range preds.Objects[pred] {
+					if o > 0 {
+						out.WriteString(", ")
+					}
+					out.WriteString(TurtleTerm(obj.Object,obj.Literal))
+				}
+			}
+
+			out.WriteString(" .\n")
+		}
+
+		if graph != "" {
+			out.WriteString("}\n")
+		}
+	}
+
+	return out.String()
+}
+
+// **************************************************************************
+
+type RDFSubjects struct {
+
+	Order    []string
+	Subjects map[string]*RDFPredicates
+}
+
+type RDFPredicates struct {
+
+	Order   []string
+	Objects map[string][]RDFTriple
+}
+
+// **************************************************************************
+
+func GroupRDFTriples(triples []RDFTriple) (map[string]*RDFSubjects,[]string) {
+
+	// graph -> subject -> predicate -> objects, keeping first-seen order
+
+	var graphs = make(map[string]*RDFSubjects)
+	var order []string
+
+	for _,t := range triples {
+
+		g,ok := graphs[t.Graph]
+
+		if !ok {
+			g = &RDFSubjects{Subjects: make(map[string]*RDFPredicates)}
+			graphs[t.Graph] = g
+			order = append(order,t.Graph)
+		}
+
+		s,ok := g.Subjects[t.Subject]
+
+		if !ok {
+			s = &RDFPredicates{Objects: make(map[string][]RDFTriple)}
+			g.Subjects[t.Subject] = s
+			g.Order = append(g.Order,t.Subject)
+		}
+
+		if _,ok := s.Objects[t.Predicate]; !ok {
+			s.Order = append(s.Order,t.Predicate)
+		}
+
+		s.Objects[t.Predicate] = append(s.Objects[t.Predicate],t)
+	}
+
+	sort.Strings(order) // default graph "" first
+
+	return graphs,order
+}
+
+// **************************************************************************
+
+func TurtleTerm(term string,literal bool) string {
+
+	if literal {
+		return TurtleString(term)
+	}
+
+	if strings.HasPrefix(term,"_:") {
+		return term
+	}
+
+	if term == RDF_TYPE {
+		return "a"
+	}
+
+	for name,ns := range RDF_PREFIXES {
+		if strings.HasPrefix(term,ns) && IsTurtleLocalName(term[len(ns):]) {
+			return name + ":" + term[len(ns):]
+		}
+	}
+
+	return "<" + strings.NewReplacer(">","%3E"," ","%20","\"","%22").Replace(term) + ">"
+}
+
+// **************************************************************************
+
+func IsTurtleLocalName(local string) bool {
+
+	// Keep to the safe subset, otherwise write the full IRI
+
+	if local == "" {
+		return false
+	}
+
+	for i,r := range local {
+		switch {
+		case r == '_' || unicode.IsLetter(r):
+		case unicode.IsDigit(r) || r == '-':
+			if i == 0 && r == '-' {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// **************************************************************************
+
+func TurtleString(s string) string {
+
+	r := strings.NewReplacer("\\","\\\\","\"","\\\"","\n","\\n","\r","\\r","\t","\\t")
+	return "\"" + r.Replace(s) + "\""
+}
+
+// **************************************************************************
+// JSON-LD output
+// **************************************************************************
+
+func WriteJSONLD(triples []RDFTriple) string {
+
+	// Expanded form, so that no @context is needed to read it back
+
+	graphs,order := GroupRDFTriples(triples)
+
+	var top []interface{}
+
+	for _,graph := range order {
+
+		var objects []interface{}
+
+		for _,subject := range graphs[graph].Order {
+
+			obj := map[string]interface{}{"@id": subject}
+			preds := graphs[graph].Subjects[subject]
+
+			for _,pred := range preds.Order {
+
+				var values []interface{}
+
+				for _,t := range preds.Objects[pred] {
+					if t.Literal {
+						values = append(values,map[string]interface{}{"@value": t.Object})
+					} else {
+						values = append(values,map[string]interface{}{"@id": t.Object})
+					}
+				}
+
+				if pred == RDF_TYPE && !HasRDFLiteral(preds.Objects[pred]) {
+					var types []string
+					for _,t := range preds.Objects[pred] {
+						types = append(types,t.Object)
+					}
+					obj["@type"] = types
+				} else {
+					obj[pred] = values
+				}
+			}
+
+			objects = append(objects,obj)
+		}
+
+		if graph == "" {
+			top = append(top,objects...)
+		} else {
+			top = append(top,map[string]interface{}{"@id": graph, "@graph": objects})
+		}
+	}
+
+	data,err := json.MarshalIndent(map[string]interface{}{"@graph": top},"","  ")
+
+	if err != nil {
+		fmt.Println("Unable to marshal JSON-LD",err)
+		return ""
+	}
+
+	return string(data) + "\n"
+}
+
+// **************************************************************************
+
+func HasRDFLiteral(triples []RDFTriple) bool {
+
+	for _,t := range triples {
+		if t.Literal {
+			return true
+		}
+	}
+
+	return false
+}
+
+// **************************************************************************
+// Turtle / TriG input
+// **************************************************************************
+
+type TurtleToken struct {
+
+	Kind  byte     // 'I' iri, 'P' prefixed name, 'B' blank, 'L' literal, 'K' keyword, or punctuation
+	Text  string
+	Line  int
+}
+
+// **************************************************************************
+
+type TurtleParser struct {
+
+	Tokens   []TurtleToken
+	Pos      int
+	Prefixes map[string]string
+	Base     string
+	Graph    string
+	Blank    int
+	Triples  []RDFTriple
+}
+
+// **************************************************************************
+
+func ParseTurtle(text string) ([]RDFTriple,error) {
+
+	// Turtle, N-Triples and TriG (named graph blocks)
+
+	tokens,err := TurtleTokens(text)
+
+	if err != nil {
+		return nil,err
+	}
+
+	var p TurtleParser
+
+	p.Tokens = tokens
+	p.Prefixes = make(map[string]string)
+
+	for p.Pos < len(p.Tokens) {
+
+		err = p.Statement()
+
+		if err != nil {
+			return p.Triples,err
+		}
+	}
+
+	return p.Triples,nil
+}
+
+// **************************************************************************
+
+func (p *TurtleParser) Statement() error {
+
+	tok := p.Tokens[p.Pos]
+
+	if tok.Kind == 'K' {
+
+		switch strings.ToLower(tok.Text) {
+
+		case "@prefix","prefix":
+			if p.Pos+2 >= len(p.Tokens) || p.Tokens[p.Pos+1].Kind != 'P' || p.Tokens[p.Pos+2].Kind != 'I' {
+				return p.Error("bad prefix declaration")
+			}
+			name := strings.TrimSuffix(p.Tokens[p.Pos+1].Text,":")
+			p.Prefixes[name] = p.ResolveIRI(p.Tokens[p.Pos+2].Text)
+			p.Pos += 3
+			if tok.Text == "@prefix" {
+				return p.Expect(".")
+			}
+			return nil
+
+		case "@base","base":
+			if p.Pos+1 >= len(p.Tokens) || p.Tokens[p.Pos+1].Kind != 'I' {
+				return p.Error("bad base declaration")
+			}
+			p.Base = p.ResolveIRI(p.Tokens[p.Pos+1].Text)
+			p.Pos += 2
+			if tok.Text == "@base" {
+				return p.Expect(".")
+			}
+			return nil
+
+		case "graph":
+			p.Pos++
+			name,_,err := p.Term()
+			if err != nil {
+				return err
+			}
+			return p.GraphBlock(name)
+		}
+
+		return p.Error("unexpected "+tok.Text)
+	}
+
+	// A TriG block, either { ... } or <graph> { ... }
+
+	if tok.Kind == '{' {
+		return p.GraphBlock("")
+	}
+
+	if p.Pos+1 < len(p.Tokens) && p.Tokens[p.Pos+1].Kind == '{' && (tok.Kind == 'I' || tok.Kind == 'P' || tok.Kind == 'B') {
+		name,_,err := p.Term()
+		if err != nil {
+			return err
+		}
+		return p.GraphBlock(name)
+	}
+
+	err := p.Triples3()
+
+	if err != nil {
+		return err
+	}
+
+	return p.Expect(".")
+}
+
+// **************************************************************************
+
+func (p *TurtleParser) GraphBlock(name string) error {
+
+	err := p.Expect("{")
+
+	if err != nil {
+		return err
+	}
+
+	outer := p.Graph
+	p.Graph = name
+
+	for p.Pos < len(p.Tokens) && p.Tokens[p.Pos].Kind != '}' {
+
+		err = p.Triples3()
+
+		if err != nil {
+			return err
+		}
+
+		// The final dot in a block is optional
+
+		if p.Pos < len(p.Tokens) && p.Tokens[p.Pos].Kind == '.' {
+			p.Pos++
+		}
+	}
+
+	p.Graph = outer
+
+	return p.Expect("}")
+}
+
+// **************************************************************************
+
+func (p *TurtleParser) Triples3() error {
+
+	if p.Pos < len(p.Tokens) && p.Tokens[p.Pos].Kind == '[' {
+
+		subject,err := p.BlankPropertyList()
+
+		if err != nil {
+			return err
+		}
+
+		// [ ... ] alone is a complete statement
+
+		if p.Pos < len(p.Tokens) && (p.Tokens[p.Pos].Kind == '.' || p.Tokens[p.Pos].Kind == '}') {
+			return nil
+		}
+
+		return p.PredicateObjectList(subject)
+	}
+
+	subject,literal,err := p.Term()
+
+	if err != nil {
+		return err
+	}
+
+	if literal {
+		return p.Error("a literal cannot be a subject")
+	}
+
+	return p.PredicateObjectList(subject)
+}
+
+// **************************************************************************
+
+func (p *TurtleParser) PredicateObjectList(subject string) error {
+
+	for {
+		if p.Pos >= len(p.Tokens) {
+			return p.Error("unexpected end of input")
+		}
+
+		predicate,literal,err := p.Term()
+
+		if err != nil {
+			return err
+		}
+
+		if literal {
+			return p.Error("a literal cannot be a predicate")
+		}
+
+		for {
+			var object string
+			var literal bool
+
+			if p.Pos < len(p.Tokens) && p.Tokens[p.Pos].Kind == '[' {
+				object,err = p.BlankPropertyList()
+			} else {
+				object,literal,err = p.Term()
+			}
+
+			if err != nil {
+				return err
+			}
+
+			p.Triples = append(p.Triples,RDFTriple{Subject: subject, Predicate: predicate, Object: object, Literal: literal, Graph: p.Graph})
+
+			if p.Pos < len(p.Tokens) && p.Tokens[p.Pos].Kind == ',' {
+				p.Pos++
+				continue
+			}
+			break
+		}
+
+		// Any number of semicolons, possibly trailing
+
+		if p.Pos >= len(p.Tokens) || p.Tokens[p.Pos].Kind != ';' {
+			return nil
+		}
+
+		for p.Pos < len(p.Tokens) && p.Tokens[p.Pos].Kind == ';' {
+			p.Pos++
+		}
+
+		if p.Pos >= len(p.Tokens) {
+			return nil
+		}
+
+		switch p.Tokens[p.Pos].Kind {
+		case '.', ']', '}':
+			return nil
+		}
+	}
+}
+
+// **************************************************************************
+
+func (p *TurtleParser) BlankPropertyList() (string,error) {
+
+	err := p.Expect("[")
+
+	if err != nil {
+		return "",err
+	}
+
+	subject := p.NewBlank()
+
+	if p.Pos < len(p.Tokens) && p.Tokens[p.Pos].Kind == ']' {
+		p.Pos++
+		return subject,nil
+	}
+
+	err = p.PredicateObjectList(subject)
+
+	if err != nil {
+		return "",err
+	}
+
+	return subject,p.Expect("]")
+}
+
+// **************************************************************************
+
+func (p *TurtleParser) Term() (string,bool,error) {
+
+	if p.Pos >= len(p.Tokens) {
+		return "",false,p.Error("unexpected end of input")
+	}
+
+	tok := p.Tokens[p.Pos]
+	p.Pos++
+
+	switch tok.Kind {
+	case 'I':
+		return p.ResolveIRI(tok.Text),false,nil
+	case 'B':
+		return tok.Text,false,nil
+	case 'L':
+		return tok.Text,true,nil
+	case 'P':
+		if tok.Text == "a" {
+			return RDF_TYPE,false,nil
+		}
+		if tok.Text == "true" || tok.Text == "false" {
+			return tok.Text,true,nil
+		}
+		colon := strings.Index(tok.Text,":")
+		if colon < 0 {
+			p.Pos--
+			return "",false,p.Error("expected a term, found "+tok.Text)
+		}
+		ns,ok := p.Prefixes[tok.Text[:colon]]
+		if !ok {
+			p.Pos--
+			return "",false,p.Error("undeclared prefix "+tok.Text[:colon])
+		}
+		return ns + TurtleUnescapeLocal(tok.Text[colon+1:]),false,nil
+	case '(':
+		p.Pos--
+		return "",false,p.Error("RDF collections ( ... ) are not supported")
+	}
+
+	p.Pos--
+	return "",false,p.Error("expected a term, found "+tok.Text)
+}
+
+// **************************************************************************
+
+func (p *TurtleParser) ResolveIRI(iri string) string {
+
+	if p.Base == "" || strings.Contains(iri,":") {
+		return iri
+	}
+
+	if strings.HasPrefix(iri,"#") {
+		return strings.SplitN(p.Base,"#",2)[0] + iri
+	}
+
+	return p.Base[:strings.LastIndex(p.Base,"/")+1] + iri
+}
+
+// **************************************************************************
+
+func (p *TurtleParser) NewBlank() string {
+
+	p.Blank++
+	return fmt.Sprintf("_:anon%d",p.Blank)
+}
+
+// **************************************************************************
+
+func (p *TurtleParser) Expect(punct string) error {
+
+	if p.Pos >= len(p.Tokens) || p.Tokens[p.Pos].Text != punct {
+		return p.Error("expected '"+punct+"'")
+	}
+
+	p.Pos++
+	return nil
+}
+
+// **************************************************************************
+
+func (p *TurtleParser) Error(msg string) error {
+
+	line := 0
+
+	if p.Pos < len(p.Tokens) {
+		line = p.Tokens[p.Pos].Line
+	} else if len(p.Tokens) > 0 {
+		line = p.Tokens[len(p.Tokens)-1].Line
+	}
+
+	return fmt.Errorf("turtle line %d: %s",line,msg)
+}
+
+// **************************************************************************
+
+func TurtleTokens(text string) ([]TurtleToken,error) {
+
+	var tokens []TurtleToken
+
+	runes := []rune(text)
+	line := 1
+
+	for i := 0; i < len(runes); {
+
+		r := runes[i]
+
+		switch {
+		case r == '\n':
+			line++
+			i++
+
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '#':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+
+		case r == '<':
+			end := i+1
+			for end < len(runes) && runes[end] != '>' {
+				end++
+			}
+			if end >= len(runes) {
+				return nil,fmt.Errorf("turtle line %d: unterminated IRI",line)
+			}
+			tokens = append(tokens,TurtleToken{Kind: 'I', Text: TurtleUnescapeString(string(runes[i+1:end])), Line: line})
+			i = end+1
+
+		case r == '"' || r == '\'':
+			value,next,lines,err := TurtleReadString(runes,i)
+			if err != nil {
+				return nil,fmt.Errorf("turtle line %d: %s",line,err)
+			}
+			tokens = append(tokens,TurtleToken{Kind: 'L', Text: value, Line: line})
+			line += lines
+			i = TurtleSkipAnnotation(runes,next)
+
+		case strings.ContainsRune(".,;[]{}()",r):
+			// a dot can also start a number like .5, but vocabularies rarely do that
+			tokens = append(tokens,TurtleToken{Kind: byte(r), Text: string(r), Line: line})
+			i++
+
+		case r == '_' && i+1 < len(runes) && runes[i+1] == ':':
+			word,next := TurtleReadWord(runes,i)
+			tokens = append(tokens,TurtleToken{Kind: 'B', Text: word, Line: line})
+			i = next
+
+		case r == '@':
+			word,next := TurtleReadWord(runes,i)
+			tokens = append(tokens,TurtleToken{Kind: 'K', Text: word, Line: line})
+			i = next
+
+		case r == '^':
+			// stray datatype marker, e.g. after a number
+			i = TurtleSkipAnnotation(runes,i)
+
+		default:
+			word,next := TurtleReadWord(runes,i)
+
+			if next == i {
+				return nil,fmt.Errorf("turtle line %d: unexpected character %q",line,r)
+			}
+
+			i = next
+
+			switch {
+			case IsTurtleNumber(word):
+				tokens = append(tokens,TurtleToken{Kind: 'L', Text: word, Line: line})
+				i = TurtleSkipAnnotation(runes,i)
+			case strings.EqualFold(word,"prefix") || strings.EqualFold(word,"base") || strings.EqualFold(word,"graph"):
+				tokens = append(tokens,TurtleToken{Kind: 'K', Text: word, Line: line})
+			default:
+				tokens = append(tokens,TurtleToken{Kind: 'P', Text: word, Line: line})
+			}
+		}
+	}
+
+	return tokens,nil
+}
+
+// **************************************************************************
+
+func TurtleReadWord(runes []rune,i int) (string,int) {
+
+	// Prefixed names, blank node labels, keywords and numbers
+
+	start := i
+
+	for i < len(runes) {
+
+		r := runes[i]
+
+		if r == '\\' && i+1 < len(runes) {
+			i += 2
+			continue
+		}
+
+		if unicode.IsSpace(r) || strings.ContainsRune(",;[]{}()<\"'#^",r) {
+			break
+		}
+
+		// A dot ends the word unless more name follows
+
+		if r == '.' && (i+1 >= len(runes) || unicode.IsSpace(runes[i+1]) || strings.ContainsRune(",;[]{}()<\"'#.",runes[i+1])) {
+			break
+		}
+
+		i++
+	}
+
+	return string(runes[start:i]),i
+}
+
+// **************************************************************************
+
+func IsTurtleNumber(word string) bool {
+
+	_,err := strconv.ParseFloat(word,64)
+	return err == nil
+}
+
+// **************************************************************************
+
+func TurtleReadString(runes []rune,i int) (string,int,int,error) {
+
+	quote := runes[i]
+	long := i+2 < len(runes) && runes[i+1] == quote && runes[i+2] == quote
+
+	var value strings.Builder
+	var lines int
+
+	if long {
+		i += 3
+	} else {
+		i++
+	}
+
+	for i < len(runes) {
+
+		r := runes[i]
+
+		if long && r == quote && i+2 < len(runes) && runes[i+1] == quote && runes[i+2] == quote {
+			return value.String(),i+3,lines,nil
+		}
+
+		if !long && r == quote {
+			return value.String(),i+1,lines,nil
+		}
+
+		if !long && r == '\n' {
+			return "",i,lines,fmt.Errorf("newline in string")
+		}
+
+		if r == '\n' {
+			lines++
+		}
+
+		if r == '\\' && i+1 < len(runes) {
+			s,next := TurtleEscape(runes,i)
+			value.WriteString(s)
+			i = next
+			continue
+		}
+
+		value.WriteRune(r)
+		i++
+	}
+
+	return "",i,lines,fmt.Errorf("unterminated string")
+}
+
+// **************************************************************************
+
+func TurtleEscape(runes []rune,i int) (string,int) {
+
+	switch runes[i+1] {
+	case 't':
+		return "\t",i+2
+	case 'n':
+		return "\n",i+2
+	case 'r':
+		return "\r",i+2
+	case 'b':
+		return "\b",i+2
+	case 'f':
+		return "\f",i+2
+	case 'u','U':
+		size := 4
+		if runes[i+1] == 'U' {
+			size = 8
+		}
+		if i+2+size <= len(runes) {
+			code,err := strconv.ParseUint(string(runes[i+2:i+2+size]),16,32)
+			if err == nil {
+				return string(rune(code)),i+2+size
+			}
+		}
+	}
+
+	return string(runes[i+1]),i+2
+}
+
+// **************************************************************************
+
+func TurtleUnescapeString(s string) string {
+
+	if !strings.Contains(s,"\\") {
+		return s
+	}
+
+	var out strings.Builder
+	runes := []rune(s)
+
+	for i := 0; i < len(runes); {
+		if runes[i] == '\\' && i+1 < len(runes) {
+			e,next := TurtleEscape(runes,i)
+			out.WriteString(e)
+			i = next
+		} else {
+			out.WriteRune(runes[i])
+			i++
+		}
+	}
+
+	return out.String()
+}
+
+// **************************************************************************
+
+func TurtleUnescapeLocal(local string) string {
+
+	// Local names escape punctuation with a backslash, e.g. ex:a\,b
+
+	return strings.NewReplacer("\\","").Replace(local)
+}
+
+// **************************************************************************
+
+func TurtleSkipAnnotation(runes []rune,i int) int {
+
+	// Language tags and datatypes are not kept: @en, ^^xsd:int, ^^<...>
+
+	if i < len(runes) && runes[i] == '@' {
+		i++
+		for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '-') {
+			i++
+		}
+		return i
+	}
+
+	if i+1 < len(runes) && runes[i] == '^' && runes[i+1] == '^' {
+		i += 2
+		if i < len(runes) && runes[i] == '<' {
+			for i < len(runes) && runes[i] != '>' {
+				i++
+			}
+			return i+1
+		}
+		_,next := TurtleReadWord(runes,i)
+		return next
+	}
+
+	return i
+}
+
+// **************************************************************************
+// JSON-LD input
+// **************************************************************************
+
+type JSONLDContext struct {
+
+	Terms  map[string]string
+	IdType map[string]bool    // terms whose string values are IRIs
+	Vocab  string
+	Base   string
+}
+
+// **************************************************************************
+
+func ParseJSONLD(text string) ([]RDFTriple,error) {
+
+	var doc interface{}
+
+	err := json.Unmarshal([]byte(text),&doc)
+
+	if err != nil {
+		return nil,err
+	}
+
+	var triples []RDFTriple
+	var blank int
+
+	ctx := JSONLDContext{Terms: make(map[string]string), IdType: make(map[string]bool)}
+
+	switch top := doc.(type) {
+	case []interface{}:
+		for _,item := range top {
+			JSONLDNode(item,ctx,"",&triples,&blank)
+		}
+	case map[string]interface{}:
+		JSONLDNode(top,ctx,"",&triples,&blank)
+	default:
+		return nil,fmt.Errorf("JSON-LD document must be an object or an array")
+	}
+
+	return triples,nil
+}
+
+// **************************************************************************
+
+func JSONLDNode(item interface{},ctx JSONLDContext,graph string,triples *[]RDFTriple,blank *int) (string,bool) {
+
+	// Returns the subject of a node object, so it can be linked to
+
+	obj,ok := item.(map[string]interface{})
+
+	if !ok {
+		return "",false
+	}
+
+	if c,ok := obj["@context"]; ok {
+		ctx = JSONLDMergeContext(ctx,c)
+	}
+
+	var subject string
+
+	if id,ok := obj["@id"].(string); ok {
+		subject = JSONLDExpand(id,ctx,true)
+	} else {
+		*blank++
+		subject = fmt.Sprintf("_:j%d",*blank)
+	}
+
+	// A graph object holds its own nodes; with an @id it is a named graph
+
+	if members,ok := obj["@graph"]; ok {
+
+		inner := graph
+
+		if _,named := obj["@id"]; named {
+			inner = subject
+		}
+
+		for _,m := range JSONLDList(members) {
+			JSONLDNode(m,ctx,inner,triples,blank)
+		}
+	}
+
+	var keys []string
+
+	for key := range obj {
+		keys = append(keys,key)
+	}
+
+	sort.Strings(keys)
+
+	for _,key := range keys {
+
+		switch key {
+		case "@context","@id","@graph":
+			continue
+		case "@type":
+			for _,t := range JSONLDList(obj[key]) {
+				if s,ok := t.(string); ok {
+					*triples = append(*triples,RDFTriple{Subject: subject, Predicate: RDF_TYPE, Object: JSONLDExpand(s,ctx,true), Graph: graph})
+				}
+			}
+			continue
+		}
+
+		if strings.HasPrefix(key,"@") {
+			continue
+		}
+
+		predicate := JSONLDExpand(key,ctx,false)
+
+		for _,value := range JSONLDList(obj[key]) {
+
+			object,literal,ok := JSONLDValue(value,key,ctx,graph,triples,blank)
+
+			if ok {
+				*triples = append(*triples,RDFTriple{Subject: subject, Predicate: predicate, Object: object, Literal: literal, Graph: graph})
+			}
+		}
+	}
+
+	return subject,true
+}
+
+// **************************************************************************
+
+func JSONLDValue(value interface{},key string,ctx JSONLDContext,graph string,triples *[]RDFTriple,blank *int) (string,bool,bool) {
+
+	switch v := value.(type) {
+
+	case string:
+		if ctx.IdType[key] {
+			return JSONLDExpand(v,ctx,true),false,true
+		}
+		return v,true,true
+
+	case float64:
+		return strconv.FormatFloat(v,'g',-1,64),true,true
+
+	case bool:
+		return strconv.FormatBool(v),true,true
+
+	case map[string]interface{}:
+
+		if lit,ok := v["@value"]; ok {
+			return fmt.Sprint(lit),true,true
+		}
+
+		if _,ok := v["@list"]; ok {
+			fmt.Println("JSON-LD @list values are not supported, skipping",key)
+			return "",false,false
+		}
+
+		// A reference or an embedded node
+
+		if id,ok := v["@id"].(string); ok && len(v) == 1 {
+			return JSONLDExpand(id,ctx,true),false,true
+		}
+
+		subject,ok := JSONLDNode(v,ctx,graph,triples,blank)
+		return subject,false,ok
+	}
+
+	return "",false,false
+}
+
+// **************************************************************************
+
+func JSONLDList(value interface{}) []interface{} {
+
+	if list,ok := value.([]interface{}); ok {
+		return list
+	}
+
+	return []interface{}{value}
+}
+
+// **************************************************************************
+
+func JSONLDMergeContext(ctx JSONLDContext,c interface{}) JSONLDContext {
+
+	// Copy, since contexts are scoped to the object they appear in
+
+	var merged JSONLDContext
+
+	merged.Terms = make(map[string]string)
+	merged.IdType = make(map[string]bool)
+	merged.Vocab = ctx.Vocab
+	merged.Base = ctx.Base
+
+	for k,v := range ctx.Terms {
+		merged.Terms[k] = v
+	}
+
+	for k,v := range ctx.IdType {
+		merged.IdType[k] = v
+	}
+
+	for _,item := range JSONLDList(c) {
+
+		defs,ok := item.(map[string]interface{})
+
+		if !ok {
+			if s,ok := item.(string); ok {
+				fmt.Println("Remote JSON-LD contexts are not fetched, ignoring",s)
+			}
+			continue
+		}
+
+		for term,def := range defs {
+
+			switch d := def.(type) {
+			case string:
+				switch term {
+				case "@vocab":
+					merged.Vocab = d
+				case "@base":
+					merged.Base = d
+				default:
+					merged.Terms[term] = d
+				}
+			case map[string]interface{}:
+				if id,ok := d["@id"].(string); ok {
+					merged.Terms[term] = id
+				}
+				if t,ok := d["@type"].(string); ok && (t == "@id" || t == "@vocab") {
+					merged.IdType[term] = true
+				}
+			}
+		}
+	}
+
+	// Terms may be written with prefixes defined in the same context
+
+	for term,iri := range merged.Terms {
+		merged.Terms[term] = JSONLDExpandPrefix(iri,merged)
+	}
+
+	return merged
+}
+
+// **************************************************************************
+
+func JSONLDExpand(term string,ctx JSONLDContext,is_id bool) string {
+
+	if strings.HasPrefix(term,"_:") {
+		return term
+	}
+
+	if iri,ok := ctx.Terms[term]; ok {
+		return iri
+	}
+
+	expanded := JSONLDExpandPrefix(term,ctx)
+
+	if expanded != term || strings.Contains(term,":") {
+		return expanded
+	}
+
+	if is_id && ctx.Base != "" {
+		return ctx.Base + term
+	}
+
+	if ctx.Vocab != "" {
+		return ctx.Vocab + term
+	}
+
+	return term
+}
+
+// **************************************************************************
+
+func JSONLDExpandPrefix(term string,ctx JSONLDContext) string {
+
+	colon := strings.Index(term,":")
+
+	if colon < 0 || strings.HasPrefix(term[colon:],"://") {
+		return term
+	}
+
+	if ns,ok := ctx.Terms[term[:colon]]; ok {
+		return ns + term[colon+1:]
+	}
+
+	return term
+}
+
+//
+// rdf_parsing.go
+//
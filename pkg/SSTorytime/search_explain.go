@@ -0,0 +1,314 @@
+// **************************************************************************
+//
+// search_explain.go
+//
+// The \explain modifier: how a search string was decoded, which solver
+// handled it, the queries that ran, and how long it all took
+//
+// **************************************************************************
+
+package SSTorytime
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	_ "github.com/lib/pq"
+
+)
+
+// **************************************************************************
+
+const (
+	EXPLAIN_ORBIT = "orbit"
+	EXPLAIN_CONE = "cone"
+	EXPLAIN_PATH = "path"
+	EXPLAIN_PAGEMAP = "pagemap"
+	EXPLAIN_STORIES = "stories"
+	EXPLAIN_ARROWS = "arrows"
+	EXPLAIN_CONTENTS = "contents"
+	EXPLAIN_BOOKMARKS = "bookmarks"
+	EXPLAIN_STATS = "stats"
+	EXPLAIN_OVERVIEW = "overview"
+	EXPLAIN_EXPORT = "export"
+	EXPLAIN_PROVENANCE = "provenance"
+	EXPLAIN_CHANGES = "changes"
+	EXPLAIN_NONE = "none"
+
+	// Path searches ask for links at every step, so keep the first few
+	EXPLAIN_MAX_QUERIES = 50
+)
+
+// **************************************************************************
+
+type SearchExplanation struct {
+
+	Query      string
+	Parameters SearchParameters
+	MinLimit   int
+	MaxLimit   int
+	Handler    string
+
+	NodePtrs   []NodePtr
+	FromPtrs   []NodePtr
+	ToPtrs     []NodePtr
+	ArrowPtrs  []ArrowPtr
+	STtypes    []int
+
+	Queries    []ExplainedQuery
+	Omitted    int              // queries beyond EXPLAIN_MAX_QUERIES
+	Timings    []ExplainedTiming
+
+	started    time.Time
+	lock       sync.Mutex
+}
+
+// **************************************************************************
+
+type ExplainedQuery struct {
+
+	Function string
+	Query    string
+}
+
+// **************************************************************************
+
+type ExplainedTiming struct {
+
+	Step     string
+	Millisec float64
+}
+
+// **************************************************************************
+
+func NewSearchExplanation(query string,search SearchParameters,started time.Time) *SearchExplanation {
+
+	// started is when the search string arrived, before decoding
+
+	var explain SearchExplanation
+
+	explain.Query = query
+	explain.Parameters = search
+	explain.Handler = EXPLAIN_NONE
+	explain.started = started
+
+	return &explain
+}
+
+// **************************************************************************
+
+func WithExplanation(sst PoSST,explain *SearchExplanation) PoSST {
+
+	// A copy of the session that records what it does in explain
+
+	sst.EXPLAIN = explain
+	return sst
+}
+
+// **************************************************************************
+
+func ExplainLimits(sst *PoSST,minlimit,maxlimit int) {
+
+	if sst.EXPLAIN == nil {
+		return
+	}
+
+	sst.EXPLAIN.lock.Lock()
+	sst.EXPLAIN.MinLimit = minlimit
+	sst.EXPLAIN.MaxLimit = maxlimit
+	sst.EXPLAIN.lock.Unlock()
+}
+
+// **************************************************************************
+
+func ExplainArrows(sst *PoSST,arrowptrs []ArrowPtr,sttypes []int) {
+
+	if sst.EXPLAIN == nil {
+		return
+	}
+
+	sst.EXPLAIN.lock.Lock()
+	sst.EXPLAIN.ArrowPtrs = arrowptrs
+	sst.EXPLAIN.STtypes = sttypes
+	sst.EXPLAIN.lock.Unlock()
+}
+
+// **************************************************************************
+
+func ExplainNodePtrs(sst *PoSST,nodeptrs,leftptrs,rightptrs []NodePtr) {
+
+	if sst.EXPLAIN == nil {
+		return
+	}
+
+	sst.EXPLAIN.lock.Lock()
+	sst.EXPLAIN.NodePtrs = nodeptrs
+	sst.EXPLAIN.FromPtrs = leftptrs
+	sst.EXPLAIN.ToPtrs = rightptrs
+	sst.EXPLAIN.lock.Unlock()
+}
+
+// **************************************************************************
+
+func ExplainHandler(sst *PoSST,handler string) {
+
+	if sst.EXPLAIN == nil {
+		return
+	}
+
+	sst.EXPLAIN.lock.Lock()
+	sst.EXPLAIN.Handler = handler
+	sst.EXPLAIN.lock.Unlock()
+}
+
+// **************************************************************************
+
+func ExplainQuery(sst *PoSST,function,qstr string) {
+
+	if sst.EXPLAIN == nil {
+		return
+	}
+
+	sst.EXPLAIN.lock.Lock()
+
+	if len(sst.EXPLAIN.Queries) < EXPLAIN_MAX_QUERIES {
+		sst.EXPLAIN.Queries = append(sst.EXPLAIN.Queries,ExplainedQuery{Function: function, Query: qstr})
+	} else {
+		sst.EXPLAIN.Omitted++
+	}
+
+	sst.EXPLAIN.lock.Unlock()
+}
+
+// **************************************************************************
+
+func ExplainGoCall(sst *PoSST,function string,args ...any) {
+
+	// Backends without stored functions do the same work in Go
+
+	if sst.EXPLAIN == nil {
+		return
+	}
+
+	var list []string
+
+	for _,arg := range args {
+		list = append(list,fmt.Sprintf("%v",arg))
+	}
+
+	ExplainQuery(sst,function,fmt.Sprintf("Go %s(%s)",function,strings.Join(list,",")))
+}
+
+// **************************************************************************
+
+func ExplainTime(sst *PoSST,step string,since time.Time) {
+
+	if sst.EXPLAIN == nil {
+		return
+	}
+
+	ms := float64(time.Since(since).Microseconds()) / 1000.0
+
+	sst.EXPLAIN.lock.Lock()
+	sst.EXPLAIN.Timings = append(sst.EXPLAIN.Timings,ExplainedTiming{Step: step, Millisec: ms})
+	sst.EXPLAIN.lock.Unlock()
+}
+
+// **************************************************************************
+
+func ExplainDone(sst *PoSST) {
+
+	// The total from the arrival of the search string
+
+	if sst.EXPLAIN == nil {
+		return
+	}
+
+	ExplainTime(sst,"total",sst.EXPLAIN.started)
+}
+
+// **************************************************************************
+
+func FormatExplanation(sst *PoSST,explain *SearchExplanation) string {
+
+	var s string
+
+	// Looking up names for the report should not add to it
+
+	quiet := WithExplanation(CopySession(sst),nil)
+	sst = &quiet
+
+	search := explain.Parameters
+
+	s += fmt.Sprintf("\nExplaining the search: %s\n\n",explain.Query)
+
+	s += fmt.Sprintln(" -         start set:",search.Name)
+	s += fmt.Sprintln(" -           finding:",search.Finds)
+	s += fmt.Sprintln(" -              from:",search.From)
+	s += fmt.Sprintln(" -                to:",search.To)
+	s += fmt.Sprintln(" -           chapter:",search.Chapter)
+	s += fmt.Sprintln(" -           context:",search.Context)
+	s += fmt.Sprintln(" -            arrows:",search.Arrows)
+	s += fmt.Sprintln(" -            pagenr:",search.PageNr)
+	s += fmt.Sprintln(" -    sequence/story:",search.Sequence)
+	s += fmt.Sprintln(" -    weighted paths:",search.Weighted)
+	s += fmt.Sprintln(" - limit/range/depth:",explain.MaxLimit)
+	s += fmt.Sprintln(" -  at least/minimum:",explain.MinLimit)
+
+	s += fmt.Sprintln("\n Handled as:",explain.Handler)
+
+	s += FormatExplainedNodes(sst," Start nodes:",explain.NodePtrs)
+	s += FormatExplainedNodes(sst," From nodes:",explain.FromPtrs)
+	s += FormatExplainedNodes(sst," To nodes:",explain.ToPtrs)
+
+	if len(explain.ArrowPtrs) > 0 {
+		s += fmt.Sprintln("\n Arrows:")
+		for _,arr := range explain.ArrowPtrs {
+			a := GetDBArrowByPtr(sst,arr)
+			s += fmt.Sprintf("   %d = %s (%s)\n",arr,a.Long,a.Short)
+		}
+		s += fmt.Sprintln("   ST types:",explain.STtypes)
+	}
+
+	if len(explain.Queries) > 0 {
+		s += fmt.Sprintln("\n Queries:")
+		for _,q := range explain.Queries {
+			s += fmt.Sprintf("   %s: %s\n",q.Function,q.Query)
+		}
+		if explain.Omitted > 0 {
+			s += fmt.Sprintf("   .. and %d more\n",explain.Omitted)
+		}
+	}
+
+	if len(explain.Timings) > 0 {
+		s += fmt.Sprintln("\n Timings:")
+		for _,t := range explain.Timings {
+			s += fmt.Sprintf("   %-10s %8.3f ms\n",t.Step,t.Millisec)
+		}
+	}
+
+	return s
+}
+
+// **************************************************************************
+
+func FormatExplainedNodes(sst *PoSST,title string,nptrs []NodePtr) string {
+
+	if len(nptrs) == 0 {
+		return ""
+	}
+
+	s := fmt.Sprintln("\n" + title)
+
+	for _,nptr := range nptrs {
+		node := GetDBNodeByNodePtr(sst,nptr)
+		s += fmt.Sprintf("   %v \"%s\" in chapter: %s\n",nptr,node.S,node.Chap)
+	}
+
+	return s
+}
+
+//
+// search_explain.go
+//
@@ -7,6 +7,7 @@
 package SSTorytime
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"regexp"
@@ -34,6 +35,16 @@ type SearchParameters struct {
 	Stats     bool
 	Bookmarks bool
 	Horizon   int
+	Export    string
+	Weighted  int     // k least total weight paths, or 0 for the wave front search
+	Explain   bool    // report how the search was decoded and solved
+	Provenance bool   // where the nodes found and their links came from
+	AsOf      string  // search the graph as it was at a revision or date
+	Changes   string  // list what changed after a revision or date
+
+	Warnings  []string // what was ignored or taken as a search term, see ParseSearchField()
+
+	problems  []error // malformed expressions, which leave no sensible search
 }
 
 // ******************************************************************
@@ -77,6 +88,7 @@ const (
 	CMD_SECTION = "\\section"
 	CMD_IN = "\\in"
 	CMD_IN_2 = "in"
+	CMD_NOTIN = "\\notin"
 	CMD_ARROW = "\\arrow"
 	CMD_ARROWS = "\\arrows"
 	CMD_LIMIT = "\\limit"
@@ -101,6 +113,20 @@ const (
 	CMD_ATMOST = "\\atmost"
 	CMD_NEVER = "\\never"
 	CMD_NEW = "\\new"
+	// graph export formats, see graph_export.go
+	CMD_EXPORT = "\\export"
+	// path ranking by Link.Wgt, see path_weighted_search.go
+	CMD_WEIGHT = "\\weight"
+	// decoding and query report, see search_explain.go
+	CMD_EXPLAIN = "\\explain"
+	// source files and authors, see provenance.go
+	CMD_PROVENANCE = "\\provenance"
+	// history, see changelog.go
+	CMD_ASOF = "\\asof"
+	CMD_CHANGES = "\\changes"
+	CMD_SINCE = "since" // optional, after \\changes
+
+	WEIGHTED_PATHS = 3 // alternatives when no number is given
 
 	RECENT = 4  // Four hours between a morning and afternoon
         NEVER = -1   // Haven't seen in this long
@@ -112,12 +138,31 @@ const (
 
 func DecodeSearchField(cmd string) SearchParameters {
 
+	// Makes the best of a malformed query, use ParseSearchField() to reject it
+
+	param,err := ParseSearchField(cmd)
+
+	if err != nil {
+		fmt.Println("WARNING:",err)
+	}
+
+	for _,warning := range param.Warnings {
+		fmt.Println("WARNING:",warning)
+	}
+
+	return param
+}
+
+//******************************************************************
+
+func ParseSearchField(cmd string) (SearchParameters,error) {
+
 	var keywords = []string{ 
 		CMD_NOTES, CMD_BROWSE,
 		CMD_PATH,CMD_FROM,CMD_TO,CMD_TO_2,
 		CMD_SEQ1,CMD_SEQ2,CMD_STORY,CMD_STORIES,
 		CMD_CONTEXT,CMD_CTX,CMD_AS,CMD_AS_2,
-		CMD_CHAPTER,CMD_IN,CMD_IN_2,CMD_SECTION,CMD_CONTENTS,CMD_TOC,CMD_TOC_2,CMD_MAP,
+		CMD_CHAPTER,CMD_IN,CMD_IN_2,CMD_SECTION,CMD_CONTENTS,CMD_TOC,CMD_TOC_2,CMD_MAP,CMD_NOTIN,
 		CMD_ARROW,CMD_ARROWS,
 		CMD_GT,CMD_MIN,CMD_ATLEAST,
 		CMD_LT,CMD_MAX,CMD_ATMOST,
@@ -129,10 +174,14 @@ func DecodeSearchField(cmd string) SearchParameters {
 		CMD_HELP,CMD_HELP_2,
 		CMD_FINDS,CMD_ABOUT,
 		CMD_BOOKMARKS,
+		CMD_EXPORT,CMD_WEIGHT,CMD_EXPLAIN,CMD_PROVENANCE,
+		CMD_ASOF,CMD_CHANGES,
         }
 	
-	// parentheses are reserved for unaccenting
+	// parentheses are reserved for unaccenting, or grouping in
+	// boolean expressions, whose AND OR NOT must survive lowercasing
 
+	cmd = MarkQueryOperators(cmd)
 	cmd = strings.ToLower(cmd)
 
 	m := regexp.MustCompile("[ \t]+") 
@@ -189,7 +238,7 @@ func DecodeSearchField(cmd string) SearchParameters {
 		}
 	}
 
-	return param
+	return param,errors.Join(param.problems...)
 }
 
 //******************************************************************
@@ -197,6 +246,7 @@ func DecodeSearchField(cmd string) SearchParameters {
 func FillInParameters(cmd_parts [][]string,keywords []string) SearchParameters {
 
 	var param SearchParameters 
+	var notin []string
 
 	for c := 0; c < len(cmd_parts); c++ {
 
@@ -220,6 +270,32 @@ func FillInParameters(cmd_parts [][]string,keywords []string) SearchParameters {
 
 			case CMD_CHAPTER,CMD_SECTION,CMD_IN,CMD_IN_2,CMD_CONTENTS,CMD_TOC,CMD_TOC_2,CMD_MAP:
 
+				if end := NextCommand(p+1,lenp,cmd_parts[c],keywords); IsQueryExpression(cmd_parts[c][p+1:end]) {
+
+					// One chapter, and any number of them to leave out
+
+					var include,exclude []string
+					param,include,exclude = QueryListField(param,cmd_parts,c,p+1,end)
+
+					if len(include) > 1 {
+						param.problems = append(param.problems,fmt.Errorf("%w: %s takes one chapter, not %s",
+							ERR_MALFORMED_QUERY,cmd_parts[c][p],strings.Join(include," OR ")))
+					}
+
+					param.Chapter = ""
+
+					if len(include) > 0 {
+						param.Chapter = include[0]
+					}
+
+					for _,ex := range exclude {
+						param.Chapter = AddChapterExclusion(param.Chapter,ex)
+					}
+
+					p = end-1
+					continue
+				}
+
 				if lenp > p+1 {
 					str := cmd_parts[c][p+1]
 					str = strings.TrimSpace(str)
@@ -236,6 +312,22 @@ func FillInParameters(cmd_parts [][]string,keywords []string) SearchParameters {
 				}
 				continue
 
+			case CMD_NOTIN:
+				// chapters to exclude, added after any \\chapter
+				if lenp > p+1 {
+					p++
+					ult := strings.Split(cmd_parts[c][p],",")
+					for u := range ult {
+						str := strings.TrimSpace(DeQ(ult[u]))
+						if len(str) > 0 {
+							notin = append(notin,str)
+						}
+					}
+				} else {
+					param = MissingParameter(param,cmd_parts[c][p],"a chapter")
+				}
+				continue
+
 			case CMD_NOTES, CMD_BROWSE:
 				if param.PageNr < 1 {
 					param.PageNr = 1
@@ -263,11 +355,10 @@ func FillInParameters(cmd_parts [][]string,keywords []string) SearchParameters {
 					if no > 0 {
 						param.PageNr = no
 					} else {
-						param = AddOrphan(param,cmd_parts[c][p-1])
-						param = AddOrphan(param,cmd_parts[c][p])
+						param = WrongParameter(param,cmd_parts[c][p-1],cmd_parts[c][p],"a page number")
 					}
 				} else {
-					param = AddOrphan(param,cmd_parts[c][p])
+					param = MissingParameter(param,cmd_parts[c][p],"a page number")
 				}
 				continue
 
@@ -280,11 +371,10 @@ func FillInParameters(cmd_parts [][]string,keywords []string) SearchParameters {
 					if no > 0 {
 						param.Range = no
 					} else {
-						param = AddOrphan(param,cmd_parts[c][p-1])
-						param = AddOrphan(param,cmd_parts[c][p])
+						param = WrongParameter(param,cmd_parts[c][p-1],cmd_parts[c][p],"a number")
 					}
 				} else {
-					param = AddOrphan(param,cmd_parts[c][p])
+					param = MissingParameter(param,cmd_parts[c][p],"a number")
 				}
 				continue
 
@@ -297,11 +387,10 @@ func FillInParameters(cmd_parts [][]string,keywords []string) SearchParameters {
 					if no > 0 {
 						param.Min = append(param.Min,no)
 					} else {
-						param = AddOrphan(param,cmd_parts[c][p-1])
-						param = AddOrphan(param,cmd_parts[c][p])
+						param = WrongParameter(param,cmd_parts[c][p-1],cmd_parts[c][p],"a number")
 					}
 				} else {
-					param = AddOrphan(param,cmd_parts[c][p])
+					param = MissingParameter(param,cmd_parts[c][p],"a number")
 				}
 				continue
 
@@ -314,15 +403,28 @@ func FillInParameters(cmd_parts [][]string,keywords []string) SearchParameters {
 					if no > 0 {
 						param.Max = append(param.Max,no)
 					} else {
-						param = AddOrphan(param,cmd_parts[c][p-1])
-						param = AddOrphan(param,cmd_parts[c][p])
+						param = WrongParameter(param,cmd_parts[c][p-1],cmd_parts[c][p],"a number")
 					}
 				} else {
-					param = AddOrphan(param,cmd_parts[c][p])
+					param = MissingParameter(param,cmd_parts[c][p],"a number")
 				}
 				continue
 
 			case CMD_ARROW,CMD_ARROWS:
+				if end := NextCommand(p+1,lenp,cmd_parts[c],keywords); IsQueryExpression(cmd_parts[c][p+1:end]) {
+
+					var include,exclude []string
+					param,include,exclude = QueryListField(param,cmd_parts,c,p+1,end)
+					param.Arrows = append(param.Arrows,include...)
+
+					for _,ex := range exclude {
+						param.Arrows = append(param.Arrows,QUERY_NOT_OP+ex)
+					}
+
+					p = end-1
+					continue
+				}
+
 				if lenp > p+1 {
 					for pp := p+1; IsParam(pp,lenp,cmd_parts[c],keywords); pp++ {
 						p++
@@ -332,11 +434,19 @@ func FillInParameters(cmd_parts [][]string,keywords []string) SearchParameters {
 						}
 					}
 				} else {
-					param = AddOrphan(param,cmd_parts[c][p])
+					param = MissingParameter(param,cmd_parts[c][p],"an arrow name")
 				}
 				continue
 				
 				case CMD_CONTEXT,CMD_CTX,CMD_AS,CMD_AS_2:
+				if end := NextCommand(p+1,lenp,cmd_parts[c],keywords); IsQueryExpression(cmd_parts[c][p+1:end]) {
+					var alts []string
+					param,alts = QueryField(param,cmd_parts,c,p+1,end)
+					param.Context = append(param.Context,alts...)
+					p = end-1
+					continue
+				}
+
 				if lenp > p+1 {
 					for pp := p+1; IsParam(pp,lenp,cmd_parts[c],keywords); pp++ {
 						p++
@@ -349,7 +459,7 @@ func FillInParameters(cmd_parts [][]string,keywords []string) SearchParameters {
 						}
 					}
 				} else {
-					param = AddOrphan(param,cmd_parts[c][p])
+					param = MissingParameter(param,cmd_parts[c][p],"a context")
 				}
 				continue
 
@@ -359,6 +469,14 @@ func FillInParameters(cmd_parts [][]string,keywords []string) SearchParameters {
 					continue
 				}
 
+				if end := NextCommand(p+1,lenp,cmd_parts[c],keywords); IsQueryExpression(cmd_parts[c][p+1:end]) {
+					var alts []string
+					param,alts = QueryField(param,cmd_parts,c,p+1,end)
+					param.From = append(param.From,alts...)
+					p = end-1
+					continue
+				}
+
 				if lenp > p+1 {
 					for pp := p+1; IsParam(pp,lenp,cmd_parts[c],keywords); pp++ {
 						p++
@@ -372,11 +490,22 @@ func FillInParameters(cmd_parts [][]string,keywords []string) SearchParameters {
 						}
 					}
 				} else {
-					param = AddOrphan(param,cmd_parts[c][p])
+					param = MissingParameter(param,cmd_parts[c][p],"a starting point")
 				}
 				continue
 
 			case CMD_TO,CMD_TO_2:
+				if end := NextCommand(p+1,lenp,cmd_parts[c],keywords); IsQueryExpression(cmd_parts[c][p+1:end]) {
+					if p > 0 && param.From == nil {
+						param.From = append(param.From,cmd_parts[c][p-1])
+					}
+					var alts []string
+					param,alts = QueryField(param,cmd_parts,c,p+1,end)
+					param.To = append(param.To,alts...)
+					p = end-1
+					continue
+				}
+
 				if p > 0 && lenp > p+1 {
 					if param.From == nil {
 						param.From = append(param.From,cmd_parts[c][p-1])
@@ -415,11 +544,81 @@ func FillInParameters(cmd_parts [][]string,keywords []string) SearchParameters {
 			case CMD_NEW:
 				param.Horizon = RECENT
 				continue
+
+			case CMD_EXPORT:
+				// if followed by a format, else default
+				if lenp > p+1 && IsGraphFormat(cmd_parts[c][p+1]) {
+					p++
+					param.Export = cmd_parts[c][p]
+				} else {
+					param.Export = GRAPH_FORMAT_GRAPHML
+				}
+				continue
+			case CMD_WEIGHT:
+				// if followed by the number of paths, else default
+				param.Weighted = WEIGHTED_PATHS
+				if lenp > p+1 {
+					var no int = -1
+					fmt.Sscanf(cmd_parts[c][p+1],"%d",&no)
+					if no > 0 {
+						p++
+						param.Weighted = no
+					}
+				}
+				continue
+
 			case CMD_NEVER:
 				param.Horizon = NEVER
 				continue
 
+			case CMD_EXPLAIN:
+				param.Explain = true
+				continue
+
+			case CMD_PROVENANCE:
+				param.Provenance = true
+				continue
+
+			case CMD_ASOF:
+				// a revision number or a date
+				if lenp > p+1 {
+					p++
+					if IsRevision(cmd_parts[c][p]) {
+						param.AsOf = cmd_parts[c][p]
+					} else {
+						param = WrongParameter(param,cmd_parts[c][p-1],cmd_parts[c][p],"a revision number or a date")
+					}
+				} else {
+					param = MissingParameter(param,cmd_parts[c][p],"a revision number or a date")
+				}
+				continue
+
+			case CMD_CHANGES:
+				// \\changes [since] revision|date
+				if lenp > p+1 && cmd_parts[c][p+1] == CMD_SINCE {
+					p++
+				}
+				if lenp > p+1 {
+					p++
+					if IsRevision(cmd_parts[c][p]) {
+						param.Changes = cmd_parts[c][p]
+					} else {
+						param = WrongParameter(param,CMD_CHANGES,cmd_parts[c][p],"a revision number or a date")
+					}
+				} else {
+					param = MissingParameter(param,CMD_CHANGES,"a revision number or a date")
+				}
+				continue
+
 			case CMD_ON,CMD_ON_2,CMD_FOR,CMD_FOR_2:
+				if end := NextCommand(p+1,lenp,cmd_parts[c],keywords); param.PageNr == 0 && IsQueryExpression(cmd_parts[c][p+1:end]) {
+					var alts []string
+					param,alts = QueryField(param,cmd_parts,c,p+1,end)
+					param.Name = append(param.Name,alts...)
+					p = end-1
+					continue
+				}
+
 				if lenp > p+1 {
 					for pp := p+1; IsParam(pp,lenp,cmd_parts[c],keywords); pp++ {
 						p++
@@ -433,7 +632,7 @@ func FillInParameters(cmd_parts [][]string,keywords []string) SearchParameters {
 						}
 					}
 				} else {
-					param = AddOrphan(param,cmd_parts[c][p])
+					param = MissingParameter(param,cmd_parts[c][p],"a search term")
 				}
 				continue
 
@@ -457,6 +656,22 @@ func FillInParameters(cmd_parts [][]string,keywords []string) SearchParameters {
 					continue
 				}
 
+				end := NextCommand(p,lenp,cmd_parts[c],keywords)
+
+				for _,term := range cmd_parts[c][p:end] {
+					if strings.HasPrefix(term,"\\") {
+						param.Warnings = append(param.Warnings,fmt.Sprintf("unknown command %s, searching for it instead",term))
+					}
+				}
+
+				if IsQueryExpression(cmd_parts[c][p:end]) {
+					var alts []string
+					param,alts = QueryField(param,cmd_parts,c,p,end)
+					param.Name = append(param.Name,alts...)
+					p = end-1
+					continue
+				}
+
 				for pp := p; IsParam(pp,lenp,cmd_parts[c],keywords); pp++ {
 					p++
 					ult := SplitQuotes(cmd_parts[c][pp])
@@ -490,6 +705,10 @@ func FillInParameters(cmd_parts [][]string,keywords []string) SearchParameters {
 		param.Name = rnames
 	}
 
+	for _,chap := range notin {
+		param.Chapter = AddChapterExclusion(param.Chapter,chap)
+	}
+
 	return param
 }
 
@@ -516,6 +735,59 @@ func IsParam(i,lenp int,keys []string,keywords []string) bool {
 
 //******************************************************************
 
+func NextCommand(i,lenp int,keys []string,keywords []string) int {
+
+	// The end of the parameters that start at i
+
+	for i < lenp && IsParam(i,lenp,keys,keywords) {
+		i++
+	}
+
+	return i
+}
+
+//******************************************************************
+
+func QueryField(param SearchParameters,cmd_parts [][]string,c,start,end int) (SearchParameters,[]string) {
+
+	// A boolean expression for names or contexts, see query_expression.go.
+	// It may end with AND only if another command follows
+
+	joined := end < len(cmd_parts[c]) || c < len(cmd_parts)-1
+	alts,err := ExpandQueryTerms(cmd_parts[c][start:end],joined)
+
+	if err != nil {
+		param.problems = append(param.problems,err)
+	}
+
+	return param,alts
+}
+
+//******************************************************************
+
+func QueryListField(param SearchParameters,cmd_parts [][]string,c,start,end int) (SearchParameters,[]string,[]string) {
+
+	// As QueryField(), for arrows and chapters, which are only a list
+	// of alternatives and the names to leave out of them
+
+	param,alts := QueryField(param,cmd_parts,c,start,end)
+
+	if alts == nil {
+		return param,nil,nil
+	}
+
+	include,exclude,ok := QueryTermList(alts)
+
+	if !ok {
+		param.problems = append(param.problems,fmt.Errorf("%w: %s only takes alternatives and NOT, not \"%s\"",
+			ERR_MALFORMED_QUERY,cmd_parts[c][start-1],strings.Join(cmd_parts[c][start:end]," ")))
+	}
+
+	return param,include,exclude
+}
+
+//******************************************************************
+
 func MinMaxPolicy(search SearchParameters) (int,int) {
 
 	// The min max doubles as context dependent role as
@@ -772,6 +1044,32 @@ func AddOrphan(param SearchParameters,orphan string) SearchParameters {
 
 //******************************************************************
 
+func MissingParameter(param SearchParameters,cmd,expected string) SearchParameters {
+
+	// A short word like "on" may well be a search term, but a \command
+	// without its parameter is a mistake the user should hear about
+
+	if strings.HasPrefix(cmd,"\\") {
+		param.Warnings = append(param.Warnings,fmt.Sprintf("%s expects %s",cmd,expected))
+	}
+
+	return AddOrphan(param,cmd)
+}
+
+//******************************************************************
+
+func WrongParameter(param SearchParameters,cmd,arg,expected string) SearchParameters {
+
+	if strings.HasPrefix(cmd,"\\") {
+		param.Warnings = append(param.Warnings,fmt.Sprintf("%s expects %s, not \"%s\"",cmd,expected,arg))
+	}
+
+	param = AddOrphan(param,cmd)
+	return AddOrphan(param,arg)
+}
+
+//******************************************************************
+
 func SplitQuotes(s string) []string {
 
 	var items []string
@@ -791,12 +1089,14 @@ func SplitQuotes(s string) []string {
 				items = append(items,string(upto))
 			}
 
-			qstr,offset := ReadToNext(cmd,r,cmd[r])
+			qstr,_ := ReadToNext(cmd,r,cmd[r])
 
 			if len(qstr) > 0 {
 				items = append(items,qstr)
-				r += offset
+				r += len([]rune(qstr))-1
+				r = SkipSeparator(cmd,r)
 			}
+			upto = nil
 			continue
 		}
 
@@ -813,12 +1113,14 @@ func SplitQuotes(s string) []string {
 				items = append(items,string(upto))
 			}
 
-			qstr,offset := ReadToNext(cmd,r,')')
+			qstr := ReadBracketed(cmd,r)
 
 			if len(qstr) > 0 {
 				items = append(items,qstr)
-				r += offset
+				r += len([]rune(qstr))-1
+				r = SkipSeparator(cmd,r)
 			}
+			upto = nil
 			continue
 
 		}
@@ -835,6 +1137,45 @@ func SplitQuotes(s string) []string {
 
 // **************************************************************************
 
+func SkipSeparator(array []rune,pos int) int {
+
+	// A comma after a quote or bracket only separates items, but an
+	// operator must be kept for a boolean expression
+
+	if pos+1 < len(array) && array[pos+1] == ',' {
+		return pos+1
+	}
+
+	return pos
+}
+
+// **************************************************************************
+
+func ReadBracketed(array []rune,pos int) string {
+
+	// Up to the matching bracket, as groups may be nested in expressions
+
+	var depth int
+
+	for i := pos; i < len(array); i++ {
+
+		switch array[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+
+		if depth == 0 {
+			return string(array[pos:i+1])
+		}
+	}
+
+	return string(array[pos:])
+}
+
+// **************************************************************************
+
 func IsApostrophe(s []rune, pos int) bool {
 
 	if s[pos] != '\'' {
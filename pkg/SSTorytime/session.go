@@ -2,10 +2,13 @@
 package SSTorytime
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"os"
 	"io/ioutil"
+	"strings"
+	"sync"
 	_ "github.com/lib/pq"
 
 )
@@ -19,9 +22,41 @@ import (
 
 func Open(load_arrows bool) PoSST {
 
+	sst,err := OpenErr(context.Background(),load_arrows)
+	ExitOnError(err)
+
+	return sst
+}
+
+// **************************************************************************
+
+func OpenErr(ctx context.Context,load_arrows bool) (PoSST,error) {
+
+	// As Open, but return connection and setup errors instead of exiting,
+	// for long running services. Opening is abandoned if ctx is done
+
 	var sst PoSST
-	var err error
-	
+	err := OpenSessionErr(ctx,&sst,load_arrows)
+
+	return sst,err
+}
+
+// **************************************************************************
+
+func OpenSessionErr(ctx context.Context,sst *PoSST,load_arrows bool) error {
+
+	// As OpenErr, but into a session whose settings, like WIPE,
+	// have been made already
+
+	// Another backend can be chosen by URI, e.g.
+	// export SST_STORE_URI=sqlite:///home/me/sstoryline.db
+
+	uri := os.Getenv("SST_STORE_URI")
+
+	if len(uri) > 0 {
+		return OpenURISessionErr(ctx,sst,uri,load_arrows)
+	}
+
 	// Replace credentials with a private file
 
 	var (
@@ -40,52 +75,203 @@ func Open(load_arrows bool) PoSST {
 
 	env := os.Getenv("POSTGRESQL_URI")
 
-	if len(env) == 0 {
-		sst.DB, err = sql.Open("postgres", connect_str)
-	} else {
-		sst.DB, err = sql.Open("postgres",env)
+	if len(env) > 0 {
+		connect_str = env
+	}
+
+	return OpenPostgresSessionErr(ctx,sst,connect_str,load_arrows)
+}
+
+// **************************************************************************
+
+func OpenURI(uri string,load_arrows bool) PoSST {
+
+	sst,err := OpenURIErr(context.Background(),uri,load_arrows)
+	ExitOnError(err)
+
+	return sst
+}
+
+// **************************************************************************
+
+func OpenURIErr(ctx context.Context,uri string,load_arrows bool) (PoSST,error) {
+
+	// postgres://..., sqlite:///path/file.db, sqlite:file.db or memory:
+
+	var sst PoSST
+	err := OpenURISessionErr(ctx,&sst,uri,load_arrows)
+
+	return sst,err
+}
+
+// **************************************************************************
+
+func OpenURISessionErr(ctx context.Context,sst *PoSST,uri string,load_arrows bool) error {
+
+	switch {
+
+	case strings.HasPrefix(uri,"postgres://") || strings.HasPrefix(uri,"postgresql://"):
+		return OpenPostgresSessionErr(ctx,sst,uri,load_arrows)
+
+	case strings.HasPrefix(uri,"sqlite:"):
+		path := strings.TrimPrefix(uri,"sqlite:")
+		path = strings.TrimPrefix(path,"//")
+
+		store,err := NewSQLiteStoreErr(ctx,path)
+
+		if err != nil {
+			return err
+		}
+
+		err = OpenStoreSessionErr(ctx,sst,store,load_arrows)
+
+		if err != nil {
+			store.DB.Close()
+		}
+
+		return err
+
+	case strings.HasPrefix(uri,"memory:"):
+		return OpenStoreSessionErr(ctx,sst,NewMemoryStore(),load_arrows)
 	}
 
+	return fmt.Errorf("%w: %s",ERR_UNKNOWN_STORE,uri)
+}
+
+// **************************************************************************
+
+func OpenPostgres(connect_str string,load_arrows bool) PoSST {
+
+	sst,err := OpenPostgresErr(context.Background(),connect_str,load_arrows)
+	ExitOnError(err)
+
+	return sst
+}
+
+// **************************************************************************
+
+func OpenPostgresErr(ctx context.Context,connect_str string,load_arrows bool) (PoSST,error) {
+
+	var sst PoSST
+	err := OpenPostgresSessionErr(ctx,&sst,connect_str,load_arrows)
+
+	return sst,err
+}
+
+// **************************************************************************
+
+func OpenPostgresSessionErr(ctx context.Context,sst *PoSST,connect_str string,load_arrows bool) error {
+
+	var err error
+
+	sst.DB, err = sql.Open("postgres",connect_str)
+
 	if err != nil {
-		fmt.Println("Error connecting to the database: ", err)
-		os.Exit(-1)
+		return fmt.Errorf("%w: %v",ERR_DB_CONNECT,err)
 	}
 
 	// Basic test
 	
-	err = sst.DB.Ping()
+	err = sst.DB.PingContext(ctx)
 	
 	if err != nil {
-		fmt.Println("Error pinging the database: ", err)
-		os.Exit(-1)
+		sst.DB.Close()
+		return fmt.Errorf("%w (ping): %v",ERR_DB_CONNECT,err)
+	}
+
+	sst.STORE = PostgresStore{}
+	err = InitSessionWith(ctx,sst,load_arrows)
+
+	if err != nil {
+		sst.DB.Close()
 	}
 
-	MemoryInit(&sst)
-	Configure(sst,load_arrows)
+	return err
+}
+
+// **************************************************************************
 
-	DownloadArrowsFromDB(&sst)
-	DownloadContextsFromDB(&sst)
-	SynchronizeNPtrs(&sst)
+func InitSession(sst *PoSST,load_arrows bool) error {
+
+	return InitSessionWith(DBContext(sst),sst,load_arrows)
+}
+
+// **************************************************************************
+
+func InitSessionWith(ctx context.Context,sst *PoSST,load_arrows bool) error {
+
+	// Common to all storage backends, once sst.STORE is set. Only the
+	// setup is bounded by ctx, not the session it opens
+
+	MemoryInit(sst)
+
+	if err := sst.STORE.Configure(ctx,sst,load_arrows); err != nil {
+		return err
+	}
+
+	if err := DownloadArrowsFromDBWith(ctx,sst); err != nil {
+		return err
+	}
+
+	if err := DownloadContextsFromDBWith(ctx,sst); err != nil {
+		return err
+	}
+
+	SynchronizeNPtrsWith(ctx,sst)
 	
 	NO_NODE_PTR.Class = 0
 	NO_NODE_PTR.CPtr =  -1
 	NONODE.Class = 0
 	NONODE.CPtr = 0
 
+	return nil
+}
+
+// **************************************************************************
+
+func WithContext(sst PoSST,ctx context.Context) PoSST {
+
+	// A copy of the session whose database queries are abandoned when ctx
+	// is cancelled or its deadline passes, e.g. when a web client goes away.
+	// The copy shares the directories, so use it for searching, not uploading.
+	// This is for the functions that have no ctx parameter: the ...Err()
+	// entry points take ctx explicitly and pass it down to the store
+
+	sst.CTX = ctx
 	return sst
 }
 
 // **************************************************************************
 
+func DBContext(sst *PoSST) context.Context {
+
+	if sst.CTX == nil {
+		return context.Background()
+	}
+
+	return sst.CTX
+}
+
+// **************************************************************************
+
+func Cancelled(sst *PoSST) bool {
+
+	// For long searches that loop in Go rather than in a single query
+
+	return sst.CTX != nil && sst.CTX.Err() != nil
+}
+
+// **************************************************************************
+
 func OverrideCredentials(u,p,d string) (string,string,string) {
 
 	// Store database/postgres credentials in a system file instead of hardcoding
 
 	dirname, err := os.UserHomeDir()
 
-	if err != nil && len(dirname) > 1 {
-		fmt.Println("Unable to determine user's home directory")
-		os.Exit(-1)
+	if err != nil {
+		fmt.Println("Unable to determine user's home directory, using default credentials")
+		return u,p,d
 	}
 
 	filename := dirname+"/"+CREDENTIALS_FILE
@@ -167,6 +353,14 @@ func MemoryInit(sst *PoSST) {
 
 //  When opening a connection, restore config and allocate maps
 
+	if sst.DIRLOCK == nil {
+		sst.DIRLOCK = new(sync.RWMutex)
+	}
+
+	if sst.NODE_DIRECTORY == nil {
+		sst.NODE_DIRECTORY = NewNodeDirectory()
+	}
+
         if sst.NODE_DIRECTORY.N1grams == nil {
 		sst.NODE_DIRECTORY.N1grams = make(map[string]ClassedNodePtr)
 	}
@@ -188,136 +382,189 @@ func MemoryInit(sst *PoSST) {
 	sst.ARROW_SHORT_DIR = make(map[string]ArrowPtr)
 	sst.ARROW_LONG_DIR = make(map[string]ArrowPtr)
 	sst.ARROW_DIRECTORY_TOP = 0
-	sst.CONTEXT_DIR = make(map[string]ContextPtr)
+	sst.CONTEXTS = NewContextTable()
+	SyncContextFields(sst)
+
+	// The deprecated global is still the default
+
+	if WIPE_DB {
+		sst.WIPE = true
+	}
+}
+
+// **************************************************************************
+
+func Configure(sst PoSST,load_arrows bool) error {
+
+	return sst.STORE.Configure(DBContext(&sst),&sst,load_arrows)
 }
 
 // **************************************************************************
 
-func Configure(sst PoSST,load_arrows bool) {
+func (pg PostgresStore) Configure(ctx context.Context,sst *PoSST,load_arrows bool) error {
 
 	// Tmp reset
 
-	if WIPE_DB {
+	if sst.WIPE {
 
 		fmt.Println("***********************")
 		fmt.Println("* WIPING DB")
 		fmt.Println("***********************")
 		
-		sst.DB.QueryRow("DROP INDEX sst_nan")
-		sst.DB.QueryRow("DROP INDEX sst_type")
-		sst.DB.QueryRow("DROP INDEX sst_gin")
-		sst.DB.QueryRow("DROP INDEX sst_ungin")
-		sst.DB.QueryRow("DROP INDEX sst_s")
-		sst.DB.QueryRow("DROP INDEX sst_n")
-		sst.DB.QueryRow("DROP INDEX sst_cnt")
-
-		sst.DB.QueryRow("drop function fwdconeaslinks")
-		sst.DB.QueryRow("drop function fwdconeasnodes")
-		sst.DB.QueryRow("drop function fwdpathsaslinks")
-		sst.DB.QueryRow("drop function getfwdlinks")
-		sst.DB.QueryRow("drop function getfwdnodes")
-		sst.DB.QueryRow("drop function getneighboursbytype")
-		sst.DB.QueryRow("drop function getsingletonaslink")
-		sst.DB.QueryRow("drop function AllNCPathsAsLinks")
-		sst.DB.QueryRow("drop function AllSuperNCPathsAsLinks")
-		sst.DB.QueryRow("drop function SumAllNCPaths")
-		sst.DB.QueryRow("drop function GetNCFwdLinks")
-		sst.DB.QueryRow("drop function GetNCCLinks")
+		sst.DB.QueryRowContext(ctx,"DROP INDEX sst_nan")
+		sst.DB.QueryRowContext(ctx,"DROP INDEX sst_type")
+		sst.DB.QueryRowContext(ctx,"DROP INDEX sst_gin")
+		sst.DB.QueryRowContext(ctx,"DROP INDEX sst_ungin")
+		sst.DB.QueryRowContext(ctx,"DROP INDEX sst_s")
+		sst.DB.QueryRowContext(ctx,"DROP INDEX sst_n")
+		sst.DB.QueryRowContext(ctx,"DROP INDEX sst_cnt")
+
+		sst.DB.QueryRowContext(ctx,"drop function fwdconeaslinks")
+		sst.DB.QueryRowContext(ctx,"drop function fwdconeasnodes")
+		sst.DB.QueryRowContext(ctx,"drop function fwdpathsaslinks")
+		sst.DB.QueryRowContext(ctx,"drop function getfwdlinks")
+		sst.DB.QueryRowContext(ctx,"drop function getfwdnodes")
+		sst.DB.QueryRowContext(ctx,"drop function getneighboursbytype")
+		sst.DB.QueryRowContext(ctx,"drop function getsingletonaslink")
+		sst.DB.QueryRowContext(ctx,"drop function AllNCPathsAsLinks")
+		sst.DB.QueryRowContext(ctx,"drop function AllSuperNCPathsAsLinks")
+		sst.DB.QueryRowContext(ctx,"drop function SumAllNCPaths")
+		sst.DB.QueryRowContext(ctx,"drop function GetNCFwdLinks")
+		sst.DB.QueryRowContext(ctx,"drop function GetNCCLinks")
+
+		sst.DB.QueryRowContext(ctx,"drop function getsingletonaslinkarray")
+		sst.DB.QueryRowContext(ctx,"drop function idempinsertnode")
+		sst.DB.QueryRowContext(ctx,"drop function sumfwdpaths")
+		sst.DB.QueryRowContext(ctx,"drop function match_context")
+		sst.DB.QueryRowContext(ctx,"drop function match_context_set")
+		sst.DB.QueryRowContext(ctx,"drop function match_context_conjunction")
+		sst.DB.QueryRowContext(ctx,"drop function match_chapter")
+		sst.DB.QueryRowContext(ctx,"drop function empty_path")
+		sst.DB.QueryRowContext(ctx,"drop function match_arrows")
+		sst.DB.QueryRowContext(ctx,"drop function ArrowInList")
+		sst.DB.QueryRowContext(ctx,"drop function GetNCCStoryStartNodes")
+		sst.DB.QueryRowContext(ctx,"drop function GetStoryStartNodes")
+		sst.DB.QueryRowContext(ctx,"drop function GetAppointments")
+		sst.DB.QueryRowContext(ctx,"drop function UnCmp")
+		sst.DB.QueryRowContext(ctx,"drop function DeleteChapter")
+
+		sst.DB.QueryRowContext(ctx,"drop function lastsawsection(text)")
+		sst.DB.QueryRowContext(ctx,"drop function lastsawnptr(nodeptr)")
+
+		sst.DB.QueryRowContext(ctx,"drop type NodePtr")
+		sst.DB.QueryRowContext(ctx,"drop type Link")
+		sst.DB.QueryRowContext(ctx,"drop type Appointment")
+
+		sst.DB.QueryRowContext(ctx,"drop table Node")
+		sst.DB.QueryRowContext(ctx,"drop table PageMap")
+		sst.DB.QueryRowContext(ctx,"drop table NodeArrowNode")
+		sst.DB.QueryRowContext(ctx,"drop table ArrowDirectory")
+		sst.DB.QueryRowContext(ctx,"drop table ArrowInverses")
+		sst.DB.QueryRowContext(ctx,"drop table ContextDirectory")
+		sst.DB.QueryRowContext(ctx,"drop table LastSeen")
+		sst.DB.QueryRowContext(ctx,"drop table Bookmarks")
+		sst.DB.QueryRowContext(ctx,"drop table NodeAlias")
+		sst.DB.QueryRowContext(ctx,"drop table Provenance")
+		sst.DB.QueryRowContext(ctx,"drop table ChangeLog")
+		sst.DB.QueryRowContext(ctx,"drop sequence ChangeRevision")
 
-		sst.DB.QueryRow("drop function getsingletonaslinkarray")
-		sst.DB.QueryRow("drop function idempinsertnode")
-		sst.DB.QueryRow("drop function sumfwdpaths")
-		sst.DB.QueryRow("drop function match_context")
-		sst.DB.QueryRow("drop function empty_path")
-		sst.DB.QueryRow("drop function match_arrows")
-		sst.DB.QueryRow("drop function ArrowInList")
-		sst.DB.QueryRow("drop function GetNCCStoryStartNodes")
-		sst.DB.QueryRow("drop function GetStoryStartNodes")
-		sst.DB.QueryRow("drop function GetAppointments")
-		sst.DB.QueryRow("drop function UnCmp")
-		sst.DB.QueryRow("drop function DeleteChapter")
+	}
 
-		sst.DB.QueryRow("drop function lastsawsection(text)")
-		sst.DB.QueryRow("drop function lastsawnptr(nodeptr)")
+	// Create functions, some we use in autocreating index columns. The
+	// helpers take the session by value
 
-		sst.DB.QueryRow("drop type NodePtr")
-		sst.DB.QueryRow("drop type Link")
-		sst.DB.QueryRow("drop type Appointment")
+	setup := WithContext(CopySession(sst),ctx)
 
-		sst.DB.QueryRow("drop table Node")
-		sst.DB.QueryRow("drop table PageMap")
-		sst.DB.QueryRow("drop table NodeArrowNode")
-		sst.DB.QueryRow("drop table ArrowDirectory")
-		sst.DB.QueryRow("drop table ArrowInverses")
-		sst.DB.QueryRow("drop table ContextDirectory")
-		sst.DB.QueryRow("drop table LastSeen")
-		sst.DB.QueryRow("drop table Bookmarks")
+	sst.DB.QueryRowContext(ctx,"CREATE EXTENSION unaccent")
 
+	if !CreateType(setup,NODEPTR_TYPE) {
+		return fmt.Errorf("%w: type %s",ERR_DB_CONFIGURE,NODEPTR_TYPE)
 	}
 
-	// Create functions, some we use in autocreating index columns
+	if !CreateType(setup,LINK_TYPE) {
+		return fmt.Errorf("%w: type %s",ERR_DB_CONFIGURE,LINK_TYPE)
+	}
 
-	sst.DB.QueryRow("CREATE EXTENSION unaccent")
+	if !CreateType(setup,APPOINTMENT_TYPE) {
+		return fmt.Errorf("%w: type %s",ERR_DB_CONFIGURE,APPOINTMENT_TYPE)
+	}
 
-	if !CreateType(sst,NODEPTR_TYPE) {
-		fmt.Println("Unable to create type as, ",NODEPTR_TYPE)
-		os.Exit(-1)
+	if !CreateTable(setup,CONTEXT_DIRECTORY_TABLE) {
+		return fmt.Errorf("%w: table %s",ERR_DB_CONFIGURE,CONTEXT_DIRECTORY_TABLE)
 	}
 
-	if !CreateType(sst,LINK_TYPE) {
-		fmt.Println("Unable to create type as, ",LINK_TYPE)
-		os.Exit(-1)
+	if !CreateTable(setup,BOOKMARK_TABLE) {
+		return fmt.Errorf("%w: table %s",ERR_DB_CONFIGURE,BOOKMARK_TABLE)
 	}
 
-	if !CreateType(sst,APPOINTMENT_TYPE) {
-		fmt.Println("Unable to create type as, ",APPOINTMENT_TYPE)
-		os.Exit(-1)
+	DefineStoredFunctions(setup)
+
+	if !CreateTable(setup,PAGEMAP_TABLE) {
+		return fmt.Errorf("%w: table %s",ERR_DB_CONFIGURE,PAGEMAP_TABLE)
 	}
 
-	if !CreateTable(sst,CONTEXT_DIRECTORY_TABLE) {
-		fmt.Println("Unable to create table as, ",CONTEXT_DIRECTORY_TABLE)
-		os.Exit(-1)
+	if !CreateTable(setup,NODE_TABLE) {
+		return fmt.Errorf("%w: table %s",ERR_DB_CONFIGURE,NODE_TABLE)
 	}
 
-	if !CreateTable(sst,BOOKMARK_TABLE) {
-		fmt.Println("Unable to create table as, ",CONTEXT_DIRECTORY_TABLE)
-		os.Exit(-1)
+	if !CreateTable(setup,ARROW_INVERSES_TABLE) {
+		return fmt.Errorf("%w: table %s",ERR_DB_CONFIGURE,ARROW_INVERSES_TABLE)
 	}
 
-	DefineStoredFunctions(sst)
+	if !CreateTable(setup,ARROW_DIRECTORY_TABLE) {
+		return fmt.Errorf("%w: table %s",ERR_DB_CONFIGURE,ARROW_DIRECTORY_TABLE)
+	}
 
-	if !CreateTable(sst,PAGEMAP_TABLE) {
-		fmt.Println("Unable to create table as, ",PAGEMAP_TABLE)
-		os.Exit(-1)
+	if !CreateTable(setup,LASTSEEN_TABLE) {
+		return fmt.Errorf("%w: table %s",ERR_DB_CONFIGURE,LASTSEEN_TABLE)
 	}
 
-	if !CreateTable(sst,NODE_TABLE) {
-		fmt.Println("Unable to create table as, ",NODE_TABLE)
-		os.Exit(-1)
+	if !CreateTable(setup,NODE_ALIAS_TABLE) {
+		return fmt.Errorf("%w: table %s",ERR_DB_CONFIGURE,NODE_ALIAS_TABLE)
 	}
 
-	if !CreateTable(sst,ARROW_INVERSES_TABLE) {
-		fmt.Println("Unable to create table as, ",ARROW_INVERSES_TABLE)
-		os.Exit(-1)
+	if !CreateTable(setup,PROVENANCE_TABLE) {
+		return fmt.Errorf("%w: table %s",ERR_DB_CONFIGURE,PROVENANCE_TABLE)
 	}
 
-	if !CreateTable(sst,ARROW_DIRECTORY_TABLE) {
-		fmt.Println("Unable to create table as, ",ARROW_DIRECTORY_TABLE)
-		os.Exit(-1)
+	if !CreateTable(setup,CHANGELOG_TABLE) {
+		return fmt.Errorf("%w: table %s",ERR_DB_CONFIGURE,CHANGELOG_TABLE)
 	}
 
-	if !CreateTable(sst,LASTSEEN_TABLE) {
-		fmt.Println("Unable to create table as, ",LASTSEEN_TABLE)
-		os.Exit(-1)
+	if !CreateTable(setup,CHANGE_REVISION_SEQUENCE) {
+		return fmt.Errorf("%w: %s",ERR_DB_CONFIGURE,CHANGE_REVISION_SEQUENCE)
 	}
 
 	// Find ignorable arrows
+
+	return nil
 }
 
 
+// **************************************************************************
+
+func (pg PostgresStore) Finalize(ctx context.Context,sst *PoSST) {
+
+	// Build indices after a bulk upload
+
+	sst.DB.QueryRowContext(ctx,"CREATE INDEX IF NOT EXISTS sst_gin on Node USING GIN (to_tsvector('english',Search))")
+	sst.DB.QueryRowContext(ctx,"CREATE INDEX IF NOT EXISTS sst_ungin on Node USING GIN (to_tsvector('english',UnSearch))")
+	sst.DB.QueryRowContext(ctx,"CREATE INDEX IF NOT EXISTS sst_s on Node USING GIN (S)")
+	sst.DB.QueryRowContext(ctx,"CREATE INDEX IF NOT EXISTS sst_n on Node USING GIN (NPtr)")
+	sst.DB.QueryRowContext(ctx,"CREATE INDEX IF NOT EXISTS sst_cnt on ContextDirectory USING GIN (Context)")
+	sst.DB.QueryRowContext(ctx,"ALTER TABLE Node SET LOGGED")
+	sst.DB.QueryRowContext(ctx,"ALTER TABLE PageMap SET LOGGED")
+}
+
 // **************************************************************************
 
 func Close(sst PoSST) {
+	sst.STORE.Close(&sst)
+}
+
+// **************************************************************************
+
+func (pg PostgresStore) Close(sst *PoSST) {
 	sst.DB.Close()
 }
 
@@ -0,0 +1,284 @@
+//**************************************************************
+//
+// shared_session.go
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"context"
+	"sync"
+	"time"
+	_ "github.com/lib/pq"
+
+)
+
+//**************************************************************
+// One long lived session for a server, shared by its requests.
+// Opening a session configures the database and downloads the
+// arrow and context directories, which is too slow to do per
+// request, and each open session holds its own connections.
+//
+//   shared,err := OpenSharedErr(ctx,true,DefaultPool())
+//   ...
+//   sst := shared.Request(r.Context())
+//
+// A request's copy shares the arrow and context directories, see
+// concurrency.go, but keeps its own node cache. An edit, which
+// may add contexts, should use Writer() so that they are not seen
+// until stored, and an upload of N4L should use Uploader(). The
+// directories are read again when the change log shows that the
+// graph has changed, e.g. after an upload by N4L, see changelog.go
+//**************************************************************
+
+type SharedSession struct {
+
+	lock     sync.RWMutex
+	sst      PoSST
+	revision int64     // of the change log when the directories were read
+	checked  time.Time // when the revision was last compared
+	stale    bool      // read the directories again on the next request
+}
+
+//**************************************************************
+
+type PoolConfig struct {
+
+	MaxOpen     int           // connections, 0 for no limit
+	MaxIdle     int           // kept open between requests
+	MaxLifetime time.Duration // before a connection is replaced, 0 for ever
+	MaxIdleTime time.Duration
+}
+
+//**************************************************************
+
+const SHARED_RECHECK = 2 * time.Second // between looks at the change log
+
+// **************************************************************************
+
+func DefaultPool() PoolConfig {
+
+	var pool PoolConfig
+
+	pool.MaxOpen = 20
+	pool.MaxIdle = 5
+	pool.MaxLifetime = 30 * time.Minute
+	pool.MaxIdleTime = 5 * time.Minute
+
+	return pool
+}
+
+// **************************************************************************
+
+func SetPool(sst *PoSST,pool PoolConfig) {
+
+	// Only for PostgreSQL, SQLite keeps to a single connection
+
+	if sst.DB == nil {
+		return
+	}
+
+	sst.DB.SetMaxOpenConns(pool.MaxOpen)
+	sst.DB.SetMaxIdleConns(pool.MaxIdle)
+	sst.DB.SetConnMaxLifetime(pool.MaxLifetime)
+	sst.DB.SetConnMaxIdleTime(pool.MaxIdleTime)
+}
+
+// **************************************************************************
+
+func OpenShared(load_arrows bool,pool PoolConfig) *SharedSession {
+
+	shared,err := OpenSharedErr(context.Background(),load_arrows,pool)
+	ExitOnError(err)
+
+	return shared
+}
+
+// **************************************************************************
+
+func OpenSharedErr(ctx context.Context,load_arrows bool,pool PoolConfig) (*SharedSession,error) {
+
+	sst,err := OpenErr(ctx,load_arrows)
+
+	if err != nil {
+		return nil,err
+	}
+
+	return NewSharedSession(sst,pool),nil
+}
+
+// **************************************************************************
+
+func NewSharedSession(sst PoSST,pool PoolConfig) *SharedSession {
+
+	// Share a session opened by other means, e.g. OpenURIErr()
+
+	var shared SharedSession
+
+	SetPool(&sst,pool)
+
+	sst.CTX = nil
+	shared.sst = sst
+	shared.revision = LatestDBRevision(&sst)
+	shared.checked = time.Now()
+
+	return &shared
+}
+
+// **************************************************************************
+
+func (shared *SharedSession) Request(ctx context.Context) PoSST {
+
+	// A copy of the session for one request, cancelled with ctx
+
+	shared.RefreshIfChanged()
+
+	shared.lock.RLock()
+	sst := shared.sst
+	shared.lock.RUnlock()
+
+	sst.CTX = ctx
+	sst.NODE_CACHE = make(map[NodePtr]NodePtr)
+	sst.NODE_DIRECTORY = NewNodeDirectory()
+
+	return sst
+}
+
+// **************************************************************************
+
+func (shared *SharedSession) Writer(ctx context.Context) PoSST {
+
+	// As Request, with private context directories that it can add
+	// to. Other requests see the additions after Changed()
+
+	sst := shared.Request(ctx)
+
+	sst.CONTEXTS = CloneContextTable(&sst)
+	SyncContextFields(&sst)
+
+	return sst
+}
+
+// **************************************************************************
+
+func (shared *SharedSession) Uploader(ctx context.Context) PoSST {
+
+	// As Writer, with private arrow directories and a node directory
+	// that continues from the database, to compile N4L into and upload
+	// with GraphToDB(). Only one should be uploading at a time, as the
+	// new nodes' pointers are allocated from the directory
+
+	sst := shared.Writer(ctx)
+
+	CloneArrowDirectory(&sst)
+
+	var hwm [N_CHANNELS]ClassedNodePtr
+
+	sst.HWM = hwm
+	sst.PAGE_MAP = nil
+	sst.PROVENANCE = nil
+
+	SynchronizeNPtrs(&sst)
+
+	return sst
+}
+
+// **************************************************************************
+
+func (shared *SharedSession) Changed() {
+
+	// Read the directories again before the next request, e.g. after an edit
+
+	shared.lock.Lock()
+	shared.stale = true
+	shared.lock.Unlock()
+}
+
+// **************************************************************************
+
+func (shared *SharedSession) RefreshIfChanged() {
+
+	shared.lock.Lock()
+
+	due := shared.stale || time.Since(shared.checked) > SHARED_RECHECK
+
+	if due {
+		shared.checked = time.Now()
+	}
+
+	stale := shared.stale
+	sst := shared.sst
+	revision := shared.revision
+
+	shared.lock.Unlock()
+
+	if !due {
+		return
+	}
+
+	if stale || LatestDBRevision(&sst) != revision {
+		if err := shared.Refresh(); err != nil {
+
+			// Keep serving the old directories, and try again next time
+
+			RunErr(err.Error())
+			shared.Changed()
+		}
+	}
+}
+
+// **************************************************************************
+
+func (shared *SharedSession) Refresh() error {
+
+	// Read the directories into new maps, so that requests still
+	// using the old ones are not disturbed, then swap them in.
+	// If either download fails, the old directories are kept
+
+	shared.lock.RLock()
+	next := shared.sst
+	shared.lock.RUnlock()
+
+	next.CTX = nil
+
+	// Note the revision first, so that changes made while reading are not missed
+
+	revision := LatestDBRevision(&next)
+
+	next.ARROW_SHORT_DIR = make(map[string]ArrowPtr)
+	next.ARROW_LONG_DIR = make(map[string]ArrowPtr)
+	next.INVERSE_ARROWS = make(map[ArrowPtr]ArrowPtr)
+	next.CONTEXTS = NewContextTable()
+	SyncContextFields(&next)
+
+	if err := DownloadArrowsFromDB(&next); err != nil {
+		return err
+	}
+
+	if err := DownloadContextsFromDB(&next); err != nil {
+		return err
+	}
+
+	shared.lock.Lock()
+	shared.sst = next
+	shared.revision = revision
+	shared.stale = false
+	shared.lock.Unlock()
+
+	return nil
+}
+
+// **************************************************************************
+
+func (shared *SharedSession) Close() {
+
+	shared.lock.Lock()
+	defer shared.lock.Unlock()
+
+	Close(shared.sst)
+}
+
+//
+// shared_session.go
+//
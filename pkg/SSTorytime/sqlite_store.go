@@ -0,0 +1,1935 @@
+//**************************************************************
+//
+// sqlite_store.go
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+
+)
+
+//**************************************************************
+// An embedded single file backend, for laptops without postgres.
+// The tables follow NODE_TABLE, PAGEMAP_TABLE etc, except that
+// SQLite has no composite types: NodePtr is split into its Chan
+// and CPtr columns, and Link[] columns hold the same array text
+// that FormatSQLLinkArray() writes for postgres. There are no
+// stored functions, so searches use the Go versions in
+// cone_search.go. Open it with
+//
+//   export SST_STORE_URI=sqlite:///home/me/sstoryline.db
+//
+// or OpenStore(NewSQLiteStore("sstoryline.db"),true)
+//**************************************************************
+
+const SQLITE_NODE_TABLE = "CREATE TABLE IF NOT EXISTS Node " +
+	"( " +
+	"Chan      int,            \n" +
+	"CPtr      int,            \n" +
+	"L         int,            \n" +
+	"S         text,           \n" +
+	"Chap      text,           \n" +
+	"Seq       boolean,        \n" +
+	I_MEXPR+"  text,           \n" + // Im3
+	I_MCONT+"  text,           \n" + // Im2
+	I_MLEAD+"  text,           \n" + // Im1
+	I_NEAR +"  text,           \n" + // In0
+	I_PLEAD+"  text,           \n" + // Il1
+	I_PCONT+"  text,           \n" + // Ic2
+	I_PEXPR+"  text,           \n" + // Ie3
+	"PRIMARY KEY(Chan,CPtr)    \n" +
+	")"
+
+const SQLITE_PAGEMAP_TABLE = "CREATE TABLE IF NOT EXISTS PageMap " +
+	"( " +
+	"Chap     text,  " +
+	"Alias    text,  " +
+	"Ctx      int,   " +
+	"Line     int,   " +
+	"Path     text   " +
+	")"
+
+const SQLITE_ARROW_DIRECTORY_TABLE = "CREATE TABLE IF NOT EXISTS ArrowDirectory " +
+	"(    " +
+	"STAindex int,           " +
+	"Long text,              " +
+	"Short text,             " +
+	"ArrPtr int primary key  " +
+	")"
+
+const SQLITE_ARROW_INVERSES_TABLE = "CREATE TABLE IF NOT EXISTS ArrowInverses " +
+	"(    " +
+	"Plus int,  " +
+	"Minus int,  " +
+	"Primary Key(Plus,Minus)" +
+	")"
+
+const SQLITE_LASTSEEN_TABLE = "CREATE TABLE IF NOT EXISTS LastSeen " +
+	"(    " +
+	"Section text," +
+	"Chan    int," +
+	"CPtr    int," +
+	"First   int,"+      // unix seconds
+	"Last    int," +
+	"Delta   real," +
+	"Freq    int" +
+	")"
+
+const SQLITE_CONTEXT_DIRECTORY_TABLE = "CREATE TABLE IF NOT EXISTS ContextDirectory " +
+	"(    " +
+	"Context text,            " +
+	"CtxPtr  int primary key  " +
+	")"
+
+const SQLITE_BOOKMARK_TABLE = "CREATE TABLE IF NOT EXISTS Bookmarks " +
+	"(    " +
+	"Query text,  " +
+	"Bookmark text" +
+	")"
+
+const SQLITE_NODE_ALIAS_TABLE = "CREATE TABLE IF NOT EXISTS NodeAlias " +
+	"( " +
+	"Chan      int,            \n" +
+	"CPtr      int,            \n" +
+	"S         text,           \n" +
+	"Chap      text,           \n" +
+	"Seq       boolean,        \n" +
+	"IntoChan  int,            \n" +
+	"IntoCPtr  int,            \n" +
+	"IntoChap  text,           \n" +
+	I_MEXPR+"  text,           \n" + // Im3
+	I_MCONT+"  text,           \n" + // Im2
+	I_MLEAD+"  text,           \n" + // Im1
+	I_NEAR +"  text,           \n" + // In0
+	I_PLEAD+"  text,           \n" + // Il1
+	I_PCONT+"  text,           \n" + // Ic2
+	I_PEXPR+"  text,           \n" + // Ie3
+	"Shared    text            \n" +
+	")"
+
+const SQLITE_PROVENANCE_TABLE = "CREATE TABLE IF NOT EXISTS Provenance " +
+	"( " +
+	"Chan      int,            \n" +
+	"CPtr      int,            \n" +
+	"Arr       int,            \n" + // -1 for the node itself
+	"DChan     int,            \n" +
+	"DCPtr     int,            \n" +
+	"File      text,           \n" +
+	"Line      int,            \n" +
+	"Author    text,           \n" +
+	"Time      int,            \n" + // unix seconds
+	"Primary Key(Chan,CPtr,Arr,DChan,DCPtr,File)" +
+	")"
+
+const SQLITE_CHANGELOG_TABLE = "CREATE TABLE IF NOT EXISTS ChangeLog " +
+	"( " +
+	"Id        integer primary key autoincrement,\n" + // order within a revision
+	"Rev       int,            \n" +
+	"Time      int,            \n" + // unix seconds
+	"Author    text,           \n" +
+	"Op        text,           \n" +
+	"Chan      int,            \n" +
+	"CPtr      int,            \n" +
+	"STtype    int,            \n" +
+	"Arr       int,            \n" +
+	"Wgt       real,           \n" +
+	"Ctx       int,            \n" +
+	"DChan     int,            \n" +
+	"DCPtr     int,            \n" +
+	"Chap      text,           \n" +
+	"Old       text,           \n" +
+	"New       text            \n" +
+	")"
+
+const SQLITE_NODE_COLS = "Chan,CPtr,L,S,Chap,Seq," +
+	I_MEXPR + "," + I_MCONT + "," + I_MLEAD + "," + I_NEAR + "," + I_PLEAD + "," + I_PCONT + "," + I_PEXPR
+
+//**************************************************************
+
+type SQLiteStore struct {
+
+	DB   *sql.DB
+	Path string
+}
+
+//**************************************************************
+
+func NewSQLiteStore(path string) *SQLiteStore {
+
+	s,err := NewSQLiteStoreErr(context.Background(),path)
+	ExitOnError(err)
+
+	return s
+}
+
+//**************************************************************
+
+func NewSQLiteStoreErr(ctx context.Context,path string) (*SQLiteStore,error) {
+
+	var s SQLiteStore
+	var err error
+
+	s.Path = path
+	s.DB, err = sql.Open("sqlite",path)
+
+	if err != nil {
+		return nil,fmt.Errorf("%w: sqlite %s: %v",ERR_DB_CONNECT,path,err)
+	}
+
+	err = s.DB.PingContext(ctx)
+
+	if err != nil {
+		s.DB.Close()
+		return nil,fmt.Errorf("%w (ping): sqlite %s: %v",ERR_DB_CONNECT,path,err)
+	}
+
+	// A single writer avoids SQLITE_BUSY between pooled connections
+
+	s.DB.SetMaxOpenConns(1)
+
+	return &s,nil
+}
+
+// **************************************************************************
+// Session
+// **************************************************************************
+
+func (s *SQLiteStore) Configure(ctx context.Context,sst *PoSST,load_arrows bool) error {
+
+	if sst.WIPE {
+
+		fmt.Println("***********************")
+		fmt.Println("* WIPING DB",s.Path)
+		fmt.Println("***********************")
+
+		s.Exec(ctx,sst,"DROP TABLE IF EXISTS Node")
+		s.Exec(ctx,sst,"DROP TABLE IF EXISTS PageMap")
+		s.Exec(ctx,sst,"DROP TABLE IF EXISTS ArrowDirectory")
+		s.Exec(ctx,sst,"DROP TABLE IF EXISTS ArrowInverses")
+		s.Exec(ctx,sst,"DROP TABLE IF EXISTS ContextDirectory")
+		s.Exec(ctx,sst,"DROP TABLE IF EXISTS LastSeen")
+		s.Exec(ctx,sst,"DROP TABLE IF EXISTS Bookmarks")
+		s.Exec(ctx,sst,"DROP TABLE IF EXISTS NodeAlias")
+		s.Exec(ctx,sst,"DROP TABLE IF EXISTS Provenance")
+		s.Exec(ctx,sst,"DROP TABLE IF EXISTS ChangeLog")
+	}
+
+	tables := []string{
+		SQLITE_NODE_TABLE,
+		SQLITE_PAGEMAP_TABLE,
+		SQLITE_ARROW_DIRECTORY_TABLE,
+		SQLITE_ARROW_INVERSES_TABLE,
+		SQLITE_LASTSEEN_TABLE,
+		SQLITE_CONTEXT_DIRECTORY_TABLE,
+		SQLITE_BOOKMARK_TABLE,
+		SQLITE_NODE_ALIAS_TABLE,
+		SQLITE_PROVENANCE_TABLE,
+		SQLITE_CHANGELOG_TABLE,
+	}
+
+	for _,defn := range tables {
+		if !s.Exec(ctx,sst,defn) {
+			return fmt.Errorf("%w: %s",ERR_DB_CONFIGURE,defn)
+		}
+	}
+
+	return nil
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) Finalize(ctx context.Context,sst *PoSST) {
+
+	s.Exec(ctx,sst,"CREATE INDEX IF NOT EXISTS sst_s ON Node (S)")
+	s.Exec(ctx,sst,"CREATE INDEX IF NOT EXISTS sst_chap ON Node (Chap)")
+	s.Exec(ctx,sst,"CREATE INDEX IF NOT EXISTS sst_seen ON LastSeen (Chan,CPtr)")
+	s.Exec(ctx,sst,"CREATE INDEX IF NOT EXISTS sst_rev ON ChangeLog (Rev)")
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) Close(sst *PoSST) {
+
+	s.DB.Close()
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) Exec(ctx context.Context,sst *PoSST,qstr string,args ...any) bool {
+
+	_,err := s.DB.ExecContext(ctx,qstr,args...)
+
+	if err != nil {
+		fmt.Println("FAILED \n",qstr,err)
+		return false
+	}
+
+	return true
+}
+
+// **************************************************************************
+// Nodes and links
+// **************************************************************************
+
+func (s *SQLiteStore) UploadNodes(ctx context.Context,sst *PoSST,nodes []Node) {
+
+	tx,err := s.DB.BeginTx(ctx,nil)
+
+	if err != nil {
+		fmt.Println("Failed to begin node upload",err)
+		return
+	}
+
+	qstr := "INSERT OR REPLACE INTO Node ("+SQLITE_NODE_COLS+") VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?)"
+
+	for _,n := range nodes {
+
+		if n.S == "" {
+			continue // placeholder from SynchronizeNPtrs
+		}
+
+		var cols [ST_TOP]any
+
+		for stindex := 0; stindex < ST_TOP; stindex++ {
+			cols[stindex] = FormatSQLLinkArray(n.I[stindex])
+		}
+
+		_,err = tx.Exec(qstr,n.NPtr.Class,n.NPtr.CPtr,n.L,n.S,n.Chap,n.Seq,
+			cols[0],cols[1],cols[2],cols[3],cols[4],cols[5],cols[6])
+
+		if err != nil {
+			fmt.Println("Failed to insert",n.S,err)
+		}
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		fmt.Println("Failed to commit node upload",err)
+	}
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) IdempAddNode(ctx context.Context,sst *PoSST,n Node) Node {
+
+	// Same policy as IdempAppendNode(): names are unique, CPtr = max+1
+
+	n.L,n.NPtr.Class = StorageClass(n.S)
+
+	tx,err := s.DB.BeginTx(ctx,nil)
+
+	if err != nil {
+		fmt.Println("Failed to add node",err)
+		return n
+	}
+
+	defer tx.Rollback()
+
+	err = tx.QueryRow("SELECT Chan,CPtr FROM Node WHERE S=? LIMIT 1",n.S).Scan(&n.NPtr.Class,&n.NPtr.CPtr)
+
+	if err == nil {
+		return n
+	}
+
+	var top int
+
+	tx.QueryRow("SELECT coalesce(max(CPtr),0) FROM Node WHERE Chan=?",n.NPtr.Class).Scan(&top)
+
+	n.NPtr.CPtr = ClassedNodePtr(top + 1)
+
+	_,err = tx.Exec("INSERT INTO Node ("+SQLITE_NODE_COLS+") VALUES (?,?,?,?,?,?,'{}','{}','{}','{}','{}','{}','{}')",
+		n.NPtr.Class,n.NPtr.CPtr,n.L,n.S,n.Chap,n.Seq)
+
+	if err == nil {
+		_,err = AppendSQLiteChanges(ctx,tx,AddedNodeChanges(sst,n))
+	}
+
+	if err != nil {
+		fmt.Println("Failed to add node",n.S,err)
+		return n
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		fmt.Println("Failed to add node",n.S,err)
+	}
+
+	return n
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) AppendLink(ctx context.Context,sst *PoSST,nptr NodePtr,lnk Link,sttype int) bool {
+
+	if sttype < -EXPRESS || sttype > EXPRESS {
+		fmt.Println(ERR_ST_OUT_OF_BOUNDS,sttype)
+		return false
+	}
+
+	if nptr == lnk.Dst {
+		return false
+	}
+
+	col,err := STTypeDBChannel(sttype)
+
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+
+	tx,err := s.DB.BeginTx(ctx,nil)
+
+	if err != nil {
+		fmt.Println("Failed to append",err)
+		return false
+	}
+
+	defer tx.Rollback()
+
+	var array string
+
+	err = tx.QueryRow("SELECT "+col+" FROM Node WHERE Chan=? AND CPtr=?",nptr.Class,nptr.CPtr).Scan(&array)
+
+	if err != nil {
+		return false
+	}
+
+	links := ParseLinkArray(array)
+
+	for _,prev := range links {
+		if prev == lnk {
+			return true
+		}
+	}
+
+	links = append(links,lnk)
+
+	_,err = tx.Exec("UPDATE Node SET "+col+"=? WHERE Chan=? AND CPtr=?",FormatSQLLinkArray(links),nptr.Class,nptr.CPtr)
+
+	if err != nil {
+		fmt.Println("Failed to append",err)
+		return false
+	}
+
+	return tx.Commit() == nil
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) GetNode(ctx context.Context,sst *PoSST,nptr NodePtr) (Node,bool) {
+
+	nodes := s.ScanNodes(ctx,sst,"SELECT "+SQLITE_NODE_COLS+" FROM Node WHERE Chan=? AND CPtr=?",nptr.Class,nptr.CPtr)
+
+	if len(nodes) == 0 {
+		var empty Node
+		empty.NPtr = nptr
+		return empty,false
+	}
+
+	return nodes[0],false
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) ScanNodes(ctx context.Context,sst *PoSST,qstr string,args ...any) []Node {
+
+	row,err := s.DB.QueryContext(ctx,qstr,args...)
+
+	if err != nil {
+		fmt.Println("QUERY Node failed",qstr,err)
+		return nil
+	}
+
+	var nodes []Node
+
+	for row.Next() {
+
+		var n Node
+		var cols [ST_TOP]string
+
+		err = row.Scan(&n.NPtr.Class,&n.NPtr.CPtr,&n.L,&n.S,&n.Chap,&n.Seq,
+			&cols[0],&cols[1],&cols[2],&cols[3],&cols[4],&cols[5],&cols[6])
+
+		if err != nil {
+			fmt.Println("Couldn't read node",err)
+			continue
+		}
+
+		for stindex := 0; stindex < ST_TOP; stindex++ {
+			n.I[stindex] = ParseLinkArray(cols[stindex])
+		}
+
+		nodes = append(nodes,n)
+	}
+
+	row.Close()
+	return nodes
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) GetNodePtrsByName(ctx context.Context,sst *PoSST,name string) []NodePtr {
+
+	row,err := s.DB.QueryContext(ctx,"SELECT Chan,CPtr FROM Node WHERE S=?",name)
+
+	if err != nil {
+		fmt.Println("QUERY GetNodePtrByName Failed",err)
+		return nil
+	}
+
+	var retval []NodePtr
+
+	for row.Next() {
+		var nptr NodePtr
+		err = row.Scan(&nptr.Class,&nptr.CPtr)
+		retval = append(retval,nptr)
+	}
+
+	row.Close()
+	return retval
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) GetNodePtrsMatching(ctx context.Context,sst *PoSST,nm,chap string,cn []string,arrow []ArrowPtr,seq bool,limit int) []NodePtr {
+
+	ExplainGoCall(sst,"GetNodePtrsMatching",nm,chap,cn,arrow,seq,limit)
+
+	// No tsvector here, so match in Go like the memory store
+
+	var matches []Node
+
+	for _,n := range s.ScanNodes(ctx,sst,"SELECT "+SQLITE_NODE_COLS+" FROM Node") {
+		if NodeMatchesNCCS(sst,n,nm,chap,cn,arrow,seq) {
+			matches = append(matches,n)
+		}
+	}
+
+	return OrderNodeMatches(matches,limit)
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) GetChaptersMatching(ctx context.Context,sst *PoSST,src string) []string {
+
+	row,err := s.DB.QueryContext(ctx,"SELECT DISTINCT Chap FROM Node")
+
+	if err != nil {
+		fmt.Println("QUERY GetDBChaptersMatchingName",err)
+		return nil
+	}
+
+	var chaps []string
+
+	for row.Next() {
+		var whole string
+		err = row.Scan(&whole)
+		chaps = append(chaps,whole)
+	}
+
+	row.Close()
+
+	return FilterChaptersMatching(chaps,src)
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) GetNodePtrsByChapter(ctx context.Context,sst *PoSST,chap string) []NodePtr {
+
+	row,err := s.DB.QueryContext(ctx,"SELECT Chan,CPtr,Chap FROM Node WHERE Chap LIKE ?","%"+chap+"%")
+
+	if err != nil {
+		fmt.Println("QUERY GetDBNodePtrsByChapter Failed",err)
+		return nil
+	}
+
+	var retval []NodePtr
+
+	for row.Next() {
+		var nptr NodePtr
+		var chaps string
+		err = row.Scan(&nptr.Class,&nptr.CPtr,&chaps)
+
+		if InChapterList(chaps,chap) {
+			retval = append(retval,nptr)
+		}
+	}
+
+	row.Close()
+	return retval
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) GetTopCPtr(ctx context.Context,sst *PoSST,channel int) ClassedNodePtr {
+
+	var top_cptr int
+
+	err := s.DB.QueryRowContext(ctx,"SELECT coalesce(max(CPtr),0) FROM Node WHERE Chan=?",channel).Scan(&top_cptr)
+
+	if err != nil {
+		fmt.Println("QUERY Synchronizing nptrs",err)
+		return 0
+	}
+
+	return ClassedNodePtr(top_cptr)
+}
+
+// **************************************************************************
+
+func ReadSQLiteNodes(ctx context.Context,tx *sql.Tx,nptrs []NodePtr) (map[NodePtr]Node,error) {
+
+	// As GetNode(), in the caller's transaction, see EditNodes()
+
+	var nodes = make(map[NodePtr]Node)
+
+	for _,nptr := range nptrs {
+
+		var n Node
+		var cols [ST_TOP]string
+
+		err := tx.QueryRowContext(ctx,"SELECT "+SQLITE_NODE_COLS+" FROM Node WHERE Chan=? AND CPtr=?",nptr.Class,nptr.CPtr).Scan(
+			&n.NPtr.Class,&n.NPtr.CPtr,&n.L,&n.S,&n.Chap,&n.Seq,&cols[0],&cols[1],&cols[2],&cols[3],&cols[4],&cols[5],&cols[6])
+
+		if err == sql.ErrNoRows {
+			nodes[nptr] = Node{NPtr: nptr}
+			continue
+		}
+
+		if err != nil {
+			return nil,fmt.Errorf("%w: (%d,%d) %v",ERR_EDIT_FAILED,nptr.Class,nptr.CPtr,err)
+		}
+
+		for stindex := 0; stindex < ST_TOP; stindex++ {
+			n.I[stindex] = ParseLinkArray(cols[stindex])
+		}
+
+		nodes[nptr] = n
+	}
+
+	return nodes,nil
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) GetAllNodes(ctx context.Context,sst *PoSST) []Node {
+
+	return s.ScanNodes(ctx,sst,"SELECT "+SQLITE_NODE_COLS+" FROM Node")
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) UploadBatch(ctx context.Context,sst *PoSST,nodes []Node,links []BatchLink,chapters map[NodePtr]string,tops map[int]ClassedNodePtr,record BatchRecord) error {
+
+	const chunk = 500
+
+	tx,err := s.DB.BeginTx(ctx,nil)
+
+	if err != nil {
+		return fmt.Errorf("%w: %v",ERR_BATCH_FAILED,err)
+	}
+
+	defer tx.Rollback()
+
+	for channel,top := range tops {
+
+		var now int
+
+		err = tx.QueryRow("SELECT coalesce(max(CPtr),0) FROM Node WHERE Chan=?",channel).Scan(&now)
+
+		if err != nil {
+			return fmt.Errorf("%w: %v",ERR_BATCH_FAILED,err)
+		}
+
+		if ClassedNodePtr(now) != top {
+			return fmt.Errorf("%w: channel %d was at %d, now %d",ERR_BATCH_CONFLICT,channel,top,now)
+		}
+	}
+
+	for i := 0; i < len(nodes); i += chunk {
+
+		end := i + chunk
+
+		if end > len(nodes) {
+			end = len(nodes)
+		}
+
+		var rows []string
+		var args []any
+
+		for _,n := range nodes[i:end] {
+
+			rows = append(rows,"(?,?,?,?,?,?,?,?,?,?,?,?,?)")
+			args = append(args,n.NPtr.Class,n.NPtr.CPtr,n.L,n.S,n.Chap,n.Seq)
+
+			for stindex := 0; stindex < ST_TOP; stindex++ {
+				args = append(args,FormatSQLLinkArray(n.I[stindex]))
+			}
+		}
+
+		_,err = tx.Exec("INSERT INTO Node ("+SQLITE_NODE_COLS+") VALUES "+strings.Join(rows,","),args...)
+
+		if err != nil {
+			return fmt.Errorf("%w: %v",ERR_BATCH_FAILED,err)
+		}
+	}
+
+	for nptr,chaps := range chapters {
+
+		_,err = tx.Exec("UPDATE Node SET Chap=? WHERE Chan=? AND CPtr=?",chaps,nptr.Class,nptr.CPtr)
+
+		if err != nil {
+			return fmt.Errorf("%w: %v",ERR_BATCH_FAILED,err)
+		}
+	}
+
+	// Links are text here, so read each column once and write it back
+
+	type column struct {
+		nptr   NodePtr
+		sttype int
+	}
+
+	var order []column
+	var appends = make(map[column][]Link)
+
+	for _,bl := range links {
+
+		col := column{bl.NPtr,bl.STtype}
+
+		if _,seen := appends[col]; !seen {
+			order = append(order,col)
+		}
+
+		appends[col] = append(appends[col],bl.Link)
+	}
+
+	for _,col := range order {
+
+		name,err := STTypeDBChannel(col.sttype)
+
+		if err != nil {
+			return fmt.Errorf("%w: %v",ERR_BATCH_FAILED,err)
+		}
+
+		var array string
+
+		err = tx.QueryRow("SELECT "+name+" FROM Node WHERE Chan=? AND CPtr=?",col.nptr.Class,col.nptr.CPtr).Scan(&array)
+
+		if err != nil {
+			continue // as an UPDATE of a missing node
+		}
+
+		links := ParseLinkArray(array)
+
+		for _,lnk := range appends[col] {
+			if lnk.Dst != col.nptr && !LinkInList(links,lnk) {
+				links = append(links,lnk)
+			}
+		}
+
+		_,err = tx.Exec("UPDATE Node SET "+name+"=? WHERE Chan=? AND CPtr=?",FormatSQLLinkArray(links),col.nptr.Class,col.nptr.CPtr)
+
+		if err != nil {
+			return fmt.Errorf("%w: %v",ERR_BATCH_FAILED,err)
+		}
+	}
+
+	if len(record.Changes) > 0 {
+
+		var rev int64
+
+		err = tx.QueryRow("SELECT coalesce(max(Rev),0)+1 FROM ChangeLog").Scan(&rev)
+
+		if err != nil {
+			return fmt.Errorf("%w: %v",ERR_BATCH_FAILED,err)
+		}
+
+		for _,c := range record.Changes {
+
+			err = InsertSQLiteChange(tx,rev,c)
+
+			if err != nil {
+				return fmt.Errorf("%w: %v",ERR_BATCH_FAILED,err)
+			}
+		}
+	}
+
+	for _,line := range record.PageMap {
+
+		err = InsertSQLitePageMap(tx,line)
+
+		if err != nil {
+			return fmt.Errorf("%w: %v",ERR_BATCH_FAILED,err)
+		}
+	}
+
+	for _,p := range record.Sources {
+
+		err = InsertSQLiteProvenance(tx,p)
+
+		if err != nil {
+			return fmt.Errorf("%w: %v",ERR_BATCH_FAILED,err)
+		}
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return fmt.Errorf("%w: %v",ERR_BATCH_FAILED,err)
+	}
+
+	return nil
+}
+
+// **************************************************************************
+// Editing
+// **************************************************************************
+
+func (s *SQLiteStore) SetLinks(ctx context.Context,sst *PoSST,nptr NodePtr,links []Link,sttype int) bool {
+
+	err := s.EditNodes(ctx,sst,&NodeEdits{Links: []LinkEdit{{NPtr: nptr,STtype: sttype,Links: links}}})
+
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+
+	return true
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) EditNodes(ctx context.Context,sst *PoSST,edits *NodeEdits) error {
+
+	// Same order as the Postgres version, all or nothing. There is only
+	// one connection, so nodes read in the transaction are locked
+
+	tx,err := s.DB.BeginTx(ctx,nil)
+
+	if err != nil {
+		return fmt.Errorf("%w: %v",ERR_EDIT_FAILED,err)
+	}
+
+	defer tx.Rollback()
+
+	if plan := edits.Plan; plan != nil {
+
+		read := func(nptrs ...NodePtr) (map[NodePtr]Node,error) {
+			return ReadSQLiteNodes(ctx,tx,nptrs)
+		}
+
+		if err = plan(read,edits); err != nil {
+			return err
+		}
+	}
+
+	exec := func(nptr NodePtr,qstr string,args ...any) error {
+
+		_,err := tx.ExecContext(ctx,qstr,args...)
+
+		if err != nil {
+			return fmt.Errorf("%w: (%d,%d) %v",ERR_EDIT_FAILED,nptr.Class,nptr.CPtr,err)
+		}
+
+		return nil
+	}
+
+	for _,n := range edits.Added {
+
+		var cols [ST_TOP]any
+
+		for stindex := 0; stindex < ST_TOP; stindex++ {
+			cols[stindex] = FormatSQLLinkArray(n.I[stindex])
+		}
+
+		err = exec(n.NPtr,"INSERT INTO Node ("+SQLITE_NODE_COLS+") VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?)",
+			n.NPtr.Class,n.NPtr.CPtr,n.L,n.S,n.Chap,n.Seq,cols[0],cols[1],cols[2],cols[3],cols[4],cols[5],cols[6])
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for _,e := range edits.Links {
+
+		if e.STtype < -EXPRESS || e.STtype > EXPRESS {
+			return fmt.Errorf("%w: %d",ERR_ST_OUT_OF_BOUNDS,e.STtype)
+		}
+
+		col,err := STTypeDBChannel(e.STtype)
+
+		if err != nil {
+			return err
+		}
+
+		err = exec(e.NPtr,"UPDATE Node SET "+col+"=? WHERE Chan=? AND CPtr=?",FormatSQLLinkArray(e.Links),e.NPtr.Class,e.NPtr.CPtr)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for _,bl := range edits.Appended {
+
+		if bl.STtype < -EXPRESS || bl.STtype > EXPRESS {
+			return fmt.Errorf("%w: %d",ERR_ST_OUT_OF_BOUNDS,bl.STtype)
+		}
+
+		col,err := STTypeDBChannel(bl.STtype)
+
+		if err != nil {
+			return err
+		}
+
+		var array string
+
+		err = tx.QueryRowContext(ctx,"SELECT "+col+" FROM Node WHERE Chan=? AND CPtr=?",bl.NPtr.Class,bl.NPtr.CPtr).Scan(&array)
+
+		if err != nil {
+			return fmt.Errorf("%w: (%d,%d) %v",ERR_EDIT_FAILED,bl.NPtr.Class,bl.NPtr.CPtr,err)
+		}
+
+		links := ParseLinkArray(array)
+
+		if bl.NPtr == bl.Link.Dst || LinkInList(links,bl.Link) {
+			continue
+		}
+
+		err = exec(bl.NPtr,"UPDATE Node SET "+col+"=? WHERE Chan=? AND CPtr=?",FormatSQLLinkArray(append(links,bl.Link)),bl.NPtr.Class,bl.NPtr.CPtr)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for nptr,name := range edits.Renamed {
+
+		l,_ := StorageClass(name)
+
+		if err = exec(nptr,"UPDATE Node SET S=?,L=? WHERE Chan=? AND CPtr=?",name,l,nptr.Class,nptr.CPtr); err != nil {
+			return err
+		}
+	}
+
+	for nptr,chap := range edits.Chapters {
+		if err = exec(nptr,"UPDATE Node SET Chap=? WHERE Chan=? AND CPtr=?",chap,nptr.Class,nptr.CPtr); err != nil {
+			return err
+		}
+	}
+
+	for nptr,seq := range edits.Seqs {
+		if err = exec(nptr,"UPDATE Node SET Seq=? WHERE Chan=? AND CPtr=?",seq,nptr.Class,nptr.CPtr); err != nil {
+			return err
+		}
+	}
+
+	for from,to := range edits.Repoint {
+
+		count,err := RewriteSQLitePageMap(ctx,tx,func(links []Link) ([]Link,bool) {
+			return links,RepointPath(links,from,to)
+		})
+
+		if err != nil {
+			return fmt.Errorf("%w: (%d,%d) %v",ERR_EDIT_FAILED,from.Class,from.CPtr,err)
+		}
+
+		edits.Repointed += count
+	}
+
+	for _,alias := range edits.Aliases {
+
+		var args []any
+
+		args = append(args,alias.Alias.Class,alias.Alias.CPtr,alias.S,alias.Chap,alias.Seq,alias.Into.Class,alias.Into.CPtr,alias.IntoChap)
+
+		for stindex := 0; stindex < ST_TOP; stindex++ {
+			args = append(args,FormatSQLLinkArray(alias.I[stindex]))
+		}
+
+		args = append(args,FormatSQLLinkArray(alias.Shared))
+
+		err = exec(alias.Alias,"DELETE FROM NodeAlias WHERE Chan=? AND CPtr=?",alias.Alias.Class,alias.Alias.CPtr)
+
+		if err == nil {
+			err = exec(alias.Alias,"INSERT INTO NodeAlias (Chan,CPtr,S,Chap,Seq,IntoChan,IntoCPtr,IntoChap,"+
+				I_MEXPR+","+I_MCONT+","+I_MLEAD+","+I_NEAR+","+I_PLEAD+","+I_PCONT+","+I_PEXPR+
+				",Shared) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)",args...)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for _,alias := range edits.Unaliased {
+		if err = exec(alias,"DELETE FROM NodeAlias WHERE Chan=? AND CPtr=?",alias.Class,alias.CPtr); err != nil {
+			return err
+		}
+	}
+
+	for _,nptr := range edits.Deleted {
+
+		err = exec(nptr,"DELETE FROM Node WHERE Chan=? AND CPtr=?",nptr.Class,nptr.CPtr)
+
+		if err == nil {
+			err = exec(nptr,"DELETE FROM Provenance WHERE (Chan=? AND CPtr=?) OR (DChan=? AND DCPtr=?)",
+				nptr.Class,nptr.CPtr,nptr.Class,nptr.CPtr)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		_,err = RewriteSQLitePageMap(ctx,tx,func(links []Link) ([]Link,bool) {
+			kept := DeleteFromPath(links,nptr)
+			return kept,len(kept) < len(links)
+		})
+
+		if err != nil {
+			return fmt.Errorf("%w: (%d,%d) %v",ERR_EDIT_FAILED,nptr.Class,nptr.CPtr,err)
+		}
+	}
+
+	for chap,lines := range edits.PageMaps {
+
+		_,err = tx.ExecContext(ctx,"DELETE FROM PageMap WHERE Chap=?",chap)
+
+		for l := 0; err == nil && l < len(lines); l++ {
+			err = InsertSQLitePageMap(tx,lines[l])
+		}
+
+		if err != nil {
+			return fmt.Errorf("%w: page map of %s %v",ERR_EDIT_FAILED,chap,err)
+		}
+	}
+
+	for file,records := range edits.Sources {
+
+		_,err = tx.ExecContext(ctx,"DELETE FROM Provenance WHERE File=?",file)
+
+		for r := 0; err == nil && r < len(records); r++ {
+			err = InsertSQLiteProvenance(tx,records[r])
+		}
+
+		if err != nil {
+			return fmt.Errorf("%w: provenance of %s %v",ERR_EDIT_FAILED,file,err)
+		}
+	}
+
+	edits.Revision,err = AppendSQLiteChanges(ctx,tx,edits.Changes)
+
+	if err != nil {
+		return fmt.Errorf("%w: change log %v",ERR_EDIT_FAILED,err)
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return fmt.Errorf("%w: %v",ERR_EDIT_FAILED,err)
+	}
+
+	return nil
+}
+
+// **************************************************************************
+
+func RewriteSQLitePageMap(ctx context.Context,tx *sql.Tx,rewrite func([]Link) ([]Link,bool)) (int,error) {
+
+	// Paths are text here, so rewrite them in Go, returning the number
+	// of lines changed
+
+	row,err := tx.QueryContext(ctx,"SELECT rowid,Path FROM PageMap")
+
+	if err != nil {
+		return 0,err
+	}
+
+	var changed = make(map[int64][]Link)
+
+	for row.Next() {
+
+		var rowid int64
+		var path string
+
+		if row.Scan(&rowid,&path) != nil {
+			continue
+		}
+
+		if links,ok := rewrite(ParseLinkArray(path)); ok {
+			changed[rowid] = links
+		}
+	}
+
+	row.Close()
+
+	for rowid,links := range changed {
+
+		_,err = tx.ExecContext(ctx,"UPDATE PageMap SET Path=? WHERE rowid=?",FormatSQLLinkArray(links),rowid)
+
+		if err != nil {
+			return 0,err
+		}
+	}
+
+	return len(changed),nil
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) RenameNode(ctx context.Context,sst *PoSST,nptr NodePtr,name string) bool {
+
+	return s.EditOne(ctx,sst,&NodeEdits{Renamed: map[NodePtr]string{nptr: name}})
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) DeleteNode(ctx context.Context,sst *PoSST,nptr NodePtr) bool {
+
+	return s.EditOne(ctx,sst,&NodeEdits{Deleted: []NodePtr{nptr}})
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) SetNodeChapter(ctx context.Context,sst *PoSST,nptr NodePtr,chap string) bool {
+
+	return s.EditOne(ctx,sst,&NodeEdits{Chapters: map[NodePtr]string{nptr: chap}})
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) SetNodeSeq(ctx context.Context,sst *PoSST,nptr NodePtr,seq bool) bool {
+
+	return s.EditOne(ctx,sst,&NodeEdits{Seqs: map[NodePtr]bool{nptr: seq}})
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) RepointPageMap(ctx context.Context,sst *PoSST,from,to NodePtr) int {
+
+	edits := NodeEdits{Repoint: map[NodePtr]NodePtr{from: to}}
+	s.EditOne(ctx,sst,&edits)
+
+	return edits.Repointed
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) UploadAlias(ctx context.Context,sst *PoSST,alias NodeAlias) {
+
+	s.EditOne(ctx,sst,&NodeEdits{Aliases: []NodeAlias{alias}})
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) EditOne(ctx context.Context,sst *PoSST,edits *NodeEdits) bool {
+
+	err := s.EditNodes(ctx,sst,edits)
+
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+
+	return true
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) GetAliases(ctx context.Context,sst *PoSST,into NodePtr) []NodeAlias {
+
+	qstr := "SELECT Chan,CPtr,S,Chap,Seq,IntoChap," +
+		I_MEXPR+","+I_MCONT+","+I_MLEAD+","+I_NEAR+","+I_PLEAD+","+I_PCONT+","+I_PEXPR+
+		",Shared FROM NodeAlias WHERE IntoChan=? AND IntoCPtr=?"
+
+	row,err := s.DB.QueryContext(ctx,qstr,into.Class,into.CPtr)
+
+	if err != nil {
+		fmt.Println("QUERY GetAliases Failed",err)
+		return nil
+	}
+
+	var aliases []NodeAlias
+
+	for row.Next() {
+
+		var alias NodeAlias
+		var shared string
+		var cols [ST_TOP]string
+
+		err = row.Scan(&alias.Alias.Class,&alias.Alias.CPtr,&alias.S,&alias.Chap,&alias.Seq,&alias.IntoChap,
+			&cols[0],&cols[1],&cols[2],&cols[3],&cols[4],&cols[5],&cols[6],&shared)
+
+		if err != nil {
+			fmt.Println("Couldn't read alias",err)
+			continue
+		}
+
+		for stindex := 0; stindex < ST_TOP; stindex++ {
+			alias.I[stindex] = ParseLinkArray(cols[stindex])
+		}
+
+		alias.Into = into
+		alias.Shared = ParseLinkArray(shared)
+		aliases = append(aliases,alias)
+	}
+
+	row.Close()
+	return aliases
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) DeleteAlias(ctx context.Context,sst *PoSST,alias NodePtr) bool {
+
+	return s.EditOne(ctx,sst,&NodeEdits{Unaliased: []NodePtr{alias}})
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) UploadProvenance(ctx context.Context,sst *PoSST,records []Provenance) {
+
+	tx,err := s.DB.BeginTx(ctx,nil)
+
+	if err != nil {
+		fmt.Println("Failed to begin provenance upload",err)
+		return
+	}
+
+	for _,p := range records {
+
+		err = InsertSQLiteProvenance(tx,p)
+
+		if err != nil {
+			fmt.Println("Failed to insert provenance",err)
+		}
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		fmt.Println("Failed to commit provenance upload",err)
+	}
+}
+
+// **************************************************************************
+
+func InsertSQLiteProvenance(tx *sql.Tx,p Provenance) error {
+
+	_,err := tx.Exec("INSERT OR REPLACE INTO Provenance (Chan,CPtr,Arr,DChan,DCPtr,File,Line,Author,Time) VALUES (?,?,?,?,?,?,?,?,?)",
+		p.NPtr.Class,p.NPtr.CPtr,p.Arr,p.Dst.Class,p.Dst.CPtr,p.File,p.Line,p.Author,p.Time)
+
+	return err
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) GetProvenance(ctx context.Context,sst *PoSST,nptr NodePtr) []Provenance {
+
+	qstr := "SELECT Chan,CPtr,Arr,DChan,DCPtr,File,Line,Author,Time FROM Provenance " +
+		"WHERE (Chan=? AND CPtr=?) OR (DChan=? AND DCPtr=? AND Arr>=0) ORDER BY File,Line"
+
+	row,err := s.DB.QueryContext(ctx,qstr,nptr.Class,nptr.CPtr,nptr.Class,nptr.CPtr)
+
+	if err != nil {
+		fmt.Println("QUERY GetProvenance Failed",err)
+		return nil
+	}
+
+	var records []Provenance
+
+	for row.Next() {
+
+		var p Provenance
+
+		err = row.Scan(&p.NPtr.Class,&p.NPtr.CPtr,&p.Arr,&p.Dst.Class,&p.Dst.CPtr,&p.File,&p.Line,&p.Author,&p.Time)
+
+		if err != nil {
+			fmt.Println("Couldn't read provenance",err)
+			continue
+		}
+
+		records = append(records,p)
+	}
+
+	row.Close()
+	return records
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) DeleteProvenance(ctx context.Context,sst *PoSST,file string) int {
+
+	result,err := s.DB.ExecContext(ctx,"DELETE FROM Provenance WHERE File=?",file)
+
+	if err != nil {
+		fmt.Println("Failed to delete provenance",err)
+		return 0
+	}
+
+	count,_ := result.RowsAffected()
+	return int(count)
+}
+
+// **************************************************************************
+// Change log
+// **************************************************************************
+
+func (s *SQLiteStore) AppendChanges(ctx context.Context,sst *PoSST,changes []Change) int64 {
+
+	tx,err := s.DB.BeginTx(ctx,nil)
+
+	if err != nil {
+		fmt.Println("Failed to begin change log",err)
+		return 0
+	}
+
+	defer tx.Rollback()
+
+	rev,err := AppendSQLiteChanges(ctx,tx,changes)
+
+	if err == nil {
+		err = tx.Commit()
+	}
+
+	if err != nil {
+		fmt.Println("Failed to store change log",err)
+		return 0
+	}
+
+	return rev
+}
+
+// **************************************************************************
+
+func AppendSQLiteChanges(ctx context.Context,tx *sql.Tx,changes []Change) (int64,error) {
+
+	// One new revision, in the caller's transaction, 0 if there is nothing
+
+	if len(changes) == 0 {
+		return 0,nil
+	}
+
+	var rev int64
+
+	err := tx.QueryRowContext(ctx,"SELECT coalesce(max(Rev),0)+1 FROM ChangeLog").Scan(&rev)
+
+	for c := 0; err == nil && c < len(changes); c++ {
+		err = InsertSQLiteChange(tx,rev,changes[c])
+	}
+
+	if err != nil {
+		return 0,err
+	}
+
+	return rev,nil
+}
+
+// **************************************************************************
+
+func InsertSQLiteChange(tx *sql.Tx,rev int64,c Change) error {
+
+	_,err := tx.Exec("INSERT INTO ChangeLog (Rev,Time,Author,Op,Chan,CPtr,STtype,Arr,Wgt,Ctx,DChan,DCPtr,Chap,Old,New) "+
+		"VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)",
+		rev,c.Time,c.Author,c.Op,c.NPtr.Class,c.NPtr.CPtr,c.STtype,c.Lnk.Arr,c.Lnk.Wgt,c.Lnk.Ctx,
+		c.Lnk.Dst.Class,c.Lnk.Dst.CPtr,c.Chap,c.Old,c.New)
+
+	return err
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) GetChanges(ctx context.Context,sst *PoSST,after int64) []Change {
+
+	qstr := "SELECT Rev,Time,Author,Op,Chan,CPtr,STtype,Arr,Wgt,Ctx,DChan,DCPtr,Chap,Old,New FROM ChangeLog " +
+		"WHERE Rev > ? ORDER BY Rev,Id"
+
+	row,err := s.DB.QueryContext(ctx,qstr,after)
+
+	if err != nil {
+		fmt.Println("QUERY GetChanges Failed",err)
+		return nil
+	}
+
+	var changes []Change
+
+	for row.Next() {
+
+		var c Change
+
+		err = row.Scan(&c.Rev,&c.Time,&c.Author,&c.Op,&c.NPtr.Class,&c.NPtr.CPtr,&c.STtype,
+			&c.Lnk.Arr,&c.Lnk.Wgt,&c.Lnk.Ctx,&c.Lnk.Dst.Class,&c.Lnk.Dst.CPtr,&c.Chap,&c.Old,&c.New)
+
+		if err != nil {
+			fmt.Println("Couldn't read change",err)
+			continue
+		}
+
+		changes = append(changes,c)
+	}
+
+	row.Close()
+	return changes
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) GetRevisionAt(ctx context.Context,sst *PoSST,t int64) int64 {
+
+	var rev int64
+
+	err := s.DB.QueryRowContext(ctx,"SELECT coalesce(max(Rev),0) FROM ChangeLog WHERE Time <= ?",t).Scan(&rev)
+
+	if err != nil {
+		fmt.Println("QUERY GetRevisionAt Failed",err)
+		return 0
+	}
+
+	return rev
+}
+
+// **************************************************************************
+// Arrows and contexts
+// **************************************************************************
+
+func (s *SQLiteStore) UploadArrows(ctx context.Context,sst *PoSST) {
+
+	// Replaces the previous directory, like dropping the tables
+
+	tx,err := s.DB.BeginTx(ctx,nil)
+
+	if err != nil {
+		fmt.Println("Failed to begin arrow upload",err)
+		return
+	}
+
+	tx.Exec("DELETE FROM ArrowDirectory")
+	tx.Exec("DELETE FROM ArrowInverses")
+
+	for arrow,ad := range GetArrowDirectory(sst) {
+
+		_,err = tx.Exec("INSERT INTO ArrowDirectory (STAindex,Long,Short,ArrPtr) VALUES (?,?,?,?)",ad.STAindex,ad.Long,ad.Short,arrow)
+
+		if err != nil {
+			fmt.Println("Failed to insert arrow",ad.Long,err)
+		}
+	}
+
+	for plus,minus := range GetInverseArrows(sst) {
+		tx.Exec("INSERT OR IGNORE INTO ArrowInverses (Plus,Minus) VALUES (?,?)",plus,minus)
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		fmt.Println("Failed to commit arrow upload",err)
+	}
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) DownloadArrows(ctx context.Context,sst *PoSST) ([]ArrowDirectory,map[ArrowPtr]ArrowPtr,error) {
+
+	// All or nothing, a partial directory would misnumber the arrows
+
+	var directory []ArrowDirectory
+	var inverses = make(map[ArrowPtr]ArrowPtr)
+
+	row,err := s.DB.QueryContext(ctx,"SELECT STAindex,Long,Short,ArrPtr FROM ArrowDirectory ORDER BY ArrPtr")
+
+	if err != nil {
+		return nil,nil,fmt.Errorf("%w: arrows: %v",ERR_DOWNLOAD_FAILED,err)
+	}
+
+	for row.Next() {
+
+		var ad ArrowDirectory
+
+		if err = row.Scan(&ad.STAindex,&ad.Long,&ad.Short,&ad.Ptr); err != nil {
+			break
+		}
+
+		directory = append(directory,ad)
+	}
+
+	if err == nil {
+		err = row.Err()
+	}
+
+	row.Close()
+
+	if err != nil {
+		return nil,nil,fmt.Errorf("%w: arrows: %v",ERR_DOWNLOAD_FAILED,err)
+	}
+
+	row,err = s.DB.QueryContext(ctx,"SELECT Plus,Minus FROM ArrowInverses ORDER BY Plus")
+
+	if err != nil {
+		return nil,nil,fmt.Errorf("%w: inverses: %v",ERR_DOWNLOAD_FAILED,err)
+	}
+
+	for row.Next() {
+
+		var plus,minus ArrowPtr
+
+		if err = row.Scan(&plus,&minus); err != nil {
+			break
+		}
+
+		inverses[plus] = minus
+	}
+
+	if err == nil {
+		err = row.Err()
+	}
+
+	row.Close()
+
+	if err != nil {
+		return nil,nil,fmt.Errorf("%w: inverses: %v",ERR_DOWNLOAD_FAILED,err)
+	}
+
+	return directory,inverses,nil
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) IdempAddContext(ctx context.Context,sst *PoSST,context string,ptr ContextPtr) ContextPtr {
+
+	// Same policy as IdempInsertContext(), -1 means allocate a new pointer
+
+	tx,err := s.DB.BeginTx(ctx,nil)
+
+	if err != nil {
+		fmt.Println("Failed to add context",err)
+		return 0
+	}
+
+	defer tx.Rollback()
+
+	var cptr ContextPtr
+
+	if ptr == -1 {
+		tx.QueryRow("SELECT coalesce(max(CtxPtr)+1,0) FROM ContextDirectory").Scan(&cptr)
+	} else {
+		err = tx.QueryRow("SELECT CtxPtr FROM ContextDirectory WHERE CtxPtr=? OR Context=? LIMIT 1",ptr,context).Scan(&cptr)
+
+		if err == nil {
+			return cptr
+		}
+
+		cptr = ptr
+	}
+
+	_,err = tx.Exec("INSERT INTO ContextDirectory (Context,CtxPtr) VALUES (?,?)",context,cptr)
+
+	if err != nil {
+		fmt.Println("Failed to add context",context,err)
+		return cptr
+	}
+
+	tx.Commit()
+	return cptr
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) DownloadContexts(ctx context.Context,sst *PoSST) ([]ContextDirectory,error) {
+
+	row,err := s.DB.QueryContext(ctx,"SELECT Context,CtxPtr FROM ContextDirectory ORDER BY CtxPtr")
+
+	if err != nil {
+		return nil,fmt.Errorf("%w: contexts: %v",ERR_DOWNLOAD_FAILED,err)
+	}
+
+	var directory []ContextDirectory
+
+	for row.Next() {
+
+		var c ContextDirectory
+
+		if err = row.Scan(&c.Context,&c.Ptr); err != nil {
+			break
+		}
+
+		directory = append(directory,c)
+	}
+
+	if err == nil {
+		err = row.Err()
+	}
+
+	row.Close()
+
+	if err != nil {
+		return nil,fmt.Errorf("%w: contexts: %v",ERR_DOWNLOAD_FAILED,err)
+	}
+
+	return directory,nil
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) GetContextByName(ctx context.Context,sst *PoSST,src string) (string,ContextPtr) {
+
+	remove_accents,stripped := IsBracketedSearchTerm(src)
+	stripped = SQLUnescape(stripped)
+
+	directory,err := s.DownloadContexts(ctx,sst)
+
+	if err != nil {
+		fmt.Println(err)
+		return "",0
+	}
+
+	for _,c := range directory {
+
+		if remove_accents && Unaccent(c.Context) == stripped {
+			return c.Context,c.Ptr
+		}
+
+		if !remove_accents && c.Context == src {
+			return c.Context,c.Ptr
+		}
+	}
+
+	return "",0
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) GetContextByPtr(ctx context.Context,sst *PoSST,ptr ContextPtr) (string,ContextPtr) {
+
+	var context string
+	var cptr ContextPtr
+
+	err := s.DB.QueryRowContext(ctx,"SELECT Context,CtxPtr FROM ContextDirectory WHERE CtxPtr=?",ptr).Scan(&context,&cptr)
+
+	if err != nil {
+		return "",0
+	}
+
+	return context,cptr
+}
+
+// **************************************************************************
+// Page map and bookmarks
+// **************************************************************************
+
+func (s *SQLiteStore) UploadPageMap(ctx context.Context,sst *PoSST,lines []PageMap) {
+
+	tx,err := s.DB.BeginTx(ctx,nil)
+
+	if err != nil {
+		fmt.Println("Failed to begin page map upload",err)
+		return
+	}
+
+	for _,line := range lines {
+
+		err = InsertSQLitePageMap(tx,line)
+
+		if err != nil {
+			fmt.Println("Failed to insert page map",err)
+		}
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		fmt.Println("Failed to commit page map upload",err)
+	}
+}
+
+// **************************************************************************
+
+func InsertSQLitePageMap(tx *sql.Tx,line PageMap) error {
+
+	_,err := tx.Exec("INSERT INTO PageMap (Chap,Alias,Ctx,Line,Path) VALUES (?,?,?,?,?)",
+		line.Chapter,line.Alias,line.Context,line.Line,FormatSQLLinkArray(line.Path))
+
+	return err
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) DeletePageMap(ctx context.Context,sst *PoSST,chap string) int {
+
+	result,err := s.DB.ExecContext(ctx,"DELETE FROM PageMap WHERE Chap=?",chap)
+
+	if err != nil {
+		fmt.Println("Failed to delete page map",err)
+		return 0
+	}
+
+	count,_ := result.RowsAffected()
+	return int(count)
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) GetPageMap(ctx context.Context,sst *PoSST,chap string,cn []string,page,limit int) []PageMap {
+
+	ExplainGoCall(sst,"GetPageMap",chap,cn,page,limit)
+
+	row,err := s.DB.QueryContext(ctx,"SELECT Chap,Alias,Ctx,Line,Path FROM PageMap")
+
+	if err != nil {
+		fmt.Println("QUERY GetDBPageMap Failed",err)
+		return nil
+	}
+
+	var pagemap []PageMap
+
+	for row.Next() {
+
+		var event PageMap
+		var path string
+
+		err = row.Scan(&event.Chapter,&event.Alias,&event.Context,&event.Line,&path)
+		event.Path = ParseLinkArray(path)
+
+		pagemap = append(pagemap,event)
+	}
+
+	row.Close()
+
+	return FilterPageMap(sst,pagemap,chap,cn,page,limit)
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) UploadBookmarks(ctx context.Context,sst *PoSST,marks map[string]string) {
+
+	for b, q := range marks {
+		s.Exec(ctx,sst,"INSERT INTO Bookmarks (Bookmark,Query) VALUES (?,?)",b,q)
+	}
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) GetBookmarks(ctx context.Context,sst *PoSST) []Bookmark {
+
+	row,err := s.DB.QueryContext(ctx,"SELECT Bookmark,Query FROM Bookmarks")
+
+	if err != nil {
+		fmt.Println("QUERY GetBookmarks Failed",err)
+		return nil
+	}
+
+	var marks []Bookmark
+
+	for row.Next() {
+		var bm Bookmark
+		err = row.Scan(&bm.Bookmark,&bm.Query)
+		marks = append(marks,bm)
+	}
+
+	row.Close()
+	return marks
+}
+
+// **************************************************************************
+// Last seen, same 1 minute dead time as LastSawSection() and LastSawNPtr()
+// **************************************************************************
+
+func (s *SQLiteStore) LastSawSection(ctx context.Context,sst *PoSST,name string) {
+
+	var section NodePtr
+
+	section.Class = -1
+	section.CPtr = -1
+
+	s.UpdateLastSeen(ctx,sst,"Section=?",name,section,name)
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) LastSawNPtr(ctx context.Context,sst *PoSST,nptr NodePtr,name string) {
+
+	s.UpdateLastSeen(ctx,sst,"Chan=? AND CPtr=?",name,nptr,nptr.Class,nptr.CPtr)
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) UpdateLastSeen(ctx context.Context,sst *PoSST,where,name string,nptr NodePtr,args ...any) {
+
+	now := time.Now().Unix()
+
+	tx,err := s.DB.BeginTx(ctx,nil)
+
+	if err != nil {
+		fmt.Println("Failed to update LastSeen",err)
+		return
+	}
+
+	defer tx.Rollback()
+
+	var last int64
+	var delta float64
+	var freq int
+
+	err = tx.QueryRow("SELECT Last,Delta,Freq FROM LastSeen WHERE "+where,args...).Scan(&last,&delta,&freq)
+
+	if err == sql.ErrNoRows {
+		_,err = tx.Exec("INSERT INTO LastSeen (Section,Chan,CPtr,First,Last,Delta,Freq) VALUES (?,?,?,?,?,0,1)",
+			name,nptr.Class,nptr.CPtr,now,now)
+	} else if err == nil && now - last > 60 {
+		delta = 0.5 * float64(now - last) + 0.5 * delta
+		_,err = tx.Exec("UPDATE LastSeen SET Last=?,Delta=?,Freq=? WHERE "+where,append([]any{now,delta,freq+1},args...)...)
+	}
+
+	if err != nil {
+		fmt.Println("Failed to update LastSeen",err)
+		return
+	}
+
+	tx.Commit()
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) GetLastSeen(ctx context.Context,sst *PoSST) []LastSeen {
+
+	return s.ScanLastSeen(ctx,sst,"SELECT Section,Chan,CPtr,First,Last,Freq,Delta FROM LastSeen ORDER BY Section")
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) GetLastSeenNPtr(ctx context.Context,sst *PoSST,nptr NodePtr) LastSeen {
+
+	var ls LastSeen
+
+	for _,prev := range s.ScanLastSeen(ctx,sst,"SELECT Section,Chan,CPtr,First,Last,Freq,Delta FROM LastSeen WHERE Chan=? AND CPtr=?",nptr.Class,nptr.CPtr) {
+		ls = prev
+	}
+
+	ls.NPtr = nptr
+	return ls
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) ScanLastSeen(ctx context.Context,sst *PoSST,qstr string,args ...any) []LastSeen {
+
+	row,err := s.DB.QueryContext(ctx,qstr,args...)
+
+	if err != nil {
+		fmt.Println("GetLastSawSection failed\n",qstr,err)
+		return nil
+	}
+
+	var ret []LastSeen
+	now := time.Now().Unix()
+
+	for row.Next() {
+		var ls LastSeen
+		err = row.Scan(&ls.Section,&ls.NPtr.Class,&ls.NPtr.CPtr,&ls.First,&ls.Last,&ls.Freq,&ls.Pdelta)
+		ls.Ndelta = float64(now - ls.Last)
+		ret = append(ret,ls)
+	}
+
+	row.Close()
+	return ret
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) GetNewlySeen(ctx context.Context,sst *PoSST,horizon int) map[NodePtr]bool {
+
+	var nptrs = make (map[NodePtr]bool)
+	var since int64
+
+	switch horizon {
+	case RECENT:
+		since = time.Now().Unix() - int64(horizon) * 3600
+	case NEVER:
+		since = 0
+	default:
+		return nptrs
+	}
+
+	for _,ls := range s.ScanLastSeen(ctx,sst,"SELECT Section,Chan,CPtr,First,Last,Freq,Delta FROM LastSeen WHERE Last > ?",since) {
+		nptrs[ls.NPtr] = true
+	}
+
+	return nptrs
+}
+
+// **************************************************************************
+// Cone and path searches, using the Go versions of the stored functions
+// on a copy of the session that carries ctx, as they check Cancelled().
+// The copy is taken under the directory lock, see CopySession()
+// **************************************************************************
+
+func (s *SQLiteStore) FwdPathsAsLinks(ctx context.Context,sst *PoSST,start NodePtr,sttype,depth,maxlimit int) [][]Link {
+
+	ExplainGoCall(sst,"FwdPathsAsLinks",start,sttype,depth,maxlimit)
+
+	search := WithContext(CopySession(sst),ctx)
+
+	return FwdPathsAsLinks(&search,start,sttype,depth,maxlimit)
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) EntireConePathsAsLinks(ctx context.Context,sst *PoSST,orientation string,start NodePtr,depth,limit int) [][]Link {
+
+	ExplainGoCall(sst,"EntireConePathsAsLinks",orientation,start,depth,limit)
+
+	search := WithContext(CopySession(sst),ctx)
+
+	return AllPathsAsLinks(&search,start,orientation,depth,limit)
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) EntireNCConePathsAsLinks(ctx context.Context,sst *PoSST,orientation string,start []NodePtr,depth int,chapter string,context []string,limit int) [][]Link {
+
+	ExplainGoCall(sst,"EntireNCConePathsAsLinks",orientation,start,depth,chapter,context,limit)
+
+	chapter,rm_acc := ChapterLikePattern(chapter)
+
+	search := WithContext(CopySession(sst),ctx)
+
+	return AllNCPathsAsLinks(&search,start,chapter,rm_acc,context,orientation,depth,limit)
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) ConstraintConePathsAsLinks(ctx context.Context,sst *PoSST,start []NodePtr,depth int,chapter string,context []string,arrows []ArrowPtr,sttypes []int,limit int) [][]Link {
+
+	ExplainGoCall(sst,"ConstraintConePathsAsLinks",start,depth,chapter,context,arrows,sttypes,limit)
+
+	chapter,rm_acc := ChapterLikePattern(chapter)
+
+	search := WithContext(CopySession(sst),ctx)
+
+	return ConstraintPathsAsLinks(&search,start,chapter,rm_acc,context,arrows,sttypes,depth,limit)
+}
+
+// **************************************************************************
+
+func (s *SQLiteStore) ConstrainedFwdLinks(ctx context.Context,sst *PoSST,start []NodePtr,chapter string,context []string,sttypes []int,arrows []ArrowPtr,maxlimit int) []Link {
+
+	ExplainGoCall(sst,"ConstrainedFwdLinks",start,chapter,context,sttypes,arrows,maxlimit)
+
+	search := WithContext(CopySession(sst),ctx)
+
+	return ConstrainedFwdLinks(&search,start,chapter,context,sttypes,arrows)
+}
+
+//
+// sqlite_store.go
+//
@@ -0,0 +1,157 @@
+//**************************************************************
+//
+// storage.go
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"context"
+	_ "github.com/lib/pq"
+
+)
+
+//**************************************************************
+// The storage backend behind a PoSST session. Postgres is the
+// original, with its PL/pgSQL helpers; the in-memory store keeps
+// the graph in Go maps so that tools can run without a database.
+//
+// Functions outside this set (appointments, stories, chapter
+// deletion, etc) still require the Postgres backend and sst.DB
+//
+// Every method but Close() runs its queries under the ctx it is
+// given, not the one carried by sst, see DBContext()
+//**************************************************************
+
+type Storage interface {
+
+	// Session
+
+	Configure(ctx context.Context,sst *PoSST,load_arrows bool) error
+	Finalize(ctx context.Context,sst *PoSST)
+	Close(sst *PoSST)
+
+	// Nodes and links
+
+	UploadNodes(ctx context.Context,sst *PoSST,nodes []Node)
+	IdempAddNode(ctx context.Context,sst *PoSST,n Node) Node
+	AppendLink(ctx context.Context,sst *PoSST,nptr NodePtr,lnk Link,sttype int) bool
+
+	// GetNode returns an empty node with only NPtr set when nptr is not
+	// stored. The bool is not "found": it reports that nptr was ambiguous
+	// (more than one row matched) and the first match was chosen, so
+	// callers test node.S == "" for existence
+
+	GetNode(ctx context.Context,sst *PoSST,nptr NodePtr) (node Node,ambiguous bool)
+
+	GetNodePtrsByName(ctx context.Context,sst *PoSST,name string) []NodePtr
+	GetNodePtrsMatching(ctx context.Context,sst *PoSST,name,chap string,cn []string,arrows []ArrowPtr,seq bool,limit int) []NodePtr
+	GetChaptersMatching(ctx context.Context,sst *PoSST,src string) []string
+	GetNodePtrsByChapter(ctx context.Context,sst *PoSST,chap string) []NodePtr
+	GetTopCPtr(ctx context.Context,sst *PoSST,channel int) ClassedNodePtr
+	GetAllNodes(ctx context.Context,sst *PoSST) []Node
+	UploadBatch(ctx context.Context,sst *PoSST,nodes []Node,links []BatchLink,chapters map[NodePtr]string,tops map[int]ClassedNodePtr,record BatchRecord) error
+
+	// Editing, see db_editing.go
+
+	SetLinks(ctx context.Context,sst *PoSST,nptr NodePtr,links []Link,sttype int) bool
+	EditNodes(ctx context.Context,sst *PoSST,edits *NodeEdits) error
+	RenameNode(ctx context.Context,sst *PoSST,nptr NodePtr,name string) bool
+	DeleteNode(ctx context.Context,sst *PoSST,nptr NodePtr) bool
+	SetNodeChapter(ctx context.Context,sst *PoSST,nptr NodePtr,chap string) bool
+	SetNodeSeq(ctx context.Context,sst *PoSST,nptr NodePtr,seq bool) bool
+	RepointPageMap(ctx context.Context,sst *PoSST,from,to NodePtr) int
+
+	// Merged nodes, see node_merging.go
+
+	UploadAlias(ctx context.Context,sst *PoSST,alias NodeAlias)
+	GetAliases(ctx context.Context,sst *PoSST,into NodePtr) []NodeAlias
+	DeleteAlias(ctx context.Context,sst *PoSST,alias NodePtr) bool
+
+	// Provenance, see provenance.go
+
+	UploadProvenance(ctx context.Context,sst *PoSST,records []Provenance)
+	GetProvenance(ctx context.Context,sst *PoSST,nptr NodePtr) []Provenance
+	DeleteProvenance(ctx context.Context,sst *PoSST,file string) int
+
+	// Change log, see changelog.go
+
+	AppendChanges(ctx context.Context,sst *PoSST,changes []Change) int64
+	GetChanges(ctx context.Context,sst *PoSST,after int64) []Change
+	GetRevisionAt(ctx context.Context,sst *PoSST,t int64) int64
+
+	// Arrows and contexts
+
+	UploadArrows(ctx context.Context,sst *PoSST)
+	DownloadArrows(ctx context.Context,sst *PoSST) ([]ArrowDirectory,map[ArrowPtr]ArrowPtr,error)
+	IdempAddContext(ctx context.Context,sst *PoSST,context string,ptr ContextPtr) ContextPtr
+	DownloadContexts(ctx context.Context,sst *PoSST) ([]ContextDirectory,error)
+	GetContextByName(ctx context.Context,sst *PoSST,name string) (string,ContextPtr)
+	GetContextByPtr(ctx context.Context,sst *PoSST,ptr ContextPtr) (string,ContextPtr)
+
+	// Page map and bookmarks
+
+	UploadPageMap(ctx context.Context,sst *PoSST,lines []PageMap)
+	GetPageMap(ctx context.Context,sst *PoSST,chap string,cn []string,page,limit int) []PageMap
+	DeletePageMap(ctx context.Context,sst *PoSST,chap string) int
+	UploadBookmarks(ctx context.Context,sst *PoSST,marks map[string]string)
+	GetBookmarks(ctx context.Context,sst *PoSST) []Bookmark
+
+	// Last seen
+
+	LastSawSection(ctx context.Context,sst *PoSST,name string)
+	LastSawNPtr(ctx context.Context,sst *PoSST,nptr NodePtr,name string)
+	GetLastSeen(ctx context.Context,sst *PoSST) []LastSeen
+	GetLastSeenNPtr(ctx context.Context,sst *PoSST,nptr NodePtr) LastSeen
+	GetNewlySeen(ctx context.Context,sst *PoSST,horizon int) map[NodePtr]bool
+
+	// Cone and path searches
+
+	FwdPathsAsLinks(ctx context.Context,sst *PoSST,start NodePtr,sttype,depth,maxlimit int) [][]Link
+	EntireConePathsAsLinks(ctx context.Context,sst *PoSST,orientation string,start NodePtr,depth,limit int) [][]Link
+	EntireNCConePathsAsLinks(ctx context.Context,sst *PoSST,orientation string,start []NodePtr,depth int,chapter string,context []string,limit int) [][]Link
+	ConstraintConePathsAsLinks(ctx context.Context,sst *PoSST,start []NodePtr,depth int,chapter string,context []string,arrows []ArrowPtr,sttypes []int,limit int) [][]Link
+	ConstrainedFwdLinks(ctx context.Context,sst *PoSST,start []NodePtr,chapter string,context []string,sttypes []int,arrows []ArrowPtr,maxlimit int) []Link
+}
+
+//**************************************************************
+
+type PostgresStore struct {
+
+	// All state lives in sst.DB and the stored PL/pgSQL functions
+}
+
+//**************************************************************
+
+func OpenStore(store Storage,load_arrows bool) PoSST {
+
+	// Open a session on any backend, e.g. OpenStore(NewMemoryStore(),true)
+
+	sst,err := OpenStoreErr(context.Background(),store,load_arrows)
+	ExitOnError(err)
+
+	return sst
+}
+
+//**************************************************************
+
+func OpenStoreErr(ctx context.Context,store Storage,load_arrows bool) (PoSST,error) {
+
+	var sst PoSST
+	err := OpenStoreSessionErr(ctx,&sst,store,load_arrows)
+
+	return sst,err
+}
+
+// **************************************************************************
+
+func OpenStoreSessionErr(ctx context.Context,sst *PoSST,store Storage,load_arrows bool) error {
+
+	sst.STORE = store
+	return InitSessionWith(ctx,sst,load_arrows)
+}
+
+//
+// storage.go
+//
@@ -0,0 +1,518 @@
+//**************************************************************
+//
+// tabular_import.go
+//
+// Turn rows of a CSV/TSV table into nodes and links, following a
+// mapping that says which column names the node, which columns
+// are linked to it by which arrows, and which give the context
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"strconv"
+	_ "github.com/lib/pq"
+
+)
+
+//**************************************************************
+
+type TableMapping struct {
+
+	Chapter  string
+	Node     string            // column whose value is the node of each row
+	Context  []string          // columns whose values become the context
+	Fixed    []string          // context words for every row
+	Split    map[string]string // multi-valued columns and their separators
+	Links    []TableLink
+}
+
+//**************************************************************
+
+type TableLink struct {
+
+	From   string    // column names, or $n for the nth column
+	Arrow  string
+	To     string
+	Arr    ArrowPtr  // filled in by ValidateTableMapping
+}
+
+//**************************************************************
+
+type TableRowLink struct {
+
+	From    string
+	Arr     ArrowPtr
+	To      string
+	Context []string
+}
+
+//**************************************************************
+
+type TableUpload struct {
+
+	Chapter  string
+	Nodes    map[string]NodePtr
+	Memory   map[NodePtr]bool  // new nodes not yet uploaded
+	Flushed  [GT1024+1]int     // directory entries already in the database
+	NewNodes int
+	Links    int
+}
+
+//**************************************************************
+// Mapping spec
+//**************************************************************
+
+func ReadTableMapping(filename string) (TableMapping,bool) {
+
+	// A mapping file reads like N4L, e.g.
+	//
+	//  - users                  # chapter
+	//  :: accounts ::           # context for every row
+	//  node: username
+	//  context: department
+	//  split: roles ;
+	//  (email) email            # from the node column
+	//  manager (manages) username
+
+	var mapping TableMapping
+
+	mapping.Split = make(map[string]string)
+
+	content,err := os.ReadFile(filename)
+
+	if err != nil {
+		fmt.Println("Unable to read table mapping",filename,err)
+		return mapping,false
+	}
+
+	ok := true
+
+	for n,line := range strings.Split(string(content),"\n") {
+
+		line = StripTableComment(line)
+
+		if len(line) == 0 {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line,"-"):
+			mapping.Chapter = strings.TrimSpace(line[1:])
+
+		case strings.HasPrefix(line,"::"):
+			words := strings.Trim(line,": ")
+			for _,w := range strings.Split(words,",") {
+				if w = strings.TrimSpace(w); w != "" {
+					mapping.Fixed = append(mapping.Fixed,w)
+				}
+			}
+
+		case strings.HasPrefix(line,"node:"):
+			mapping.Node = UnquoteTableColumn(line[len("node:"):])
+
+		case strings.HasPrefix(line,"context:"):
+			for _,col := range SplitTableColumns(line[len("context:"):]) {
+				mapping.Context = append(mapping.Context,col)
+			}
+
+		case strings.HasPrefix(line,"split:"):
+			rest := strings.TrimSpace(line[len("split:"):])
+			space := strings.LastIndexAny(rest," \t")
+			if space < 0 {
+				fmt.Printf("%s:%d: expected split: column separator\n",filename,n+1)
+				ok = false
+				continue
+			}
+			mapping.Split[UnquoteTableColumn(rest[:space])] = rest[space+1:]
+
+		default:
+			lparen := strings.Index(line,"(")
+			rparen := strings.LastIndex(line,")")
+
+			if lparen < 0 || rparen < lparen {
+				fmt.Printf("%s:%d: expected column (arrow) column, found: %s\n",filename,n+1,line)
+				ok = false
+				continue
+			}
+
+			var link TableLink
+
+			link.From = UnquoteTableColumn(line[:lparen])
+			link.Arrow = strings.TrimSpace(line[lparen+1:rparen])
+			link.To = UnquoteTableColumn(line[rparen+1:])
+
+			mapping.Links = append(mapping.Links,link)
+		}
+	}
+
+	return mapping,ok
+}
+
+//**************************************************************
+
+func StripTableComment(line string) string {
+
+	// Comments as in N4L, outside quoted column names
+
+	quoted := false
+
+	for i,r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case quoted:
+		case r == '#':
+			return strings.TrimSpace(line[:i])
+		case r == '/' && strings.HasPrefix(line[i:],"//"):
+			return strings.TrimSpace(line[:i])
+		}
+	}
+
+	return strings.TrimSpace(line)
+}
+
+//**************************************************************
+
+func SplitTableColumns(s string) []string {
+
+	var columns []string
+
+	for _,col := range strings.Split(s,",") {
+		if col = UnquoteTableColumn(col); col != "" {
+			columns = append(columns,col)
+		}
+	}
+
+	return columns
+}
+
+//**************************************************************
+
+func UnquoteTableColumn(s string) string {
+
+	s = strings.TrimSpace(s)
+
+	if len(s) > 1 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1:len(s)-1]
+	}
+
+	return s
+}
+
+//**************************************************************
+
+func ValidateTableMapping(sst *PoSST,mapping *TableMapping,header []string) (map[string]int,bool) {
+
+	// Check the columns against the table header and the arrows
+	// against the arrow directory, reporting every problem at once
+
+	var columns = make(map[string]int)
+
+	for c,name := range header {
+		columns[strings.TrimSpace(name)] = c
+		columns["$"+strconv.Itoa(c+1)] = c
+	}
+
+	ok := true
+
+	check := func(col,what string) {
+		if _,found := columns[col]; !found {
+			fmt.Printf("No column %q (%s) in table header %v\n",col,what,header)
+			ok = false
+		}
+	}
+
+	if mapping.Node == "" {
+		fmt.Println("The table mapping needs a node: column")
+		ok = false
+	} else {
+		check(mapping.Node,"node")
+	}
+
+	for _,col := range mapping.Context {
+		check(col,"context")
+	}
+
+	for col := range mapping.Split {
+		check(col,"split")
+	}
+
+	for l := range mapping.Links {
+
+		link := &mapping.Links[l]
+
+		if link.From == "" {
+			link.From = mapping.Node
+		}
+
+		check(link.From,"link from")
+		check(link.To,"link to")
+
+		arr,found := GetArrowPtrByName(sst,link.Arrow)
+
+		if !found {
+			fmt.Printf("No such arrow (%s), it needs to be defined in SSTconfig and uploaded first\n",link.Arrow)
+			ok = false
+			continue
+		}
+
+		link.Arr = arr
+	}
+
+	return columns,ok
+}
+
+//**************************************************************
+// Rows
+//**************************************************************
+
+func TableRowLinks(mapping TableMapping,columns map[string]int,row []string) []TableRowLink {
+
+	var links []TableRowLink
+
+	if len(TableCell(mapping,columns,row,mapping.Node)) == 0 {
+		return nil
+	}
+
+	context := append([]string{},mapping.Fixed...)
+
+	for _,col := range mapping.Context {
+		context = append(context,TableCell(mapping,columns,row,col)...)
+	}
+
+	for _,link := range mapping.Links {
+		for _,from := range TableCell(mapping,columns,row,link.From) {
+			for _,to := range TableCell(mapping,columns,row,link.To) {
+				if from != to {
+					links = append(links,TableRowLink{From: from, Arr: link.Arr, To: to, Context: context})
+				}
+			}
+		}
+	}
+
+	return links
+}
+
+//**************************************************************
+
+func TableCell(mapping TableMapping,columns map[string]int,row []string,col string) []string {
+
+	// The non-empty values of a cell, split if it has several
+
+	c := columns[col]
+
+	if c >= len(row) {
+		return nil
+	}
+
+	cell := strings.TrimSpace(row[c])
+
+	if cell == "" {
+		return nil
+	}
+
+	sep,multi := mapping.Split[col]
+
+	if !multi {
+		return []string{cell}
+	}
+
+	var values []string
+
+	for _,v := range strings.Split(cell,sep) {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values,v)
+		}
+	}
+
+	return values
+}
+
+//**************************************************************
+
+func TableLinksToN4L(sst *PoSST,links []TableRowLink,context *string) string {
+
+	// Context lines are only written when the context changes
+
+	var n4l string
+
+	for _,link := range links {
+
+		ctx := "any"
+
+		if len(link.Context) > 0 {
+			ctx = CompileContextString(link.Context)
+		}
+
+		if ctx != *context {
+			n4l += fmt.Sprintf("\n :: %s ::\n\n",strings.ReplaceAll(ctx,",",", "))
+			*context = ctx
+		}
+
+		n4l += fmt.Sprintf("%s (%s) %s\n",TableN4LItem(link.From),GetDBArrowByPtr(sst,link.Arr).Short,TableN4LItem(link.To))
+	}
+
+	return n4l
+}
+
+//**************************************************************
+
+func TableN4LItem(text string) string {
+
+	if NeedsN4LQuotes(text,nil) {
+		return QuoteN4LText(text)
+	}
+
+	return text
+}
+
+//**************************************************************
+// Direct upload
+//**************************************************************
+
+func NewTableUpload(sst *PoSST,chapter string) TableUpload {
+
+	var up TableUpload
+
+	up.Chapter = chapter
+	up.Nodes = make(map[string]NodePtr)
+	up.Memory = make(map[NodePtr]bool)
+
+	for class := N1GRAM; class <= GT1024; class++ {
+		up.Flushed[class] = len(*MemoryDirectory(sst,class))
+	}
+
+	return up
+}
+
+//**************************************************************
+
+func UploadTableLinks(sst *PoSST,up *TableUpload,links []TableRowLink) {
+
+	// Add to the memory directory, or straight to the database if
+	// the node is already there
+
+	for _,link := range links {
+
+		from := GetTableNode(sst,up,link.From)
+		to := GetTableNode(sst,up,link.To)
+
+		var lnk Link
+
+		lnk.Arr = link.Arr
+		lnk.Wgt = 1
+		lnk.Ctx = RegisterContext(sst,nil,link.Context)
+
+		var inv Link
+
+		inv.Arr = GetInverseArrow(sst,link.Arr)
+		inv.Wgt = 1
+		inv.Ctx = lnk.Ctx
+
+		AppendTableLink(sst,up,from,lnk,to)
+		AppendTableLink(sst,up,to,inv,from)
+
+		up.Links++
+	}
+}
+
+//**************************************************************
+
+func GetTableNode(sst *PoSST,up *TableUpload,text string) NodePtr {
+
+	if nptr,ok := up.Nodes[text]; ok {
+		return nptr
+	}
+
+	existing := GetDBNodePtrByName(CopySession(sst),text)
+
+	if len(existing) > 0 {
+		up.Nodes[text] = existing[0]
+		return existing[0]
+	}
+
+	var node Node
+
+	node.S = text
+	node.Chap = up.Chapter
+	node.L,node.NPtr.Class = StorageClass(text)
+
+	nptr := AppendTextToDirectory(sst,node,func(s string) { fmt.Println(s) })
+
+	up.Nodes[text] = nptr
+	up.Memory[nptr] = true
+	up.NewNodes++
+
+	return nptr
+}
+
+//**************************************************************
+
+func AppendTableLink(sst *PoSST,up *TableUpload,from NodePtr,lnk Link,to NodePtr) {
+
+	if up.Memory[from] {
+		AppendLinkToNode(sst,from,lnk,to)
+		return
+	}
+
+	lnk.Dst = to
+	AppendDBLinkToNode(sst,from,lnk,STIndexToSTType(GetDBArrowByPtr(sst,lnk.Arr).STAindex))
+}
+
+//**************************************************************
+
+func FlushTableUpload(sst *PoSST,up *TableUpload) {
+
+	// Upload the new nodes so far and forget their links, keeping
+	// only the names for lookup
+
+	for class := N1GRAM; class <= GT1024; class++ {
+
+		dir := MemoryDirectory(sst,class)
+
+		if len(*dir) <= up.Flushed[class] {
+			continue
+		}
+
+		UploadNodesBatch(sst,(*dir)[up.Flushed[class]:])
+
+		for n := up.Flushed[class]; n < len(*dir); n++ {
+			(*dir)[n].I = [ST_TOP][]Link{}
+		}
+
+		up.Flushed[class] = len(*dir)
+	}
+
+	up.Memory = make(map[NodePtr]bool)
+
+	UploadContextsToDB(sst)
+}
+
+//**************************************************************
+
+func MemoryDirectory(sst *PoSST,class int) *[]Node {
+
+	switch class {
+	case N1GRAM:
+		return &sst.NODE_DIRECTORY.N1directory
+	case N2GRAM:
+		return &sst.NODE_DIRECTORY.N2directory
+	case N3GRAM:
+		return &sst.NODE_DIRECTORY.N3directory
+	case LT128:
+		return &sst.NODE_DIRECTORY.LT128directory
+	case LT1024:
+		return &sst.NODE_DIRECTORY.LT1024
+	}
+
+	return &sst.NODE_DIRECTORY.GT1024
+}
+
+//
+// tabular_import.go
+//
@@ -8,6 +8,8 @@ package SSTorytime
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"unicode"
 	_ "github.com/lib/pq"
 
@@ -118,10 +120,17 @@ func NewLine(n int) {
 
 func Waiting() {
 
+	WaitingTo(os.Stdout)
+}
+
+// **************************************************************************
+
+func WaitingTo(out io.Writer) {
+
 	var propaganda = []string{"\n1) JOT IT DOWN WHEN YOU THINK OF IT. . .\n","\n2) TYPE IT INTO N4L AS SOON AS YOU CAN. . .\n","\n3) ORGANIZE AND TIDY YOUR NOTES EVERY DAY. . .\n","\n4) UPLOAD AND BROWSE THEM ONLINE. . .\n","\n5) AND REMEMBER, IT ISN'T KNOWLEDGE IF YOU DON'T ACTUALLY KNOW IT !!\n"}
 
 	for _,n := range propaganda {
-		fmt.Println(n)
+		fmt.Fprintln(out,n)
 	}
 }
 
@@ -221,7 +230,7 @@ func PrintSomeLinkPath(sst *PoSST, cone [][]Link, p int, prefix string,chapter s
 
 			nextnode := GetDBNodeByNodePtr(sst,cone[p][l].Dst)
 
-			if !SimilarString(nextnode.Chap,chapter) {
+			if !SimilarChapter(nextnode.Chap,chapter) {
 				break
 			}
 
@@ -8,7 +8,6 @@ package SSTorytime
 
 import (
 	"fmt"
-	"os"
 	"io/ioutil"
 	"strings"
 	"sort"
@@ -22,6 +21,19 @@ import (
 
 func ReadTextFile(filename string) string {
 
+	text,err := ReadTextFileErr(filename)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return text
+}
+
+//*****************************************************************
+
+func ReadTextFileErr(filename string) (string,error) {
+
 	// Read a string and strip out characters that can't be used in kenames
 	// to yield a "pure" text for n-gram classification, with fewer special chars
 	// The text marks end of sentence with a # for later splitting
@@ -29,8 +41,7 @@ func ReadTextFile(filename string) string {
 	content,err := ioutil.ReadFile(filename)
 
 	if err != nil {
-		fmt.Println("Couldn't find or open",filename)
-		os.Exit(-1)
+		return "",fmt.Errorf("%w %s: %v",ERR_NO_SUCH_FILE,filename,err)
 	}
 
 	// Start by stripping HTML / XML tags before para-split
@@ -38,7 +49,7 @@ func ReadTextFile(filename string) string {
 
 	m1 := regexp.MustCompile("<[^>]*>") 
 	cleaned := m1.ReplaceAllString(string(content),";") 
-	return cleaned
+	return cleaned,nil
 }
 
 //**************************************************************
@@ -119,7 +130,25 @@ func CleanText(s string) string {
 
 func FractionateTextFile(name string) ([][]Sentence,int) {
 
-	file := ReadTextFile(name)
+	psf,count,err := FractionateTextFileErr(name)
+
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return psf,count
+}
+
+//******************************************************************
+
+func FractionateTextFileErr(name string) ([][]Sentence,int,error) {
+
+	file,err := ReadTextFileErr(name)
+
+	if err != nil {
+		return nil,0,err
+	}
+
 	proto_text := CleanText(file)
 	pbsf := SplitIntoParaSentences(proto_text)
 
@@ -147,7 +176,7 @@ func FractionateTextFile(name string) ([][]Sentence,int) {
 		}
 	}
 
-	return pbsf,count
+	return pbsf,count,nil
 }
 
 //**************************************************************
@@ -52,15 +52,8 @@ func GetChaptersByChapContext(sst PoSST,chap string,cn []string,limit int) map[s
 
 	if chap != "any" && chap != "" {
 
-		remove_chap_accents,chap_stripped := IsBracketedSearchTerm(chap)
-
-		if remove_chap_accents {
-			chap_search := "%"+chap_stripped+"%"
-			chap_col = fmt.Sprintf("AND lower(unaccent(chap)) LIKE lower('%s')",chap_search)
-		} else {
-			chap_search := "%"+chap+"%"
-			chap_col = fmt.Sprintf("AND lower(chap) LIKE lower('%s')",chap_search)
-		}
+		chap_search,remove_chap_accents := ChapterSearchPattern(chap)
+		chap_col = fmt.Sprintf("AND match_chapter(chap,'%s',%t)",chap_search,remove_chap_accents)
 	}
 
 	if chap == "TableOfContents" {
@@ -72,7 +65,7 @@ func GetChaptersByChapContext(sst PoSST,chap string,cn []string,limit int) map[s
 
 	qstr = fmt.Sprintf("SELECT DISTINCT chap,ctx FROM PageMap WHERE match_context(ctx,%s) %s ORDER BY Chap",context,chap_col)
 
-	row, err := sst.DB.Query(qstr)
+	row, err := sst.DB.QueryContext(DBContext(&sst),qstr)
 	
 	if err != nil {
 		fmt.Println("QUERY GetChaptersByChapContext Failed",err,qstr)
@@ -187,6 +180,8 @@ func AddContext(sst *PoSST,ambient,key string,now int64,tokens []string) string
 
 	var format = make(map[string]int)
 
+	STM_LOCK.Lock()
+
 	for fr := range STM_AMB_FRAG {
 
 		if STM_AMB_FRAG[fr].Delta > FORGOTTEN {
@@ -207,6 +202,8 @@ func AddContext(sst *PoSST,ambient,key string,now int64,tokens []string) string
 		format[fr]++
 	}
 
+	STM_LOCK.Unlock()
+
 	full_context := List2String(Map2List(format))
 
 	return full_context
@@ -217,6 +214,9 @@ func AddContext(sst *PoSST,ambient,key string,now int64,tokens []string) string
 func CommitContextToken(token string,now int64,key string) {
 	
 	var last,obs History
+
+	STM_LOCK.Lock()
+	defer STM_LOCK.Unlock()
 	
 	// Check if already known ambient
 	last,already := STM_AMB_FRAG[token]
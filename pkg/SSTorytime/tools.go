@@ -543,7 +543,7 @@ func DiracNotation(s string) (bool,string,string,string) {
 			begin = params[2]			
 		default:
 			fmt.Println("Bad Dirac notation, should be <a|b> or <a|context|b>")
-			os.Exit(-1)
+			return false,"","",""
 		}
 	} else {
 		return false,"","",""
@@ -601,6 +601,142 @@ func IsBracketedSearchTerm(src string) (bool,string) {
 
 //****************************************************************************
 
+func IsNegatedSearchTerm(src string) (bool,string) {
+
+	// Inhibition: a leading ! excludes a name, chapter, context or arrow,
+	// but !term! is an exact match, so !!term! excludes an exact match
+
+	decomp := strings.TrimSpace(src)
+
+	if len(decomp) < 2 || decomp[0] != '!' {
+		return false,src
+	}
+
+	exact,_ := IsExactMatch(decomp)
+
+	if exact && !strings.HasPrefix(decomp,"!!") {
+		return false,src
+	}
+
+	return true,strings.TrimSpace(decomp[1:])
+}
+
+//****************************************************************************
+
+func SplitNegatedSearchList(list []string) ([]string,[]string) {
+
+	var include,exclude []string
+
+	for _,item := range list {
+
+		negated,stripped := IsNegatedSearchTerm(item)
+
+		if negated {
+			exclude = append(exclude,stripped)
+		} else {
+			include = append(include,item)
+		}
+	}
+
+	return include,exclude
+}
+
+//****************************************************************************
+
+func SplitChapterExclusions(chap string) (string,[]string) {
+
+	// Excluded chapters follow the chapter search as |!chapter, e.g.
+	// "notes|!draft|!old", or the chapter is just "!draft"
+
+	var include []string
+	var exclude []string
+
+	for _,part := range strings.Split(chap,"|") {
+
+		negated,stripped := IsNegatedSearchTerm(part)
+
+		if negated {
+			exclude = append(exclude,stripped)
+		} else {
+			include = append(include,part)
+		}
+	}
+
+	return strings.Join(include,"|"),exclude
+}
+
+//****************************************************************************
+
+func AddChapterExclusion(chap,exclude string) string {
+
+	if chap == "" {
+		return "!"+exclude
+	}
+
+	return chap+"|!"+exclude
+}
+
+//****************************************************************************
+
+func ChapterSearchPattern(chap string) (string,bool) {
+
+	// The LIKE pattern for a chapter search, with the excluded patterns
+	// appended after |! for match_chapter() and MatchChapter()
+
+	include,exclude := SplitChapterExclusions(chap)
+
+	remove_accents,stripped := IsBracketedSearchTerm(include)
+	pattern := "%"+stripped+"%"
+
+	for _,ex := range exclude {
+		rm_acc,ex_stripped := IsBracketedSearchTerm(ex)
+		remove_accents = remove_accents || rm_acc
+		pattern += "|!%"+ex_stripped+"%"
+	}
+
+	return pattern,remove_accents
+}
+
+//****************************************************************************
+
+func MatchChapter(chaps,pattern string,rm_acc bool) bool {
+
+	// Go version of match_chapter(). A node can belong to several chapters,
+	// so it is only excluded if none of them is left over
+
+	parts := strings.Split(pattern,"|!")
+
+	if len(parts) == 1 {
+		return LikeMatch(UnCmp(chaps,rm_acc),strings.ToLower(pattern))
+	}
+
+	for _,chap := range strings.Split(chaps,",") {
+
+		cmp := UnCmp(chap,rm_acc)
+
+		if !LikeMatch(cmp,strings.ToLower(parts[0])) {
+			continue
+		}
+
+		excluded := false
+
+		for _,ex := range parts[1:] {
+			if LikeMatch(cmp,strings.ToLower(ex)) {
+				excluded = true
+				break
+			}
+		}
+
+		if !excluded {
+			return true
+		}
+	}
+
+	return false
+}
+
+//****************************************************************************
+
 func IsExactMatch(org string) (bool,string) {
 
 	org = strings.TrimSpace(org)
@@ -732,6 +868,19 @@ func RunErr(message string) {
 
 // **************************************************************************
 
+func ExitOnError(err error) {
+
+	// For command line tools that use the non-Err wrappers, the
+	// library itself should return errors to its caller
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+}
+
+// **************************************************************************
+
 func EscapeString(s string) string {
 
 	run := []rune(s)
@@ -814,6 +963,21 @@ func MatchContexts(sst *PoSST,context1 []string,context2ptr ContextPtr) bool {
 
 	context2 := strings.Split(GetContext(sst,context2ptr),",")
 
+	// Inhibiting terms like !draft, and alternatives like prod&!staging
+
+	context1,exclude := SplitNegatedSearchList(context1)
+	context1,conjunctions := SplitQueryConjunctions(context1)
+
+	for c := range exclude {
+		if MatchesInContext(exclude[c],context2) {
+			return false
+		}
+	}
+
+	if len(exclude) > 0 && len(context1) == 0 && len(conjunctions) == 0 {
+		return true
+	}
+
 	for c := range context1 {
 
 		if MatchesInContext(context1[c],context2) {
@@ -821,6 +985,17 @@ func MatchContexts(sst *PoSST,context1 []string,context2ptr ContextPtr) bool {
 		}
 	}
 
+	match := func(term string) bool {
+		return MatchesInContext(term,context2)
+	}
+
+	for c := range conjunctions {
+
+		if MatchQueryConjunction(conjunctions[c],match) {
+			return true
+		}
+	}
+
 	return false 
 }
 
@@ -861,6 +1036,42 @@ func SimilarString(full,like string) bool {
 
 // **************************************************************************
 
+func SimilarChapter(chaps,chapter string) bool {
+
+	// SimilarString() for a chapter search with exclusions, which only
+	// rejects a node if none of its chapters is left over
+
+	include,exclude := SplitChapterExclusions(chapter)
+
+	if len(exclude) == 0 {
+		return SimilarString(chaps,chapter)
+	}
+
+	for _,chap := range strings.Split(chaps,",") {
+
+		if !SimilarString(chap,include) {
+			continue
+		}
+
+		excluded := false
+
+		for _,ex := range exclude {
+			if strings.Contains(strings.ToLower(chap),strings.ToLower(ex)) {
+				excluded = true
+				break
+			}
+		}
+
+		if !excluded {
+			return true
+		}
+	}
+
+	return false
+}
+
+// **************************************************************************
+
 func SanitizePath(s string) string {
 
 	re := regexp.MustCompile("[^a-zA-Z0-9]")
@@ -901,7 +1112,81 @@ func GetURIFile(url string) (string,error) {
 	return string(body),nil
 }
 
+// **************************************************************************
+
+func UnCmp(value string,unacc bool) string {
+
+	// Go version of the UnCmp() SQL helper for case/accent-free comparison
+
+	if unacc {
+		return strings.ToLower(Unaccent(value))
+	}
+
+	return strings.ToLower(value)
+}
+
+// **************************************************************************
+
+var UNACCENT = strings.NewReplacer(
+	"à","a","á","a","â","a","ã","a","ä","a","å","a","ā","a","ă","a","ą","a",
+	"À","A","Á","A","Â","A","Ã","A","Ä","A","Å","A","Ā","A","Ă","A","Ą","A",
+	"æ","ae","Æ","AE","ç","c","Ç","C","ć","c","Ć","C","č","c","Č","C",
+	"ď","d","Ď","D","đ","d","Đ","D","ð","d","Ð","D",
+	"è","e","é","e","ê","e","ë","e","ē","e","ė","e","ę","e","ě","e",
+	"È","E","É","E","Ê","E","Ë","E","Ē","E","Ė","E","Ę","E","Ě","E",
+	"ì","i","í","i","î","i","ï","i","ī","i","į","i","ı","i",
+	"Ì","I","Í","I","Î","I","Ï","I","Ī","I","Į","I","İ","I",
+	"ł","l","Ł","L","ñ","n","Ñ","N","ń","n","Ń","N","ň","n","Ň","N",
+	"ò","o","ó","o","ô","o","õ","o","ö","o","ø","o","ō","o","ő","o",
+	"Ò","O","Ó","O","Ô","O","Õ","O","Ö","O","Ø","O","Ō","O","Ő","O",
+	"œ","oe","Œ","OE","ř","r","Ř","R","ś","s","Ś","S","š","s","Š","S","ß","ss",
+	"ť","t","Ť","T","þ","th","Þ","TH",
+	"ù","u","ú","u","û","u","ü","u","ū","u","ů","u","ű","u","ų","u",
+	"Ù","U","Ú","U","Û","U","Ü","U","Ū","U","Ů","U","Ű","U","Ų","U",
+	"ý","y","ÿ","y","Ý","Y","ź","z","Ź","Z","ż","z","Ż","Z","ž","z","Ž","Z",
+)
+
+// **************************************************************************
+
+func Unaccent(s string) string {
+
+	// Approximates postgres unaccent() for Latin scripts, without the db
+
+	return UNACCENT.Replace(s)
+}
+
+// **************************************************************************
+
+func LikeMatch(s,pattern string) bool {
+
+	// Match an SQL LIKE pattern with % and _ wildcards, without the db
+
+	var expr strings.Builder
+
+	expr.WriteString("(?s)^")
+
+	for _,r := range pattern {
+		switch r {
+		case '%':
+			expr.WriteString(".*")
+		case '_':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	expr.WriteString("$")
+
+	matched,err := regexp.MatchString(expr.String(),s)
+
+	if err != nil {
+		return false
+	}
+
+	return matched
+}
+
 //
 // tools.go
 //
-
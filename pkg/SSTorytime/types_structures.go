@@ -7,20 +7,41 @@
 package SSTorytime
 
 import (
+	"context"
 	"database/sql"
+	"sync"
 	_ "github.com/lib/pq"
 
 )
 
 //**************************************************************
 
+type SSTError string // Sentinel errors, see globals.go
+
+func (e SSTError) Error() string {
+
+	return string(e)
+}
+
+//**************************************************************
+
 type PoSST struct {
 
 	DB *sql.DB
+	STORE Storage // Backend for nodes, links, arrows, contexts, etc
+	CTX context.Context // Cancels database queries, see WithContext()
+	EXPLAIN *SearchExplanation // Records what a search did, see WithExplanation()
+	SOURCE *Provenance // Where new nodes and links come from, see WithProvenance()
+	DIRLOCK *sync.RWMutex // Guards the directories below, shared by copies, see concurrency.go
+
+	// Uploading, set before opening, see OpenSessionErr()
+
+	WIPE bool // drop everything stored, so nothing parsed can be stored already
+	SYNC bool // parsed nodes may already be stored, see SyncGraphToDB()
 
 	// Session globals
 	
-	NODE_DIRECTORY NodeDirectory   // Internal histo-representations
+	NODE_DIRECTORY *NodeDirectory   // Internal histo-representations, shared by copies
 	NODE_CACHE map[NodePtr]NodePtr
         HWM[N_CHANNELS] ClassedNodePtr // High water mark for existing database channels
 
@@ -30,7 +51,13 @@ type PoSST struct {
 	ARROW_DIRECTORY_TOP ArrowPtr
 	INVERSE_ARROWS map[ArrowPtr]ArrowPtr
 
-	// Context array factorization
+	// Context array factorization, shared by copies
+
+	CONTEXTS *ContextTable
+
+	// Deprecated: use GetContextDirectory() and IdempContextDirectory().
+	// These follow CONTEXTS as this copy of the session changes it, see
+	// SyncContextFields(), and are not safe to read while others add contexts
 
 	CONTEXT_DIRECTORY []ContextDirectory
 	CONTEXT_DIR map[string]ContextPtr
@@ -40,6 +67,9 @@ type PoSST struct {
 	
 	PAGE_MAP []PageMap
 
+	// Origins of parsed nodes and links
+
+	PROVENANCE []Provenance
 }
 
 //******************************************************************
@@ -113,6 +143,15 @@ type ContextDirectory struct {
 
 //**************************************************************
 
+type ContextTable struct {
+
+	Directory []ContextDirectory // indexed by ContextPtr
+	Index     map[string]ContextPtr
+	Top       ContextPtr
+}
+
+//**************************************************************
+
 type ContextPtr int // ContextDirectory index
 
 //**************************************************************
@@ -227,6 +266,7 @@ type NodeEvent struct {
         NPtr    NodePtr
 	XYZ     Coords
 	Orbits  [ST_TOP][]Orbit
+	Provenance []Provenance
 }
 
 //******************************************************************
@@ -238,6 +278,7 @@ type WebConePaths struct {
 	BTWC       []string
 	Paths      [][]WebPath
 	SuperNodes []string
+	Weights    []float32 // total Link.Wgt of each path, if ranked by weight
 }
 
 //******************************************************************
@@ -0,0 +1,851 @@
+//**************************************************************
+//
+// yaml_parsing.go
+//
+// A small YAML reader for configuration-like documents: block
+// mappings and sequences, flow [..] and {..} collections, plain
+// and quoted scalars, | and > block scalars, comments, anchors
+// and aliases, and several documents separated by ---
+//
+//**************************************************************
+
+package SSTorytime
+
+import (
+	"fmt"
+	"strings"
+	"strconv"
+	_ "github.com/lib/pq"
+
+)
+
+//**************************************************************
+
+type YAMLLine struct {
+
+	Indent int
+	Text   string  // without indentation or comment
+	Raw    string  // for block scalars
+	Number int
+}
+
+//**************************************************************
+
+type YAMLParser struct {
+
+	Lines   []YAMLLine
+	Pos     int
+	Anchors map[string]*DocumentValue
+}
+
+//**************************************************************
+
+func ParseYAMLDocument(text string) (*DocumentValue,error) {
+
+	// Several documents are returned as an array of documents
+
+	var docs []*DocumentValue
+
+	for _,lines := range SplitYAMLDocuments(YAMLLines(text)) {
+
+		var p YAMLParser
+
+		p.Lines = lines
+		p.Anchors = make(map[string]*DocumentValue)
+
+		p.SkipBlank()
+
+		if p.Pos >= len(p.Lines) {
+			continue
+		}
+
+		doc,err := p.ParseBlock(p.Lines[p.Pos].Indent)
+
+		if err != nil {
+			return nil,err
+		}
+
+		p.SkipBlank()
+
+		if p.Pos < len(p.Lines) {
+			return nil,fmt.Errorf("line %d: unexpected indentation: %s",p.Lines[p.Pos].Number,p.Lines[p.Pos].Text)
+		}
+
+		docs = append(docs,doc)
+	}
+
+	switch len(docs) {
+	case 0:
+		return &DocumentValue{Kind: DOC_NULL},nil
+	case 1:
+		return docs[0],nil
+	}
+
+	return &DocumentValue{Kind: DOC_ARRAY, Items: docs},nil
+}
+
+//**************************************************************
+
+func YAMLLines(text string) []YAMLLine {
+
+	var lines []YAMLLine
+
+	for n,raw := range strings.Split(text,"\n") {
+
+		raw = strings.TrimRight(raw," \t\r")
+		content := strings.TrimLeft(raw," ")
+
+		// Blank and comment lines are kept for block scalars
+
+		lines = append(lines,YAMLLine{Indent: len(raw)-len(content), Text: StripYAMLComment(content), Raw: raw, Number: n+1})
+	}
+
+	return lines
+}
+
+//**************************************************************
+
+func SplitYAMLDocuments(lines []YAMLLine) [][]YAMLLine {
+
+	// Documents are separated by --- and may end with ...
+
+	var docs [][]YAMLLine
+	var doc []YAMLLine
+
+	for _,line := range lines {
+
+		switch {
+		case strings.HasPrefix(line.Raw,"%"):
+			continue // directives
+
+		case line.Raw == "---" || strings.HasPrefix(line.Raw,"--- "):
+			docs = append(docs,doc)
+			doc = nil
+
+			// anything after --- starts the document, e.g. --- |
+
+			if rest := strings.TrimLeft(line.Raw[3:]," "); rest != "" {
+				line.Indent = len(line.Raw) - len(rest)
+				line.Text = StripYAMLComment(rest)
+				doc = append(doc,line)
+			}
+			continue
+
+		case line.Raw == "...":
+			docs = append(docs,doc)
+			doc = nil
+			continue
+		}
+
+		doc = append(doc,line)
+	}
+
+	return append(docs,doc)
+}
+
+//**************************************************************
+
+func StripYAMLComment(s string) string {
+
+	// A # starts a comment at the start of a line or after a space,
+	// but not inside quotes
+
+	var quote rune
+
+	for i,r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			if i == 0 || strings.ContainsRune(" \t[{,:",rune(s[i-1])) {
+				quote = r
+			}
+		case r == '#':
+			if i == 0 || s[i-1] == ' ' || s[i-1] == '\t' {
+				return strings.TrimSpace(s[:i])
+			}
+		}
+	}
+
+	return strings.TrimSpace(s)
+}
+
+//**************************************************************
+
+func (p *YAMLParser) SkipBlank() {
+
+	for p.Pos < len(p.Lines) && p.Lines[p.Pos].Text == "" {
+		p.Pos++
+	}
+}
+
+//**************************************************************
+
+func (p *YAMLParser) ParseBlock(indent int) (*DocumentValue,error) {
+
+	p.SkipBlank()
+
+	if p.Pos >= len(p.Lines) || p.Lines[p.Pos].Indent < indent {
+		return &DocumentValue{Kind: DOC_NULL},nil
+	}
+
+	line := p.Lines[p.Pos]
+
+	if IsYAMLSequenceItem(line.Text) {
+		return p.ParseSequence(line.Indent)
+	}
+
+	if _,_,ok := SplitYAMLKey(line.Text); ok {
+		return p.ParseMapping(line.Indent)
+	}
+
+	// A plain or quoted scalar, possibly folded over several lines
+
+	text := line.Text
+	p.Pos++
+
+	for p.Pos < len(p.Lines) && p.Lines[p.Pos].Text != "" && p.Lines[p.Pos].Indent >= indent {
+		text += " " + p.Lines[p.Pos].Text
+		p.Pos++
+	}
+
+	return p.ParseInline(text,line.Number)
+}
+
+//**************************************************************
+
+func (p *YAMLParser) ParseSequence(indent int) (*DocumentValue,error) {
+
+	doc := &DocumentValue{Kind: DOC_ARRAY}
+
+	for {
+		p.SkipBlank()
+
+		if p.Pos >= len(p.Lines) {
+			break
+		}
+
+		line := p.Lines[p.Pos]
+
+		if line.Indent != indent || !IsYAMLSequenceItem(line.Text) {
+			break
+		}
+
+		rest := strings.TrimSpace(line.Text[1:])
+
+		var item *DocumentValue
+		var err error
+
+		if rest == "" {
+			p.Pos++
+			item,err = p.ParseBlock(indent+1)
+		} else {
+			// "- key: value" and "- - item" open a nested block at
+			// the column after the dash, so rewrite the line that way
+
+			offset := len(line.Text) - len(strings.TrimLeft(line.Text[1:]," "))
+			p.Lines[p.Pos].Indent = indent + offset
+			p.Lines[p.Pos].Text = rest
+			item,err = p.ParseBlock(indent+offset)
+		}
+
+		if err != nil {
+			return nil,err
+		}
+
+		doc.Items = append(doc.Items,item)
+	}
+
+	return doc,nil
+}
+
+//**************************************************************
+
+func (p *YAMLParser) ParseMapping(indent int) (*DocumentValue,error) {
+
+	doc := &DocumentValue{Kind: DOC_OBJECT}
+
+	for {
+		p.SkipBlank()
+
+		if p.Pos >= len(p.Lines) {
+			break
+		}
+
+		line := p.Lines[p.Pos]
+
+		if line.Indent != indent {
+			if line.Indent > indent {
+				return nil,fmt.Errorf("line %d: unexpected indentation: %s",line.Number,line.Text)
+			}
+			break
+		}
+
+		key,rest,ok := SplitYAMLKey(line.Text)
+
+		if !ok {
+			if IsYAMLSequenceItem(line.Text) {
+				break
+			}
+			return nil,fmt.Errorf("line %d: expected key: value, found %s",line.Number,line.Text)
+		}
+
+		p.Pos++
+
+		rest,anchor := YAMLAnchor(rest)
+
+		var item *DocumentValue
+		var err error
+
+		switch {
+		case rest == "":
+			// a nested block, or a sequence at the same indentation
+			p.SkipBlank()
+			if p.Pos < len(p.Lines) && p.Lines[p.Pos].Indent == indent && IsYAMLSequenceItem(p.Lines[p.Pos].Text) {
+				item,err = p.ParseSequence(indent)
+			} else {
+				item,err = p.ParseBlock(indent+1)
+			}
+
+		case rest[0] == '|' || rest[0] == '>':
+			item = p.ParseBlockScalar(rest,indent)
+
+		default:
+			item,err = p.ParseInlineLines(rest,line.Number,indent)
+		}
+
+		if err != nil {
+			return nil,err
+		}
+
+		if anchor != "" {
+			p.Anchors[anchor] = item
+		}
+
+		if key == "<<" && item.Kind == DOC_OBJECT {
+			MergeYAMLKeys(doc,item)
+			continue
+		}
+
+		SetYAMLKey(doc,key,item)
+	}
+
+	return doc,nil
+}
+
+//**************************************************************
+
+func SetYAMLKey(doc *DocumentValue,key string,item *DocumentValue) {
+
+	// Keys given explicitly override those merged with <<
+
+	for k,existing := range doc.Keys {
+		if existing == key {
+			doc.Items[k] = item
+			return
+		}
+	}
+
+	doc.Keys = append(doc.Keys,key)
+	doc.Items = append(doc.Items,item)
+}
+
+//**************************************************************
+
+func MergeYAMLKeys(doc,merge *DocumentValue) {
+
+	// The << merge key adds the keys that are not already there
+
+	for k,key := range merge.Keys {
+
+		found := false
+
+		for _,existing := range doc.Keys {
+			if existing == key {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			doc.Keys = append(doc.Keys,key)
+			doc.Items = append(doc.Items,merge.Items[k])
+		}
+	}
+}
+
+//**************************************************************
+
+func (p *YAMLParser) ParseInlineLines(text string,number,indent int) (*DocumentValue,error) {
+
+	// Flow collections and plain scalars may continue on more
+	// indented lines
+
+	for p.Pos < len(p.Lines) {
+
+		next := p.Lines[p.Pos]
+		open := YAMLFlowOpen(text)
+
+		if next.Text == "" && !open || next.Text != "" && next.Indent <= indent && !open {
+			break
+		}
+
+		if next.Text != "" {
+			text += " " + next.Text
+		}
+
+		p.Pos++
+	}
+
+	return p.ParseInline(text,number)
+}
+
+//**************************************************************
+
+func YAMLFlowOpen(text string) bool {
+
+	if text == "" || text[0] != '[' && text[0] != '{' {
+		return false
+	}
+
+	depth := 0
+	var quote byte
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		}
+	}
+
+	return depth > 0
+}
+
+//**************************************************************
+
+func (p *YAMLParser) ParseBlockScalar(header string,indent int) *DocumentValue {
+
+	// | keeps newlines and > folds them into spaces. Trailing
+	// newlines are dropped whatever the chomping indicator, since
+	// they do not belong in a node name
+
+	folded := header[0] == '>'
+
+	var lines []string
+	block := -1
+
+	for p.Pos < len(p.Lines) {
+
+		line := p.Lines[p.Pos]
+
+		if strings.TrimSpace(line.Raw) == "" {
+			lines = append(lines,"")
+			p.Pos++
+			continue
+		}
+
+		if line.Indent <= indent || block >= 0 && line.Indent < block {
+			break
+		}
+
+		if block < 0 {
+			block = line.Indent
+		}
+
+		lines = append(lines,line.Raw[block:])
+		p.Pos++
+	}
+
+	var text string
+
+	for i,line := range lines {
+		switch {
+		case i == 0:
+			text = line
+		case folded && line != "" && lines[i-1] != "":
+			text += " " + line
+		default:
+			text += "\n" + line
+		}
+	}
+
+	return &DocumentValue{Kind: DOC_SCALAR, Scalar: strings.TrimRight(text,"\n")}
+}
+
+//**************************************************************
+
+func (p *YAMLParser) ParseInline(text string,number int) (*DocumentValue,error) {
+
+	text,anchor := YAMLAnchor(text)
+
+	var flow YAMLFlow
+
+	flow.Text = text
+	flow.Parser = p
+
+	doc,err := flow.Value()
+
+	if err == nil {
+		flow.Space()
+		if flow.Pos < len(flow.Text) {
+			err = fmt.Errorf("unexpected %q",flow.Text[flow.Pos:])
+		}
+	}
+
+	if err != nil {
+		return nil,fmt.Errorf("line %d: %v",number,err)
+	}
+
+	if anchor != "" {
+		p.Anchors[anchor] = doc
+	}
+
+	return doc,nil
+}
+
+//**************************************************************
+
+func YAMLAnchor(text string) (string,string) {
+
+	// Strip &anchor and !tag prefixes, returning the anchor name
+
+	anchor := ""
+
+	for len(text) > 0 && (text[0] == '&' || text[0] == '!') {
+
+		end := strings.IndexAny(text," \t")
+
+		if end < 0 {
+			end = len(text)
+		}
+
+		if text[0] == '&' {
+			anchor = text[1:end]
+		}
+
+		text = strings.TrimSpace(text[end:])
+	}
+
+	return text,anchor
+}
+
+//**************************************************************
+
+func IsYAMLSequenceItem(text string) bool {
+
+	return text == "-" || strings.HasPrefix(text,"- ") || strings.HasPrefix(text,"-\t")
+}
+
+//**************************************************************
+
+func SplitYAMLKey(text string) (string,string,bool) {
+
+	// key: value, "key": value or 'key': value
+
+	if len(text) > 0 && (text[0] == '"' || text[0] == '\'') {
+
+		var flow YAMLFlow
+
+		flow.Text = text
+		key,err := flow.Quoted()
+
+		if err != nil {
+			return "","",false
+		}
+
+		rest := strings.TrimLeft(text[flow.Pos:]," \t")
+
+		if !strings.HasPrefix(rest,":") {
+			return "","",false
+		}
+
+		return key,strings.TrimSpace(rest[1:]),true
+	}
+
+	if len(text) > 0 && (text[0] == '[' || text[0] == '{') {
+		return "","",false
+	}
+
+	for i := 0; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ' || text[i+1] == '\t') {
+			return strings.TrimSpace(text[:i]),strings.TrimSpace(text[i+1:]),true
+		}
+	}
+
+	return "","",false
+}
+
+//**************************************************************
+// Flow style and scalars on one line
+//**************************************************************
+
+type YAMLFlow struct {
+
+	Text   string
+	Pos    int
+	Parser *YAMLParser
+}
+
+//**************************************************************
+
+func (f *YAMLFlow) Space() {
+
+	for f.Pos < len(f.Text) && (f.Text[f.Pos] == ' ' || f.Text[f.Pos] == '\t') {
+		f.Pos++
+	}
+}
+
+//**************************************************************
+
+func (f *YAMLFlow) Value() (*DocumentValue,error) {
+
+	f.Space()
+
+	if f.Pos >= len(f.Text) {
+		return &DocumentValue{Kind: DOC_NULL},nil
+	}
+
+	switch f.Text[f.Pos] {
+
+	case '[':
+		return f.Sequence()
+
+	case '{':
+		return f.Mapping()
+
+	case '"','\'':
+		s,err := f.Quoted()
+		return &DocumentValue{Kind: DOC_SCALAR, Scalar: s},err
+
+	case '*':
+		f.Pos++
+		name := f.Plain(true)
+		if f.Parser != nil {
+			if doc,ok := f.Parser.Anchors[name]; ok {
+				return doc,nil
+			}
+		}
+		return nil,fmt.Errorf("unknown alias *%s",name)
+	}
+
+	return YAMLScalar(f.Plain(f.Nested())),nil
+}
+
+//**************************************************************
+
+func (f *YAMLFlow) Nested() bool {
+
+	// Inside [..] or {..}, commas and brackets end a plain scalar
+
+	depth := 0
+
+	for i := 0; i < f.Pos; i++ {
+		switch f.Text[i] {
+		case '[','{':
+			depth++
+		case ']','}':
+			depth--
+		}
+	}
+
+	return depth > 0
+}
+
+//**************************************************************
+
+func (f *YAMLFlow) Plain(nested bool) string {
+
+	start := f.Pos
+
+	for f.Pos < len(f.Text) {
+
+		c := f.Text[f.Pos]
+
+		if nested && (c == ',' || c == ']' || c == '}') {
+			break
+		}
+
+		if nested && c == ':' && (f.Pos+1 == len(f.Text) || strings.ContainsRune(" ,]}",rune(f.Text[f.Pos+1]))) {
+			break
+		}
+
+		f.Pos++
+	}
+
+	return strings.TrimSpace(f.Text[start:f.Pos])
+}
+
+//**************************************************************
+
+func (f *YAMLFlow) Sequence() (*DocumentValue,error) {
+
+	doc := &DocumentValue{Kind: DOC_ARRAY}
+
+	f.Pos++ // [
+
+	for {
+		f.Space()
+
+		if f.Pos >= len(f.Text) {
+			return nil,fmt.Errorf("unterminated [")
+		}
+
+		if f.Text[f.Pos] == ']' {
+			f.Pos++
+			return doc,nil
+		}
+
+		item,err := f.Value()
+
+		if err != nil {
+			return nil,err
+		}
+
+		doc.Items = append(doc.Items,item)
+
+		if err := f.Separator(']'); err != nil {
+			return nil,err
+		}
+	}
+}
+
+//**************************************************************
+
+func (f *YAMLFlow) Mapping() (*DocumentValue,error) {
+
+	doc := &DocumentValue{Kind: DOC_OBJECT}
+
+	f.Pos++ // {
+
+	for {
+		f.Space()
+
+		if f.Pos >= len(f.Text) {
+			return nil,fmt.Errorf("unterminated {")
+		}
+
+		if f.Text[f.Pos] == '}' {
+			f.Pos++
+			return doc,nil
+		}
+
+		var key string
+		var err error
+
+		if c := f.Text[f.Pos]; c == '"' || c == '\'' {
+			key,err = f.Quoted()
+			if err != nil {
+				return nil,err
+			}
+		} else {
+			key = f.Plain(true)
+		}
+
+		f.Space()
+
+		item := &DocumentValue{Kind: DOC_NULL}
+
+		if f.Pos < len(f.Text) && f.Text[f.Pos] == ':' {
+			f.Pos++
+			if item,err = f.Value(); err != nil {
+				return nil,err
+			}
+		}
+
+		doc.Keys = append(doc.Keys,key)
+		doc.Items = append(doc.Items,item)
+
+		if err := f.Separator('}'); err != nil {
+			return nil,err
+		}
+	}
+}
+
+//**************************************************************
+
+func (f *YAMLFlow) Separator(end byte) error {
+
+	f.Space()
+
+	if f.Pos < len(f.Text) {
+		switch f.Text[f.Pos] {
+		case ',':
+			f.Pos++
+			return nil
+		case end:
+			return nil
+		}
+	}
+
+	return fmt.Errorf("expected , or %c at %q",end,f.Text[f.Pos:])
+}
+
+//**************************************************************
+
+func (f *YAMLFlow) Quoted() (string,error) {
+
+	// Double quotes have escapes, single quotes only double ''
+
+	quote := f.Text[f.Pos]
+	start := f.Pos
+	f.Pos++
+
+	var s strings.Builder
+
+	for f.Pos < len(f.Text) {
+
+		c := f.Text[f.Pos]
+
+		switch {
+		case c == quote && quote == '\'' && f.Pos+1 < len(f.Text) && f.Text[f.Pos+1] == '\'':
+			s.WriteByte('\'')
+			f.Pos += 2
+
+		case c == quote:
+			f.Pos++
+			if quote == '"' {
+				if u,err := strconv.Unquote(f.Text[start:f.Pos]); err == nil {
+					return u,nil
+				}
+			}
+			return s.String(),nil
+
+		case c == '\\' && quote == '"' && f.Pos+1 < len(f.Text):
+			s.WriteString(f.Text[f.Pos:f.Pos+2])
+			f.Pos += 2
+
+		default:
+			s.WriteByte(c)
+			f.Pos++
+		}
+	}
+
+	return "",fmt.Errorf("unterminated %c quote",quote)
+}
+
+//**************************************************************
+
+func YAMLScalar(s string) *DocumentValue {
+
+	switch s {
+	case "","~","null","Null","NULL":
+		return &DocumentValue{Kind: DOC_NULL}
+	}
+
+	return &DocumentValue{Kind: DOC_SCALAR, Scalar: s}
+}
+
+//
+// yaml_parsing.go
+//
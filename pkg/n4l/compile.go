@@ -0,0 +1,402 @@
+//**************************************************************
+//
+// compile.go
+//
+//**************************************************************
+
+package n4l
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	SST "github.com/markburgess/SSTorytime/pkg/SSTorytime"
+)
+
+//**************************************************************
+// Compiling notes into a Graph. A Parser reads any number of files
+// or texts into the memory of its own session, and Finish() closes
+// the inferences. An error stops the parser and a warning doesn't,
+// both are kept as Diagnostics for the command line, a web reply
+// or an editor. Nothing is written anywhere else, unless the
+// Parser is given an OUTPUT, as the N4L command does
+//**************************************************************
+
+type Diagnostic struct {
+
+	File     string
+	Line     int
+	Column   int    // of the token, from 1, or 0 when not known
+	Severity string // SEVERITY_ERROR stops the parser
+	Message  string
+}
+
+const (
+	SEVERITY_ERROR = "error"
+	SEVERITY_WARNING = "warning"
+)
+
+//**************************************************************
+
+type Graph struct {
+
+	SST         SST.PoSST // nodes, arrows, contexts, page map and provenance in memory
+	Files       []string
+	Diagnostics []Diagnostic // from the Parser, Upload() refuses a graph with errors
+	Output      io.Writer    // for the progress of Upload(), from the Parser's OUTPUT
+}
+
+type abort struct{} // unwinds the parser after an error, see ParseError()
+
+//**************************************************************
+
+func (d Diagnostic) Error() string {
+
+	return fmt.Sprintf("%s:%d:%d: %s: %s",d.File,d.Line,d.Column,d.Severity,d.Message)
+}
+
+//**************************************************************
+
+func Parse(reader io.Reader) (*Graph,[]Diagnostic) {
+
+	// Compile one source, named after a file if it is one
+
+	name := "input.n4l"
+
+	if named,ok := reader.(interface{ Name() string }); ok {
+		name = named.Name()
+	}
+
+	p := NewParser()
+	p.ParseReader(name,reader)
+
+	return p.Finish()
+}
+
+//**************************************************************
+
+func ParseFiles(filenames ...string) (*Graph,[]Diagnostic) {
+
+	p := NewParser()
+
+	for _,filename := range filenames {
+		p.ParseFile(filename)
+	}
+
+	return p.Finish()
+}
+
+//**************************************************************
+
+func NewParser() *Parser {
+
+	var p Parser
+	var err error
+
+	p.SST,err = SST.OpenStoreErr(context.Background(),SST.NewMemoryStore(),false)
+
+	p.LINE_NUM = 1
+	p.ANNOTATION = make(map[string]string)
+	p.CONTEXT_STATE = make(map[string]bool)
+
+	if err != nil {
+		p.ParseMessage(SEVERITY_ERROR,err.Error())
+		p.FAILED = true
+	}
+
+	return &p
+}
+
+//**************************************************************
+
+func (p *Parser) ParseFile(filename string) {
+
+	p.Guard(func() {
+		p.NewFile(filename)
+		p.FILES = append(p.FILES,filename)
+		input := p.ReadFile(p.CURRENT_FILE)
+		p.ParseN4L(&p.SST,input)
+	})
+}
+
+//**************************************************************
+
+func (p *Parser) ParseReader(name string,reader io.Reader) {
+
+	// As ParseFile, for notes that were sent rather than read, e.g. uploaded
+
+	p.Guard(func() {
+		p.CURRENT_FILE = name
+		input := CleanQuotes(p.ReadUTF8(reader))
+		p.NewSource(name,int64(len(input)))
+		p.FILES = append(p.FILES,name)
+		p.ParseN4L(&p.SST,input)
+	})
+}
+
+//**************************************************************
+
+func (p *Parser) Finish() (*Graph,[]Diagnostic) {
+
+	// Post process, complete NEAR cliques, unless there were errors
+
+	p.Guard(func() {
+		p.CompleteInferences(&p.SST)
+	})
+
+	var graph Graph
+
+	graph.SST = p.SST
+	graph.Files = p.FILES
+	graph.Diagnostics = p.DIAGNOSTICS
+	graph.Output = p.OUTPUT
+
+	return &graph,p.DIAGNOSTICS
+}
+
+//**************************************************************
+
+func (p *Parser) Guard(parse func()) {
+
+	// Run a parsing step, after the configuration, until the first error.
+	// Half-typed notes can still trip the parser up where it expects
+	// more, so report that as an error rather than crash the caller
+
+	if p.FAILED {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			if _,stopped := r.(abort); !stopped {
+				p.ParseMessage(SEVERITY_ERROR,fmt.Sprint("N4L parser failed here: ",r))
+				p.FAILED = true
+			}
+		}
+	}()
+
+	if !p.CONFIGURED {
+		p.CONFIGURED = true
+		p.Configure(&p.SST)
+	}
+
+	parse()
+}
+
+//**************************************************************
+
+func (p *Parser) Configure(sst *SST.PoSST) {
+
+	AddMandatory(sst)
+
+	// Load arrow configurations
+
+	config := ReadConfig()
+
+	p.CONFIGURING = true
+
+	for input := 0; input < len(config); input++ {
+		p.NewFile(config[input])
+		con := p.ReadFile(p.CURRENT_FILE)
+		p.ParseConfig(sst,con)
+	}
+
+	p.CONFIGURING = false
+}
+
+//**************************************************************
+
+func Failed(diagnostics []Diagnostic) bool {
+
+	for _,d := range diagnostics {
+		if d.Severity == SEVERITY_ERROR {
+			return true
+		}
+	}
+
+	return false
+}
+
+// **************************************************************************
+
+func (p *Parser) ParseError(message string) {
+
+	// Report and stop, only within Guard()
+
+	p.ParseMessage(SEVERITY_ERROR,message)
+	p.FAILED = true
+	panic(abort{})
+}
+
+// **************************************************************************
+
+func (p *Parser) ParseWarning(message string) {
+
+	p.ParseMessage(SEVERITY_WARNING,message)
+}
+
+// **************************************************************************
+
+func (p *Parser) ParseMessage(severity,message string) {
+
+	var d Diagnostic
+
+	d.File = p.CURRENT_FILE
+	d.Line = p.LINE_NUM
+	d.Column = p.TOKEN_COLUMN
+	d.Severity = severity
+	d.Message = message
+
+	if !p.SILENT {
+		PrintDiagnostic(OrDiscard(p.OUTPUT),d)
+	}
+
+	p.Diag("N4L",p.CURRENT_FILE,message,"at line", p.LINE_NUM)
+
+	p.DIAGNOSTICS = append(p.DIAGNOSTICS,d)
+}
+
+// **************************************************************************
+
+func PrintDiagnostic(w io.Writer,d Diagnostic) {
+
+	const red = "\033[31;1;1m"
+	const endred = "\033[0m"
+
+	fmt.Fprint(w,"\n",d.Line,":",red)
+	fmt.Fprintln(w,"N4L",d.File,d.Message,"at line", d.Line,endred)
+}
+
+//**************************************************************
+
+func OrDiscard(w io.Writer) io.Writer {
+
+	// Where a nil OUTPUT writes to
+
+	if w == nil {
+		return io.Discard
+	}
+
+	return w
+}
+
+//**************************************************************
+
+func (p *Parser) TrackColumn(src []rune,pos int) {
+
+	// Move the start of the line up to the token at pos, from where
+	// the last token was, so that each rune is looked at only once
+
+	for ; p.SCANNED_TO < pos && p.SCANNED_TO < len(src); p.SCANNED_TO++ {
+		if src[p.SCANNED_TO] == '\n' {
+			p.LINE_START = p.SCANNED_TO+1
+		}
+	}
+
+	p.TOKEN_COLUMN = pos-p.LINE_START+1
+}
+
+//**************************************************************
+
+func (p *Parser) Verbose(a ...interface{}) {
+
+	line := fmt.Sprintln(a...)
+
+	if p.DIAGNOSTIC {
+		p.AppendDiagnosticFile(line)
+	}
+
+	if p.VERBOSE {
+		fmt.Fprint(OrDiscard(p.OUTPUT),line)
+	}
+}
+
+//**************************************************************
+
+func (p *Parser) PVerbose(a ...interface{}) {
+
+	const green = "\x1b[36m"
+	const endgreen = "\x1b[0m"
+
+	if p.VERBOSE {
+		w := OrDiscard(p.OUTPUT)
+		fmt.Fprint(w,p.LINE_NUM,":\t",green)
+		fmt.Fprintln(w,a...)
+		fmt.Fprint(w,endgreen)
+	}
+}
+
+//**************************************************************
+
+func (p *Parser) Box(a ...interface{}) {
+
+	if p.VERBOSE {
+
+		w := OrDiscard(p.OUTPUT)
+		fmt.Fprintln(w,"\n------------------------------------")
+		fmt.Fprintln(w,a...)
+		fmt.Fprint(w,"------------------------------------\n\n")
+	}
+}
+
+//**************************************************************
+
+func DiagnosticName(filename string) string {
+
+	return "test_output/"+filename+"_test_log"
+
+}
+
+//**************************************************************
+
+func (p *Parser) Diag(a ...interface{}) {
+
+	// Log diagnostic output for self-diagnostic tests
+
+	if p.DIAGNOSTIC {
+		s := fmt.Sprintln(a...)
+		prefix := fmt.Sprint(p.LINE_NUM,":")
+		p.AppendDiagnosticFile(prefix+s)
+	}
+}
+
+//**************************************************************
+
+func (p *Parser) AppendDiagnosticFile(s string) {
+
+	if err := AppendStringToFile(p.TEST_DIAG_FILE,s); err != nil {
+		fmt.Fprintln(OrDiscard(p.OUTPUT),err)
+	}
+}
+
+//**************************************************************
+
+func AppendStringToFile(name string, s string) error {
+
+	// strip out \r that mess up the file format but are useful for term
+
+	san := strings.Replace(s,"\r","",-1)
+
+	f, err := os.OpenFile(name,os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+
+	if err != nil {
+		return fmt.Errorf("Couldn't open for write/append to %s: %v",name,err)
+	}
+
+	defer f.Close()
+
+	_, err = f.WriteString(san)
+
+	if err != nil {
+		return fmt.Errorf("Couldn't write/append to %s: %v",name,err)
+	}
+
+	return nil
+}
+
+//
+// compile.go
+//
@@ -0,0 +1,339 @@
+//**************************************************************
+//
+// config.go
+//
+//**************************************************************
+
+package n4l
+
+import (
+	"os"
+	"strings"
+	"unicode"
+
+	SST "github.com/markburgess/SSTorytime/pkg/SSTorytime"
+)
+
+
+//**************************************************************
+// N4L configuration
+//**************************************************************
+
+func (p *Parser) ParseConfig(sst *SST.PoSST,src []rune) {
+
+	var token string
+
+	p.LINE_START,p.SCANNED_TO = 0,0
+
+	for pos := 0; pos < len(src); {
+
+		pos,_ = p.SkipWhiteSpace(sst,src,pos)
+		p.TrackColumn(src,pos)
+		token,pos = p.GetConfigToken(src,pos)
+
+		p.ClassifyConfigRole(sst,token)
+	}
+}
+
+//**************************************************************
+
+func (p *Parser) GetConfigToken(src []rune, pos int) (string,int) {
+
+	// Handle concatenation of words/lines and separation of types
+
+	var token string
+
+	if pos >= len(src) {
+		return "", pos
+	}
+
+	switch (src[pos]) {
+
+	case '+':
+		token,pos = p.ReadToLast(src,pos,ALPHATEXT)
+
+	case '-':
+		token,pos = p.ReadToLast(src,pos,ALPHATEXT)
+
+	case '(':
+		token,pos = p.ReadToLast(src,pos,')')  // alias
+
+	case '#':
+		return "",pos
+
+	case '/':
+		if src[pos+1] == '/' {
+			return "",pos
+		}
+
+	default: // similarity
+		token,pos = p.ReadToLast(src,pos,ALPHATEXT)
+
+	}
+
+	return token, pos
+}
+
+//**************************************************************
+
+func (p *Parser) ClassifyConfigRole(sst *SST.PoSST,token string) {
+
+	if len(token) == 0 {
+		return
+	}
+
+	// Chapter definition must be at the top
+
+	if token[0] == '-' && p.LINE_ITEM_STATE == ROLE_BLANK_LINE {
+		p.SECTION_STATE = strings.TrimSpace(token[1:])
+		p.Box("Configuration of",p.SECTION_STATE)
+		p.LINE_ITEM_STATE = ROLE_SECTION
+		return
+	}
+
+	switch p.SECTION_STATE {
+
+	case "leadsto","contains","properties":
+
+		switch token[0] {
+
+		case '+':
+			p.FWD_ARROW = strings.TrimSpace(token[1:])
+			p.LINE_ITEM_STATE = HAVE_PLUS
+			p.Diag("fwd arrow in",p.SECTION_STATE, token)
+
+		case '-':
+			p.BWD_ARROW = strings.TrimSpace(token[1:])
+			p.LINE_ITEM_STATE = HAVE_MINUS
+			p.Diag("bwd arrow in",p.SECTION_STATE, token)
+
+		case '(':
+			reln := token[1:len(token)-1]
+			reln = strings.TrimSpace(reln)
+
+			if p.LINE_ITEM_STATE == HAVE_MINUS {
+				p.BWD_INDEX = SST.InsertArrowDirectory(sst,p.SECTION_STATE,reln,p.BWD_ARROW,"-")
+				p.ArrowCollision(p.BWD_INDEX,reln,p.BWD_ARROW)
+				SST.InsertInverseArrowDirectory(sst,p.FWD_INDEX,p.BWD_INDEX)
+				p.PVerbose("In",p.SECTION_STATE,"short name",reln,"for",p.BWD_ARROW,", direction","-")
+			} else if p.LINE_ITEM_STATE == HAVE_PLUS {
+				p.FWD_INDEX = SST.InsertArrowDirectory(sst,p.SECTION_STATE,reln,p.FWD_ARROW,"+")
+				p.ArrowCollision(p.FWD_INDEX,reln,p.FWD_ARROW)
+				p.PVerbose("In",p.SECTION_STATE,"short name",reln,"for",p.FWD_ARROW,", direction","+")
+			} else {
+				p.ParseError(ERR_BAD_ABBRV)
+			}
+		}
+
+	case "similarity":
+
+		switch token[0] {
+
+		case '(':
+			reln := token[1:len(token)-1]
+			reln = strings.TrimSpace(reln)
+
+			if p.LINE_ITEM_STATE == HAVE_MINUS {
+				index := SST.InsertArrowDirectory(sst,p.SECTION_STATE,reln,p.BWD_ARROW,"both")
+				SST.InsertInverseArrowDirectory(sst,index,index)
+				p.PVerbose("In",p.SECTION_STATE,reln,"for",p.BWD_ARROW,", direction","both")
+			} else {
+				p.PVerbose(p.SECTION_STATE,"abbreviation out of place")
+			}
+
+		case '+','-':
+			p.ParseError(ERR_SIMILAR_NO_SIGN)
+
+		default:
+			similarity := strings.TrimSpace(token)
+			p.FWD_ARROW = similarity
+			p.BWD_ARROW = similarity
+			p.LINE_ITEM_STATE = HAVE_MINUS
+		}
+
+	case "annotations":
+
+		switch token[0] {
+
+		case '(':
+			if p.LINE_ITEM_STATE != HAVE_PLUS {
+				p.ParseWarning(ERR_ANNOTATION_MISSING)
+			}
+
+			p.FWD_ARROW = StripParen(token)
+			p.PVerbose("Annotation marker",p.LAST_IN_SEQUENCE,"defined as arrow:",p.FWD_ARROW)
+
+			value,defined := p.ANNOTATION[p.LAST_IN_SEQUENCE]
+
+			if defined && value != p.FWD_ARROW {
+				p.ParseError(ERR_ANNOTATION_REDEFINE)
+			}
+
+			p.ANNOTATION[p.LAST_IN_SEQUENCE] = p.FWD_ARROW
+			p.LINE_ITEM_STATE = ROLE_BLANK_LINE
+
+		default:
+
+			for r := range token {
+				if unicode.IsLetter(rune(token[r])) {
+					p.ParseWarning(ERR_ANNOTATION_BAD)
+				}
+			}
+
+			if token[0] == '+' || token[0] == '-' {
+				p.ParseError(ERR_ILLEGAL_ANNOT_CHAR)
+			}
+
+			p.Diag("Markup character defined in",p.SECTION_STATE, token)
+			p.LINE_ITEM_STATE = HAVE_PLUS
+			p.LAST_IN_SEQUENCE = token
+
+		}
+
+	case "closures":
+
+		switch token[0] {
+
+		case '(':
+
+			if p.LINE_ITEM_STATE == ROLE_RESULT {
+
+				p.AddArrowClosure(sst,p.LINE_ITEM_CACHE["THIS"],token)
+			} else {
+				p.LINE_ITEM_COUNTER++
+				p.LINE_ITEM_CACHE["THIS"] = append(p.LINE_ITEM_CACHE["THIS"],token)
+				p.LINE_ITEM_STATE = ROLE_COMPOSITION
+			}
+
+		case '+',',':
+			p.LINE_ITEM_STATE = ROLE_COMPOSITION
+
+		case '=':
+			p.LINE_ITEM_STATE = ROLE_RESULT
+
+		default:
+			p.ParseError(ERR_ILLEGAL_CONFIGURATION+" "+p.SECTION_STATE)
+		}
+
+	default:
+		p.ParseError(ERR_ILLEGAL_CONFIGURATION + " " + p.SECTION_STATE)
+	}
+}
+
+//**************************************************************
+
+func (p *Parser) ArrowCollision(arr SST.ArrowPtr,short,long string) {
+
+	if arr < 0 {
+		p.ParseWarning(ERR_ARR_REDEFINITION+"long \""+long+"\", "+"short \""+short+"\" seems to be previously used somewhere")
+		//os.Exit(-1)
+	}
+}
+
+//**************************************************************
+
+func (p *Parser) AddArrowClosure(sst *SST.PoSST,sequence []string,result string) {
+
+	var closure Closure
+
+	for _,arrow := range sequence {
+		arr := p.GetLinkArrowByName(sst,arrow).Arr
+		closure.Sum += int(arr)
+		closure.Sequence = append(closure.Sequence,arr)
+	}
+
+	closure.Result = p.GetLinkArrowByName(sst,result).Arr
+
+	p.ARROW_CLOSURES = append(p.ARROW_CLOSURES,closure)
+
+	p.PVerbose("Arrow sequences",sequence,"to be closed with cyclic",result)
+}
+
+//**************************************************************
+
+func AddMandatory(sst *SST.PoSST) {
+
+	SST.RegisterContext(sst,nil,[]string{"any"})
+
+	// empty link for orphans to retain context - NB, this convention is used a lot in context handling EMPTY == LEADSTO
+
+	arr := SST.InsertArrowDirectory(sst,"leadsto","empty","debug","+")
+	inv := SST.InsertArrowDirectory(sst,"leadsto","void","unbug","-")
+	SST.InsertInverseArrowDirectory(sst,arr,inv)
+
+	arr = SST.InsertArrowDirectory(sst,"near",SST.NEAR_CAPS_S,SST.NEAR_CAPS_L,"both")
+	SST.InsertInverseArrowDirectory(sst,arr,arr)
+
+	// reserved for text2N4L
+
+	arr = SST.InsertArrowDirectory(sst,"contains",SST.CONT_FINDS_S,SST.CONT_FINDS_L,"+")
+        inv = SST.InsertArrowDirectory(sst,"contains",SST.INV_CONT_FOUND_IN_S,SST.INV_CONT_FOUND_IN_L,"-")
+	SST.InsertInverseArrowDirectory(sst,arr,inv)
+
+	arr = SST.InsertArrowDirectory(sst,"contains",SST.CONT_FRAG_S,SST.CONT_FRAG_L,"+")
+        inv = SST.InsertArrowDirectory(sst,"contains",SST.INV_CONT_FRAG_IN_S,SST.INV_CONT_FRAG_IN_L,"-")
+	SST.InsertInverseArrowDirectory(sst,arr,inv)
+
+	arr = SST.InsertArrowDirectory(sst,"properties",SST.EXPR_INTENT_S,SST.EXPR_INTENT_L,"+")
+        inv = SST.InsertArrowDirectory(sst,"properties",SST.INV_EXPR_INTENT_S,SST.INV_EXPR_INTENT_L,"-")
+	SST.InsertInverseArrowDirectory(sst,arr,inv)
+
+	arr = SST.InsertArrowDirectory(sst,"properties",SST.EXPR_AMBIENT_S,SST.EXPR_AMBIENT_L,"+")
+        inv = SST.InsertArrowDirectory(sst,"properties",SST.INV_EXPR_AMBIENT_S,SST.INV_EXPR_AMBIENT_L,"-")
+	SST.InsertInverseArrowDirectory(sst,arr,inv)
+
+	// Reserved for special UX handling
+
+	arr = SST.InsertArrowDirectory(sst,"leadsto",SEQUENCE_RELN,SEQUENCE_RELN_LONG,"+")
+	inv = SST.InsertArrowDirectory(sst,"leadsto",SEQUENCE_RELN_INV,SEQUENCE_RELN_INV_LONG,"-")
+	SST.InsertInverseArrowDirectory(sst,arr,inv)
+
+	arr = SST.InsertArrowDirectory(sst,"properties","url","has URL","+")
+	inv = SST.InsertArrowDirectory(sst,"properties","isurl","is a URL for","-")
+	SST.InsertInverseArrowDirectory(sst,arr,inv)
+
+	arr = SST.InsertArrowDirectory(sst,"properties","img","has image","+")
+	inv = SST.InsertArrowDirectory(sst,"properties","isimg","is an image for","-")
+	SST.InsertInverseArrowDirectory(sst,arr,inv)
+
+}
+
+//**************************************************************
+
+func ReadConfig() []string {
+
+	files := []string{"arrows-LT-1.sst","arrows-NR-0.sst","arrows-CN-2.sst","arrows-EP-3.sst","annotations.sst","closures.sst"}
+	dir := os.Getenv("SST_CONFIG_PATH")
+
+	var configs []string
+
+	if dir != "" {
+
+		for f := 0; f < len(files); f++ {
+			configs = append(configs,dir+"/"+files[f])
+		}
+
+	return configs
+
+	} else {
+		search_paths := []string{"./SSTconfig","../SSTconfig","../../SSTconfig"}
+
+		for p := range search_paths {
+
+			info, err := os.Stat(search_paths[p]);
+
+			if err == nil && info.IsDir() {
+				for f := 0; f < len(files); f++ {
+					configs = append(configs,search_paths[p]+"/"+files[f])
+				}
+				return configs
+			}
+		}
+	}
+
+	return []string{"no configuration file"}
+}
+
+//
+// config.go
+//
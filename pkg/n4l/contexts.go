@@ -0,0 +1,208 @@
+//**************************************************************
+//
+// contexts.go
+//
+//**************************************************************
+
+package n4l
+
+import (
+	"regexp"
+	"strings"
+)
+
+
+//**************************************************************
+// Context logic
+//**************************************************************
+
+func (p *Parser) ResetContextState() {
+
+	p.CONTEXT_STATE = make(map[string]bool)
+}
+
+//**************************************************************
+
+func (p *Parser) ContextEval(s,op string) {
+
+	if !BalancedParens(s) {
+		p.ParseError(ERR_CONTEXT_PAREN+s)
+	}
+
+	expr := CleanExpression(s)
+
+	or_parts := SplitWithParensIntact(expr,'|')
+
+	if strings.Contains(s,"(") {
+		p.ParseWarning(WARN_INADVISABLE_CONTEXT_EXPRESSION)
+	}
+
+	// +,-,= on CONTEXT_STATE
+
+	switch op {
+
+	case "=":
+		p.ResetContextState()
+		p.ModContext(or_parts,"+")
+	default:
+		p.ModContext(or_parts,op)
+	}
+}
+
+//**************************************************************
+
+func CleanExpression(s string) string {
+
+	s = TrimParen(s)
+	r1 := regexp.MustCompile("[|,]+")
+	s = r1.ReplaceAllString(s,"|")
+	r2 := regexp.MustCompile("[&]+")
+	s = r2.ReplaceAllString(s,".")
+	r3 := regexp.MustCompile("[.]+")
+	s = r3.ReplaceAllString(s,".")
+
+	return s
+}
+
+// ***********************************************************************
+
+func SplitWithParensIntact(expr string,split_ch rune) []string {
+
+	var token string = ""
+	var set []string
+
+	unicode := []rune(expr)
+
+	for c := 0; c < len(unicode); c++ {
+
+		switch unicode[c] {
+
+		case split_ch:
+			set = append(set,token)
+			token = ""
+
+		case '(':
+			subtoken,offset := Paren(unicode,c)
+			token += subtoken
+			c = offset-1
+
+		default:
+			token += string(unicode[c])
+		}
+	}
+
+	if len(token) > 0 {
+		set = append(set,token)
+	}
+
+	return set
+}
+
+// ***********************************************************************
+
+func Paren(s []rune, offset int) (string,int) {
+
+	var level int = 0
+
+	for c := offset; c < len(s); c++ {
+
+		if s[c] == '(' {
+			level++
+			continue
+		}
+
+		if s[c] == ')' {
+			level--
+			if level == 0 {
+				token := s[offset:c+1]
+				return string(token), c+1
+			}
+		}
+	}
+
+	return "bad expression", -1
+}
+
+// ***********************************************************************
+
+func TrimParen(s string) string {
+
+	var level int = 0
+	var trim = true
+
+	if len(s) == 0 {
+		return s
+	}
+
+	s = strings.TrimSpace(s)
+
+	if s[0] != '(' {
+		return s
+	}
+
+	for c := 0; c < len(s); c++ {
+
+		if s[c] == '(' {
+			level++
+			continue
+		}
+
+		if level == 0 && c < len(s)-1 {
+			trim = false
+		}
+
+		if s[c] == ')' {
+			level--
+
+			if level == 0 && c == len(s)-1 {
+
+				var token string
+
+				if trim {
+					token = s[1:len(s)-1]
+				} else {
+					token = s
+				}
+				return token
+			}
+		}
+	}
+
+	return s
+}
+
+//**************************************************************
+
+func (p *Parser) ModContext(list []string,op string) {
+
+	for or_frag := range list {
+
+		frag := strings.TrimSpace(list[or_frag])
+
+		if len(frag) == 0 {
+			continue
+		}
+
+		switch op {
+		case "+":
+			p.CONTEXT_STATE[frag] = true
+
+		case "-": // to remove, we also need to look at children
+			for cand := range p.CONTEXT_STATE {
+				and_parts := SplitWithParensIntact(cand,'.')
+
+				for part := range and_parts {
+
+					if strings.Contains(and_parts[part],frag) {
+						delete(p.CONTEXT_STATE,cand)
+					}
+				}
+			}
+		}
+
+	}
+}
+
+//
+// contexts.go
+//
@@ -0,0 +1,187 @@
+//**************************************************************
+//
+// inferences.go
+//
+//**************************************************************
+
+package n4l
+
+import (
+	"fmt"
+	"strings"
+
+	SST "github.com/markburgess/SSTorytime/pkg/SSTorytime"
+)
+
+
+//**************************************************************
+
+func (p *Parser) CompleteInferences(sst *SST.PoSST) {
+
+	for class := SST.N1GRAM; class <= SST.GT1024; class++ {
+
+		header := fmt.Sprintf("Completing node inferences and cliques.....for class %d",class)
+		p.Box(header)
+
+		switch class {
+
+		case SST.N1GRAM:
+			for _,node := range sst.NODE_DIRECTORY.N1directory {
+				p.CompleteNode(sst,node)
+				SST.CheckAltCaps(sst,node,p.ParseWarning)
+			}
+		case SST.N2GRAM:
+			for _,node := range sst.NODE_DIRECTORY.N2directory {
+				p.CompleteNode(sst,node)
+				SST.CheckAltCaps(sst,node,p.ParseWarning)
+			}
+		case SST.N3GRAM:
+			for _,node := range sst.NODE_DIRECTORY.N3directory {
+				p.CompleteNode(sst,node)
+				SST.CheckAltCaps(sst,node,p.ParseWarning)
+			}
+		case SST.LT128:
+			for _,node := range sst.NODE_DIRECTORY.LT128directory {
+				p.CompleteNode(sst,node)
+				SST.CheckAltCaps(sst,node,p.ParseWarning)
+			}
+		case SST.LT1024:
+			for _,node := range sst.NODE_DIRECTORY.LT1024 {
+				p.CompleteNode(sst,node)
+			}
+		case SST.GT1024:
+			for _,node := range sst.NODE_DIRECTORY.GT1024 {
+				p.CompleteNode(sst,node)
+			}
+		}
+	}
+}
+
+//**************************************************************
+
+func (p *Parser) CompleteNode(sst *SST.PoSST,node SST.Node) {
+
+	p.CompleteCloseness(sst,node)
+	p.CompleteSequences(sst,node)
+
+	mesg := SST.CompleteETCTypes(sst,node)
+
+	if len(mesg) > 1 {
+		p.Verbose(mesg)
+	}
+}
+
+//**************************************************************
+
+func (p *Parser) CompleteCloseness(sst *SST.PoSST,node SST.Node) {
+
+	var equivalences = make(map[SST.ArrowPtr]int)
+
+	// Only NEAR links can be completed by inference
+
+	near_nodes := node.I[SST.ST_ZERO + SST.NEAR]
+
+	if len(near_nodes) == 0 {
+		return
+	}
+
+	// Count references with same NEAR arrow type
+
+	for _,link := range near_nodes {
+		equivalences[link.Arr]++
+	}
+
+	for arrow := range equivalences {
+		if equivalences[arrow] > 1 {
+
+			var neighbours []SST.NodePtr
+
+			// Get the semamntically NEAR neighbours
+
+			for _,link := range near_nodes {
+				if link.Arr == arrow {
+					neighbours = append(neighbours,link.Dst)
+				}
+			}
+
+			// complete the subgraph
+			for n := 0; n < len(neighbours); n++ {
+				for o := n+1; o < len(neighbours); o++{
+
+					var link SST.Link
+					link.Arr = arrow
+					link.Wgt = 1
+
+					t1 := SST.GetNodeTxtFromPtr(sst,neighbours[n])
+					t2 := SST.GetNodeTxtFromPtr(sst,neighbours[o])
+					arrname := sst.ARROW_DIRECTORY[arrow].Short
+
+					// NOTs are not close
+
+					if !strings.HasPrefix(arrname,"!") {
+						m := fmt.Sprintf("   Complete: %s -(%s)-> %s",t1,arrname,t2)
+						p.Verbose(m)
+						SST.AppendLinkToNode(sst,neighbours[n],link,neighbours[o])
+					}
+				}
+			}
+		}
+	}
+}
+
+//**************************************************************
+
+func (p *Parser) CompleteSequences(sst *SST.PoSST,node SST.Node) {
+
+	for _,cl := range p.ARROW_CLOSURES {
+
+		nptr,found := GetNodePointedTo(sst,node,cl.Sequence)
+
+		if found {
+			// Link nptr to node.NPtr with cl.Result arrow
+
+			t2 := node.S
+			t1 := SST.GetNodeTxtFromPtr(sst,nptr)
+
+			var link SST.Link
+			link.Arr = cl.Result
+			link.Wgt = 1
+			link.Ctx = node.I[SST.ST_ZERO+SST.LEADSTO][0].Ctx // infer from default node context
+
+			arrname := sst.ARROW_DIRECTORY[link.Arr].Short
+
+			m := fmt.Sprintf("   Complete: %s -(%s)-> %s",t1,arrname,t2)
+			p.Verbose(m)
+			SST.AppendLinkToNode(sst,nptr,link,node.NPtr)
+		}
+	}
+}
+
+//**************************************************************
+
+func GetNodePointedTo(sst *SST.PoSST,node SST.Node,sequence []SST.ArrowPtr) (SST.NodePtr,bool) {
+
+	for _,s_arr := range sequence {
+
+		found := false
+		arrow := sst.ARROW_DIRECTORY[s_arr]
+		stindex := arrow.STAindex
+
+		for _,lnk := range node.I[stindex] {
+			if lnk.Arr == s_arr {
+				found = true
+				node = SST.GetMemoryNodeFromPtr(sst,lnk.Dst)
+			}
+		}
+
+		if !found {
+			return node.NPtr,false
+		}
+	}
+
+	return node.NPtr,true
+}
+
+//
+// inferences.go
+//
@@ -0,0 +1,167 @@
+//**************************************************************
+//
+// n4l.go
+//
+//**************************************************************
+
+// Package n4l is the N4L compiler, used by the N4L command, the web
+// server and editors. Parse() reads notes into a Graph, in memory of
+// its own, with Diagnostics for what was wrong, and Upload() stores
+// a Graph in a database session. Each Parser keeps its own state, so
+// any number can run at once
+
+package n4l
+
+import (
+	"io"
+
+	SST "github.com/markburgess/SSTorytime/pkg/SSTorytime"
+)
+
+
+//**************************************************************
+// Parsing state variables
+//**************************************************************
+
+const (
+	ALPHATEXT = 'x'
+	NON_ASCII_LQUOTE = '“'
+	NON_ASCII_RQUOTE = '”'
+        HAVE_PLUS = 11
+        HAVE_MINUS = 22
+	ROLE_ABBR = 33
+	LARGE_FILE = 500000
+
+	SEQ_UNKNOWN = false
+	SEQ_START = true
+
+	ROLE_EVENT = 1
+	ROLE_RELATION = 2
+	ROLE_SECTION = 3
+	ROLE_CONTEXT = 4
+	ROLE_CONTEXT_ADD = 5
+	ROLE_CONTEXT_SUBTRACT = 6
+	ROLE_BLANK_LINE = 7
+	ROLE_LINE_ALIAS = 8
+	ROLE_LOOKUP = 9
+
+	ROLE_COMPOSITION = 11
+	ROLE_RESULT = 12
+
+	WORD_MISTAKE_LEN = 2 // a string shorter than this is probably a mistake
+
+	WARN_NOTE_TO_SELF = "WARNING: Found a possible note to self in the text"
+	WARN_INADVISABLE_CONTEXT_EXPRESSION = "WARNING: Inadvisably complex/parenthetic context expression - simplify?"
+	WARN_CHAPTER_CLASS_MIXUP="WARNING: possible space between class cancellation -:: <class> :: ambiguous chapter name, in: "
+	ERR_CHAPTER_COMMA="You shouldn't use commas in the chapter title (ambiguous separator): "
+
+	ERR_NO_SUCH_FILE_FOUND = "No file found in the name "
+	ERR_MISSING_EVENT = "Missing item? Dangling section, relation, or context"
+	ERR_MISSING_SECTION = "Declarations outside a section or chapter"
+	ERR_NO_SUCH_ALIAS = "No such alias or \" reference exists to fill in - aborting"
+	ERR_MISSING_ITEM_SOMEWHERE = "Missing item, empty string, perhaps a missing ditto or variable reference"
+	ERR_MISSING_ITEM_RELN = "Missing item or double relation"
+	ERR_MISMATCH_QUOTE = "Apparent missing or mismatch in ', \" or ( )"
+	ERR_ILLEGAL_CONFIGURATION = "Error in configuration, no such section"
+	ERR_BAD_LABEL_OR_REF = "Badly formed label or reference (@label becomes $label.n) in "
+	ERR_ILLEGAL_QUOTED_STRING_OR_REF = "WARNING: Something wrong, bad quoted string or mistaken back reference. Double-quoted strings should not have a space after leading quote, as it can be confused with \" ditto symbol"
+	ERR_ANNOTATION_BAD = "Annotation marker should be short mark of non-space, non-alphanumeric character "
+	ERR_BAD_ABBRV = "abbreviation out of place"
+	ERR_BAD_ALIAS_REFERENCE = "Alias references start from $name.1"
+	ERR_ANNOTATION_MISSING = "Missing non-alphnumeric annotation marker or stray relation"
+	ERR_ANNOTATION_REDEFINE = "Redefinition of annotation character"
+	ERR_SIMILAR_NO_SIGN = "Arrows for similarity do not have signs, they are directionless"
+	ERR_ARROW_SELFLOOP = "Arrow's origin points to itself"
+	ERR_ARR_REDEFINITION="Warning: Redefinition of arrow "
+	ERR_NEGATIVE_WEIGHT = "Arrow relation has a negative weight, which is disallowed. Use a NOT relation if you want to signify inhibition: "
+	ERR_TOO_MANY_WEIGHTS = "More than one weight value in the arrow relation "
+        ERR_STRAY_PAREN="Stray ) in an event/item - illegal character"
+	ERR_MISSING_LINE_LABEL_IN_REFERENCE="Missing a line label in reference, should be in the form $label.n"
+	ERR_NON_WORD_WHITE="Non word (whitespace) character after an annotation: "
+	ERR_SHORT_WORD="Short word, possible mistake or mistaken annotation (try spaces around symbol): "
+	ERR_ILLEGAL_ANNOT_CHAR="Cannot use +/- reserved tokens for annotation"
+	ERR_UNCLOSED_PAREN="Unbalanced parentheses, relation ( is not closed on the same line"
+	ERR_UNCLOSED_CONTEXT="Context expression is not closed with :: on the same line"
+	ERR_EMPTY_CONTEXT="Empty context expression between :: and ::"
+	ERR_CONTEXT_PAREN="Unbalanced parentheses in context expression: "
+)
+
+//**************************************************************
+// DATA structures for input
+//**************************************************************
+
+type Closure struct {
+
+	Sequence []SST.ArrowPtr
+	Result   SST.ArrowPtr
+	Sum      int
+}
+
+//**************************************************************
+
+type Parser struct {
+
+	SST SST.PoSST // the graph being compiled, in its own memory session
+
+	LINE_NUM int
+	LINE_ITEM_CACHE map[string][]string  // contains current and labelled line elements
+	LINE_ITEM_REFS []SST.NodePtr         // contains current line integer references
+	LINE_RELN_CACHE map[string][]SST.Link
+	LINE_ITEM_STATE int
+	LINE_ALIAS string
+	LINE_ITEM_COUNTER int
+	LINE_RELN_COUNTER int
+	LINE_PATH []SST.Link
+	TOKEN_COLUMN int                     // where the current token starts on its line
+	LINE_START int                       // offset of the current line in the source, see TrackColumn()
+	SCANNED_TO int                       // how far the source has been looked at for line starts
+
+	FWD_ARROW string
+	BWD_ARROW string
+	FWD_INDEX SST.ArrowPtr
+	BWD_INDEX SST.ArrowPtr
+	ANNOTATION map[string]string
+
+	CONTEXT_STATE map[string]bool
+	SECTION_STATE string
+
+	// Sequence mode state
+
+	SEQUENCE_MODE bool
+	SEQUENCE_START bool
+	LAST_IN_SEQUENCE string
+
+	// Flags
+
+	VERBOSE bool
+	GIVE_SIGNS_OF_LIFE bool
+	DIAGNOSTIC bool
+	SILENT bool                          // keep errors to DIAGNOSTICS, e.g. for an editor
+	OUTPUT io.Writer                     // for messages and verbose output, nil for none
+
+	CONFIGURING bool
+	CONFIGURED bool
+	CURRENT_FILE string
+	TEST_DIAG_FILE string
+
+	ARROW_CLOSURES []Closure
+
+	// What was read, and the errors and warnings found there
+
+	FILES []string
+	DIAGNOSTICS []Diagnostic
+	FAILED bool
+}
+
+//**************************************************************
+
+const (
+	SEQUENCE_RELN = "then"
+	SEQUENCE_RELN_INV = "from"
+	SEQUENCE_RELN_LONG = "then followed by"
+	SEQUENCE_RELN_INV_LONG = "follows on from"
+)
+
+//
+// n4l.go
+//
@@ -0,0 +1,349 @@
+//**************************************************************
+//
+// outline.go
+//
+//**************************************************************
+
+package n4l
+
+import (
+	"strings"
+	"unicode"
+
+	SST "github.com/markburgess/SSTorytime/pkg/SSTorytime"
+)
+
+//**************************************************************
+// An outline of notes for editors: where the sections, contexts,
+// relations, aliases and references are. It is found line by line,
+// without compiling, so that the whole text is checked even when
+// the parser stops at its first error
+//**************************************************************
+
+type Mark struct {
+
+	Role   int    // ROLE_SECTION, ROLE_CONTEXT(_ADD/_SUBTRACT), ROLE_RELATION,
+	              // ROLE_LINE_ALIAS, ROLE_LOOKUP or ROLE_EVENT
+	Text   string // the name or expression, without its punctuation
+	Line   int    // from 1
+	Column int    // in runes, from 1
+	Length int    // in runes, with the punctuation
+}
+
+//**************************************************************
+
+func Outline(name string,text string) ([]Mark,[]Diagnostic) {
+
+	// Scan notes for their marks, and what is unbalanced
+
+	src := CleanQuotes([]rune(text))
+
+	var marks []Mark
+	var problems []Diagnostic
+
+	line := 1
+	linestart := 0
+	first := true
+
+	mark := func(role int,text string,from,to int) {
+		marks = append(marks,Mark{Role: role, Text: text, Line: line, Column: from-linestart+1, Length: to-from})
+	}
+
+	problem := func(severity,message string,at int) {
+		problems = append(problems,Diagnostic{File: name, Line: line, Column: at-linestart+1, Severity: severity, Message: message})
+	}
+
+	for pos := 0; pos < len(src); {
+
+		r := src[pos]
+
+		if r == '\n' {
+			line++
+			linestart = pos+1
+			first = true
+			pos++
+			continue
+		}
+
+		if unicode.IsSpace(r) {
+			pos++
+			continue
+		}
+
+		eol := EndOfLine(src,pos)
+
+		if r == '#' || (r == '/' && pos+1 < len(src) && src[pos+1] == '/') {
+			pos = eol
+			continue
+		}
+
+		start := first
+		first = false
+
+		switch {
+
+		case r == '-' && start && !strings.Contains(string(src[pos:eol]),"::"):
+
+			section := StripComment(string(src[pos+1:eol]))
+			mark(ROLE_SECTION,strings.TrimSpace(section),pos,eol)
+			pos = eol
+
+		case r == ':' || ((r == '+' || r == '-') && pos+1 < len(src) && src[pos+1] == ':'):
+
+			role := ROLE_CONTEXT
+
+			switch r {
+			case '+':
+				role = ROLE_CONTEXT_ADD
+			case '-':
+				role = ROLE_CONTEXT_SUBTRACT
+			}
+
+			open := pos+1
+
+			for open < eol && src[open] == ':' {
+				open++
+			}
+
+			shut := open
+
+			for shut+1 < eol && !(src[shut] == ':' && src[shut+1] == ':') {
+				shut++
+			}
+
+			if shut+1 >= eol {
+				problem(SEVERITY_WARNING,ERR_UNCLOSED_CONTEXT,pos)
+				pos = eol
+				continue
+			}
+
+			end := shut
+
+			for end < eol && src[end] == ':' {
+				end++
+			}
+
+			expression := strings.TrimSpace(string(src[open:shut]))
+
+			if len(expression) == 0 {
+				problem(SEVERITY_WARNING,ERR_EMPTY_CONTEXT,pos)
+			} else if !BalancedParens(expression) {
+				problem(SEVERITY_ERROR,ERR_CONTEXT_PAREN+expression,pos)
+			}
+
+			mark(role,expression,pos,end)
+			pos = end
+
+		case r == '(':
+
+			shut := pos+1
+
+			for shut < eol && src[shut] != ')' && src[shut] != '(' {
+				shut++
+			}
+
+			if shut == eol || src[shut] != ')' {
+				problem(SEVERITY_ERROR,ERR_UNCLOSED_PAREN,pos)
+				pos = shut
+				continue
+			}
+
+			mark(ROLE_RELATION,strings.TrimSpace(string(src[pos+1:shut])),pos,shut+1)
+			pos = shut+1
+
+		case r == ')':
+
+			problem(SEVERITY_ERROR,ERR_STRAY_PAREN,pos)
+			pos++
+
+		case r == '@' || r == '$':
+
+			end := pos+1
+
+			for end < eol && !unicode.IsSpace(src[end]) && src[end] != '(' {
+				end++
+			}
+
+			if r == '@' {
+				mark(ROLE_LINE_ALIAS,string(src[pos+1:end]),pos,end)
+			} else {
+				mark(ROLE_LOOKUP,string(src[pos+1:end]),pos,end)
+			}
+
+			pos = end
+
+		case IsQuote(r) && pos+1 < len(src) && !unicode.IsSpace(src[pos+1]):
+
+			// A quoted string ends at a quote before a space, maybe lines later
+
+			end := pos+1
+
+			for end < len(src) && !(src[end] == r && (end+1 == len(src) || unicode.IsSpace(src[end+1]))) {
+				end++
+			}
+
+			if end == len(src) {
+				problem(SEVERITY_ERROR,ERR_MISMATCH_QUOTE,pos)
+				pos = end
+				continue
+			}
+
+			mark(ROLE_EVENT,string(src[pos+1:end]),pos,end+1)
+
+			for i := pos; i < end; i++ {
+				if src[i] == '\n' {
+					line++
+					linestart = i+1
+				}
+			}
+
+			pos = end+1
+
+		case r == '"':
+
+			mark(ROLE_EVENT,"\"",pos,pos+1) // ditto
+			pos++
+
+		default:
+
+			end := pos
+
+			for end < eol && src[end] != '(' && src[end] != ')' && src[end] != '#' && !(src[end] == '/' && end+1 < eol && src[end+1] == '/') {
+
+				// an embedded quote is part of the item, with its parentheses
+
+				if src[end] == '"' {
+					if shut := strings.IndexRune(string(src[end+1:eol]),'"'); shut >= 0 {
+						end += len([]rune(string(src[end+1:eol])[:shut]))+1
+					}
+				}
+
+				end++
+			}
+
+			mark(ROLE_EVENT,strings.TrimSpace(string(src[pos:end])),pos,end)
+			pos = end
+		}
+	}
+
+	return marks,problems
+}
+
+//**************************************************************
+
+func CheckArrows(sst *SST.PoSST,name string,marks []Mark) []Diagnostic {
+
+	// The relations of an outline that sst doesn't know
+
+	var problems []Diagnostic
+
+	for _,m := range marks {
+
+		if m.Role != ROLE_RELATION {
+			continue
+		}
+
+		arrow := ArrowName(m.Text)
+
+		if _,ok := GetArrow(sst,arrow); ok {
+			continue
+		}
+
+		var d Diagnostic
+
+		d.File = name
+		d.Line = m.Line
+		d.Column = m.Column
+		d.Severity = SEVERITY_ERROR
+		d.Message = string(SST.ERR_NO_SUCH_ARROW)+": ("+arrow+")"
+
+		problems = append(problems,d)
+	}
+
+	return problems
+}
+
+//**************************************************************
+
+func ArrowName(relation string) string {
+
+	// The arrow of a (relation), without weights or contexts
+
+	relation = strings.TrimSpace(relation)
+
+	if strings.HasPrefix(relation,"(") && strings.HasSuffix(relation,")") {
+		relation = strings.TrimSpace(relation[1:len(relation)-1])
+	}
+
+	return strings.Split(relation,",")[0]
+}
+
+//**************************************************************
+
+func GetArrow(sst *SST.PoSST,name string) (SST.ArrowDirectory,bool) {
+
+	// By short name first, as GetLinkArrowByName() looks them up
+
+	ptr,ok := sst.ARROW_SHORT_DIR[name]
+
+	if !ok {
+		ptr,ok = sst.ARROW_LONG_DIR[name]
+	}
+
+	if !ok || int(ptr) >= len(sst.ARROW_DIRECTORY) {
+		return SST.ArrowDirectory{},false
+	}
+
+	return sst.ARROW_DIRECTORY[ptr],true
+}
+
+//**************************************************************
+
+func BalancedParens(s string) bool {
+
+	level := 0
+
+	for _,r := range s {
+		switch r {
+		case '(':
+			level++
+		case ')':
+			level--
+			if level < 0 {
+				return false
+			}
+		}
+	}
+
+	return level == 0
+}
+
+//**************************************************************
+
+func EndOfLine(src []rune,pos int) int {
+
+	for pos < len(src) && src[pos] != '\n' {
+		pos++
+	}
+
+	return pos
+}
+
+//**************************************************************
+
+func StripComment(s string) string {
+
+	// Remove a trailing # or // comment from a line
+
+	for i,r := range s {
+		if (r == '#' || strings.HasPrefix(s[i:],"//")) && (i == 0 || unicode.IsSpace(rune(s[i-1]))) {
+			return s[:i]
+		}
+	}
+
+	return s
+}
+
+//
+// outline.go
+//
@@ -0,0 +1,1364 @@
+//**************************************************************
+//
+// parser.go
+//
+//**************************************************************
+
+package n4l
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	SST "github.com/markburgess/SSTorytime/pkg/SSTorytime"
+)
+
+
+//**************************************************************
+// Parsing
+//**************************************************************
+
+func (p *Parser) NewFile(filename string) {
+
+	p.CURRENT_FILE = filename
+
+	stat, err := os.Stat(filename)
+
+	if err != nil {
+		p.ParseError(ERR_NO_SUCH_FILE_FOUND+filename)
+	}
+
+	p.NewSource(filename,stat.Size())
+}
+
+//**************************************************************
+
+func (p *Parser) NewSource(name string,size int64) {
+
+	// Reset the parser for the start of a file or text
+
+	p.CURRENT_FILE = name
+	p.TEST_DIAG_FILE = DiagnosticName(name)
+	p.GIVE_SIGNS_OF_LIFE = false
+	
+	p.Box("Parsing new file",name)
+
+	if size > LARGE_FILE {
+		p.GIVE_SIGNS_OF_LIFE = true
+	}
+
+	if !p.VERBOSE && !p.SILENT && p.GIVE_SIGNS_OF_LIFE {
+		fmt.Fprintf(OrDiscard(p.OUTPUT),"\n[%s] is a large file. This will take a while...\n",name)
+	}
+
+	p.LINE_ITEM_STATE = ROLE_BLANK_LINE
+	p.LINE_NUM = 1
+	p.LINE_ITEM_CACHE = make(map[string][]string)
+	p.LINE_RELN_CACHE = make(map[string][]SST.Link)
+	p.LINE_ITEM_REFS = nil
+	p.LINE_ITEM_COUNTER = 1
+	p.LINE_RELN_COUNTER = 0
+	p.LINE_ALIAS = ""
+	p.LAST_IN_SEQUENCE = ""
+	p.LINE_PATH = nil
+	p.SEQUENCE_MODE = false
+	p.FWD_ARROW = ""
+	p.BWD_ARROW = ""
+	p.SECTION_STATE = ""
+	p.ResetContextState()
+	p.Box("Reset context","any")
+	p.ContextEval("any","=")
+}
+
+//**************************************************************
+
+func (p *Parser) GetLinkArrowByName(sst *SST.PoSST,token string) SST.Link {
+
+	// Return a preregistered link/arrow ptr bythe name of a link
+
+	var reln []string
+	var weight float32 = 1
+	var weightcount int
+	var ctx []string
+	var name string
+
+	if token[0] == '(' {
+		name = token[1:len(token)-1]
+	} else {
+		name = token
+	}
+
+	name = strings.TrimSpace(name)
+
+	if strings.Contains(name,",") {
+		reln = strings.Split(name,",")
+		name = reln[0]
+
+		// look at any comma separated notes after the arrow name
+		for i := 1; i < len(reln); i++ {
+
+			v, err := strconv.ParseFloat(reln[i], 32)
+
+			if err == nil {
+				if weight < 0 {
+					p.ParseError(ERR_NEGATIVE_WEIGHT+token)
+				}
+				if weightcount > 1 {
+					p.ParseError(ERR_TOO_MANY_WEIGHTS+token)
+				}
+				weight = float32(v)
+				weightcount++
+			} else {
+				ctx = append(ctx,reln[i])
+			}
+		}
+	}
+
+	// First check if this is an alias/short name
+
+	ptr, ok := sst.ARROW_SHORT_DIR[name]
+
+	// If not, then check longname
+
+	if !ok {
+		ptr, ok = sst.ARROW_LONG_DIR[name]
+
+		if !ok {
+			p.ParseError(string(SST.ERR_NO_SUCH_ARROW)+": ("+name+")")
+		}
+	}
+
+	var link SST.Link
+	link.Arr = ptr
+	link.Wgt = weight
+	link.Ctx = SST.RegisterContext(sst,p.CONTEXT_STATE,ctx)
+	return link
+}
+
+//**************************************************************
+
+func (p *Parser) LookupAlias(alias string, counter int) string {
+
+	value,ok := p.LINE_ITEM_CACHE[alias]
+
+	if !ok || counter > len(value) {
+		p.ParseError(ERR_NO_SUCH_ALIAS)
+	}
+
+	return p.LINE_ITEM_CACHE[alias][counter-1]
+
+}
+
+//**************************************************************
+
+func (p *Parser) ResolveAliasedItem(token string) string {
+
+	// split $alias.n into (alias string,n int)
+
+	if! strings.Contains(token,".") {
+		// just a dollar amount
+		return token
+	}
+
+	var contig string
+	fmt.Sscanf(token,"%s",&contig)
+
+	if len(contig) == 1 {
+		return token
+	}
+
+	if contig == "$$" {
+		return token
+	}
+
+	split := strings.Split(token[1:],".")
+
+	if len(split) < 2 {
+		p.ParseError(ERR_MISSING_LINE_LABEL_IN_REFERENCE)
+	}
+
+	name := strings.TrimSpace(split[0])
+
+	var number int = 0
+	fmt.Sscanf(split[1],"%d",&number)
+
+	if number < 1 {
+		p.ParseError(ERR_BAD_ALIAS_REFERENCE)
+	}
+
+	return p.LookupAlias(name,number)
+}
+
+//**************************************************************
+// N4L language
+//**************************************************************
+
+func (p *Parser) ParseN4L(sst *SST.PoSST,src []rune) {
+
+	var token string
+	var last  rune
+
+	p.LINE_START,p.SCANNED_TO = 0,0
+
+	for pos := 0; pos < len(src); {
+
+		pos,last = p.SkipWhiteSpace(sst,src,pos)
+		p.TrackColumn(src,pos)
+		token,pos = p.GetToken(sst,src,pos)
+
+		p.ClassifyTokenRole(sst,token,last)
+	}
+
+	if p.Dangler() {
+		p.ParseWarning(ERR_MISSING_EVENT)
+	}
+}
+
+//**************************************************************
+
+func (p *Parser) SkipWhiteSpace(sst *SST.PoSST,src []rune, pos int) (int,rune) {
+
+	var last rune
+	
+	for ; pos < len(src) && IsWhiteSpace(src[pos],src[pos]); pos++ {
+
+		last = src[pos]
+		
+		if src[pos] == '\n' {
+			p.UpdateLastLineCache(sst)
+		} else {
+
+			if src[pos] == '#' || (src[pos] == '/' && src[pos+1] == '/') {
+
+				for ; pos < len(src) && src[pos] != '\n'; pos++ {
+				}
+
+				p.UpdateLastLineCache(sst)
+			}
+		}
+	}
+
+	return pos,last
+}
+
+//**************************************************************
+
+func (p *Parser) GetToken(sst *SST.PoSST,src []rune, pos int) (string,int) {
+
+	// Handle concatenation of words/lines and separation of types
+
+	var token string
+
+	if pos >= len(src) {	    // end of file
+		p.UpdateLastLineCache(sst)
+		return "", pos
+	}
+
+	switch (src[pos]) {
+
+	case '+':  // could be +::
+
+		switch (src[pos+1]) {
+
+		case ':':
+			token,pos = p.ReadToLast(src,pos,':')
+		default:
+			token,pos = p.ReadToLast(src,pos,ALPHATEXT)
+		}
+
+	case '-':  // could -:: or -section
+
+		switch (src[pos+1]) {
+
+		case ':':
+			token,pos = p.ReadToLast(src,pos,':')
+		default:
+			token,pos = p.ReadToLast(src,pos,ALPHATEXT)
+		}
+
+	case ':':
+		token,pos = p.ReadToLast(src,pos,':')
+
+	case '(':
+		token,pos = p.ReadToLast(src,pos,')')
+
+        case '"','\'':
+		quote := src[pos]
+
+		if IsQuote(quote) && IsBackReference(src,pos) {
+			token = "\""
+			pos++
+		} else {
+			if quote == '"' && pos+2 < len(src) && IsWhiteSpace(src[pos+1],src[pos+2]) {
+				p.ParseError(ERR_ILLEGAL_QUOTED_STRING_OR_REF)
+			}
+			token,pos = p.ReadToLast(src,pos,quote)
+		}
+
+	case '#':
+		return "",pos
+
+	case '/':
+		if src[pos+1] == '/' {
+			return "",pos
+		}
+
+	case '@':
+		token,pos = p.ReadToLast(src,pos,' ')
+
+	default: // a text item that could end with any of the above
+		token,pos = p.ReadToLast(src,pos,ALPHATEXT)
+
+	}
+
+	return token, pos
+}
+
+//**************************************************************
+
+func (p *Parser) ClassifyTokenRole(sst *SST.PoSST,token string,last rune) {
+
+	if len(token) == 0 {
+		return
+	}
+
+	// Chapter definition must be at the top
+
+
+	switch token[0] {
+
+	case ':':
+		expression := ExtractContextExpression(token)
+		p.CheckSequenceMode(expression,'+')
+		p.LINE_ITEM_STATE = ROLE_CONTEXT
+		p.AssessGrammarCompletions(sst,expression,p.LINE_ITEM_STATE)
+
+	case '+':
+		expression := ExtractContextExpression(token)
+		p.CheckSequenceMode(expression,'+')
+		p.LINE_ITEM_STATE = ROLE_CONTEXT_ADD
+		p.AssessGrammarCompletions(sst,expression,p.LINE_ITEM_STATE)
+
+	case '-':
+		if last == '\n' && len(token) > 0 && !strings.Contains(token,"::") {
+				p.SECTION_STATE = strings.TrimSpace(token[1:])
+				p.Box("New chapter:",p.SECTION_STATE)
+				p.LINE_ITEM_STATE = ROLE_SECTION
+				p.LINE_ITEM_COUNTER += len(token)
+				return
+		} else if token[len(token)-1:] == string(':') {
+			expression := ExtractContextExpression(token)
+			p.CheckSequenceMode(expression,'-')
+			p.LINE_ITEM_STATE = ROLE_CONTEXT_SUBTRACT
+			p.AssessGrammarCompletions(sst,expression,p.LINE_ITEM_STATE)
+		} else if len(p.SECTION_STATE) == 0 {
+			section := strings.TrimSpace(token[1:])
+			p.LINE_ITEM_STATE = ROLE_SECTION
+			p.AssessGrammarCompletions(sst,section,p.LINE_ITEM_STATE)
+		} else {
+			// The line starts with a -, but it's not a new chapter
+			p.LINE_ITEM_CACHE["THIS"] = append(p.LINE_ITEM_CACHE["THIS"],token)
+			p.StoreAlias(token)
+			p.AssessGrammarCompletions(sst,token,p.LINE_ITEM_STATE)
+
+			p.LINE_ITEM_STATE = ROLE_EVENT
+			p.LINE_ITEM_COUNTER++
+		}
+
+		// No quotes here in a string, we need to allow quoting in excerpts.
+
+	case '(':
+		if p.LINE_ITEM_STATE == ROLE_RELATION {
+			p.ParseError(ERR_MISSING_ITEM_RELN)
+		}
+		link := p.GetLinkArrowByName(sst,token)
+		p.LINE_ITEM_STATE = ROLE_RELATION
+		p.LINE_RELN_CACHE["THIS"] = append(p.LINE_RELN_CACHE["THIS"],link)
+		p.LINE_RELN_COUNTER++
+
+	case '"': // prior reference, unless it is a full quoted string
+
+		if len(token) > 1 { // quoted string: treat as an ordinary item
+			p.LINE_ITEM_CACHE["THIS"] = append(p.LINE_ITEM_CACHE["THIS"],token)
+			p.StoreAlias(token)
+			p.AssessGrammarCompletions(sst,token,p.LINE_ITEM_STATE)
+			p.LINE_ITEM_STATE = ROLE_EVENT
+			p.LINE_ITEM_COUNTER++
+			break
+		}
+		
+		result := p.LookupAlias("PREV",p.LINE_ITEM_COUNTER)
+		p.LINE_ITEM_CACHE["THIS"] = append(p.LINE_ITEM_CACHE["THIS"],result)
+		p.StoreAlias(result)
+		p.AssessGrammarCompletions(sst,result,p.LINE_ITEM_STATE)
+		p.LINE_ITEM_STATE = ROLE_EVENT
+		p.LINE_ITEM_COUNTER++
+
+	case '@':
+		p.LINE_ITEM_STATE = ROLE_LINE_ALIAS
+		token  = strings.TrimSpace(token)
+		p.LINE_ALIAS = token[1:]
+		p.CheckLineAlias(token)
+
+	case '$':
+		p.CheckLineAlias(token)
+		actual := p.ResolveAliasedItem(token)
+		p.LINE_ITEM_CACHE["THIS"] = append(p.LINE_ITEM_CACHE["THIS"],actual)
+		p.PVerbose("fyi, line reference",token,"resolved to",actual)
+		p.AssessGrammarCompletions(sst,actual,p.LINE_ITEM_STATE)
+		p.LINE_ITEM_STATE = ROLE_LOOKUP
+		p.LINE_ITEM_COUNTER++
+
+	default:
+		p.LINE_ITEM_CACHE["THIS"] = append(p.LINE_ITEM_CACHE["THIS"],token)
+		p.StoreAlias(token)
+		p.AssessGrammarCompletions(sst,token,p.LINE_ITEM_STATE)
+
+		p.LINE_ITEM_STATE = ROLE_EVENT
+		p.LINE_ITEM_COUNTER++
+	}
+}
+
+//**************************************************************
+
+func (p *Parser) AssessGrammarCompletions(sst *SST.PoSST,token string, prior_state int) {
+
+	if len(token) == 0 {
+		return
+	}
+
+	this_item := token
+
+	const BOM_UTF8 = "\xef\xbb\xbf"
+
+	if this_item == BOM_UTF8 {
+		// Skip a unicode header, needed for text editors
+		p.Verbose("Skipping embedded Unicode Header")
+		return
+	}
+
+	switch prior_state {
+
+	case ROLE_RELATION:
+
+		p.CheckNonNegative(p.LINE_ITEM_COUNTER-2)
+		last_item := p.LINE_ITEM_CACHE["THIS"][p.LINE_ITEM_COUNTER-2]
+		last_reln := p.LINE_RELN_CACHE["THIS"][p.LINE_RELN_COUNTER-1]
+		last_iptr := p.LINE_ITEM_REFS[p.LINE_ITEM_COUNTER-2]
+		this_iptr := p.HandleNode(sst,this_item)
+		const annotation = false
+		p.IdempAddLink(sst,last_item,last_iptr,last_reln,this_item,this_iptr,annotation)
+		p.CheckSection(this_item)
+
+	case ROLE_CONTEXT:
+		p.Box("Reset context: ->",this_item)
+		p.ContextEval(this_item,"=")
+		p.CheckSection(this_item)
+
+	case ROLE_CONTEXT_ADD:
+		p.Box("Add to context:",this_item)
+		p.ContextEval(this_item,"+")
+		p.CheckSection(this_item)
+
+	case ROLE_CONTEXT_SUBTRACT:
+		p.Box("Remove from context:",this_item)
+		p.ContextEval(this_item,"-")
+		p.CheckSection(this_item)
+
+	case ROLE_SECTION:
+		p.Box("Set chapter/section: ->",this_item)
+		p.CheckChapter(this_item)
+		p.SECTION_STATE = this_item
+
+	default:
+		p.CheckSection(this_item)
+
+		if p.NoteToSelf(token) {
+			p.ParseWarning(WARN_NOTE_TO_SELF+" ("+token+")")
+		}
+
+		p.HandleNode(sst,this_item)
+		p.LinkUpStorySequence(sst,this_item)
+	}
+}
+
+//**************************************************************
+
+func (p *Parser) CheckLineAlias(token string) {
+
+	var contig string
+	fmt.Sscanf(token,"%s",&contig)
+
+	if token[0] == '@' && len(contig) == 1 {
+		p.ParseError(ERR_BAD_LABEL_OR_REF+token)
+	}
+}
+
+//**************************************************************
+
+func (p *Parser) CheckChapter(name string) {
+
+	if name[0] == ':' {
+		p.ParseError(WARN_CHAPTER_CLASS_MIXUP+name)
+	}
+
+	if strings.Contains(name,",") {
+		p.ParseError(ERR_CHAPTER_COMMA+name)
+	}
+
+	p.SEQUENCE_MODE = false
+	p.SEQUENCE_START = false
+}
+
+//**************************************************************
+
+func (p *Parser) StoreAlias(name string) {
+
+	if p.LINE_ALIAS != "" {
+		p.PVerbose("-- Storing alias",p.LINE_ITEM_CACHE[p.LINE_ALIAS],name,"as",p.LINE_ALIAS)
+		p.LINE_ITEM_CACHE[p.LINE_ALIAS] = append(p.LINE_ITEM_CACHE[p.LINE_ALIAS],name)
+	}
+}
+
+//**************************************************************
+// Memory representation
+//**************************************************************
+
+func (p *Parser) IdempAddLink(sst *SST.PoSST,from string, frptr SST.NodePtr, link SST.Link,to string, toptr SST.NodePtr, is_annotation bool) {
+
+	// Add a link index cache pointer directly to a from node
+
+	if from == to {
+		p.ParseError(ERR_ARROW_SELFLOOP)
+	}
+
+	if link.Wgt != 1 {
+		p.PVerbose("... Relation:",from,"--(",sst.ARROW_DIRECTORY[link.Arr].Long,",",link.Wgt,")-> '",to,"'",sst.CONTEXTS.Directory[link.Ctx])
+	} else {
+		p.PVerbose("... Relation:",from,"--",sst.ARROW_DIRECTORY[link.Arr].Long,"-> '",to,"'",sst.CONTEXTS.Directory[link.Ctx])
+	}
+
+        // Build PageMap
+
+	link.Dst = toptr
+
+	if !is_annotation {
+		p.LINE_PATH = append(p.LINE_PATH,link)
+	}
+
+	if from == "" || to == "" {
+		p.ParseError(ERR_MISSING_ITEM_SOMEWHERE + " (adding link)")
+	}
+
+	SST.AppendLinkToNode(sst,frptr,link,toptr)
+	SST.NoteProvenance(sst,frptr,link.Arr,toptr,p.CURRENT_FILE,p.LINE_NUM)
+
+	// Double up the reverse definition for easy indexing of both in/out arrows
+	// But be careful not the make the graph undirected by mistake
+
+	invlink := p.GetLinkArrowByName(sst,sst.ARROW_DIRECTORY[sst.INVERSE_ARROWS[link.Arr]].Short)
+
+	invlink.Ctx = link.Ctx
+
+	SST.AppendLinkToNode(sst,toptr,invlink,frptr)
+
+}
+
+//**************************************************************
+
+func (p *Parser) HandleNode(sst *SST.PoSST,annotated string) SST.NodePtr {
+
+	clean_ptr,clean_version := p.IdempAddNode(sst,annotated,SEQ_UNKNOWN)
+
+	p.PVerbose("Event/item/node: \"",clean_version,"\" in chapter",p.SECTION_STATE)
+
+	p.LINE_ITEM_REFS = append(p.LINE_ITEM_REFS,clean_ptr)
+
+	SST.NoteProvenance(sst,clean_ptr,SST.NODE_PROVENANCE,SST.NO_NODE_PTR,p.CURRENT_FILE,p.LINE_NUM)
+
+	if len(clean_version) != len(annotated) {
+		p.AddBackAnnotations(sst,clean_version,clean_ptr,annotated)
+	}
+
+	p.IdempAddContextToNode(sst,clean_ptr)
+	
+	return clean_ptr
+}
+
+//**************************************************************
+
+func (p *Parser) IdempAddNode(sst *SST.PoSST,s string,intended_sequence bool) (SST.NodePtr,string) {
+
+	clean_version := p.StripAnnotations(s)
+	
+	l,c := SST.StorageClass(s)
+
+	var new_nodetext SST.Node
+	new_nodetext.S = clean_version
+	new_nodetext.L = l
+	new_nodetext.Seq = new_nodetext.Seq || intended_sequence
+	new_nodetext.Chap = p.SECTION_STATE
+	new_nodetext.NPtr.Class = c
+
+	iptr,err := SST.AppendTextToDirectoryErr(SST.DBContext(sst),sst,new_nodetext)
+
+	if err != nil {
+		p.ParseError(err.Error())
+	}
+
+	// Build page map
+
+	if p.LINE_PATH == nil {
+		var leg SST.Link
+		leg.Dst = iptr
+		p.LINE_PATH = append(p.LINE_PATH,leg)
+	}
+
+	return iptr,clean_version
+}
+
+//**************************************************************
+
+func (p *Parser) IdempAddContextToNode(sst *SST.PoSST,nptr SST.NodePtr) {
+
+	// add a nullpotent link containing root node for
+	// context membership, in case it's a singleton
+
+	var nowhere SST.NodePtr
+	var empty SST.Link
+	empty.Ctx = SST.RegisterContext(sst,p.CONTEXT_STATE,nil)
+	empty.Arr = 0
+	empty.Wgt = 1
+
+	SST.AppendLinkToNode(sst,nptr,empty,nowhere)
+}
+
+//**************************************************************
+// Scan text input
+//**************************************************************
+
+func (p *Parser) ReadFile(filename string) []rune {
+
+	text := p.ReadUTF8FileBuffered(filename)
+
+	return CleanQuotes(text)
+}
+
+//**************************************************************
+
+func CleanQuotes(text []rune) []rune {
+
+	// clean unicode nonsense
+
+	for r := range text {
+		switch text[r] {
+		case NON_ASCII_LQUOTE,NON_ASCII_RQUOTE:
+			text[r] = '"'
+		}
+	}
+
+	return text
+}
+
+
+//**************************************************************
+
+func (p *Parser) ReadToLast(src []rune,pos int, stop rune) (string,int) {
+
+	// Read until we find a terminator for this kind of token
+	// determined by "stop" signal - watch out for embedded quotes
+
+	var cpy []rune
+
+	var starting_at = p.LINE_NUM
+
+	// We have to read the string in rune form to handle unicode
+	// rune by rune to handle special cases and aggregated into cpy
+
+	for ; p.Collect(src,pos,stop,cpy) && pos < len(src); pos++ {
+
+		cpy = append(cpy,src[pos])
+
+		// if there's an embedded " quote, treat quoted section as a single character
+
+		if pos+1 < len(src) && src[pos] == '"' {
+			for i := pos+1; i < len(src); i++ {
+				if src[i] == '"' {
+					// a lone quote is just a character, not a section
+					cpy = append(cpy,src[pos+1:i+1]...)
+					pos = i
+					break
+				}
+			}
+		}
+	}
+
+	if IsQuote(stop) && src[pos-1] != stop {
+		e := fmt.Sprintf("%s starting at line %d (found token %s)",ERR_MISMATCH_QUOTE,starting_at,string(cpy))
+		p.ParseError(e)
+	}
+
+	// Tokenize the string
+
+	token := string(cpy)
+	token = strings.TrimSpace(token)
+	count := strings.Count(token,"\n")
+	p.LINE_NUM += count
+	return token,pos
+}
+
+//**************************************************************
+
+func (p *Parser) Collect(src []rune,pos int, stop rune,cpy []rune) bool {
+
+	// Generalize the stop-condition for for-loop accumulating runes
+	// when we receive the "stop" rune signal, that's the end by policy
+
+	var collect bool = true
+
+	// Quoted strings are tricky, especially when they start in the middle of another string
+
+	if IsQuote(stop) {
+		var is_end bool
+
+		if pos+1 >= len(src) {
+			is_end= true
+		} else {
+			is_end = IsWhiteSpace(src[pos],src[pos+1])
+		}
+
+		if src[pos-1] == stop && is_end {
+			return false
+		} else {
+			return true
+		}
+	}
+
+	// nothing unquoted can exceed a line length
+
+	if pos >= len(src) || src[pos] == '\n' {
+		return false
+	}
+
+	// ordinary text strings are signalled by ALPHATEXT policy
+
+	if stop == ALPHATEXT {
+		collect = p.IsGeneralString(src,pos)
+	} else {
+		// a ::: cluster is special, we don't care how many
+
+		if stop != ':' && !IsQuote(stop) {
+			return !LastSpecialChar(src,pos,stop)
+		} else {
+			var groups int = 0
+
+			for r := 1; r < len(cpy)-1; r++ {
+
+				if cpy[r] != ':' && cpy[r-1] == ':' {
+					groups++
+				}
+
+				if cpy[r] != '"' && cpy[r-1] == '"' {
+					groups++
+				}
+			}
+
+			if groups > 1 {
+				collect = !LastSpecialChar(src,pos,stop)
+			}
+		}
+	}
+
+	return collect
+}
+
+//**************************************************************
+
+func (p *Parser) IsGeneralString(src []rune,pos int) bool {
+
+	// Plain text should terminate like this, but
+	// beware of quotes inside
+
+	switch src[pos] {
+
+        case ')':
+		var before,after int
+
+		if pos-20 > 0 {
+			before = pos-20
+		} else {
+			before = 0
+		}
+
+		if pos + 20 < len(src) {
+			after = pos+20
+		} else {
+			after = len(src)-1
+		}
+
+		msg := fmt.Sprintf("%s at position %d near '...%s...'",ERR_STRAY_PAREN,pos,string(src[before:after]))
+	        p.ParseError(msg)
+	case '(':
+		return false
+	case '#':
+		return false
+	case '\n':
+		return false
+
+	case '/':
+		if src[pos+1] == '/' {
+			return false
+		}
+	}
+
+	return true
+}
+
+//**************************************************************
+
+func IsQuote(r rune) bool {
+
+	switch r {
+	case '"','\'',NON_ASCII_LQUOTE,NON_ASCII_RQUOTE:
+		return true
+	}
+
+	return false
+}
+
+//**************************************************************
+
+func LastSpecialChar(src []rune,pos int, stop rune) bool {
+
+	if src[pos] == '\n' {
+		if stop != '"' {
+			return true
+		}
+	}
+
+	// Special case, but still don't understand why?!
+
+	if src[pos] == '@' {
+		return false
+	}
+
+	if pos > 0 && src[pos-1] == stop && src[pos] != stop {
+		return true
+	}
+
+	return false
+}
+
+//**************************************************************
+
+func (p *Parser) UpdateLastLineCache(sst *SST.PoSST,) {
+
+	if p.Dangler() {
+		p.ParseWarning(ERR_MISSING_EVENT)
+	}
+
+	if !p.CONFIGURING {
+		p.PageMap(sst,p.SECTION_STATE,p.CONTEXT_STATE,p.LINE_PATH,p.LINE_NUM,p.LINE_ALIAS)
+	}
+
+	p.LINE_NUM++
+
+	// If this line was not blank, overwrite previous settings and reset
+
+	if p.LINE_ITEM_STATE != ROLE_BLANK_LINE {
+
+		if p.LINE_ITEM_CACHE["THIS"] != nil {
+			p.LINE_ITEM_CACHE["PREV"] = p.LINE_ITEM_CACHE["THIS"]
+		}
+		if p.LINE_RELN_CACHE["THIS"] != nil {
+			p.LINE_RELN_CACHE["PREV"] = p.LINE_RELN_CACHE["THIS"]
+		}
+	}
+
+	p.LINE_ITEM_CACHE["THIS"] = nil
+	p.LINE_RELN_CACHE["THIS"] = nil
+	p.LINE_ITEM_REFS = nil
+	p.LINE_ITEM_COUNTER = 1
+	p.LINE_RELN_COUNTER = 0
+	p.LINE_ALIAS = ""
+	p.LINE_PATH = nil
+
+	p.LINE_ITEM_STATE = ROLE_BLANK_LINE
+
+}
+
+//**************************************************************
+
+func (p *Parser) PageMap(sst *SST.PoSST,chapter string,ctxmap map[string]bool,path []SST.Link,line int,alias string) {
+
+	if len(path) == 0 {
+		return
+	}
+
+	var page_event SST.PageMap;
+	var context []string
+	var contextstr string
+
+	for c := range ctxmap {
+		context = append(context,c)
+	}
+
+	sort.Strings(context)
+
+	for c := 0; c < len(context); c++ {
+		contextstr += context[c]
+		if c < len(context)-1 {
+			contextstr += ", "
+		}
+	}
+
+	page_event.Chapter = chapter
+	page_event.Alias = alias
+	page_event.Context = SST.RegisterContext(sst,p.CONTEXT_STATE,nil)
+	page_event.Line = line
+	page_event.Path = path
+
+	sst.PAGE_MAP = append(sst.PAGE_MAP,page_event)
+}
+
+//**************************************************************
+
+func IsWhiteSpace(r,rn rune) bool {
+
+	return (unicode.IsSpace(r) || r == '#' || r == '/' && rn == '/')
+}
+
+//**************************************************************
+
+func IsBackReference(src []rune,pos int) bool {
+
+	// Any non-whitespace before \n or ( means it's not a back reference
+
+	for pos++; pos < len(src); pos++ {
+
+		if src[pos] == '(' || src[pos] == '\n' || src[pos] == '#' {
+			return true
+		} else {
+			if !unicode.IsSpace(src[pos]) {
+				return false
+			}
+		}
+	}
+
+	return false
+}
+
+//**************************************************************
+
+func (p *Parser) Dangler() bool {
+
+	switch p.LINE_ITEM_STATE {
+
+	case ROLE_EVENT:
+		return false
+	case ROLE_LOOKUP:
+		return false
+	case ROLE_BLANK_LINE:
+		return false
+	case ROLE_SECTION:
+		return false
+	case ROLE_CONTEXT:
+		return false
+	case ROLE_CONTEXT_ADD:
+		return false
+	case ROLE_CONTEXT_SUBTRACT:
+		return false
+	case HAVE_MINUS:
+		return false
+	case ROLE_RESULT:
+		return false
+	}
+
+	return true
+}
+
+//**************************************************************
+
+func ExtractContextExpression(token string) string {
+
+	var expression string
+
+	s := strings.Split(token, ":")
+
+	for i := 1; i < len(s); i++ {
+		if len(s[i]) > 1 {
+			expression = strings.TrimSpace(s[i])
+			break
+		}
+	}
+
+	return expression
+}
+
+//**************************************************************
+
+func (p *Parser) CheckSequenceMode(context string, mode rune) {
+
+	if (strings.Contains(context,"_sequence_")) {
+
+		switch mode {
+		case '+':
+			p.PVerbose("\nStart sequence mode for items")
+			p.SEQUENCE_MODE = true
+			p.SEQUENCE_START = true
+			p.LAST_IN_SEQUENCE = ""
+
+		case '-':
+			p.PVerbose("End sequence mode for items\n")
+			p.SEQUENCE_MODE = false
+			p.SEQUENCE_START = false
+		}
+	}
+
+}
+
+//**************************************************************
+
+func (p *Parser) LinkUpStorySequence(sst *SST.PoSST,this string) {
+
+	// Join together a sequence of nodes using default "(then)"
+
+	if p.SEQUENCE_MODE && this != p.LAST_IN_SEQUENCE {
+
+		if p.LINE_ITEM_COUNTER == 1 && p.LAST_IN_SEQUENCE != "" {
+
+			p.PVerbose("* ... Sequence addition: ",p.LAST_IN_SEQUENCE,"-(",SEQUENCE_RELN,")->",this,"\n")
+
+			var last_iptr SST.NodePtr
+
+			if p.SEQUENCE_START {
+				last_iptr,_ = p.IdempAddNode(sst,p.LAST_IN_SEQUENCE,SEQ_START)
+				p.SEQUENCE_START = false
+			} else {
+				last_iptr,_ = p.IdempAddNode(sst,p.LAST_IN_SEQUENCE,SEQ_UNKNOWN)
+			}
+
+			this_iptr,_ := p.IdempAddNode(sst,this,SEQ_UNKNOWN)
+			link := p.GetLinkArrowByName(sst,"(then)")
+			SST.AppendLinkToNode(sst,last_iptr,link,this_iptr)
+			SST.NoteProvenance(sst,last_iptr,link.Arr,this_iptr,p.CURRENT_FILE,p.LINE_NUM)
+
+			invlink := p.GetLinkArrowByName(sst,sst.ARROW_DIRECTORY[sst.INVERSE_ARROWS[link.Arr]].Short)
+			SST.AppendLinkToNode(sst,this_iptr,invlink,last_iptr)
+
+		}
+
+		p.LAST_IN_SEQUENCE = this
+	}
+}
+
+//**************************************************************
+
+func (p *Parser) StripAnnotations(fulltext string) string {
+
+	var protected bool = false
+	var deloused []rune
+
+	if fulltext[0] == fulltext[len(fulltext)-1] {
+		switch fulltext[0] {
+		case '"','\'':
+			if len(fulltext) > 1 {
+				fulltext = fulltext[1:len(fulltext)-1]
+				protected = true
+			}
+		}
+	}
+	
+	var preserve_unicode = []rune(fulltext)
+
+	for r := 0; r < len(preserve_unicode); r++ {
+
+		if preserve_unicode[r] == '"' {
+			protected = !protected
+		        // here: drop surrounding quotes		  
+            		// drop only the surrounding quote pair; embedded quotes stay
+		}
+
+		if !protected {
+			skip,symb := p.EmbeddedSymbol(preserve_unicode,r)
+
+			if skip > 0 {
+				r += skip-1
+				if unicode.IsSpace(preserve_unicode[r]) {
+					p.ParseWarning(ERR_NON_WORD_WHITE+symb)
+				}
+				continue
+			}
+		}
+
+		deloused = append(deloused,preserve_unicode[r])
+	}
+
+	return string(deloused)
+}
+
+//**************************************************************
+
+func (p *Parser) AddBackAnnotations(sst *SST.PoSST,cleantext string,cleanptr SST.NodePtr,annotated string) {
+
+	var protected bool = false
+
+	reminder := fmt.Sprintf("%.30s...",cleantext)
+	p.PVerbose("\n        Checking annotations from \""+reminder+"\"")
+
+	for r := 0; r < len(annotated); r++ {
+
+		if annotated[r] == '"' {
+			protected = !protected
+		} else {
+			if !protected {
+				skip,symb := p.EmbeddedSymbol([]rune(annotated),r)
+
+				if skip > 0 {
+					link := p.GetLinkArrowByName(sst,p.ANNOTATION[symb])
+					this_item := p.ExtractWord(annotated,r+skip)
+
+					if len(this_item) <= WORD_MISTAKE_LEN {
+						err := fmt.Sprintf("%s \"%s\"  after annotation %s, len %d",ERR_SHORT_WORD,this_item,symb,skip)
+						p.ParseWarning(err)
+					}
+
+					this_iptr,_ := p.IdempAddNode(sst,this_item,SEQ_UNKNOWN)
+					const is_annotation = true
+					p.IdempAddLink(sst,reminder,cleanptr,link,this_item,this_iptr,is_annotation)
+					r += skip-1
+					continue
+				}
+			}
+		}
+	}
+}
+
+//**************************************************************
+
+func (p *Parser) EmbeddedSymbol(runetext []rune,offset int) (int,string) {
+
+	if offset >= len(runetext) {
+		return 0,"end of string"
+	}
+
+	var found_len int
+	var found string
+
+	for an := range p.ANNOTATION {
+
+		// Careful of unicode, convert to runes
+
+		uni := []rune(an)
+		match := runetext[offset] == uni[0]
+
+		for r := 0; r < len(uni) && r+offset < len(runetext); r++ {
+
+			if uni[r] != runetext[offset+r] {
+				match = false
+				continue
+			}
+
+			if offset+r >= len(runetext)-1 {
+				match = false
+				continue
+			}
+
+			// No space between marker and text
+			if offset+r+1 < len(runetext) && unicode.IsSpace(runetext[offset+r+1]) {
+				match = false
+				continue
+			}
+		}
+
+		// There might still be another longer greedy match
+
+		if match && len(an) > found_len {
+			found = an
+			found_len = len(an)
+			match = false
+		}
+	}
+
+	if len(found) > 0 {
+		return found_len,found
+	}
+
+	return 0,"UNKNOWN SYMBOL"
+}
+
+//**************************************************************
+
+func (p *Parser) ExtractWord(fulltext string,offset int) string {
+
+	var protected bool = false
+	var end_of_protection = false
+
+	runetext := []rune(fulltext)
+	var word []rune
+	var pair_quote string
+
+	for r := offset; r < len(runetext); r++ {
+
+		if runetext[r] == '"' || runetext[r] == '\'' {
+			if protected {
+				end_of_protection = true
+			}
+			protected = !protected
+			pair_quote = string(runetext[r]) + " "
+			continue
+		}
+
+		// end of protection catches quote parts not ending in spaces
+		
+		if !protected && unicode.IsSpace(rune(runetext[r])) || !protected && end_of_protection {
+
+			sword := strings.Trim(strings.TrimSpace(string(word)),pair_quote)
+			return sword
+		}
+
+		word = append(word,runetext[r])
+	}
+
+	sword := strings.Trim(strings.TrimSpace(string(word)),pair_quote)
+
+	if len(sword) <= WORD_MISTAKE_LEN {
+		p.ParseWarning(ERR_SHORT_WORD+"\""+sword+"\"")
+	}
+
+	return sword
+}
+
+//**************************************************************
+
+func (p *Parser) CheckNonNegative(i int) {
+
+	if i < 0 {
+		p.ParseError(ERR_MISSING_ITEM_SOMEWHERE)
+	}
+}
+
+//**************************************************************
+
+func (p *Parser) CheckSection(item string) {
+
+	if len(p.SECTION_STATE) == 0 {
+		p.ParseError(ERR_MISSING_SECTION)
+	}
+}
+
+//**************************************************************
+
+func (p *Parser) NoteToSelf(s string) bool {
+
+	if len(s) <= 2 * WORD_MISTAKE_LEN {
+		return false
+	}
+
+	const intentionality_threshold = 50
+
+	if (len(s) > intentionality_threshold) && s[len(s)-1] == '.' {
+		return false
+	}
+
+	for _, r := range s {
+
+		if !unicode.IsUpper(r) && (unicode.IsLetter(r) || unicode.IsNumber(r)) {
+			return false
+		}
+	}
+
+	// Don't repeat the same message for multi-line dittos
+
+	if p.LINE_ITEM_CACHE["THIS"] != nil && p.LINE_ITEM_CACHE["PREV"] != nil {
+		if p.LINE_ITEM_CACHE["THIS"][0] == p.LINE_ITEM_CACHE["PREV"][0] {
+			return false
+		}
+	}
+
+	return true
+}
+
+//**************************************************************
+
+func StripParen(token string) string {
+
+	token =	strings.TrimSpace(token[1:])
+
+	if token[0] == '(' {
+		token =	strings.TrimSpace(token[1:])
+	}
+
+	if token[len(token)-1] == ')' {
+		token =	token[:len(token)-1]
+	}
+
+	return token
+}
+
+//**************************************************************
+
+func (p *Parser) ReadUTF8FileBuffered(filename string) []rune {
+
+	// Open a stream to the file instead of reading it all at once.
+
+	file, err := os.Open(filename)
+
+	if err != nil {
+		p.ParseError(ERR_NO_SUCH_FILE_FOUND + filename)
+	}
+
+	defer file.Close()
+
+	return p.ReadUTF8(file)
+}
+
+//**************************************************************
+
+func (p *Parser) ReadUTF8(reader io.Reader) []rune {
+
+	// Create a new scanner to read from the stream.
+
+	scanner := bufio.NewScanner(reader)
+
+	// Configure the scanner to split the input by Unicode runes, not lines.
+
+	scanner.Split(bufio.ScanRunes)
+
+	var unicode []rune
+
+	// The scanner reads one rune at a time until the end of the file.
+
+	for scanner.Scan() {
+
+		// Get the text for the current rune
+
+		runeText := scanner.Text()
+
+		// Decode the string (which contains one rune) to a rune value
+
+		r, _ := utf8.DecodeRuneInString(runeText)
+
+		unicode = append(unicode, r)
+	}
+
+	// Check for any errors that occurred during scanning.
+
+	if err := scanner.Err(); err != nil {
+
+		p.ParseError(fmt.Sprintf("Error reading file %s: %v", p.CURRENT_FILE, err))
+	}
+
+	return unicode
+}
+
+//
+// parser.go
+//
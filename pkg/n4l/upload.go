@@ -0,0 +1,392 @@
+//**************************************************************
+//
+// upload.go
+//
+//**************************************************************
+
+package n4l
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	SST "github.com/markburgess/SSTorytime/pkg/SSTorytime"
+)
+
+//**************************************************************
+
+const ERR_CHAPTER_EXISTS SST.SSTError = "Database already contains a chapter"
+const ERR_GRAPH_FAILED SST.SSTError = "The notes have errors, so nothing was stored"
+
+//**************************************************************
+// Storing a Graph. The parser numbers nodes, arrows and contexts in
+// its own session, so MergeGraph() renumbers them into the session
+// they are stored by, after what it has already stored. Progress
+// goes to the graph's Output
+//**************************************************************
+
+func Upload(sst SST.PoSST,graph *Graph,force bool) error {
+
+	// Store a compiled graph, unless it has errors or would add to
+	// stored chapters
+
+	if err := CheckGraph(graph); err != nil {
+		return err
+	}
+
+	out := OrDiscard(graph.Output)
+	dbchapters := SST.GetDBChaptersMatchingName(sst,"")
+	memchapters := GetMemChapters(graph.SST)
+
+	var conflicts []string
+
+	for m := range memchapters {
+		for d := range dbchapters {
+			if memchapters[m] == dbchapters[d] {
+
+				fmt.Fprintln(out," Database already contains a chapter: ",dbchapters[d])
+				conflicts = append(conflicts,dbchapters[d])
+			}
+		}
+	}
+
+	if len(conflicts) > 0 && !force {
+		return fmt.Errorf("%w: %s",ERR_CHAPTER_EXISTS,strings.Join(conflicts,", "))
+	}
+
+	if err := MergeGraph(&sst,graph); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out,"\n\nUploading nodes..")
+
+	return SST.GraphToDB(sst,out)
+}
+
+//**************************************************************
+
+func CheckGraph(graph *Graph) error {
+
+	// The first error found while compiling, if any
+
+	for _,d := range graph.Diagnostics {
+		if d.Severity == SEVERITY_ERROR {
+			return fmt.Errorf("%w: %w",ERR_GRAPH_FAILED,d)
+		}
+	}
+
+	return nil
+}
+
+//**************************************************************
+
+func SyncUpload(sst SST.PoSST,graph *Graph) error {
+
+	// Apply only the differences from what is stored for these
+	// chapters, where the graph's nodes may already be stored
+
+	if err := CheckGraph(graph); err != nil {
+		return err
+	}
+
+	sst.SYNC = true
+
+	out := OrDiscard(graph.Output)
+
+	if err := MergeGraph(&sst,graph); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out,"\n\nSynchronizing nodes..")
+
+	report,err := SST.SyncGraphToDB(sst,out)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out,"\nChanges:")
+	fmt.Fprint(out,SST.FormatSyncReport(report))
+
+	return nil
+}
+
+//**************************************************************
+
+func UploadBookMarks(sst SST.PoSST,out io.Writer) error {
+
+	// Store the bookmarks file beside the notes, for the command line
+	// tools, skipping those already stored. Any that could be read
+	// are stored even if a file could not be
+
+	out = OrDiscard(out)
+	marks,failed := GetBookMarks()
+
+	for _,b := range sst.STORE.GetBookmarks(SST.DBContext(&sst),&sst) {
+		if marks[b.Bookmark] == b.Query {
+			delete(marks,b.Bookmark)
+		}
+	}
+
+	fmt.Fprintln(out,"\n\nUploading bookmarks..")
+	SST.BookmarksToDB(sst,marks)
+
+	return failed
+}
+
+//**************************************************************
+
+func MergeGraph(sst *SST.PoSST,graph *Graph) error {
+
+	// Add a graph's nodes, links, page map and provenance to sst's
+	// directories, which GraphToDB() then stores from sst.HWM
+
+	from := &graph.SST
+
+	// Arrows, by name, so that those sst knows keep their numbers
+
+	arrows := make(map[SST.ArrowPtr]SST.ArrowPtr)
+	declared := make(map[SST.ArrowPtr]bool)
+
+	for _,a := range from.ARROW_DIRECTORY {
+
+		ptr := SST.InsertArrowDirectoryByIndex(sst,a.STAindex,a.Short,a.Long)
+
+		if ptr != SST.ArrowPtr(-1) {
+			arrows[a.Ptr] = ptr
+			declared[a.Ptr] = true
+			continue
+		}
+
+		// One of the names means another arrow here, use that
+
+		ptr,ok := sst.ARROW_SHORT_DIR[a.Short]
+
+		if !ok {
+			ptr,ok = sst.ARROW_LONG_DIR[a.Long]
+		}
+
+		if !ok {
+			return fmt.Errorf("%w: (%s)",SST.ERR_NO_SUCH_ARROW,a.Long)
+		}
+
+		arrows[a.Ptr] = ptr
+	}
+
+	for fwd,bwd := range from.INVERSE_ARROWS {
+		if declared[fwd] && declared[bwd] {
+			SST.InsertInverseArrowDirectory(sst,arrows[fwd],arrows[bwd])
+		}
+	}
+
+	// Contexts, by their normalized strings
+
+	contexts := make(map[SST.ContextPtr]SST.ContextPtr)
+
+	for _,cd := range SST.GetContextDirectory(from) {
+		contexts[cd.Ptr] = SST.IdempContextDirectory(sst,cd.Context)
+	}
+
+	// Nodes, then the links between them
+
+	parsed := SST.ParsedNodes(from)
+	nodes := make(map[SST.NodePtr]SST.NodePtr)
+
+	for _,node := range parsed {
+
+		event := node
+		event.I = [SST.ST_TOP][]SST.Link{}
+
+		nptr,err := SST.AppendTextToDirectoryErr(SST.DBContext(sst),sst,event)
+
+		if err != nil {
+			return NodeDiagnostic(from,node.NPtr,err)
+		}
+
+		nodes[node.NPtr] = nptr
+	}
+
+	node := func(nptr SST.NodePtr) SST.NodePtr {
+
+		if nptr.Class == 0 {
+			return nptr // nowhere, or no node
+		}
+
+		return nodes[nptr]
+	}
+
+	link := func(lnk SST.Link) SST.Link {
+
+		lnk.Arr = arrows[lnk.Arr]
+		lnk.Ctx = contexts[lnk.Ctx]
+		lnk.Dst = node(lnk.Dst)
+		return lnk
+	}
+
+	for _,n := range parsed {
+		for st := range n.I {
+			for _,lnk := range n.I[st] {
+				mapped := link(lnk)
+				SST.AppendLinkToNode(sst,node(n.NPtr),mapped,mapped.Dst)
+			}
+		}
+	}
+
+	// Where the lines were written, and by whom
+
+	for _,event := range from.PAGE_MAP {
+
+		event.Context = contexts[event.Context]
+
+		var path []SST.Link
+
+		for _,lnk := range event.Path {
+			path = append(path,link(lnk))
+		}
+
+		event.Path = path
+		sst.PAGE_MAP = append(sst.PAGE_MAP,event)
+	}
+
+	for _,p := range from.PROVENANCE {
+
+		p.NPtr = node(p.NPtr)
+		p.Dst = node(p.Dst)
+
+		if p.Arr != SST.NODE_PROVENANCE {
+			p.Arr = arrows[p.Arr]
+		}
+
+		sst.PROVENANCE = append(sst.PROVENANCE,p)
+	}
+
+	return nil
+}
+
+//**************************************************************
+
+func NodeDiagnostic(sst *SST.PoSST,nptr SST.NodePtr,err error) Diagnostic {
+
+	// Say where a node was written, when it can't be stored
+
+	var d Diagnostic
+
+	d.Severity = SEVERITY_ERROR
+	d.Message = err.Error()
+
+	for _,p := range sst.PROVENANCE {
+		if p.NPtr == nptr && p.Arr == SST.NODE_PROVENANCE {
+			d.File = p.File
+			d.Line = p.Line
+			break
+		}
+	}
+
+	return d
+}
+
+//**************************************************************
+
+func GetBookMarks() (map[string]string,error) {
+
+	// Any that could be read, and the last file or line that couldn't,
+	// as a Diagnostic for a line
+
+	var failed error
+
+	marks := make(map[string]string)
+	
+	search_paths := []string{"./SSTconfig","../SSTconfig","../../SSTconfig"}
+
+	for p := range search_paths {
+
+		filename := fmt.Sprintf("%s/bookmarks.sst",search_paths[p]);
+
+		info, err := os.Stat(filename)
+		
+		if err == nil && !info.IsDir() {
+			
+			file, err := os.Open(filename)
+
+			if err != nil {
+				failed = fmt.Errorf("Error opening file: %w",err)
+				continue
+			}
+
+			defer file.Close()
+			scanner := bufio.NewScanner(file)
+
+			for lineno := 1; scanner.Scan(); lineno++ {
+				line := scanner.Text()
+
+				const silly = 10
+				
+				if len(line) > silly {
+					s := strings.SplitN(line,":",2)
+
+					if len(s) < 2 {
+						failed = Diagnostic{File: filename,Line: lineno,Severity: SEVERITY_WARNING,
+							Message: "A bookmark needs a name and a query, separated by ':'"}
+						continue
+					}
+
+					key := strings.TrimSpace(s[0])
+					value := strings.TrimSpace(s[1])
+					if len(key) > 0 && len(value) > 0 {
+						marks[key] = value
+					}
+				}
+			}
+		}
+	}
+
+	return marks,failed
+}
+
+//**************************************************************
+
+func GetMemChapters(sst SST.PoSST) []string {
+
+	var chapters = make(map[string]int)
+
+	for index := range sst.NODE_DIRECTORY.N1directory {
+		chap := sst.NODE_DIRECTORY.N1directory[index].Chap
+		chapters[chap]++
+	}
+
+	for index := range sst.NODE_DIRECTORY.N2directory {
+		chap := sst.NODE_DIRECTORY.N2directory[index].Chap
+		chapters[chap]++
+	}
+
+	for index := range sst.NODE_DIRECTORY.N3directory {
+		chap := sst.NODE_DIRECTORY.N3directory[index].Chap
+		chapters[chap]++
+	}
+
+	for index := range sst.NODE_DIRECTORY.LT128directory {
+		chap := sst.NODE_DIRECTORY.LT128directory[index].Chap
+		chapters[chap]++
+	}
+
+	for index := range sst.NODE_DIRECTORY.LT1024 {
+		chap := sst.NODE_DIRECTORY.LT1024[index].Chap
+		chapters[chap]++
+	}
+
+	for index := range sst.NODE_DIRECTORY.GT1024 {
+		chap := sst.NODE_DIRECTORY.GT1024[index].Chap
+		chapters[chap]++
+	}
+
+	delete(chapters,"") // placeholders from SynchronizeNPtrs
+
+	return SST.Map2List(chapters)
+}
+
+//
+// upload.go
+//
@@ -3,6 +3,10 @@
 # SST
 #######################################################
 
+import getpass
+import os
+import sys
+import time
 import psycopg2
 
 #######################################################
@@ -259,6 +263,19 @@ def Edge(conn,n1,arrowname,n2,context,weight):
 #
     
 def AppendDBLinkToNode(conn,frptr,link,sttype):
+    cmd,icmd = AppendDBLinkCommands(frptr,link,sttype)
+
+    curs = conn.cursor()
+    curs.execute(cmd)
+    conn.commit()
+
+    curs = conn.cursor()
+    curs.execute(icmd)
+    conn.commit()
+
+#
+
+def AppendDBLinkCommands(frptr,link,sttype):
     arr = link[0]
     weight = link[1]
     ctxptr = link[2]
@@ -267,17 +284,202 @@ def AppendDBLinkToNode(conn,frptr,link,sttype):
     Ix = STTypeDBChannel(sttype)
 
     cmd = f"UPDATE NODE SET {Ix}=array_append({Ix},{txtlink}) WHERE NPtr='{frptr}'::NodePtr AND ({Ix} IS NULL OR NOT {txtlink} = ANY({Ix}))"
-    curs = conn.cursor()
-    curs.execute(cmd)
-    conn.commit()
 
     invarr = INVERSE_ARROWS[arr]
     invlink = f"({invarr},{weight},{ctxptr},{frptr}::NodePtr)::Link"
     invIx = STTypeDBChannel(-sttype)
     icmd = f"UPDATE NODE SET {invIx}=array_append( {invIx} , {invlink} ) WHERE NPtr='{dst}'::NodePtr AND ( {invIx} IS NULL OR NOT {invlink} = ANY({invIx}) )"
-    curs = conn.cursor()
-    curs.execute(icmd)
-    conn.commit()
+
+    return cmd,icmd
+
+#######################################################
+# Batches: collect many vertices and edges, then store
+# them all in one transaction, or none, e.g.
+#
+#   batch = SST.Batch(conn)
+#   v1 = batch.Vertex("first node","chapter")
+#   v2 = batch.Vertex("second node","chapter")
+#   batch.Edge(v1,"then",v2,context,1.0)
+#   ok = batch.Commit()
+#
+# Vertex() returns a BatchVertex, whose NPtr is set by
+# Commit(). Edge() also takes NPtr strings like "(1,2)"
+# for nodes that are already stored. As in Go, the batch
+# is one revision of the change log, and the provenance
+# names the source file, by default the running script.
+#######################################################
+
+BATCH_CHUNK = 500
+NODE_PROVENANCE = -1
+NO_NODE_PTR = "(0,-1)"
+
+class BatchVertex:
+
+    def __init__(self,name,chapter):
+        self.S = name
+        self.Chap = chapter
+        self.NPtr = None
+
+#
+
+class Batch:
+
+    def __init__(self,conn,source=None,line=0,author=None):
+        self.conn = conn
+        self.source = source if source is not None else os.path.basename(sys.argv[0])
+        self.line = line
+        self.author = author if author else ProvenanceAuthor()
+        self.vertices = {}
+        self.edges = []
+        self.arrows = {}
+        self.contexts = {}
+        self.closed = False
+
+    def Vertex(self,name,chapter):
+        if name not in self.vertices:
+            self.vertices[name] = BatchVertex(name,chapter)
+        else:
+            v = self.vertices[name]
+            v.Chap = MergeChapterLists(v.Chap,chapter)
+        return self.vertices[name]
+
+    def Edge(self,n1,arrowname,n2,context,weight):
+        if arrowname not in self.arrows:
+            self.arrows[arrowname] = GetDBArrowsWithArrowName(self.conn,arrowname)
+        arr,sttype = self.arrows[arrowname]
+
+        ctxstr = NormalizeContext(context)
+        if ctxstr not in self.contexts:
+            self.contexts[ctxstr] = TryContext(self.conn,context)
+
+        self.edges.append((n1,arr,self.contexts[ctxstr],n2,weight,sttype))
+
+    def Commit(self):
+        if self.closed:
+            print("This batch has already been committed or rolled back")
+            return False
+        self.closed = True
+
+        vertices = list(self.vertices.values())
+        curs = self.conn.cursor()
+
+        try:
+            # Keep out other writers until we are done, as UploadBatch() does,
+            # so the change log records what this batch did
+
+            curs.execute("LOCK TABLE Node IN SHARE ROW EXCLUSIVE MODE")
+            curs.execute("SELECT nextval('ChangeRevision')")
+            stamp = f"{curs.fetchone()[0]},{int(time.time())},'{SQLEscape(self.author)}'"
+
+            # IdempAppendNode() for every vertex, a chunk per statement,
+            # noting which names were already stored, and in which chapters
+
+            cmds = []
+            for i in range(0,len(vertices),BATCH_CHUNK):
+                chunk = vertices[i:i+BATCH_CHUNK]
+                names = ",".join(f"'{SQLEscape(v.S)}'" for v in chunk)
+                curs.execute(f"SELECT S,Chap FROM Node WHERE S IN ({names})")
+                stored = dict(curs.fetchall())
+                rows = []
+                for j,v in enumerate(chunk):
+                    rows.append(f"({j},{len(v.S)},{NChannel(v.S)},'{SQLEscape(v.S)}','{SQLEscape(v.Chap)}')")
+                values = ",".join(rows)
+                curs.execute(f"SELECT v.i,IdempAppendNode(v.l,v.c,v.s,v.ch) FROM (VALUES {values}) AS v(i,l,c,s,ch) ORDER BY v.i")
+                for pg_row in curs.fetchall():
+                    chunk[pg_row[0]].NPtr = pg_row[1]
+                for v in chunk:
+                    if v.S not in stored:
+                        cmds.append(FormatSQLChange(stamp,"node added",v.NPtr,chap=v.Chap,new=v.S))
+                        continue
+                    chap = MergeChapterLists(stored[v.S] or "",v.Chap)
+                    if chap != stored[v.S]:
+                        cmds.append(f"UPDATE Node SET Chap='{SQLEscape(chap)}' WHERE NPtr='{v.NPtr}'::NodePtr")
+                        cmds.append(FormatSQLChange(stamp,"node chapter",v.NPtr,old=stored[v.S] or "",new=chap))
+
+            for v in vertices:
+                cmds.append(FormatSQLProvenance(v.NPtr,NODE_PROVENANCE,NO_NODE_PTR,self.source,self.line,self.author))
+
+            for n1,arr,ctxptr,n2,weight,sttype in self.edges:
+                frptr = BatchNPtr(n1)
+                dst = BatchNPtr(n2)
+                link = (arr,weight,ctxptr,dst)
+                invlink = (INVERSE_ARROWS[arr],weight,ctxptr,frptr)
+                cmd,icmd = AppendDBLinkCommands(frptr,link,sttype)
+                cmds.append(LogLinkCommand(cmd,stamp,sttype,link))
+                cmds.append(LogLinkCommand(icmd,stamp,-sttype,invlink))
+                cmds.append(FormatSQLProvenance(frptr,arr,dst,self.source,self.line,self.author))
+
+            for i in range(0,len(cmds),BATCH_CHUNK):
+                curs.execute(";\n".join(cmds[i:i+BATCH_CHUNK]))
+
+            self.conn.commit()
+
+        except Exception as err:
+            self.conn.rollback()
+            for v in vertices:
+                v.NPtr = None
+            print("Unable to commit the batch, nothing was changed:",err)
+            return False
+
+        return True
+
+    def Rollback(self):
+        self.closed = True
+        self.vertices = {}
+        self.edges = []
+
+#
+
+def BatchNPtr(n):
+    if isinstance(n,BatchVertex):
+        return n.NPtr
+    return n
+
+#
+
+def ProvenanceAuthor():
+    author = os.environ.get("SST_AUTHOR")
+    if author:
+        return author
+    try:
+        return getpass.getuser()
+    except Exception:
+        return os.environ.get("USER","")
+
+#
+
+def MergeChapterLists(chaps,more):
+    # Node.Chap is a comma separated list, keep the order and no repeats
+    merged = chaps
+    for c in more.split(","):
+        if c == "" or c in merged.split(","):
+            continue
+        merged = c if merged == "" else merged + "," + c
+    return merged
+
+#
+
+def FormatSQLChange(stamp,op,nptr,chap="",old="",new=""):
+    # A node change for the ChangeLog, stamp is "rev,time,'author'"
+    return ("INSERT INTO ChangeLog (Rev,Time,Author,Op,NPtr,STtype,Arr,Wgt,Ctx,Dst,Chap,Old,New) "
+            f"VALUES ({stamp},'{op}','{nptr}'::NodePtr,0,0,0,0,'(0,0)'::NodePtr,'{SQLEscape(chap)}','{SQLEscape(old)}','{SQLEscape(new)}')")
+
+#
+
+def LogLinkCommand(cmd,stamp,sttype,link):
+    # Wrap an UPDATE from AppendDBLinkCommands() so that the link is
+    # logged only if it was really appended
+    arr,weight,ctxptr,dst = link
+    return (f"WITH u AS ({cmd} RETURNING NPtr) "
+            "INSERT INTO ChangeLog (Rev,Time,Author,Op,NPtr,STtype,Arr,Wgt,Ctx,Dst,Chap,Old,New) "
+            f"SELECT {stamp},'link added',u.NPtr,{sttype},{arr},{weight},{ctxptr},'{dst}'::NodePtr,'','','' FROM u")
+
+#
+
+def FormatSQLProvenance(nptr,arr,dst,source,line,author):
+    return ("INSERT INTO Provenance (NPtr,Arr,Dst,File,Line,Author,Time) "
+            f"VALUES ('{nptr}'::NodePtr,{arr},'{dst}'::NodePtr,'{SQLEscape(source)}',{line},'{SQLEscape(author)}',{int(time.time())}) "
+            "ON CONFLICT (NPtr,Arr,Dst,File) DO UPDATE SET Line=EXCLUDED.Line,Author=EXCLUDED.Author,Time=EXCLUDED.Time")
 
 #
         
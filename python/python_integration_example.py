@@ -30,6 +30,22 @@ print("RESULT v1:",fetch1)
 fetch2 = SST.GetDBNodeByNodePtr(sst,v2)
 print("RESULT v2:",fetch2)
 
+print("\n------- Or many at once, in one transaction  --------")
+
+batch = SST.Batch(sst)
+
+previous = batch.Vertex("batch node 0","examples chapter")
+
+for i in range(1,100):
+    node = batch.Vertex(f"batch node {i}","examples chapter")
+    batch.Edge(previous,"then",node,context,1.0)
+    previous = node
+
+batch.Edge(v2,"then",previous,context,1.0)
+
+if batch.Commit():
+    print("Last batch node is",previous.NPtr)
+
 # Access class and instance variables
 
 print("\n------- Now simple search for paths in examples --------")